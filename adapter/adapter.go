@@ -2,24 +2,46 @@ package adapter
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
-	"fmt"
+	"io"
 	"net"
 	"net/http"
-	"net/url"
+	"net/http/httptrace"
 	"time"
 
 	"github.com/Dreamacro/clash/common/queue"
 	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/hook"
+	"github.com/Dreamacro/clash/component/probeserver"
 	C "github.com/Dreamacro/clash/constant"
 
 	"go.uber.org/atomic"
 )
 
+const (
+	// probeIdleTimeout keeps the probe connection of a proxy alive between health check rounds
+	probeIdleTimeout = 10 * time.Minute
+	// probeDrainSize is the most of a probe body read to keep its connection, a longer one
+	// is closed with it
+	probeDrainSize = 4 << 10
+)
+
 type Proxy struct {
 	C.ProxyAdapter
-	history *queue.Queue
-	alive   *atomic.Bool
+	history    *queue.Queue
+	udpHistory *queue.Queue
+	alive      *atomic.Bool
+	probe      *http.Client
+	probeBytes *atomic.Int64
+	via        atomic.Pointer[viaProbe]
+	impairment atomic.Pointer[Impairment]
+	identity   string
+}
+
+// Identity return the key of the server of the proxy, see Identity
+func (p *Proxy) Identity() string {
+	return p.identity
 }
 
 // Alive implements C.Proxy
@@ -36,8 +58,19 @@ func (p *Proxy) Dial(metadata *C.Metadata) (C.Conn, error) {
 
 // DialContext implements C.ProxyAdapter
 func (p *Proxy) DialContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.Conn, error) {
+	ctx, metadata, err := p.upstream(ctx, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.impairDial(ctx); err != nil {
+		p.setAlive(err)
+		return nil, err
+	}
 	conn, err := p.ProxyAdapter.DialContext(ctx, metadata, opts...)
-	p.alive.Store(err == nil)
+	p.setAlive(err)
+	if err == nil && p.Impairment() != nil {
+		conn = &impairedConn{Conn: conn, proxy: p}
+	}
 	return conn, err
 }
 
@@ -50,11 +83,30 @@ func (p *Proxy) DialUDP(metadata *C.Metadata) (C.PacketConn, error) {
 
 // ListenPacketContext implements C.ProxyAdapter
 func (p *Proxy) ListenPacketContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.PacketConn, error) {
+	if err := p.impairDial(ctx); err != nil {
+		p.setAlive(err)
+		return nil, err
+	}
 	pc, err := p.ProxyAdapter.ListenPacketContext(ctx, metadata, opts...)
-	p.alive.Store(err == nil)
+	p.setAlive(err)
+	if err == nil && p.Impairment() != nil {
+		pc = &impairedPacketConn{PacketConn: pc, proxy: p}
+	}
 	return pc, err
 }
 
+// setAlive records the result of a dial or a health check, the hooks only
+// follow real nodes, groups and the builtin proxies are skipped
+func (p *Proxy) setAlive(err error) {
+	p.alive.Store(err == nil)
+
+	switch p.Type() {
+	case C.Direct, C.Reject, C.Relay, C.Selector, C.Fallback, C.URLTest, C.LoadBalance:
+	default:
+		hook.ProxyState(p.Name(), err == nil, err)
+	}
+}
+
 // DelayHistory implements C.Proxy
 func (p *Proxy) DelayHistory() []C.DelayHistory {
 	queue := p.history.Copy()
@@ -84,6 +136,15 @@ func (p *Proxy) LastDelay() (delay uint16) {
 	return history.Delay
 }
 
+// UDPFraming return how the adapter relays a datagram, false when it doesn't relay udp
+func (p *Proxy) UDPFraming() (C.UDPFraming, bool) {
+	framer, ok := p.ProxyAdapter.(C.UDPFramer)
+	if !ok || !p.SupportUDP() {
+		return C.UDPFraming{}, false
+	}
+	return framer.UDPFraming(), true
+}
+
 // MarshalJSON implements C.ProxyAdapter
 func (p *Proxy) MarshalJSON() ([]byte, error) {
 	inner, err := p.ProxyAdapter.MarshalJSON()
@@ -94,17 +155,32 @@ func (p *Proxy) MarshalJSON() ([]byte, error) {
 	mapping := map[string]any{}
 	json.Unmarshal(inner, &mapping)
 	mapping["history"] = p.DelayHistory()
+	mapping["udpHistory"] = p.UDPHistory()
 	mapping["alive"] = p.Alive()
 	mapping["name"] = p.Name()
 	mapping["udp"] = p.SupportUDP()
+	if framing, ok := p.UDPFraming(); ok {
+		mapping["udpFraming"] = framing
+	}
+	if impairment := p.Impairment(); impairment != nil {
+		mapping["impairment"] = impairment
+	}
 	return json.Marshal(mapping)
 }
 
 // URLTest get the delay for the specified URL
 // implements C.Proxy
 func (p *Proxy) URLTest(ctx context.Context, url string) (delay, meanDelay uint16, err error) {
+	return p.urlTest(ctx, url, p.probe)
+}
+
+func (p *Proxy) urlTest(ctx context.Context, url string, client *http.Client) (delay, meanDelay uint16, err error) {
+	if url, err = probeserver.URL(url, p.Name()); err != nil {
+		return
+	}
+
 	defer func() {
-		p.alive.Store(err == nil)
+		p.setAlive(err)
 		record := C.DelayHistory{Time: time.Now()}
 		if err == nil {
 			record.Delay = delay
@@ -116,86 +192,191 @@ func (p *Proxy) URLTest(ctx context.Context, url string) (delay, meanDelay uint1
 		}
 	}()
 
-	addr, err := urlToMetadata(url)
-	if err != nil {
+	start := time.Now()
+	if err = probeURL(ctx, client, url); err != nil {
 		return
 	}
+	delay = uint16(time.Since(start) / time.Millisecond)
 
-	start := time.Now()
-	instance, err := p.DialContext(ctx, &addr)
+	if err = probeURL(ctx, client, url); err != nil {
+		// ignore error because some server will hijack the connection and close immediately
+		return delay, 0, nil
+	}
+	meanDelay = uint16(time.Since(start) / time.Millisecond / 2)
+
+	return
+}
+
+// CloseIdleConnections drops the probe connection kept between health checks
+func (p *Proxy) CloseIdleConnections() {
+	p.probe.CloseIdleConnections()
+	if v := p.via.Load(); v != nil {
+		v.client.CloseIdleConnections()
+	}
+}
+
+// ProbeBytes return the total bytes spent on URLTest through this proxy
+func (p *Proxy) ProbeBytes() int64 {
+	return p.probeBytes.Load()
+}
+
+type probeBytesKey struct{}
+
+// WithProbeBytes returns a context counting into counter the bytes of the URLTest or UDPTest
+// it's passed to, apart from the tests running at the same time through the same proxy
+func WithProbeBytes(ctx context.Context, counter *atomic.Int64) context.Context {
+	return context.WithValue(ctx, probeBytesKey{}, counter)
+}
+
+func probeBytesFrom(ctx context.Context) *atomic.Int64 {
+	counter, _ := ctx.Value(probeBytesKey{}).(*atomic.Int64)
+	return counter
+}
+
+// addProbeBytes counts n bytes of a test with ctx
+func (p *Proxy) addProbeBytes(ctx context.Context, n int64) {
+	p.probeBytes.Add(n)
+	if counter := probeBytesFrom(ctx); counter != nil {
+		counter.Add(n)
+	}
+}
+
+// probeURL sends a HEAD request (or GET when the server refuses HEAD), the start of the body
+// is drained so that the connection is kept for the next probe
+func probeURL(ctx context.Context, client *http.Client, url string) error {
+	// a kept connection counts for the request it's given to
+	var conn *countedConn
+	if counter := probeBytesFrom(ctx); counter != nil {
+		ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if conn = probeConn(info.Conn); conn != nil {
+					conn.request.Store(counter)
+				}
+			},
+		})
+		defer func() {
+			if conn != nil {
+				conn.request.CompareAndSwap(counter, nil)
+			}
+		}()
+	}
+
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, probeDrainSize))
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (p *Proxy) dialProbe(ctx context.Context, network, address string) (net.Conn, error) {
+	addr, err := addrToMetadata(address)
 	if err != nil {
-		return
+		return nil, err
 	}
-	defer instance.Close()
 
-	req, err := http.NewRequest(http.MethodHead, url, nil)
+	conn, err := p.DialContext(ctx, &addr)
 	if err != nil {
-		return
+		return nil, err
 	}
-	req = req.WithContext(ctx)
 
-	transport := &http.Transport{
-		Dial: func(string, string) (net.Conn, error) {
-			return instance, nil
-		},
-		// from http.DefaultTransport
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
+	return newCountedConn(ctx, conn, p.probeBytes), nil
+}
+
+func NewProxy(adapter C.ProxyAdapter) *Proxy {
+	p := &Proxy{
+		ProxyAdapter: adapter,
+		history:      queue.New(10),
+		udpHistory:   queue.New(10),
+		alive:        atomic.NewBool(true),
+		probeBytes:   atomic.NewInt64(0),
 	}
 
-	client := http.Client{
-		Transport: transport,
+	p.probe = newProbeClient(p.dialProbe)
+	return p
+}
+
+// newProbeClient keeps the probe connection alive between health check rounds,
+// so a new handshake only happens when the previous one is broken
+func newProbeClient(dial func(ctx context.Context, network, address string) (net.Conn, error)) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:           dial,
+			MaxIdleConnsPerHost:   1,
+			IdleConnTimeout:       probeIdleTimeout,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 	}
-	defer client.CloseIdleConnections()
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return
+// countedConn counts the bytes transferred in both directions, into the counter of the
+// request using it too
+type countedConn struct {
+	net.Conn
+	counter *atomic.Int64
+	request atomic.Pointer[atomic.Int64]
+}
+
+// newCountedConn counts the handshakes of the dial into the counter of the request of ctx
+func newCountedConn(ctx context.Context, conn net.Conn, counter *atomic.Int64) *countedConn {
+	c := &countedConn{Conn: conn, counter: counter}
+	c.request.Store(probeBytesFrom(ctx))
+	return c
+}
+
+// probeConn returns the counted conn under a conn of the probe client
+func probeConn(conn net.Conn) *countedConn {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
 	}
-	resp.Body.Close()
-	delay = uint16(time.Since(start) / time.Millisecond)
+	c, _ := conn.(*countedConn)
+	return c
+}
 
-	resp, err = client.Do(req)
-	if err != nil {
-		// ignore error because some server will hijack the connection and close immediately
-		return delay, 0, nil
+func (c *countedConn) add(n int) {
+	c.counter.Add(int64(n))
+	if request := c.request.Load(); request != nil {
+		request.Add(int64(n))
 	}
-	resp.Body.Close()
-	meanDelay = uint16(time.Since(start) / time.Millisecond / 2)
+}
 
-	return
+func (c *countedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.add(n)
+	return n, err
 }
 
-func NewProxy(adapter C.ProxyAdapter) *Proxy {
-	return &Proxy{adapter, queue.New(10), atomic.NewBool(true)}
+func (c *countedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.add(n)
+	return n, err
 }
 
-func urlToMetadata(rawURL string) (addr C.Metadata, err error) {
-	u, err := url.Parse(rawURL)
+func addrToMetadata(address string) (addr C.Metadata, err error) {
+	host, port, err := net.SplitHostPort(address)
 	if err != nil {
 		return
 	}
 
-	port := u.Port()
-	if port == "" {
-		switch u.Scheme {
-		case "https":
-			port = "443"
-		case "http":
-			port = "80"
-		default:
-			err = fmt.Errorf("%s scheme not Support", rawURL)
-			return
-		}
-	}
-
 	addr = C.Metadata{
-		Host:    u.Hostname(),
+		Host:    host,
 		DstIP:   nil,
 		DstPort: port,
 	}
@@ -0,0 +1,77 @@
+package adapter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Dreamacro/clash/adapter/outbound"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestProxy_ProbeBytes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	proxy := NewProxy(outbound.NewDirect())
+	defer proxy.CloseIdleConnections()
+
+	// the first test leaves a kept connection to the second one
+	first := atomic.NewInt64(0)
+	_, _, err := proxy.URLTest(WithProbeBytes(context.Background(), first), server.URL)
+	require.NoError(t, err)
+	assert.Positive(t, first.Load())
+	assert.Equal(t, first.Load(), proxy.ProbeBytes())
+
+	before := proxy.ProbeBytes()
+	counters := []*atomic.Int64{atomic.NewInt64(0), atomic.NewInt64(0)}
+	wg := sync.WaitGroup{}
+	for _, counter := range counters {
+		wg.Add(1)
+		go func(counter *atomic.Int64) {
+			defer wg.Done()
+			_, _, err := proxy.URLTest(WithProbeBytes(context.Background(), counter), server.URL)
+			assert.NoError(t, err)
+		}(counter)
+	}
+	wg.Wait()
+
+	assert.Positive(t, counters[0].Load())
+	assert.Positive(t, counters[1].Load())
+	assert.Equal(t, proxy.ProbeBytes()-before, counters[0].Load()+counters[1].Load())
+}
+
+func TestProxy_ProbeKeepsConnection(t *testing.T) {
+	conns := atomic.NewInt64(0)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a probe url answering GET only, with a body
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Write([]byte("<html>ok</html>"))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Inc()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	proxy := NewProxy(outbound.NewDirect())
+	defer proxy.CloseIdleConnections()
+	for i := 0; i < 3; i++ {
+		_, _, err := proxy.URLTest(context.Background(), server.URL)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(1), conns.Load())
+}
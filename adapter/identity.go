@@ -0,0 +1,28 @@
+package adapter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// credentialKeys are the fields of a proxy mapping telling apart the accounts of a server
+var credentialKeys = []string{"username", "password", "uuid", "psk", "auth-str", "token", "private-key"}
+
+// Identity return the key of the server of a proxy mapping, made of its type, server, port and
+// a hash of its credentials, so that the same server under different names has the same key.
+// It is empty without a server
+func Identity(mapping map[string]any) string {
+	server, ok := mapping["server"]
+	if !ok {
+		return ""
+	}
+
+	h := sha256.New()
+	for _, key := range credentialKeys {
+		if v, ok := mapping[key]; ok {
+			fmt.Fprintf(h, "%s=%v\n", key, v)
+		}
+	}
+	return fmt.Sprintf("%v|%v|%v|%s", mapping["type"], server, mapping["port"], hex.EncodeToString(h.Sum(nil)[:8]))
+}
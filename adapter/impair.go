@@ -0,0 +1,159 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	maxImpairLatency  = time.Minute
+	maxImpairDuration = 24 * time.Hour
+)
+
+var (
+	// ImpairmentEnabled is experimental.impairment, the /debug/impair api is off without it
+	ImpairmentEnabled = atomic.NewBool(false)
+
+	// ErrImpaired is the error of a dial lost to an impairment
+	ErrImpaired = errors.New("impaired")
+)
+
+// Impairment is the latency and the loss injected into the traffic of a proxy until a time,
+// to test the failover of the groups. It's only kept in memory, a restart or a reload of
+// the proxies clears it
+type Impairment struct {
+	LatencyMs   int64     `json:"latencyMs"`
+	LossPercent int       `json:"lossPercent"`
+	Until       time.Time `json:"until"`
+}
+
+func (i *Impairment) latency() time.Duration {
+	return time.Duration(i.LatencyMs) * time.Millisecond
+}
+
+func (i *Impairment) lost() bool {
+	return i.LossPercent > 0 && rand.Intn(100) < i.LossPercent
+}
+
+// wait sleeps the latency unless ctx is done first
+func (i *Impairment) wait(ctx context.Context) error {
+	if i.LatencyMs <= 0 {
+		return nil
+	}
+	t := time.NewTimer(i.latency())
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Impair injects the latency and the loss into the traffic of the proxy for the duration, the
+// health checks included. A dial waits the latency and is lost at the loss rate, each write
+// of a conn waits the latency and each packet of a packet conn waits it or is lost both ways
+func (p *Proxy) Impair(latency time.Duration, loss int, duration time.Duration) (*Impairment, error) {
+	switch {
+	case latency < 0 || latency > maxImpairLatency:
+		return nil, fmt.Errorf("latency %s: expect 0-%s", latency, maxImpairLatency)
+	case loss < 0 || loss > 100:
+		return nil, fmt.Errorf("loss %d%%: expect 0-100", loss)
+	case duration <= 0 || duration > maxImpairDuration:
+		return nil, fmt.Errorf("duration %s: expect up to %s", duration, maxImpairDuration)
+	}
+
+	i := &Impairment{
+		LatencyMs:   latency.Milliseconds(),
+		LossPercent: loss,
+		Until:       time.Now().Add(duration),
+	}
+	p.impairment.Store(i)
+	// the next health check dials through the impairment
+	p.CloseIdleConnections()
+	return i, nil
+}
+
+// ClearImpairment removes the impairment, false when there was none
+func (p *Proxy) ClearImpairment() bool {
+	active := p.Impairment() != nil
+	p.impairment.Store(nil)
+	p.CloseIdleConnections()
+	return active
+}
+
+// Impairment return the impairment in effect, nil when none, over or the api is turned off
+func (p *Proxy) Impairment() *Impairment {
+	i := p.impairment.Load()
+	if i == nil || time.Now().After(i.Until) || !ImpairmentEnabled.Load() {
+		return nil
+	}
+	return i
+}
+
+// impairDial waits the latency of a dial and loses it at the loss rate
+func (p *Proxy) impairDial(ctx context.Context) error {
+	i := p.Impairment()
+	if i == nil {
+		return nil
+	}
+	if err := i.wait(ctx); err != nil {
+		return err
+	}
+	if i.lost() {
+		return fmt.Errorf("%w: %s dial lost", ErrImpaired, p.Name())
+	}
+	return nil
+}
+
+// impairedConn reads the impairment of its proxy on each write, so a DELETE or the end
+// of the duration lifts it from the conns already dialed
+type impairedConn struct {
+	C.Conn
+	proxy *Proxy
+}
+
+func (c *impairedConn) Write(b []byte) (int, error) {
+	if i := c.proxy.Impairment(); i != nil {
+		time.Sleep(i.latency())
+	}
+	return c.Conn.Write(b)
+}
+
+type impairedPacketConn struct {
+	C.PacketConn
+	proxy *Proxy
+}
+
+func (pc *impairedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if i := pc.proxy.Impairment(); i != nil {
+		if i.lost() {
+			return len(b), nil
+		}
+		time.Sleep(i.latency())
+	}
+	return pc.PacketConn.WriteTo(b, addr)
+}
+
+func (pc *impairedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := pc.PacketConn.ReadFrom(b)
+		i := pc.proxy.Impairment()
+		if err != nil || i == nil {
+			return n, addr, err
+		}
+		if i.lost() {
+			continue
+		}
+		time.Sleep(i.latency())
+		return n, addr, err
+	}
+}
@@ -0,0 +1,68 @@
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/outbound"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy_Impair(t *testing.T) {
+	defer ImpairmentEnabled.Store(false)
+	proxy := NewProxy(outbound.NewDirect())
+	target := udpEcho(t, func(int) bool { return false })
+
+	// nothing is impaired while the api is off
+	ImpairmentEnabled.Store(false)
+	_, err := proxy.Impair(0, 100, time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, proxy.Impairment())
+
+	ImpairmentEnabled.Store(true)
+	require.NotNil(t, proxy.Impairment())
+	addr, err := addrToMetadata(target)
+	require.NoError(t, err)
+	_, err = proxy.ListenPacketContext(context.Background(), &addr)
+	assert.True(t, errors.Is(err, ErrImpaired))
+	assert.False(t, proxy.Alive())
+
+	b, err := proxy.MarshalJSON()
+	require.NoError(t, err)
+	mapping := map[string]any{}
+	require.NoError(t, json.Unmarshal(b, &mapping))
+	assert.Contains(t, mapping, "impairment")
+
+	_, err = proxy.Impair(50*time.Millisecond, 0, time.Minute)
+	require.NoError(t, err)
+	start := time.Now()
+	pc, err := proxy.ListenPacketContext(context.Background(), &addr)
+	require.NoError(t, err)
+	defer pc.Close()
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.IsType(t, &impairedPacketConn{}, pc)
+
+	assert.True(t, proxy.ClearImpairment())
+	assert.False(t, proxy.ClearImpairment())
+	b, _ = proxy.MarshalJSON()
+	assert.NotContains(t, string(b), "impairment")
+
+	// over once the duration is
+	_, err = proxy.Impair(0, 100, time.Millisecond)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	assert.Nil(t, proxy.Impairment())
+
+	for _, args := range []struct {
+		latency, duration time.Duration
+		loss              int
+	}{{-time.Second, time.Minute, 0}, {0, time.Minute, 101}, {0, 0, 10}, {0, 48 * time.Hour, 10}} {
+		_, err := proxy.Impair(args.latency, args.loss, args.duration)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,99 @@
+package inbound
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/Dreamacro/clash/common/cache"
+	"github.com/Dreamacro/clash/log"
+
+	"go.uber.org/atomic"
+)
+
+// listener names of the capability config
+const (
+	ListenerHTTP  = "http"
+	ListenerSocks = "socks"
+	ListenerMixed = "mixed"
+)
+
+// Capability restricts what the clients of a listener may ask for, the zero value allows everything
+type Capability struct {
+	HTTPConnectOnly  bool
+	SocksUDPDisabled bool
+	AllowedPorts     []uint16
+
+	// ForwardHeaders is the mode of the forward headers of the http listeners, empty
+	// decides by the destination, see ForwardHeadersFor
+	ForwardHeaders   string
+	ForwardOverrides *ForwardOverrides
+
+	// RejectResponse is the answer of the http listeners to a request of REJECT, nil closes
+	// the connection as the other listeners do
+	RejectResponse *RejectResponse
+}
+
+// RejectResponse is the http response to a request matched to REJECT, {rule} of the body is
+// replaced with the matched rule when ShowRule
+type RejectResponse struct {
+	Status   int
+	Body     string
+	ShowRule bool
+}
+
+// the defaults of a RejectResponse
+const (
+	DefaultRejectStatus = 403
+	DefaultRejectBody   = "<html><body>blocked by clash{rule}</body></html>"
+)
+
+// AllowPort reports whether the destination port may be proxied
+func (c Capability) AllowPort(port string) bool {
+	if len(c.AllowedPorts) == 0 {
+		return true
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range c.AllowedPorts {
+		if uint16(p) == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	capabilities = atomic.NewPointer(&map[string]Capability{})
+
+	// a denied source is logged once a minute per reason
+	deniedLogs = cache.New(cache.WithAge(60), cache.WithSize(1024))
+)
+
+// SetCapabilities replaces the capabilities of the listeners, it applies to new requests
+// so that changing it doesn't rebind the listeners
+func SetCapabilities(caps map[string]Capability) {
+	capabilities.Store(&caps)
+}
+
+// CapabilityOf return the capability of the listener
+func CapabilityOf(listener string) Capability {
+	return (*capabilities.Load())[listener]
+}
+
+// LogDenied records a request refused by the capability of the listener
+func LogDenied(listener string, src net.Addr, reason string) {
+	host := src.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	key := listener + "|" + host + "|" + reason
+	if deniedLogs.Exist(key) {
+		return
+	}
+	deniedLogs.Set(key, struct{}{})
+	log.Warnln("[%s] %s denied from %s", listener, reason, host)
+}
@@ -0,0 +1,105 @@
+package inbound
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+
+	"github.com/Dreamacro/clash/component/trie"
+)
+
+// modes of the X-Forwarded-For, X-Real-IP and Via headers of the plain http requests
+// forwarded by the http listeners
+const (
+	ForwardHeadersStrip    = "strip"
+	ForwardHeadersAppend   = "append"
+	ForwardHeadersPreserve = "preserve"
+)
+
+type prefixMode struct {
+	prefix netip.Prefix
+	mode   string
+}
+
+// ForwardOverrides are the forward header modes by destination, a pattern is a domain
+// the way the hosts take it (*.example.com, +.example.com) or a cidr
+type ForwardOverrides struct {
+	domains *trie.DomainTrie
+	// the longest prefix first
+	prefixes []prefixMode
+}
+
+// NewForwardOverrides parses the pattern to mode map of forward-headers-overrides
+func NewForwardOverrides(patterns map[string]string) (*ForwardOverrides, error) {
+	o := &ForwardOverrides{domains: trie.New()}
+	for pattern, mode := range patterns {
+		if !validForwardMode(mode) {
+			return nil, fmt.Errorf("%s: invalid mode %s, expect strip, append or preserve", pattern, mode)
+		}
+
+		if prefix, err := netip.ParsePrefix(pattern); err == nil {
+			o.prefixes = append(o.prefixes, prefixMode{prefix: prefix.Masked(), mode: mode})
+			continue
+		}
+		if addr, err := netip.ParseAddr(pattern); err == nil {
+			o.prefixes = append(o.prefixes, prefixMode{prefix: netip.PrefixFrom(addr, addr.BitLen()), mode: mode})
+			continue
+		}
+		if err := o.domains.Insert(pattern, mode); err != nil {
+			return nil, fmt.Errorf("%s: %w", pattern, err)
+		}
+	}
+	sort.Slice(o.prefixes, func(i, j int) bool {
+		return o.prefixes[i].prefix.Bits() > o.prefixes[j].prefix.Bits()
+	})
+	return o, nil
+}
+
+func (o *ForwardOverrides) lookup(host string) (string, bool) {
+	if o == nil {
+		return "", false
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addr = addr.Unmap()
+		for _, p := range o.prefixes {
+			if p.prefix.Contains(addr) {
+				return p.mode, true
+			}
+		}
+		return "", false
+	}
+
+	if node := o.domains.Search(host); node != nil {
+		return node.Data.(string), true
+	}
+	return "", false
+}
+
+func validForwardMode(mode string) bool {
+	switch mode {
+	case ForwardHeadersStrip, ForwardHeadersAppend, ForwardHeadersPreserve:
+		return true
+	}
+	return false
+}
+
+// ForwardHeadersFor return the forward header mode of a request to host. Without a mode
+// set the headers are appended for the private, loopback and link local addresses and
+// stripped for the rest, the domains included.
+func (c Capability) ForwardHeadersFor(host string) string {
+	if mode, ok := c.ForwardOverrides.lookup(host); ok {
+		return mode
+	}
+	if c.ForwardHeaders != "" {
+		return c.ForwardHeaders
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addr = addr.Unmap()
+		if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+			return ForwardHeadersAppend
+		}
+	}
+	return ForwardHeadersStrip
+}
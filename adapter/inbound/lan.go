@@ -0,0 +1,37 @@
+package inbound
+
+import (
+	"net"
+
+	"go.uber.org/atomic"
+)
+
+var allowLan = atomic.NewBool(false)
+
+// SetAllowLan controls whether the proxy listeners serve clients other than the local host,
+// it is checked for every connection so that changing it doesn't rebind the listeners
+func SetAllowLan(al bool) {
+	allowLan.Store(al)
+}
+
+func AllowLan() bool {
+	return allowLan.Load()
+}
+
+// IsRemoteAllowed reports whether a client of a proxy listener should be served
+func IsRemoteAllowed(addr net.Addr) bool {
+	if allowLan.Load() {
+		return true
+	}
+
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		return true
+	}
+	return ip.IsLoopback()
+}
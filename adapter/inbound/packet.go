@@ -19,6 +19,14 @@ func (s *PacketAdapter) Metadata() *C.Metadata {
 	return s.metadata
 }
 
+// Batch implements C.UDPPacketBatch, it is empty when the packet carries a single payload
+func (s *PacketAdapter) Batch() [][]byte {
+	if batch, ok := s.UDPPacket.(C.UDPPacketBatch); ok {
+		return batch.Batch()
+	}
+	return nil
+}
+
 // NewPacket is PacketAdapter generator
 func NewPacket(target socks5.Addr, originTarget net.Addr, packet C.UDPPacket, source C.Type) *PacketAdapter {
 	metadata := parseSocksAddr(target)
@@ -6,17 +6,19 @@ import (
 	"errors"
 	"net"
 
+	"github.com/Dreamacro/clash/component/clientcert"
 	"github.com/Dreamacro/clash/component/dialer"
 	C "github.com/Dreamacro/clash/constant"
 )
 
 type Base struct {
-	name  string
-	addr  string
-	iface string
-	tp    C.AdapterType
-	udp   bool
-	rmark int
+	name    string
+	addr    string
+	iface   string
+	tp      C.AdapterType
+	udp     bool
+	rmark   int
+	resolve string
 }
 
 // Name implements C.ProxyAdapter
@@ -56,6 +58,11 @@ func (b *Base) Addr() string {
 	return b.addr
 }
 
+// DNSResolve implements C.DNSResolver
+func (b *Base) DNSResolve() string {
+	return b.resolve
+}
+
 // Unwrap implements C.ProxyAdapter
 func (b *Base) Unwrap(metadata *C.Metadata) C.Proxy {
 	return nil
@@ -77,6 +84,19 @@ func (b *Base) DialOptions(opts ...dialer.Option) []dialer.Option {
 type BasicOption struct {
 	Interface   string `proxy:"interface-name,omitempty" group:"interface-name,omitempty"`
 	RoutingMark int    `proxy:"routing-mark,omitempty" group:"routing-mark,omitempty"`
+	DNSResolve  string `proxy:"dns-resolve,omitempty"`
+}
+
+// ClientCertOption is the client certificate of the tls outbounds behind mutual tls,
+// the cert and the key are a file path or inline PEM
+type ClientCertOption struct {
+	ClientCert          string `proxy:"client-cert,omitempty"`
+	ClientKey           string `proxy:"client-key,omitempty"`
+	ClientKeyPassphrase string `proxy:"client-key-passphrase,omitempty"`
+}
+
+func (o ClientCertOption) load() (*clientcert.Certificate, error) {
+	return clientcert.New(clientcert.Option{Cert: o.ClientCert, Key: o.ClientKey, Passphrase: o.ClientKeyPassphrase})
 }
 
 type BaseOption struct {
@@ -102,6 +122,7 @@ func NewBase(opt BaseOption) *Base {
 type conn struct {
 	net.Conn
 	chain C.Chain
+	leaf  C.AdapterType
 }
 
 // Chains implements C.Connection
@@ -114,8 +135,13 @@ func (c *conn) AppendToChains(a C.ProxyAdapter) {
 	c.chain = append(c.chain, a.Name())
 }
 
+// LeafType return the type of the proxy dialing the connection, the first of the chain
+func (c *conn) LeafType() C.AdapterType {
+	return c.leaf
+}
+
 func NewConn(c net.Conn, a C.ProxyAdapter) C.Conn {
-	return &conn{c, []string{a.Name()}}
+	return &conn{c, []string{a.Name()}, a.Type()}
 }
 
 type packetConn struct {
@@ -6,6 +6,10 @@ import (
 
 	"github.com/Dreamacro/clash/component/dialer"
 	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 type Direct struct {
@@ -22,15 +26,32 @@ func (d *Direct) DialContext(ctx context.Context, metadata *C.Metadata, opts ...
 	return NewConn(c, d), nil
 }
 
+// UDPFraming implements C.UDPFramer
+func (d *Direct) UDPFraming() C.UDPFraming {
+	return C.UDPFraming{}
+}
+
 // ListenPacketContext implements C.ProxyAdapter
 func (d *Direct) ListenPacketContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.PacketConn, error) {
 	pc, err := dialer.ListenPacket(ctx, "udp", "", d.Base.DialOptions(opts...)...)
 	if err != nil {
 		return nil, err
 	}
+	if metadata.TTL > 0 {
+		setTTL(pc, int(metadata.TTL))
+	}
 	return newPacketConn(&directPacketConn{pc}, d), nil
 }
 
+// setTTL sets the ttl of both families, the socket is dual stack or one of them
+func setTTL(pc net.PacketConn, ttl int) {
+	err4 := ipv4.NewPacketConn(pc).SetTTL(ttl)
+	err6 := ipv6.NewPacketConn(pc).SetHopLimit(ttl)
+	if err4 != nil && err6 != nil {
+		log.Debugln("[DIRECT] set ttl %d: %v", ttl, err4)
+	}
+}
+
 type directPacketConn struct {
 	net.PacketConn
 }
@@ -44,3 +65,27 @@ func NewDirect() *Direct {
 		},
 	}
 }
+
+// ListenContext implements C.Binder
+func (d *Direct) ListenContext(ctx context.Context, metadata *C.Metadata, address string) (C.BindListener, error) {
+	ln, err := (&net.ListenConfig{}).Listen(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &directListener{ln, d}, nil
+}
+
+type directListener struct {
+	net.Listener
+	direct *Direct
+}
+
+// Accept implements C.BindListener
+func (l *directListener) Accept() (C.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tcpKeepAlive(c)
+	return NewConn(c, l.direct), nil
+}
@@ -0,0 +1,48 @@
+package outbound
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/ipv4"
+)
+
+// receivedTTL sends a packet from a udp conn of DIRECT and return its ttl on arrival
+func receivedTTL(t *testing.T, ttl uint8) int {
+	ln, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer ln.Close()
+	receiver := ipv4.NewPacketConn(ln)
+	require.NoError(t, receiver.SetControlMessage(ipv4.FlagTTL, true))
+
+	addr := ln.LocalAddr().(*net.UDPAddr)
+	pc, err := NewDirect().ListenPacketContext(context.Background(), &C.Metadata{
+		NetWork: C.UDP,
+		DstIP:   addr.IP,
+		DstPort: "0",
+		TTL:     ttl,
+	})
+	require.NoError(t, err)
+	defer pc.Close()
+	_, err = pc.WriteTo([]byte("probe"), addr)
+	require.NoError(t, err)
+
+	ln.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	_, cm, _, err := receiver.ReadFrom(buf)
+	require.NoError(t, err)
+	require.NotNil(t, cm)
+	return cm.TTL
+}
+
+func TestDirect_TTL(t *testing.T) {
+	assert.Equal(t, 3, receivedTTL(t, 3))
+	// the default of the system
+	assert.NotEqual(t, 3, receivedTTL(t, 0))
+}
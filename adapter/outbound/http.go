@@ -27,6 +27,7 @@ type Http struct {
 
 type HttpOption struct {
 	BasicOption
+	ClientCertOption
 	Name           string            `proxy:"name"`
 	Server         string            `proxy:"server"`
 	Port           int               `proxy:"port"`
@@ -123,17 +124,21 @@ func (h *Http) shakeHand(metadata *C.Metadata, rw io.ReadWriter) error {
 	return fmt.Errorf("can not connect remote err code: %d", resp.StatusCode)
 }
 
-func NewHttp(option HttpOption) *Http {
+func NewHttp(option HttpOption) (*Http, error) {
 	var tlsConfig *tls.Config
 	if option.TLS {
 		sni := option.Server
 		if option.SNI != "" {
 			sni = option.SNI
 		}
-		tlsConfig = &tls.Config{
+		clientCert, err := option.load()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = clientCert.Apply(&tls.Config{
 			InsecureSkipVerify: option.SkipCertVerify,
 			ServerName:         sni,
-		}
+		})
 	}
 
 	headers := http.Header{}
@@ -143,15 +148,16 @@ func NewHttp(option HttpOption) *Http {
 
 	return &Http{
 		Base: &Base{
-			name:  option.Name,
-			addr:  net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
-			tp:    C.Http,
-			iface: option.Interface,
-			rmark: option.RoutingMark,
+			name:    option.Name,
+			addr:    net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
+			tp:      C.Http,
+			iface:   option.Interface,
+			rmark:   option.RoutingMark,
+			resolve: option.DNSResolve,
 		},
 		user:      option.UserName,
 		pass:      option.Password,
 		tlsConfig: tlsConfig,
 		Headers:   headers,
-	}
+	}, nil
 }
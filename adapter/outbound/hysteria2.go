@@ -0,0 +1,122 @@
+package outbound
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport/hysteria2"
+	"github.com/Dreamacro/clash/transport/quicconn"
+)
+
+type Hysteria2 struct {
+	*Base
+	pool *quicPool[*hysteria2.Client]
+}
+
+type Hysteria2Option struct {
+	BasicOption
+	QUICPortsOption
+	Name           string   `proxy:"name"`
+	Server         string   `proxy:"server"`
+	Port           int      `proxy:"port,omitempty"`
+	Password       string   `proxy:"password"`
+	SNI            string   `proxy:"sni,omitempty"`
+	SkipCertVerify bool     `proxy:"skip-cert-verify,omitempty"`
+	ALPN           []string `proxy:"alpn,omitempty"`
+	Up             string   `proxy:"up,omitempty"`
+	Down           string   `proxy:"down,omitempty"`
+	UDP            bool     `proxy:"udp,omitempty"`
+}
+
+// DialContext implements C.ProxyAdapter
+func (h *Hysteria2) DialContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.Conn, error) {
+	client, shared, err := h.pool.get(ctx, h.Base.DialOptions(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", h.addr, err)
+	}
+	c, err := client.DialTCP(ctx, metadata.RemoteAddress())
+	if err != nil {
+		if !shared {
+			client.Close()
+		}
+		return nil, err
+	}
+	return NewConn(wrapQUICConn(c, client, shared), h), nil
+}
+
+// ListenPacketContext implements C.ProxyAdapter
+func (h *Hysteria2) ListenPacketContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.PacketConn, error) {
+	client, shared, err := h.pool.get(ctx, h.Base.DialOptions(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", h.addr, err)
+	}
+	pc, err := client.ListenUDP()
+	if err != nil {
+		if !shared {
+			client.Close()
+		}
+		return nil, err
+	}
+	return newPacketConn(wrapQUICPacketConn(pc, client, shared), h), nil
+}
+
+// MarshalJSON implements C.ProxyAdapter
+func (h *Hysteria2) MarshalJSON() ([]byte, error) {
+	mapping := map[string]any{
+		"type": h.Type().String(),
+	}
+	h.pool.stats(mapping)
+	return json.Marshal(mapping)
+}
+
+func NewHysteria2(option Hysteria2Option) (*Hysteria2, error) {
+	addr := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
+	up, err := quicconn.ParseBandwidth(option.Up)
+	if err != nil {
+		return nil, fmt.Errorf("hysteria2 %s up: %w", addr, err)
+	}
+	down, err := quicconn.ParseBandwidth(option.Down)
+	if err != nil {
+		return nil, fmt.Errorf("hysteria2 %s down: %w", addr, err)
+	}
+	if option.Port == 0 && option.Ports == "" {
+		return nil, fmt.Errorf("hysteria2 %s: missing port", addr)
+	}
+
+	serverName := option.Server
+	if option.SNI != "" {
+		serverName = option.SNI
+	}
+	clientOption := hysteria2.Option{
+		Password:       option.Password,
+		ServerName:     serverName,
+		SkipCertVerify: option.SkipCertVerify,
+		ALPN:           option.ALPN,
+		Up:             up,
+		Down:           down,
+	}
+	pool, err := newQUICPool(option.Name, addr, option.QUICPortsOption, func(ctx context.Context, pc net.PacketConn, addr net.Addr) (*hysteria2.Client, error) {
+		return hysteria2.NewClient(ctx, pc, addr, clientOption)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("hysteria2 %s ports: %w", addr, err)
+	}
+
+	return &Hysteria2{
+		Base: &Base{
+			name:    option.Name,
+			addr:    addr,
+			tp:      C.Hysteria2,
+			udp:     option.UDP,
+			iface:   option.Interface,
+			rmark:   option.RoutingMark,
+			resolve: option.DNSResolve,
+		},
+		pool: pool,
+	}, nil
+}
@@ -0,0 +1,240 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/netmon"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+	"github.com/Dreamacro/clash/transport/quicconn"
+
+	"go.uber.org/atomic"
+)
+
+// defaultHopInterval is how often a quic outbound with ports takes another one
+const defaultHopInterval = 30 * time.Second
+
+// migrateTimeout is how long a quic connection moved to another network waits for the
+// server before it's dialed again
+var migrateTimeout = 5 * time.Second
+
+// QUICPortsOption is the port hopping of the quic outbounds, the server listens on every
+// one of Ports and the client takes another one each HopInterval seconds
+type QUICPortsOption struct {
+	Ports       string `proxy:"ports,omitempty"`
+	HopInterval int    `proxy:"hop-interval,omitempty"`
+}
+
+type quicClient interface {
+	Done() <-chan struct{}
+	Close() error
+	// Ping sends a packet the server acknowledges
+	Ping() error
+}
+
+// quicPool holds the quic connection the connections of an outbound share, it's dialed
+// again once it's closed. It follows the network, when the route changes the connection
+// moves to a new socket and it's dialed again only when the server doesn't answer there.
+type quicPool[T quicClient] struct {
+	name        string
+	addr        string
+	ports       quicconn.Ports
+	hopping     bool
+	hopInterval time.Duration
+	dial        func(ctx context.Context, pc net.PacketConn, addr net.Addr) (T, error)
+
+	mux    sync.Mutex
+	client T
+	alive  bool
+
+	statMux  sync.Mutex
+	hop      *quicconn.HopConn
+	pastHops uint32
+
+	migrations atomic.Uint32
+	reconnects atomic.Uint32
+}
+
+func newQUICPool[T quicClient](name, addr string, option QUICPortsOption, dial func(context.Context, net.PacketConn, net.Addr) (T, error)) (*quicPool[T], error) {
+	p := &quicPool[T]{name: name, addr: addr, dial: dial}
+	ports := option.Ports
+	if ports == "" {
+		_, ports, _ = net.SplitHostPort(addr)
+	} else {
+		p.hopping = true
+		p.hopInterval = defaultHopInterval
+		if option.HopInterval > 0 {
+			p.hopInterval = time.Duration(option.HopInterval) * time.Second
+		}
+	}
+	var err error
+	if p.ports, err = quicconn.ParsePorts(ports); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// get return the shared client, the options of a group dial a client of its own that the
+// caller closes
+func (p *quicPool[T]) get(ctx context.Context, base []dialer.Option, opts []dialer.Option) (client T, shared bool, err error) {
+	if len(opts) != 0 {
+		client, _, err = p.connect(ctx, append(base, opts...))
+		return client, false, err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.alive {
+		select {
+		case <-p.client.Done():
+		default:
+			return p.client, true, nil
+		}
+	}
+	client, hop, err := p.connect(ctx, base)
+	if err != nil {
+		return client, true, err
+	}
+	p.client, p.alive = client, true
+
+	p.statMux.Lock()
+	if p.hop != nil {
+		p.pastHops += p.hop.Hops()
+	}
+	p.hop = hop
+	p.statMux.Unlock()
+	go p.follow(client, hop, base)
+	return client, true, nil
+}
+
+func (p *quicPool[T]) connect(ctx context.Context, opts []dialer.Option) (client T, hop *quicconn.HopConn, err error) {
+	addr, err := resolveUDPAddr("udp", p.addr)
+	if err != nil {
+		return client, nil, err
+	}
+	pc, err := dialer.ListenPacket(ctx, "udp", "", opts...)
+	if err != nil {
+		return client, nil, err
+	}
+
+	hop = quicconn.NewHopConn(pc, addr.IP, p.ports, p.hopInterval)
+	client, err = p.dial(ctx, hop, hop.Addr())
+	return client, hop, err
+}
+
+// follow moves the shared client to the network after each change of the route
+func (p *quicPool[T]) follow(client T, hop *quicconn.HopConn, opts []dialer.Option) {
+	sub := netmon.Subscribe()
+	defer sub.Close()
+	for {
+		select {
+		case <-sub.C:
+			p.migrate(client, hop, opts)
+		case <-client.Done():
+			return
+		}
+	}
+}
+
+// migrate moves the client to a new socket, it's closed and dialed again when the server
+// doesn't answer there within migrateTimeout
+func (p *quicPool[T]) migrate(client T, hop *quicconn.HopConn, opts []dialer.Option) {
+	pc, err := dialer.ListenPacket(context.Background(), "udp", "", opts...)
+	if err == nil {
+		answered := hop.Rebind(pc)
+		timeout := time.NewTimer(migrateTimeout)
+		defer timeout.Stop()
+		ping := time.NewTicker(time.Second)
+		defer ping.Stop()
+		client.Ping()
+
+	wait:
+		for {
+			select {
+			case <-answered:
+				p.migrations.Inc()
+				log.Infoln("[QUIC] %s moved to %s", p.name, pc.LocalAddr().String())
+				return
+			case <-client.Done():
+				return
+			case <-ping.C:
+				client.Ping()
+			case <-timeout.C:
+				break wait
+			}
+		}
+		err = errors.New("no answer from the server")
+	}
+
+	p.reconnects.Inc()
+	log.Warnln("[QUIC] %s failed to move to the new network, dialing again: %s", p.name, err.Error())
+	client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), C.DefaultTCPTimeout)
+	defer cancel()
+	p.get(ctx, opts, nil)
+}
+
+// stats adds the migrations and the reconnects of the shared client to mapping, and with
+// ports the port it sends to and the hops it and the clients before it took
+func (p *quicPool[T]) stats(mapping map[string]any) {
+	mapping["quic"] = map[string]any{
+		"migrations": p.migrations.Load(),
+		"reconnects": p.reconnects.Load(),
+	}
+	if !p.hopping {
+		return
+	}
+	p.statMux.Lock()
+	defer p.statMux.Unlock()
+	port, hops := 0, p.pastHops
+	if p.hop != nil {
+		port = p.hop.Port()
+		hops += p.hop.Hops()
+	}
+	mapping["hop"] = map[string]any{
+		"port": port,
+		"hops": hops,
+	}
+}
+
+// quicConn closes the client dialed for it with the options of a group
+type quicConn struct {
+	net.Conn
+	client quicClient
+}
+
+func (c *quicConn) Close() error {
+	err := c.Conn.Close()
+	c.client.Close()
+	return err
+}
+
+type quicPacketConn struct {
+	net.PacketConn
+	client quicClient
+}
+
+func (pc *quicPacketConn) Close() error {
+	err := pc.PacketConn.Close()
+	pc.client.Close()
+	return err
+}
+
+func wrapQUICConn(c net.Conn, client quicClient, shared bool) net.Conn {
+	if shared {
+		return c
+	}
+	return &quicConn{Conn: c, client: client}
+}
+
+func wrapQUICPacketConn(pc net.PacketConn, client quicClient, shared bool) net.PacketConn {
+	if shared {
+		return pc
+	}
+	return &quicPacketConn{PacketConn: pc, client: client}
+}
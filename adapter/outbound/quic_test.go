@@ -0,0 +1,252 @@
+package outbound
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/listener/hysteria2"
+	"github.com/Dreamacro/clash/listener/tuic"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const tuicUser = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+func newKeyPair(t *testing.T) (certPEM, keyPEM string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+// udpRelay forwards the packets of a client to target and back, the server is reached on
+// the port of the relay too
+func udpRelay(t *testing.T, target string) int {
+	targetAddr, err := net.ResolveUDPAddr("udp", target)
+	require.NoError(t, err)
+	relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { relay.Close() })
+
+	go func() {
+		var client net.Addr
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := relay.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if from.String() != targetAddr.String() {
+				client = from
+				relay.WriteTo(buf[:n], targetAddr)
+			} else if client != nil {
+				relay.WriteTo(buf[:n], client)
+			}
+		}
+	}()
+	return relay.LocalAddr().(*net.UDPAddr).Port
+}
+
+// pingThrough dials example.com:443 over proxy and checks the stream reaches tcpIn
+func pingThrough(t *testing.T, proxy C.ProxyAdapter, tcpIn chan C.ConnContext) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := proxy.DialContext(ctx, &C.Metadata{Host: "example.com", DstPort: "443"})
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	var connCtx C.ConnContext
+	select {
+	case connCtx = <-tcpIn:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection from the listener")
+	}
+	defer connCtx.Conn().Close()
+	assert.Equal(t, "example.com", connCtx.Metadata().Host)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(connCtx.Conn(), buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestHysteria2_PortHopping(t *testing.T) {
+	certPEM, keyPEM := newKeyPair(t)
+	tcpIn := make(chan C.ConnContext, 1)
+	l, err := hysteria2.New(hysteria2.Option{
+		Name:        "test",
+		Listen:      "127.0.0.1:0",
+		Certificate: certPEM,
+		PrivateKey:  keyPEM,
+		Users:       map[string]string{"alice": "secret"},
+	}, tcpIn, make(chan *inbound.PacketAdapter, 1))
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, port, _ := net.SplitHostPort(l.Address())
+	proxy, err := NewHysteria2(Hysteria2Option{
+		Name:            "hysteria2",
+		Server:          "127.0.0.1",
+		QUICPortsOption: QUICPortsOption{Ports: fmt.Sprintf("%s,%d", port, udpRelay(t, l.Address()))},
+		Password:        "secret",
+		SNI:             "localhost",
+		SkipCertVerify:  true,
+	})
+	require.NoError(t, err)
+
+	pingThrough(t, proxy, tcpIn)
+	client, hop := proxy.pool.client, proxy.pool.hop
+	defer client.Close()
+	last := hop.Port()
+
+	// the connection carries on over the other port
+	hop.Hop()
+	pingThrough(t, proxy, tcpIn)
+	assert.Same(t, client, proxy.pool.client)
+
+	b, err := json.Marshal(proxy)
+	require.NoError(t, err)
+	stats := struct {
+		Hop struct {
+			Port int    `json:"port"`
+			Hops uint32 `json:"hops"`
+		} `json:"hop"`
+	}{}
+	require.NoError(t, json.Unmarshal(b, &stats))
+	assert.NotEqual(t, last, stats.Hop.Port)
+	assert.Equal(t, hop.Port(), stats.Hop.Port)
+	assert.Equal(t, uint32(1), stats.Hop.Hops)
+}
+
+func TestTuic(t *testing.T) {
+	certPEM, keyPEM := newKeyPair(t)
+	tcpIn := make(chan C.ConnContext, 1)
+	l, err := tuic.New(tuic.Option{
+		Name:        "test",
+		Listen:      "127.0.0.1:0",
+		Certificate: certPEM,
+		PrivateKey:  keyPEM,
+		Users:       map[string]string{tuicUser: "secret"},
+	}, tcpIn, make(chan *inbound.PacketAdapter, 1))
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, port, _ := net.SplitHostPort(l.Address())
+	proxy, err := NewTuic(TuicOption{
+		Name:            "tuic",
+		Server:          "127.0.0.1",
+		QUICPortsOption: QUICPortsOption{Ports: port},
+		UUID:            tuicUser,
+		Password:        "secret",
+		SNI:             "localhost",
+		SkipCertVerify:  true,
+	})
+	require.NoError(t, err)
+
+	pingThrough(t, proxy, tcpIn)
+	defer proxy.pool.client.Close()
+	client := proxy.pool.client
+	pingThrough(t, proxy, tcpIn)
+	assert.Same(t, client, proxy.pool.client)
+
+	_, err = NewTuic(TuicOption{Server: "127.0.0.1", Port: 443, UUID: tuicUser, UDPRelayMode: "tcp"})
+	assert.ErrorContains(t, err, "udp-relay-mode")
+	_, err = NewTuic(TuicOption{Server: "127.0.0.1", UUID: tuicUser})
+	assert.ErrorContains(t, err, "missing port")
+}
+
+func TestHysteria2_Migrate(t *testing.T) {
+	migrateTimeout = time.Second
+	certPEM, keyPEM := newKeyPair(t)
+	tcpIn := make(chan C.ConnContext, 1)
+	l, err := hysteria2.New(hysteria2.Option{
+		Name:        "test",
+		Listen:      "127.0.0.1:0",
+		Certificate: certPEM,
+		PrivateKey:  keyPEM,
+		Users:       map[string]string{"alice": "secret"},
+	}, tcpIn, make(chan *inbound.PacketAdapter, 1))
+	require.NoError(t, err)
+	defer l.Close()
+
+	newProxy := func(port int) *Hysteria2 {
+		proxy, err := NewHysteria2(Hysteria2Option{
+			Name:           "hysteria2",
+			Server:         "127.0.0.1",
+			Port:           port,
+			Password:       "secret",
+			SNI:            "localhost",
+			SkipCertVerify: true,
+		})
+		require.NoError(t, err)
+		return proxy
+	}
+	stats := func(proxy *Hysteria2) (migrations, reconnects uint32) {
+		b, err := json.Marshal(proxy)
+		require.NoError(t, err)
+		mapping := struct {
+			QUIC struct {
+				Migrations uint32 `json:"migrations"`
+				Reconnects uint32 `json:"reconnects"`
+			} `json:"quic"`
+		}{}
+		require.NoError(t, json.Unmarshal(b, &mapping))
+		return mapping.QUIC.Migrations, mapping.QUIC.Reconnects
+	}
+
+	// behind the relay the server keeps seeing the same address, the connection moves
+	proxy := newProxy(udpRelay(t, l.Address()))
+	pingThrough(t, proxy, tcpIn)
+	client := proxy.pool.client
+	defer client.Close()
+	local := proxy.pool.hop.LocalAddr().String()
+	proxy.pool.migrate(client, proxy.pool.hop, nil)
+	assert.NotEqual(t, local, proxy.pool.hop.LocalAddr().String())
+	pingThrough(t, proxy, tcpIn)
+	assert.Same(t, client, proxy.pool.client)
+	migrations, reconnects := stats(proxy)
+	assert.Equal(t, uint32(1), migrations)
+	assert.Zero(t, reconnects)
+
+	// quic-go servers don't follow a client to another address, it's dialed again
+	_, port, _ := net.SplitHostPort(l.Address())
+	portNum, _ := strconv.Atoi(port)
+	proxy = newProxy(portNum)
+	pingThrough(t, proxy, tcpIn)
+	client = proxy.pool.client
+	proxy.pool.migrate(client, proxy.pool.hop, nil)
+	defer proxy.pool.client.Close()
+	assert.NotSame(t, client, proxy.pool.client)
+	pingThrough(t, proxy, tcpIn)
+	migrations, reconnects = stats(proxy)
+	assert.Zero(t, migrations)
+	assert.Equal(t, uint32(1), reconnects)
+}
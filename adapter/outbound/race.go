@@ -0,0 +1,141 @@
+package outbound
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	raceSamples = 32
+	// until raceMinSamples handshakes are recorded the threshold is raceDefaultThreshold
+	raceMinSamples       = 5
+	raceDefaultThreshold = time.Second
+	raceMinThreshold     = 50 * time.Millisecond
+	// a handshake slower than this share of the recent ones starts another attempt
+	racePercentile = 0.9
+)
+
+// racer starts up to n concurrent dials, a new attempt is started when the current
+// ones haven't completed within the percentile of the recent durations of the proxy
+type racer struct {
+	mux     sync.Mutex
+	samples []time.Duration
+	next    int
+
+	raced atomic.Int64
+	won   atomic.Int64
+}
+
+func (r *racer) record(d time.Duration) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if len(r.samples) < raceSamples {
+		r.samples = append(r.samples, d)
+		return
+	}
+	r.samples[r.next] = d
+	r.next = (r.next + 1) % raceSamples
+}
+
+func (r *racer) threshold() time.Duration {
+	r.mux.Lock()
+	if len(r.samples) < raceMinSamples {
+		r.mux.Unlock()
+		return raceDefaultThreshold
+	}
+	samples := append([]time.Duration(nil), r.samples...)
+	r.mux.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	d := samples[int(float64(len(samples)-1)*racePercentile)]
+	if d < raceMinThreshold {
+		d = raceMinThreshold
+	}
+	return d
+}
+
+type raceResult struct {
+	conn    net.Conn
+	err     error
+	attempt int
+	elapsed time.Duration
+}
+
+// dial returns the first attempt that succeeds, the others are cancelled and closed
+func (r *racer) dial(ctx context.Context, n int, dial func(context.Context) (net.Conn, error)) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan raceResult, n)
+	started := 0
+	start := func() {
+		attempt := started
+		started++
+		go func() {
+			begin := time.Now()
+			c, err := dial(ctx)
+			results <- raceResult{conn: c, err: err, attempt: attempt, elapsed: time.Since(begin)}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(r.threshold())
+	defer timer.Stop()
+
+	var (
+		err     error
+		pending = 1
+	)
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if started < n {
+				r.raced.Inc()
+				start()
+				pending++
+				timer.Reset(r.threshold())
+			}
+		case result := <-results:
+			pending--
+			if result.err != nil {
+				err = result.err
+				if started < n && ctx.Err() == nil {
+					start()
+					pending++
+				}
+				continue
+			}
+
+			r.record(result.elapsed)
+			if result.attempt != 0 {
+				r.won.Inc()
+			}
+			go drainRace(results, pending)
+			return result.conn, nil
+		}
+	}
+	return nil, err
+}
+
+func drainRace(results chan raceResult, pending int) {
+	for ; pending > 0; pending-- {
+		if result := <-results; result.conn != nil {
+			result.conn.Close()
+		}
+	}
+}
+
+// stats return the number of raced dials, how many a later attempt won and the threshold in milliseconds
+func (r *racer) stats() map[string]any {
+	return map[string]any{
+		"raced":     r.raced.Load(),
+		"won":       r.won.Load(),
+		"threshold": r.threshold().Milliseconds(),
+	}
+}
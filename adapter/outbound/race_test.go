@@ -0,0 +1,58 @@
+package outbound
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+)
+
+func TestRacer_SlowFirstAttempt(t *testing.T) {
+	r := &racer{}
+	for i := 0; i < raceMinSamples; i++ {
+		r.record(10 * time.Millisecond)
+	}
+	assert.Equal(t, raceMinThreshold, r.threshold())
+
+	var (
+		attempts = atomic.NewInt32(0)
+		closed   = make(chan struct{})
+	)
+	c, err := r.dial(context.Background(), 2, func(ctx context.Context) (net.Conn, error) {
+		left, right := net.Pipe()
+		if attempts.Inc() == 1 {
+			// the first handshake stalls and is closed once it completes
+			time.Sleep(300 * time.Millisecond)
+			go func() {
+				right.Read(make([]byte, 1))
+				close(closed)
+			}()
+		}
+		return left, nil
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, c)
+	assert.EqualValues(t, 2, attempts.Load())
+	assert.EqualValues(t, 1, r.raced.Load())
+	assert.EqualValues(t, 1, r.won.Load())
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("losing attempt not closed")
+	}
+}
+
+func TestRacer_Failure(t *testing.T) {
+	r := &racer{}
+	attempts := atomic.NewInt32(0)
+	_, err := r.dial(context.Background(), 2, func(ctx context.Context) (net.Conn, error) {
+		attempts.Inc()
+		return nil, net.ErrClosed
+	})
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.EqualValues(t, 2, attempts.Load())
+}
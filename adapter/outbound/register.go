@@ -0,0 +1,45 @@
+package outbound
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Dreamacro/clash/common/structure"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+// AdapterParser builds the adapter of a proxies entry, mapping holds its fields with type
+type AdapterParser func(mapping map[string]any) (C.ProxyAdapter, error)
+
+var (
+	adaptersMux sync.RWMutex
+	adapters    = map[string]AdapterParser{}
+)
+
+// RegisterAdapter adds the proxy type typeName, a package out of this repo calls it from its
+// init and a fork links it in with a blank import in its main. The registered types are
+// looked up before the builtin ones, so one of them can be replaced. Registering a type twice
+// panics.
+func RegisterAdapter(typeName string, parse AdapterParser) {
+	adaptersMux.Lock()
+	defer adaptersMux.Unlock()
+	if _, exist := adapters[typeName]; exist {
+		panic(fmt.Sprintf("proxy type %s is registered twice", typeName))
+	}
+	adapters[typeName] = parse
+}
+
+// RegisteredAdapter return the parser of a type of RegisterAdapter
+func RegisteredAdapter(typeName string) (AdapterParser, bool) {
+	adaptersMux.RLock()
+	defer adaptersMux.RUnlock()
+	parse, ok := adapters[typeName]
+	return parse, ok
+}
+
+// DecodeOption decodes the mapping of a proxies entry into option by the proxy tags, the way
+// the builtin types are
+func DecodeOption(mapping map[string]any, option any) error {
+	decoder := structure.NewDecoder(structure.Option{TagName: "proxy", WeaklyTypedInput: true})
+	return decoder.Decode(mapping, option)
+}
@@ -2,14 +2,19 @@ package outbound
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Dreamacro/clash/common/structure"
 	"github.com/Dreamacro/clash/component/dialer"
 	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport"
+	"github.com/Dreamacro/clash/transport/quicconn"
 	"github.com/Dreamacro/clash/transport/shadowsocks/core"
 	obfs "github.com/Dreamacro/clash/transport/simple-obfs"
 	"github.com/Dreamacro/clash/transport/socks5"
@@ -24,6 +29,10 @@ type ShadowSocks struct {
 	obfsMode    string
 	obfsOption  *simpleObfsOption
 	v2rayOption *v2rayObfs.Option
+	// the connection of the v2ray-plugin quic mode
+	quic *quicPool[*v2rayObfs.QUICClient]
+	// a plugin of transport.RegisterPlugin
+	plugin transport.Plugin
 }
 
 type ShadowSocksOption struct {
@@ -50,12 +59,29 @@ type v2rayObfsOption struct {
 	TLS            bool              `obfs:"tls,omitempty"`
 	Headers        map[string]string `obfs:"headers,omitempty"`
 	SkipCertVerify bool              `obfs:"skip-cert-verify,omitempty"`
+	Certificate    string            `obfs:"certificate,omitempty"`
+	Fingerprint    string            `obfs:"fingerprint,omitempty"`
 	Mux            bool              `obfs:"mux,omitempty"`
+	QUICOpts       v2rayQUICOption   `obfs:"quic-opts,omitempty"`
+}
+
+type v2rayQUICOption struct {
+	Up            string `obfs:"up,omitempty"`
+	ReceiveWindow int    `obfs:"receive-window,omitempty"`
+	IdleTimeout   int    `obfs:"idle-timeout,omitempty"`
 }
 
 // StreamConn implements C.ProxyAdapter
 func (ss *ShadowSocks) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	if ss.plugin != nil {
+		var err error
+		if c, err = ss.plugin.StreamConn(c); err != nil {
+			return nil, fmt.Errorf("%s connect error: %w", ss.addr, err)
+		}
+	}
 	switch ss.obfsMode {
+	case "quic":
+		return nil, fmt.Errorf("%s v2ray-plugin mode quic doesn't run over another proxy", ss.addr)
 	case "tls":
 		c = obfs.NewTLSObfs(c, ss.obfsOption.Host)
 	case "http":
@@ -75,6 +101,10 @@ func (ss *ShadowSocks) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, e
 
 // DialContext implements C.ProxyAdapter
 func (ss *ShadowSocks) DialContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (_ C.Conn, err error) {
+	if ss.quic != nil {
+		return ss.dialQUIC(ctx, metadata, opts)
+	}
+
 	c, err := dialer.DialContext(ctx, "tcp", ss.addr, ss.Base.DialOptions(opts...)...)
 	if err != nil {
 		return nil, fmt.Errorf("%s connect error: %w", ss.addr, err)
@@ -89,6 +119,57 @@ func (ss *ShadowSocks) DialContext(ctx context.Context, metadata *C.Metadata, op
 	return NewConn(c, ss), err
 }
 
+// dialQUIC opens a stream of the quic connection of the plugin
+func (ss *ShadowSocks) dialQUIC(ctx context.Context, metadata *C.Metadata, opts []dialer.Option) (C.Conn, error) {
+	client, shared, err := ss.quic.get(ctx, ss.Base.DialOptions(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", ss.addr, err)
+	}
+	c, err := client.Dial(ctx)
+	if err != nil {
+		if !shared {
+			client.Close()
+		}
+		return nil, fmt.Errorf("%s connect error: %w", ss.addr, err)
+	}
+	c = ss.cipher.StreamConn(wrapQUICConn(c, client, shared))
+	if _, err := c.Write(serializesSocksAddr(metadata)); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return NewConn(c, ss), nil
+}
+
+// MarshalJSON implements C.ProxyAdapter, the quic mode of v2ray-plugin adds the stats of
+// its connection
+func (ss *ShadowSocks) MarshalJSON() ([]byte, error) {
+	if ss.quic == nil {
+		return ss.Base.MarshalJSON()
+	}
+	mapping := map[string]any{
+		"type": ss.Type().String(),
+	}
+	ss.quic.stats(mapping)
+	return json.Marshal(mapping)
+}
+
+// UDPFraming implements C.UDPFramer
+func (ss *ShadowSocks) UDPFraming() C.UDPFraming {
+	return C.UDPFraming{Overhead: cipherOverhead(ss.cipher) + udpAddrOverhead}
+}
+
+// cipherOverhead is the salt and the tag of an aead cipher or the iv of a stream one
+func cipherOverhead(c core.Cipher) int {
+	switch c := c.(type) {
+	case *core.AeadCipher:
+		// the tag of all the aead ciphers is 16 bytes
+		return c.SaltSize() + 16
+	case *core.StreamCipher:
+		return c.IVSize()
+	}
+	return 0
+}
+
 // ListenPacketContext implements C.ProxyAdapter
 func (ss *ShadowSocks) ListenPacketContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.PacketConn, error) {
 	pc, err := dialer.ListenPacket(ctx, "udp", "", ss.Base.DialOptions(opts...)...)
@@ -116,11 +197,17 @@ func NewShadowSocks(option ShadowSocksOption) (*ShadowSocks, error) {
 	}
 
 	var v2rayOption *v2rayObfs.Option
+	var quic *quicPool[*v2rayObfs.QUICClient]
 	var obfsOption *simpleObfsOption
+	var plugin transport.Plugin
 	obfsMode := ""
 
 	decoder := structure.NewDecoder(structure.Option{TagName: "obfs", WeaklyTypedInput: true})
-	if option.Plugin == "obfs" {
+	if constructor, ok := transport.RegisteredPlugin(option.Plugin); ok {
+		if plugin, err = constructor(addr, option.PluginOpts); err != nil {
+			return nil, fmt.Errorf("ss %s initialize %s error: %w", addr, option.Plugin, err)
+		}
+	} else if option.Plugin == "obfs" {
 		opts := simpleObfsOption{Host: "bing.com"}
 		if err := decoder.Decode(option.PluginOpts, &opts); err != nil {
 			return nil, fmt.Errorf("ss %s initialize obfs error: %w", addr, err)
@@ -137,7 +224,7 @@ func NewShadowSocks(option ShadowSocksOption) (*ShadowSocks, error) {
 			return nil, fmt.Errorf("ss %s initialize v2ray-plugin error: %w", addr, err)
 		}
 
-		if opts.Mode != "websocket" {
+		if opts.Mode != "websocket" && opts.Mode != "quic" {
 			return nil, fmt.Errorf("ss %s obfs mode error: %s", addr, opts.Mode)
 		}
 		obfsMode = opts.Mode
@@ -148,26 +235,71 @@ func NewShadowSocks(option ShadowSocksOption) (*ShadowSocks, error) {
 			Mux:     opts.Mux,
 		}
 
-		if opts.TLS {
+		// quic always runs over tls
+		if opts.TLS || opts.Mode == "quic" {
 			v2rayOption.TLS = true
 			v2rayOption.SkipCertVerify = opts.SkipCertVerify
+
+			if opts.Certificate != "" {
+				certificate := opts.Certificate
+				if !strings.Contains(certificate, "-----BEGIN") {
+					certificate = C.Path.Resolve(certificate)
+				}
+				pool, err := v2rayObfs.LoadCertificate(certificate)
+				if err != nil {
+					return nil, fmt.Errorf("ss %s v2ray-plugin certificate error: %w", addr, err)
+				}
+				v2rayOption.RootCAs = pool
+			}
+			if opts.Fingerprint != "" {
+				fp, err := v2rayObfs.ParseFingerprint(opts.Fingerprint)
+				if err != nil {
+					return nil, fmt.Errorf("ss %s v2ray-plugin fingerprint error: %w", addr, err)
+				}
+				v2rayOption.Fingerprint = fp
+			}
+		}
+
+		if opts.Mode == "quic" {
+			// the plugin listens for quic on the udp port the relay would go to
+			if option.UDP {
+				return nil, fmt.Errorf("ss %s v2ray-plugin mode quic takes the udp port of the server, udp relay is not possible", addr)
+			}
+			up, err := quicconn.ParseBandwidth(opts.QUICOpts.Up)
+			if err != nil {
+				return nil, fmt.Errorf("ss %s v2ray-plugin quic-opts up: %w", addr, err)
+			}
+			quicOption := &v2rayObfs.QUICOption{
+				Up:            up,
+				ReceiveWindow: uint64(opts.QUICOpts.ReceiveWindow),
+				IdleTimeout:   time.Duration(opts.QUICOpts.IdleTimeout) * time.Second,
+			}
+			quic, err = newQUICPool(option.Name, addr, QUICPortsOption{}, func(ctx context.Context, pc net.PacketConn, addr net.Addr) (*v2rayObfs.QUICClient, error) {
+				return v2rayObfs.DialQUIC(ctx, pc, addr, v2rayOption, quicOption)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("ss %s initialize v2ray-plugin error: %w", addr, err)
+			}
 		}
 	}
 
 	return &ShadowSocks{
 		Base: &Base{
-			name:  option.Name,
-			addr:  addr,
-			tp:    C.Shadowsocks,
-			udp:   option.UDP,
-			iface: option.Interface,
-			rmark: option.RoutingMark,
+			name:    option.Name,
+			addr:    addr,
+			tp:      C.Shadowsocks,
+			udp:     option.UDP,
+			iface:   option.Interface,
+			rmark:   option.RoutingMark,
+			resolve: option.DNSResolve,
 		},
 		cipher: ciph,
 
 		obfsMode:    obfsMode,
 		v2rayOption: v2rayOption,
+		quic:        quic,
 		obfsOption:  obfsOption,
+		plugin:      plugin,
 	}, nil
 }
 
@@ -0,0 +1,100 @@
+package outbound
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport/quicconn"
+	"github.com/Dreamacro/clash/transport/shadowsocks/core"
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShadowSocks_V2rayPluginQUIC(t *testing.T) {
+	certPEM, keyPEM := newKeyPair(t)
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	require.NoError(t, err)
+	ln, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2"},
+	}, &quic.Config{})
+	require.NoError(t, err)
+	defer ln.Close()
+
+	// the server side of the plugin and of ss, it answers the target of each stream
+	cipher, err := core.PickCipher("aes-128-gcm", nil, "password")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					s, err := conn.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+					go func() {
+						c := cipher.StreamConn(quicconn.NewStreamConn(s, conn.LocalAddr(), conn.RemoteAddr(), nil))
+						defer c.Close()
+						target, err := socks5.ReadAddr(c, make([]byte, socks5.MaxAddrLen))
+						if err != nil {
+							return
+						}
+						c.Write([]byte(target.String()))
+					}()
+				}
+			}()
+		}
+	}()
+
+	option := ShadowSocksOption{
+		Name:     "ss",
+		Server:   "127.0.0.1",
+		Port:     ln.Addr().(*net.UDPAddr).Port,
+		Password: "password",
+		Cipher:   "aes-128-gcm",
+		Plugin:   "v2ray-plugin",
+		PluginOpts: map[string]any{
+			"mode":             "quic",
+			"host":             "localhost",
+			"skip-cert-verify": true,
+			"mux":              false,
+			"quic-opts":        map[string]any{"up": "100 mbps", "receive-window": 1 << 20},
+		},
+	}
+	ss, err := NewShadowSocks(option)
+	require.NoError(t, err)
+
+	for _, port := range []int{80, 443} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := ss.DialContext(ctx, &C.Metadata{Host: "example.com", DstPort: strconv.Itoa(port)})
+		require.NoError(t, err)
+		expected := "example.com:" + strconv.Itoa(port)
+		buf := make([]byte, len(expected))
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(buf))
+		conn.Close()
+	}
+	defer ss.quic.client.Close()
+
+	_, err = ss.StreamConn(nil, &C.Metadata{})
+	assert.ErrorContains(t, err, "doesn't run over another proxy")
+
+	option.UDP = true
+	_, err = NewShadowSocks(option)
+	assert.ErrorContains(t, err, "udp relay is not possible")
+}
@@ -74,6 +74,11 @@ func (ssr *ShadowSocksR) DialContext(ctx context.Context, metadata *C.Metadata,
 	return NewConn(c, ssr), err
 }
 
+// UDPFraming implements C.UDPFramer, the auth protocols add up to 8 bytes
+func (ssr *ShadowSocksR) UDPFraming() C.UDPFraming {
+	return C.UDPFraming{Overhead: cipherOverhead(ssr.cipher) + udpAddrOverhead + 8}
+}
+
 // ListenPacketContext implements C.ProxyAdapter
 func (ssr *ShadowSocksR) ListenPacketContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.PacketConn, error) {
 	pc, err := dialer.ListenPacket(ctx, "udp", "", ssr.Base.DialOptions(opts...)...)
@@ -145,12 +150,13 @@ func NewShadowSocksR(option ShadowSocksROption) (*ShadowSocksR, error) {
 
 	return &ShadowSocksR{
 		Base: &Base{
-			name:  option.Name,
-			addr:  addr,
-			tp:    C.ShadowsocksR,
-			udp:   option.UDP,
-			iface: option.Interface,
-			rmark: option.RoutingMark,
+			name:    option.Name,
+			addr:    addr,
+			tp:      C.ShadowsocksR,
+			udp:     option.UDP,
+			iface:   option.Interface,
+			rmark:   option.RoutingMark,
+			resolve: option.DNSResolve,
 		},
 		cipher:   coreCiph,
 		obfs:     obfs,
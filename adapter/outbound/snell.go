@@ -88,6 +88,11 @@ func (s *Snell) DialContext(ctx context.Context, metadata *C.Metadata, opts ...d
 	return NewConn(c, s), err
 }
 
+// UDPFraming implements C.UDPFramer, a datagram has a command and its address in the stream
+func (s *Snell) UDPFraming() C.UDPFraming {
+	return C.UDPFraming{Overhead: 1 + udpAddrOverhead, Stream: true, MaxPayload: 0x3FFF}
+}
+
 // ListenPacketContext implements C.ProxyAdapter
 func (s *Snell) ListenPacketContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.PacketConn, error) {
 	c, err := dialer.DialContext(ctx, "tcp", s.addr, s.Base.DialOptions(opts...)...)
@@ -139,12 +144,13 @@ func NewSnell(option SnellOption) (*Snell, error) {
 
 	s := &Snell{
 		Base: &Base{
-			name:  option.Name,
-			addr:  addr,
-			tp:    C.Snell,
-			udp:   option.UDP,
-			iface: option.Interface,
-			rmark: option.RoutingMark,
+			name:    option.Name,
+			addr:    addr,
+			tp:      C.Snell,
+			udp:     option.UDP,
+			iface:   option.Interface,
+			rmark:   option.RoutingMark,
+			resolve: option.DNSResolve,
 		},
 		psk:        psk,
 		obfsOption: obfsOption,
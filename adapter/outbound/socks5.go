@@ -81,6 +81,12 @@ func (ss *Socks5) DialContext(ctx context.Context, metadata *C.Metadata, opts ..
 	return NewConn(c, ss), nil
 }
 
+// UDPFraming implements C.UDPFramer, a datagram has the reserved bytes and the fragment
+// number before its address
+func (ss *Socks5) UDPFraming() C.UDPFraming {
+	return C.UDPFraming{Overhead: 3 + udpAddrOverhead}
+}
+
 // ListenPacketContext implements C.ProxyAdapter
 func (ss *Socks5) ListenPacketContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (_ C.PacketConn, err error) {
 	c, err := dialer.DialContext(ctx, "tcp", ss.addr, ss.Base.DialOptions(opts...)...)
@@ -157,12 +163,13 @@ func NewSocks5(option Socks5Option) *Socks5 {
 
 	return &Socks5{
 		Base: &Base{
-			name:  option.Name,
-			addr:  net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
-			tp:    C.Socks5,
-			udp:   option.UDP,
-			iface: option.Interface,
-			rmark: option.RoutingMark,
+			name:    option.Name,
+			addr:    net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
+			tp:      C.Socks5,
+			udp:     option.UDP,
+			iface:   option.Interface,
+			rmark:   option.RoutingMark,
+			resolve: option.DNSResolve,
 		},
 		user:           option.UserName,
 		pass:           option.Password,
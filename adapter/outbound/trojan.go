@@ -3,6 +3,7 @@ package outbound
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
@@ -16,10 +17,14 @@ import (
 	"golang.org/x/net/http2"
 )
 
+// tls sessions kept per trojan proxy for resumption
+const trojanSessionCacheSize = 32
+
 type Trojan struct {
 	*Base
 	instance *trojan.Trojan
 	option   *TrojanOption
+	racer    *racer
 
 	// for gun mux
 	gunTLSConfig *tls.Config
@@ -29,6 +34,7 @@ type Trojan struct {
 
 type TrojanOption struct {
 	BasicOption
+	ClientCertOption
 	Name           string      `proxy:"name"`
 	Server         string      `proxy:"server"`
 	Port           int         `proxy:"port"`
@@ -40,6 +46,7 @@ type TrojanOption struct {
 	Network        string      `proxy:"network,omitempty"`
 	GrpcOpts       GrpcOptions `proxy:"grpc-opts,omitempty"`
 	WSOpts         WSOptions   `proxy:"ws-opts,omitempty"`
+	RaceDial       int         `proxy:"race-dial,omitempty"`
 }
 
 func (t *Trojan) plainStream(c net.Conn) (net.Conn, error) {
@@ -69,15 +76,16 @@ func (t *Trojan) plainStream(c net.Conn) (net.Conn, error) {
 	return t.instance.StreamConn(c)
 }
 
-// StreamConn implements C.ProxyAdapter
-func (t *Trojan) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
-	var err error
+func (t *Trojan) tlsStream(c net.Conn) (net.Conn, error) {
 	if t.transport != nil {
-		c, err = gun.StreamGunWithConn(c, t.gunTLSConfig, t.gunConfig)
-	} else {
-		c, err = t.plainStream(c)
+		return gun.StreamGunWithConn(c, t.gunTLSConfig, t.gunConfig)
 	}
+	return t.plainStream(c)
+}
 
+// StreamConn implements C.ProxyAdapter
+func (t *Trojan) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
+	c, err := t.tlsStream(c)
 	if err != nil {
 		return nil, fmt.Errorf("%s connect error: %w", t.addr, err)
 	}
@@ -86,6 +94,52 @@ func (t *Trojan) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error)
 	return c, err
 }
 
+// dialStream dials the server and completes the handshake of stream, with race-dial
+// a slow handshake is raced by another one and the loser is closed
+func (t *Trojan) dialStream(ctx context.Context, stream func(net.Conn) (net.Conn, error), opts ...dialer.Option) (net.Conn, error) {
+	dial := func(ctx context.Context) (net.Conn, error) {
+		c, err := dialer.DialContext(ctx, "tcp", t.addr, t.Base.DialOptions(opts...)...)
+		if err != nil {
+			return nil, fmt.Errorf("%s connect error: %w", t.addr, err)
+		}
+		tcpKeepAlive(c)
+
+		sc, err := stream(c)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("%s connect error: %w", t.addr, err)
+		}
+		return sc, nil
+	}
+
+	if t.racer == nil {
+		return dial(ctx)
+	}
+	return t.racer.dial(ctx, t.option.RaceDial, dial)
+}
+
+// MarshalJSON implements C.ProxyAdapter
+func (t *Trojan) MarshalJSON() ([]byte, error) {
+	handshakes, resumed := t.instance.ResumeStats()
+	rate := 0.0
+	if handshakes != 0 {
+		rate = float64(resumed) / float64(handshakes)
+	}
+
+	mapping := map[string]any{
+		"type": t.Type().String(),
+		"tls": map[string]any{
+			"handshakes":     handshakes,
+			"resumed":        resumed,
+			"resumptionRate": rate,
+		},
+	}
+	if t.racer != nil {
+		mapping["race"] = t.racer.stats()
+	}
+	return json.Marshal(mapping)
+}
+
 // DialContext implements C.ProxyAdapter
 func (t *Trojan) DialContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (_ C.Conn, err error) {
 	// gun transport
@@ -103,24 +157,27 @@ func (t *Trojan) DialContext(ctx context.Context, metadata *C.Metadata, opts ...
 		return NewConn(c, t), nil
 	}
 
-	c, err := dialer.DialContext(ctx, "tcp", t.addr, t.Base.DialOptions(opts...)...)
+	c, err := t.dialStream(ctx, t.tlsStream, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("%s connect error: %w", t.addr, err)
+		return nil, err
 	}
-	tcpKeepAlive(c)
 
 	defer func(c net.Conn) {
 		safeConnClose(c, err)
 	}(c)
 
-	c, err = t.StreamConn(c, metadata)
-	if err != nil {
+	if err = t.instance.WriteHeader(c, trojan.CommandTCP, serializesSocksAddr(metadata)); err != nil {
 		return nil, err
 	}
 
 	return NewConn(c, t), err
 }
 
+// UDPFraming implements C.UDPFramer, a datagram has its address, length and crlf in the stream
+func (t *Trojan) UDPFraming() C.UDPFraming {
+	return C.UDPFraming{Overhead: udpAddrOverhead + 4, Stream: true, MaxPayload: 8192}
+}
+
 // ListenPacketContext implements C.ProxyAdapter
 func (t *Trojan) ListenPacketContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (_ C.PacketConn, err error) {
 	var c net.Conn
@@ -135,18 +192,13 @@ func (t *Trojan) ListenPacketContext(ctx context.Context, metadata *C.Metadata,
 			safeConnClose(c, err)
 		}(c)
 	} else {
-		c, err = dialer.DialContext(ctx, "tcp", t.addr, t.Base.DialOptions(opts...)...)
+		c, err = t.dialStream(ctx, t.plainStream, opts...)
 		if err != nil {
-			return nil, fmt.Errorf("%s connect error: %w", t.addr, err)
+			return nil, err
 		}
 		defer func(c net.Conn) {
 			safeConnClose(c, err)
 		}(c)
-		tcpKeepAlive(c)
-		c, err = t.plainStream(c)
-		if err != nil {
-			return nil, fmt.Errorf("%s connect error: %w", t.addr, err)
-		}
 	}
 
 	err = t.instance.WriteHeader(c, trojan.CommandUDP, serializesSocksAddr(metadata))
@@ -161,11 +213,18 @@ func (t *Trojan) ListenPacketContext(ctx context.Context, metadata *C.Metadata,
 func NewTrojan(option TrojanOption) (*Trojan, error) {
 	addr := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
 
+	clientCert, err := option.load()
+	if err != nil {
+		return nil, err
+	}
+
 	tOption := &trojan.Option{
-		Password:       option.Password,
-		ALPN:           option.ALPN,
-		ServerName:     option.Server,
-		SkipCertVerify: option.SkipCertVerify,
+		Password:           option.Password,
+		ALPN:               option.ALPN,
+		ServerName:         option.Server,
+		SkipCertVerify:     option.SkipCertVerify,
+		ClientSessionCache: tls.NewLRUClientSessionCache(trojanSessionCacheSize),
+		ClientCert:         clientCert,
 	}
 
 	if option.SNI != "" {
@@ -174,17 +233,22 @@ func NewTrojan(option TrojanOption) (*Trojan, error) {
 
 	t := &Trojan{
 		Base: &Base{
-			name:  option.Name,
-			addr:  addr,
-			tp:    C.Trojan,
-			udp:   option.UDP,
-			iface: option.Interface,
-			rmark: option.RoutingMark,
+			name:    option.Name,
+			addr:    addr,
+			tp:      C.Trojan,
+			udp:     option.UDP,
+			iface:   option.Interface,
+			rmark:   option.RoutingMark,
+			resolve: option.DNSResolve,
 		},
 		instance: trojan.New(tOption),
 		option:   &option,
 	}
 
+	if option.RaceDial > 1 {
+		t.racer = &racer{}
+	}
+
 	if option.Network == "grpc" {
 		dialFn := func(network, addr string) (net.Conn, error) {
 			c, err := dialer.DialContext(context.Background(), "tcp", t.addr, t.Base.DialOptions()...)
@@ -195,12 +259,13 @@ func NewTrojan(option TrojanOption) (*Trojan, error) {
 			return c, nil
 		}
 
-		tlsConfig := &tls.Config{
+		tlsConfig := clientCert.Apply(&tls.Config{
 			NextProtos:         option.ALPN,
 			MinVersion:         tls.VersionTLS12,
 			InsecureSkipVerify: tOption.SkipCertVerify,
 			ServerName:         tOption.ServerName,
-		}
+			ClientSessionCache: tOption.ClientSessionCache,
+		})
 
 		t.transport = gun.NewHTTP2Client(dialFn, tlsConfig)
 		t.gunTLSConfig = tlsConfig
@@ -0,0 +1,134 @@
+package outbound
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport/socks5"
+	"github.com/Dreamacro/clash/transport/tuic"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+type Tuic struct {
+	*Base
+	pool *quicPool[*tuic.Client]
+}
+
+type TuicOption struct {
+	BasicOption
+	QUICPortsOption
+	Name              string   `proxy:"name"`
+	Server            string   `proxy:"server"`
+	Port              int      `proxy:"port,omitempty"`
+	UUID              string   `proxy:"uuid"`
+	Password          string   `proxy:"password"`
+	SNI               string   `proxy:"sni,omitempty"`
+	SkipCertVerify    bool     `proxy:"skip-cert-verify,omitempty"`
+	ALPN              []string `proxy:"alpn,omitempty"`
+	UDPRelayMode      string   `proxy:"udp-relay-mode,omitempty"`
+	HeartbeatInterval int      `proxy:"heartbeat-interval,omitempty"`
+	UDP               bool     `proxy:"udp,omitempty"`
+}
+
+// DialContext implements C.ProxyAdapter
+func (t *Tuic) DialContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.Conn, error) {
+	target := socks5.ParseAddr(metadata.RemoteAddress())
+	if target == nil {
+		return nil, fmt.Errorf("invalid address %s", metadata.RemoteAddress())
+	}
+	client, shared, err := t.pool.get(ctx, t.Base.DialOptions(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", t.addr, err)
+	}
+	c, err := client.DialTCP(ctx, target)
+	if err != nil {
+		if !shared {
+			client.Close()
+		}
+		return nil, err
+	}
+	return NewConn(wrapQUICConn(c, client, shared), t), nil
+}
+
+// ListenPacketContext implements C.ProxyAdapter
+func (t *Tuic) ListenPacketContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.PacketConn, error) {
+	client, shared, err := t.pool.get(ctx, t.Base.DialOptions(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s connect error: %w", t.addr, err)
+	}
+	pc, err := client.ListenUDP()
+	if err != nil {
+		if !shared {
+			client.Close()
+		}
+		return nil, err
+	}
+	return newPacketConn(wrapQUICPacketConn(pc, client, shared), t), nil
+}
+
+// MarshalJSON implements C.ProxyAdapter
+func (t *Tuic) MarshalJSON() ([]byte, error) {
+	mapping := map[string]any{
+		"type": t.Type().String(),
+	}
+	t.pool.stats(mapping)
+	return json.Marshal(mapping)
+}
+
+func NewTuic(option TuicOption) (*Tuic, error) {
+	addr := net.JoinHostPort(option.Server, strconv.Itoa(option.Port))
+	id, err := uuid.FromString(option.UUID)
+	if err != nil {
+		return nil, fmt.Errorf("tuic %s uuid: %w", addr, err)
+	}
+	switch option.UDPRelayMode {
+	case "":
+		option.UDPRelayMode = tuic.RelayNative
+	case tuic.RelayNative, tuic.RelayQUIC:
+	default:
+		return nil, fmt.Errorf("tuic %s udp-relay-mode error: %s", addr, option.UDPRelayMode)
+	}
+	if option.Port == 0 && option.Ports == "" {
+		return nil, fmt.Errorf("tuic %s: missing port", addr)
+	}
+
+	serverName := option.Server
+	if option.SNI != "" {
+		serverName = option.SNI
+	}
+	clientOption := tuic.Option{
+		UUID:              id,
+		Password:          option.Password,
+		ServerName:        serverName,
+		SkipCertVerify:    option.SkipCertVerify,
+		ALPN:              option.ALPN,
+		UDPRelayMode:      option.UDPRelayMode,
+		HeartbeatInterval: time.Duration(option.HeartbeatInterval) * time.Millisecond,
+	}
+	pool, err := newQUICPool(option.Name, addr, option.QUICPortsOption, func(ctx context.Context, pc net.PacketConn, addr net.Addr) (*tuic.Client, error) {
+		return tuic.NewClient(ctx, pc, addr, clientOption)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("tuic %s ports: %w", addr, err)
+	}
+
+	return &Tuic{
+		Base: &Base{
+			name:    option.Name,
+			addr:    addr,
+			tp:      C.Tuic,
+			udp:     option.UDP,
+			iface:   option.Interface,
+			rmark:   option.RoutingMark,
+			resolve: option.DNSResolve,
+		},
+		pool: pool,
+	}, nil
+}
@@ -12,6 +12,10 @@ import (
 	"github.com/Dreamacro/protobytes"
 )
 
+// udpAddrOverhead is the socks address of a datagram to an ipv6 destination, the one to a
+// domain takes its length less 16 more
+const udpAddrOverhead = 1 + 16 + 2
+
 func tcpKeepAlive(c net.Conn) {
 	if tcp, ok := c.(*net.TCPConn); ok {
 		tcp.SetKeepAlive(true)
@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/Dreamacro/clash/component/clientcert"
 	"github.com/Dreamacro/clash/component/dialer"
 	"github.com/Dreamacro/clash/component/resolver"
 	C "github.com/Dreamacro/clash/constant"
@@ -24,8 +25,9 @@ var ErrUDPRemoteAddrMismatch = errors.New("udp packet dropped due to mismatched
 
 type Vmess struct {
 	*Base
-	client *vmess.Client
-	option *VmessOption
+	client     *vmess.Client
+	option     *VmessOption
+	clientCert *clientcert.Certificate
 
 	// for gun mux
 	gunTLSConfig *tls.Config
@@ -35,6 +37,7 @@ type Vmess struct {
 
 type VmessOption struct {
 	BasicOption
+	ClientCertOption
 	Name           string       `proxy:"name"`
 	Server         string       `proxy:"server"`
 	Port           int          `proxy:"port"`
@@ -98,11 +101,11 @@ func (v *Vmess) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
 
 		if v.option.TLS {
 			wsOpts.TLS = true
-			wsOpts.TLSConfig = &tls.Config{
+			wsOpts.TLSConfig = v.clientCert.Apply(&tls.Config{
 				ServerName:         host,
 				InsecureSkipVerify: v.option.SkipCertVerify,
 				NextProtos:         []string{"http/1.1"},
-			}
+			})
 			if v.option.ServerName != "" {
 				wsOpts.TLSConfig.ServerName = v.option.ServerName
 			} else if host := wsOpts.Headers.Get("Host"); host != "" {
@@ -117,6 +120,7 @@ func (v *Vmess) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
 			tlsOpts := &vmess.TLSConfig{
 				Host:           host,
 				SkipCertVerify: v.option.SkipCertVerify,
+				ClientCert:     v.clientCert,
 			}
 
 			if v.option.ServerName != "" {
@@ -144,6 +148,7 @@ func (v *Vmess) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
 			Host:           host,
 			SkipCertVerify: v.option.SkipCertVerify,
 			NextProtos:     []string{"h2"},
+			ClientCert:     v.clientCert,
 		}
 
 		if v.option.ServerName != "" {
@@ -170,6 +175,7 @@ func (v *Vmess) StreamConn(c net.Conn, metadata *C.Metadata) (net.Conn, error) {
 			tlsOpts := &vmess.TLSConfig{
 				Host:           host,
 				SkipCertVerify: v.option.SkipCertVerify,
+				ClientCert:     v.clientCert,
 			}
 
 			if v.option.ServerName != "" {
@@ -220,6 +226,11 @@ func (v *Vmess) DialContext(ctx context.Context, metadata *C.Metadata, opts ...d
 	return NewConn(c, v), err
 }
 
+// UDPFraming implements C.UDPFramer, a datagram is a chunk of the stream with its length and tag
+func (v *Vmess) UDPFraming() C.UDPFraming {
+	return C.UDPFraming{Overhead: 2 + 16, Stream: true}
+}
+
 // ListenPacketContext implements C.ProxyAdapter
 func (v *Vmess) ListenPacketContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (_ C.PacketConn, err error) {
 	// vmess use stream-oriented udp with a special address, so we needs a net.UDPAddr
@@ -284,17 +295,24 @@ func NewVmess(option VmessOption) (*Vmess, error) {
 		}
 	}
 
+	clientCert, err := option.load()
+	if err != nil {
+		return nil, err
+	}
+
 	v := &Vmess{
 		Base: &Base{
-			name:  option.Name,
-			addr:  net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
-			tp:    C.Vmess,
-			udp:   option.UDP,
-			iface: option.Interface,
-			rmark: option.RoutingMark,
+			name:    option.Name,
+			addr:    net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
+			tp:      C.Vmess,
+			udp:     option.UDP,
+			iface:   option.Interface,
+			rmark:   option.RoutingMark,
+			resolve: option.DNSResolve,
 		},
-		client: client,
-		option: &option,
+		client:     client,
+		option:     &option,
+		clientCert: clientCert,
 	}
 
 	switch option.Network {
@@ -316,10 +334,10 @@ func NewVmess(option VmessOption) (*Vmess, error) {
 			ServiceName: v.option.GrpcOpts.GrpcServiceName,
 			Host:        v.option.ServerName,
 		}
-		tlsConfig := &tls.Config{
+		tlsConfig := v.clientCert.Apply(&tls.Config{
 			InsecureSkipVerify: v.option.SkipCertVerify,
 			ServerName:         v.option.ServerName,
-		}
+		})
 
 		if v.option.ServerName == "" {
 			host, _, _ := net.SplitHostPort(v.addr)
@@ -1,6 +1,7 @@
 package outboundgroup
 
 import (
+	"context"
 	"time"
 
 	C "github.com/Dreamacro/clash/constant"
@@ -17,6 +18,15 @@ func touchProviders(providers []provider.ProxyProvider) {
 	}
 }
 
+// waitHealthCheck blocks until the providers probed their proxies after power save resumed
+func waitHealthCheck(ctx context.Context, providers []provider.ProxyProvider) {
+	for _, pd := range providers {
+		if w, ok := pd.(provider.HealthCheckWaiter); ok {
+			w.WaitHealthCheck(ctx)
+		}
+	}
+}
+
 func getProvidersProxies(providers []provider.ProxyProvider, touch bool) []C.Proxy {
 	proxies := []C.Proxy{}
 	for _, provider := range providers {
@@ -27,3 +37,39 @@ func getProvidersProxies(providers []provider.ProxyProvider, touch bool) []C.Pro
 	}
 	return proxies
 }
+
+// resolveAlias return the name of the proxy kept for name when a provider dropped it as a duplicate
+func resolveAlias(providers []provider.ProxyProvider, name string) string {
+	for _, pd := range providers {
+		if pa, ok := pd.(provider.ProxyAliases); ok {
+			if alias, ok := pa.Alias(name); ok {
+				return alias
+			}
+		}
+	}
+	return name
+}
+
+// Members return the names of the proxies in a group, ok is false when adapter is not a group
+func Members(adapter C.ProxyAdapter) (names []string, ok bool) {
+	var providers []provider.ProxyProvider
+	switch group := adapter.(type) {
+	case *Selector:
+		providers = group.providers
+	case *Fallback:
+		providers = group.providers
+	case *URLTest:
+		providers = group.providers
+	case *LoadBalance:
+		providers = group.providers
+	case *Relay:
+		providers = group.providers
+	default:
+		return nil, false
+	}
+
+	for _, proxy := range getProvidersProxies(providers, false) {
+		names = append(names, proxy.Name())
+	}
+	return names, true
+}
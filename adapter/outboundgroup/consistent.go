@@ -0,0 +1,75 @@
+package outboundgroup
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Dreamacro/clash/common/cache"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/samber/lo"
+)
+
+const defaultConsistentWindow = 5 * time.Minute
+
+// endpoints pins the flows of a source to a host on the proxy of the group the first one took,
+// so the tcp signaling and the udp media of a call go out of the same exit. A pin lasts the
+// window after the last flow, or until its proxy is down or out of the group.
+type endpoints struct {
+	pins *cache.LruCache
+}
+
+// newEndpoints return the endpoints of a group with consistent-endpoints, nil without it
+func newEndpoints(option *GroupCommonOption) *endpoints {
+	if !option.ConsistentEndpoints {
+		return nil
+	}
+	window := defaultConsistentWindow
+	if option.ConsistentWindow > 0 {
+		window = time.Duration(option.ConsistentWindow) * time.Second
+	}
+	return &endpoints{pins: cache.New(cache.WithSize(4096), cache.WithAge(int64(window/time.Second)))}
+}
+
+// endpointKeys are the source with the host and with the ip of the destination, a tcp flow
+// sniffed or mapped to a host pins a udp flow to its ip too
+func endpointKeys(metadata *C.Metadata) []string {
+	if metadata.SrcIP == nil {
+		return nil
+	}
+	src := metadata.SrcIP.String()
+	keys := make([]string, 0, 2)
+	if metadata.Host != "" {
+		keys = append(keys, src+" "+strings.ToLower(strings.TrimSuffix(metadata.Host, ".")))
+	}
+	if metadata.DstIP != nil {
+		keys = append(keys, src+" "+metadata.DstIP.String())
+	}
+	return keys
+}
+
+// pick return the proxy pinned for metadata while it's alive in proxies, else the one of
+// choose, pinned from then
+func (e *endpoints) pick(metadata *C.Metadata, proxies []C.Proxy, choose func() C.Proxy) C.Proxy {
+	if e == nil {
+		return choose()
+	}
+
+	keys := endpointKeys(metadata)
+	proxy := C.Proxy(nil)
+	for _, key := range keys {
+		if value, ok := e.pins.Get(key); ok {
+			if pinned := value.(C.Proxy); pinned.Alive() && lo.Contains(proxies, pinned) {
+				proxy = pinned
+				break
+			}
+		}
+	}
+	if proxy == nil {
+		proxy = choose()
+	}
+	for _, key := range keys {
+		e.pins.Set(key, proxy)
+	}
+	return proxy
+}
@@ -16,6 +16,7 @@ type Fallback struct {
 	disableUDP bool
 	single     *singledo.Single
 	providers  []provider.ProxyProvider
+	endpoints  *endpoints
 }
 
 func (f *Fallback) Now() string {
@@ -25,7 +26,7 @@ func (f *Fallback) Now() string {
 
 // DialContext implements C.ProxyAdapter
 func (f *Fallback) DialContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.Conn, error) {
-	proxy := f.findAliveProxy(true)
+	proxy := f.Unwrap(metadata)
 	c, err := proxy.DialContext(ctx, metadata, f.Base.DialOptions(opts...)...)
 	if err == nil {
 		c.AppendToChains(f)
@@ -35,7 +36,7 @@ func (f *Fallback) DialContext(ctx context.Context, metadata *C.Metadata, opts .
 
 // ListenPacketContext implements C.ProxyAdapter
 func (f *Fallback) ListenPacketContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.PacketConn, error) {
-	proxy := f.findAliveProxy(true)
+	proxy := f.Unwrap(metadata)
 	pc, err := proxy.ListenPacketContext(ctx, metadata, f.Base.DialOptions(opts...)...)
 	if err == nil {
 		pc.AppendToChains(f)
@@ -68,8 +69,10 @@ func (f *Fallback) MarshalJSON() ([]byte, error) {
 
 // Unwrap implements C.ProxyAdapter
 func (f *Fallback) Unwrap(metadata *C.Metadata) C.Proxy {
-	proxy := f.findAliveProxy(true)
-	return proxy
+	if f.endpoints == nil {
+		return f.findAliveProxy(true)
+	}
+	return f.endpoints.pick(metadata, f.proxies(false), func() C.Proxy { return f.findAliveProxy(true) })
 }
 
 func (f *Fallback) proxies(touch bool) []C.Proxy {
@@ -101,6 +104,7 @@ func NewFallback(option *GroupCommonOption, providers []provider.ProxyProvider)
 		}),
 		single:     singledo.NewSingle(defaultGetProxiesDuration),
 		providers:  providers,
+		endpoints:  newEndpoints(option),
 		disableUDP: option.DisableUDP,
 	}
 }
@@ -25,6 +25,7 @@ type LoadBalance struct {
 	single     *singledo.Single
 	providers  []provider.ProxyProvider
 	strategyFn strategyFn
+	endpoints  *endpoints
 }
 
 var errStrategy = errors.New("unsupported strategy")
@@ -138,10 +139,59 @@ func strategyConsistentHashing() strategyFn {
 	}
 }
 
+// udpHistorian is implemented by proxies keeping the results of the udp-test
+type udpHistorian interface {
+	LastUDPHistory() (C.UDPHistory, bool)
+}
+
+// strategyLeastLoss picks the alive proxy with the least loss in its last udp-test, then the
+// least jitter and round trip. The proxies without a udp-test come after, by their delay.
+func strategyLeastLoss() strategyFn {
+	return func(proxies []C.Proxy, metadata *C.Metadata) C.Proxy {
+		var best C.Proxy
+		var bestHistory C.UDPHistory
+		var bestTested bool
+		for _, proxy := range proxies {
+			if !proxy.Alive() {
+				continue
+			}
+			var history C.UDPHistory
+			tested := false
+			if h, ok := proxy.(udpHistorian); ok {
+				history, tested = h.LastUDPHistory()
+			}
+			if best == nil || lessLoss(proxy, history, tested, best, bestHistory, bestTested) {
+				best, bestHistory, bestTested = proxy, history, tested
+			}
+		}
+
+		if best == nil {
+			return proxies[0]
+		}
+		return best
+	}
+}
+
+func lessLoss(a C.Proxy, ha C.UDPHistory, testedA bool, b C.Proxy, hb C.UDPHistory, testedB bool) bool {
+	switch {
+	case testedA != testedB:
+		return testedA
+	case !testedA:
+		return a.LastDelay() < b.LastDelay()
+	case ha.Loss != hb.Loss:
+		return ha.Loss < hb.Loss
+	case ha.Jitter != hb.Jitter:
+		return ha.Jitter < hb.Jitter
+	}
+	return ha.Delay < hb.Delay
+}
+
 // Unwrap implements C.ProxyAdapter
 func (lb *LoadBalance) Unwrap(metadata *C.Metadata) C.Proxy {
 	proxies := lb.proxies(true)
-	return lb.strategyFn(proxies, metadata)
+	return lb.endpoints.pick(metadata, proxies, func() C.Proxy {
+		return lb.strategyFn(proxies, metadata)
+	})
 }
 
 func (lb *LoadBalance) proxies(touch bool) []C.Proxy {
@@ -171,6 +221,8 @@ func NewLoadBalance(option *GroupCommonOption, providers []provider.ProxyProvide
 		strategyFn = strategyConsistentHashing()
 	case "round-robin":
 		strategyFn = strategyRoundRobin()
+	case "least-loss":
+		strategyFn = strategyLeastLoss()
 	default:
 		return nil, fmt.Errorf("%w: %s", errStrategy, strategy)
 	}
@@ -184,6 +236,7 @@ func NewLoadBalance(option *GroupCommonOption, providers []provider.ProxyProvide
 		single:     singledo.NewSingle(defaultGetProxiesDuration),
 		providers:  providers,
 		strategyFn: strategyFn,
+		endpoints:  newEndpoints(option),
 		disableUDP: option.DisableUDP,
 	}, nil
 }
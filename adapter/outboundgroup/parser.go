@@ -31,6 +31,14 @@ type GroupCommonOption struct {
 	Lazy       bool     `group:"lazy,omitempty"`
 	DisableUDP bool     `group:"disable-udp,omitempty"`
 	Filter     string   `group:"filter,omitempty"`
+	Dedup      bool     `group:"dedup,omitempty"`
+	TestVia    string   `group:"test-via,omitempty"`
+	// ConsistentEndpoints pins the tcp and udp flows of a source to a host on one proxy for
+	// ConsistentWindow seconds after the last one
+	ConsistentEndpoints bool `group:"consistent-endpoints,omitempty"`
+	ConsistentWindow    int  `group:"consistent-window,omitempty"`
+
+	UDPTest provider.UDPTestOption `group:"udp-test,omitempty"`
 }
 
 func ParseProxyGroup(config map[string]any, proxyMap map[string]C.Proxy, providersMap map[string]types.ProxyProvider) (C.ProxyAdapter, error) {
@@ -64,6 +72,29 @@ func ParseProxyGroup(config map[string]any, proxyMap map[string]C.Proxy, provide
 		return nil, fmt.Errorf("%s: %w", groupName, errMissProxy)
 	}
 
+	var testVia C.Proxy
+	if groupOption.TestVia != "" {
+		if groupOption.TestVia == groupName {
+			return nil, fmt.Errorf("%s: test-via refers to the group itself", groupName)
+		}
+		via, ok := proxyMap[groupOption.TestVia]
+		if !ok {
+			return nil, fmt.Errorf("%s: test-via %s not found", groupName, groupOption.TestVia)
+		}
+		testVia = via
+	}
+
+	if groupOption.ConsistentEndpoints {
+		switch groupOption.Type {
+		case "url-test", "fallback", "load-balance":
+		default:
+			return nil, fmt.Errorf("%s: consistent-endpoints only applies to url-test, fallback and load-balance", groupName)
+		}
+	}
+	if groupOption.ConsistentWindow < 0 {
+		return nil, fmt.Errorf("%s: invalid consistent-window %d", groupName, groupOption.ConsistentWindow)
+	}
+
 	providers := []types.ProxyProvider{}
 
 	if len(groupOption.Proxies) != 0 {
@@ -92,6 +123,12 @@ func ParseProxyGroup(config map[string]any, proxyMap map[string]C.Proxy, provide
 			}
 
 			hc := provider.NewHealthCheck(ps, groupOption.URL, uint(groupOption.Interval), groupOption.Lazy)
+			hc.SetTestVia(testVia)
+			if groupOption.UDPTest.Target != "" {
+				if err := hc.SetUDPTest(groupOption.UDPTest); err != nil {
+					return nil, fmt.Errorf("%s: %w", groupName, err)
+				}
+			}
 			pd, err := provider.NewCompatibleProvider(groupName, ps, hc)
 			if err != nil {
 				return nil, fmt.Errorf("%s: %w", groupName, err)
@@ -115,6 +152,11 @@ func ParseProxyGroup(config map[string]any, proxyMap map[string]C.Proxy, provide
 		}
 	}
 
+	// the names of the proxies from several providers may collide
+	if groupOption.Dedup || len(providers) > 1 || (filterReg != nil && len(groupOption.Use) > 1) {
+		providers = []types.ProxyProvider{provider.NewMergedProvider(groupName, providers, groupOption.Dedup)}
+	}
+
 	var group C.ProxyAdapter
 	switch groupOption.Type {
 	case "url-test":
@@ -126,6 +168,9 @@ func ParseProxyGroup(config map[string]any, proxyMap map[string]C.Proxy, provide
 		group = NewFallback(groupOption, providers)
 	case "load-balance":
 		strategy := parseStrategy(config)
+		if strategy == "least-loss" && len(groupOption.Use) == 0 && groupOption.UDPTest.Target == "" {
+			return nil, fmt.Errorf("%s: strategy least-loss needs udp-test", groupName)
+		}
 		return NewLoadBalance(groupOption, providers, strategy)
 	case "relay":
 		group = NewRelay(groupOption, providers)
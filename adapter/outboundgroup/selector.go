@@ -65,16 +65,27 @@ func (s *Selector) Now() string {
 	return s.selectedProxy(false).Name()
 }
 
+// Set selects the proxy, the name of a dropped duplicate selects the proxy kept for it
 func (s *Selector) Set(name string) error {
+	name = resolveAlias(s.providers, name)
+	if !s.Selectable(name) {
+		return errors.New("proxy not exist")
+	}
+
+	s.selected = name
+	s.single.Reset()
+	return nil
+}
+
+// Selectable reports whether name is a candidate of the selector
+func (s *Selector) Selectable(name string) bool {
+	name = resolveAlias(s.providers, name)
 	for _, proxy := range getProvidersProxies(s.providers, false) {
 		if proxy.Name() == name {
-			s.selected = name
-			s.single.Reset()
-			return nil
+			return true
 		}
 	}
-
-	return errors.New("proxy not exist")
+	return false
 }
 
 // Unwrap implements C.ProxyAdapter
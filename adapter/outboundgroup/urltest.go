@@ -28,6 +28,7 @@ type URLTest struct {
 	single     *singledo.Single
 	fastSingle *singledo.Single
 	providers  []provider.ProxyProvider
+	endpoints  *endpoints
 }
 
 func (u *URLTest) Now() string {
@@ -36,7 +37,8 @@ func (u *URLTest) Now() string {
 
 // DialContext implements C.ProxyAdapter
 func (u *URLTest) DialContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (c C.Conn, err error) {
-	c, err = u.fast(true).DialContext(ctx, metadata, u.Base.DialOptions(opts...)...)
+	waitHealthCheck(ctx, u.providers)
+	c, err = u.Unwrap(metadata).DialContext(ctx, metadata, u.Base.DialOptions(opts...)...)
 	if err == nil {
 		c.AppendToChains(u)
 	}
@@ -45,7 +47,8 @@ func (u *URLTest) DialContext(ctx context.Context, metadata *C.Metadata, opts ..
 
 // ListenPacketContext implements C.ProxyAdapter
 func (u *URLTest) ListenPacketContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.PacketConn, error) {
-	pc, err := u.fast(true).ListenPacketContext(ctx, metadata, u.Base.DialOptions(opts...)...)
+	waitHealthCheck(ctx, u.providers)
+	pc, err := u.Unwrap(metadata).ListenPacketContext(ctx, metadata, u.Base.DialOptions(opts...)...)
 	if err == nil {
 		pc.AppendToChains(u)
 	}
@@ -54,7 +57,10 @@ func (u *URLTest) ListenPacketContext(ctx context.Context, metadata *C.Metadata,
 
 // Unwrap implements C.ProxyAdapter
 func (u *URLTest) Unwrap(metadata *C.Metadata) C.Proxy {
-	return u.fast(true)
+	if u.endpoints == nil {
+		return u.fast(true)
+	}
+	return u.endpoints.pick(metadata, u.proxies(false), func() C.Proxy { return u.fast(true) })
 }
 
 func (u *URLTest) proxies(touch bool) []C.Proxy {
@@ -146,6 +152,7 @@ func NewURLTest(option *GroupCommonOption, providers []provider.ProxyProvider, o
 		single:     singledo.NewSingle(defaultGetProxiesDuration),
 		fastSingle: singledo.NewSingle(time.Second * 10),
 		providers:  providers,
+		endpoints:  newEndpoints(option),
 		disableUDP: option.DisableUDP,
 	}
 
@@ -0,0 +1,106 @@
+package outboundgroup
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter"
+	"github.com/Dreamacro/clash/adapter/outbound"
+	"github.com/Dreamacro/clash/adapter/provider"
+	C "github.com/Dreamacro/clash/constant"
+	types "github.com/Dreamacro/clash/constant/provider"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// burstProvider is in the probe burst after power save resumed until release is closed
+type burstProvider struct {
+	*provider.CompatibleProvider
+	release chan struct{}
+}
+
+func (bp *burstProvider) VehicleType() types.VehicleType {
+	return types.File
+}
+
+func (bp *burstProvider) WaitHealthCheck(ctx context.Context) {
+	select {
+	case <-bp.release:
+	case <-ctx.Done():
+	}
+}
+
+func TestURLTest_WaitHealthCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+
+	for _, c := range []struct {
+		name   string
+		config map[string]any
+	}{
+		{"proxies and use", map[string]any{"proxies": []string{"DIRECT"}, "use": []string{"sub"}}},
+		{"filter", map[string]any{"use": []string{"sub"}, "filter": "."}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			direct := adapter.NewProxy(outbound.NewDirect())
+			hc := provider.NewHealthCheck([]C.Proxy{direct}, "", 0, true)
+			pd, err := provider.NewCompatibleProvider("sub", []C.Proxy{direct}, hc)
+			require.NoError(t, err)
+			sub := &burstProvider{CompatibleProvider: pd, release: make(chan struct{})}
+
+			config := map[string]any{"name": "auto", "type": "url-test", "url": server.URL, "interval": 300}
+			for k, v := range c.config {
+				config[k] = v
+			}
+			proxyMap := map[string]C.Proxy{"DIRECT": direct}
+			group, err := ParseProxyGroup(config, proxyMap, map[string]types.ProxyProvider{"sub": sub})
+			require.NoError(t, err)
+
+			dialed := make(chan error, 1)
+			go func() {
+				metadata := &C.Metadata{NetWork: C.TCP, DstIP: net.ParseIP("127.0.0.1"), DstPort: port}
+				conn, err := group.DialContext(context.Background(), metadata)
+				if err == nil {
+					conn.Close()
+				}
+				dialed <- err
+			}()
+
+			// routing waits for the burst of the wrapped provider
+			select {
+			case <-dialed:
+				t.Fatal("dialed before the probe burst is over")
+			case <-time.After(50 * time.Millisecond):
+			}
+			close(sub.release)
+			select {
+			case err := <-dialed:
+				assert.NoError(t, err)
+			case <-time.After(time.Second):
+				t.Fatal("dial still waiting")
+			}
+		})
+	}
+}
@@ -19,36 +19,39 @@ func ParseProxy(mapping map[string]any) (C.Proxy, error) {
 		proxy C.ProxyAdapter
 		err   error
 	)
-	switch proxyType {
-	case "ss":
+	parse, registered := outbound.RegisteredAdapter(proxyType)
+	switch {
+	case registered:
+		proxy, err = parse(mapping)
+	case proxyType == "ss":
 		ssOption := &outbound.ShadowSocksOption{}
 		err = decoder.Decode(mapping, ssOption)
 		if err != nil {
 			break
 		}
 		proxy, err = outbound.NewShadowSocks(*ssOption)
-	case "ssr":
+	case proxyType == "ssr":
 		ssrOption := &outbound.ShadowSocksROption{}
 		err = decoder.Decode(mapping, ssrOption)
 		if err != nil {
 			break
 		}
 		proxy, err = outbound.NewShadowSocksR(*ssrOption)
-	case "socks5":
+	case proxyType == "socks5":
 		socksOption := &outbound.Socks5Option{}
 		err = decoder.Decode(mapping, socksOption)
 		if err != nil {
 			break
 		}
 		proxy = outbound.NewSocks5(*socksOption)
-	case "http":
+	case proxyType == "http":
 		httpOption := &outbound.HttpOption{}
 		err = decoder.Decode(mapping, httpOption)
 		if err != nil {
 			break
 		}
-		proxy = outbound.NewHttp(*httpOption)
-	case "vmess":
+		proxy, err = outbound.NewHttp(*httpOption)
+	case proxyType == "vmess":
 		vmessOption := &outbound.VmessOption{
 			HTTPOpts: outbound.HTTPOptions{
 				Method: "GET",
@@ -60,20 +63,34 @@ func ParseProxy(mapping map[string]any) (C.Proxy, error) {
 			break
 		}
 		proxy, err = outbound.NewVmess(*vmessOption)
-	case "snell":
+	case proxyType == "snell":
 		snellOption := &outbound.SnellOption{}
 		err = decoder.Decode(mapping, snellOption)
 		if err != nil {
 			break
 		}
 		proxy, err = outbound.NewSnell(*snellOption)
-	case "trojan":
+	case proxyType == "trojan":
 		trojanOption := &outbound.TrojanOption{}
 		err = decoder.Decode(mapping, trojanOption)
 		if err != nil {
 			break
 		}
 		proxy, err = outbound.NewTrojan(*trojanOption)
+	case proxyType == "hysteria2":
+		hysteria2Option := &outbound.Hysteria2Option{}
+		err = decoder.Decode(mapping, hysteria2Option)
+		if err != nil {
+			break
+		}
+		proxy, err = outbound.NewHysteria2(*hysteria2Option)
+	case proxyType == "tuic":
+		tuicOption := &outbound.TuicOption{}
+		err = decoder.Decode(mapping, tuicOption)
+		if err != nil {
+			break
+		}
+		proxy, err = outbound.NewTuic(*tuicOption)
 	default:
 		return nil, fmt.Errorf("unsupport proxy type: %s", proxyType)
 	}
@@ -82,5 +99,15 @@ func ParseProxy(mapping map[string]any) (C.Proxy, error) {
 		return nil, err
 	}
 
-	return NewProxy(proxy), nil
+	if r, ok := proxy.(C.DNSResolver); ok {
+		switch r.DNSResolve() {
+		case "", C.DNSResolveRemote, C.DNSResolveLocal:
+		default:
+			return nil, fmt.Errorf("invalid dns-resolve %s", r.DNSResolve())
+		}
+	}
+
+	p := NewProxy(proxy)
+	p.identity = Identity(mapping)
+	return p, nil
 }
@@ -0,0 +1,255 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/common/singledo"
+	C "github.com/Dreamacro/clash/constant"
+	types "github.com/Dreamacro/clash/constant/provider"
+	"github.com/Dreamacro/clash/log"
+)
+
+type identified interface {
+	Identity() string
+}
+
+func identityOf(proxy C.Proxy) string {
+	if p, ok := proxy.(identified); ok {
+		return p.Identity()
+	}
+	return ""
+}
+
+// dedupProxies keeps the first of the proxies with the same identity,
+// aliases maps the names of the dropped ones to the kept ones
+func dedupProxies(proxies []C.Proxy) (kept []C.Proxy, aliases map[string]string) {
+	kept = make([]C.Proxy, 0, len(proxies))
+	aliases = map[string]string{}
+	first := map[string]C.Proxy{}
+	for _, proxy := range proxies {
+		id := identityOf(proxy)
+		if id == "" {
+			kept = append(kept, proxy)
+			continue
+		}
+		if p, ok := first[id]; ok {
+			if proxy.Name() != p.Name() {
+				aliases[proxy.Name()] = p.Name()
+			}
+			continue
+		}
+		first[id] = proxy
+		kept = append(kept, proxy)
+	}
+	return kept, aliases
+}
+
+// renamedProxy is a proxy whose name collides with the one of another provider of a group
+type renamedProxy struct {
+	C.Proxy
+	name string
+}
+
+// Name implements C.ProxyAdapter
+func (r *renamedProxy) Name() string {
+	return r.name
+}
+
+// Identity return the identity of the proxy renamed
+func (r *renamedProxy) Identity() string {
+	return identityOf(r.Proxy)
+}
+
+// MarshalJSON implements C.ProxyAdapter
+func (r *renamedProxy) MarshalJSON() ([]byte, error) {
+	inner, err := r.Proxy.MarshalJSON()
+	if err != nil {
+		return inner, err
+	}
+
+	mapping := map[string]any{}
+	json.Unmarshal(inner, &mapping)
+	mapping["name"] = r.name
+	return json.Marshal(mapping)
+}
+
+// warned holds the collisions already logged, by provider and name
+var warned sync.Map
+
+var (
+	_ types.ProxyProvider     = (*MergedProvider)(nil)
+	_ types.HealthCheckWaiter = (*MergedProvider)(nil)
+)
+
+// MergedProvider joins the providers of a group. A proxy whose name is taken by an earlier
+// provider is renamed with the name of its provider as suffix, so every name of the group is
+// unique. With dedup the proxies of the same server are dropped but the first one
+type MergedProvider struct {
+	name      string
+	providers []types.ProxyProvider
+	dedup     bool
+	single    *singledo.Single
+
+	mux     sync.Mutex
+	renamed map[C.Proxy]*renamedProxy
+	aliases map[string]string
+}
+
+func (mp *MergedProvider) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"name":        mp.Name(),
+		"type":        mp.Type().String(),
+		"vehicleType": mp.VehicleType().String(),
+		"proxies":     mp.Proxies(),
+	})
+}
+
+func (mp *MergedProvider) Name() string {
+	return mp.name
+}
+
+func (mp *MergedProvider) HealthCheck() {
+}
+
+func (mp *MergedProvider) Update() error {
+	return nil
+}
+
+func (mp *MergedProvider) Initial() error {
+	return nil
+}
+
+func (mp *MergedProvider) VehicleType() types.VehicleType {
+	return types.Compatible
+}
+
+func (mp *MergedProvider) Type() types.ProviderType {
+	return types.Proxy
+}
+
+func (mp *MergedProvider) Touch() {
+	for _, provider := range mp.providers {
+		provider.Touch()
+	}
+}
+
+// WaitHealthCheck implements types.HealthCheckWaiter
+func (mp *MergedProvider) WaitHealthCheck(ctx context.Context) {
+	waitHealthCheck(ctx, mp.providers)
+}
+
+func (mp *MergedProvider) Proxies() []C.Proxy {
+	elm, _, _ := mp.single.Do(func() (any, error) {
+		return mp.merge(), nil
+	})
+
+	return elm.([]C.Proxy)
+}
+
+// Alias implements types.ProxyAliases
+func (mp *MergedProvider) Alias(name string) (string, bool) {
+	mp.mux.Lock()
+	alias, ok := mp.aliases[name]
+	mp.mux.Unlock()
+	if ok {
+		return alias, true
+	}
+
+	for _, provider := range mp.providers {
+		if pa, ok := provider.(types.ProxyAliases); ok {
+			if alias, ok := pa.Alias(name); ok {
+				return alias, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (mp *MergedProvider) merge() []C.Proxy {
+	mp.mux.Lock()
+	defer mp.mux.Unlock()
+
+	var (
+		proxies = []C.Proxy{}
+		names   = map[string]bool{}
+		renamed = map[C.Proxy]*renamedProxy{}
+		first   = map[string]C.Proxy{}
+		aliases = map[string]string{}
+	)
+	for _, source := range sourcesOf(mp.providers) {
+		for _, proxy := range source.proxies {
+			if proxy == reject {
+				continue
+			}
+
+			id := identityOf(proxy)
+			if mp.dedup && id != "" {
+				if kept, ok := first[id]; ok {
+					if kept.Name() != proxy.Name() {
+						aliases[proxy.Name()] = kept.Name()
+					}
+					continue
+				}
+			}
+
+			if names[proxy.Name()] {
+				name := proxy.Name() + "@" + source.name
+				if _, loaded := warned.LoadOrStore(source.name+"\x00"+proxy.Name(), struct{}{}); !loaded {
+					log.Warnln("[Provider] %s: %s of %s is taken, renamed as %s", mp.name, proxy.Name(), source.name, name)
+				}
+
+				r, ok := mp.renamed[proxy]
+				if !ok || r.name != name {
+					r = &renamedProxy{Proxy: proxy, name: name}
+				}
+				renamed[proxy] = r
+				proxy = r
+			}
+			names[proxy.Name()] = true
+			if id != "" {
+				first[id] = proxy
+			}
+			proxies = append(proxies, proxy)
+		}
+	}
+	mp.renamed = renamed
+	mp.aliases = aliases
+
+	if len(proxies) == 0 {
+		proxies = append(proxies, reject)
+	}
+	return proxies
+}
+
+type proxySource struct {
+	name    string
+	proxies []C.Proxy
+}
+
+// sourcesOf return the proxies by provider, a FilterableProvider is expanded to
+// the providers it filters so that a collision names the provider of the proxy
+func sourcesOf(providers []types.ProxyProvider) []proxySource {
+	sources := []proxySource{}
+	for _, provider := range providers {
+		if fp, ok := provider.(*FilterableProvider); ok {
+			for _, p := range fp.providers {
+				sources = append(sources, proxySource{p.Name(), fp.filter(p.Proxies())})
+			}
+			continue
+		}
+		sources = append(sources, proxySource{provider.Name(), provider.Proxies()})
+	}
+	return sources
+}
+
+func NewMergedProvider(name string, providers []types.ProxyProvider, dedup bool) *MergedProvider {
+	return &MergedProvider{
+		name:      name,
+		providers: providers,
+		dedup:     dedup,
+		single:    singledo.NewSingle(time.Second * 10),
+	}
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/Dreamacro/clash/adapter"
+	C "github.com/Dreamacro/clash/constant"
+	types "github.com/Dreamacro/clash/constant/provider"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func socksProxy(t *testing.T, name, server string, port int, password string) C.Proxy {
+	proxy, err := adapter.ParseProxy(map[string]any{
+		"name":     name,
+		"type":     "socks5",
+		"server":   server,
+		"port":     port,
+		"username": "user",
+		"password": password,
+	})
+	require.NoError(t, err)
+	return proxy
+}
+
+func compatible(t *testing.T, name string, proxies ...C.Proxy) *CompatibleProvider {
+	pd, err := NewCompatibleProvider(name, proxies, NewHealthCheck(proxies, "", 0, true))
+	require.NoError(t, err)
+	return pd
+}
+
+func names(proxies []C.Proxy) []string {
+	return lo.Map(proxies, func(p C.Proxy, _ int) string { return p.Name() })
+}
+
+func TestDedupProxies(t *testing.T) {
+	proxies, aliases := dedupProxies([]C.Proxy{
+		socksProxy(t, "hk", "1.1.1.1", 1080, "a"),
+		socksProxy(t, "hk-copy", "1.1.1.1", 1080, "a"),
+		// another account of the same server
+		socksProxy(t, "hk-b", "1.1.1.1", 1080, "b"),
+	})
+	assert.Equal(t, []string{"hk", "hk-b"}, names(proxies))
+	assert.Equal(t, map[string]string{"hk-copy": "hk"}, aliases)
+}
+
+func TestMergedProvider(t *testing.T) {
+	sub1 := compatible(t, "sub1",
+		socksProxy(t, "hk", "1.1.1.1", 1080, "a"),
+		socksProxy(t, "jp", "2.2.2.2", 1080, "a"),
+	)
+	sub2 := compatible(t, "sub2",
+		// the same server as hk of sub1
+		socksProxy(t, "HK 01", "1.1.1.1", 1080, "a"),
+		// the same name as jp of sub1 but another server
+		socksProxy(t, "jp", "3.3.3.3", 1080, "a"),
+	)
+
+	merged := NewMergedProvider("auto", []types.ProxyProvider{sub1, sub2}, false)
+	assert.Equal(t, []string{"hk", "jp", "HK 01", "jp@sub2"}, names(merged.Proxies()))
+	// the renamed proxy is kept across merges
+	assert.Same(t, merged.Proxies()[3], merged.merge()[3])
+
+	deduped := NewMergedProvider("auto", []types.ProxyProvider{sub1, sub2}, true)
+	assert.Equal(t, []string{"hk", "jp", "jp@sub2"}, names(deduped.Proxies()))
+	alias, ok := deduped.Alias("HK 01")
+	assert.True(t, ok)
+	assert.Equal(t, "hk", alias)
+}
@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/Dreamacro/clash/component/power"
+	C "github.com/Dreamacro/clash/constant"
 	types "github.com/Dreamacro/clash/constant/provider"
 	"github.com/Dreamacro/clash/log"
 )
@@ -79,7 +81,7 @@ func (f *fetcher) Initial() (any, error) {
 		isLocal = false
 	}
 
-	if f.vehicle.Type() != types.File && !isLocal {
+	if f.vehicle.Type() != types.File && !isLocal && !C.Path.CacheDisabled() {
 		if err := safeWrite(f.vehicle.Path(), buf); err != nil {
 			return nil, err
 		}
@@ -105,7 +107,9 @@ func (f *fetcher) Update() (any, bool, error) {
 	hash := md5.Sum(buf)
 	if bytes.Equal(f.hash[:], hash[:]) {
 		f.updatedAt = &now
-		os.Chtimes(f.vehicle.Path(), now, now)
+		if !C.Path.CacheDisabled() {
+			os.Chtimes(f.vehicle.Path(), now, now)
+		}
 		return nil, true, nil
 	}
 
@@ -114,7 +118,7 @@ func (f *fetcher) Update() (any, bool, error) {
 		return nil, false, err
 	}
 
-	if f.vehicle.Type() != types.File {
+	if f.vehicle.Type() != types.File && !C.Path.CacheDisabled() {
 		if err := safeWrite(f.vehicle.Path(), buf); err != nil {
 			return nil, false, err
 		}
@@ -156,10 +160,30 @@ func (f *fetcher) pullLoop(immediately bool) {
 		update()
 	}
 
+	// an update skipped while power save suspended runs on resume
+	missed := false
+	resumed := make(chan struct{}, 1)
+	unregister := power.Register(power.Hook{Resume: func() {
+		select {
+		case resumed <- struct{}{}:
+		default:
+		}
+	}})
+	defer unregister()
+
 	for {
 		select {
 		case <-f.ticker.C:
+			if power.Suspended() {
+				missed = true
+				continue
+			}
 			update()
+		case <-resumed:
+			if missed {
+				missed = false
+				update()
+			}
 		case <-f.done:
 			f.ticker.Stop()
 			return
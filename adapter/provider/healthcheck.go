@@ -2,11 +2,17 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
+	"sync"
 	"time"
 
+	"github.com/Dreamacro/clash/adapter"
 	"github.com/Dreamacro/clash/common/batch"
+	"github.com/Dreamacro/clash/component/power"
 	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
 
+	"github.com/samber/lo"
 	"go.uber.org/atomic"
 )
 
@@ -24,17 +30,51 @@ type HealthCheck struct {
 	proxies   []C.Proxy
 	interval  uint
 	lazy      bool
+	via       C.Proxy
+	udpTest   *udpTest
 	lastTouch *atomic.Int64
+	bytes     *atomic.Int64
 	done      chan struct{}
+
+	// burst is closed once the probe burst after power save resumed is over
+	burstMux sync.Mutex
+	burst    chan struct{}
+
+	// quarantine is disabled when threshold is 0
+	threshold   int
+	qInterval   time.Duration
+	qMux        sync.Mutex
+	failures    map[string]int
+	quarantined map[string]time.Time // name -> last probe
+}
+
+// viaTester is implemented by proxies that can be probed through another proxy
+type viaTester interface {
+	URLTestVia(ctx context.Context, url string, via C.Proxy) (uint16, uint16, error)
+}
+
+// udpTester is implemented by proxies that can be probed with a udp burst
+type udpTester interface {
+	UDPTest(ctx context.Context, target string, count int, interval time.Duration) (C.UDPHistory, error)
+}
+
+// idleCloser is implemented by proxies keeping their probe connection between the rounds
+type idleCloser interface {
+	CloseIdleConnections()
 }
 
 func (hc *HealthCheck) process() {
 	ticker := time.NewTicker(time.Duration(hc.interval) * time.Second)
+	unregister := power.Register(power.Hook{Suspend: hc.suspend, Resume: hc.resume})
+	defer unregister()
 
 	go hc.check()
 	for {
 		select {
 		case <-ticker.C:
+			if power.Suspended() {
+				continue
+			}
 			now := time.Now().Unix()
 			if !hc.lazy || now-hc.lastTouch.Load() < int64(hc.interval) {
 				hc.check()
@@ -48,6 +88,73 @@ func (hc *HealthCheck) process() {
 
 func (hc *HealthCheck) setProxy(proxies []C.Proxy) {
 	hc.proxies = proxies
+
+	// forget proxies that are gone after an update
+	hc.qMux.Lock()
+	defer hc.qMux.Unlock()
+	names := map[string]bool{}
+	for _, proxy := range proxies {
+		names[proxy.Name()] = true
+	}
+	for name := range hc.quarantined {
+		if !names[name] {
+			delete(hc.quarantined, name)
+		}
+	}
+	for name := range hc.failures {
+		if !names[name] {
+			delete(hc.failures, name)
+		}
+	}
+}
+
+// suspend drops the probe connections kept alive between the rounds if asked to
+func (hc *HealthCheck) suspend() {
+	if !power.SuspendKeepalive() {
+		return
+	}
+	closeIdle(hc.proxies)
+}
+
+// closeIdle drops the probe connections kept alive by proxies
+func closeIdle(proxies []C.Proxy) {
+	for _, proxy := range proxies {
+		if p, ok := proxy.(idleCloser); ok {
+			p.CloseIdleConnections()
+		}
+	}
+}
+
+// resume probes all the proxies at once, waitBurst blocks until the results are in
+func (hc *HealthCheck) resume() {
+	burst := make(chan struct{})
+	hc.burstMux.Lock()
+	hc.burst = burst
+	hc.burstMux.Unlock()
+
+	go func() {
+		hc.check()
+		hc.burstMux.Lock()
+		if hc.burst == burst {
+			hc.burst = nil
+		}
+		hc.burstMux.Unlock()
+		close(burst)
+	}()
+}
+
+func (hc *HealthCheck) waitBurst(ctx context.Context) {
+	hc.burstMux.Lock()
+	burst := hc.burst
+	hc.burstMux.Unlock()
+	if burst == nil {
+		return
+	}
+
+	select {
+	case <-burst:
+	case <-ctx.Done():
+	}
 }
 
 func (hc *HealthCheck) auto() bool {
@@ -62,16 +169,209 @@ func (hc *HealthCheck) check() {
 	b, _ := batch.New(context.Background(), batch.WithConcurrencyNum(10))
 	for _, proxy := range hc.proxies {
 		p := proxy
+		if !hc.due(p.Name()) {
+			continue
+		}
+
 		b.Go(p.Name(), func() (any, error) {
-			ctx, cancel := context.WithTimeout(context.Background(), defaultURLTestTimeout)
+			// the bytes are counted per request, apart from the URLTests of the api
+			ctx, cancel := context.WithTimeout(adapter.WithProbeBytes(context.Background(), hc.bytes), defaultURLTestTimeout)
 			defer cancel()
-			p.URLTest(ctx, hc.url)
+
+			var err error
+			// the via proxy itself is probed directly
+			if vt, isVia := p.(viaTester); isVia && hc.via != nil && p != hc.via {
+				_, _, err = vt.URLTestVia(ctx, hc.url, hc.via)
+			} else {
+				_, _, err = p.URLTest(ctx, hc.url)
+			}
+			if err == nil {
+				hc.checkUDP(p)
+			}
+			hc.record(p.Name(), err == nil)
 			return nil, nil
 		})
 	}
 	b.Wait()
 }
 
+// checkUDP sends the udp-test burst through a proxy supporting udp
+func (hc *HealthCheck) checkUDP(p C.Proxy) {
+	ut, ok := p.(udpTester)
+	if hc.udpTest == nil || !ok || !p.SupportUDP() {
+		return
+	}
+
+	t := hc.udpTest
+	ctx, cancel := context.WithTimeout(adapter.WithProbeBytes(context.Background(), hc.bytes), defaultURLTestTimeout+time.Duration(t.count)*t.interval)
+	defer cancel()
+	if _, err := ut.UDPTest(ctx, t.target, t.count, t.interval); err != nil {
+		log.Debugln("[Provider] udp test of %s: %s", p.Name(), err)
+	}
+}
+
+// SetUDPTest adds a udp burst to the checks of the proxies supporting udp, after their url
+// test succeeded. It must be set before the health check starts.
+func (hc *HealthCheck) SetUDPTest(option UDPTestOption) error {
+	t, err := option.parse()
+	if err != nil {
+		return err
+	}
+	hc.udpTest = t
+	return nil
+}
+
+// SetTestVia dials the probes to the proxy servers through via, so the delays cover
+// the whole chain. It must be set before the health check starts.
+func (hc *HealthCheck) SetTestVia(via C.Proxy) {
+	hc.via = via
+}
+
+// setQuarantine moves a proxy out of group selection after threshold consecutive
+// failed checks, it is then only probed every interval until a check succeeds
+func (hc *HealthCheck) setQuarantine(threshold int, interval time.Duration) {
+	hc.threshold = threshold
+	hc.qInterval = interval
+}
+
+// due reports whether the proxy should be probed in this round
+func (hc *HealthCheck) due(name string) bool {
+	if hc.threshold == 0 {
+		return true
+	}
+
+	hc.qMux.Lock()
+	defer hc.qMux.Unlock()
+	last, ok := hc.quarantined[name]
+	return !ok || time.Since(last) >= hc.qInterval
+}
+
+func (hc *HealthCheck) record(name string, alive bool) {
+	if hc.threshold == 0 {
+		return
+	}
+
+	hc.qMux.Lock()
+	defer hc.qMux.Unlock()
+
+	if alive {
+		delete(hc.failures, name)
+		if _, ok := hc.quarantined[name]; ok {
+			delete(hc.quarantined, name)
+			log.Infoln("[Provider] %s is alive again, restored from quarantine", name)
+		}
+		return
+	}
+
+	if _, ok := hc.quarantined[name]; ok {
+		hc.quarantined[name] = time.Now()
+		return
+	}
+
+	hc.failures[name]++
+	if hc.failures[name] >= hc.threshold {
+		delete(hc.failures, name)
+		hc.quarantined[name] = time.Now()
+		log.Warnln("[Provider] %s failed %d health checks in a row, quarantined", name, hc.threshold)
+	}
+}
+
+// available filters out quarantined proxies, all proxies are kept if none would be left
+func (hc *HealthCheck) available(proxies []C.Proxy) []C.Proxy {
+	if hc.threshold == 0 {
+		return proxies
+	}
+
+	hc.qMux.Lock()
+	defer hc.qMux.Unlock()
+	if len(hc.quarantined) == 0 {
+		return proxies
+	}
+
+	alive := make([]C.Proxy, 0, len(proxies))
+	for _, proxy := range proxies {
+		if _, ok := hc.quarantined[proxy.Name()]; !ok {
+			alive = append(alive, proxy)
+		}
+	}
+	if len(alive) == 0 {
+		return proxies
+	}
+	return alive
+}
+
+func (hc *HealthCheck) isQuarantined(name string) bool {
+	hc.qMux.Lock()
+	defer hc.qMux.Unlock()
+	_, ok := hc.quarantined[name]
+	return ok
+}
+
+// Quarantined return the names of the quarantined proxies
+func (hc *HealthCheck) Quarantined() []string {
+	hc.qMux.Lock()
+	defer hc.qMux.Unlock()
+
+	names := make([]string, 0, len(hc.quarantined))
+	for name := range hc.quarantined {
+		names = append(names, name)
+	}
+	return names
+}
+
+// quarantinedProxy marshals a proxy with the quarantined flag
+type quarantinedProxy struct {
+	C.Proxy
+}
+
+func (q quarantinedProxy) MarshalJSON() ([]byte, error) {
+	buf, err := json.Marshal(q.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	mapping := map[string]any{}
+	if err := json.Unmarshal(buf, &mapping); err != nil {
+		return nil, err
+	}
+	mapping["quarantined"] = true
+	return json.Marshal(mapping)
+}
+
+// marshalProxies lists the quarantined proxies too, with the quarantined flag
+func marshalProxies(proxies []C.Proxy, quarantined func(name string) bool) []any {
+	return lo.Map(proxies, func(proxy C.Proxy, _ int) any {
+		if quarantined(proxy.Name()) {
+			return quarantinedProxy{proxy}
+		}
+		return proxy
+	})
+}
+
+// Unquarantine restores all quarantined proxies
+func (hc *HealthCheck) Unquarantine() {
+	hc.qMux.Lock()
+	defer hc.qMux.Unlock()
+
+	hc.failures = map[string]int{}
+	hc.quarantined = map[string]time.Time{}
+}
+
+func (hc *HealthCheck) MarshalJSON() ([]byte, error) {
+	mapping := map[string]any{
+		"url":      hc.url,
+		"interval": hc.interval,
+		"bytes":    hc.bytes.Load(),
+	}
+	if t := hc.udpTest; t != nil {
+		mapping["udpTest"] = map[string]any{
+			"target":   t.target,
+			"count":    t.count,
+			"interval": t.interval.Milliseconds(),
+		}
+	}
+	return json.Marshal(mapping)
+}
+
 func (hc *HealthCheck) close() {
 	hc.done <- struct{}{}
 }
@@ -83,6 +383,10 @@ func NewHealthCheck(proxies []C.Proxy, url string, interval uint, lazy bool) *He
 		interval:  interval,
 		lazy:      lazy,
 		lastTouch: atomic.NewInt64(0),
+		bytes:     atomic.NewInt64(0),
 		done:      make(chan struct{}, 1),
+
+		failures:    map[string]int{},
+		quarantined: map[string]time.Time{},
 	}
 }
@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+	types "github.com/Dreamacro/clash/constant/provider"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthCheck_Quarantine(t *testing.T) {
+	hk := socksProxy(t, "hk", "1.1.1.1", 1080, "a")
+	jp := socksProxy(t, "jp", "2.2.2.2", 1080, "a")
+	proxies := []C.Proxy{hk, jp}
+	hc := NewHealthCheck(proxies, "", 0, true)
+	hc.setQuarantine(2, time.Hour)
+
+	// the failures have to be consecutive
+	hc.record("hk", false)
+	hc.record("hk", true)
+	hc.record("hk", false)
+	assert.False(t, hc.isQuarantined("hk"))
+	assert.Equal(t, proxies, hc.available(proxies))
+
+	hc.record("hk", false)
+	assert.True(t, hc.isQuarantined("hk"))
+	assert.Equal(t, []string{"hk"}, hc.Quarantined())
+	assert.Equal(t, []C.Proxy{jp}, hc.available(proxies))
+	// probed again after the quarantine interval only
+	assert.False(t, hc.due("hk"))
+	assert.True(t, hc.due("jp"))
+
+	// the proxies are all kept when none would be left
+	hc.record("jp", false)
+	hc.record("jp", false)
+	assert.Equal(t, proxies, hc.available(proxies))
+
+	// released by a successful check
+	hc.record("jp", true)
+	assert.False(t, hc.isQuarantined("jp"))
+	assert.Equal(t, []C.Proxy{jp}, hc.available(proxies))
+
+	hc.Unquarantine()
+	assert.Empty(t, hc.Quarantined())
+	assert.True(t, hc.due("hk"))
+
+	// gone after an update
+	hc.record("hk", false)
+	hc.record("hk", false)
+	hc.setProxy([]C.Proxy{jp})
+	assert.False(t, hc.isQuarantined("hk"))
+}
+
+func TestHealthCheck_QuarantineDisabled(t *testing.T) {
+	hc := NewHealthCheck(nil, "", 0, true)
+	for i := 0; i < 10; i++ {
+		hc.record("hk", false)
+	}
+	assert.False(t, hc.isQuarantined("hk"))
+	assert.True(t, hc.due("hk"))
+}
+
+func TestProvider_MarshalQuarantined(t *testing.T) {
+	hk := socksProxy(t, "hk", "1.1.1.1", 1080, "a")
+	jp := socksProxy(t, "jp", "2.2.2.2", 1080, "a")
+	hc := NewHealthCheck([]C.Proxy{hk, jp}, "", 0, true)
+	hc.setQuarantine(1, time.Hour)
+	pd, err := NewCompatibleProvider("p", []C.Proxy{hk, jp}, hc)
+	require.NoError(t, err)
+	hc.record("hk", false)
+
+	fp := NewFilterableProvider("f", []types.ProxyProvider{pd}, regexp.MustCompile("."))
+	for _, provider := range []types.ProxyProvider{pd, fp} {
+		assert.Equal(t, []C.Proxy{jp}, provider.Proxies())
+		assert.Equal(t, []C.Proxy{hk, jp}, provider.(types.ProxyQuarantine).AllProxies())
+
+		buf, err := json.Marshal(provider)
+		require.NoError(t, err)
+		var body struct {
+			Proxies []struct {
+				Name        string `json:"name"`
+				Type        string `json:"type"`
+				Quarantined bool   `json:"quarantined"`
+			} `json:"proxies"`
+		}
+		require.NoError(t, json.Unmarshal(buf, &body))
+		require.Len(t, body.Proxies, 2, provider.Name())
+		assert.Equal(t, "hk", body.Proxies[0].Name)
+		assert.Equal(t, "Socks5", body.Proxies[0].Type)
+		assert.True(t, body.Proxies[0].Quarantined)
+		assert.False(t, body.Proxies[1].Quarantined)
+	}
+
+	fp.Unquarantine()
+	assert.False(t, pd.IsQuarantined("hk"))
+}
@@ -3,16 +3,18 @@ package provider
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"time"
 
 	"github.com/Dreamacro/clash/common/structure"
+	"github.com/Dreamacro/clash/component/fetch"
 	C "github.com/Dreamacro/clash/constant"
 	types "github.com/Dreamacro/clash/constant/provider"
 )
 
 var (
 	errVehicleType = errors.New("unsupport vehicle type")
-	errSubPath     = errors.New("path is not subpath of home directory")
+	errSubPath     = errors.New("path is not subpath of state directory")
 )
 
 type healthCheckSchema struct {
@@ -20,6 +22,13 @@ type healthCheckSchema struct {
 	URL      string `provider:"url"`
 	Interval int    `provider:"interval"`
 	Lazy     bool   `provider:"lazy,omitempty"`
+
+	QuarantineThreshold int `provider:"quarantine-threshold,omitempty"`
+	QuarantineInterval  int `provider:"quarantine-interval,omitempty"`
+
+	TestVia string `provider:"test-via,omitempty"`
+
+	UDPTest UDPTestOption `provider:"udp-test,omitempty"`
 }
 
 type proxyProviderSchema struct {
@@ -28,10 +37,20 @@ type proxyProviderSchema struct {
 	URL         string            `provider:"url,omitempty"`
 	Interval    int               `provider:"interval,omitempty"`
 	Filter      string            `provider:"filter,omitempty"`
+	Dedup       bool              `provider:"dedup,omitempty"`
+	FetchProxy  string            `provider:"fetch-proxy,omitempty"`
 	HealthCheck healthCheckSchema `provider:"health-check,omitempty"`
 }
 
-func ParseProxyProvider(name string, mapping map[string]any) (types.ProxyProvider, error) {
+func parseFetchProxy(raw string) (*url.URL, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	return fetch.ParseProxy(raw)
+}
+
+// ParseProxyProvider parses a provider, proxies are the proxies and groups the health check can be tested via
+func ParseProxyProvider(name string, mapping map[string]any, proxies map[string]C.Proxy) (types.ProxyProvider, error) {
 	decoder := structure.NewDecoder(structure.Option{TagName: "provider", WeaklyTypedInput: true})
 
 	schema := &proxyProviderSchema{
@@ -48,6 +67,28 @@ func ParseProxyProvider(name string, mapping map[string]any) (types.ProxyProvide
 		hcInterval = uint(schema.HealthCheck.Interval)
 	}
 	hc := NewHealthCheck([]C.Proxy{}, schema.HealthCheck.URL, hcInterval, schema.HealthCheck.Lazy)
+	if threshold := schema.HealthCheck.QuarantineThreshold; threshold > 0 {
+		// quarantined proxies are probed every 10 rounds by default
+		qInterval := time.Duration(schema.HealthCheck.QuarantineInterval) * time.Second
+		if qInterval <= 0 {
+			qInterval = 10 * time.Duration(hcInterval) * time.Second
+		}
+		hc.setQuarantine(threshold, qInterval)
+	}
+	if via := schema.HealthCheck.TestVia; via != "" {
+		// a group is parsed before the providers it doesn't use
+		p, ok := proxies[via]
+		if !ok {
+			return nil, fmt.Errorf("health-check test-via %s is not a proxy or group", via)
+		}
+		hc.SetTestVia(p)
+	}
+
+	if schema.HealthCheck.UDPTest.Target != "" {
+		if err := hc.SetUDPTest(schema.HealthCheck.UDPTest); err != nil {
+			return nil, fmt.Errorf("health-check %w", err)
+		}
+	}
 
 	path := C.Path.Resolve(schema.Path)
 
@@ -56,15 +97,75 @@ func ParseProxyProvider(name string, mapping map[string]any) (types.ProxyProvide
 	case "file":
 		vehicle = NewFileVehicle(path)
 	case "http":
-		if !C.Path.IsSubPath(path) {
+		// the cache of an http provider is part of the state
+		path = C.Path.ResolveState(schema.Path)
+		if !C.Path.IsStateSubPath(path) {
 			return nil, fmt.Errorf("%w: %s", errSubPath, path)
 		}
-		vehicle = NewHTTPVehicle(schema.URL, path)
+		fetchProxy, err := parseFetchProxy(schema.FetchProxy)
+		if err != nil {
+			return nil, err
+		}
+		vehicle = NewHTTPVehicle(schema.URL, path, fetchProxy)
 	default:
 		return nil, fmt.Errorf("%w: %s", errVehicleType, schema.Type)
 	}
 
 	interval := time.Duration(uint(schema.Interval)) * time.Second
 	filter := schema.Filter
-	return NewProxySetProvider(name, interval, filter, vehicle, hc)
+	return NewProxySetProvider(name, interval, filter, schema.Dedup, vehicle, hc)
+}
+
+type ruleProviderSchema struct {
+	Type       string `provider:"type"`
+	Behavior   string `provider:"behavior"`
+	Path       string `provider:"path"`
+	URL        string `provider:"url,omitempty"`
+	Interval   int    `provider:"interval,omitempty"`
+	FetchProxy string `provider:"fetch-proxy,omitempty"`
+}
+
+func ParseRuleProvider(name string, mapping map[string]any) (types.RuleProvider, error) {
+	decoder := structure.NewDecoder(structure.Option{TagName: "provider", WeaklyTypedInput: true})
+
+	schema := &ruleProviderSchema{}
+	if err := decoder.Decode(mapping, schema); err != nil {
+		return nil, err
+	}
+
+	var behavior types.RuleType
+	switch schema.Behavior {
+	case "domain":
+		behavior = types.Domain
+	case "ipcidr":
+		behavior = types.IPCIDR
+	case "classical", "logical":
+		behavior = types.Classical
+	default:
+		return nil, fmt.Errorf("unsupport behavior type: %s", schema.Behavior)
+	}
+
+	path := C.Path.Resolve(schema.Path)
+
+	var vehicle types.Vehicle
+	switch schema.Type {
+	case "file":
+		vehicle = NewFileVehicle(path)
+	case "http":
+		// the cache of an http provider is part of the state
+		path = C.Path.ResolveState(schema.Path)
+		if !C.Path.IsStateSubPath(path) {
+			return nil, fmt.Errorf("%w: %s", errSubPath, path)
+		}
+		fetchProxy, err := parseFetchProxy(schema.FetchProxy)
+		if err != nil {
+			return nil, err
+		}
+		vehicle = NewHTTPVehicle(schema.URL, path, fetchProxy)
+	default:
+		return nil, fmt.Errorf("%w: %s", errVehicleType, schema.Type)
+	}
+
+	interval := time.Duration(uint(schema.Interval)) * time.Second
+	return NewRuleSetProvider(name, behavior, interval, vehicle), nil
 }
@@ -1,6 +1,7 @@
 package provider
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,8 +14,10 @@ import (
 	"github.com/Dreamacro/clash/common/singledo"
 	C "github.com/Dreamacro/clash/constant"
 	types "github.com/Dreamacro/clash/constant/provider"
+	"github.com/Dreamacro/clash/tunnel/statistic"
 
 	"github.com/samber/lo"
+	"go.uber.org/atomic"
 	"gopkg.in/yaml.v3"
 )
 
@@ -25,7 +28,7 @@ const (
 )
 
 type ProxySchema struct {
-	Proxies []map[string]any `yaml:"proxies"`
+	Proxies []any `yaml:"proxies"`
 }
 
 // for auto gc
@@ -37,16 +40,30 @@ type proxySetProvider struct {
 	*fetcher
 	proxies     []C.Proxy
 	healthCheck *HealthCheck
+	dedup       bool
+	aliases     *atomic.Pointer[map[string]string]
 }
 
 func (pp *proxySetProvider) MarshalJSON() ([]byte, error) {
-	return json.Marshal(map[string]any{
+	mapping := map[string]any{
 		"name":        pp.Name(),
 		"type":        pp.Type().String(),
 		"vehicleType": pp.VehicleType().String(),
-		"proxies":     pp.Proxies(),
+		"proxies":     marshalProxies(pp.proxies, pp.healthCheck.isQuarantined),
+		"quarantined": pp.healthCheck.Quarantined(),
 		"updatedAt":   pp.updatedAt,
-	})
+		"healthCheck": pp.healthCheck,
+	}
+	if pp.dedup {
+		mapping["aliases"] = *pp.aliases.Load()
+	}
+	return json.Marshal(mapping)
+}
+
+// Alias implements types.ProxyAliases
+func (pp *proxySetProvider) Alias(name string) (string, bool) {
+	alias, ok := (*pp.aliases.Load())[name]
+	return alias, ok
 }
 
 func (pp *proxySetProvider) Name() string {
@@ -80,14 +97,52 @@ func (pp *proxySetProvider) Type() types.ProviderType {
 }
 
 func (pp *proxySetProvider) Proxies() []C.Proxy {
+	return pp.healthCheck.available(pp.proxies)
+}
+
+// AllProxies implements types.ProxyQuarantine
+func (pp *proxySetProvider) AllProxies() []C.Proxy {
 	return pp.proxies
 }
 
+// IsQuarantined implements types.ProxyQuarantine
+func (pp *proxySetProvider) IsQuarantined(name string) bool {
+	return pp.healthCheck.isQuarantined(name)
+}
+
+func (pp *proxySetProvider) Unquarantine() {
+	pp.healthCheck.Unquarantine()
+}
+
 func (pp *proxySetProvider) Touch() {
 	pp.healthCheck.touch()
 }
 
+func (pp *proxySetProvider) WaitHealthCheck(ctx context.Context) {
+	pp.healthCheck.waitBurst(ctx)
+}
+
 func (pp *proxySetProvider) setProxies(proxies []C.Proxy) {
+	if pp.dedup {
+		var aliases map[string]string
+		proxies, aliases = dedupProxies(proxies)
+		pp.aliases.Store(&aliases)
+	}
+
+	removed := []string{}
+	dropped := []C.Proxy{}
+	for _, old := range pp.proxies {
+		if !lo.ContainsBy(proxies, func(p C.Proxy) bool { return p.Name() == old.Name() }) {
+			removed = append(removed, old.Name())
+		}
+		// an updated proxy of the same name is a new adapter with its own probe client
+		if !lo.Contains(proxies, old) {
+			dropped = append(dropped, old)
+		}
+	}
+	statistic.DefaultManager.CloseRemoved(removed, statistic.DefaultClosePolicy())
+	closeIdle(dropped)
+
 	pp.proxies = proxies
 	pp.healthCheck.setProxy(proxies)
 	if pp.healthCheck.auto() {
@@ -98,9 +153,12 @@ func (pp *proxySetProvider) setProxies(proxies []C.Proxy) {
 func stopProxyProvider(pd *ProxySetProvider) {
 	pd.healthCheck.close()
 	pd.fetcher.Destroy()
+	closeIdle(pd.proxies)
 }
 
-func NewProxySetProvider(name string, interval time.Duration, filter string, vehicle types.Vehicle, hc *HealthCheck) (*ProxySetProvider, error) {
+// NewProxySetProvider return a provider of the proxies of vehicle, with dedup the proxies of
+// the same server are dropped but the first one, see adapter.Identity
+func NewProxySetProvider(name string, interval time.Duration, filter string, dedup bool, vehicle types.Vehicle, hc *HealthCheck) (*ProxySetProvider, error) {
 	filterReg, err := regexp.Compile(filter)
 	if err != nil {
 		return nil, fmt.Errorf("invalid filter regex: %w", err)
@@ -113,6 +171,8 @@ func NewProxySetProvider(name string, interval time.Duration, filter string, veh
 	pd := &proxySetProvider{
 		proxies:     []C.Proxy{},
 		healthCheck: hc,
+		dedup:       dedup,
+		aliases:     atomic.NewPointer(&map[string]string{}),
 	}
 
 	onUpdate := func(elm any) {
@@ -132,7 +192,11 @@ func NewProxySetProvider(name string, interval time.Duration, filter string, veh
 		}
 
 		proxies := []C.Proxy{}
-		for idx, mapping := range schema.Proxies {
+		for idx, item := range schema.Proxies {
+			mapping, err := adapter.ProxyMapping(item)
+			if err != nil {
+				return nil, fmt.Errorf("proxy %d error: %w", idx, err)
+			}
 			if name, ok := mapping["name"].(string); ok && len(filter) > 0 && !filterReg.MatchString(name) {
 				continue
 			}
@@ -177,7 +241,9 @@ func (cp *compatibleProvider) MarshalJSON() ([]byte, error) {
 		"name":        cp.Name(),
 		"type":        cp.Type().String(),
 		"vehicleType": cp.VehicleType().String(),
-		"proxies":     cp.Proxies(),
+		"proxies":     marshalProxies(cp.proxies, cp.healthCheck.isQuarantined),
+		"quarantined": cp.healthCheck.Quarantined(),
+		"healthCheck": cp.healthCheck,
 	})
 }
 
@@ -206,13 +272,31 @@ func (cp *compatibleProvider) Type() types.ProviderType {
 }
 
 func (cp *compatibleProvider) Proxies() []C.Proxy {
+	return cp.healthCheck.available(cp.proxies)
+}
+
+// AllProxies implements types.ProxyQuarantine
+func (cp *compatibleProvider) AllProxies() []C.Proxy {
 	return cp.proxies
 }
 
+// IsQuarantined implements types.ProxyQuarantine
+func (cp *compatibleProvider) IsQuarantined(name string) bool {
+	return cp.healthCheck.isQuarantined(name)
+}
+
+func (cp *compatibleProvider) Unquarantine() {
+	cp.healthCheck.Unquarantine()
+}
+
 func (cp *compatibleProvider) Touch() {
 	cp.healthCheck.touch()
 }
 
+func (cp *compatibleProvider) WaitHealthCheck(ctx context.Context) {
+	cp.healthCheck.waitBurst(ctx)
+}
+
 func stopCompatibleProvider(pd *CompatibleProvider) {
 	pd.healthCheck.close()
 }
@@ -237,7 +321,13 @@ func NewCompatibleProvider(name string, proxies []C.Proxy, hc *HealthCheck) (*Co
 	return wrapper, nil
 }
 
-var _ types.ProxyProvider = (*FilterableProvider)(nil)
+var (
+	_ types.ProxyProvider     = (*FilterableProvider)(nil)
+	_ types.ProxyQuarantine   = (*FilterableProvider)(nil)
+	_ types.HealthCheckWaiter = (*FilterableProvider)(nil)
+	_ types.ProxyQuarantine   = (*ProxySetProvider)(nil)
+	_ types.ProxyQuarantine   = (*CompatibleProvider)(nil)
+)
 
 type FilterableProvider struct {
 	name      string
@@ -251,7 +341,7 @@ func (fp *FilterableProvider) MarshalJSON() ([]byte, error) {
 		"name":        fp.Name(),
 		"type":        fp.Type().String(),
 		"vehicleType": fp.VehicleType().String(),
-		"proxies":     fp.Proxies(),
+		"proxies":     marshalProxies(fp.AllProxies(), fp.IsQuarantined),
 	})
 }
 
@@ -283,11 +373,7 @@ func (fp *FilterableProvider) Proxies() []C.Proxy {
 		proxies := lo.FlatMap(
 			fp.providers,
 			func(item types.ProxyProvider, _ int) []C.Proxy {
-				return lo.Filter(
-					item.Proxies(),
-					func(item C.Proxy, _ int) bool {
-						return fp.filterReg.MatchString(item.Name())
-					})
+				return fp.filter(item.Proxies())
 			})
 
 		if len(proxies) == 0 {
@@ -299,6 +385,67 @@ func (fp *FilterableProvider) Proxies() []C.Proxy {
 	return elm.([]C.Proxy)
 }
 
+// AllProxies implements types.ProxyQuarantine
+func (fp *FilterableProvider) AllProxies() []C.Proxy {
+	return lo.FlatMap(fp.providers, func(item types.ProxyProvider, _ int) []C.Proxy {
+		if pq, ok := item.(types.ProxyQuarantine); ok {
+			return fp.filter(pq.AllProxies())
+		}
+		return fp.filter(item.Proxies())
+	})
+}
+
+// IsQuarantined implements types.ProxyQuarantine
+func (fp *FilterableProvider) IsQuarantined(name string) bool {
+	return lo.SomeBy(fp.providers, func(item types.ProxyProvider) bool {
+		pq, ok := item.(types.ProxyQuarantine)
+		return ok && pq.IsQuarantined(name)
+	})
+}
+
+// Unquarantine implements types.ProxyQuarantine
+func (fp *FilterableProvider) Unquarantine() {
+	for _, provider := range fp.providers {
+		if pq, ok := provider.(types.ProxyQuarantine); ok {
+			pq.Unquarantine()
+		}
+	}
+}
+
+func (fp *FilterableProvider) filter(proxies []C.Proxy) []C.Proxy {
+	return lo.Filter(
+		proxies,
+		func(item C.Proxy, _ int) bool {
+			return fp.filterReg.MatchString(item.Name())
+		})
+}
+
+// Alias implements types.ProxyAliases
+func (fp *FilterableProvider) Alias(name string) (string, bool) {
+	for _, provider := range fp.providers {
+		if pa, ok := provider.(types.ProxyAliases); ok {
+			if alias, ok := pa.Alias(name); ok {
+				return alias, true
+			}
+		}
+	}
+	return "", false
+}
+
+// WaitHealthCheck implements types.HealthCheckWaiter
+func (fp *FilterableProvider) WaitHealthCheck(ctx context.Context) {
+	waitHealthCheck(ctx, fp.providers)
+}
+
+// waitHealthCheck waits for the probe bursts of the providers wrapped by a group provider
+func waitHealthCheck(ctx context.Context, providers []types.ProxyProvider) {
+	for _, provider := range providers {
+		if w, ok := provider.(types.HealthCheckWaiter); ok {
+			w.WaitHealthCheck(ctx)
+		}
+	}
+}
+
 func (fp *FilterableProvider) Touch() {
 	for _, provider := range fp.providers {
 		provider.Touch()
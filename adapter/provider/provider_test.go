@@ -0,0 +1,40 @@
+package provider
+
+import (
+	"testing"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+)
+
+type idleProxy struct {
+	C.Proxy
+	closed int
+}
+
+func (p *idleProxy) CloseIdleConnections() {
+	p.closed++
+}
+
+func TestProxySetProvider_CloseIdle(t *testing.T) {
+	hk := &idleProxy{Proxy: socksProxy(t, "hk", "1.1.1.1", 1080, "a")}
+	jp := &idleProxy{Proxy: socksProxy(t, "jp", "2.2.2.2", 1080, "a")}
+	pp := &proxySetProvider{
+		proxies:     []C.Proxy{hk, jp},
+		healthCheck: NewHealthCheck([]C.Proxy{hk, jp}, "", 0, true),
+		aliases:     atomic.NewPointer[map[string]string](nil),
+	}
+
+	// jp is kept, hk is dropped for a new adapter of the same name
+	updated := &idleProxy{Proxy: socksProxy(t, "hk", "3.3.3.3", 1080, "a")}
+	pp.setProxies([]C.Proxy{updated, jp})
+	assert.Equal(t, 1, hk.closed)
+	assert.Equal(t, 0, jp.closed)
+	assert.Equal(t, 0, updated.closed)
+
+	pp.setProxies([]C.Proxy{updated})
+	assert.Equal(t, 1, jp.closed)
+	assert.Equal(t, 0, updated.closed)
+}
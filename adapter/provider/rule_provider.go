@@ -0,0 +1,374 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/Dreamacro/clash/component/trie"
+	C "github.com/Dreamacro/clash/constant"
+	types "github.com/Dreamacro/clash/constant/provider"
+	"github.com/Dreamacro/clash/log"
+	R "github.com/Dreamacro/clash/rule"
+
+	"go.uber.org/atomic"
+	"gopkg.in/yaml.v3"
+)
+
+// at most this many failed lines are listed in the update report
+const maxReportedLines = 10
+
+type RuleSchema struct {
+	Payload []yaml.Node `yaml:"payload"`
+}
+
+type ruleLine struct {
+	line int
+	text string
+}
+
+type ruleStrategy interface {
+	Match(metadata *C.Metadata) bool
+	Lookup(metadata *C.Metadata) (string, bool)
+	Payload() []string
+	Count() int
+	ShouldResolveIP() bool
+	ShouldFindProcess() bool
+}
+
+// for auto gc
+type RuleSetProvider struct {
+	*ruleSetProvider
+}
+
+type ruleSetProvider struct {
+	*fetcher
+	behavior types.RuleType
+	strategy atomic.Pointer[ruleStrategy]
+}
+
+func (rp *ruleSetProvider) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"name":        rp.Name(),
+		"type":        rp.Type().String(),
+		"vehicleType": rp.VehicleType().String(),
+		"behavior":    rp.behavior.String(),
+		"ruleCount":   rp.load().Count(),
+		"updatedAt":   rp.updatedAt,
+	})
+}
+
+func (rp *ruleSetProvider) Type() types.ProviderType {
+	return types.Rule
+}
+
+func (rp *ruleSetProvider) Behavior() types.RuleType {
+	return rp.behavior
+}
+
+func (rp *ruleSetProvider) Initial() error {
+	elm, err := rp.fetcher.Initial()
+	if err != nil {
+		return err
+	}
+
+	rp.onUpdate(elm)
+	return nil
+}
+
+func (rp *ruleSetProvider) Update() error {
+	elm, same, err := rp.fetcher.Update()
+	if err == nil && !same {
+		rp.onUpdate(elm)
+	}
+	return err
+}
+
+func (rp *ruleSetProvider) Match(metadata *C.Metadata) bool {
+	return rp.load().Match(metadata)
+}
+
+func (rp *ruleSetProvider) ShouldResolveIP() bool {
+	return rp.load().ShouldResolveIP()
+}
+
+// Lookup return the entry matching the metadata
+func (rp *ruleSetProvider) Lookup(metadata *C.Metadata) (string, bool) {
+	return rp.load().Lookup(metadata)
+}
+
+// Payload return the entries in the order of the source
+func (rp *ruleSetProvider) Payload() []string {
+	return rp.load().Payload()
+}
+
+func (rp *ruleSetProvider) ShouldFindProcess() bool {
+	return rp.load().ShouldFindProcess()
+}
+
+func (rp *ruleSetProvider) AsRule(adapter string) C.Rule {
+	return R.NewRuleSet(rp, adapter, false)
+}
+
+func (rp *ruleSetProvider) load() ruleStrategy {
+	if s := rp.strategy.Load(); s != nil {
+		return *s
+	}
+	return emptyStrategy{}
+}
+
+func stopRuleProvider(pd *RuleSetProvider) {
+	pd.fetcher.Destroy()
+}
+
+func NewRuleSetProvider(name string, behavior types.RuleType, interval time.Duration, vehicle types.Vehicle) *RuleSetProvider {
+	rp := &ruleSetProvider{
+		behavior: behavior,
+	}
+
+	onUpdate := func(elm any) {
+		strategy := elm.(ruleStrategy)
+		rp.strategy.Store(&strategy)
+	}
+
+	parse := func(buf []byte) (any, error) {
+		return parseRuleSet(name, behavior, buf)
+	}
+
+	rp.fetcher = newFetcher(name, interval, vehicle, parse, onUpdate)
+
+	wrapper := &RuleSetProvider{rp}
+	runtime.SetFinalizer(wrapper, stopRuleProvider)
+	return wrapper
+}
+
+func parseRuleSet(name string, behavior types.RuleType, buf []byte) (ruleStrategy, error) {
+	var (
+		strategy interface {
+			ruleStrategy
+			insert(text string) error
+		}
+		failed []string
+	)
+
+	switch behavior {
+	case types.Domain:
+		strategy = &domainStrategy{trie: trie.New()}
+	case types.IPCIDR:
+		strategy = &ipcidrStrategy{trie: trie.NewIPTrie()}
+	default:
+		strategy = &classicalStrategy{}
+	}
+
+	lines := splitRuleLines(buf)
+	for _, l := range lines {
+		if err := strategy.insert(l.text); err != nil {
+			failed = append(failed, fmt.Sprintf("line %d [%s]: %s", l.line, l.text, err.Error()))
+		}
+	}
+
+	if len(failed) == len(lines) && len(lines) != 0 {
+		return nil, fmt.Errorf("no valid rule, %s", failed[0])
+	}
+
+	// reported once per update instead of once per line
+	if len(failed) != 0 {
+		report := failed
+		if len(report) > maxReportedLines {
+			report = append(report[:maxReportedLines:maxReportedLines], fmt.Sprintf("and %d more", len(failed)-maxReportedLines))
+		}
+		log.Warnln("[Provider] %s: %d lines failed: %s", name, len(failed), strings.Join(report, "; "))
+	}
+
+	return strategy, nil
+}
+
+// splitRuleLines accepts a yaml document with a payload list or plain text
+// with one item per line, empty lines and # comments are skipped
+func splitRuleLines(buf []byte) []ruleLine {
+	lines := []ruleLine{}
+
+	schema := &RuleSchema{}
+	if err := yaml.Unmarshal(buf, schema); err == nil && schema.Payload != nil {
+		for _, node := range schema.Payload {
+			if text := strings.TrimSpace(node.Value); text != "" {
+				lines = append(lines, ruleLine{line: node.Line, text: text})
+			}
+		}
+		return lines
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for n := 1; scanner.Scan(); n++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		lines = append(lines, ruleLine{line: n, text: text})
+	}
+	return lines
+}
+
+type emptyStrategy struct{}
+
+func (emptyStrategy) Match(*C.Metadata) bool            { return false }
+func (emptyStrategy) Lookup(*C.Metadata) (string, bool) { return "", false }
+func (emptyStrategy) Payload() []string                 { return nil }
+func (emptyStrategy) Count() int                        { return 0 }
+func (emptyStrategy) ShouldResolveIP() bool             { return false }
+func (emptyStrategy) ShouldFindProcess() bool           { return false }
+
+// the tries keep the source line as data, so a lookup can tell which entry matched
+type domainStrategy struct {
+	trie    *trie.DomainTrie
+	payload []string
+}
+
+func (ds *domainStrategy) Match(metadata *C.Metadata) bool {
+	return metadata.Host != "" && ds.trie.Search(metadata.Host) != nil
+}
+
+func (ds *domainStrategy) Lookup(metadata *C.Metadata) (string, bool) {
+	if metadata.Host == "" {
+		return "", false
+	}
+	if node := ds.trie.Search(metadata.Host); node != nil {
+		return node.Data.(string), true
+	}
+	return "", false
+}
+
+func (ds *domainStrategy) Payload() []string {
+	return ds.payload
+}
+
+func (ds *domainStrategy) Count() int {
+	return len(ds.payload)
+}
+
+func (ds *domainStrategy) ShouldResolveIP() bool {
+	return false
+}
+
+func (ds *domainStrategy) ShouldFindProcess() bool {
+	return false
+}
+
+func (ds *domainStrategy) insert(text string) error {
+	if err := ds.trie.Insert(text, text); err != nil {
+		return err
+	}
+	ds.payload = append(ds.payload, text)
+	return nil
+}
+
+type ipcidrStrategy struct {
+	trie    *trie.IPTrie
+	payload []string
+}
+
+func (is *ipcidrStrategy) Match(metadata *C.Metadata) bool {
+	addr, ok := netip.AddrFromSlice(metadata.DstIP)
+	return ok && is.trie.Search(addr.Unmap()) != nil
+}
+
+func (is *ipcidrStrategy) Lookup(metadata *C.Metadata) (string, bool) {
+	addr, ok := netip.AddrFromSlice(metadata.DstIP)
+	if !ok {
+		return "", false
+	}
+	if data := is.trie.Search(addr.Unmap()); data != nil {
+		return data.(string), true
+	}
+	return "", false
+}
+
+func (is *ipcidrStrategy) Payload() []string {
+	return is.payload
+}
+
+func (is *ipcidrStrategy) Count() int {
+	return is.trie.Size()
+}
+
+func (is *ipcidrStrategy) ShouldResolveIP() bool {
+	return true
+}
+
+func (is *ipcidrStrategy) ShouldFindProcess() bool {
+	return false
+}
+
+func (is *ipcidrStrategy) insert(text string) error {
+	prefix, err := netip.ParsePrefix(text)
+	if err != nil {
+		return err
+	}
+	// a duplicated prefix keeps the first line
+	if is.trie.Insert(prefix, text) {
+		is.payload = append(is.payload, text)
+	}
+	return nil
+}
+
+// classicalStrategy accepts the full rule grammar without the target,
+// lines are parsed by the main rule parser so they never diverge
+type classicalStrategy struct {
+	rules       []C.Rule
+	payload     []string
+	resolveIP   bool
+	findProcess bool
+}
+
+func (cs *classicalStrategy) Match(metadata *C.Metadata) bool {
+	for _, rule := range cs.rules {
+		if rule.Match(metadata) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cs *classicalStrategy) Lookup(metadata *C.Metadata) (string, bool) {
+	for i, rule := range cs.rules {
+		if rule.Match(metadata) {
+			return cs.payload[i], true
+		}
+	}
+	return "", false
+}
+
+func (cs *classicalStrategy) Payload() []string {
+	return cs.payload
+}
+
+func (cs *classicalStrategy) Count() int {
+	return len(cs.rules)
+}
+
+func (cs *classicalStrategy) ShouldResolveIP() bool {
+	return cs.resolveIP
+}
+
+func (cs *classicalStrategy) ShouldFindProcess() bool {
+	return cs.findProcess
+}
+
+func (cs *classicalStrategy) insert(text string) error {
+	rule, err := R.ParseLine(text, "")
+	if err != nil {
+		return err
+	}
+
+	cs.rules = append(cs.rules, rule)
+	cs.payload = append(cs.payload, text)
+	cs.resolveIP = cs.resolveIP || rule.ShouldResolveIP()
+	cs.findProcess = cs.findProcess || rule.ShouldFindProcess()
+	return nil
+}
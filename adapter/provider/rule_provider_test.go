@@ -0,0 +1,63 @@
+package provider
+
+import (
+	"net"
+	"testing"
+
+	C "github.com/Dreamacro/clash/constant"
+	types "github.com/Dreamacro/clash/constant/provider"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRuleSet_Classical(t *testing.T) {
+	buf := []byte(`# comment
+DOMAIN-SUFFIX,example.com
+
+IP-CIDR,1.1.1.0/24,no-resolve
+AND,((DOMAIN-KEYWORD,google),(DST-PORT,443)) # trailing comment
+UNKNOWN,foo
+MATCH
+`)
+
+	strategy, err := parseRuleSet("test", types.Classical, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, strategy.Count())
+	assert.False(t, strategy.ShouldResolveIP())
+	assert.True(t, strategy.Match(&C.Metadata{Host: "www.google.com", DstPort: "443"}))
+	assert.True(t, strategy.Match(&C.Metadata{DstIP: net.ParseIP("1.1.1.1")}))
+	assert.False(t, strategy.Match(&C.Metadata{Host: "www.google.com", DstPort: "80"}))
+}
+
+func TestParseRuleSet_Lines(t *testing.T) {
+	lines := splitRuleLines([]byte("payload:\n  - DOMAIN,a.com\n  # comment\n  - 'OR,((DOMAIN,b.com),(DOMAIN,c.com))'\n"))
+	assert.Equal(t, []ruleLine{{line: 2, text: "DOMAIN,a.com"}, {line: 4, text: "OR,((DOMAIN,b.com),(DOMAIN,c.com))"}}, lines)
+
+	_, err := parseRuleSet("test", types.Classical, []byte("FOO,bar\n"))
+	assert.NotNil(t, err)
+}
+
+func TestParseRuleSet_Lookup(t *testing.T) {
+	strategy, err := parseRuleSet("test", types.Domain, []byte("+.example.com\nwww.google.com\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"+.example.com", "www.google.com"}, strategy.Payload())
+
+	entry, ok := strategy.Lookup(&C.Metadata{Host: "a.example.com"})
+	assert.True(t, ok)
+	assert.Equal(t, "+.example.com", entry)
+	_, ok = strategy.Lookup(&C.Metadata{Host: "google.com"})
+	assert.False(t, ok)
+
+	strategy, err = parseRuleSet("test", types.IPCIDR, []byte("10.0.0.0/8\n10.1.0.0/16\n10.0.0.0/8\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, strategy.Count())
+	entry, ok = strategy.Lookup(&C.Metadata{DstIP: net.ParseIP("10.1.2.3")})
+	assert.True(t, ok)
+	assert.Equal(t, "10.1.0.0/16", entry)
+
+	strategy, err = parseRuleSet("test", types.Classical, []byte("DOMAIN,a.com\nDOMAIN-SUFFIX,b.com\n"))
+	assert.Nil(t, err)
+	entry, ok = strategy.Lookup(&C.Metadata{Host: "x.b.com"})
+	assert.True(t, ok)
+	assert.Equal(t, "DOMAIN-SUFFIX,b.com", entry)
+}
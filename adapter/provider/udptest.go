@@ -0,0 +1,52 @@
+package provider
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// the bounds of a udp-test burst, the probes are 64 bytes so a burst is at most 3.2 KB
+// each way at 6.4 KB/s
+const (
+	defaultUDPTestCount    = 10
+	maxUDPTestCount        = 50
+	defaultUDPTestInterval = 20 * time.Millisecond
+	minUDPTestInterval     = 10 * time.Millisecond
+	maxUDPTestInterval     = time.Second
+)
+
+// UDPTestOption is the udp-test of a group or a provider health check, a burst of Count
+// probes Interval ms apart to the udp echo server Target
+type UDPTestOption struct {
+	Target   string `group:"target" provider:"target"`
+	Count    int    `group:"count,omitempty" provider:"count,omitempty"`
+	Interval int    `group:"interval,omitempty" provider:"interval,omitempty"`
+}
+
+type udpTest struct {
+	target   string
+	count    int
+	interval time.Duration
+}
+
+func (o UDPTestOption) parse() (*udpTest, error) {
+	if _, _, err := net.SplitHostPort(o.Target); err != nil {
+		return nil, fmt.Errorf("udp-test target %s: %w", o.Target, err)
+	}
+
+	t := &udpTest{target: o.Target, count: defaultUDPTestCount, interval: defaultUDPTestInterval}
+	if o.Count != 0 {
+		if o.Count < 1 || o.Count > maxUDPTestCount {
+			return nil, fmt.Errorf("udp-test count %d: expect 1-%d", o.Count, maxUDPTestCount)
+		}
+		t.count = o.Count
+	}
+	if o.Interval != 0 {
+		t.interval = time.Duration(o.Interval) * time.Millisecond
+		if t.interval < minUDPTestInterval || t.interval > maxUDPTestInterval {
+			return nil, fmt.Errorf("udp-test interval %d: expect %d-%d ms", o.Interval, minUDPTestInterval/time.Millisecond, maxUDPTestInterval/time.Millisecond)
+		}
+	}
+	return t, nil
+}
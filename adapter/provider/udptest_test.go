@@ -0,0 +1,31 @@
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDPTestOption(t *testing.T) {
+	test, err := UDPTestOption{Target: "echo.example.com:7"}.parse()
+	require.NoError(t, err)
+	assert.Equal(t, &udpTest{target: "echo.example.com:7", count: 10, interval: 20 * time.Millisecond}, test)
+
+	test, err = UDPTestOption{Target: "192.0.2.1:7", Count: 50, Interval: 1000}.parse()
+	require.NoError(t, err)
+	assert.Equal(t, 50, test.count)
+	assert.Equal(t, time.Second, test.interval)
+
+	for _, option := range []UDPTestOption{
+		{Target: "192.0.2.1"},
+		{Target: "192.0.2.1:7", Count: 51},
+		{Target: "192.0.2.1:7", Count: -1},
+		{Target: "192.0.2.1:7", Interval: 5},
+		{Target: "192.0.2.1:7", Interval: 1001},
+	} {
+		_, err := option.parse()
+		assert.Error(t, err, option)
+	}
+}
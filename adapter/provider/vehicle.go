@@ -3,13 +3,12 @@ package provider
 import (
 	"context"
 	"io"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"time"
 
-	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/fetch"
 	types "github.com/Dreamacro/clash/constant/provider"
 )
 
@@ -34,8 +33,9 @@ func NewFileVehicle(path string) *FileVehicle {
 }
 
 type HTTPVehicle struct {
-	url  string
-	path string
+	url   string
+	path  string
+	proxy *url.URL
 }
 
 func (h *HTTPVehicle) Type() types.VehicleType {
@@ -67,16 +67,8 @@ func (h *HTTPVehicle) Read() ([]byte, error) {
 
 	req = req.WithContext(ctx)
 
-	transport := &http.Transport{
-		// from http.DefaultTransport
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
-			return dialer.DialContext(ctx, network, address)
-		},
-	}
+	transport := fetch.NewTransport(h.proxy)
+	defer transport.CloseIdleConnections()
 
 	client := http.Client{Transport: transport}
 	resp, err := client.Do(req)
@@ -93,6 +85,7 @@ func (h *HTTPVehicle) Read() ([]byte, error) {
 	return buf, nil
 }
 
-func NewHTTPVehicle(url string, path string) *HTTPVehicle {
-	return &HTTPVehicle{url, path}
+// NewHTTPVehicle downloads url into path, through proxy when it isn't nil
+func NewHTTPVehicle(url string, path string, proxy *url.URL) *HTTPVehicle {
+	return &HTTPVehicle{url, path, proxy}
 }
@@ -0,0 +1,30 @@
+package provider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPVehicle_FetchProxy(t *testing.T) {
+	var requested, authorization string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+		authorization = r.Header.Get("Proxy-Authorization")
+		w.Write([]byte("payload: []"))
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.User = url.UserPassword("user", "pass")
+
+	vehicle := NewHTTPVehicle("http://provider.invalid/list.yaml", "", proxyURL)
+	buf, err := vehicle.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, "payload: []", string(buf))
+	assert.Equal(t, "http://provider.invalid/list.yaml", requested)
+	assert.Equal(t, "Basic dXNlcjpwYXNz", authorization)
+}
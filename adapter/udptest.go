@@ -0,0 +1,171 @@
+package adapter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/Dreamacro/clash/component/resolver"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+const (
+	// udpProbeSize is the size of a probe, the token, the sequence and the send offset padded
+	udpProbeSize = 64
+	// udpProbeWait is how long the replies are waited for after the last probe
+	udpProbeWait = time.Second
+)
+
+// UDPTest sends count probes interval apart through the proxy to the udp echo server target
+// and measures the share lost and the variation of their round trip. A proxy that can't relay
+// udp to the target loses them all.
+func (p *Proxy) UDPTest(ctx context.Context, target string, count int, interval time.Duration) (history C.UDPHistory, err error) {
+	history = C.UDPHistory{Sent: count, Lost: count, Loss: 100}
+	defer func() {
+		history.Time = time.Now()
+		p.udpHistory.Put(history)
+		if p.udpHistory.Len() > 10 {
+			p.udpHistory.Pop()
+		}
+	}()
+
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return history, err
+	}
+	ip, err := resolver.ResolveIP(host)
+	if err != nil {
+		return history, err
+	}
+	portNum, _ := strconv.Atoi(port)
+	addr := &net.UDPAddr{IP: ip, Port: portNum}
+
+	pc, err := p.ListenPacketContext(ctx, &C.Metadata{NetWork: C.UDP, DstIP: ip, DstPort: port})
+	if err != nil {
+		return history, err
+	}
+	defer pc.Close()
+
+	var token [8]byte
+	rand.Read(token[:])
+	rtts := make([]time.Duration, count)
+	received := make([]bool, count)
+	start := time.Now()
+
+	// the replies are read until udpProbeWait after the last probe or the end of ctx
+	deadline := start.Add(time.Duration(count-1)*interval + udpProbeWait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	pc.SetReadDeadline(deadline)
+	done := make(chan int)
+	go func() {
+		got := 0
+		buf := make([]byte, udpProbeSize*2)
+		for got < count {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			p.addProbeBytes(ctx, int64(n))
+			if n < 20 || [8]byte(buf[:8]) != token {
+				continue
+			}
+			seq := binary.BigEndian.Uint32(buf[8:12])
+			if seq >= uint32(count) || received[seq] {
+				continue
+			}
+			sent := time.Duration(binary.BigEndian.Uint64(buf[12:20]))
+			received[seq] = true
+			rtts[seq] = time.Since(start) - sent
+			got++
+		}
+		done <- got
+	}()
+
+	probe := make([]byte, udpProbeSize)
+	copy(probe, token[:])
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+send:
+	for seq := 0; seq < count; seq++ {
+		if seq > 0 {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				break send
+			}
+		}
+		binary.BigEndian.PutUint32(probe[8:12], uint32(seq))
+		binary.BigEndian.PutUint64(probe[12:20], uint64(time.Since(start)))
+		if _, err := pc.WriteTo(probe, addr); err == nil {
+			p.addProbeBytes(ctx, udpProbeSize)
+		}
+	}
+
+	got := <-done
+	history = udpHistory(count, received, rtts)
+	if got == 0 {
+		return history, errors.New("no probe came back from " + target)
+	}
+	return history, nil
+}
+
+// udpHistory takes the jitter over the probes received in a row
+func udpHistory(count int, received []bool, rtts []time.Duration) C.UDPHistory {
+	history := C.UDPHistory{Sent: count}
+	var sum, variation time.Duration
+	var last time.Duration
+	pairs := 0
+	inRow := false
+	for seq := 0; seq < count; seq++ {
+		if !received[seq] {
+			history.Lost++
+			inRow = false
+			continue
+		}
+		rtt := rtts[seq]
+		sum += rtt
+		if inRow {
+			diff := rtt - last
+			if diff < 0 {
+				diff = -diff
+			}
+			variation += diff
+			pairs++
+		}
+		last, inRow = rtt, true
+	}
+
+	history.Loss = float64(history.Lost) * 100 / float64(count)
+	if got := count - history.Lost; got > 0 {
+		history.Delay = uint16(sum / time.Duration(got) / time.Millisecond)
+	}
+	if pairs > 0 {
+		history.Jitter = uint16(variation / time.Duration(pairs) / time.Millisecond)
+	}
+	return history
+}
+
+// UDPHistory return the results of the last udp probe bursts
+func (p *Proxy) UDPHistory() []C.UDPHistory {
+	queue := p.udpHistory.Copy()
+	histories := []C.UDPHistory{}
+	for _, item := range queue {
+		histories = append(histories, item.(C.UDPHistory))
+	}
+	return histories
+}
+
+// LastUDPHistory return the result of the last udp probe burst, false before the first one
+func (p *Proxy) LastUDPHistory() (C.UDPHistory, bool) {
+	last := p.udpHistory.Last()
+	if last == nil {
+		return C.UDPHistory{}, false
+	}
+	return last.(C.UDPHistory), true
+}
@@ -0,0 +1,70 @@
+package adapter
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/outbound"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// udpEcho answers the packets it reads, dropping the ones drop returns true for
+func udpEcho(t *testing.T, drop func(i int) bool) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for i := 0; ; i++ {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if !drop(i) {
+				pc.WriteTo(buf[:n], addr)
+			}
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestProxy_UDPTest(t *testing.T) {
+	proxy := NewProxy(outbound.NewDirect())
+
+	target := udpEcho(t, func(i int) bool { return i%2 == 1 })
+	history, err := proxy.UDPTest(context.Background(), target, 10, 10*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 10, history.Sent)
+	assert.Equal(t, 5, history.Lost)
+	assert.Equal(t, float64(50), history.Loss)
+	assert.Greater(t, proxy.ProbeBytes(), int64(10*udpProbeSize))
+
+	last, ok := proxy.LastUDPHistory()
+	require.True(t, ok)
+	assert.Equal(t, history, last)
+
+	// nothing comes back
+	target = udpEcho(t, func(int) bool { return true })
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	history, err = proxy.UDPTest(ctx, target, 3, 10*time.Millisecond)
+	assert.Error(t, err)
+	assert.Equal(t, float64(100), history.Loss)
+	assert.Len(t, proxy.UDPHistory(), 2)
+}
+
+func TestUDPHistory_Jitter(t *testing.T) {
+	ms := time.Millisecond
+	// 10 -> 30 -> 20 varies by 20 and 10, the probe after the lost one starts a new row
+	history := udpHistory(5,
+		[]bool{true, true, true, false, true},
+		[]time.Duration{10 * ms, 30 * ms, 20 * ms, 0, 100 * ms})
+	assert.Equal(t, 1, history.Lost)
+	assert.Equal(t, float64(20), history.Loss)
+	assert.Equal(t, uint16(15), history.Jitter)
+	assert.Equal(t, uint16(40), history.Delay)
+}
@@ -0,0 +1,61 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Dreamacro/clash/component/resolver"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+// upstream return the destination sent to the server of the proxy and records it into
+// the Upstream of ctx. With dns-resolve local the hostname is resolved by clash. A fake
+// ip is never sent, the tunnel maps it back to the hostname before the dial and drops
+// the connection when the mapping is gone, the check here covers the other callers.
+func (p *Proxy) upstream(ctx context.Context, metadata *C.Metadata) (context.Context, *C.Metadata, error) {
+	if metadata.Host == "" && resolver.IsFakeIP(metadata.DstIP) {
+		return ctx, nil, fmt.Errorf("fake ip %s has no hostname", metadata.DstIP)
+	}
+
+	switch p.Type() {
+	case C.Reject, C.Selector, C.Fallback, C.URLTest, C.LoadBalance:
+		// the proxy picked by the group records its own
+		return ctx, metadata, nil
+	case C.Relay:
+		// the hops are dialed with the servers of the next ones, the last one gets metadata as is
+		recordUpstream(ctx, metadata, C.DNSResolveRemote)
+		return C.WithUpstream(ctx, nil), metadata, nil
+	case C.Direct:
+		recordUpstream(ctx, metadata, C.DNSResolveLocal)
+		return ctx, metadata, nil
+	}
+
+	r, ok := p.ProxyAdapter.(C.DNSResolver)
+	if !ok || r.DNSResolve() != C.DNSResolveLocal {
+		recordUpstream(ctx, metadata, C.DNSResolveRemote)
+		return ctx, metadata, nil
+	}
+	if metadata.Host == "" {
+		recordUpstream(ctx, metadata, C.DNSResolveLocal)
+		return ctx, metadata, nil
+	}
+
+	ip, err := resolver.ResolveIP(metadata.Host)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("dns-resolve %s: %w", metadata.Host, err)
+	}
+	if resolver.IsFakeIP(ip) {
+		return ctx, nil, fmt.Errorf("dns-resolve %s: got fake ip %s", metadata.Host, ip)
+	}
+
+	resolved := *metadata
+	resolved.Host, resolved.DstIP = "", ip
+	recordUpstream(ctx, &resolved, C.DNSResolveLocal)
+	return ctx, &resolved, nil
+}
+
+func recordUpstream(ctx context.Context, metadata *C.Metadata, resolve string) {
+	if u := C.UpstreamFromContext(ctx); u != nil {
+		u.Address, u.Resolve = metadata.RemoteAddress(), resolve
+	}
+}
@@ -0,0 +1,57 @@
+package adapter
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/component/trie"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy_DNSResolve(t *testing.T) {
+	hosts := resolver.DefaultHosts
+	defer func() { resolver.DefaultHosts = hosts }()
+	resolver.DefaultHosts = trie.New()
+	resolver.DefaultHosts.Insert("upstream.test", net.IPv4(10, 0, 0, 1))
+
+	parse := func(resolve string) (C.Proxy, error) {
+		return ParseProxy(map[string]any{
+			"name":        "socks",
+			"type":        "socks5",
+			"server":      "127.0.0.1",
+			"port":        1080,
+			"dns-resolve": resolve,
+		})
+	}
+	metadata := &C.Metadata{NetWork: C.TCP, Host: "upstream.test", DstPort: "443"}
+
+	for _, resolve := range []string{"", C.DNSResolveRemote} {
+		proxy, err := parse(resolve)
+		require.NoError(t, err)
+
+		u := &C.Upstream{}
+		_, sent, err := proxy.(*Proxy).upstream(C.WithUpstream(context.Background(), u), metadata)
+		require.NoError(t, err)
+		assert.Same(t, metadata, sent)
+		assert.Equal(t, C.Upstream{Address: "upstream.test:443", Resolve: C.DNSResolveRemote}, *u)
+	}
+
+	proxy, err := parse(C.DNSResolveLocal)
+	require.NoError(t, err)
+
+	u := &C.Upstream{}
+	_, sent, err := proxy.(*Proxy).upstream(C.WithUpstream(context.Background(), u), metadata)
+	require.NoError(t, err)
+	assert.Empty(t, sent.Host)
+	assert.Equal(t, "10.0.0.1", sent.DstIP.String())
+	assert.Equal(t, "upstream.test", metadata.Host)
+	assert.Equal(t, C.Upstream{Address: "10.0.0.1:443", Resolve: C.DNSResolveLocal}, *u)
+
+	_, err = parse("server")
+	assert.Error(t, err)
+}
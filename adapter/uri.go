@@ -0,0 +1,352 @@
+package adapter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ProxyMapping returns an item of a proxies list as a mapping, share links are expanded
+func ProxyMapping(item any) (map[string]any, error) {
+	switch v := item.(type) {
+	case map[string]any:
+		return v, nil
+	case string:
+		return ParseProxyURI(strings.TrimSpace(v))
+	default:
+		return nil, fmt.Errorf("expect a mapping or a share link, got %T", item)
+	}
+}
+
+// ParseProxyURI expands a ss://, vmess:// or trojan:// share link into a proxy mapping
+func ParseProxyURI(uri string) (map[string]any, error) {
+	scheme, _, found := strings.Cut(uri, "://")
+	if !found {
+		return nil, errors.New("not a share link, missing scheme")
+	}
+
+	switch strings.ToLower(scheme) {
+	case "ss":
+		return parseSSURI(uri)
+	case "vmess":
+		return parseVmessURI(uri)
+	case "trojan":
+		return parseTrojanURI(uri)
+	default:
+		return nil, fmt.Errorf("unsupport share link scheme: %s", scheme)
+	}
+}
+
+// parseSSURI accepts SIP002 links and the legacy form with the whole address base64 encoded
+func parseSSURI(uri string) (map[string]any, error) {
+	_, body, _ := strings.Cut(uri, "://")
+	body, fragment, _ := strings.Cut(body, "#")
+	if !strings.Contains(body, "@") {
+		// ss://base64(method:password@host:port)#name
+		decoded, err := decodeBase64(body)
+		if err != nil {
+			return nil, fmt.Errorf("ss link: base64 of method:password@host:port: %w", err)
+		}
+		// the password of the legacy form is not escaped
+		cipher, rest, found := strings.Cut(decoded, ":")
+		at := strings.LastIndex(rest, "@")
+		if !found || at < 0 {
+			return nil, errors.New("ss link: expect method:password@host:port in the base64 part")
+		}
+		body = url.UserPassword(cipher, rest[:at]).String() + "@" + rest[at+1:]
+	}
+
+	u, err := url.Parse("ss://" + body)
+	if err != nil {
+		return nil, fmt.Errorf("ss link: %w", err)
+	}
+	if u.Fragment, err = url.PathUnescape(fragment); err != nil {
+		return nil, fmt.Errorf("ss link: name: %w", err)
+	}
+
+	cipher, password, ok := ssUserInfo(u.User)
+	if !ok {
+		return nil, errors.New("ss link: userinfo must be method:password, plain or base64 encoded")
+	}
+
+	server, port, err := uriAddress(u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("ss link: %w", err)
+	}
+
+	mapping := map[string]any{
+		"type":     "ss",
+		"name":     uriName(u.Fragment, u.Host),
+		"server":   server,
+		"port":     port,
+		"cipher":   cipher,
+		"password": password,
+		"udp":      true,
+	}
+
+	if plugin := u.Query().Get("plugin"); plugin != "" {
+		name, opts, err := parseSSPlugin(plugin)
+		if err != nil {
+			return nil, fmt.Errorf("ss link: plugin: %w", err)
+		}
+		mapping["plugin"] = name
+		mapping["plugin-opts"] = opts
+	}
+
+	return mapping, nil
+}
+
+func ssUserInfo(user *url.Userinfo) (cipher, password string, ok bool) {
+	if password, ok = user.Password(); ok {
+		return user.Username(), password, true
+	}
+
+	decoded, err := decodeBase64(user.Username())
+	if err != nil {
+		return "", "", false
+	}
+	cipher, password, ok = strings.Cut(decoded, ":")
+	return
+}
+
+// parseSSPlugin converts the SIP003 plugin parameter, e.g. obfs-local;obfs=http;obfs-host=example.com
+func parseSSPlugin(plugin string) (string, map[string]any, error) {
+	fields := strings.Split(plugin, ";")
+	params := map[string]string{}
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		params[key] = value
+	}
+
+	switch fields[0] {
+	case "obfs-local", "simple-obfs", "obfs":
+		return "obfs", map[string]any{
+			"mode": params["obfs"],
+			"host": params["obfs-host"],
+		}, nil
+	case "v2ray-plugin":
+		opts := map[string]any{
+			"mode": "websocket",
+			"host": params["host"],
+			"path": params["path"],
+		}
+		if mode := params["mode"]; mode != "" {
+			opts["mode"] = mode
+		}
+		if _, ok := params["tls"]; ok {
+			opts["tls"] = true
+		}
+		if _, ok := params["mux"]; ok {
+			opts["mux"] = true
+		}
+		return "v2ray-plugin", opts, nil
+	default:
+		return "", nil, fmt.Errorf("unsupport plugin %s", fields[0])
+	}
+}
+
+// vmessLink is the v2rayN json format, numbers are sometimes written as strings
+type vmessLink struct {
+	Name    string          `json:"ps"`
+	Server  string          `json:"add"`
+	Port    json.RawMessage `json:"port"`
+	UUID    string          `json:"id"`
+	AlterID json.RawMessage `json:"aid"`
+	Cipher  string          `json:"scy"`
+	Network string          `json:"net"`
+	Type    string          `json:"type"`
+	Host    string          `json:"host"`
+	Path    string          `json:"path"`
+	TLS     string          `json:"tls"`
+	SNI     string          `json:"sni"`
+}
+
+func parseVmessURI(uri string) (map[string]any, error) {
+	_, payload, _ := strings.Cut(uri, "://")
+	decoded, err := decodeBase64(payload)
+	if err != nil {
+		return nil, fmt.Errorf("vmess link: base64 payload: %w", err)
+	}
+
+	link := &vmessLink{}
+	if err := json.Unmarshal([]byte(decoded), link); err != nil {
+		return nil, fmt.Errorf("vmess link: json payload: %w", err)
+	}
+
+	if link.Server == "" || link.UUID == "" {
+		return nil, errors.New("vmess link: add and id are required")
+	}
+	port, err := jsonNumber(link.Port)
+	if err != nil {
+		return nil, fmt.Errorf("vmess link: port: %w", err)
+	}
+	alterID, err := jsonNumber(link.AlterID)
+	if err != nil {
+		return nil, fmt.Errorf("vmess link: aid: %w", err)
+	}
+
+	cipher := link.Cipher
+	if cipher == "" {
+		cipher = "auto"
+	}
+
+	mapping := map[string]any{
+		"type":    "vmess",
+		"name":    uriName(link.Name, net.JoinHostPort(link.Server, strconv.Itoa(port))),
+		"server":  link.Server,
+		"port":    port,
+		"uuid":    link.UUID,
+		"alterId": alterID,
+		"cipher":  cipher,
+		"udp":     true,
+	}
+
+	if link.TLS == "tls" {
+		mapping["tls"] = true
+		if link.SNI != "" {
+			mapping["servername"] = link.SNI
+		}
+	}
+
+	switch link.Network {
+	case "", "tcp":
+		if link.Type == "http" {
+			mapping["network"] = "http"
+			opts := map[string]any{"path": []string{defaultString(link.Path, "/")}}
+			if link.Host != "" {
+				opts["headers"] = map[string]any{"Host": []string{link.Host}}
+			}
+			mapping["http-opts"] = opts
+		}
+	case "ws":
+		mapping["network"] = "ws"
+		opts := map[string]any{"path": defaultString(link.Path, "/")}
+		if link.Host != "" {
+			opts["headers"] = map[string]any{"Host": link.Host}
+		}
+		mapping["ws-opts"] = opts
+	case "h2", "http":
+		mapping["network"] = "h2"
+		opts := map[string]any{"path": defaultString(link.Path, "/")}
+		if link.Host != "" {
+			opts["host"] = strings.Split(link.Host, ",")
+		}
+		mapping["h2-opts"] = opts
+	case "grpc":
+		mapping["network"] = "grpc"
+		mapping["grpc-opts"] = map[string]any{"grpc-service-name": link.Path}
+	default:
+		return nil, fmt.Errorf("vmess link: unsupport net %s", link.Network)
+	}
+
+	return mapping, nil
+}
+
+func parseTrojanURI(uri string) (map[string]any, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("trojan link: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("trojan link: password is required before @")
+	}
+
+	server, port, err := uriAddress(u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("trojan link: %w", err)
+	}
+
+	query := u.Query()
+	mapping := map[string]any{
+		"type":     "trojan",
+		"name":     uriName(u.Fragment, u.Host),
+		"server":   server,
+		"port":     port,
+		"password": u.User.Username(),
+		"udp":      true,
+	}
+
+	if sni := defaultString(query.Get("sni"), query.Get("peer")); sni != "" {
+		mapping["sni"] = sni
+	}
+	if alpn := query.Get("alpn"); alpn != "" {
+		mapping["alpn"] = strings.Split(alpn, ",")
+	}
+	switch query.Get("allowInsecure") {
+	case "1", "true":
+		mapping["skip-cert-verify"] = true
+	}
+
+	switch network := query.Get("type"); network {
+	case "", "tcp":
+	case "ws":
+		mapping["network"] = "ws"
+		opts := map[string]any{"path": defaultString(query.Get("path"), "/")}
+		if host := query.Get("host"); host != "" {
+			opts["headers"] = map[string]any{"Host": host}
+		}
+		mapping["ws-opts"] = opts
+	case "grpc":
+		mapping["network"] = "grpc"
+		mapping["grpc-opts"] = map[string]any{"grpc-service-name": query.Get("serviceName")}
+	default:
+		return nil, fmt.Errorf("trojan link: unsupport type %s", network)
+	}
+
+	return mapping, nil
+}
+
+func uriAddress(hostport string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", 0, fmt.Errorf("address %s: expect host:port", hostport)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return "", 0, fmt.Errorf("address %s: invalid port", hostport)
+	}
+	return host, int(port), nil
+}
+
+func uriName(name, fallback string) string {
+	if name = strings.TrimSpace(name); name != "" {
+		return name
+	}
+	return fallback
+}
+
+func jsonNumber(raw json.RawMessage) (int, error) {
+	if len(raw) == 0 {
+		return 0, nil
+	}
+	s := strings.Trim(string(raw), `"`)
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func defaultString(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// decodeBase64 accepts standard and url safe alphabets with or without padding
+func decodeBase64(s string) (string, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	if b, err := base64.RawStdEncoding.DecodeString(s); err == nil {
+		return string(b), nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
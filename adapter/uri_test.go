@@ -0,0 +1,76 @@
+package adapter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProxyURI_Shadowsocks(t *testing.T) {
+	mapping, err := ParseProxyURI("ss://YWVzLTEyOC1nY206cGFzcw@example.com:8388/?plugin=obfs-local%3Bobfs%3Dhttp%3Bobfs-host%3Dbing.com#my%20ss")
+	assert.Nil(t, err)
+	assert.Equal(t, "my ss", mapping["name"])
+	assert.Equal(t, "aes-128-gcm", mapping["cipher"])
+	assert.Equal(t, "pass", mapping["password"])
+	assert.Equal(t, 8388, mapping["port"])
+	assert.Equal(t, "obfs", mapping["plugin"])
+	assert.Equal(t, map[string]any{"mode": "http", "host": "bing.com"}, mapping["plugin-opts"])
+
+	// legacy form with an unescaped password
+	mapping, err = ParseProxyURI("ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpwQHNzOndAZXhhbXBsZS5jb206ODM4OA==")
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com:8388", mapping["name"])
+	assert.Equal(t, "chacha20-ietf-poly1305", mapping["cipher"])
+	assert.Equal(t, "p@ss:w", mapping["password"])
+
+	proxy, err := ParseProxy(mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com:8388", proxy.Name())
+}
+
+func TestParseProxyURI_Vmess(t *testing.T) {
+	mapping, err := ParseProxyURI("vmess://eyJ2IjogIjIiLCAicHMiOiAianAiLCAiYWRkIjogIjEuMi4zLjQiLCAicG9ydCI6ICI0NDMiLCAiaWQiOiAiYjgzMTM4MWQtNjMyNC00ZDUzLWFkNGYtOGNkYTQ4YjMwODExIiwgImFpZCI6IDAsICJuZXQiOiAid3MiLCAiaG9zdCI6ICJjZG4uZXhhbXBsZS5jb20iLCAicGF0aCI6ICIvcmF5IiwgInRscyI6ICJ0bHMifQ==")
+	assert.Nil(t, err)
+	assert.Equal(t, "jp", mapping["name"])
+	assert.Equal(t, 443, mapping["port"])
+	assert.Equal(t, "ws", mapping["network"])
+	assert.Equal(t, true, mapping["tls"])
+
+	proxy, err := ParseProxy(mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, "jp", proxy.Name())
+}
+
+func TestParseProxyURI_Trojan(t *testing.T) {
+	mapping, err := ParseProxyURI("trojan://secret@example.com:443?sni=cdn.example.com&allowInsecure=1&type=grpc&serviceName=tun#hk")
+	assert.Nil(t, err)
+	assert.Equal(t, "hk", mapping["name"])
+	assert.Equal(t, "secret", mapping["password"])
+	assert.Equal(t, "cdn.example.com", mapping["sni"])
+	assert.Equal(t, true, mapping["skip-cert-verify"])
+	assert.Equal(t, map[string]any{"grpc-service-name": "tun"}, mapping["grpc-opts"])
+
+	_, err = ParseProxy(mapping)
+	assert.Nil(t, err)
+}
+
+// the two legacy ss links without a colon before their @ are user@host:8388 and method@host in base64
+func TestParseProxyURI_Invalid(t *testing.T) {
+	for uri, hint := range map[string]string{
+		"example.com:443":                     "missing scheme",
+		"ss://bm90LWJhc2U2NA@host:1":          "userinfo",
+		"ss://YWVzLTEyOC1nY206cGFzcw@h":       "address",
+		"ss://dXNlckBob3N0OjgzODg":            "method:password@host:port",
+		"ss://bWV0aG9kQGhvc3Q":                "method:password@host:port",
+		"vmess://e30":                         "add and id",
+		"trojan://@example.com:443":           "password",
+		"trojan://pw@example.com:0":           "invalid port",
+		"vless://id@example.com:443":          "unsupport share link scheme",
+		"trojan://pw@example.com:1?type=quic": "type quic",
+	} {
+		_, err := ParseProxyURI(uri)
+		if assert.Error(t, err, uri) {
+			assert.Contains(t, err.Error(), hint, uri)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	C "github.com/Dreamacro/clash/constant"
+)
+
+// viaProbe is the probe client of a proxy dialed through another one, it's kept
+// for the connection reuse until the via proxy changes
+type viaProbe struct {
+	via    C.Proxy
+	client *http.Client
+}
+
+// URLTestVia is URLTest with the probe connection to the proxy server dialed through via,
+// so the delay covers the whole chain
+func (p *Proxy) URLTestVia(ctx context.Context, url string, via C.Proxy) (delay, meanDelay uint16, err error) {
+	if via == nil {
+		return p.URLTest(ctx, url)
+	}
+
+	v := p.via.Load()
+	if v == nil || v.via != via {
+		if v != nil {
+			v.client.CloseIdleConnections()
+		}
+		v = &viaProbe{via: via}
+		v.client = newProbeClient(func(ctx context.Context, network, address string) (net.Conn, error) {
+			return p.dialProbeVia(ctx, address, via)
+		})
+		p.via.Store(v)
+	}
+	return p.urlTest(ctx, url, v.client)
+}
+
+func (p *Proxy) dialProbeVia(ctx context.Context, address string, via C.Proxy) (net.Conn, error) {
+	addr, err := addrToMetadata(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var leaf C.Proxy = p
+	for {
+		next := leaf.Unwrap(&addr)
+		if next == nil {
+			break
+		}
+		leaf = next
+	}
+
+	var conn net.Conn
+	switch leaf.Type() {
+	case C.Direct:
+		conn, err = via.DialContext(ctx, &addr)
+	case C.Reject, C.Relay:
+		return nil, fmt.Errorf("%s can't be tested via %s", leaf.Name(), via.Name())
+	default:
+		server, merr := addrToMetadata(leaf.Addr())
+		if merr != nil {
+			return nil, merr
+		}
+		var c net.Conn
+		if c, err = via.DialContext(ctx, &server); err == nil {
+			if conn, err = leaf.StreamConn(c, &addr); err != nil {
+				c.Close()
+			}
+		}
+	}
+	p.setAlive(err)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCountedConn(ctx, conn, p.probeBytes), nil
+}
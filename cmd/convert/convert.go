@@ -0,0 +1,337 @@
+// Package convert imports the proxies, groups and rules of Surge, Quantumult X and
+// sing-box configs, `clash convert -from surge -in surge.conf -out config.yaml`.
+// The proxies are built from the option structs of the adapters and every item is
+// parsed back, so what is written is a config clash accepts.
+package convert
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/Dreamacro/clash/adapter"
+	"github.com/Dreamacro/clash/adapter/outboundgroup"
+	"github.com/Dreamacro/clash/config"
+	"github.com/Dreamacro/clash/log"
+	R "github.com/Dreamacro/clash/rule"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FromSurge       = "surge"
+	FromQuantumultX = "quantumult-x"
+	FromSingBox     = "sing-box"
+
+	defaultTestURL  = "http://www.gstatic.com/generate_204"
+	defaultInterval = 300
+)
+
+// Skipped is an item of the source that couldn't be converted
+type Skipped struct {
+	Item   string
+	Reason string
+}
+
+// Result is the converted config, Config is the yaml of the proxies, proxy-groups and rules
+type Result struct {
+	Config  []byte
+	Skipped []Skipped
+}
+
+type group struct {
+	item      string
+	name      string
+	tp        string
+	members   []string
+	url       string
+	interval  int
+	tolerance int
+	strategy  string
+}
+
+type rule struct {
+	item    string
+	tp      string
+	payload string
+	target  string
+	params  []string
+}
+
+func (r rule) String() string {
+	parts := []string{r.tp}
+	if r.payload != "" {
+		parts = append(parts, r.payload)
+	}
+	parts = append(parts, r.target)
+	return strings.Join(append(parts, r.params...), ",")
+}
+
+// builder collects what the parsers found, the targets are checked once all is read
+type builder struct {
+	proxies []*mapping
+	names   map[string]bool
+	groups  []*group
+	rules   []rule
+	// alias maps the named direct and reject policies of the source to the builtin ones
+	alias   map[string]string
+	skipped []Skipped
+}
+
+func newBuilder() *builder {
+	return &builder{names: map[string]bool{}, alias: map[string]string{}}
+}
+
+func (b *builder) skip(item string, format string, args ...any) {
+	b.skipped = append(b.skipped, Skipped{Item: item, Reason: fmt.Sprintf(format, args...)})
+}
+
+// addProxy encodes the option struct of an adapter, it's parsed back like a config would be
+func (b *builder) addProxy(item, tp string, option any) {
+	m := &mapping{}
+	name := reflect.ValueOf(option).FieldByName("Name").String()
+	m.set("name", name)
+	m.set("type", tp)
+	encode(m, "proxy", reflect.ValueOf(option))
+
+	if name == "" {
+		b.skip(item, "the proxy has no name")
+		return
+	}
+	if b.names[name] {
+		b.skip(item, "duplicate name %s", name)
+		return
+	}
+	if err := parseBack(m, func(v map[string]any) error {
+		_, err := adapter.ParseProxy(v)
+		return err
+	}); err != nil {
+		b.skip(item, "%s", err)
+		return
+	}
+	b.names[name] = true
+	b.proxies = append(b.proxies, m)
+}
+
+func (b *builder) addRule(r rule) {
+	b.rules = append(b.rules, r)
+}
+
+// resolve return the policy a name of the source stands for
+func (b *builder) resolve(name string) string {
+	if builtin, ok := b.alias[name]; ok {
+		return builtin
+	}
+	return name
+}
+
+func (b *builder) build() (*Result, error) {
+	out := struct {
+		Proxies []*mapping `yaml:"proxies"`
+		Groups  []*mapping `yaml:"proxy-groups,omitempty"`
+		Rules   []string   `yaml:"rules"`
+	}{Proxies: b.proxies, Rules: []string{}}
+
+	known := map[string]bool{"DIRECT": true, "REJECT": true}
+	for name := range b.names {
+		known[name] = true
+	}
+
+	// a group is only kept once its members are, the ones depending on a dropped group follow it
+	groups := map[string]*group{}
+	for _, g := range b.groups {
+		if known[g.name] || groups[g.name] != nil {
+			b.skip(g.item, "duplicate name %s", g.name)
+			continue
+		}
+		groups[g.name] = g
+	}
+	for changed := true; changed; {
+		changed = false
+		for name, g := range groups {
+			members := []string{}
+			for _, member := range g.members {
+				member = b.resolve(member)
+				if known[member] || groups[member] != nil {
+					members = append(members, member)
+				}
+			}
+			if len(members) == 0 {
+				b.skip(g.item, "none of the members of %s could be converted", name)
+				delete(groups, name)
+				changed = true
+			}
+		}
+	}
+	for _, g := range b.groups {
+		if groups[g.name] == g {
+			known[g.name] = true
+		}
+	}
+
+	for _, g := range b.groups {
+		if groups[g.name] != g {
+			continue
+		}
+		option := outboundgroup.GroupCommonOption{Name: g.name, Type: g.tp}
+		for _, member := range g.members {
+			if member = b.resolve(member); known[member] {
+				option.Proxies = append(option.Proxies, member)
+			} else {
+				b.skip(g.item, "member %s of %s is dropped, it couldn't be converted", member, g.name)
+			}
+		}
+		if g.tp != "select" {
+			option.URL, option.Interval = g.url, g.interval
+			if option.URL == "" {
+				option.URL = defaultTestURL
+			}
+			if option.Interval <= 0 {
+				option.Interval = defaultInterval
+			}
+		}
+
+		m := &mapping{}
+		m.set("name", g.name)
+		m.set("type", g.tp)
+		encode(m, "group", reflect.ValueOf(option))
+		if g.tolerance > 0 {
+			m.set("tolerance", g.tolerance)
+		}
+		if g.strategy != "" {
+			m.set("strategy", g.strategy)
+		}
+		out.Groups = append(out.Groups, m)
+	}
+
+	for _, r := range b.rules {
+		r.target = b.resolve(r.target)
+		if !known[r.target] {
+			b.skip(r.item, "unknown policy %s", r.target)
+			continue
+		}
+		if err := checkRule(r); err != nil {
+			b.skip(r.item, "%s", err)
+			continue
+		}
+		out.Rules = append(out.Rules, r.String())
+	}
+
+	buf, err := yaml.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	// the items were checked one by one, this catches what only fails together
+	if _, err := config.Parse(buf); err != nil {
+		return nil, fmt.Errorf("the converted config doesn't parse: %w", err)
+	}
+	return &Result{Config: buf, Skipped: b.skipped}, nil
+}
+
+func checkRule(r rule) error {
+	switch r.tp {
+	case "MATCH":
+		return nil
+	case "AND", "OR", "NOT":
+		_, err := R.ParseLine(r.tp+","+r.payload, r.target)
+		return err
+	default:
+		_, err := R.ParseRule(r.tp, r.payload, r.target, r.params)
+		return err
+	}
+}
+
+// parseBack round trips m through yaml so fn sees what a config file would give
+func parseBack(m *mapping, fn func(map[string]any) error) error {
+	buf, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	v := map[string]any{}
+	if err := yaml.Unmarshal(buf, &v); err != nil {
+		return err
+	}
+	return fn(v)
+}
+
+// Convert converts the config of the tool from
+func Convert(from string, data []byte) (*Result, error) {
+	b := newBuilder()
+	switch from {
+	case FromSurge:
+		parseSurge(b, string(data))
+	case FromQuantumultX, "quantumultx", "qx":
+		parseQuantumultX(b, string(data))
+	case FromSingBox, "singbox":
+		if err := parseSingBox(b, data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %s, expect %s, %s or %s", from, FromSurge, FromQuantumultX, FromSingBox)
+	}
+	return b.build()
+}
+
+// Main runs the convert subcommand and return the exit code
+func Main(args []string) int {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	from := flags.String("from", "", "format of the input: surge, quantumult-x or sing-box")
+	in := flags.String("in", "-", "input file, - is stdin")
+	out := flags.String("out", "-", "output file, - is stdout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	// parsing the result back logs to stdout, where the config may go
+	log.SetLevel(log.SILENT)
+	if err := run(*from, *in, *out); err != nil {
+		fmt.Fprintf(os.Stderr, "convert: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func run(from, in, out string) error {
+	if from == "" {
+		return errors.New("-from is required")
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if in == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(in)
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := Convert(from, data)
+	if err != nil {
+		return err
+	}
+
+	if out == "-" {
+		_, err = os.Stdout.Write(result.Config)
+	} else {
+		err = os.WriteFile(out, result.Config, 0o644)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(result.Skipped) > 0 {
+		fmt.Fprintf(os.Stderr, "%d items couldn't be converted:\n", len(result.Skipped))
+		for _, s := range result.Skipped {
+			fmt.Fprintf(os.Stderr, "  %s\n    %s\n", s.Item, s.Reason)
+		}
+	}
+	return nil
+}
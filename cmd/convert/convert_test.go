@@ -0,0 +1,145 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Dreamacro/clash/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func skippedItems(result *Result) string {
+	items := []string{}
+	for _, s := range result.Skipped {
+		items = append(items, s.Item)
+	}
+	return strings.Join(items, "\n")
+}
+
+func TestConvert_Surge(t *testing.T) {
+	result, err := Convert(FromSurge, []byte(`
+[Proxy]
+On = direct
+ss1 = ss, 1.2.3.4, 8388, encrypt-method=aes-128-gcm, password=pass, obfs=http, obfs-host=bing.com, udp-relay=true
+vm = vmess, example.com, 443, username=b831381d-6324-4d53-ad4f-8cda48b30811, tls=true, ws=true, ws-path=/ws, ws-headers=Host:example.com
+h = https, example.com, 443, user, pass
+wg = wireguard, section-name=home
+
+[Proxy Group]
+Proxy = select, ss1, vm, h, wg, On
+Auto = url-test, ss1, vm, interval=600, tolerance=50
+Home = select, wg
+
+[Rule]
+DOMAIN-SUFFIX,google.com,Proxy
+DEST-PORT,22,On
+AND,((DOMAIN-KEYWORD,ads),(DEST-PORT,443)),REJECT-TINYGIF
+URL-REGEX,^http://ads,REJECT
+IP-CIDR,10.0.0.0/8,DIRECT,no-resolve
+FINAL,Auto
+`))
+	require.NoError(t, err)
+
+	cfg, err := config.Parse(result.Config)
+	require.NoError(t, err)
+	assert.Contains(t, cfg.Proxies, "ss1")
+	assert.Contains(t, cfg.Proxies, "vm")
+	assert.Contains(t, cfg.Proxies, "Auto")
+	assert.NotContains(t, cfg.Proxies, "Home")
+
+	out := string(result.Config)
+	assert.Contains(t, out, "DST-PORT,22,DIRECT")
+	assert.Contains(t, out, "AND,((DOMAIN-KEYWORD,ads),(DST-PORT,443)),REJECT")
+	assert.Contains(t, out, "IP-CIDR,10.0.0.0/8,DIRECT,no-resolve")
+	assert.Contains(t, out, "MATCH,Auto")
+
+	skipped := skippedItems(result)
+	assert.Contains(t, skipped, "wg = wireguard")
+	assert.Contains(t, skipped, "Home = select")
+	assert.Contains(t, skipped, "URL-REGEX")
+}
+
+func TestConvert_QuantumultX(t *testing.T) {
+	result, err := Convert(FromQuantumultX, []byte(`
+[server_local]
+shadowsocks=1.2.3.4:443, method=chacha20-ietf-poly1305, password=pass, obfs=wss, obfs-host=example.com, obfs-uri=/ws, tag=ss-ws
+vmess=example.com:443, method=chacha20-ietf-poly1305, password=b831381d-6324-4d53-ad4f-8cda48b30811, obfs=over-tls, tag=vm
+
+[server_remote]
+https://example.com/servers, tag=sub
+
+[policy]
+static=Proxy, ss-ws, vm, direct
+round-robin=LB, ss-ws, vm
+
+[filter_local]
+host-suffix, google.com, Proxy
+HOST-KEYWORD, ads, reject
+user-agent, curl*, direct
+final, LB
+`))
+	require.NoError(t, err)
+
+	cfg, err := config.Parse(result.Config)
+	require.NoError(t, err)
+	assert.Contains(t, cfg.Proxies, "ss-ws")
+	assert.Contains(t, cfg.Proxies, "vm")
+	assert.Contains(t, cfg.Proxies, "LB")
+
+	out := string(result.Config)
+	assert.Contains(t, out, "strategy: round-robin")
+	assert.Contains(t, out, "DOMAIN-KEYWORD,ads,REJECT")
+	assert.Contains(t, out, "MATCH,LB")
+
+	skipped := skippedItems(result)
+	assert.Contains(t, skipped, "https://example.com/servers")
+	assert.Contains(t, skipped, "user-agent")
+}
+
+func TestConvert_SingBox(t *testing.T) {
+	result, err := Convert(FromSingBox, []byte(`{
+  "outbounds": [
+    {"type": "trojan", "tag": "tj", "server": "example.com", "server_port": 443, "password": "pass",
+     "tls": {"enabled": true, "server_name": "example.com"}, "transport": {"type": "grpc", "service_name": "tun"}},
+    {"type": "vmess", "tag": "vm", "server": "example.com", "server_port": 443,
+     "uuid": "b831381d-6324-4d53-ad4f-8cda48b30811", "transport": {"type": "ws", "path": "/ws"}},
+    {"type": "hysteria2", "tag": "hy", "server": "example.com", "server_port": 443},
+    {"type": "urltest", "tag": "auto", "outbounds": ["tj", "vm", "hy"], "interval": "5m"},
+    {"type": "direct", "tag": "direct"},
+    {"type": "block", "tag": "block"}
+  ],
+  "route": {
+    "rules": [
+      {"domain_suffix": ["google.com", "youtube.com"], "outbound": "auto"},
+      {"domain_keyword": "ads", "port": [80, 443], "outbound": "block"},
+      {"geoip": "private", "outbound": "direct"},
+      {"protocol": "bittorrent", "outbound": "direct"}
+    ],
+    "final": "auto"
+  }
+}`))
+	require.NoError(t, err)
+
+	cfg, err := config.Parse(result.Config)
+	require.NoError(t, err)
+	assert.Contains(t, cfg.Proxies, "tj")
+	assert.Contains(t, cfg.Proxies, "auto")
+
+	out := string(result.Config)
+	assert.Contains(t, out, "interval: 300")
+	assert.Contains(t, out, "DOMAIN-SUFFIX,youtube.com,auto")
+	assert.Contains(t, out, "AND,((DOMAIN-KEYWORD,ads),(OR,((DST-PORT,80),(DST-PORT,443)))),REJECT")
+	assert.Contains(t, out, "GEOIP,LAN,DIRECT")
+	assert.Contains(t, out, "MATCH,auto")
+
+	skipped := skippedItems(result)
+	assert.Contains(t, skipped, "hysteria2")
+	assert.Contains(t, skipped, "bittorrent")
+}
+
+func TestConvert_Unsupported(t *testing.T) {
+	_, err := Convert("clash", nil)
+	assert.Error(t, err)
+}
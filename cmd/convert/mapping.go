@@ -0,0 +1,74 @@
+package convert
+
+import (
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// mapping is a yaml mapping keeping the order of its keys, so the proxies and the
+// groups are written with their name and type first like a hand written config
+type mapping struct {
+	keys   []string
+	values map[string]any
+}
+
+func (m *mapping) set(key string, value any) {
+	if m.values == nil {
+		m.values = map[string]any{}
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *mapping) empty() bool {
+	return len(m.keys) == 0
+}
+
+// MarshalYAML implements yaml.Marshaler
+func (m *mapping) MarshalYAML() (any, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, key := range m.keys {
+		value := &yaml.Node{}
+		if err := value.Encode(m.values[key]); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	}
+	return node, nil
+}
+
+// encode sets the fields of the option struct v to m by their tag, the reverse of
+// common/structure. Embedded structs are flattened and the zero omitempty fields left out.
+func encode(m *mapping, tag string, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, value := t.Field(i), v.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			encode(m, tag, value)
+			continue
+		}
+
+		name, opts, _ := strings.Cut(field.Tag.Get(tag), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		omitEmpty := strings.Contains(opts, "omitempty")
+		if omitEmpty && value.IsZero() {
+			continue
+		}
+
+		if value.Kind() == reflect.Struct {
+			nested := &mapping{}
+			encode(nested, tag, value)
+			if !nested.empty() || !omitEmpty {
+				m.set(name, nested)
+			}
+			continue
+		}
+		m.set(name, value.Interface())
+	}
+}
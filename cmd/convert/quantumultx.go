@@ -0,0 +1,228 @@
+package convert
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Dreamacro/clash/adapter/outbound"
+)
+
+// quantumultXRules are the filter types of Quantumult X with a clash equivalent
+var quantumultXRules = map[string]string{
+	"host":         "DOMAIN",
+	"host-suffix":  "DOMAIN-SUFFIX",
+	"host-keyword": "DOMAIN-KEYWORD",
+	"ip-cidr":      "IP-CIDR",
+	"ip6-cidr":     "IP-CIDR6",
+	"geoip":        "GEOIP",
+	"final":        "MATCH",
+}
+
+func parseQuantumultX(b *builder, data string) {
+	lines(data, func(section, line string) {
+		switch section {
+		case "server_local":
+			parseQuantumultXServer(b, line)
+		case "policy":
+			parseQuantumultXPolicy(b, line)
+		case "filter_local":
+			parseQuantumultXFilter(b, line)
+		case "server_remote", "filter_remote":
+			b.skip(line, "the remote resources of [%s] aren't fetched, convert them separately", section)
+		}
+	})
+	// the builtin policies of Quantumult X
+	b.alias["direct"] = "DIRECT"
+	b.alias["reject"] = "REJECT"
+}
+
+func parseQuantumultXServer(b *builder, line string) {
+	tp, rest, ok := strings.Cut(line, "=")
+	if !ok {
+		b.skip(line, "expect type=host:port, ..., tag=name")
+		return
+	}
+	tp = strings.ToLower(strings.TrimSpace(tp))
+	values, params := fields(rest)
+	if len(values) == 0 {
+		b.skip(line, "missing the server address")
+		return
+	}
+	server, portStr, err := net.SplitHostPort(values[0])
+	if err != nil {
+		b.skip(line, "invalid server address %s", values[0])
+		return
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		b.skip(line, "invalid port %s", portStr)
+		return
+	}
+
+	name := params["tag"]
+	skipCertVerify := params["tls-verification"] == "false"
+	udp := isTrue(params["udp-relay"])
+	obfs, obfsHost, obfsURI := params["obfs"], params["obfs-host"], params["obfs-uri"]
+	switch tp {
+	case "shadowsocks":
+		option := outbound.ShadowSocksOption{
+			Name: name, Server: server, Port: port,
+			Cipher: params["method"], Password: params["password"], UDP: udp,
+		}
+		switch obfs {
+		case "":
+		case "http", "tls":
+			option.Plugin = "obfs"
+			option.PluginOpts = map[string]any{"mode": obfs, "host": obfsHost}
+		case "ws", "wss":
+			option.Plugin = "v2ray-plugin"
+			option.PluginOpts = map[string]any{
+				"mode": "websocket", "tls": obfs == "wss", "host": obfsHost, "path": obfsURI,
+				"skip-cert-verify": skipCertVerify,
+			}
+		default:
+			b.skip(line, "shadowsocks obfs %s has no clash equivalent", obfs)
+			return
+		}
+		b.addProxy(line, "ss", option)
+	case "vmess":
+		option := outbound.VmessOption{
+			Name: name, Server: server, Port: port,
+			UUID: params["password"], Cipher: params["method"], UDP: udp,
+			SkipCertVerify: skipCertVerify, ServerName: params["tls-host"],
+		}
+		if option.Cipher == "chacha20-ietf-poly1305" {
+			option.Cipher = "chacha20-poly1305"
+		}
+		switch obfs {
+		case "":
+		case "over-tls":
+			option.TLS = true
+		case "ws", "wss":
+			option.Network = "ws"
+			option.TLS = obfs == "wss"
+			option.WSOpts.Path = obfsURI
+			if obfsHost != "" {
+				option.WSOpts.Headers = map[string]string{"Host": obfsHost}
+			}
+		case "http":
+			option.Network = "http"
+			option.HTTPOpts.Path = []string{obfsURI}
+			if obfsHost != "" {
+				option.HTTPOpts.Headers = map[string][]string{"Host": {obfsHost}}
+			}
+		default:
+			b.skip(line, "vmess obfs %s has no clash equivalent", obfs)
+			return
+		}
+		if option.TLS && option.ServerName == "" {
+			option.ServerName = obfsHost
+		}
+		b.addProxy(line, "vmess", option)
+	case "trojan":
+		option := outbound.TrojanOption{
+			Name: name, Server: server, Port: port,
+			Password: params["password"], SNI: params["tls-host"], SkipCertVerify: skipCertVerify, UDP: udp,
+		}
+		switch obfs {
+		case "", "over-tls":
+		case "wss":
+			option.Network = "ws"
+			option.WSOpts.Path = obfsURI
+			if obfsHost != "" {
+				option.WSOpts.Headers = map[string]string{"Host": obfsHost}
+			}
+		default:
+			b.skip(line, "trojan obfs %s has no clash equivalent", obfs)
+			return
+		}
+		if option.SNI == "" {
+			option.SNI = obfsHost
+		}
+		b.addProxy(line, "trojan", option)
+	case "http":
+		b.addProxy(line, "http", outbound.HttpOption{
+			Name: name, Server: server, Port: port,
+			UserName: params["username"], Password: params["password"],
+			TLS: obfs == "over-tls", SNI: params["tls-host"], SkipCertVerify: skipCertVerify,
+		})
+	case "socks5":
+		b.addProxy(line, "socks5", outbound.Socks5Option{
+			Name: name, Server: server, Port: port,
+			UserName: params["username"], Password: params["password"],
+			TLS: obfs == "over-tls", UDP: udp, SkipCertVerify: skipCertVerify,
+		})
+	default:
+		b.skip(line, "server type %s has no clash equivalent", tp)
+	}
+}
+
+func parseQuantumultXPolicy(b *builder, line string) {
+	tp, rest, ok := strings.Cut(line, "=")
+	if !ok {
+		b.skip(line, "expect type=name, members, ...")
+		return
+	}
+	values, params := fields(rest)
+	if len(values) == 0 {
+		b.skip(line, "missing the policy name")
+		return
+	}
+
+	g := &group{item: line, name: values[0], members: values[1:], url: params["server-check-url"]}
+	g.interval, _ = strconv.Atoi(params["check-interval"])
+	g.tolerance, _ = strconv.Atoi(params["tolerance"])
+	switch tp = strings.ToLower(strings.TrimSpace(tp)); tp {
+	case "static":
+		g.tp = "select"
+	case "url-latency-benchmark":
+		g.tp = "url-test"
+	case "available":
+		g.tp = "fallback"
+	case "round-robin":
+		g.tp, g.strategy = "load-balance", "round-robin"
+	case "dest-hash":
+		g.tp, g.strategy = "load-balance", "consistent-hashing"
+	default:
+		b.skip(line, "policy type %s has no clash equivalent", tp)
+		return
+	}
+	b.groups = append(b.groups, g)
+}
+
+func parseQuantumultXFilter(b *builder, line string) {
+	values, _ := fields(line)
+	if len(values) == 0 {
+		return
+	}
+	tp := strings.ToLower(values[0])
+	clashType, ok := quantumultXRules[tp]
+	if !ok {
+		b.skip(line, "filter type %s has no clash equivalent", tp)
+		return
+	}
+
+	r := rule{item: line, tp: clashType}
+	if clashType == "MATCH" {
+		if len(values) < 2 {
+			b.skip(line, "missing the policy")
+			return
+		}
+		r.target = values[1]
+		b.addRule(r)
+		return
+	}
+
+	if len(values) < 3 {
+		b.skip(line, "expect type, value, policy")
+		return
+	}
+	r.payload, r.target = values[1], values[2]
+	for _, param := range values[3:] {
+		if param == "no-resolve" {
+			r.params = append(r.params, param)
+		}
+	}
+	b.addRule(r)
+}
@@ -0,0 +1,314 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/outbound"
+)
+
+// singBoxRules are the route rule fields of sing-box with a clash equivalent
+var singBoxRules = map[string]string{
+	"domain":         "DOMAIN",
+	"domain_suffix":  "DOMAIN-SUFFIX",
+	"domain_keyword": "DOMAIN-KEYWORD",
+	"ip_cidr":        "IP-CIDR",
+	"geoip":          "GEOIP",
+	"source_ip_cidr": "SRC-IP-CIDR",
+	"port":           "DST-PORT",
+	"source_port":    "SRC-PORT",
+	"process_name":   "PROCESS-NAME",
+}
+
+type singBoxTLS struct {
+	Enabled    bool     `json:"enabled"`
+	ServerName string   `json:"server_name"`
+	Insecure   bool     `json:"insecure"`
+	ALPN       []string `json:"alpn"`
+}
+
+type singBoxTransport struct {
+	Type        string            `json:"type"`
+	Path        string            `json:"path"`
+	Headers     map[string]string `json:"headers"`
+	Host        []string          `json:"host"`
+	ServiceName string            `json:"service_name"`
+}
+
+type singBoxOutbound struct {
+	Type       string            `json:"type"`
+	Tag        string            `json:"tag"`
+	Server     string            `json:"server"`
+	ServerPort int               `json:"server_port"`
+	Method     string            `json:"method"`
+	Password   string            `json:"password"`
+	Username   string            `json:"username"`
+	UUID       string            `json:"uuid"`
+	AlterID    int               `json:"alter_id"`
+	Security   string            `json:"security"`
+	Plugin     string            `json:"plugin"`
+	PluginOpts string            `json:"plugin_opts"`
+	Version    string            `json:"version"`
+	Network    string            `json:"network"`
+	TLS        *singBoxTLS       `json:"tls"`
+	Transport  *singBoxTransport `json:"transport"`
+
+	Outbounds []string `json:"outbounds"`
+	URL       string   `json:"url"`
+	Interval  string   `json:"interval"`
+	Tolerance int      `json:"tolerance"`
+}
+
+type singBoxConfig struct {
+	Outbounds []json.RawMessage `json:"outbounds"`
+	Route     struct {
+		Rules []map[string]json.RawMessage `json:"rules"`
+		Final string                       `json:"final"`
+	} `json:"route"`
+}
+
+func parseSingBox(b *builder, data []byte) error {
+	cfg := &singBoxConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("invalid sing-box config: %w", err)
+	}
+
+	for _, raw := range cfg.Outbounds {
+		o := &singBoxOutbound{}
+		if err := json.Unmarshal(raw, o); err != nil {
+			b.skip(string(raw), "%s", err)
+			continue
+		}
+		parseSingBoxOutbound(b, string(raw), o)
+	}
+
+	for _, r := range cfg.Route.Rules {
+		raw, _ := json.Marshal(r)
+		parseSingBoxRule(b, string(raw), r)
+	}
+	if cfg.Route.Final != "" {
+		b.addRule(rule{item: `"final": "` + cfg.Route.Final + `"`, tp: "MATCH", target: cfg.Route.Final})
+	}
+	return nil
+}
+
+func parseSingBoxOutbound(b *builder, item string, o *singBoxOutbound) {
+	tls := o.TLS
+	if tls == nil {
+		tls = &singBoxTLS{}
+	}
+	udp := o.Network == "" || o.Network == "udp"
+	switch o.Type {
+	case "direct":
+		b.alias[o.Tag] = "DIRECT"
+	case "block":
+		b.alias[o.Tag] = "REJECT"
+	case "shadowsocks":
+		option := outbound.ShadowSocksOption{
+			Name: o.Tag, Server: o.Server, Port: o.ServerPort,
+			Cipher: o.Method, Password: o.Password, UDP: udp,
+		}
+		switch o.Plugin {
+		case "":
+		case "obfs-local":
+			opts := map[string]string{}
+			for _, kv := range strings.Split(o.PluginOpts, ";") {
+				if key, value, ok := strings.Cut(kv, "="); ok {
+					opts[strings.TrimSpace(key)] = strings.TrimSpace(value)
+				}
+			}
+			option.Plugin = "obfs"
+			option.PluginOpts = map[string]any{"mode": opts["obfs"], "host": opts["obfs-host"]}
+		default:
+			b.skip(item, "shadowsocks plugin %s has no clash equivalent", o.Plugin)
+			return
+		}
+		b.addProxy(item, "ss", option)
+	case "vmess":
+		option := outbound.VmessOption{
+			Name: o.Tag, Server: o.Server, Port: o.ServerPort,
+			UUID: o.UUID, AlterID: o.AlterID, Cipher: o.Security, UDP: udp,
+			TLS: tls.Enabled, ServerName: tls.ServerName, SkipCertVerify: tls.Insecure,
+		}
+		if option.Cipher == "" {
+			option.Cipher = "auto"
+		}
+		if o.Transport != nil {
+			switch t := o.Transport; t.Type {
+			case "ws":
+				option.Network = "ws"
+				option.WSOpts = outbound.WSOptions{Path: t.Path, Headers: t.Headers}
+			case "grpc":
+				option.Network = "grpc"
+				option.GrpcOpts.GrpcServiceName = t.ServiceName
+			case "http":
+				option.Network = "h2"
+				option.HTTP2Opts = outbound.HTTP2Options{Host: t.Host, Path: t.Path}
+			default:
+				b.skip(item, "vmess transport %s has no clash equivalent", t.Type)
+				return
+			}
+		}
+		b.addProxy(item, "vmess", option)
+	case "trojan":
+		option := outbound.TrojanOption{
+			Name: o.Tag, Server: o.Server, Port: o.ServerPort,
+			Password: o.Password, ALPN: tls.ALPN, SNI: tls.ServerName, SkipCertVerify: tls.Insecure, UDP: udp,
+		}
+		if o.Transport != nil {
+			switch t := o.Transport; t.Type {
+			case "ws":
+				option.Network = "ws"
+				option.WSOpts = outbound.WSOptions{Path: t.Path, Headers: t.Headers}
+			case "grpc":
+				option.Network = "grpc"
+				option.GrpcOpts.GrpcServiceName = t.ServiceName
+			default:
+				b.skip(item, "trojan transport %s has no clash equivalent", t.Type)
+				return
+			}
+		}
+		b.addProxy(item, "trojan", option)
+	case "socks":
+		if o.Version != "" && o.Version != "5" {
+			b.skip(item, "socks version %s has no clash equivalent", o.Version)
+			return
+		}
+		b.addProxy(item, "socks5", outbound.Socks5Option{
+			Name: o.Tag, Server: o.Server, Port: o.ServerPort,
+			UserName: o.Username, Password: o.Password, UDP: udp,
+		})
+	case "http":
+		b.addProxy(item, "http", outbound.HttpOption{
+			Name: o.Tag, Server: o.Server, Port: o.ServerPort,
+			UserName: o.Username, Password: o.Password,
+			TLS: tls.Enabled, SNI: tls.ServerName, SkipCertVerify: tls.Insecure,
+		})
+	case "selector", "urltest":
+		g := &group{item: item, name: o.Tag, tp: "select", members: o.Outbounds}
+		if o.Type == "urltest" {
+			g.tp, g.url, g.tolerance = "url-test", o.URL, o.Tolerance
+			if o.Interval != "" {
+				interval, err := time.ParseDuration(o.Interval)
+				if err != nil {
+					b.skip(item, "invalid interval %s", o.Interval)
+					return
+				}
+				g.interval = int(interval / time.Second)
+			}
+		}
+		b.groups = append(b.groups, g)
+	default:
+		b.skip(item, "outbound type %s has no clash equivalent", o.Type)
+	}
+}
+
+func parseSingBoxRule(b *builder, item string, r map[string]json.RawMessage) {
+	var target string
+	if raw, ok := r["action"]; ok {
+		var action string
+		json.Unmarshal(raw, &action)
+		switch action {
+		case "route":
+		case "reject":
+			target = "REJECT"
+		default:
+			b.skip(item, "rule action %s has no clash equivalent", action)
+			return
+		}
+	}
+	if target == "" {
+		if err := json.Unmarshal(r["outbound"], &target); err != nil || target == "" {
+			b.skip(item, "missing the outbound")
+			return
+		}
+	}
+
+	// the fields of a rule are and-ed, the values of a field or-ed
+	keys := []string{}
+	for key := range r {
+		if key != "outbound" && key != "action" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	conditions := [][]string{}
+	for _, key := range keys {
+		tp, ok := singBoxRules[key]
+		if !ok {
+			b.skip(item, "rule field %s has no clash equivalent", key)
+			return
+		}
+		values, err := listable(r[key])
+		if err != nil {
+			b.skip(item, "invalid %s: %s", key, err)
+			return
+		}
+		condition := []string{}
+		for _, value := range values {
+			if tp == "GEOIP" && strings.EqualFold(value, "private") {
+				value = "LAN"
+			}
+			if tp == "SRC-IP-CIDR" && !strings.Contains(value, "/") {
+				if strings.Contains(value, ":") {
+					value += "/128"
+				} else {
+					value += "/32"
+				}
+			}
+			condition = append(condition, tp+","+value)
+		}
+		if len(condition) > 0 {
+			conditions = append(conditions, condition)
+		}
+	}
+
+	switch len(conditions) {
+	case 0:
+		b.skip(item, "the rule has no condition")
+	case 1:
+		for _, condition := range conditions[0] {
+			tp, payload, _ := strings.Cut(condition, ",")
+			b.addRule(rule{item: item, tp: tp, payload: payload, target: target})
+		}
+	default:
+		subs := []string{}
+		for _, condition := range conditions {
+			if len(condition) == 1 {
+				subs = append(subs, "("+condition[0]+")")
+			} else {
+				subs = append(subs, "(OR,(("+strings.Join(condition, "),(")+")))")
+			}
+		}
+		b.addRule(rule{item: item, tp: "AND", payload: "(" + strings.Join(subs, ",") + ")", target: target})
+	}
+}
+
+// listable decodes a field given either as a single value or as a list of them
+func listable(raw json.RawMessage) ([]string, error) {
+	var values []any
+	if err := json.Unmarshal(raw, &values); err != nil {
+		var value any
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, err
+		}
+		values = []any{value}
+	}
+
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			result = append(result, v)
+		case float64:
+			result = append(result, fmt.Sprint(v))
+		default:
+			return nil, fmt.Errorf("unexpected value %v", v)
+		}
+	}
+	return result, nil
+}
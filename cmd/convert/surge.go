@@ -0,0 +1,328 @@
+package convert
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Dreamacro/clash/adapter/outbound"
+	R "github.com/Dreamacro/clash/rule"
+)
+
+// surgeRules are the rule types of Surge with a clash equivalent
+var surgeRules = map[string]string{
+	"DOMAIN":         "DOMAIN",
+	"DOMAIN-SUFFIX":  "DOMAIN-SUFFIX",
+	"DOMAIN-KEYWORD": "DOMAIN-KEYWORD",
+	"IP-CIDR":        "IP-CIDR",
+	"IP-CIDR6":       "IP-CIDR6",
+	"GEOIP":          "GEOIP",
+	"SRC-IP":         "SRC-IP-CIDR",
+	"SRC-PORT":       "SRC-PORT",
+	"DEST-PORT":      "DST-PORT",
+	"DST-PORT":       "DST-PORT",
+	"PROCESS-NAME":   "PROCESS-NAME",
+	"AND":            "AND",
+	"OR":             "OR",
+	"NOT":            "NOT",
+	"FINAL":          "MATCH",
+}
+
+// lines return the lines of an ini like config by section, without the blank lines and comments
+func lines(data string, fn func(section, line string)) {
+	section := ""
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "", strings.HasPrefix(line, "#"), strings.HasPrefix(line, ";"), strings.HasPrefix(line, "//"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+		fn(section, line)
+	}
+}
+
+// fields splits a comma separated list into the positional values and the key=value ones
+func fields(s string) (values []string, params map[string]string) {
+	params = map[string]string{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if key, value, ok := strings.Cut(part, "="); ok {
+			params[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		} else if part != "" {
+			values = append(values, part)
+		}
+	}
+	return
+}
+
+func isTrue(s string) bool {
+	b, _ := strconv.ParseBool(s)
+	return b
+}
+
+// headers parses Host:example.com|User-Agent:curl
+func headers(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+	h := map[string]string{}
+	for _, pair := range strings.Split(s, "|") {
+		if key, value, ok := strings.Cut(pair, ":"); ok {
+			h[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return h
+}
+
+func parseSurge(b *builder, data string) {
+	lines(data, func(section, line string) {
+		switch section {
+		case "proxy":
+			parseSurgeProxy(b, line)
+		case "proxy group":
+			parseSurgeGroup(b, line)
+		case "rule":
+			parseSurgeRule(b, line)
+		}
+	})
+	// the builtin reject policies of Surge, clash has only the one
+	for _, name := range []string{"REJECT-TINYGIF", "REJECT-DROP", "REJECT-NO-DROP"} {
+		b.alias[name] = "REJECT"
+	}
+}
+
+func parseSurgeProxy(b *builder, line string) {
+	name, rest, ok := strings.Cut(line, "=")
+	if !ok {
+		b.skip(line, "expect name = type, server, port, ...")
+		return
+	}
+	name = strings.TrimSpace(name)
+	values, params := fields(rest)
+	if len(values) == 0 {
+		b.skip(line, "missing the proxy type")
+		return
+	}
+
+	tp := strings.ToLower(values[0])
+	switch tp {
+	case "direct":
+		b.alias[name] = "DIRECT"
+		return
+	case "reject", "reject-tinygif", "reject-drop", "reject-no-drop":
+		b.alias[name] = "REJECT"
+		return
+	}
+
+	if len(values) < 3 {
+		b.skip(line, "expect name = type, server, port, ...")
+		return
+	}
+	server := values[1]
+	port, err := strconv.Atoi(values[2])
+	if err != nil {
+		b.skip(line, "invalid port %s", values[2])
+		return
+	}
+	// http and socks5 take the credentials positionally too
+	if len(values) >= 5 {
+		if params["username"] == "" {
+			params["username"] = values[3]
+		}
+		if params["password"] == "" {
+			params["password"] = values[4]
+		}
+	}
+
+	skipCertVerify := isTrue(params["skip-cert-verify"])
+	udp := isTrue(params["udp-relay"])
+	switch tp {
+	case "ss":
+		option := outbound.ShadowSocksOption{
+			Name: name, Server: server, Port: port,
+			Cipher: params["encrypt-method"], Password: params["password"], UDP: udp,
+		}
+		if obfs := params["obfs"]; obfs != "" {
+			option.Plugin = "obfs"
+			option.PluginOpts = map[string]any{"mode": obfs, "host": params["obfs-host"]}
+		}
+		b.addProxy(line, "ss", option)
+	case "vmess":
+		option := outbound.VmessOption{
+			Name: name, Server: server, Port: port,
+			UUID: params["username"], Cipher: "auto", UDP: udp,
+			TLS: isTrue(params["tls"]), ServerName: params["sni"], SkipCertVerify: skipCertVerify,
+		}
+		if isTrue(params["ws"]) {
+			option.Network = "ws"
+			option.WSOpts = outbound.WSOptions{Path: params["ws-path"], Headers: headers(params["ws-headers"])}
+		}
+		b.addProxy(line, "vmess", option)
+	case "trojan":
+		option := outbound.TrojanOption{
+			Name: name, Server: server, Port: port,
+			Password: params["password"], SNI: params["sni"], SkipCertVerify: skipCertVerify, UDP: udp,
+		}
+		if isTrue(params["ws"]) {
+			option.Network = "ws"
+			option.WSOpts = outbound.WSOptions{Path: params["ws-path"], Headers: headers(params["ws-headers"])}
+		}
+		b.addProxy(line, "trojan", option)
+	case "http", "https":
+		b.addProxy(line, "http", outbound.HttpOption{
+			Name: name, Server: server, Port: port,
+			UserName: params["username"], Password: params["password"],
+			TLS: tp == "https", SNI: params["sni"], SkipCertVerify: skipCertVerify,
+		})
+	case "socks5", "socks5-tls":
+		b.addProxy(line, "socks5", outbound.Socks5Option{
+			Name: name, Server: server, Port: port,
+			UserName: params["username"], Password: params["password"],
+			TLS: tp == "socks5-tls", UDP: udp, SkipCertVerify: skipCertVerify,
+		})
+	case "snell":
+		option := outbound.SnellOption{Name: name, Server: server, Port: port, Psk: params["psk"], UDP: udp}
+		option.Version, _ = strconv.Atoi(params["version"])
+		if obfs := params["obfs"]; obfs != "" {
+			option.ObfsOpts = map[string]any{"mode": obfs, "host": params["obfs-host"]}
+		}
+		b.addProxy(line, "snell", option)
+	default:
+		b.skip(line, "proxy type %s has no clash equivalent", tp)
+	}
+}
+
+func parseSurgeGroup(b *builder, line string) {
+	name, rest, ok := strings.Cut(line, "=")
+	if !ok {
+		b.skip(line, "expect name = type, members, ...")
+		return
+	}
+	values, params := fields(rest)
+	if len(values) == 0 {
+		b.skip(line, "missing the group type")
+		return
+	}
+
+	g := &group{item: line, name: strings.TrimSpace(name), members: values[1:], url: params["url"]}
+	g.interval, _ = strconv.Atoi(params["interval"])
+	g.tolerance, _ = strconv.Atoi(params["tolerance"])
+	switch tp := strings.ToLower(values[0]); tp {
+	case "select", "url-test", "fallback", "load-balance":
+		g.tp = tp
+	default:
+		b.skip(line, "group type %s has no clash equivalent", tp)
+		return
+	}
+	if params["policy-path"] != "" || params["include-other-group"] != "" || isTrue(params["include-all-proxies"]) {
+		b.skip(line, "the members from policy-path or include-* are not converted, only the listed ones")
+	}
+	b.groups = append(b.groups, g)
+}
+
+func parseSurgeRule(b *builder, line string) {
+	tp, rest, _ := strings.Cut(line, ",")
+	tp = strings.ToUpper(strings.TrimSpace(tp))
+	clashType, ok := surgeRules[tp]
+	if !ok {
+		b.skip(line, "rule type %s has no clash equivalent", tp)
+		return
+	}
+
+	r := rule{item: line, tp: clashType}
+	if clashType == "AND" || clashType == "OR" || clashType == "NOT" {
+		payload, rest, err := R.SplitLogicPayload(rest)
+		if err != nil {
+			b.skip(line, "%s", err)
+			return
+		}
+		if r.payload, err = surgeLogic(payload); err != nil {
+			b.skip(line, "%s", err)
+			return
+		}
+		values, _ := fields(rest)
+		if len(values) == 0 {
+			b.skip(line, "missing the policy")
+			return
+		}
+		r.target = values[0]
+		b.addRule(r)
+		return
+	}
+
+	values, _ := fields(rest)
+	if clashType == "MATCH" {
+		if len(values) == 0 {
+			b.skip(line, "missing the policy")
+			return
+		}
+		r.target = values[0]
+		b.addRule(r)
+		return
+	}
+
+	if len(values) < 2 {
+		b.skip(line, "expect type, value, policy")
+		return
+	}
+	r.payload, r.target = values[0], values[1]
+	if clashType == "SRC-IP-CIDR" && !strings.Contains(r.payload, "/") {
+		if strings.Contains(r.payload, ":") {
+			r.payload += "/128"
+		} else {
+			r.payload += "/32"
+		}
+	}
+	for _, param := range values[2:] {
+		if param == "no-resolve" {
+			r.params = append(r.params, param)
+		}
+	}
+	b.addRule(r)
+}
+
+// surgeLogic renames the types of the sub rules of a logic payload, ((DEST-PORT,443),(...))
+func surgeLogic(payload string) (string, error) {
+	inner := strings.TrimSpace(payload)
+	if len(inner) < 2 || inner[0] != '(' || inner[len(inner)-1] != ')' {
+		return "", fmt.Errorf("invalid logic payload %s", payload)
+	}
+	inner = inner[1 : len(inner)-1]
+
+	subs := []string{}
+	depth, start := 0, 0
+	for i, c := range inner {
+		switch c {
+		case '(':
+			if depth == 0 {
+				start = i + 1
+			}
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				subs = append(subs, inner[start:i])
+			}
+		}
+	}
+
+	for i, sub := range subs {
+		tp, rest, _ := strings.Cut(sub, ",")
+		tp = strings.ToUpper(strings.TrimSpace(tp))
+		clashType, ok := surgeRules[tp]
+		if !ok || clashType == "MATCH" {
+			return "", fmt.Errorf("sub rule type %s has no clash equivalent", tp)
+		}
+		if clashType == "AND" || clashType == "OR" || clashType == "NOT" {
+			nested, err := surgeLogic(rest)
+			if err != nil {
+				return "", err
+			}
+			rest = nested
+		}
+		subs[i] = "(" + clashType + "," + strings.TrimSpace(rest) + ")"
+	}
+	return "(" + strings.Join(subs, ",") + ")", nil
+}
@@ -103,6 +103,20 @@ func (c *LruCache) GetWithExpire(key any) (any, time.Time, bool) {
 	return entry.value, time.Unix(entry.expires, 0), true
 }
 
+// PeekWithExpire is GetWithExpire without moving the element to the back of the list, a
+// lookup aside of the users of the cache doesn't keep an element from being evicted
+func (c *LruCache) PeekWithExpire(key any) (any, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	le, ok := c.cache[key]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	e := le.Value.(*entry)
+	return e.value, time.Unix(e.expires, 0), true
+}
+
 // Exist returns if key exist in cache but not put item to the head of linked list
 func (c *LruCache) Exist(key any) bool {
 	c.mu.Lock()
@@ -146,6 +160,29 @@ func (c *LruCache) SetWithExpire(key any, value any, expires time.Time) {
 	c.maybeDeleteOldest()
 }
 
+// Clear removes every element without calling the evict callback and return how many there were
+func (c *LruCache) Clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := c.lru.Len()
+	c.lru = list.New()
+	c.cache = make(map[any]*list.Element)
+	return n
+}
+
+// Purge removes every element, calling the evict callback for each, and return how many there were
+func (c *LruCache) Purge() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := c.lru.Len()
+	for le := c.lru.Front(); le != nil; le = c.lru.Front() {
+		c.deleteElement(le)
+	}
+	return n
+}
+
 // CloneTo clone and overwrite elements to another LruCache
 func (c *LruCache) CloneTo(n *LruCache) {
 	c.mu.Lock()
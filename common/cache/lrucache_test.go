@@ -137,6 +137,19 @@ func TestEvict(t *testing.T) {
 	assert.Equal(t, temp, 3)
 }
 
+func TestPurge(t *testing.T) {
+	evicted := map[any]any{}
+	c := New(WithEvict(func(key any, value any) {
+		evicted[key] = value
+	}))
+	c.Set(1, 2)
+	c.Set(3, 4)
+
+	assert.Equal(t, 2, c.Purge())
+	assert.Equal(t, map[any]any{1: 2, 3: 4}, evicted)
+	assert.False(t, c.Exist(1))
+}
+
 func TestSetWithExpire(t *testing.T) {
 	c := New(WithAge(1))
 	now := time.Now().Unix()
@@ -181,3 +194,21 @@ func TestCloneTo(t *testing.T) {
 	n.Set("5", 5)
 	assert.False(t, n.Exist("1"))
 }
+
+func TestPeekWithExpire(t *testing.T) {
+	c := New(WithSize(2))
+	expires := time.Unix(time.Now().Unix()+10, 0)
+	c.SetWithExpire(1, 1, expires)
+	c.Set(2, 2)
+
+	res, exp, exist := c.PeekWithExpire(1)
+	assert.Equal(t, 1, res)
+	assert.Equal(t, expires, exp)
+	assert.True(t, exist)
+
+	// peeking left 1 the oldest
+	c.Set(3, 3)
+	assert.False(t, c.Exist(1))
+	_, _, exist = c.PeekWithExpire(1)
+	assert.False(t, exist)
+}
@@ -0,0 +1,82 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+const (
+	protocolTCP = 6
+	protocolUDP = 17
+
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+)
+
+// MaxPayload is the largest payload fitting into a single synthesized packet
+const MaxPayload = 0xFFFF - 60 - 20
+
+// TCPSegment synthesizes an IP packet carrying a PSH|ACK tcp segment,
+// checksums of the transport layer are left empty
+func TCPSegment(src, dst netip.AddrPort, seq, ack uint32, payload []byte) []byte {
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[0:], src.Port())
+	binary.BigEndian.PutUint16(tcp[2:], dst.Port())
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4 // data offset
+	tcp[13] = tcpFlagPSH | tcpFlagACK
+	binary.BigEndian.PutUint16(tcp[14:], 0xFFFF) // window
+
+	return ipPacket(src.Addr(), dst.Addr(), protocolTCP, tcp, payload)
+}
+
+// UDPDatagram synthesizes an IP packet carrying a udp datagram
+func UDPDatagram(src, dst netip.AddrPort, payload []byte) []byte {
+	udp := make([]byte, 8)
+	binary.BigEndian.PutUint16(udp[0:], src.Port())
+	binary.BigEndian.PutUint16(udp[2:], dst.Port())
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+
+	return ipPacket(src.Addr(), dst.Addr(), protocolUDP, udp, payload)
+}
+
+func ipPacket(src, dst netip.Addr, protocol byte, l4 []byte, payload []byte) []byte {
+	src, dst = src.Unmap(), dst.Unmap()
+	l4Len := len(l4) + len(payload)
+
+	// use IPv6 when the families differ, IPv4 addresses are mapped
+	if src.Is4() && dst.Is4() {
+		pkt := make([]byte, 20, 20+l4Len)
+		pkt[0] = 4<<4 | 5
+		binary.BigEndian.PutUint16(pkt[2:], uint16(20+l4Len))
+		pkt[8] = 64 // ttl
+		pkt[9] = protocol
+		s, d := src.As4(), dst.As4()
+		copy(pkt[12:16], s[:])
+		copy(pkt[16:20], d[:])
+		binary.BigEndian.PutUint16(pkt[10:], ipv4Checksum(pkt))
+		return append(append(pkt, l4...), payload...)
+	}
+
+	pkt := make([]byte, 40, 40+l4Len)
+	pkt[0] = 6 << 4
+	binary.BigEndian.PutUint16(pkt[4:], uint16(l4Len))
+	pkt[6] = protocol
+	pkt[7] = 64 // hop limit
+	s, d := src.As16(), dst.As16()
+	copy(pkt[8:24], s[:])
+	copy(pkt[24:40], d[:])
+	return append(append(pkt, l4...), payload...)
+}
+
+func ipv4Checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,82 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+const (
+	blockSectionHeader   = 0x0A0D0D0A
+	blockInterface       = 0x00000001
+	blockEnhancedPacket  = 0x00000006
+	byteOrderMagic       = 0x1A2B3C4D
+	LinkTypeRaw          = 101 // raw IPv4/IPv6, no link layer header
+	defaultSnapLen       = 0   // no limit
+	blockHeaderTrailSize = 12  // type + total length + trailing total length
+)
+
+// Writer writes packets in pcap-ng format with a single interface
+type Writer struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewWriter writes the section header and the interface description block
+func NewWriter(w io.Writer, linkType uint16) (*Writer, error) {
+	pw := &Writer{w: w}
+
+	// section header: byte-order magic, version 1.0, section length unknown
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], byteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:], 1)
+	binary.LittleEndian.PutUint16(shb[6:], 0)
+	binary.LittleEndian.PutUint64(shb[8:], 0xFFFFFFFFFFFFFFFF)
+	if err := pw.writeBlock(blockSectionHeader, shb); err != nil {
+		return nil, err
+	}
+
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], linkType)
+	binary.LittleEndian.PutUint32(idb[4:], defaultSnapLen)
+	if err := pw.writeBlock(blockInterface, idb); err != nil {
+		return nil, err
+	}
+
+	return pw, nil
+}
+
+// WritePacket writes an enhanced packet block, timestamps use the default microsecond resolution
+func (pw *Writer) WritePacket(t time.Time, data []byte) (int, error) {
+	ts := uint64(t.UnixMicro())
+
+	body := make([]byte, 20+pad4(len(data)))
+	binary.LittleEndian.PutUint32(body[0:], 0) // interface id
+	binary.LittleEndian.PutUint32(body[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(len(data)))
+	copy(body[20:], data)
+
+	if err := pw.writeBlock(blockEnhancedPacket, body); err != nil {
+		return 0, err
+	}
+	return len(body) + blockHeaderTrailSize, nil
+}
+
+func (pw *Writer) writeBlock(blockType uint32, body []byte) error {
+	total := uint32(len(body) + blockHeaderTrailSize)
+
+	pw.buf = pw.buf[:0]
+	pw.buf = binary.LittleEndian.AppendUint32(pw.buf, blockType)
+	pw.buf = binary.LittleEndian.AppendUint32(pw.buf, total)
+	pw.buf = append(pw.buf, body...)
+	pw.buf = binary.LittleEndian.AppendUint32(pw.buf, total)
+
+	_, err := pw.w.Write(pw.buf)
+	return err
+}
+
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,48 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriter_Blocks(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, LinkTypeRaw)
+	assert.NoError(t, err)
+
+	headerLen := buf.Len()
+	n, err := w.WritePacket(time.Now(), []byte{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, buf.Len()-headerLen, n)
+
+	data := buf.Bytes()
+	assert.Equal(t, uint32(blockSectionHeader), binary.LittleEndian.Uint32(data))
+	assert.Equal(t, uint32(byteOrderMagic), binary.LittleEndian.Uint32(data[8:]))
+
+	// every block starts and ends with its total length, 4 bytes aligned
+	for len(data) > 0 {
+		total := binary.LittleEndian.Uint32(data[4:])
+		assert.Zero(t, total%4)
+		assert.Equal(t, total, binary.LittleEndian.Uint32(data[total-4:]))
+		data = data[total:]
+	}
+}
+
+func TestPacket_Family(t *testing.T) {
+	v4 := netip.MustParseAddrPort("127.0.0.1:1080")
+	v6 := netip.MustParseAddrPort("[::1]:443")
+
+	pkt := TCPSegment(v4, v4, 1, 1, []byte("hello"))
+	assert.Equal(t, byte(4), pkt[0]>>4)
+	assert.Equal(t, 20+20+5, len(pkt))
+	assert.Zero(t, ipv4Checksum(pkt[:20]))
+
+	pkt = UDPDatagram(v4, v6, []byte("hello"))
+	assert.Equal(t, byte(6), pkt[0]>>4)
+	assert.Equal(t, uint16(8+5), binary.BigEndian.Uint16(pkt[4:]))
+}
@@ -0,0 +1,168 @@
+package clientcert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+)
+
+const (
+	// reloadInterval is how often the files are checked for rotation on handshake
+	reloadInterval = time.Minute
+	// expiryWarning warns at load when the certificate expires sooner
+	expiryWarning = 30 * 24 * time.Hour
+)
+
+// Option is a client certificate for mutual tls, Cert and Key are a file path or inline PEM.
+// Passphrase decrypts a legacy encrypted PEM key.
+type Option struct {
+	Cert       string
+	Key        string
+	Passphrase string
+}
+
+func (o Option) empty() bool {
+	return o.Cert == "" && o.Key == ""
+}
+
+// Certificate serves the client certificate to the handshakes, the files are read again
+// when they changed so a rotated certificate is picked up without a reload
+type Certificate struct {
+	option Option
+
+	mux     sync.Mutex
+	cert    *tls.Certificate
+	stamp   string
+	checked time.Time
+}
+
+// New loads the certificate of opt, it return nil when opt sets none
+func New(opt Option) (*Certificate, error) {
+	if opt.empty() {
+		return nil, nil
+	}
+	if opt.Cert == "" || opt.Key == "" {
+		return nil, errors.New("client-cert and client-key must be set together")
+	}
+
+	c := &Certificate{option: opt, checked: time.Now()}
+	c.stamp = c.fileStamp()
+	cert, err := load(opt)
+	if err != nil {
+		return nil, err
+	}
+	if remain := time.Until(cert.Leaf.NotAfter); remain < expiryWarning {
+		log.Warnln("[TLS] client certificate %s expires in %d days", cert.Leaf.Subject.CommonName, int(remain.Hours()/24))
+	}
+	c.cert = cert
+	return c, nil
+}
+
+// Apply sets the certificate to config, it's a no-op on a nil Certificate
+func (c *Certificate) Apply(config *tls.Config) *tls.Config {
+	if c != nil {
+		config.GetClientCertificate = c.GetClientCertificate
+	}
+	return config
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate
+func (c *Certificate) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if time.Since(c.checked) >= reloadInterval {
+		c.checked = time.Now()
+		if stamp := c.fileStamp(); stamp != c.stamp {
+			cert, err := load(c.option)
+			if err != nil {
+				log.Warnln("[TLS] reload client certificate failed, keep the old one: %s", err)
+			} else {
+				log.Infoln("[TLS] client certificate %s reloaded", cert.Leaf.Subject.CommonName)
+				c.cert = cert
+				c.stamp = stamp
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+// fileStamp identifies the content of the files, inline PEM never changes
+func (c *Certificate) fileStamp() string {
+	stamp := ""
+	for _, s := range []string{c.option.Cert, c.option.Key} {
+		if isPEM(s) {
+			continue
+		}
+		if info, err := os.Stat(C.Path.Resolve(s)); err == nil {
+			stamp += fmt.Sprintf("%d:%d;", info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return stamp
+}
+
+func load(opt Option) (*tls.Certificate, error) {
+	certPEM, err := read(opt.Cert)
+	if err != nil {
+		return nil, fmt.Errorf("client-cert: %w", err)
+	}
+	keyPEM, err := read(opt.Key)
+	if err != nil {
+		return nil, fmt.Errorf("client-key: %w", err)
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("client-key: no PEM data found")
+	}
+	switch {
+	case block.Type == "ENCRYPTED PRIVATE KEY":
+		return nil, errors.New("client-key: encrypted PKCS#8 keys are not supported, decrypt it or use a legacy encrypted PEM")
+	case x509.IsEncryptedPEMBlock(block): //nolint:staticcheck // legacy encrypted PEM, what openssl -des3 writes
+		if opt.Passphrase == "" {
+			return nil, errors.New("client-key is encrypted, client-key-passphrase is required")
+		}
+		der, err := x509.DecryptPEMBlock(block, []byte(opt.Passphrase)) //nolint:staticcheck
+		if err != nil {
+			return nil, fmt.Errorf("client-key: %w", err)
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("client-cert and client-key: %w", err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("client-cert: %w", err)
+	}
+
+	now := time.Now()
+	if now.After(cert.Leaf.NotAfter) {
+		return nil, fmt.Errorf("client-cert %s expired at %s", cert.Leaf.Subject.CommonName, cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(cert.Leaf.NotBefore) {
+		return nil, fmt.Errorf("client-cert %s is not valid before %s", cert.Leaf.Subject.CommonName, cert.Leaf.NotBefore.Format(time.RFC3339))
+	}
+	return &cert, nil
+}
+
+func read(s string) ([]byte, error) {
+	if isPEM(s) {
+		return []byte(s), nil
+	}
+	return os.ReadFile(C.Path.Resolve(s))
+}
+
+func isPEM(s string) bool {
+	return strings.Contains(s, "-----BEGIN")
+}
@@ -0,0 +1,96 @@
+package clientcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newKeyPair(t *testing.T, name string, notAfter time.Time) (certPEM, keyPEM string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return
+}
+
+func TestNew(t *testing.T) {
+	valid := time.Now().Add(90 * 24 * time.Hour)
+	certPEM, keyPEM := newKeyPair(t, "client", valid)
+	_, otherKey := newKeyPair(t, "other", valid)
+	expiredCert, expiredKey := newKeyPair(t, "expired", time.Now().Add(-time.Minute))
+
+	c, err := New(Option{})
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	c, err = New(Option{Cert: certPEM, Key: keyPEM})
+	require.NoError(t, err)
+	cert, err := c.GetClientCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "client", cert.Leaf.Subject.CommonName)
+
+	_, err = New(Option{Cert: certPEM})
+	assert.Error(t, err)
+
+	_, err = New(Option{Cert: certPEM, Key: otherKey})
+	assert.ErrorContains(t, err, "does not match")
+
+	_, err = New(Option{Cert: expiredCert, Key: expiredKey})
+	assert.ErrorContains(t, err, "expired")
+}
+
+func TestCertificate_Rotate(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	write := func(name string) {
+		certPEM, keyPEM := newKeyPair(t, name, time.Now().Add(90*24*time.Hour))
+		require.NoError(t, os.WriteFile(certPath, []byte(certPEM), 0o600))
+		require.NoError(t, os.WriteFile(keyPath, []byte(keyPEM), 0o600))
+	}
+
+	write("before")
+	c, err := New(Option{Cert: certPath, Key: keyPath})
+	require.NoError(t, err)
+
+	write("after")
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(certPath, future, future)
+
+	// the files are only checked every reloadInterval
+	cert, _ := c.GetClientCertificate(nil)
+	assert.Equal(t, "before", cert.Leaf.Subject.CommonName)
+
+	c.checked = time.Now().Add(-reloadInterval)
+	cert, _ = c.GetClientCertificate(nil)
+	assert.Equal(t, "after", cert.Leaf.Subject.CommonName)
+
+	// a broken rotation keeps the loaded certificate
+	require.NoError(t, os.WriteFile(keyPath, []byte("broken"), 0o600))
+	c.checked = time.Now().Add(-reloadInterval)
+	cert, _ = c.GetClientCertificate(nil)
+	assert.Equal(t, "after", cert.Leaf.Subject.CommonName)
+}
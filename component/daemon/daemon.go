@@ -0,0 +1,57 @@
+// Package daemon reports the state of clash to the service manager running it, systemd
+// through sd_notify and the Windows service control manager
+package daemon
+
+import (
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+var (
+	health       = atomic.NewPointer[func() error](nil)
+	watchdogOnce sync.Once
+)
+
+// SetHealthCheck sets the check the watchdog heartbeats are tied to, no heartbeat is
+// sent while it fails
+func SetHealthCheck(fn func() error) {
+	health.Store(&fn)
+}
+
+func healthy() error {
+	if fn := health.Load(); fn != nil && *fn != nil {
+		return (*fn)()
+	}
+	return nil
+}
+
+// Ready reports the config applied and the listeners bound, the watchdog starts with it
+func Ready() {
+	notify("READY=1", "STATUS=running")
+	watchdogOnce.Do(startWatchdog)
+}
+
+// Reloading reports a new config being applied
+func Reloading() {
+	notify("RELOADING=1", monotonicUsec(), "STATUS=reloading the config")
+}
+
+// Reloaded reports the end of a reload, err is what failed to apply
+func Reloaded(err error) {
+	status := "STATUS=running"
+	if err != nil {
+		status = "STATUS=running, the last reload failed: " + err.Error()
+	}
+	notify("READY=1", status)
+}
+
+// Failed reports the error clash exits with on startup
+func Failed(err error) {
+	notify("STATUS=failed: " + err.Error())
+}
+
+// Stopping reports the shutdown started
+func Stopping() {
+	notify("STOPPING=1", "STATUS=stopping")
+}
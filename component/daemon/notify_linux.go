@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dreamacro/clash/log"
+
+	"golang.org/x/sys/unix"
+)
+
+// notify sends the state lines to the socket of systemd, it's a no-op outside a
+// Type=notify unit
+func notify(lines ...string) {
+	if err := sdNotify(os.Getenv("NOTIFY_SOCKET"), strings.Join(lines, "\n")); err != nil {
+		log.Warnln("[Daemon] sd_notify: %s", err)
+	}
+}
+
+// sdNotify writes state to the datagram socket, an abstract one starts with @
+func sdNotify(socket, state string) error {
+	if socket == "" {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+func monotonicUsec() string {
+	var ts unix.Timespec
+	unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
+	return "MONOTONIC_USEC=" + strconv.FormatInt(ts.Nano()/1000, 10)
+}
+
+// startWatchdog pings at a third of WatchdogSec= of the unit, if it's set for this process
+func startWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	go watchdog(time.Duration(usec)*time.Microsecond/3, nil)
+}
+
+// watchdog pings on every tick the health check passes, systemd restarts clash once the
+// pings stop for WatchdogSec=
+func watchdog(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	unhealthy := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if err := healthy(); err != nil {
+			if !unhealthy {
+				log.Warnln("[Daemon] unhealthy, watchdog heartbeats paused: %s", err)
+				notify("STATUS=unhealthy: " + err.Error())
+				unhealthy = true
+			}
+			continue
+		}
+		if unhealthy {
+			notify("WATCHDOG=1", "STATUS=running")
+			unhealthy = false
+		} else {
+			notify("WATCHDOG=1")
+		}
+	}
+}
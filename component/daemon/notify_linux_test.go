@@ -0,0 +1,69 @@
+package daemon
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func notifySocket(t *testing.T) *net.UnixConn {
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+func readState(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestNotify_States(t *testing.T) {
+	conn := notifySocket(t)
+
+	Ready()
+	assert.Equal(t, "READY=1\nSTATUS=running", readState(t, conn))
+
+	Reloading()
+	assert.Regexp(t, `^RELOADING=1\nMONOTONIC_USEC=\d+\nSTATUS=reloading the config$`, readState(t, conn))
+
+	Reloaded(errors.New("bad rule"))
+	assert.Equal(t, "READY=1\nSTATUS=running, the last reload failed: bad rule", readState(t, conn))
+
+	Stopping()
+	assert.Equal(t, "STOPPING=1\nSTATUS=stopping", readState(t, conn))
+}
+
+func TestNotify_Watchdog(t *testing.T) {
+	conn := notifySocket(t)
+
+	failing := atomic.NewBool(true)
+	SetHealthCheck(func() error {
+		if failing.Load() {
+			return errors.New("the tcp queue of the tunnel is full")
+		}
+		return nil
+	})
+	defer SetHealthCheck(nil)
+
+	done := make(chan struct{})
+	defer close(done)
+	go watchdog(10*time.Millisecond, done)
+
+	// no heartbeat while the check fails
+	assert.Equal(t, "STATUS=unhealthy: the tcp queue of the tunnel is full", readState(t, conn))
+	failing.Store(false)
+	assert.Equal(t, "WATCHDOG=1\nSTATUS=running", readState(t, conn))
+	assert.Equal(t, "WATCHDOG=1", readState(t, conn))
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package daemon
+
+func notify(lines ...string) {}
+
+func monotonicUsec() string { return "" }
+
+func startWatchdog() {}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package daemon
+
+import "errors"
+
+var errNotWindows = errors.New("the service subcommands are for windows, on linux run clash in a systemd unit with Type=notify")
+
+func RunService(name string, start func() error) error {
+	return errNotWindows
+}
+
+func Install(name string, args []string) error {
+	return errNotWindows
+}
+
+func Uninstall(name string) error {
+	return errNotWindows
+}
@@ -0,0 +1,108 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Dreamacro/clash/log"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// exitStartFailed is the service specific exit code of a failed startup, the SCM
+// records it and runs the recovery actions
+const exitStartFailed = 1
+
+type handler struct {
+	start func() error
+}
+
+// Execute implements svc.Handler
+func (h *handler) Execute(args []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (bool, uint32) {
+	s <- svc.Status{State: svc.StartPending}
+	if err := h.start(); err != nil {
+		log.Errorln("[Daemon] start failed: %s", err)
+		s <- svc.Status{State: svc.StopPending}
+		return true, exitStartFailed
+	}
+
+	s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for c := range r {
+		switch c.Cmd {
+		case svc.Interrogate:
+			s <- c.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			s <- svc.Status{State: svc.StopPending}
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+// RunService runs clash under the SCM until it's stopped, start brings clash up and
+// return once the listeners are bound
+func RunService(name string, start func() error) error {
+	if ok, err := svc.IsWindowsService(); err != nil || !ok {
+		return fmt.Errorf("not started by the service control manager, start it with sc start %s", name)
+	}
+	return svc.Run(name, &handler{start: start})
+}
+
+// Install registers the running executable as an automatically started service, args
+// are its arguments
+func Install(name string, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", name)
+	}
+
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: "Clash",
+		Description: "A rule based tunnel",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// restart after a failure, the startup ones included
+	return s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+		{Type: mgr.NoAction},
+	}, uint32((24 * time.Hour).Seconds()))
+}
+
+// Uninstall stops and removes the service
+func Uninstall(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+
+	if status, err := s.Query(); err == nil && status.State != svc.Stopped {
+		s.Control(svc.Stop)
+	}
+	return s.Delete()
+}
@@ -50,6 +50,11 @@ func (c *cachefileStore) Exist(ip net.IP) bool {
 	return exist
 }
 
+// Flush implements store.Flush
+func (c *cachefileStore) Flush() int {
+	return c.cache.FlushFakeip() / 2
+}
+
 // CloneTo implements store.CloneTo
 // already persistence
 func (c *cachefileStore) CloneTo(store store) {}
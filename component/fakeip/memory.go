@@ -60,6 +60,11 @@ func (m *memoryStore) Exist(ip net.IP) bool {
 	return m.cache.Exist(ipToUint(ip.To4()))
 }
 
+// Flush implements store.Flush, a mapping is cached under its ip and its host
+func (m *memoryStore) Flush() int {
+	return m.cache.Clear() / 2
+}
+
 // CloneTo implements store.CloneTo
 // only for memoryStore to memoryStore
 func (m *memoryStore) CloneTo(store store) {
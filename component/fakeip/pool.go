@@ -9,6 +9,7 @@ import (
 	"github.com/Dreamacro/clash/common/cache"
 	"github.com/Dreamacro/clash/component/profile/cachefile"
 	"github.com/Dreamacro/clash/component/trie"
+	"github.com/Dreamacro/clash/log"
 )
 
 type store interface {
@@ -19,6 +20,8 @@ type store interface {
 	DelByIP(ip net.IP)
 	Exist(ip net.IP) bool
 	CloneTo(store)
+	// Flush drops every mapping and return how many there were
+	Flush() int
 }
 
 // Pool is an implementation about fake ip generator without storage
@@ -31,6 +34,10 @@ type Pool struct {
 	host    *trie.DomainTrie
 	ipnet   *net.IPNet
 	store   store
+	tracker *tracker
+
+	// set while the pool drops a mapping itself, so the store doesn't report it again
+	deleting bool
 }
 
 // Lookup return a fake ip with host
@@ -41,9 +48,12 @@ func (p *Pool) Lookup(host string) net.IP {
 	// RFC4343: DNS Case Insensitive, we SHOULD return result with all cases.
 	host = strings.ToLower(host)
 	if ip, exist := p.store.GetByHost(host); exist {
+		p.tracker.lookupHit++
+		p.tracker.use(ipKey(ip))
 		return ip
 	}
 
+	p.tracker.lookupMiss++
 	ip := p.get(host)
 	p.store.PutByHost(host, ip)
 	return ip
@@ -58,7 +68,14 @@ func (p *Pool) LookBack(ip net.IP) (string, bool) {
 		return "", false
 	}
 
-	return p.store.GetByIP(ip)
+	host, exist := p.store.GetByIP(ip)
+	if exist {
+		p.tracker.lookBackHit++
+		p.tracker.use(ipKey(ip))
+	} else {
+		p.tracker.lookBackMiss++
+	}
+	return host, exist
 }
 
 // ShouldSkipped return if domain should be skipped
@@ -93,9 +110,39 @@ func (p *Pool) IPNet() *net.IPNet {
 
 // CloneFrom clone cache from old pool
 func (p *Pool) CloneFrom(o *Pool) {
+	if p == o {
+		return
+	}
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	// the mappings of another range would hand out ips outside of it, the persisted
+	// store is shared with the old pool so it is flushed
+	if p.ipnet.String() != o.ipnet.String() {
+		if n := p.store.Flush(); n != 0 {
+			log.Infoln("[FakeIP] fake-ip-range changed, %d mappings of %s flushed", n, o.ipnet)
+		}
+		return
+	}
+
+	// the tracker goes first, so what the new store evicts while cloning is dropped
+	o.tracker.cloneTo(p.tracker)
 	o.store.CloneTo(p.store)
 }
 
+// Flush drops every mapping, the persisted ones too, and return how many there were.
+// The connections to a flushed fake ip can't be mapped back to their host anymore.
+func (p *Pool) Flush() int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.offset = 0
+	p.tracker.flush()
+	return p.store.Flush()
+}
+
 func (p *Pool) get(host string) net.IP {
 	current := p.offset
 	for {
@@ -109,12 +156,16 @@ func (p *Pool) get(host string) net.IP {
 		if p.offset == current {
 			p.offset = (p.offset + 1) % (p.max - p.min)
 			ip := uintToIP(p.min + p.offset)
+			p.deleting = true
 			p.store.DelByIP(ip)
+			p.deleting = false
+			p.tracker.recycle(ipKey(ip))
 			break
 		}
 	}
 	ip := uintToIP(p.min + p.offset)
 	p.store.PutByIP(ip, host)
+	p.tracker.allocate(ipKey(ip), host)
 	return ip
 }
 
@@ -161,6 +212,7 @@ func New(options Options) (*Pool, error) {
 		gateway: min - 1,
 		host:    options.Host,
 		ipnet:   options.IPNet,
+		tracker: newTracker(),
 	}
 	if options.Persistence {
 		pool.store = &cachefileStore{
@@ -168,7 +220,7 @@ func New(options Options) (*Pool, error) {
 		}
 	} else {
 		pool.store = &memoryStore{
-			cache: cache.New(cache.WithSize(options.Size * 2)),
+			cache: cache.New(cache.WithSize(options.Size*2), cache.WithEvict(pool.onEvict)),
 		}
 	}
 
@@ -210,3 +210,129 @@ func TestPool_Error(t *testing.T) {
 
 	assert.Error(t, err)
 }
+
+func TestPool_Stats(t *testing.T) {
+	_, ipnet, _ := net.ParseCIDR("192.168.0.1/29")
+	pools, tempfile, err := createPools(Options{
+		IPNet: ipnet,
+		Size:  10,
+	})
+	assert.Nil(t, err)
+	defer os.Remove(tempfile)
+
+	for _, pool := range pools {
+		for _, host := range []string{"a.com", "b.com", "c.com", "d.com", "e.com", "f.com", "g.com"} {
+			pool.Lookup(host)
+		}
+		pool.Lookup("g.com")
+		pool.LookBack(net.IP{192, 168, 0, 2})
+
+		stats := pool.Stats()
+		assert.EqualValues(t, 6, stats.Size)
+		// the pool cycles through 5 of them
+		assert.Equal(t, 5, stats.Allocated)
+		assert.EqualValues(t, 2, stats.Recycled)
+		assert.EqualValues(t, 1, stats.LookupHit)
+		assert.EqualValues(t, 7, stats.LookupMiss)
+		assert.EqualValues(t, 1, stats.LookBackHit)
+
+		mappings, total := pool.Recent(0, 2)
+		assert.Equal(t, 5, total)
+		assert.Equal(t, []string{"g.com", "f.com"}, []string{mappings[0].Host, mappings[1].Host})
+		assert.Equal(t, "192.168.0.3", mappings[0].IP)
+	}
+}
+
+func TestPool_StatsEvicted(t *testing.T) {
+	_, ipnet, _ := net.ParseCIDR("192.168.0.1/24")
+	pool, _ := New(Options{
+		IPNet: ipnet,
+		Size:  2,
+	})
+
+	// the memory store evicts the oldest mapping beyond its size
+	foo := pool.Lookup("foo.com")
+	pool.Lookup("bar.com")
+	pool.Lookup("baz.com")
+	_, exist := pool.LookBack(foo)
+	assert.False(t, exist)
+
+	stats := pool.Stats()
+	assert.EqualValues(t, 1, stats.Recycled)
+	assert.Equal(t, 2, stats.Allocated)
+	assert.EqualValues(t, 1, stats.LookBackMiss)
+}
+
+func TestPool_Flush(t *testing.T) {
+	_, ipnet, _ := net.ParseCIDR("192.168.0.1/24")
+	pools, tempfile, err := createPools(Options{
+		IPNet: ipnet,
+		Size:  10,
+	})
+	assert.Nil(t, err)
+	defer os.Remove(tempfile)
+
+	for _, pool := range pools {
+		first := pool.Lookup("foo.com")
+		pool.Lookup("bar.com")
+
+		assert.Equal(t, 2, pool.Flush())
+		assert.False(t, pool.Exist(first))
+		_, exist := pool.LookBack(first)
+		assert.False(t, exist)
+		assert.Equal(t, 0, pool.Stats().Allocated)
+		assert.Equal(t, 0, pool.Flush())
+
+		// allocated from the start of the range again
+		assert.True(t, first.Equal(pool.Lookup("baz.com")))
+	}
+}
+
+func TestPool_CloneOtherRange(t *testing.T) {
+	_, ipnet, _ := net.ParseCIDR("192.168.0.1/24")
+	_, other, _ := net.ParseCIDR("192.168.1.1/24")
+	pool, tempfile, err := createCachefileStore(Options{IPNet: ipnet})
+	assert.Nil(t, err)
+	defer os.Remove(tempfile)
+
+	pool.Lookup("foo.com")
+
+	// the persisted store is shared by the pools
+	newPool, _ := New(Options{IPNet: other})
+	newPool.store = pool.store
+	newPool.CloneFrom(pool)
+
+	assert.Equal(t, 0, newPool.Stats().Allocated)
+	assert.True(t, other.Contains(newPool.Lookup("foo.com")))
+}
+
+func TestPool_Find(t *testing.T) {
+	_, ipnet, _ := net.ParseCIDR("192.168.0.1/29")
+	pools, tempfile, err := createPools(Options{
+		IPNet: ipnet,
+		Size:  10,
+	})
+	assert.Nil(t, err)
+	defer os.Remove(tempfile)
+
+	for _, pool := range pools {
+		ip := pool.Lookup("a.com")
+		found, ok := pool.Find(ip)
+		assert.True(t, ok)
+		assert.Equal(t, "a.com", found.Host)
+		assert.Equal(t, ip.String(), found.IP)
+		allocatedAt := *found.AllocatedAt
+		assert.Equal(t, allocatedAt, *found.LastUse)
+
+		time.Sleep(10 * time.Millisecond)
+		pool.Lookup("a.com")
+		found, _ = pool.Find(ip)
+		assert.Equal(t, allocatedAt, *found.AllocatedAt)
+		assert.True(t, found.LastUse.After(allocatedAt))
+		// finding isn't looking back
+		assert.EqualValues(t, 0, pool.Stats().LookBackHit)
+
+		_, ok = pool.Find(net.IP{192, 168, 0, 6})
+		assert.False(t, ok)
+	}
+}
@@ -0,0 +1,229 @@
+package fakeip
+
+import (
+	"container/list"
+	"net"
+	"time"
+
+	"github.com/Dreamacro/clash/log"
+)
+
+// a recycled mapping breaks the connections still using its fake ip, more than
+// recycleWarnThreshold a minute means the range or the size of the pool is too small
+const recycleWarnThreshold = 60
+
+// Stats is the usage of the pool, the mappings loaded from a persisted
+// pool are only counted by Allocated once they are allocated again
+type Stats struct {
+	Size          uint32  `json:"size"`
+	Allocated     int     `json:"allocated"`
+	Recycled      uint64  `json:"recycled"`
+	RecycleRate   float64 `json:"recycleRate"`
+	LookupHit     uint64  `json:"lookupHit"`
+	LookupMiss    uint64  `json:"lookupMiss"`
+	LookBackHit   uint64  `json:"lookBackHit"`
+	LookBackMiss  uint64  `json:"lookBackMiss"`
+	OldestMapping float64 `json:"oldestMapping"`
+}
+
+// Mapping is an allocated fake ip, Age is in seconds
+type Mapping struct {
+	Host string  `json:"host"`
+	IP   string  `json:"ip"`
+	Age  float64 `json:"age"`
+}
+
+// Allocation is the mapping of a fake ip with when it was allocated and last looked up or
+// back, the times are missing for a mapping loaded from a persisted pool
+type Allocation struct {
+	Host        string     `json:"host"`
+	IP          string     `json:"ip"`
+	AllocatedAt *time.Time `json:"allocatedAt,omitempty"`
+	LastUse     *time.Time `json:"lastUse,omitempty"`
+}
+
+type allocation struct {
+	ip      uint32
+	host    string
+	at      time.Time
+	lastUse time.Time
+}
+
+// tracker keeps the allocations in order, the newest at the front,
+// it is guarded by the mutex of the pool
+type tracker struct {
+	allocations *list.List
+	index       map[uint32]*list.Element
+
+	recycled     uint64
+	lookupHit    uint64
+	lookupMiss   uint64
+	lookBackHit  uint64
+	lookBackMiss uint64
+
+	// recycles of the current and the last complete minute
+	windowStart    time.Time
+	windowRecycled uint64
+	lastRecycled   uint64
+}
+
+func newTracker() *tracker {
+	return &tracker{
+		allocations: list.New(),
+		index:       map[uint32]*list.Element{},
+		windowStart: time.Now(),
+	}
+}
+
+func (t *tracker) allocate(ip uint32, host string) {
+	t.remove(ip)
+	now := time.Now()
+	t.index[ip] = t.allocations.PushFront(&allocation{ip: ip, host: host, at: now, lastUse: now})
+}
+
+// use records a look up or back of the mapping of ip
+func (t *tracker) use(ip uint32) {
+	if elm, ok := t.index[ip]; ok {
+		elm.Value.(*allocation).lastUse = time.Now()
+	}
+}
+
+func (t *tracker) remove(ip uint32) {
+	if elm, ok := t.index[ip]; ok {
+		t.allocations.Remove(elm)
+		delete(t.index, ip)
+	}
+}
+
+// recycle records a mapping dropped to make room for another one
+func (t *tracker) recycle(ip uint32) {
+	t.remove(ip)
+	t.recycled++
+	t.rotate(time.Now())
+	t.windowRecycled++
+
+	if t.windowRecycled == recycleWarnThreshold {
+		log.Dedupln(log.WARNING, "fakeip-recycle", "[FakeIP] more than %d mappings recycled in a minute, fake-ip-range is too small", recycleWarnThreshold)
+	}
+}
+
+func (t *tracker) rotate(now time.Time) {
+	elapsed := now.Sub(t.windowStart)
+	if elapsed < time.Minute {
+		return
+	}
+	t.lastRecycled = t.windowRecycled
+	if elapsed >= 2*time.Minute {
+		// nothing recycled during the last complete minute
+		t.lastRecycled = 0
+	}
+	t.windowRecycled = 0
+	t.windowStart = now
+}
+
+func (t *tracker) stats(size uint32) Stats {
+	now := time.Now()
+	t.rotate(now)
+
+	stats := Stats{
+		Size:         size,
+		Allocated:    t.allocations.Len(),
+		Recycled:     t.recycled,
+		RecycleRate:  float64(t.lastRecycled),
+		LookupHit:    t.lookupHit,
+		LookupMiss:   t.lookupMiss,
+		LookBackHit:  t.lookBackHit,
+		LookBackMiss: t.lookBackMiss,
+	}
+	if oldest := t.allocations.Back(); oldest != nil {
+		stats.OldestMapping = now.Sub(oldest.Value.(*allocation).at).Seconds()
+	}
+	return stats
+}
+
+// recent return the allocations from the newest, skipping offset of them
+func (t *tracker) recent(offset, limit int) []Mapping {
+	now := time.Now()
+	mappings := []Mapping{}
+
+	elm := t.allocations.Front()
+	for i := 0; i < offset && elm != nil; i++ {
+		elm = elm.Next()
+	}
+	for ; elm != nil && len(mappings) < limit; elm = elm.Next() {
+		a := elm.Value.(*allocation)
+		mappings = append(mappings, Mapping{
+			Host: a.host,
+			IP:   uintToIP(a.ip).String(),
+			Age:  now.Sub(a.at).Seconds(),
+		})
+	}
+	return mappings
+}
+
+// flush forgets the allocations, the counters are kept
+func (t *tracker) flush() {
+	t.allocations.Init()
+	t.index = map[uint32]*list.Element{}
+}
+
+func (t *tracker) cloneTo(n *tracker) {
+	for elm := t.allocations.Back(); elm != nil; elm = elm.Prev() {
+		a := *elm.Value.(*allocation)
+		n.remove(a.ip)
+		n.index[a.ip] = n.allocations.PushFront(&a)
+	}
+	n.recycled = t.recycled
+	n.lookupHit, n.lookupMiss = t.lookupHit, t.lookupMiss
+	n.lookBackHit, n.lookBackMiss = t.lookBackHit, t.lookBackMiss
+}
+
+// Stats return the usage of the pool
+func (p *Pool) Stats() Stats {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return p.tracker.stats(p.max - p.min + 1)
+}
+
+// Recent return the most recently allocated mappings and the number of tracked mappings
+func (p *Pool) Recent(offset, limit int) ([]Mapping, int) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return p.tracker.recent(offset, limit), p.tracker.allocations.Len()
+}
+
+// Find return the mapping of ip, it isn't counted as a look back
+func (p *Pool) Find(ip net.IP) (Allocation, bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if ip = ip.To4(); ip == nil {
+		return Allocation{}, false
+	}
+	host, exist := p.store.GetByIP(ip)
+	if !exist {
+		return Allocation{}, false
+	}
+	found := Allocation{Host: host, IP: ip.String()}
+	if elm, ok := p.tracker.index[ipKey(ip)]; ok {
+		a := elm.Value.(*allocation)
+		at, lastUse := a.at, a.lastUse
+		found.AllocatedAt, found.LastUse = &at, &lastUse
+	}
+	return found, true
+}
+
+func (p *Pool) onEvict(key any, _ any) {
+	// the host keys of the memory store follow their ip
+	ip, ok := key.(uint32)
+	if !ok || p.deleting {
+		return
+	}
+	p.tracker.recycle(ip)
+}
+
+func ipKey(ip net.IP) uint32 {
+	return ipToUint(ip.To4())
+}
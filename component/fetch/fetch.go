@@ -0,0 +1,60 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dialer"
+
+	"go.uber.org/atomic"
+)
+
+var proxy = atomic.NewPointer[url.URL](nil)
+
+// ParseProxy validates a fetch-proxy url, socks5:// and http:// with optional user:pass are accepted
+func ParseProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("fetch-proxy: %w", err)
+	}
+
+	switch u.Scheme {
+	case "socks5", "http", "https":
+	default:
+		return nil, fmt.Errorf("fetch-proxy: unsupport scheme %s, expect socks5, http or https", u.Scheme)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("fetch-proxy: expect host:port in %s", u.Redacted())
+	}
+	return u, nil
+}
+
+// SetProxy sets the global fetch proxy, nil connects directly
+func SetProxy(u *url.URL) {
+	proxy.Store(u)
+}
+
+// Proxy return the current fetch proxy, nil when there is none
+func Proxy() *url.URL {
+	return proxy.Load()
+}
+
+// NewTransport returns a transport for the internal downloads (providers, mmdb),
+// it never goes through the clash proxies, only through proxy when it isn't nil
+func NewTransport(proxy *url.URL) *http.Transport {
+	return &http.Transport{
+		// from http.DefaultTransport
+		Proxy:                 http.ProxyURL(proxy),
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+}
@@ -0,0 +1,227 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/log"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	StateUp   = "up"
+	StateDown = "down"
+
+	// DefaultDebounce is how long a new state has to hold before it is reported
+	DefaultDebounce = 30 * time.Second
+
+	actionTimeout = 10 * time.Second
+)
+
+// Action is a webhook and/or a command fired on a state change, either may be empty
+type Action struct {
+	URL     string
+	Command []string
+}
+
+func (a Action) empty() bool {
+	return a.URL == "" && len(a.Command) == 0
+}
+
+type Option struct {
+	Debounce time.Duration
+	Down     Action
+	Up       Action
+
+	// Groups return the groups containing the proxy
+	Groups func(proxy string) []string
+}
+
+// Event is POSTed as json to the webhook, commands get it as CLASH_* env vars
+type Event struct {
+	Proxy    string    `json:"proxy"`
+	Groups   []string  `json:"groups"`
+	Previous string    `json:"previous"`
+	Current  string    `json:"current"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+type proxyState struct {
+	reported bool // alive state of the last report
+	current  bool
+	err      string
+	timer    *time.Timer
+}
+
+var (
+	mux sync.Mutex
+	// option is read without mux first, the dials don't lock when the hooks are off
+	option atomic.Pointer[Option]
+	states = map[string]*proxyState{}
+)
+
+// Update replaces the hooks, nil or an option without actions disables them.
+// Pending reports of the previous option are dropped.
+func Update(opt *Option) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	for _, s := range states {
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+	}
+	states = map[string]*proxyState{}
+
+	if opt == nil || (opt.Down.empty() && opt.Up.empty()) {
+		option.Store(nil)
+		return
+	}
+	if opt.Debounce <= 0 {
+		opt.Debounce = DefaultDebounce
+	}
+	option.Store(opt)
+}
+
+// ProxyState records the result of a health check or a dial, only a change that
+// lasts the debounce duration is reported, so a flapping proxy stays quiet
+func ProxyState(name string, alive bool, err error) {
+	if option.Load() == nil {
+		return
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	opt := option.Load()
+	if opt == nil {
+		return
+	}
+
+	s, ok := states[name]
+	if !ok {
+		// proxies start alive
+		s = &proxyState{reported: true, current: true}
+		states[name] = s
+	}
+	if !alive && err != nil {
+		s.err = err.Error()
+	}
+	if s.current == alive {
+		return
+	}
+	s.current = alive
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if alive == s.reported {
+		// flapped back before the report was due
+		return
+	}
+
+	s.timer = time.AfterFunc(opt.Debounce, func() {
+		mux.Lock()
+		defer mux.Unlock()
+		if option.Load() != opt || states[name] != s || s.current == s.reported {
+			return
+		}
+
+		s.timer = nil
+		event := Event{
+			Proxy:    name,
+			Previous: stateString(s.reported),
+			Current:  stateString(s.current),
+			Time:     time.Now(),
+		}
+		if !s.current {
+			event.Error = s.err
+		}
+		s.reported = s.current
+
+		action := opt.Up
+		if !s.current {
+			action = opt.Down
+		}
+		go fire(action, event, opt.Groups)
+	})
+}
+
+func stateString(alive bool) string {
+	if alive {
+		return StateUp
+	}
+	return StateDown
+}
+
+func fire(action Action, event Event, groups func(string) []string) {
+	if action.empty() {
+		return
+	}
+	if groups != nil {
+		event.Groups = groups(event.Proxy)
+	}
+	if event.Groups == nil {
+		event.Groups = []string{}
+	}
+
+	log.Infoln("[Hook] %s is %s", event.Proxy, event.Current)
+
+	ctx, cancel := context.WithTimeout(context.Background(), actionTimeout)
+	defer cancel()
+
+	if action.URL != "" {
+		if err := post(ctx, action.URL, event); err != nil {
+			log.Warnln("[Hook] webhook %s: %s", action.URL, err.Error())
+		}
+	}
+
+	if len(action.Command) != 0 {
+		cmd := exec.CommandContext(ctx, action.Command[0], action.Command[1:]...)
+		cmd.Env = append(os.Environ(),
+			"CLASH_PROXY="+event.Proxy,
+			"CLASH_GROUPS="+strings.Join(event.Groups, ","),
+			"CLASH_PREVIOUS="+event.Previous,
+			"CLASH_CURRENT="+event.Current,
+			"CLASH_ERROR="+event.Error,
+		)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			log.Warnln("[Hook] command %s: %s %s", action.Command[0], err.Error(), bytes.TrimSpace(output))
+		}
+	}
+}
+
+func post(ctx context.Context, url string, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package hook
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyState_Debounce(t *testing.T) {
+	events := make(chan Event, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := Event{}
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer server.Close()
+
+	Update(&Option{
+		Debounce: 50 * time.Millisecond,
+		Down:     Action{URL: server.URL},
+		Up:       Action{URL: server.URL},
+		Groups:   func(string) []string { return []string{"auto"} },
+	})
+	defer Update(nil)
+
+	// flapping back within the debounce duration is not reported
+	ProxyState("hk", false, errors.New("timeout"))
+	ProxyState("hk", true, nil)
+
+	ProxyState("jp", false, errors.New("connection refused"))
+	select {
+	case event := <-events:
+		assert.Equal(t, "jp", event.Proxy)
+		assert.Equal(t, StateUp, event.Previous)
+		assert.Equal(t, StateDown, event.Current)
+		assert.Equal(t, "connection refused", event.Error)
+		assert.Equal(t, []string{"auto"}, event.Groups)
+	case <-time.After(time.Second):
+		t.Fatal("down event not fired")
+	}
+
+	ProxyState("jp", true, nil)
+	select {
+	case event := <-events:
+		assert.Equal(t, StateUp, event.Current)
+		assert.Empty(t, event.Error)
+	case <-time.After(time.Second):
+		t.Fatal("up event not fired")
+	}
+
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestProxyState_Disabled(t *testing.T) {
+	Update(nil)
+
+	// the dials don't take the lock when no hook is set
+	mux.Lock()
+	done := make(chan struct{})
+	go func() {
+		ProxyState("hk", false, errors.New("refused"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ProxyState waited for the lock")
+	}
+	mux.Unlock()
+	assert.Empty(t, states)
+}
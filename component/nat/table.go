@@ -31,6 +31,12 @@ func (t *Table) Delete(key string) {
 	t.mapping.Delete(key)
 }
 
+// DeleteIfEqual deletes the key only if it still maps to pc,
+// an entry that has been replaced in the meantime is kept
+func (t *Table) DeleteIfEqual(key string, pc C.PacketConn) {
+	t.mapping.CompareAndDelete(key, pc)
+}
+
 // New return *Cache
 func New() *Table {
 	return &Table{}
@@ -0,0 +1,117 @@
+// Package netmon tells when the network changes under the outbounds: the local address of
+// interface-name, or the one the default route goes out of, is polled and each change is
+// sent to the subscribers.
+package netmon
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dialer"
+)
+
+// interval is how often the route is checked while there are subscribers
+var interval = 2 * time.Second
+
+// source return the local addresses the outbounds send from, empty without a network
+var source = func() string {
+	if name := dialer.DefaultInterface.Load(); name != "" {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return ""
+		}
+		addrs, _ := iface.Addrs()
+		ips := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			ips = append(ips, addr.String())
+		}
+		sort.Strings(ips)
+		return strings.Join(ips, ",")
+	}
+
+	// connecting a udp socket picks the route without sending anything
+	var ips []string
+	for _, target := range []string{"192.0.2.1:9", "[2001:db8::1]:9"} {
+		conn, err := net.Dial("udp", target)
+		if err != nil {
+			continue
+		}
+		ips = append(ips, conn.LocalAddr().(*net.UDPAddr).IP.String())
+		conn.Close()
+	}
+	return strings.Join(ips, ",")
+}
+
+var (
+	mux         sync.Mutex
+	subscribers = map[*Subscription]struct{}{}
+	stop        chan struct{}
+)
+
+// Subscription receives a value on C after each change, the changes while one is pending
+// are merged into it
+type Subscription struct {
+	C <-chan struct{}
+	c chan struct{}
+}
+
+// Subscribe starts the polling with the first subscriber
+func Subscribe() *Subscription {
+	c := make(chan struct{}, 1)
+	s := &Subscription{C: c, c: c}
+
+	mux.Lock()
+	defer mux.Unlock()
+	subscribers[s] = struct{}{}
+	if stop == nil {
+		stop = make(chan struct{})
+		go poll(stop)
+	}
+	return s
+}
+
+// Close stops the polling with the last subscriber
+func (s *Subscription) Close() {
+	mux.Lock()
+	defer mux.Unlock()
+	delete(subscribers, s)
+	if len(subscribers) == 0 && stop != nil {
+		close(stop)
+		stop = nil
+	}
+}
+
+func poll(stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := source()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		current := source()
+		if current == last {
+			continue
+		}
+		last = current
+		// a network going away isn't a path to move to, its return is
+		if current == "" {
+			continue
+		}
+
+		mux.Lock()
+		for s := range subscribers {
+			select {
+			case s.c <- struct{}{}:
+			default:
+			}
+		}
+		mux.Unlock()
+	}
+}
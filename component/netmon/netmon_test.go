@@ -0,0 +1,40 @@
+package netmon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+)
+
+func TestSubscribe(t *testing.T) {
+	current := atomic.NewString("192.168.1.2")
+	source = current.Load
+	interval = 10 * time.Millisecond
+
+	s := Subscribe()
+	changed := func() bool {
+		select {
+		case <-s.C:
+			return true
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}
+	assert.False(t, changed())
+
+	current.Store("10.0.0.2")
+	assert.True(t, changed())
+
+	// the network going away isn't told, its return is
+	current.Store("")
+	assert.False(t, changed())
+	current.Store("10.0.0.2")
+	assert.True(t, changed())
+
+	s.Close()
+	mux.Lock()
+	assert.Nil(t, stop)
+	mux.Unlock()
+}
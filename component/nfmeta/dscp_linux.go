@@ -0,0 +1,70 @@
+package nfmeta
+
+import (
+	"errors"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// DSCP return the DSCP of an accepted IPv4 TCP connection, the kernel keeps the TOS
+// of the SYN for IP_PKTOPTIONS
+func DSCP(conn net.Conn) (uint8, error) {
+	c, ok := conn.(*net.TCPConn)
+	if !ok {
+		return 0, errors.New("only work with TCP connection")
+	}
+	if remote, ok := c.RemoteAddr().(*net.TCPAddr); !ok || remote.IP.To4() == nil {
+		return 0, ErrNotSupported
+	}
+
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var tos uint8
+	ctrlErr := rc.Control(func(fd uintptr) {
+		if err = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_RECVTOS, 1); err != nil {
+			return
+		}
+
+		buf := make([]byte, unix.CmsgSpace(4))
+		size := uint32(len(buf))
+		if err = socketcall(GETSOCKOPT, fd, unix.SOL_IP, unix.IP_PKTOPTIONS, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0); err != nil {
+			return
+		}
+
+		var found bool
+		if tos, found = ParseTOS(buf[:size]); !found {
+			err = errors.New("no TOS in the packet options")
+		}
+	})
+	if ctrlErr != nil {
+		return 0, ctrlErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return tos >> 2, nil
+}
+
+// ParseTOS return the TOS or traffic class from the control messages
+// of IP_RECVTOS or IPV6_RECVTCLASS
+func ParseTOS(oob []byte) (uint8, bool) {
+	scms, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+	for _, scm := range scms {
+		switch {
+		case scm.Header.Level == unix.SOL_IP && scm.Header.Type == unix.IP_TOS && len(scm.Data) >= 1:
+			return scm.Data[0], true
+		case scm.Header.Level == unix.SOL_IPV6 && scm.Header.Type == unix.IPV6_TCLASS && len(scm.Data) >= 4:
+			// an int in host byte order
+			return uint8(*(*int32)(unsafe.Pointer(&scm.Data[0]))), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,119 @@
+package nfmeta
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// from linux/include/uapi/linux/netfilter/nfnetlink_conntrack.h
+const (
+	ipctnlMsgCtGet = 1
+
+	ctaTupleOrig = 1
+	ctaMark      = 8
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+	ctaIPv6Src = 3
+	ctaIPv6Dst = 4
+
+	ctaProtoNum     = 1
+	ctaProtoSrcPort = 2
+	ctaProtoDstPort = 3
+)
+
+// Mark return the conntrack mark (ct mark, what CONNMARK sets) of the connection from -> to
+// in the direction the client sent it, 0 when the entry has no mark
+func Mark(network string, from, to netip.AddrPort) (uint32, error) {
+	from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+	to = netip.AddrPortFrom(to.Addr().Unmap(), to.Port())
+	if from.Addr().Is4() != to.Addr().Is4() {
+		return 0, errors.New("mixed address families")
+	}
+
+	var proto uint8
+	switch network {
+	case "tcp":
+		proto = unix.IPPROTO_TCP
+	case "udp":
+		proto = unix.IPPROTO_UDP
+	default:
+		return 0, errors.New("invalid network " + network)
+	}
+
+	family, srcType, dstType := uint8(unix.AF_INET6), uint16(ctaIPv6Src), uint16(ctaIPv6Dst)
+	if from.Addr().Is4() {
+		family, srcType, dstType = unix.AF_INET, ctaIPv4Src, ctaIPv4Dst
+	}
+
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	ae.Nested(ctaTupleOrig, func(tuple *netlink.AttributeEncoder) error {
+		tuple.Nested(ctaTupleIP, func(ip *netlink.AttributeEncoder) error {
+			ip.Bytes(srcType, from.Addr().AsSlice())
+			ip.Bytes(dstType, to.Addr().AsSlice())
+			return nil
+		})
+		tuple.Nested(ctaTupleProto, func(p *netlink.AttributeEncoder) error {
+			p.Uint8(ctaProtoNum, proto)
+			p.Uint16(ctaProtoSrcPort, from.Port())
+			p.Uint16(ctaProtoDstPort, to.Port())
+			return nil
+		})
+		return nil
+	})
+	attrs, err := ae.Encode()
+	if err != nil {
+		return 0, err
+	}
+
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	message := netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(unix.NFNL_SUBSYS_CTNETLINK<<8 | ipctnlMsgCtGet),
+			Flags: netlink.Request,
+		},
+		// nfgenmsg: family, version, resource id
+		Data: append([]byte{family, unix.NFNETLINK_V0, 0, 0}, attrs...),
+	}
+
+	messages, err := conn.Execute(message)
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	for _, msg := range messages {
+		if len(msg.Data) < 4 {
+			continue
+		}
+		ad, err := netlink.NewAttributeDecoder(msg.Data[4:])
+		if err != nil {
+			return 0, err
+		}
+		ad.ByteOrder = binary.BigEndian
+		for ad.Next() {
+			if ad.Type() == ctaMark {
+				return ad.Uint32(), nil
+			}
+		}
+		// the kernel leaves out a zero mark
+		return 0, ad.Err()
+	}
+
+	return 0, ErrNotFound
+}
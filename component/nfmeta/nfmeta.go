@@ -0,0 +1,12 @@
+// Package nfmeta reads the netfilter classification of the connections accepted by
+// redir and tproxy, the conntrack mark and the DSCP of the client packets
+package nfmeta
+
+import (
+	"errors"
+)
+
+var (
+	ErrNotSupported = errors.New("not supported on current platform")
+	ErrNotFound     = errors.New("conntrack entry not found")
+)
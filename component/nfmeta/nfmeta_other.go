@@ -0,0 +1,20 @@
+//go:build !linux
+
+package nfmeta
+
+import (
+	"net"
+	"net/netip"
+)
+
+func Mark(network string, from, to netip.AddrPort) (uint32, error) {
+	return 0, ErrNotSupported
+}
+
+func DSCP(conn net.Conn) (uint8, error) {
+	return 0, ErrNotSupported
+}
+
+func ParseTOS(oob []byte) (uint8, bool) {
+	return 0, false
+}
@@ -0,0 +1,169 @@
+package ntp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/log"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	// seconds between 1900 (NTP epoch) and 1970 (unix epoch)
+	ntpEpochOffset = 2208988800
+
+	queryTimeout = 5 * time.Second
+
+	// VMessTolerance is the clock difference a vmess server accepts
+	VMessTolerance = 120 * time.Second
+)
+
+var (
+	offset = atomic.NewInt64(0)
+
+	mux    sync.Mutex
+	cancel context.CancelFunc
+)
+
+// Now return the wall clock corrected by the offset from the last NTP query,
+// the system time is never modified
+func Now() time.Time {
+	return time.Now().Add(Offset())
+}
+
+// Offset return the last measured difference between NTP and the system clock
+func Offset() time.Duration {
+	return time.Duration(offset.Load())
+}
+
+// Start queries the server immediately and then every interval,
+// an empty server stops the service and resets the offset
+func Start(server string, interval time.Duration) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	if cancel != nil {
+		cancel()
+		cancel = nil
+	}
+
+	if server == "" {
+		offset.Store(0)
+		return
+	}
+
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.Background())
+	go loop(ctx, server, interval)
+}
+
+func loop(ctx context.Context, server string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		update(ctx, server)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func update(ctx context.Context, server string) {
+	d, err := Query(ctx, server)
+	if err != nil {
+		log.Warnln("[NTP] query %s failed: %s", server, err.Error())
+		return
+	}
+
+	offset.Store(int64(d))
+	if d > VMessTolerance || d < -VMessTolerance {
+		log.Warnln("[NTP] system clock is off by %s, exceeds the vmess tolerance of %s, protocol timestamps are corrected", d.Round(time.Second), VMessTolerance)
+	} else {
+		log.Debugln("[NTP] system clock offset %s", d)
+	}
+}
+
+// Query measures the clock offset against an NTP server with a single SNTP exchange
+func Query(ctx context.Context, server string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := make([]byte, 48)
+	req[0] = 0<<6 | 4<<3 | 3 // LI 0, version 4, client mode
+	t1 := time.Now()
+	putTimestamp(req[40:], t1)
+
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+
+	// a reply not echoing the transmit timestamp of the request is stale or spoofed, the
+	// next one is read until the deadline
+	resp := make([]byte, 48)
+	for {
+		n, err := conn.Read(resp)
+		if err != nil {
+			return 0, err
+		}
+		t4 := time.Now()
+
+		if n < 48 {
+			return 0, errors.New("short ntp response")
+		}
+		if !bytes.Equal(resp[24:32], req[40:48]) {
+			log.Debugln("[NTP] %s: dropped a response to another request", server)
+			continue
+		}
+		if mode := resp[0] & 0x7; mode != 4 {
+			return 0, errors.New("unexpected ntp mode")
+		}
+		if stratum := resp[1]; stratum == 0 || stratum > 15 {
+			return 0, errors.New("ntp server is unsynchronized")
+		}
+		if binary.BigEndian.Uint64(resp[40:]) == 0 {
+			return 0, errors.New("ntp response without transmit timestamp")
+		}
+
+		t2 := getTimestamp(resp[32:])
+		t3 := getTimestamp(resp[40:])
+		return (t2.Sub(t1) + t3.Sub(t4)) / 2, nil
+	}
+}
+
+func putTimestamp(b []byte, t time.Time) {
+	sec := uint64(t.Unix()) + ntpEpochOffset
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	binary.BigEndian.PutUint32(b[0:], uint32(sec))
+	binary.BigEndian.PutUint32(b[4:], uint32(frac))
+}
+
+func getTimestamp(b []byte) time.Time {
+	sec := int64(binary.BigEndian.Uint32(b[0:])) - ntpEpochOffset
+	frac := uint64(binary.BigEndian.Uint32(b[4:]))
+	return time.Unix(sec, int64(frac*uint64(time.Second)>>32))
+}
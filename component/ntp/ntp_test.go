@@ -0,0 +1,101 @@
+package ntp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ntpServer answers the requests with its clock ahead by offset, reply may alter the
+// responses or add others before them
+func ntpServer(t *testing.T, offset time.Duration, reply func(req, resp []byte) [][]byte) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		req := make([]byte, 48)
+		for {
+			n, addr, err := pc.ReadFrom(req)
+			if err != nil {
+				return
+			}
+			if n < 48 {
+				continue
+			}
+			resp := make([]byte, 48)
+			resp[0] = 0<<6 | 4<<3 | 4 // server mode
+			resp[1] = 2
+			copy(resp[24:32], req[40:48])
+			now := time.Now().Add(offset)
+			putTimestamp(resp[32:], now)
+			putTimestamp(resp[40:], now)
+			for _, b := range reply(req, resp) {
+				pc.WriteTo(b, addr)
+			}
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestNTP_Timestamp(t *testing.T) {
+	now := time.Unix(1700000000, 123456789)
+	buf := make([]byte, 8)
+	putTimestamp(buf, now)
+
+	assert.InDelta(t, now.UnixNano(), getTimestamp(buf).UnixNano(), 1)
+}
+
+func TestQuery(t *testing.T) {
+	server := ntpServer(t, 10*time.Second, func(req, resp []byte) [][]byte {
+		return [][]byte{resp}
+	})
+	d, err := Query(context.Background(), server)
+	require.NoError(t, err)
+	assert.InDelta(t, 10*time.Second, d, float64(100*time.Millisecond))
+}
+
+func TestQuery_Originate(t *testing.T) {
+	// a spoofed reply claiming an hour of offset comes first
+	server := ntpServer(t, 10*time.Second, func(req, resp []byte) [][]byte {
+		spoofed := make([]byte, 48)
+		copy(spoofed, resp)
+		putTimestamp(spoofed[24:], time.Now().Add(-time.Minute))
+		putTimestamp(spoofed[32:], time.Now().Add(time.Hour))
+		putTimestamp(spoofed[40:], time.Now().Add(time.Hour))
+		return [][]byte{spoofed, resp}
+	})
+	d, err := Query(context.Background(), server)
+	require.NoError(t, err)
+	assert.InDelta(t, 10*time.Second, d, float64(100*time.Millisecond))
+
+	// nothing but replies to other requests
+	server = ntpServer(t, time.Hour, func(req, resp []byte) [][]byte {
+		resp[24] ^= 0xff
+		return [][]byte{resp}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = Query(ctx, server)
+	assert.Error(t, err)
+}
+
+func TestQuery_Invalid(t *testing.T) {
+	for name, alter := range map[string]func(resp []byte){
+		"zero transmit":  func(resp []byte) { copy(resp[40:], make([]byte, 8)) },
+		"unsynchronized": func(resp []byte) { resp[1] = 0 },
+		"client mode":    func(resp []byte) { resp[0] = 0<<6 | 4<<3 | 3 },
+	} {
+		alter := alter
+		server := ntpServer(t, 0, func(req, resp []byte) [][]byte {
+			alter(resp)
+			return [][]byte{resp}
+		})
+		_, err := Query(context.Background(), server)
+		assert.Error(t, err, name)
+	}
+}
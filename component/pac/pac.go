@@ -0,0 +1,113 @@
+// Package pac generates the proxy auto-config file served at /proxy.pac, it sends the
+// hosts of system-proxy-bypass DIRECT and the rest to the mixed port
+package pac
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Local is the bypass entry of the plain host names, without a dot, as on windows
+const Local = "<local>"
+
+var (
+	mux    sync.RWMutex
+	bypass []string
+)
+
+// Validate checks the entries of system-proxy-bypass: <local>, a domain, +.domain with
+// its subdomains, a shell pattern like *.lan, an ip or an ipv4 cidr
+func Validate(entries []string) error {
+	for _, entry := range entries {
+		if _, err := condition(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update replaces the bypass list, the entries are validated
+func Update(entries []string) {
+	mux.Lock()
+	defer mux.Unlock()
+	bypass = entries
+}
+
+// Bypass return the bypass list
+func Bypass() []string {
+	mux.RLock()
+	defer mux.RUnlock()
+	return bypass
+}
+
+// Proxy is the PAC directive of the listeners at host, the mixed port, then http or socks,
+// DIRECT without any of them
+func Proxy(host string, mixed, http, socks int) string {
+	addr := func(port int) string {
+		return net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	switch {
+	case mixed != 0:
+		return fmt.Sprintf("PROXY %s; SOCKS5 %s; DIRECT", addr(mixed), addr(mixed))
+	case http != 0:
+		return fmt.Sprintf("PROXY %s; DIRECT", addr(http))
+	case socks != 0:
+		return fmt.Sprintf("SOCKS5 %s; DIRECT", addr(socks))
+	}
+	return "DIRECT"
+}
+
+// Render return the PAC file of the bypass list sending the rest to proxy, with its ETag
+func Render(proxy string) ([]byte, string) {
+	b := &strings.Builder{}
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	b.WriteString("  var ip = /^\\d+\\.\\d+\\.\\d+\\.\\d+$/.test(host);\n")
+	for _, entry := range Bypass() {
+		// validated by Update
+		cond, _ := condition(entry)
+		fmt.Fprintf(b, "  if (%s) return \"DIRECT\";\n", cond)
+	}
+	fmt.Fprintf(b, "  return %s;\n}\n", strconv.Quote(proxy))
+
+	content := []byte(b.String())
+	sum := sha256.Sum256(content)
+	return content, `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// condition is the javascript condition of an entry, isInNet only tests ip hosts so a
+// cidr doesn't resolve every host
+func condition(entry string) (string, error) {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	switch {
+	case entry == Local:
+		return "isPlainHostName(host)", nil
+	case entry == "":
+		return "", fmt.Errorf("system-proxy-bypass: empty entry")
+	case strings.ContainsAny(entry, `"\ `):
+		return "", fmt.Errorf("system-proxy-bypass: invalid entry %s", entry)
+	}
+
+	if prefix, err := netip.ParsePrefix(entry); err == nil {
+		if !prefix.Addr().Is4() {
+			return "", fmt.Errorf("system-proxy-bypass: %s, only ipv4 cidrs can be tested by a pac", entry)
+		}
+		mask := net.IP(net.CIDRMask(prefix.Bits(), 32)).String()
+		return fmt.Sprintf("ip && isInNet(host, %q, %q)", prefix.Masked().Addr().String(), mask), nil
+	}
+	if addr, err := netip.ParseAddr(entry); err == nil {
+		return fmt.Sprintf("host == %q", addr.String()), nil
+	}
+	if strings.ContainsAny(entry, "*?") {
+		return fmt.Sprintf("shExpMatch(host, %q)", entry), nil
+	}
+	if domain, ok := strings.CutPrefix(entry, "+."); ok {
+		return fmt.Sprintf("host == %q || dnsDomainIs(host, %q)", domain, "."+domain), nil
+	}
+	return fmt.Sprintf("host == %q", entry), nil
+}
@@ -0,0 +1,41 @@
+package pac
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate([]string{Local, "localhost", "+.lan", "*.local", "192.168.0.0/16", "10.0.0.1", "::1"}))
+
+	for _, entry := range []string{"", "fd00::/8", `a"b`, "a b"} {
+		assert.Error(t, Validate([]string{entry}), entry)
+	}
+}
+
+func TestRender(t *testing.T) {
+	Update([]string{Local, "+.lan", "*.local", "192.168.1.0/24", "printer.home"})
+	defer Update(nil)
+
+	content, etag := Render(Proxy("192.168.1.2", 7890, 0, 0))
+	assert.Equal(t, `function FindProxyForURL(url, host) {
+  var ip = /^\d+\.\d+\.\d+\.\d+$/.test(host);
+  if (isPlainHostName(host)) return "DIRECT";
+  if (host == "lan" || dnsDomainIs(host, ".lan")) return "DIRECT";
+  if (shExpMatch(host, "*.local")) return "DIRECT";
+  if (ip && isInNet(host, "192.168.1.0", "255.255.255.0")) return "DIRECT";
+  if (host == "printer.home") return "DIRECT";
+  return "PROXY 192.168.1.2:7890; SOCKS5 192.168.1.2:7890; DIRECT";
+}
+`, string(content))
+
+	// the etag follows the content
+	_, same := Render(Proxy("192.168.1.2", 7890, 0, 0))
+	assert.Equal(t, etag, same)
+	_, other := Render(Proxy("192.168.1.2", 0, 7891, 0))
+	assert.NotEqual(t, etag, other)
+
+	assert.Equal(t, "SOCKS5 [::1]:7891; DIRECT", Proxy("::1", 0, 0, 7891))
+	assert.Equal(t, "DIRECT", Proxy("127.0.0.1", 0, 0, 0))
+}
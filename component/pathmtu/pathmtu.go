@@ -0,0 +1,134 @@
+// Package pathmtu compares the mtu of the tun with the largest datagram each proxy relays
+// without fragmenting it. The tcp from the tun is terminated by the ipstack and sent again
+// by the outbound, so only the udp is concerned.
+package pathmtu
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+const (
+	// DefaultUplinkMTU is assumed when the interface to the servers can't be found
+	DefaultUplinkMTU = 1500
+
+	ipv4UDPHeaders = 20 + 8
+	ipv6UDPHeaders = 40 + 8
+)
+
+// framedProxy is an adapter.Proxy, the groups report no framing
+type framedProxy interface {
+	UDPFraming() (C.UDPFraming, bool)
+}
+
+// Report is the check of a tun mtu, Uplink is empty when the mtu of the uplink is assumed
+type Report struct {
+	TunMTU    int            `json:"tunMTU"`
+	Uplink    string         `json:"uplink,omitempty"`
+	UplinkMTU int            `json:"uplinkMTU"`
+	Budgets   map[string]int `json:"budgets"`
+	Warnings  []string       `json:"warnings"`
+}
+
+// Budget return the largest udp payload relayed through f without fragments, the one of a
+// stream is its cap, 0 for none
+func Budget(f C.UDPFraming, uplinkMTU int, ipv6 bool) int {
+	if f.Stream {
+		return f.MaxPayload
+	}
+	if ipv6 {
+		return uplinkMTU - ipv6UDPHeaders - f.Overhead
+	}
+	return uplinkMTU - ipv4UDPHeaders - f.Overhead
+}
+
+// Check compares the largest udp payload of the tun with the budget of each proxy relaying
+// udp, tun is the name of the tun device which isn't taken for the uplink
+func Check(tun string, tunMTU int, proxies []C.Proxy) Report {
+	report := Report{TunMTU: tunMTU, Budgets: map[string]int{}, Warnings: []string{}}
+	report.Uplink, report.UplinkMTU = uplink(tun)
+
+	// the payload of the largest datagram of the tun, ipv4 has the smaller headers
+	payload := tunMTU - ipv4UDPHeaders
+	if tunMTU > report.UplinkMTU {
+		uplink := report.Uplink
+		if uplink == "" {
+			uplink = "assumed"
+		}
+		report.Warnings = append(report.Warnings, fmt.Sprintf(
+			"tun mtu %d exceeds the mtu %d of the uplink (%s), the larger direct udp datagrams get fragmented; consider mtu %d",
+			tunMTU, report.UplinkMTU, uplink, report.UplinkMTU))
+	}
+
+	sort.Slice(proxies, func(i, j int) bool { return proxies[i].Name() < proxies[j].Name() })
+	for _, proxy := range proxies {
+		framed, ok := proxy.(framedProxy)
+		if !ok || proxy.Type() == C.Direct {
+			continue
+		}
+		framing, ok := framed.UDPFraming()
+		if !ok {
+			continue
+		}
+		budget := Budget(framing, report.UplinkMTU, isIPv6(proxy.Addr()))
+		if budget <= 0 {
+			continue
+		}
+		report.Budgets[proxy.Name()] = budget
+		if payload > budget {
+			report.Warnings = append(report.Warnings, fmt.Sprintf(
+				"tun mtu %d exceeds the udp budget %d via proxy %s (%s), the larger datagrams get fragmented or dropped; consider mtu %d",
+				tunMTU, budget, proxy.Name(), proxy.Type(), budget+ipv4UDPHeaders))
+		}
+	}
+	return report
+}
+
+func isIPv6(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.Is6() && !ip.Is4In6()
+}
+
+// uplink return the interface of interface-name, or the one the route to a public address
+// goes out of, DefaultUplinkMTU when it's the tun or can't be found
+func uplink(tun string) (string, int) {
+	if name := dialer.DefaultInterface.Load(); name != "" {
+		if iface, err := net.InterfaceByName(name); err == nil {
+			return iface.Name, iface.MTU
+		}
+	}
+
+	// connecting a udp socket picks the route without sending anything
+	conn, err := net.Dial("udp4", "192.0.2.1:9")
+	if err != nil {
+		return "", DefaultUplinkMTU
+	}
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", DefaultUplinkMTU
+	}
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(local) {
+				if iface.Name == tun {
+					return "", DefaultUplinkMTU
+				}
+				return iface.Name, iface.MTU
+			}
+		}
+	}
+	return "", DefaultUplinkMTU
+}
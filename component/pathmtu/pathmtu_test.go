@@ -0,0 +1,45 @@
+package pathmtu
+
+import (
+	"testing"
+
+	"github.com/Dreamacro/clash/adapter"
+	"github.com/Dreamacro/clash/adapter/outbound"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBudget(t *testing.T) {
+	assert.Equal(t, 1500-28-22, Budget(C.UDPFraming{Overhead: 22}, 1500, false))
+	assert.Equal(t, 1500-48-22, Budget(C.UDPFraming{Overhead: 22}, 1500, true))
+	assert.Equal(t, 8192, Budget(C.UDPFraming{Overhead: 23, Stream: true, MaxPayload: 8192}, 1500, false))
+	assert.Equal(t, 0, Budget(C.UDPFraming{Overhead: 18, Stream: true}, 1500, false))
+}
+
+func TestCheck(t *testing.T) {
+	ss, err := outbound.NewShadowSocks(outbound.ShadowSocksOption{
+		Name: "ss", Server: "2001:db8::1", Port: 8388, Password: "password", Cipher: "aes-128-gcm", UDP: true,
+	})
+	require.NoError(t, err)
+	proxies := []C.Proxy{
+		adapter.NewProxy(outbound.NewSocks5(outbound.Socks5Option{Name: "socks", Server: "192.0.2.2", Port: 1080, UDP: true})),
+		adapter.NewProxy(outbound.NewSocks5(outbound.Socks5Option{Name: "tcp-only", Server: "192.0.2.3", Port: 1080})),
+		adapter.NewProxy(ss),
+		adapter.NewProxy(outbound.NewDirect()),
+	}
+
+	// the mtu of the uplink differs by host, a tun as large warns of the proxies only
+	_, uplinkMTU := uplink("clashtun")
+	report := Check("clashtun", uplinkMTU, proxies)
+	socks := report.UplinkMTU - ipv4UDPHeaders - (3 + udpAddrOverhead)
+	shadowsocks := report.UplinkMTU - ipv6UDPHeaders - (16 + 16 + udpAddrOverhead)
+	assert.Equal(t, map[string]int{"socks": socks, "ss": shadowsocks}, report.Budgets)
+	assert.Len(t, report.Warnings, 2)
+
+	report = Check("clashtun", shadowsocks+ipv4UDPHeaders, proxies)
+	assert.Empty(t, report.Warnings)
+}
+
+const udpAddrOverhead = 1 + 16 + 2
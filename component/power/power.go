@@ -0,0 +1,179 @@
+package power
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/log"
+	"github.com/Dreamacro/clash/tunnel/statistic"
+
+	"go.uber.org/atomic"
+)
+
+// DefaultIdle is how long without proxied traffic suspends the background activity
+const DefaultIdle = 10 * time.Minute
+
+type Option struct {
+	Enable bool
+	Idle   time.Duration
+	// SuspendKeepalive drops the idle probe connections kept between health checks on suspend
+	SuspendKeepalive bool
+}
+
+// Hook is called on the state changes with the state locked, Resume runs before the
+// connection resuming is routed so it should only start its work and return
+type Hook struct {
+	Suspend func()
+	Resume  func()
+}
+
+// State is the power save state reported to the controller
+type State struct {
+	Enable           bool      `json:"enable"`
+	Idle             int       `json:"idle"`
+	SuspendKeepalive bool      `json:"suspend-keepalive"`
+	Suspended        bool      `json:"suspended"`
+	LastTraffic      time.Time `json:"lastTraffic"`
+}
+
+var (
+	mux       sync.Mutex
+	option    Option
+	timer     *time.Timer
+	lastTotal int64
+	hooks     = map[int]Hook{}
+	nextHook  int
+
+	suspended   = atomic.NewBool(false)
+	keepalive   = atomic.NewBool(false)
+	lastTraffic = atomic.NewInt64(time.Now().UnixNano())
+
+	// traffic return the bytes proxied so far, connections moving data don't touch
+	traffic = func() int64 {
+		up, down := statistic.DefaultManager.Total()
+		return up + down
+	}
+)
+
+// Update replaces the option, disabling power save resumes a suspended state
+func Update(opt Option) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	if timer != nil {
+		timer.Stop()
+		timer = nil
+	}
+	if opt.Idle <= 0 {
+		opt.Idle = DefaultIdle
+	}
+	option = opt
+	keepalive.Store(opt.SuspendKeepalive)
+
+	if !opt.Enable {
+		resume()
+		return
+	}
+	lastTotal = traffic()
+	timer = time.AfterFunc(opt.Idle, check)
+}
+
+// Register adds a hook called on the state changes, the returned func removes it
+func Register(h Hook) (unregister func()) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	id := nextHook
+	nextHook++
+	hooks[id] = h
+	return func() {
+		mux.Lock()
+		defer mux.Unlock()
+		delete(hooks, id)
+	}
+}
+
+// Suspended reports whether the background activity is suspended
+func Suspended() bool {
+	return suspended.Load()
+}
+
+// SuspendKeepalive reports whether the idle probe connections should be dropped on suspend
+func SuspendKeepalive() bool {
+	return keepalive.Load()
+}
+
+// Touch records a new connection, it resumes a suspended state
+func Touch() {
+	lastTraffic.Store(time.Now().UnixNano())
+	if !suspended.Load() {
+		return
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	if !resume() {
+		return
+	}
+	lastTotal = traffic()
+	if timer != nil {
+		timer.Reset(option.Idle)
+	}
+}
+
+// Snapshot return the current state
+func Snapshot() State {
+	mux.Lock()
+	defer mux.Unlock()
+
+	return State{
+		Enable:           option.Enable,
+		Idle:             int(option.Idle / time.Minute),
+		SuspendKeepalive: option.SuspendKeepalive,
+		Suspended:        suspended.Load(),
+		LastTraffic:      time.Unix(0, lastTraffic.Load()),
+	}
+}
+
+// check runs every idle duration since the last traffic, it is not rearmed once suspended
+func check() {
+	mux.Lock()
+	defer mux.Unlock()
+
+	if !option.Enable || suspended.Load() {
+		return
+	}
+
+	now := time.Now()
+	if total := traffic(); total != lastTotal {
+		lastTotal = total
+		lastTraffic.Store(now.UnixNano())
+	}
+	if idle := now.Sub(time.Unix(0, lastTraffic.Load())); idle < option.Idle {
+		timer.Reset(option.Idle - idle)
+		return
+	}
+
+	suspended.Store(true)
+	log.Infoln("[Power] no traffic for %s, health checks and provider updates suspended", option.Idle)
+	for _, h := range hooks {
+		if h.Suspend != nil {
+			h.Suspend()
+		}
+	}
+}
+
+// resume is called with mux held
+func resume() bool {
+	if !suspended.CompareAndSwap(true, false) {
+		return false
+	}
+
+	log.Infoln("[Power] traffic resumed")
+	for _, h := range hooks {
+		if h.Resume != nil {
+			h.Resume()
+		}
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package power
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+)
+
+func TestPower_SuspendResume(t *testing.T) {
+	total := atomic.NewInt64(0)
+	traffic = total.Load
+
+	events := make(chan string, 4)
+	unregister := Register(Hook{
+		Suspend: func() { events <- "suspend" },
+		Resume:  func() { events <- "resume" },
+	})
+	defer unregister()
+
+	Update(Option{Enable: true, Idle: 50 * time.Millisecond})
+	defer Update(Option{})
+
+	// traffic on the open connections holds the suspension off
+	for i := 0; i < 3; i++ {
+		time.Sleep(30 * time.Millisecond)
+		total.Add(100)
+	}
+	assert.False(t, Suspended())
+
+	select {
+	case event := <-events:
+		assert.Equal(t, "suspend", event)
+	case <-time.After(time.Second):
+		t.Fatal("not suspended")
+	}
+	assert.True(t, Suspended())
+	assert.True(t, Snapshot().Suspended)
+
+	Touch()
+	assert.Equal(t, "resume", <-events)
+	assert.False(t, Suspended())
+	assert.WithinDuration(t, time.Now(), Snapshot().LastTraffic, time.Second)
+
+	// disabling resumes too
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		t.Fatal("not suspended again")
+	}
+	Update(Option{})
+	assert.Equal(t, "resume", <-events)
+	assert.False(t, Suspended())
+}
@@ -0,0 +1,136 @@
+// Package probeserver serves the internal:// health check urls, a 204 endpoint
+// on the clash host so delay checks don't depend on an external site
+package probeserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/log"
+)
+
+// Scheme is the prefix of the health check urls served by the server,
+// internal:// or internal://host to point the probes to another address of this host
+const Scheme = "internal://"
+
+const probePath = "/generate_204/"
+
+var (
+	errDisabled = errors.New("internal:// health checks need health-check-server")
+
+	mux      sync.Mutex
+	server   *http.Server
+	address  string
+	port     int
+	hostname string
+
+	// not under mux, Update waits for the handlers to finish
+	hitsMux sync.Mutex
+	hits    = map[string]int64{}
+)
+
+// State is the state of the server GET /health-check-server reports
+type State struct {
+	Address string           `json:"address"`
+	Hits    map[string]int64 `json:"hits"`
+}
+
+// Update starts the server on addr, an empty addr stops it. The hits are kept
+// while the address doesn't change.
+func Update(addr string) error {
+	mux.Lock()
+	defer mux.Unlock()
+
+	if addr == address {
+		return nil
+	}
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		server.Shutdown(ctx)
+		cancel()
+		server = nil
+	}
+	address, port, hostname = "", 0, ""
+	hitsMux.Lock()
+	hits = map[string]int64{}
+	hitsMux.Unlock()
+	if addr == "" {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("health-check-server: %w", err)
+	}
+
+	tcpAddr := l.Addr().(*net.TCPAddr)
+	port = tcpAddr.Port
+	hostname = "127.0.0.1"
+	if !tcpAddr.IP.IsUnspecified() {
+		hostname = tcpAddr.IP.String()
+	}
+
+	srv := &http.Server{Handler: http.HandlerFunc(handle), ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(l)
+	server, address = srv, addr
+	log.Infoln("Health check server listening at: %s", l.Addr())
+	return nil
+}
+
+func handle(w http.ResponseWriter, r *http.Request) {
+	if name, ok := strings.CutPrefix(r.URL.Path, probePath); ok && name != "" {
+		hitsMux.Lock()
+		hits[name]++
+		hitsMux.Unlock()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// URL expands an internal:// url for the probe of the proxy name, the path carries
+// the name so the hits are counted per proxy. Other urls are returned as is.
+func URL(rawURL, name string) (string, error) {
+	host, ok := strings.CutPrefix(rawURL, Scheme)
+	if !ok {
+		return rawURL, nil
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	if server == nil {
+		return "", errDisabled
+	}
+
+	if host = strings.TrimSuffix(host, "/"); host == "" {
+		host = hostname
+	}
+	u := &url.URL{
+		Scheme:  "http",
+		Host:    net.JoinHostPort(host, strconv.Itoa(port)),
+		Path:    probePath + name,
+		RawPath: probePath + url.PathEscape(name),
+	}
+	return u.String(), nil
+}
+
+// Snapshot return the address and the hits per proxy
+func Snapshot() State {
+	mux.Lock()
+	state := State{Address: address}
+	mux.Unlock()
+
+	hitsMux.Lock()
+	defer hitsMux.Unlock()
+	state.Hits = make(map[string]int64, len(hits))
+	for name, n := range hits {
+		state.Hits[name] = n
+	}
+	return state
+}
@@ -0,0 +1,35 @@
+package probeserver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbeServer(t *testing.T) {
+	_, err := URL("internal://", "hk")
+	assert.ErrorIs(t, err, errDisabled)
+
+	u, err := URL("http://www.gstatic.com/generate_204", "hk")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://www.gstatic.com/generate_204", u)
+
+	require.NoError(t, Update("127.0.0.1:0"))
+	defer Update("")
+
+	u, err = URL("internal://", "hk 01/a")
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(u)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	}
+	assert.Equal(t, map[string]int64{"hk 01/a": 2}, Snapshot().Hits)
+
+	u, err = URL("internal://10.0.0.5", "hk")
+	require.NoError(t, err)
+	assert.Regexp(t, `^http://10\.0\.0\.5:\d+/generate_204/hk$`, u)
+}
@@ -24,11 +24,44 @@ var (
 // CacheFile store and update the cache file
 type CacheFile struct {
 	DB *bbolt.DB
+
+	// mem replaces DB when the cache is disabled, the state lasts for the session
+	mem *memoryCache
+}
+
+type memoryCache struct {
+	mux      sync.Mutex
+	selected map[string]string
+	fakeip   map[string][]byte
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{
+		selected: map[string]string{},
+		fakeip:   map[string][]byte{},
+	}
+}
+
+// logWriteFailed logs the failed writes once a minute, a read-only filesystem fails every one of them
+func (c *CacheFile) logWriteFailed(err error) {
+	log.Dedupln(log.WARNING, err.Error(), "[CacheFile] write cache to %s failed: %s", c.DB.Path(), err.Error())
 }
 
 func (c *CacheFile) SetSelected(group, selected string) {
+	c.SetSelectedMap(map[string]string{group: selected})
+}
+
+// SetSelectedMap stores the selections of several groups in one transaction
+func (c *CacheFile) SetSelectedMap(selected map[string]string) {
 	if !profile.StoreSelected.Load() {
 		return
+	} else if c.mem != nil {
+		c.mem.mux.Lock()
+		for group, name := range selected {
+			c.mem.selected[group] = name
+		}
+		c.mem.mux.Unlock()
+		return
 	} else if c.DB == nil {
 		return
 	}
@@ -38,10 +71,15 @@ func (c *CacheFile) SetSelected(group, selected string) {
 		if err != nil {
 			return err
 		}
-		return bucket.Put([]byte(group), []byte(selected))
+		for group, name := range selected {
+			if err := bucket.Put([]byte(group), []byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 	if err != nil {
-		log.Warnln("[CacheFile] write cache to %s failed: %s", c.DB.Path(), err.Error())
+		c.logWriteFailed(err)
 		return
 	}
 }
@@ -49,6 +87,14 @@ func (c *CacheFile) SetSelected(group, selected string) {
 func (c *CacheFile) SelectedMap() map[string]string {
 	if !profile.StoreSelected.Load() {
 		return nil
+	} else if c.mem != nil {
+		c.mem.mux.Lock()
+		defer c.mem.mux.Unlock()
+		mapping := make(map[string]string, len(c.mem.selected))
+		for group, name := range c.mem.selected {
+			mapping[group] = name
+		}
+		return mapping
 	} else if c.DB == nil {
 		return nil
 	}
@@ -70,7 +116,12 @@ func (c *CacheFile) SelectedMap() map[string]string {
 }
 
 func (c *CacheFile) PutFakeip(key, value []byte) error {
-	if c.DB == nil {
+	if c.mem != nil {
+		c.mem.mux.Lock()
+		c.mem.fakeip[string(key)] = append([]byte(nil), value...)
+		c.mem.mux.Unlock()
+		return nil
+	} else if c.DB == nil {
 		return nil
 	}
 
@@ -82,14 +133,22 @@ func (c *CacheFile) PutFakeip(key, value []byte) error {
 		return bucket.Put(key, value)
 	})
 	if err != nil {
-		log.Warnln("[CacheFile] write cache to %s failed: %s", c.DB.Path(), err.Error())
+		c.logWriteFailed(err)
 	}
 
 	return err
 }
 
 func (c *CacheFile) DelFakeipPair(ip, host []byte) error {
-	if c.DB == nil {
+	if c.mem != nil {
+		c.mem.mux.Lock()
+		delete(c.mem.fakeip, string(ip))
+		if len(host) > 0 {
+			delete(c.mem.fakeip, string(host))
+		}
+		c.mem.mux.Unlock()
+		return nil
+	} else if c.DB == nil {
 		return nil
 	}
 
@@ -107,14 +166,18 @@ func (c *CacheFile) DelFakeipPair(ip, host []byte) error {
 		return err
 	})
 	if err != nil {
-		log.Warnln("[CacheFile] write cache to %s failed: %s", c.DB.Path(), err.Error())
+		c.logWriteFailed(err)
 	}
 
 	return err
 }
 
 func (c *CacheFile) GetFakeip(key []byte) []byte {
-	if c.DB == nil {
+	if c.mem != nil {
+		c.mem.mux.Lock()
+		defer c.mem.mux.Unlock()
+		return c.mem.fakeip[string(key)]
+	} else if c.DB == nil {
 		return nil
 	}
 
@@ -132,11 +195,49 @@ func (c *CacheFile) GetFakeip(key []byte) []byte {
 	return bucket.Get(key)
 }
 
+// FlushFakeip drops the persisted fake ip mappings and return the number of keys dropped,
+// a mapping is stored under its ip and its host
+func (c *CacheFile) FlushFakeip() int {
+	if c.mem != nil {
+		c.mem.mux.Lock()
+		defer c.mem.mux.Unlock()
+		n := len(c.mem.fakeip)
+		c.mem.fakeip = map[string][]byte{}
+		return n
+	} else if c.DB == nil {
+		return 0
+	}
+
+	n := 0
+	err := c.DB.Update(func(t *bbolt.Tx) error {
+		bucket := t.Bucket(bucketFakeip)
+		if bucket == nil {
+			return nil
+		}
+		n = bucket.Stats().KeyN
+		return t.DeleteBucket(bucketFakeip)
+	})
+	if err != nil {
+		c.logWriteFailed(err)
+		return 0
+	}
+
+	return n
+}
+
 func (c *CacheFile) Close() error {
+	if c.DB == nil {
+		return nil
+	}
 	return c.DB.Close()
 }
 
 func initCache() {
+	if C.Path.CacheDisabled() {
+		defaultCache = &CacheFile{mem: newMemoryCache()}
+		return
+	}
+
 	options := bbolt.Options{Timeout: time.Second}
 	db, err := bbolt.Open(C.Path.Cache(), fileMode, &options)
 	switch err {
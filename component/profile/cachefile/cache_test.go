@@ -0,0 +1,27 @@
+package cachefile
+
+import (
+	"testing"
+
+	"github.com/Dreamacro/clash/component/profile"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheFile_Memory(t *testing.T) {
+	profile.StoreSelected.Store(true)
+	c := &CacheFile{mem: newMemoryCache()}
+
+	c.SetSelected("Proxy", "hk")
+	c.SetSelectedMap(map[string]string{"Proxy": "jp", "Video": "us"})
+	assert.Equal(t, map[string]string{"Proxy": "jp", "Video": "us"}, c.SelectedMap())
+
+	assert.NoError(t, c.PutFakeip([]byte("198.18.0.1"), []byte("example.com")))
+	assert.NoError(t, c.PutFakeip([]byte("example.com"), []byte("198.18.0.1")))
+	assert.Equal(t, []byte("example.com"), c.GetFakeip([]byte("198.18.0.1")))
+
+	assert.NoError(t, c.DelFakeipPair([]byte("198.18.0.1"), []byte("example.com")))
+	assert.Nil(t, c.GetFakeip([]byte("198.18.0.1")))
+	assert.Nil(t, c.GetFakeip([]byte("example.com")))
+	assert.NoError(t, c.Close())
+}
@@ -64,17 +64,7 @@ func LookupIPv4(ctx context.Context, host string) ([]net.IP, error) {
 	if DefaultResolver != nil {
 		return DefaultResolver.LookupIPv4(ctx, host)
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), DefaultDNSTimeout)
-	defer cancel()
-	ipAddrs, err := net.DefaultResolver.LookupIP(ctx, "ip4", host)
-	if err != nil {
-		return nil, err
-	} else if len(ipAddrs) == 0 {
-		return nil, ErrIPNotFound
-	}
-
-	return ipAddrs, nil
+	return System.LookupIPv4(ctx, host)
 }
 
 // ResolveIPv4 with a host, return ipv4
@@ -111,17 +101,7 @@ func LookupIPv6(ctx context.Context, host string) ([]net.IP, error) {
 	if DefaultResolver != nil {
 		return DefaultResolver.LookupIPv6(ctx, host)
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), DefaultDNSTimeout)
-	defer cancel()
-	ipAddrs, err := net.DefaultResolver.LookupIP(ctx, "ip6", host)
-	if err != nil {
-		return nil, err
-	} else if len(ipAddrs) == 0 {
-		return nil, ErrIPNotFound
-	}
-
-	return ipAddrs, nil
+	return System.LookupIPv6(ctx, host)
 }
 
 // ResolveIPv6 with a host, return ipv6
@@ -154,15 +134,7 @@ func LookupIPWithResolver(ctx context.Context, host string, r Resolver) ([]net.I
 	if ip != nil {
 		return []net.IP{ip}, nil
 	}
-
-	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
-	if err != nil {
-		return nil, err
-	} else if len(ips) == 0 {
-		return nil, ErrIPNotFound
-	}
-
-	return ips, nil
+	return System.LookupIP(ctx, host)
 }
 
 // ResolveIP with a host, return ip
@@ -0,0 +1,76 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// ErrSystemExchange is returned by the system resolver for raw dns messages
+var ErrSystemExchange = errors.New("dns exchange is not supported by the system resolver")
+
+// System resolves with the Go resolver and so the system config, it is used
+// whenever DefaultResolver is nil, i.e. the dns section is disabled
+var System Resolver = &systemResolver{}
+
+type systemResolver struct{}
+
+func (r *systemResolver) lookup(ctx context.Context, network, host string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultDNSTimeout)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, err
+	} else if len(ips) == 0 {
+		return nil, ErrIPNotFound
+	}
+	return ips, nil
+}
+
+func (r *systemResolver) resolve(network, host string) (net.IP, error) {
+	ips, err := r.lookup(context.Background(), network, host)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, host)
+	}
+	return ips[rand.Intn(len(ips))], nil
+}
+
+// LookupIP implements Resolver
+func (r *systemResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	return r.lookup(ctx, "ip", host)
+}
+
+// LookupIPv4 implements Resolver
+func (r *systemResolver) LookupIPv4(ctx context.Context, host string) ([]net.IP, error) {
+	return r.lookup(ctx, "ip4", host)
+}
+
+// LookupIPv6 implements Resolver
+func (r *systemResolver) LookupIPv6(ctx context.Context, host string) ([]net.IP, error) {
+	return r.lookup(ctx, "ip6", host)
+}
+
+// ResolveIP implements Resolver
+func (r *systemResolver) ResolveIP(host string) (net.IP, error) {
+	return r.resolve("ip", host)
+}
+
+// ResolveIPv4 implements Resolver
+func (r *systemResolver) ResolveIPv4(host string) (net.IP, error) {
+	return r.resolve("ip4", host)
+}
+
+// ResolveIPv6 implements Resolver
+func (r *systemResolver) ResolveIPv6(host string) (net.IP, error) {
+	return r.resolve("ip6", host)
+}
+
+// ExchangeContext implements Resolver
+func (r *systemResolver) ExchangeContext(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	return nil, ErrSystemExchange
+}
@@ -0,0 +1,28 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupIP_System(t *testing.T) {
+	old := DefaultResolver
+	DefaultResolver = nil
+	defer func() { DefaultResolver = old }()
+
+	ips, err := LookupIPv4(context.Background(), "localhost")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ips)
+
+	// the system lookup honours the caller's context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = LookupIPWithResolver(ctx, "nonexistent.invalid", nil)
+	assert.Error(t, err)
+
+	_, err = System.ExchangeContext(context.Background(), &dns.Msg{})
+	assert.ErrorIs(t, err, ErrSystemExchange)
+}
@@ -0,0 +1,74 @@
+package sniff
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+)
+
+var (
+	ErrNotHTTP = errors.New("not a http request")
+	ErrNoHost  = errors.New("http request without host")
+
+	methods = []string{"GET", "POST", "HEAD", "PUT", "DELETE", "OPTIONS", "PATCH", "CONNECT", "TRACE"}
+)
+
+type httpSniffer struct{}
+
+func (httpSniffer) Protocol() string { return "http" }
+
+func (httpSniffer) Sniff(data []byte) (string, error) {
+	return HostHeader(data)
+}
+
+// HostHeader return the host of the host header of the http/1 request starting data, without
+// the port, ErrNoHost for an ip
+func HostHeader(data []byte) (string, error) {
+	method, _, found := bytes.Cut(data, []byte(" "))
+	if !knownMethod(string(method), found) {
+		return "", ErrNotHTTP
+	}
+
+	lines := data
+	for first := true; ; first = false {
+		line, rest, found := bytes.Cut(lines, []byte("\r\n"))
+		if !found {
+			return "", ErrShort
+		}
+		lines = rest
+		if first {
+			if !bytes.Contains(line, []byte(" HTTP/1.")) {
+				return "", ErrNotHTTP
+			}
+			continue
+		}
+		if len(line) == 0 {
+			return "", ErrNoHost
+		}
+
+		key, value, found := bytes.Cut(line, []byte(":"))
+		if !found || !strings.EqualFold(string(key), "host") {
+			continue
+		}
+		host := strings.TrimSpace(string(value))
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if host == "" || net.ParseIP(strings.Trim(host, "[]")) != nil {
+			return "", ErrNoHost
+		}
+		return host, nil
+	}
+}
+
+// knownMethod tells whether method is a http method, or the start of one cut short
+func knownMethod(method string, complete bool) bool {
+	for _, m := range methods {
+		if complete && method == m || !complete && strings.HasPrefix(m, method) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package sniff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostHeader(t *testing.T) {
+	for request, host := range map[string]string{
+		"GET / HTTP/1.1\r\nHost: Example.com\r\n\r\n":                         "example.com",
+		"POST /api HTTP/1.1\r\nUser-Agent: curl\r\nhost:example.com:8080\r\n": "example.com",
+		"GET http://example.com/ HTTP/1.1\r\nHost: example.com.\r\n":          "example.com",
+	} {
+		got, err := HostHeader([]byte(request))
+		if assert.NoError(t, err, request) {
+			assert.Equal(t, host, got)
+		}
+	}
+
+	for request, expected := range map[string]error{
+		"":                                      ErrShort,
+		"GE":                                    ErrShort,
+		"GET / HTTP/1.1\r\nAccept: */*":         ErrShort,
+		"GET / HTTP/1.0\r\n\r\n":                ErrNoHost,
+		"GET / HTTP/1.1\r\nHost: [::1]:80\r\n":  ErrNoHost,
+		"GET / HTTP/1.1\r\nHost: 192.0.2.1\r\n": ErrNoHost,
+		"SSH-2.0-OpenSSH_9.6\r\n":               ErrNotHTTP,
+		"GET /\r\n":                             ErrNotHTTP,
+	} {
+		_, err := HostHeader([]byte(request))
+		assert.ErrorIs(t, err, expected, request)
+	}
+}
@@ -0,0 +1,82 @@
+package sniff
+
+import (
+	"errors"
+	"time"
+
+	N "github.com/Dreamacro/clash/common/net"
+)
+
+// ErrShort is returned by a Sniffer for data ending before the host
+var ErrShort = errors.New("data is cut short")
+
+// Sniffer reads the host a client asks for from the first bytes it sends, the start of a
+// tcp stream or a datagram for a protocol over udp like quic
+type Sniffer interface {
+	Protocol() string
+	// Sniff return the host in data, ErrShort if more data may hold it
+	Sniff(data []byte) (string, error)
+}
+
+var (
+	// TLS reads the server name of a client hello
+	TLS Sniffer = tlsSniffer{}
+	// HTTP reads the host header of a http/1 request
+	HTTP Sniffer = httpSniffer{}
+)
+
+type tlsSniffer struct{}
+
+func (tlsSniffer) Protocol() string { return "tls" }
+
+func (tlsSniffer) Sniff(data []byte) (string, error) {
+	if len(data) < RecordHeaderSize {
+		if len(data) == 0 || data[0] == recordTypeHandshake {
+			return "", ErrShort
+		}
+		return "", ErrNotTLS
+	}
+	size, err := RecordSize(data)
+	if err != nil {
+		return "", err
+	}
+	name, err := ServerName(data)
+	// the end of a record cut short may hold the server name
+	if err != nil && len(data) < size {
+		return "", ErrShort
+	}
+	return name, err
+}
+
+// Stream return the host and the protocol of the first sniffer reading one at the start of
+// conn, empty for none. The bytes stay in the buffer of conn, more are waited for while a
+// sniffer is cut short, until the buffer is full or the timeout.
+func Stream(conn *N.BufferedConn, sniffers []Sniffer, timeout time.Duration) (host string, protocol string) {
+	if conn.SetReadDeadline(time.Now().Add(timeout)) != nil {
+		return "", ""
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	size := conn.Reader().Size()
+	for n := 1; ; n = conn.Buffered() + 1 {
+		// what is buffered comes along the error of the deadline
+		data, err := conn.Peek(n)
+		if len(data) == 0 {
+			return "", ""
+		}
+		if err == nil {
+			data, _ = conn.Peek(conn.Buffered())
+		}
+		short := false
+		for _, sniffer := range sniffers {
+			host, sniffErr := sniffer.Sniff(data)
+			if sniffErr == nil {
+				return host, sniffer.Protocol()
+			}
+			short = short || errors.Is(sniffErr, ErrShort)
+		}
+		if !short || err != nil || len(data) >= size {
+			return "", ""
+		}
+	}
+}
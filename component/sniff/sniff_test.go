@@ -0,0 +1,49 @@
+package sniff
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	N "github.com/Dreamacro/clash/common/net"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStream(t *testing.T) {
+	record := clientHello(t, "example.com")
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		// the hello comes in two segments
+		client.Write(record[:10])
+		time.Sleep(10 * time.Millisecond)
+		client.Write(record[10:])
+	}()
+
+	conn := N.NewBufferedConn(server)
+	host, protocol := Stream(conn, []Sniffer{HTTP, TLS}, time.Second)
+	assert.Equal(t, "example.com", host)
+	assert.Equal(t, "tls", protocol)
+	assert.Equal(t, len(record), conn.Buffered())
+}
+
+func TestStream_Timeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("GET / HTTP/1.1\r\n"))
+
+	// a request cut short
+	conn := N.NewBufferedConn(server)
+	host, _ := Stream(conn, []Sniffer{TLS, HTTP}, 50*time.Millisecond)
+	assert.Empty(t, host)
+	assert.Equal(t, 16, conn.Buffered())
+
+	// a server speaking first
+	start := time.Now()
+	client, server = net.Pipe()
+	defer client.Close()
+	host, _ = Stream(N.NewBufferedConn(server), []Sniffer{TLS, HTTP}, 50*time.Millisecond)
+	assert.Empty(t, host)
+	assert.Less(t, time.Since(start), time.Second)
+}
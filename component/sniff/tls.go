@@ -0,0 +1,141 @@
+package sniff
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+const (
+	recordTypeHandshake  = 0x16
+	handshakeClientHello = 0x01
+	extensionServerName  = 0x0000
+	serverNameHostName   = 0x00
+
+	// RecordHeaderSize is the size of the header of a tls record
+	RecordHeaderSize = 5
+)
+
+var (
+	ErrNotTLS  = errors.New("not a tls client hello")
+	ErrNoSNI   = errors.New("client hello without server name")
+	errBadSize = errors.New("bad length in client hello")
+)
+
+// RecordSize return the size of the tls record starting with header, the header included,
+// or ErrNotTLS if it's not the handshake record a client hello goes in
+func RecordSize(header []byte) (int, error) {
+	if len(header) < RecordHeaderSize || header[0] != recordTypeHandshake || header[1] != 0x03 {
+		return 0, ErrNotTLS
+	}
+	return RecordHeaderSize + int(binary.BigEndian.Uint16(header[3:5])), nil
+}
+
+// ServerName return the server name of the client hello in the tls record, the record may be
+// cut short by the buffer it was peeked into, the name is read as long as it's in there
+func ServerName(record []byte) (string, error) {
+	if _, err := RecordSize(record); err != nil {
+		return "", err
+	}
+	b := reader(record[RecordHeaderSize:])
+
+	msgType, ok := b.u8()
+	if !ok || msgType != handshakeClientHello {
+		return "", ErrNotTLS
+	}
+	// handshake length, client version and random
+	if !b.skip(3 + 2 + 32) {
+		return "", ErrShort
+	}
+	// session id, cipher suites and compression methods
+	if !b.skipVector(1) || !b.skipVector(2) || !b.skipVector(1) {
+		return "", ErrShort
+	}
+
+	extensions, ok := b.vector(2)
+	if !ok {
+		// a hello without extensions ends where the record does
+		if len(b) < 2 {
+			return "", ErrNoSNI
+		}
+		// the extensions are cut short
+		extensions = b[2:]
+	}
+	for len(extensions) > 0 {
+		typ, ok1 := extensions.u16()
+		data, ok2 := extensions.vector(2)
+		if !ok1 || !ok2 {
+			return "", ErrShort
+		}
+		if typ != extensionServerName {
+			continue
+		}
+
+		list, ok := data.vector(2)
+		if !ok {
+			return "", errBadSize
+		}
+		for len(list) > 0 {
+			nameType, ok1 := list.u8()
+			name, ok2 := list.vector(2)
+			if !ok1 || !ok2 {
+				return "", errBadSize
+			}
+			if nameType == serverNameHostName && len(name) > 0 {
+				return strings.ToLower(strings.TrimSuffix(string(name), ".")), nil
+			}
+		}
+		return "", ErrNoSNI
+	}
+	return "", ErrNoSNI
+}
+
+type reader []byte
+
+func (r *reader) u8() (byte, bool) {
+	if len(*r) < 1 {
+		return 0, false
+	}
+	v := (*r)[0]
+	*r = (*r)[1:]
+	return v, true
+}
+
+func (r *reader) u16() (uint16, bool) {
+	if len(*r) < 2 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint16(*r)
+	*r = (*r)[2:]
+	return v, true
+}
+
+func (r *reader) skip(n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*r = (*r)[n:]
+	return true
+}
+
+// vector reads the data after a length of size bytes
+func (r *reader) vector(size int) (reader, bool) {
+	if len(*r) < size {
+		return nil, false
+	}
+	n := 0
+	for _, c := range (*r)[:size] {
+		n = n<<8 | int(c)
+	}
+	if len(*r) < size+n {
+		return nil, false
+	}
+	v := (*r)[size : size+n]
+	*r = (*r)[size+n:]
+	return v, true
+}
+
+func (r *reader) skipVector(size int) bool {
+	_, ok := r.vector(size)
+	return ok
+}
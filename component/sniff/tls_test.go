@@ -0,0 +1,66 @@
+package sniff
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clientHello return the first record a tls client sends for serverName
+func clientHello(t *testing.T, serverName string) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+		client.Close()
+	}()
+
+	header := make([]byte, RecordHeaderSize)
+	_, err := server.Read(header)
+	require.NoError(t, err)
+	size, err := RecordSize(header)
+	require.NoError(t, err)
+
+	record := append(header, make([]byte, size-RecordHeaderSize)...)
+	for n := RecordHeaderSize; n < size; {
+		m, err := server.Read(record[n:])
+		require.NoError(t, err)
+		n += m
+	}
+	return record
+}
+
+func TestServerName(t *testing.T) {
+	record := clientHello(t, "Www.Example.com")
+	name, err := ServerName(record)
+	require.NoError(t, err)
+	assert.Equal(t, "www.example.com", name)
+
+	// no server name for an ip
+	_, err = ServerName(clientHello(t, "192.0.2.1"))
+	assert.ErrorIs(t, err, ErrNoSNI)
+}
+
+func TestServerName_Truncated(t *testing.T) {
+	record := clientHello(t, "example.com")
+	// the server name comes early, cutting the hello doesn't lose it
+	for _, size := range []int{len(record) - 1, len(record) - 64} {
+		name, err := ServerName(record[:size])
+		if assert.NoError(t, err, size) {
+			assert.Equal(t, "example.com", name)
+		}
+	}
+
+	for _, size := range []int{0, 3, 10, 50} {
+		_, err := ServerName(record[:size])
+		assert.Error(t, err, size)
+	}
+}
+
+func TestServerName_NotTLS(t *testing.T) {
+	_, err := ServerName([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrNotTLS)
+}
@@ -0,0 +1,131 @@
+package stun
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+const (
+	headerSize  = 20
+	magicCookie = 0x2112A442
+
+	typeBindingRequest  = 0x0001
+	typeBindingResponse = 0x0101
+
+	attrMappedAddress    = 0x0001
+	attrChangeRequest    = 0x0003
+	attrChangedAddress   = 0x0005 // RFC 3489, OTHER-ADDRESS of the older servers
+	attrXorMappedAddress = 0x0020
+	attrOtherAddress     = 0x802c
+
+	changeIP   = 0x04
+	changePort = 0x02
+)
+
+var errBadMessage = errors.New("bad stun message")
+
+type transactionID [12]byte
+
+// response is what the tests need of a binding response
+type response struct {
+	mapped *net.UDPAddr
+	// other is the alternate address of an RFC 5780 server, nil for the others
+	other *net.UDPAddr
+}
+
+// bindingRequest return a binding request, with a CHANGE-REQUEST when change isn't 0
+func bindingRequest(change uint32) (transactionID, []byte) {
+	var id transactionID
+	rand.Read(id[:])
+
+	length := 0
+	if change != 0 {
+		length = 8
+	}
+	b := make([]byte, headerSize+length)
+	binary.BigEndian.PutUint16(b[0:], typeBindingRequest)
+	binary.BigEndian.PutUint16(b[2:], uint16(length))
+	binary.BigEndian.PutUint32(b[4:], magicCookie)
+	copy(b[8:headerSize], id[:])
+	if change != 0 {
+		binary.BigEndian.PutUint16(b[20:], attrChangeRequest)
+		binary.BigEndian.PutUint16(b[22:], 4)
+		binary.BigEndian.PutUint32(b[24:], change)
+	}
+	return id, b
+}
+
+// parseResponse parses the binding response to the request id
+func parseResponse(id transactionID, b []byte) (*response, error) {
+	if len(b) < headerSize || binary.BigEndian.Uint16(b[0:]) != typeBindingResponse ||
+		binary.BigEndian.Uint32(b[4:]) != magicCookie || transactionID(b[8:headerSize]) != id {
+		return nil, errBadMessage
+	}
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if len(b) < headerSize+length {
+		return nil, errBadMessage
+	}
+
+	resp := &response{}
+	var mapped *net.UDPAddr
+	for attrs := b[headerSize : headerSize+length]; len(attrs) >= 4; {
+		typ := binary.BigEndian.Uint16(attrs[0:])
+		size := int(binary.BigEndian.Uint16(attrs[2:]))
+		if len(attrs) < 4+size {
+			return nil, errBadMessage
+		}
+		value := attrs[4 : 4+size]
+		// the attributes are padded to 4 bytes
+		if next := 4 + (size+3)&^3; next < len(attrs) {
+			attrs = attrs[next:]
+		} else {
+			attrs = nil
+		}
+
+		switch typ {
+		case attrXorMappedAddress:
+			resp.mapped = parseAddress(value, id, true)
+		case attrMappedAddress:
+			mapped = parseAddress(value, id, false)
+		case attrOtherAddress, attrChangedAddress:
+			resp.other = parseAddress(value, id, false)
+		}
+	}
+	// the servers of RFC 3489 only send MAPPED-ADDRESS
+	if resp.mapped == nil {
+		resp.mapped = mapped
+	}
+	if resp.mapped == nil {
+		return nil, errors.New("stun response without mapped address")
+	}
+	return resp, nil
+}
+
+func parseAddress(value []byte, id transactionID, xor bool) *net.UDPAddr {
+	if len(value) < 4 {
+		return nil
+	}
+	var ip net.IP
+	switch family := value[1]; {
+	case family == 0x01 && len(value) >= 8:
+		ip = append(net.IP{}, value[4:8]...)
+	case family == 0x02 && len(value) >= 20:
+		ip = append(net.IP{}, value[4:20]...)
+	default:
+		return nil
+	}
+	port := binary.BigEndian.Uint16(value[2:])
+
+	if xor {
+		port ^= magicCookie >> 16
+		var key [16]byte
+		binary.BigEndian.PutUint32(key[:], magicCookie)
+		copy(key[4:], id[:])
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}
+}
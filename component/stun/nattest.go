@@ -0,0 +1,197 @@
+package stun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the mapping and filtering behaviors of RFC 5780
+const (
+	EndpointIndependent     = "endpoint-independent"
+	AddressDependent        = "address-dependent"
+	AddressAndPortDependent = "address-and-port-dependent"
+	// Dependent is a mapping that changes with the server, the servers without an
+	// alternate address can't tell if it's by address only
+	Dependent = "dependent"
+	Unknown   = "unknown"
+)
+
+const (
+	// a request is sent again after retransmitTimeout, up to retransmits times
+	retransmitTimeout = 500 * time.Millisecond
+	retransmits       = 3
+)
+
+// DefaultServers are the stun servers of the nat tests, the first one is asked first
+var DefaultServers = []string{"stun.l.google.com:19302", "stun.cloudflare.com:3478"}
+
+var (
+	serversMux sync.RWMutex
+	servers    = DefaultServers
+)
+
+// SetServers sets the stun servers of the nat tests, empty restores DefaultServers
+func SetServers(s []string) {
+	serversMux.Lock()
+	defer serversMux.Unlock()
+	if len(s) == 0 {
+		s = DefaultServers
+	}
+	servers = s
+}
+
+// Servers return the stun servers of the nat tests
+func Servers() []string {
+	serversMux.RLock()
+	defer serversMux.RUnlock()
+	return servers
+}
+
+// ValidateServers checks the host:port of the stun servers
+func ValidateServers(s []string) error {
+	for _, server := range s {
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			return fmt.Errorf("stun server %s: %w", server, err)
+		}
+		if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 || host == "" {
+			return fmt.Errorf("stun server %s: expect host:port", server)
+		}
+	}
+	return nil
+}
+
+// Result is the nat behavior seen through a packet conn, Server is the stun server
+// of the mapped address
+type Result struct {
+	PublicIP      string `json:"publicIP"`
+	MappedAddress string `json:"mappedAddress"`
+	Mapping       string `json:"mapping"`
+	Filtering     string `json:"filtering"`
+	Server        string `json:"server"`
+}
+
+// packetConn is the part of a proxy packet conn the tests use
+type packetConn interface {
+	WriteTo(b []byte, addr net.Addr) (int, error)
+	ReadFrom(b []byte) (int, net.Addr, error)
+	SetReadDeadline(t time.Time) error
+}
+
+// Test runs the behavior discovery of RFC 5780 through pc against the servers, the first
+// one answering is the primary. With an alternate address from it both the mapping and
+// the filtering are tested, without one the mapping is compared with the second server
+// and the filtering is unknown.
+func Test(ctx context.Context, pc packetConn, servers []*net.UDPAddr) (*Result, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("no stun server")
+	}
+
+	var primary *net.UDPAddr
+	var first *response
+	var err error
+	for _, server := range servers {
+		if first, err = request(ctx, pc, server, 0); err == nil {
+			primary = server
+			break
+		}
+	}
+	if primary == nil {
+		return nil, fmt.Errorf("no stun server answered: %w", err)
+	}
+
+	result := &Result{
+		PublicIP:      first.mapped.IP.String(),
+		MappedAddress: first.mapped.String(),
+		Mapping:       Unknown,
+		Filtering:     Unknown,
+		Server:        primary.String(),
+	}
+
+	other := first.other
+	if other == nil || other.IP.Equal(primary.IP) || other.Port == primary.Port {
+		// a plain server, compare with another one
+		for _, server := range servers {
+			if server == primary {
+				continue
+			}
+			if resp, err := request(ctx, pc, server, 0); err == nil {
+				result.Mapping = Dependent
+				if sameAddr(resp.mapped, first.mapped) {
+					result.Mapping = EndpointIndependent
+				}
+				break
+			}
+		}
+		return result, ctx.Err()
+	}
+
+	// filtering, a reply from the alternate ip and port then from the alternate port only. It
+	// goes first, the mapping tests would let the alternate address through the filter.
+	if _, err := request(ctx, pc, primary, changeIP|changePort); err == nil {
+		result.Filtering = EndpointIndependent
+	} else if _, err := request(ctx, pc, primary, changePort); err == nil {
+		result.Filtering = AddressDependent
+	} else if ctx.Err() == nil {
+		result.Filtering = AddressAndPortDependent
+	}
+
+	// mapping, the alternate ip with the primary port then the alternate port
+	if second, err := request(ctx, pc, &net.UDPAddr{IP: other.IP, Port: primary.Port}, 0); err == nil {
+		if sameAddr(second.mapped, first.mapped) {
+			result.Mapping = EndpointIndependent
+		} else if third, err := request(ctx, pc, other, 0); err == nil {
+			result.Mapping = AddressAndPortDependent
+			if sameAddr(third.mapped, second.mapped) {
+				result.Mapping = AddressDependent
+			}
+		}
+	}
+
+	return result, ctx.Err()
+}
+
+// request sends a binding request to server and waits for its response, from any source
+// as a changed request is answered from the alternate address
+func request(ctx context.Context, pc packetConn, server *net.UDPAddr, change uint32) (*response, error) {
+	id, msg := bindingRequest(change)
+	buf := make([]byte, 1500)
+	for i := 0; i < retransmits; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := pc.WriteTo(msg, server); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(retransmitTimeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		pc.SetReadDeadline(deadline)
+		for {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			// the late responses of the previous requests are dropped
+			if resp, err := parseResponse(id, buf[:n]); err == nil {
+				return resp, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no response from %s", server)
+}
+
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
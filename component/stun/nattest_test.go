@@ -0,0 +1,236 @@
+package stun
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfc5780Server answers on two ips and two ports, the alternate address goes in OTHER-ADDRESS
+// when other is set. It return the primary and the alternate address.
+func rfc5780Server(t *testing.T, other bool) (*net.UDPAddr, *net.UDPAddr) {
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2)}
+	var conns [2][2]*net.UDPConn
+	var ports [2]int
+	for i := range ports {
+		for {
+			c, err := net.ListenUDP("udp", &net.UDPAddr{IP: ips[0]})
+			require.NoError(t, err)
+			port := c.LocalAddr().(*net.UDPAddr).Port
+			if c2, err := net.ListenUDP("udp", &net.UDPAddr{IP: ips[1], Port: port}); err == nil {
+				conns[0][i], conns[1][i], ports[i] = c, c2, port
+				break
+			}
+			c.Close()
+		}
+	}
+	t.Cleanup(func() {
+		for _, row := range conns {
+			for _, c := range row {
+				c.Close()
+			}
+		}
+	})
+
+	alternate := &net.UDPAddr{IP: ips[1], Port: ports[1]}
+	for i, row := range conns {
+		for j, c := range row {
+			go func(ip, port int, c *net.UDPConn) {
+				buf := make([]byte, 1500)
+				for {
+					n, src, err := c.ReadFromUDP(buf)
+					if err != nil {
+						return
+					}
+					if n < headerSize {
+						continue
+					}
+					var change uint32
+					if n >= headerSize+8 && binary.BigEndian.Uint16(buf[20:]) == attrChangeRequest {
+						change = binary.BigEndian.Uint32(buf[24:])
+					}
+					fromIP, fromPort := ip, port
+					if change&changeIP != 0 {
+						fromIP = 1 - ip
+					}
+					if change&changePort != 0 {
+						fromPort = 1 - port
+					}
+					var id transactionID
+					copy(id[:], buf[8:headerSize])
+					conns[fromIP][fromPort].WriteToUDP(bindingResponse(id, src, alternate, other), src)
+				}
+			}(i, j, c)
+		}
+	}
+	return &net.UDPAddr{IP: ips[0], Port: ports[0]}, alternate
+}
+
+func bindingResponse(id transactionID, mapped, other *net.UDPAddr, withOther bool) []byte {
+	attr := func(typ uint16, addr *net.UDPAddr, xor bool) []byte {
+		b := make([]byte, 12)
+		binary.BigEndian.PutUint16(b, typ)
+		binary.BigEndian.PutUint16(b[2:], 8)
+		b[5] = 0x01
+		port, ip := uint16(addr.Port), append(net.IP{}, addr.IP.To4()...)
+		if xor {
+			port ^= magicCookie >> 16
+			for i := range ip {
+				ip[i] ^= byte(uint32(magicCookie) >> (24 - 8*i))
+			}
+		}
+		binary.BigEndian.PutUint16(b[6:], port)
+		copy(b[8:], ip)
+		return b
+	}
+	attrs := attr(attrXorMappedAddress, mapped, true)
+	if withOther {
+		attrs = append(attrs, attr(attrOtherAddress, other, false)...)
+	}
+	b := make([]byte, headerSize, headerSize+len(attrs))
+	binary.BigEndian.PutUint16(b, typeBindingResponse)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(attrs)))
+	binary.BigEndian.PutUint32(b[4:], magicCookie)
+	copy(b[8:], id[:])
+	return append(b, attrs...)
+}
+
+type datagram struct {
+	data   []byte
+	src    *net.UDPAddr
+	socket *natSocket
+}
+
+// natSocket is a mapping, with the destinations it sent to
+type natSocket struct {
+	*net.UDPConn
+	sent map[string]bool
+}
+
+// natConn behaves like a nat of the given mapping and filtering
+type natConn struct {
+	t         *testing.T
+	mapping   string
+	filtering string
+	mux       sync.Mutex
+	sockets   map[string]*natSocket
+	in        chan datagram
+	deadline  time.Time
+}
+
+func newNATConn(t *testing.T, mapping, filtering string) *natConn {
+	c := &natConn{t: t, mapping: mapping, filtering: filtering, sockets: map[string]*natSocket{}, in: make(chan datagram, 16)}
+	t.Cleanup(func() {
+		for _, s := range c.sockets {
+			s.Close()
+		}
+	})
+	return c
+}
+
+func (c *natConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	dst := addr.(*net.UDPAddr)
+	key := ""
+	switch c.mapping {
+	case AddressDependent:
+		key = dst.IP.String()
+	case AddressAndPortDependent:
+		key = dst.String()
+	}
+	socket, ok := c.sockets[key]
+	if !ok {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(c.t, err)
+		socket = &natSocket{UDPConn: conn, sent: map[string]bool{}}
+		c.sockets[key] = socket
+		go func() {
+			for {
+				buf := make([]byte, 1500)
+				n, src, err := socket.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				c.in <- datagram{buf[:n], src, socket}
+			}
+		}()
+	}
+	c.mux.Lock()
+	socket.sent[dst.IP.String()], socket.sent[dst.String()] = true, true
+	c.mux.Unlock()
+	return socket.WriteTo(b, addr)
+}
+
+func (c *natConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		select {
+		case d := <-c.in:
+			c.mux.Lock()
+			drop := (c.filtering == AddressDependent && !d.socket.sent[d.src.IP.String()]) ||
+				(c.filtering == AddressAndPortDependent && !d.socket.sent[d.src.String()])
+			c.mux.Unlock()
+			if drop {
+				continue
+			}
+			return copy(b, d.data), d.src, nil
+		case <-time.After(time.Until(c.deadline)):
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (c *natConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func TestNATTest_RFC5780(t *testing.T) {
+	primary, _ := rfc5780Server(t, true)
+
+	for _, behavior := range []string{EndpointIndependent, AddressDependent, AddressAndPortDependent} {
+		t.Run(behavior, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			result, err := Test(ctx, newNATConn(t, behavior, behavior), []*net.UDPAddr{primary})
+			require.NoError(t, err)
+			assert.Equal(t, behavior, result.Mapping)
+			assert.Equal(t, behavior, result.Filtering)
+			assert.Equal(t, "127.0.0.1", result.PublicIP)
+			assert.Equal(t, primary.String(), result.Server)
+		})
+	}
+}
+
+func TestNATTest_PlainServers(t *testing.T) {
+	first, _ := rfc5780Server(t, false)
+	second, _ := rfc5780Server(t, false)
+	ctx := context.Background()
+
+	result, err := Test(ctx, newNATConn(t, EndpointIndependent, EndpointIndependent), []*net.UDPAddr{first, second})
+	require.NoError(t, err)
+	assert.Equal(t, EndpointIndependent, result.Mapping)
+	assert.Equal(t, Unknown, result.Filtering)
+
+	result, err = Test(ctx, newNATConn(t, AddressAndPortDependent, EndpointIndependent), []*net.UDPAddr{first, second})
+	require.NoError(t, err)
+	assert.Equal(t, Dependent, result.Mapping)
+
+	// nobody answers
+	closed, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	closed.Close()
+	_, err = Test(ctx, newNATConn(t, EndpointIndependent, EndpointIndependent), []*net.UDPAddr{closed.LocalAddr().(*net.UDPAddr)})
+	assert.Error(t, err)
+}
+
+func TestValidateServers(t *testing.T) {
+	assert.NoError(t, ValidateServers([]string{"stun.l.google.com:19302", "[2001:db8::1]:3478"}))
+	assert.Error(t, ValidateServers([]string{"stun.l.google.com"}))
+	assert.Error(t, ValidateServers([]string{":3478"}))
+}
@@ -0,0 +1,103 @@
+package trie
+
+import (
+	"net/netip"
+)
+
+type ipNode struct {
+	children [2]*ipNode
+	data     any
+}
+
+// IPTrie is a binary prefix trie, an address is matched against all
+// inserted prefixes in at most 32 (IPv4) or 128 (IPv6) steps
+type IPTrie struct {
+	v4   *ipNode
+	v6   *ipNode
+	size int
+}
+
+// NewIPTrie return a new empty IPTrie
+func NewIPTrie() *IPTrie {
+	return &IPTrie{v4: &ipNode{}, v6: &ipNode{}}
+}
+
+func (t *IPTrie) root(addr netip.Addr) *ipNode {
+	if addr.Is4() {
+		return t.v4
+	}
+	return t.v6
+}
+
+// Insert adds a prefix, IPv4-mapped IPv6 prefixes are kept as IPv6.
+// It returns false and keeps the existing data if the prefix already exists.
+func (t *IPTrie) Insert(prefix netip.Prefix, data any) bool {
+	if data == nil {
+		return false
+	}
+	prefix = prefix.Masked()
+	addr := prefix.Addr()
+
+	node := t.root(addr)
+	raw := addr.AsSlice()
+	for i := 0; i < prefix.Bits(); i++ {
+		bit := bitAt(raw, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &ipNode{}
+		}
+		node = node.children[bit]
+	}
+
+	if node.data != nil {
+		return false
+	}
+	node.data = data
+	t.size++
+	return true
+}
+
+// Walk calls fn with the data of every prefix containing addr, from the shortest
+// to the longest prefix, until fn returns false. IPv4-mapped addresses are unmapped.
+// It doesn't allocate, the tun matches every packet with it.
+func (t *IPTrie) Walk(addr netip.Addr, fn func(data any) bool) {
+	addr = addr.Unmap()
+	if !addr.IsValid() {
+		return
+	}
+
+	node := t.root(addr)
+	raw := addr.As16()
+	bits, offset := 128, 0
+	if addr.Is4() {
+		// the ipv4 address is the last 4 bytes of its mapped form
+		bits, offset = 32, 96
+	}
+	for i := 0; node != nil; i++ {
+		if node.data != nil && !fn(node.data) {
+			return
+		}
+		if i == bits {
+			return
+		}
+		node = node.children[bitAt(raw[:], offset+i)]
+	}
+}
+
+// Search return the data of the longest prefix containing addr
+func (t *IPTrie) Search(addr netip.Addr) any {
+	var data any
+	t.Walk(addr, func(d any) bool {
+		data = d
+		return true
+	})
+	return data
+}
+
+// Size return the number of prefixes
+func (t *IPTrie) Size() int {
+	return t.size
+}
+
+func bitAt(b []byte, i int) int {
+	return int(b[i/8]>>(7-i%8)) & 1
+}
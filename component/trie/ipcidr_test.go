@@ -0,0 +1,38 @@
+package trie
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPTrie_Search(t *testing.T) {
+	tree := NewIPTrie()
+	assert.True(t, tree.Insert(netip.MustParsePrefix("10.0.0.0/8"), "a"))
+	assert.True(t, tree.Insert(netip.MustParsePrefix("10.1.0.0/16"), "b"))
+	assert.True(t, tree.Insert(netip.MustParsePrefix("2001:db8::/32"), "c"))
+	assert.True(t, tree.Insert(netip.MustParsePrefix("0.0.0.0/0"), "d"))
+	assert.False(t, tree.Insert(netip.MustParsePrefix("10.1.2.3/16"), "e"))
+	assert.Equal(t, 4, tree.Size())
+
+	assert.Equal(t, "a", tree.Search(netip.MustParseAddr("10.2.0.1")))
+	assert.Equal(t, "b", tree.Search(netip.MustParseAddr("10.1.0.1")))
+	assert.Equal(t, "b", tree.Search(netip.MustParseAddr("::ffff:10.1.0.1")))
+	assert.Equal(t, "d", tree.Search(netip.MustParseAddr("192.168.0.1")))
+	assert.Equal(t, "c", tree.Search(netip.MustParseAddr("2001:db8::1")))
+	assert.Nil(t, tree.Search(netip.MustParseAddr("2001:db9::1")))
+}
+
+func TestIPTrie_Walk(t *testing.T) {
+	tree := NewIPTrie()
+	tree.Insert(netip.MustParsePrefix("1.1.1.1/32"), 2)
+	tree.Insert(netip.MustParsePrefix("1.0.0.0/8"), 1)
+
+	visited := []any{}
+	tree.Walk(netip.MustParseAddr("1.1.1.1"), func(data any) bool {
+		visited = append(visited, data)
+		return true
+	})
+	assert.Equal(t, []any{1, 2}, visited)
+}
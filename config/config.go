@@ -1,27 +1,46 @@
 package config
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Dreamacro/clash/adapter"
+	"github.com/Dreamacro/clash/adapter/inbound"
 	"github.com/Dreamacro/clash/adapter/outbound"
 	"github.com/Dreamacro/clash/adapter/outboundgroup"
 	"github.com/Dreamacro/clash/adapter/provider"
 	"github.com/Dreamacro/clash/component/auth"
+	"github.com/Dreamacro/clash/component/clientcert"
 	"github.com/Dreamacro/clash/component/fakeip"
+	"github.com/Dreamacro/clash/component/fetch"
+	"github.com/Dreamacro/clash/component/pac"
+	"github.com/Dreamacro/clash/component/power"
+	"github.com/Dreamacro/clash/component/probeserver"
+	"github.com/Dreamacro/clash/component/stun"
 	"github.com/Dreamacro/clash/component/trie"
 	C "github.com/Dreamacro/clash/constant"
 	providerTypes "github.com/Dreamacro/clash/constant/provider"
 	"github.com/Dreamacro/clash/dns"
+	"github.com/Dreamacro/clash/listener/tun"
 	"github.com/Dreamacro/clash/log"
 	R "github.com/Dreamacro/clash/rule"
+	"github.com/Dreamacro/clash/transport/quicconn"
 	T "github.com/Dreamacro/clash/tunnel"
+	"github.com/Dreamacro/clash/tunnel/statistic"
 
+	"github.com/gofrs/uuid/v5"
 	"github.com/samber/lo"
 	"gopkg.in/yaml.v3"
 )
@@ -30,24 +49,38 @@ import (
 type General struct {
 	Inbound
 	Controller
-	Mode        T.TunnelMode `json:"mode"`
-	LogLevel    log.LogLevel `json:"log-level"`
-	IPv6        bool         `json:"ipv6"`
-	Interface   string       `json:"-"`
-	RoutingMark int          `json:"-"`
+	Mode        T.TunnelMode   `json:"mode"`
+	LogLevel    log.LogLevel   `json:"log-level"`
+	IPv6        bool           `json:"ipv6"`
+	Interface   string         `json:"-"`
+	RoutingMark int            `json:"-"`
+	FetchProxy  *url.URL       `json:"-"`
+	TimeZone    *time.Location `json:"-"`
+
+	HealthCheckServer string                `json:"health-check-server"`
+	ClosePolicy       statistic.ClosePolicy `json:"break-connections-on-proxy-change"`
+
+	// SecureDefaults refuses the proxies and the controller open to other hosts without
+	// authentication, InsecureAllowOpen lifts it
+	SecureDefaults    bool `json:"secure-defaults"`
+	InsecureAllowOpen bool `json:"insecure-allow-open"`
+
+	// ActiveProfile is the name of the profile overlay in use, default for the config itself
+	ActiveProfile string `json:"profile"`
 }
 
 // Inbound
 type Inbound struct {
-	Port           int      `json:"port"`
-	SocksPort      int      `json:"socks-port"`
-	RedirPort      int      `json:"redir-port"`
-	TProxyPort     int      `json:"tproxy-port"`
-	MixedPort      int      `json:"mixed-port"`
-	Tun            Tun      `json:"tun"`
-	Authentication []string `json:"authentication"`
-	AllowLan       bool     `json:"allow-lan"`
-	BindAddress    string   `json:"bind-address"`
+	Port           int         `json:"port"`
+	SocksPort      int         `json:"socks-port"`
+	RedirPort      int         `json:"redir-port"`
+	TProxyPort     int         `json:"tproxy-port"`
+	MixedPort      int         `json:"mixed-port"`
+	Tun            Tun         `json:"tun"`
+	Authentication []string    `json:"authentication"`
+	AllowLan       bool        `json:"allow-lan"`
+	BindAddress    AddressList `json:"bind-address"`
+	BindFailure    string      `json:"bind-failure"`
 }
 
 // Controller
@@ -71,6 +104,12 @@ type DNS struct {
 	Hosts             *trie.DomainTrie
 	NameServerPolicy  map[string]dns.NameServer
 	SearchDomains     []string
+	Views             []dns.View
+	NameServerGroups  map[string]dns.NameServerGroup
+	// PTR are the names answering the reverse lookups of the addresses
+	PTR map[netip.Addr]string
+	// CacheKey changes with the settings the cached answers depend on
+	CacheKey string
 }
 
 // FallbackFilter config
@@ -87,16 +126,107 @@ type Profile struct {
 	StoreFakeIP   bool `yaml:"store-fake-ip"`
 }
 
+// RawRewrite overrides the destination matching Match with Target, see tunnel.NewRewrite
+type RawRewrite struct {
+	Match  string `yaml:"match"`
+	Target string `yaml:"target"`
+}
+
+// NTP config
+type NTP struct {
+	Enable   bool   `yaml:"enable"`
+	Server   string `yaml:"server"`
+	Interval int    `yaml:"interval"`
+}
+
+// PowerSave config, idle is in minutes
+type PowerSave struct {
+	Enable           bool `yaml:"enable" json:"enable"`
+	Idle             int  `yaml:"idle" json:"idle"`
+	SuspendKeepalive bool `yaml:"suspend-keepalive" json:"suspend-keepalive"`
+}
+
+// DialPool config, queue-timeout is in seconds
+type DialPool struct {
+	Size         int `yaml:"size"`
+	QueueTimeout int `yaml:"queue-timeout"`
+}
+
+// UDPPortReuse config, idle is in seconds
+type UDPPortReuse struct {
+	Enable bool `yaml:"enable"`
+	Idle   int  `yaml:"idle"`
+}
+
+// AccessLog config, max-size is in megabytes
+type AccessLog struct {
+	Path       string  `yaml:"path"`
+	Format     string  `yaml:"format"`
+	MaxSize    int     `yaml:"max-size"`
+	MaxBackups int     `yaml:"max-backups"`
+	Sample     float64 `yaml:"sample"`
+}
+
+// HookAction is a webhook POSTed with the event and/or a command run with it in the environment
+type HookAction struct {
+	URL     string   `yaml:"url"`
+	Command []string `yaml:"command"`
+}
+
+// Hooks config
+type Hooks struct {
+	Debounce    int        `yaml:"debounce"`
+	OnProxyDown HookAction `yaml:"on-proxy-down"`
+	OnProxyUp   HookAction `yaml:"on-proxy-up"`
+}
+
+// SocksBind config
+type SocksBind struct {
+	Timeout int  `yaml:"timeout"`
+	AnyPeer bool `yaml:"any-peer"`
+}
+
+// LogDedup config
+type LogDedup struct {
+	Enable bool `yaml:"enable"`
+	Window int  `yaml:"window"`
+}
+
 // Tun config
 type Tun struct {
 	Enable    bool   `yaml:"enable" json:"enable"`
 	DeviceURL string `yaml:"device-url" json:"device-url"`
 	DNSListen string `yaml:"dns-listen" json:"dns-listen"`
+	// DNSHijack are the IP:PORT or any:PORT whose udp dns queries from the tun are answered
+	DNSHijack []string `yaml:"dns-hijack" json:"dns-hijack"`
+	// Sniffing reads the host of the tcp connections from the tun
+	Sniffing TunSniffing `yaml:"sniffing" json:"sniffing"`
+	// FindProcess looks up the local process of every connection from the tun
+	FindProcess bool `yaml:"find-process" json:"find-process"`
+	// ExcludeCIDR and IncludeCIDR are the destinations whose connections from the tun skip
+	// the rules, dialed directly or refused with RejectExcluded
+	ExcludeCIDR    []string `yaml:"exclude-cidr" json:"exclude-cidr"`
+	IncludeCIDR    []string `yaml:"include-cidr" json:"include-cidr"`
+	RejectExcluded bool     `yaml:"reject-excluded" json:"reject-excluded"`
+	// Running tells the adapter is up, Enable is the one asked for
+	Running bool `yaml:"-" json:"running"`
+}
+
+// TunSniffing config, the tls server name or the http host of a connection to one of the
+// ports is matched by the rules in place of its ip
+type TunSniffing struct {
+	Enable bool     `yaml:"enable" json:"enable"`
+	Ports  []uint16 `yaml:"ports" json:"ports"`
+	// Timeout is the milliseconds a connection waits for the first bytes of the client
+	Timeout int `yaml:"timeout" json:"timeout"`
 }
 
 // Experimental config
 type Experimental struct {
 	UDPFallbackMatch bool `yaml:"udp-fallback-match"`
+	UDPRematch       bool `yaml:"udp-rematch"`
+	// Impairment turns on /debug/impair, the controller needs a secret too
+	Impairment bool `yaml:"impairment"`
 }
 
 // Config is clash config manager
@@ -104,30 +234,129 @@ type Config struct {
 	General      *General
 	Tun          *Tun
 	DNS          *DNS
+	NTP          *NTP
+	PowerSave    *PowerSave
+	DialPool     *DialPool
+	UDPPortReuse *UDPPortReuse
+	AccessLog    *AccessLog
+	Hooks        *Hooks
+	LogDedup     *LogDedup
+	SocksBind    *SocksBind
 	Experimental *Experimental
 	Hosts        *trie.DomainTrie
 	Profile      *Profile
 	Rules        []C.Rule
-	Users        []auth.AuthUser
-	Proxies      map[string]C.Proxy
-	Providers    map[string]providerTypes.ProxyProvider
-	Tunnels      []Tunnel
+	Final        string
+	// overlays of the rules and the mode from profiles:
+	ProfileOverlays []*ProfileOverlay
+	// policy of the provider targets whose proxy is gone
+	ProviderFallback string
+	Rewrites         []*T.Rewrite
+	// host-mismatch mode of the tcp connections, off, log or reject
+	HostMismatch string
+	// STUNServers are the servers of the nat tests of the proxies
+	STUNServers   []string
+	Users         []auth.AuthUser
+	Capabilities  map[string]inbound.Capability
+	Proxies       map[string]C.Proxy
+	Providers     map[string]providerTypes.ProxyProvider
+	RuleProviders map[string]providerTypes.RuleProvider
+	Tunnels       []Tunnel
+	QUICListeners []QUICListener
+
+	// SystemProxyBypass are the hosts the pac at /proxy.pac sends DIRECT
+	SystemProxyBypass []string
 }
 
 type RawDNS struct {
-	Enable            bool              `yaml:"enable"`
-	IPv6              bool              `yaml:"ipv6"`
-	UseHosts          bool              `yaml:"use-hosts"`
-	NameServer        []string          `yaml:"nameserver"`
-	Fallback          []string          `yaml:"fallback"`
-	FallbackFilter    RawFallbackFilter `yaml:"fallback-filter"`
-	Listen            string            `yaml:"listen"`
-	EnhancedMode      C.DNSMode         `yaml:"enhanced-mode"`
-	FakeIPRange       string            `yaml:"fake-ip-range"`
-	FakeIPFilter      []string          `yaml:"fake-ip-filter"`
-	DefaultNameserver []string          `yaml:"default-nameserver"`
-	NameServerPolicy  map[string]string `yaml:"nameserver-policy"`
-	SearchDomains     []string          `yaml:"search-domains"`
+	Enable            bool                     `yaml:"enable"`
+	IPv6              bool                     `yaml:"ipv6"`
+	UseHosts          bool                     `yaml:"use-hosts"`
+	NameServer        []RawNameServer          `yaml:"nameserver"`
+	Fallback          []RawNameServer          `yaml:"fallback"`
+	FallbackFilter    RawFallbackFilter        `yaml:"fallback-filter"`
+	Listen            string                   `yaml:"listen"`
+	EnhancedMode      C.DNSMode                `yaml:"enhanced-mode"`
+	FakeIPRange       string                   `yaml:"fake-ip-range"`
+	FakeIPFilter      []string                 `yaml:"fake-ip-filter"`
+	DefaultNameserver []string                 `yaml:"default-nameserver"`
+	NameServerPolicy  map[string]RawNameServer `yaml:"nameserver-policy"`
+	SearchDomains     []string                 `yaml:"search-domains"`
+	Views             []RawDNSView             `yaml:"views"`
+	Resolver          string                   `yaml:"resolver"`
+	PTRRecords        map[string]string        `yaml:"ptr-records"`
+
+	NameServerGroups map[string]RawNameServerGroup `yaml:"nameserver-groups"`
+}
+
+// resolvers of the dns section
+const (
+	dnsResolverClash  = "clash"
+	dnsResolverSystem = "system"
+)
+
+// RawNameServer is a nameserver url, or a mapping with the url and its tls options
+type RawNameServer struct {
+	URL            string   `yaml:"url"`
+	CA             string   `yaml:"ca"`
+	CAStr          string   `yaml:"ca-str"`
+	SkipCertVerify bool     `yaml:"skip-cert-verify"`
+	PinSHA256      []string `yaml:"pin-sha256"`
+
+	ClientCert          string `yaml:"client-cert"`
+	ClientKey           string `yaml:"client-key"`
+	ClientKeyPassphrase string `yaml:"client-key-passphrase"`
+}
+
+type rawNameServer RawNameServer
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (ns *RawNameServer) UnmarshalYAML(unmarshal func(any) error) error {
+	var url string
+	if err := unmarshal(&url); err == nil {
+		*ns = RawNameServer{URL: url}
+		return nil
+	}
+
+	var inner rawNameServer
+	if err := unmarshal(&inner); err != nil {
+		return err
+	}
+	*ns = RawNameServer(inner)
+	return nil
+}
+
+// RawNameServerGroup is a list of nameservers, or a mapping with the strategy and the list
+type RawNameServerGroup struct {
+	Strategy   string          `yaml:"strategy"`
+	NameServer []RawNameServer `yaml:"nameserver"`
+}
+
+type rawNameServerGroup RawNameServerGroup
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (g *RawNameServerGroup) UnmarshalYAML(unmarshal func(any) error) error {
+	var servers []RawNameServer
+	if err := unmarshal(&servers); err == nil {
+		*g = RawNameServerGroup{NameServer: servers}
+		return nil
+	}
+
+	var inner rawNameServerGroup
+	if err := unmarshal(&inner); err != nil {
+		return err
+	}
+	*g = RawNameServerGroup(inner)
+	return nil
+}
+
+type RawDNSView struct {
+	Name             string                   `yaml:"name"`
+	Client           []string                 `yaml:"client"`
+	Hosts            map[string]string        `yaml:"hosts"`
+	FakeIP           *bool                    `yaml:"fake-ip"`
+	NameServer       []RawNameServer          `yaml:"nameserver"`
+	NameServerPolicy map[string]RawNameServer `yaml:"nameserver-policy"`
 }
 
 type RawFallbackFilter struct {
@@ -137,11 +366,61 @@ type RawFallbackFilter struct {
 	Domain    []string `yaml:"domain"`
 }
 
+// bind-failure policies, fatal keeps the old sockets of a listener when one of its
+// addresses can't be bound and fails the startup, warn binds the others
+const (
+	BindFailureFatal = "fatal"
+	BindFailureWarn  = "warn"
+)
+
+// AddressList is a single address or a list of them
+type AddressList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (a *AddressList) UnmarshalYAML(unmarshal func(any) error) error {
+	var addr string
+	if err := unmarshal(&addr); err == nil {
+		*a = AddressList{addr}
+		return nil
+	}
+
+	var addrs []string
+	if err := unmarshal(&addrs); err != nil {
+		return err
+	}
+	*a = addrs
+	return nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *AddressList) UnmarshalJSON(data []byte) error {
+	var addr string
+	if err := json.Unmarshal(data, &addr); err == nil {
+		*a = AddressList{addr}
+		return nil
+	}
+
+	var addrs []string
+	if err := json.Unmarshal(data, &addrs); err != nil {
+		return err
+	}
+	*a = addrs
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, a single address stays a string
+func (a AddressList) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
 type tunnel struct {
-	Network []string `yaml:"network"`
-	Address string   `yaml:"address"`
-	Target  string   `yaml:"target"`
-	Proxy   string   `yaml:"proxy"`
+	Network []string    `yaml:"network"`
+	Address AddressList `yaml:"address"`
+	Target  string      `yaml:"target"`
+	Proxy   string      `yaml:"proxy"`
 }
 
 type Tunnel tunnel
@@ -188,41 +467,108 @@ func (t *Tunnel) UnmarshalYAML(unmarshal func(any) error) error {
 
 	*t = Tunnel(tunnel{
 		Network: network,
-		Address: address,
+		Address: AddressList{address},
 		Target:  target,
 		Proxy:   parts[3],
 	})
 	return nil
 }
 
+// QUICListener is a hysteria2 or tuic inbound, the users of hysteria2 map names to
+// passwords, the ones of tuic map uuids to passwords
+type QUICListener struct {
+	Name        string            `yaml:"name"`
+	Type        string            `yaml:"type"`
+	Listen      string            `yaml:"listen"`
+	Certificate string            `yaml:"certificate"`
+	PrivateKey  string            `yaml:"private-key"`
+	Users       map[string]string `yaml:"users"`
+	ALPN        []string          `yaml:"alpn"`
+	Up          string            `yaml:"up"`
+	Down        string            `yaml:"down"`
+}
+
+const (
+	QUICListenerHysteria2 = "hysteria2"
+	QUICListenerTUIC      = "tuic"
+)
+
+// RawCapability restricts the requests a listener accepts, see inbound.Capability
+type RawCapability struct {
+	HTTPConnectOnly         bool     `yaml:"http-connect-only"`
+	SocksUDP                *bool    `yaml:"socks-udp"`
+	AllowedDestinationPorts []uint16 `yaml:"allowed-destination-ports"`
+
+	ForwardHeaders          string            `yaml:"forward-headers"`
+	ForwardHeadersOverrides map[string]string `yaml:"forward-headers-overrides"`
+
+	RejectResponse RawRejectResponse `yaml:"reject-response"`
+}
+
+// RawRejectResponse is the answer of the http listeners to the requests of REJECT
+type RawRejectResponse struct {
+	Enable   bool   `yaml:"enable"`
+	Status   int    `yaml:"status"`
+	Body     string `yaml:"body"`
+	ShowRule bool   `yaml:"show-rule"`
+}
+
 type RawConfig struct {
-	Port               int          `yaml:"port"`
-	SocksPort          int          `yaml:"socks-port"`
-	RedirPort          int          `yaml:"redir-port"`
-	TProxyPort         int          `yaml:"tproxy-port"`
-	MixedPort          int          `yaml:"mixed-port"`
-	Authentication     []string     `yaml:"authentication"`
-	AllowLan           bool         `yaml:"allow-lan"`
-	BindAddress        string       `yaml:"bind-address"`
-	Mode               T.TunnelMode `yaml:"mode"`
-	LogLevel           log.LogLevel `yaml:"log-level"`
-	IPv6               bool         `yaml:"ipv6"`
-	ExternalController string       `yaml:"external-controller"`
-	ExternalUI         string       `yaml:"external-ui"`
-	Secret             string       `yaml:"secret"`
-	Interface          string       `yaml:"interface-name"`
-	RoutingMark        int          `yaml:"routing-mark"`
-	Tunnels            []Tunnel     `yaml:"tunnels"`
-
-	ProxyProvider map[string]map[string]any `yaml:"proxy-providers"`
-	Hosts         map[string]string         `yaml:"hosts"`
-	DNS           RawDNS                    `yaml:"dns"`
-	Tun           Tun                       `yaml:"tun"`
-	Experimental  Experimental              `yaml:"experimental"`
-	Profile       Profile                   `yaml:"profile"`
-	Proxy         []map[string]any          `yaml:"proxies"`
-	ProxyGroup    []map[string]any          `yaml:"proxy-groups"`
-	Rule          []string                  `yaml:"rules"`
+	ConfigVersion      int            `yaml:"config-version"`
+	SecureDefaults     *bool          `yaml:"secure-defaults"`
+	InsecureAllowOpen  bool           `yaml:"insecure-allow-open"`
+	Port               int            `yaml:"port"`
+	SocksPort          int            `yaml:"socks-port"`
+	RedirPort          int            `yaml:"redir-port"`
+	TProxyPort         int            `yaml:"tproxy-port"`
+	MixedPort          int            `yaml:"mixed-port"`
+	Authentication     []string       `yaml:"authentication"`
+	AllowLan           bool           `yaml:"allow-lan"`
+	BindAddress        AddressList    `yaml:"bind-address"`
+	BindFailure        string         `yaml:"bind-failure"`
+	Mode               T.TunnelMode   `yaml:"mode"`
+	LogLevel           log.LogLevel   `yaml:"log-level"`
+	IPv6               bool           `yaml:"ipv6"`
+	ExternalController string         `yaml:"external-controller"`
+	ExternalUI         string         `yaml:"external-ui"`
+	Secret             string         `yaml:"secret"`
+	Interface          string         `yaml:"interface-name"`
+	RoutingMark        int            `yaml:"routing-mark"`
+	FetchProxy         string         `yaml:"fetch-proxy"`
+	TimeZone           string         `yaml:"time-zone"`
+	HealthCheckServer  string         `yaml:"health-check-server"`
+	Tunnels            []Tunnel       `yaml:"tunnels"`
+	QUICListeners      []QUICListener `yaml:"quic-listeners"`
+
+	ListenerCapability map[string]RawCapability `yaml:"listener-capabilities"`
+	ClosePolicy        statistic.ClosePolicy    `yaml:"break-connections-on-proxy-change"`
+
+	ProxyProvider    map[string]map[string]any `yaml:"proxy-providers"`
+	RuleProvider     map[string]map[string]any `yaml:"rule-providers"`
+	Hosts            map[string]string         `yaml:"hosts"`
+	DNS              RawDNS                    `yaml:"dns"`
+	Tun              Tun                       `yaml:"tun"`
+	NTP              NTP                       `yaml:"ntp"`
+	PowerSave        PowerSave                 `yaml:"power-save"`
+	DialPool         DialPool                  `yaml:"dial-pool"`
+	UDPPortReuse     UDPPortReuse              `yaml:"udp-port-reuse"`
+	AccessLog        AccessLog                 `yaml:"access-log"`
+	Hooks            Hooks                     `yaml:"hooks"`
+	LogDedup         LogDedup                  `yaml:"log-dedup"`
+	SocksBind        SocksBind                 `yaml:"socks-bind"`
+	Experimental     Experimental              `yaml:"experimental"`
+	Profile          Profile                   `yaml:"profile"`
+	Proxy            []any                     `yaml:"proxies"`
+	ProxyGroup       []map[string]any          `yaml:"proxy-groups"`
+	Rule             []string                  `yaml:"rules"`
+	ProfileOverlay   []RawProfileOverlay       `yaml:"profiles"`
+	Final            string                    `yaml:"final"`
+	ProviderFallback string                    `yaml:"provider-target-fallback"`
+	Rewrite          []RawRewrite              `yaml:"rewrites"`
+	HostMismatch     string                    `yaml:"host-mismatch"`
+	STUNServers      []string                  `yaml:"stun-servers"`
+
+	SystemProxyBypass []string `yaml:"system-proxy-bypass"`
 }
 
 // Parse config
@@ -235,17 +581,41 @@ func Parse(buf []byte) (*Config, error) {
 	return ParseRawConfig(rawCfg)
 }
 
+// ParsePayload parses a config sent in the payload of PUT /configs. Anyone reaching the
+// controller can send one, so the hook commands it would run on this host and the !file
+// values it would read from this host are refused
+func ParsePayload(buf []byte) (*Config, error) {
+	rawCfg, err := unmarshalRawConfig(buf, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, action := range map[string]HookAction{"on-proxy-down": rawCfg.Hooks.OnProxyDown, "on-proxy-up": rawCfg.Hooks.OnProxyUp} {
+		if len(action.Command) != 0 {
+			return nil, fmt.Errorf("hooks %s: command is not allowed in a config payload, load it from a file", name)
+		}
+	}
+
+	return ParseRawConfig(rawCfg)
+}
+
 func UnmarshalRawConfig(buf []byte) (*RawConfig, error) {
+	return unmarshalRawConfig(buf, true)
+}
+
+// unmarshalRawConfig loads the !file values with load, see unmarshalYAML
+func unmarshalRawConfig(buf []byte, load bool) (*RawConfig, error) {
 	// config with default value
 	rawCfg := &RawConfig{
 		AllowLan:       false,
-		BindAddress:    "*",
+		BindAddress:    AddressList{"*"},
+		BindFailure:    BindFailureWarn,
 		Mode:           T.Rule,
 		Authentication: []string{},
 		LogLevel:       log.INFO,
 		Hosts:          map[string]string{},
 		Rule:           []string{},
-		Proxy:          []map[string]interface{}{},
+		Proxy:          []any{},
 		ProxyGroup:     []map[string]interface{}{},
 		DNS: RawDNS{
 			Enable:      false,
@@ -264,9 +634,39 @@ func UnmarshalRawConfig(buf []byte) (*RawConfig, error) {
 		Profile: Profile{
 			StoreSelected: true,
 		},
+		Tun: Tun{
+			Sniffing: TunSniffing{
+				Ports:   []uint16{443, 80},
+				Timeout: 100,
+			},
+		},
+		NTP: NTP{
+			Server:   "pool.ntp.org",
+			Interval: 3600,
+		},
+		PowerSave: PowerSave{
+			Idle: int(power.DefaultIdle / time.Minute),
+		},
+		DialPool: DialPool{
+			Size:         T.DefaultDialPoolSize,
+			QueueTimeout: int(T.DefaultDialPoolQueueTimeout / time.Second),
+		},
+		UDPPortReuse: UDPPortReuse{
+			Idle: int(T.DefaultUDPPortIdle / time.Second),
+		},
+		AccessLog: AccessLog{
+			Format:     statistic.AccessLogJSON,
+			MaxSize:    100,
+			MaxBackups: 3,
+			Sample:     1,
+		},
+		LogDedup: LogDedup{
+			Enable: true,
+			Window: int(log.DefaultDedupWindow / time.Second),
+		},
 	}
 
-	if err := yaml.Unmarshal(buf, rawCfg); err != nil {
+	if err := unmarshalYAML(buf, rawCfg, load); err != nil {
 		return nil, err
 	}
 
@@ -278,6 +678,44 @@ func ParseRawConfig(rawCfg *RawConfig) (*Config, error) {
 
 	config.Experimental = &rawCfg.Experimental
 	config.Profile = &rawCfg.Profile
+	config.NTP = &rawCfg.NTP
+
+	if rawCfg.PowerSave.Idle <= 0 {
+		return nil, fmt.Errorf("power-save: invalid idle %d", rawCfg.PowerSave.Idle)
+	}
+	config.PowerSave = &rawCfg.PowerSave
+
+	if rawCfg.DialPool.Size < 0 || rawCfg.DialPool.QueueTimeout <= 0 {
+		return nil, fmt.Errorf("dial-pool: invalid size %d or queue-timeout %d", rawCfg.DialPool.Size, rawCfg.DialPool.QueueTimeout)
+	}
+	config.DialPool = &rawCfg.DialPool
+
+	if rawCfg.UDPPortReuse.Idle <= 0 {
+		return nil, fmt.Errorf("udp-port-reuse: invalid idle %d", rawCfg.UDPPortReuse.Idle)
+	}
+	config.UDPPortReuse = &rawCfg.UDPPortReuse
+
+	accessLog, err := parseAccessLog(rawCfg)
+	if err != nil {
+		return nil, err
+	}
+	config.AccessLog = accessLog
+
+	if rawCfg.LogDedup.Enable && rawCfg.LogDedup.Window <= 0 {
+		return nil, fmt.Errorf("log-dedup: invalid window %d", rawCfg.LogDedup.Window)
+	}
+	config.LogDedup = &rawCfg.LogDedup
+
+	if rawCfg.SocksBind.Timeout < 0 {
+		return nil, fmt.Errorf("socks-bind: invalid timeout %d", rawCfg.SocksBind.Timeout)
+	}
+	config.SocksBind = &rawCfg.SocksBind
+
+	hooks, err := parseHooks(rawCfg)
+	if err != nil {
+		return nil, err
+	}
+	config.Hooks = hooks
 
 	general, err := parseGeneral(rawCfg)
 	if err != nil {
@@ -292,12 +730,57 @@ func ParseRawConfig(rawCfg *RawConfig) (*Config, error) {
 	config.Proxies = proxies
 	config.Providers = providers
 
-	rules, err := parseRules(rawCfg, proxies)
+	ruleProviders, err := parseRuleProviders(rawCfg)
+	if err != nil {
+		return nil, err
+	}
+	config.RuleProviders = ruleProviders
+
+	rules, err := parseRules(rawCfg.Rule, proxies, providers, ruleProviders)
 	if err != nil {
 		return nil, err
 	}
 	config.Rules = rules
 
+	overlays, err := parseProfileOverlays(rawCfg, proxies, providers, ruleProviders)
+	if err != nil {
+		return nil, err
+	}
+	config.ProfileOverlays = overlays
+
+	final, err := parseFinal(rawCfg, rules, proxies)
+	if err != nil {
+		return nil, err
+	}
+	config.Final = final
+
+	if fallback := rawCfg.ProviderFallback; fallback != "" {
+		if _, ok := proxies[fallback]; !ok {
+			return nil, fmt.Errorf("provider-target-fallback error: proxy [%s] not found", fallback)
+		}
+	}
+	config.ProviderFallback = rawCfg.ProviderFallback
+
+	rewrites, err := parseRewrites(rawCfg)
+	if err != nil {
+		return nil, err
+	}
+	config.Rewrites = rewrites
+
+	if config.HostMismatch, err = T.ParseHostMismatch(rawCfg.HostMismatch); err != nil {
+		return nil, err
+	}
+
+	if err := stun.ValidateServers(rawCfg.STUNServers); err != nil {
+		return nil, err
+	}
+	config.STUNServers = rawCfg.STUNServers
+
+	if err := pac.Validate(rawCfg.SystemProxyBypass); err != nil {
+		return nil, err
+	}
+	config.SystemProxyBypass = rawCfg.SystemProxyBypass
+
 	hosts, err := parseHosts(rawCfg)
 	if err != nil {
 		return nil, err
@@ -312,12 +795,31 @@ func ParseRawConfig(rawCfg *RawConfig) (*Config, error) {
 
 	config.Users = parseAuthentication(rawCfg.Authentication)
 
+	capabilities, err := parseCapabilities(rawCfg.ListenerCapability)
+	if err != nil {
+		return nil, err
+	}
+	config.Capabilities = capabilities
+
 	config.Tunnels = rawCfg.Tunnels
 	// verify tunnels
 	for _, t := range config.Tunnels {
 		if _, ok := config.Proxies[t.Proxy]; !ok {
 			return nil, fmt.Errorf("tunnel proxy %s not found", t.Proxy)
 		}
+		if len(t.Address) == 0 {
+			return nil, fmt.Errorf("tunnel to %s has no address", t.Target)
+		}
+	}
+
+	quicListeners, err := parseQUICListeners(rawCfg.QUICListeners)
+	if err != nil {
+		return nil, err
+	}
+	config.QUICListeners = quicListeners
+
+	if err := SecureController(config); err != nil {
+		return nil, err
 	}
 
 	return config, nil
@@ -335,6 +837,64 @@ func parseGeneral(cfg *RawConfig) (*General, error) {
 		}
 	}
 
+	var fetchProxy *url.URL
+	if cfg.FetchProxy != "" {
+		u, err := fetch.ParseProxy(cfg.FetchProxy)
+		if err != nil {
+			return nil, err
+		}
+		fetchProxy = u
+	}
+
+	timeZone := time.Local
+	if cfg.TimeZone != "" {
+		loc, err := time.LoadLocation(cfg.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("time-zone: %w", err)
+		}
+		timeZone = loc
+	}
+
+	if len(cfg.BindAddress) == 0 {
+		return nil, errors.New("bind-address should not be empty")
+	}
+	for _, addr := range cfg.Tun.DNSHijack {
+		if _, _, err := tun.ParseDNSHijack(addr); err != nil {
+			return nil, fmt.Errorf("tun dns-hijack: %w", err)
+		}
+	}
+	for _, cidr := range cfg.Tun.ExcludeCIDR {
+		if _, err := tun.ParseBypassCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("tun exclude-cidr: %w", err)
+		}
+	}
+	for _, cidr := range cfg.Tun.IncludeCIDR {
+		if _, err := tun.ParseBypassCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("tun include-cidr: %w", err)
+		}
+	}
+	if sniffing := cfg.Tun.Sniffing; sniffing.Enable {
+		if sniffing.Timeout <= 0 {
+			return nil, fmt.Errorf("tun sniffing: invalid timeout %d", sniffing.Timeout)
+		}
+		if len(sniffing.Ports) == 0 || lo.Contains(sniffing.Ports, 0) {
+			return nil, errors.New("tun sniffing: ports should be a list of ports other than 0")
+		}
+	}
+	switch cfg.BindFailure {
+	case BindFailureFatal, BindFailureWarn:
+	default:
+		return nil, fmt.Errorf("bind-failure: invalid policy %s", cfg.BindFailure)
+	}
+
+	if cfg.HealthCheckServer != "" {
+		if _, _, err := net.SplitHostPort(cfg.HealthCheckServer); err != nil {
+			return nil, fmt.Errorf("health-check-server: %w", err)
+		}
+	} else if name, ok := internalProbe(cfg); ok {
+		return nil, fmt.Errorf("%s: %s urls need health-check-server", name, probeserver.Scheme)
+	}
+
 	return &General{
 		Inbound: Inbound{
 			Port:        cfg.Port,
@@ -345,6 +905,9 @@ func parseGeneral(cfg *RawConfig) (*General, error) {
 			Tun:         cfg.Tun,
 			AllowLan:    cfg.AllowLan,
 			BindAddress: cfg.BindAddress,
+			BindFailure: cfg.BindFailure,
+
+			Authentication: cfg.Authentication,
 		},
 		Controller: Controller{
 			ExternalController: cfg.ExternalController,
@@ -356,9 +919,70 @@ func parseGeneral(cfg *RawConfig) (*General, error) {
 		IPv6:        cfg.IPv6,
 		Interface:   cfg.Interface,
 		RoutingMark: cfg.RoutingMark,
+		FetchProxy:  fetchProxy,
+		TimeZone:    timeZone,
+		ClosePolicy: cfg.ClosePolicy,
+
+		HealthCheckServer: cfg.HealthCheckServer,
+
+		// a config of SecureConfigVersion is secure unless it says otherwise
+		SecureDefaults:    lo.FromPtrOr(cfg.SecureDefaults, cfg.ConfigVersion >= SecureConfigVersion),
+		InsecureAllowOpen: cfg.InsecureAllowOpen,
 	}, nil
 }
 
+// internalProbe return the group or provider health checking an internal:// url
+func internalProbe(cfg *RawConfig) (string, bool) {
+	isInternal := func(v any) bool {
+		s, ok := v.(string)
+		return ok && strings.HasPrefix(s, probeserver.Scheme)
+	}
+	for _, group := range cfg.ProxyGroup {
+		if isInternal(group["url"]) {
+			return fmt.Sprintf("proxy group %v", group["name"]), true
+		}
+	}
+	for name, mapping := range cfg.ProxyProvider {
+		if hc, ok := mapping["health-check"].(map[string]any); ok && isInternal(hc["url"]) {
+			return "proxy provider " + name, true
+		}
+	}
+	return "", false
+}
+
+func parseAccessLog(cfg *RawConfig) (*AccessLog, error) {
+	accessLog := &cfg.AccessLog
+	if accessLog.Format != statistic.AccessLogJSON && accessLog.Format != statistic.AccessLogTSV {
+		return nil, fmt.Errorf("access-log: invalid format %s", accessLog.Format)
+	}
+	if accessLog.Sample <= 0 || accessLog.Sample > 1 {
+		return nil, fmt.Errorf("access-log: sample %v should be in (0, 1]", accessLog.Sample)
+	}
+	if accessLog.MaxSize < 0 || accessLog.MaxBackups < 0 {
+		return nil, errors.New("access-log: max-size and max-backups can't be negative")
+	}
+	if accessLog.Path != "" {
+		accessLog.Path = C.Path.Resolve(accessLog.Path)
+	}
+	return accessLog, nil
+}
+
+func parseHooks(cfg *RawConfig) (*Hooks, error) {
+	hooks := &cfg.Hooks
+	for name, action := range map[string]HookAction{"on-proxy-down": hooks.OnProxyDown, "on-proxy-up": hooks.OnProxyUp} {
+		if action.URL == "" {
+			continue
+		}
+		if u, err := url.Parse(action.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("hooks %s: url must be http or https: %s", name, action.URL)
+		}
+	}
+	if hooks.Debounce < 0 {
+		return nil, fmt.Errorf("hooks: invalid debounce %d", hooks.Debounce)
+	}
+	return hooks, nil
+}
+
 func parseProxies(cfg *RawConfig) (proxies map[string]C.Proxy, providersMap map[string]providerTypes.ProxyProvider, err error) {
 	proxies = make(map[string]C.Proxy)
 	providersMap = make(map[string]providerTypes.ProxyProvider)
@@ -372,7 +996,20 @@ func parseProxies(cfg *RawConfig) (proxies map[string]C.Proxy, providersMap map[
 	proxyList = append(proxyList, "DIRECT", "REJECT")
 
 	// parse proxy
-	for idx, mapping := range proxiesConfig {
+	for idx, item := range proxiesConfig {
+		mapping, err := adapter.ProxyMapping(item)
+		if err != nil {
+			return nil, nil, fmt.Errorf("proxy %d: %w", idx, err)
+		}
+
+		// share links often repeat a name, they get a numeric suffix instead of failing
+		if _, isLink := item.(string); isLink {
+			name, _ := mapping["name"].(string)
+			for n := 2; proxies[mapping["name"].(string)] != nil; n++ {
+				mapping["name"] = fmt.Sprintf("%s %d", name, n)
+			}
+		}
+
 		proxy, err := adapter.ParseProxy(mapping)
 		if err != nil {
 			return nil, nil, fmt.Errorf("proxy %d: %w", idx, err)
@@ -394,37 +1031,35 @@ func parseProxies(cfg *RawConfig) (proxies map[string]C.Proxy, providersMap map[
 		proxyList = append(proxyList, groupName)
 	}
 
-	// check if any loop exists and sort the ProxyGroups
-	if err := proxyGroupsDagSort(groupsConfig); err != nil {
+	// providers and groups are parsed once the ones they refer to are, whatever the order
+	// of the config
+	order, err := resolveOrder(groupsConfig, providersConfig)
+	if err != nil {
 		return nil, nil, err
 	}
 
-	// parse and initial providers
-	for name, mapping := range providersConfig {
-		if name == provider.ReservedName {
-			return nil, nil, fmt.Errorf("can not defined a provider called `%s`", provider.ReservedName)
-		}
-
-		pd, err := provider.ParseProxyProvider(name, mapping)
-		if err != nil {
-			return nil, nil, fmt.Errorf("parse proxy provider %s error: %w", name, err)
-		}
+	for _, d := range order {
+		if d.provider {
+			if d.name == provider.ReservedName {
+				return nil, nil, fmt.Errorf("can not defined a provider called `%s`", provider.ReservedName)
+			}
 
-		providersMap[name] = pd
-	}
+			pd, err := provider.ParseProxyProvider(d.name, withFetchProxy(cfg, d.mapping), proxies)
+			if err != nil {
+				return nil, nil, fmt.Errorf("parse proxy provider %s error: %w", d.name, err)
+			}
 
-	for _, provider := range providersMap {
-		log.Infoln("Start initial provider %s", provider.Name())
-		if err := provider.Initial(); err != nil {
-			return nil, nil, fmt.Errorf("initial proxy provider %s error: %w", provider.Name(), err)
+			log.Infoln("Start initial provider %s", pd.Name())
+			if err := pd.Initial(); err != nil {
+				return nil, nil, fmt.Errorf("initial proxy provider %s error: %w", pd.Name(), err)
+			}
+			providersMap[d.name] = pd
+			continue
 		}
-	}
 
-	// parse proxy group
-	for idx, mapping := range groupsConfig {
-		group, err := outboundgroup.ParseProxyGroup(mapping, proxies, providersMap)
+		group, err := outboundgroup.ParseProxyGroup(d.mapping, proxies, providersMap)
 		if err != nil {
-			return nil, nil, fmt.Errorf("proxy group[%d]: %w", idx, err)
+			return nil, nil, fmt.Errorf("proxy group %s: %w", d.name, err)
 		}
 
 		groupName := group.Name()
@@ -465,9 +1100,38 @@ func parseProxies(cfg *RawConfig) (proxies map[string]C.Proxy, providersMap map[
 	return proxies, providersMap, nil
 }
 
-func parseRules(cfg *RawConfig, proxies map[string]C.Proxy) ([]C.Rule, error) {
+// withFetchProxy fills the global fetch-proxy into a provider mapping, its own one takes precedence
+func withFetchProxy(cfg *RawConfig, mapping map[string]any) map[string]any {
+	if cfg.FetchProxy == "" {
+		return mapping
+	}
+	return lo.Assign(map[string]any{"fetch-proxy": cfg.FetchProxy}, mapping)
+}
+
+func parseRuleProviders(cfg *RawConfig) (map[string]providerTypes.RuleProvider, error) {
+	ruleProviders := map[string]providerTypes.RuleProvider{}
+
+	for name, mapping := range cfg.RuleProvider {
+		pd, err := provider.ParseRuleProvider(name, withFetchProxy(cfg, mapping))
+		if err != nil {
+			return nil, fmt.Errorf("parse rule provider %s error: %w", name, err)
+		}
+
+		ruleProviders[name] = pd
+	}
+
+	for _, provider := range ruleProviders {
+		log.Infoln("Start initial rule provider %s", provider.Name())
+		if err := provider.Initial(); err != nil {
+			return nil, fmt.Errorf("initial rule provider %s error: %w", provider.Name(), err)
+		}
+	}
+
+	return ruleProviders, nil
+}
+
+func parseRules(rulesConfig []string, proxies map[string]C.Proxy, providers map[string]providerTypes.ProxyProvider, ruleProviders map[string]providerTypes.RuleProvider) ([]C.Rule, error) {
 	rules := []C.Rule{}
-	rulesConfig := cfg.Rule
 
 	// parse rules
 	for idx, line := range rulesConfig {
@@ -478,6 +1142,16 @@ func parseRules(cfg *RawConfig, proxies map[string]C.Proxy) ([]C.Rule, error) {
 			params  = []string{}
 		)
 
+		// the payload of logic rules contains commas, e.g. AND,((DOMAIN,a.com),(DST-PORT,443)),PROXY
+		if tp := rule[0]; tp == "AND" || tp == "OR" || tp == "NOT" {
+			_, rest, _ := strings.Cut(line, ",")
+			logicPayload, rest, err := R.SplitLogicPayload(rest)
+			if err != nil {
+				return nil, fmt.Errorf("rules[%d] [%s] error: %s", idx, line, err.Error())
+			}
+			rule = append([]string{tp, logicPayload}, trimArr(strings.Split(rest, ","))...)
+		}
+
 		switch l := len(rule); {
 		case l == 2:
 			target = rule[1]
@@ -492,13 +1166,27 @@ func parseRules(cfg *RawConfig, proxies map[string]C.Proxy) ([]C.Rule, error) {
 			return nil, fmt.Errorf("rules[%d] [%s] error: format invalid", idx, line)
 		}
 
-		if _, ok := proxies[target]; !ok {
+		// the proxy of a provider target is only looked up at match time
+		if providerName, _, ok := T.ParseProviderTarget(target); ok {
+			if _, ok := providers[providerName]; !ok {
+				return nil, fmt.Errorf("rules[%d] [%s] error: proxy provider [%s] not found", idx, line, providerName)
+			}
+		} else if _, ok := proxies[target]; !ok {
 			return nil, fmt.Errorf("rules[%d] [%s] error: proxy [%s] not found", idx, line, target)
 		}
 
 		rule = trimArr(rule)
 		params = trimArr(params)
 
+		if rule[0] == "RULE-SET" {
+			pd, ok := ruleProviders[payload]
+			if !ok {
+				return nil, fmt.Errorf("rules[%d] [%s] error: rule provider [%s] not found", idx, line, payload)
+			}
+			rules = append(rules, R.NewRuleSet(pd, target, R.HasNoResolve(params)))
+			continue
+		}
+
 		parsed, parseErr := R.ParseRule(rule[0], payload, target, params)
 		if parseErr != nil {
 			return nil, fmt.Errorf("rules[%d] [%s] error: %s", idx, line, parseErr.Error())
@@ -510,6 +1198,45 @@ func parseRules(cfg *RawConfig, proxies map[string]C.Proxy) ([]C.Rule, error) {
 	return rules, nil
 }
 
+func parseFinal(cfg *RawConfig, rules []C.Rule, proxies map[string]C.Proxy) (string, error) {
+	hasMatch := lo.ContainsBy(rules, func(rule C.Rule) bool {
+		return rule.RuleType() == C.MATCH
+	})
+
+	if cfg.Final == "" {
+		if !hasMatch {
+			log.Warnln("neither a MATCH rule nor final is configured, unmatched traffic goes DIRECT")
+		}
+		return "DIRECT", nil
+	}
+
+	if _, ok := proxies[cfg.Final]; !ok {
+		return "", fmt.Errorf("final error: proxy [%s] not found", cfg.Final)
+	}
+	if hasMatch {
+		log.Warnln("final [%s] is unused, the MATCH rule catches all unmatched traffic", cfg.Final)
+	}
+
+	return cfg.Final, nil
+}
+
+func parseRewrites(cfg *RawConfig) ([]*T.Rewrite, error) {
+	rewrites := []*T.Rewrite{}
+	for idx, raw := range cfg.Rewrite {
+		rewrite, err := T.NewRewrite(raw.Match, raw.Target)
+		if err != nil {
+			return nil, fmt.Errorf("rewrites[%d] [%s -> %s] error: %w", idx, raw.Match, raw.Target, err)
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+
+	if err := T.CheckRewriteLoop(rewrites); err != nil {
+		return nil, err
+	}
+
+	return rewrites, nil
+}
+
 func parseHosts(cfg *RawConfig) (*trie.DomainTrie, error) {
 	tree := trie.New()
 
@@ -548,10 +1275,17 @@ func hostWithDefaultPort(host string, defPort string) (string, error) {
 	return net.JoinHostPort(hostname, port), nil
 }
 
-func parseNameServer(servers []string) ([]dns.NameServer, error) {
+// parseNameServer parses the nameservers, an url naming one of the groups refers to the group
+func parseNameServer(servers []RawNameServer, groups map[string]dns.NameServerGroup) ([]dns.NameServer, error) {
 	nameservers := []dns.NameServer{}
 
-	for idx, server := range servers {
+	for idx, raw := range servers {
+		if _, ok := groups[raw.URL]; ok {
+			nameservers = append(nameservers, dns.NameServer{Net: "group", Addr: raw.URL})
+			continue
+		}
+
+		server := raw.URL
 		// parse without scheme .e.g 8.8.8.8:53
 		if !strings.Contains(server, "://") {
 			server = "udp://" + server
@@ -591,23 +1325,71 @@ func parseNameServer(servers []string) ([]dns.NameServer, error) {
 			return nil, fmt.Errorf("DNS NameServer[%d] format error: %s", idx, err.Error())
 		}
 
+		tlsOption, hasTLS, err := parseNameServerTLS(raw)
+		if err != nil {
+			return nil, fmt.Errorf("DNS NameServer[%d] %s", idx, err.Error())
+		}
+		if hasTLS && u.Scheme != "tls" && u.Scheme != "https" {
+			return nil, fmt.Errorf("DNS NameServer[%d] tls options require tls:// or https://", idx)
+		}
+
 		nameservers = append(
 			nameservers,
 			dns.NameServer{
 				Net:       dnsNetType,
 				Addr:      addr,
 				Interface: interfaceName,
+				TLS:       tlsOption,
 			},
 		)
 	}
 	return nameservers, nil
 }
 
-func parseNameServerPolicy(nsPolicy map[string]string) (map[string]dns.NameServer, error) {
+func parseNameServerTLS(raw RawNameServer) (option dns.TLSOption, hasTLS bool, err error) {
+	if raw.CA == "" && raw.CAStr == "" && !raw.SkipCertVerify && len(raw.PinSHA256) == 0 && raw.ClientCert == "" && raw.ClientKey == "" {
+		return option, false, nil
+	}
+
+	option.SkipCertVerify = raw.SkipCertVerify
+
+	if option.ClientCert, err = clientcert.New(clientcert.Option{Cert: raw.ClientCert, Key: raw.ClientKey, Passphrase: raw.ClientKeyPassphrase}); err != nil {
+		return option, true, err
+	}
+
+	if raw.CA != "" || raw.CAStr != "" {
+		data := []byte(raw.CAStr)
+		if raw.CA != "" {
+			if data, err = os.ReadFile(C.Path.Resolve(raw.CA)); err != nil {
+				return option, true, fmt.Errorf("ca error: %w", err)
+			}
+		}
+		option.RootCAs = x509.NewCertPool()
+		if !option.RootCAs.AppendCertsFromPEM(data) {
+			return option, true, errors.New("ca error: no valid certificate found")
+		}
+	}
+
+	for _, pin := range raw.PinSHA256 {
+		digest, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(digest) != sha256.Size {
+			// hex is accepted as well, 64 hex digits are valid base64 of 48 bytes
+			digest, err = hex.DecodeString(strings.ReplaceAll(pin, ":", ""))
+		}
+		if err != nil || len(digest) != sha256.Size {
+			return option, true, fmt.Errorf("pin-sha256 %s is not a base64 or hex SHA-256 digest", pin)
+		}
+		option.PinSHA256 = append(option.PinSHA256, digest)
+	}
+
+	return option, true, nil
+}
+
+func parseNameServerPolicy(nsPolicy map[string]RawNameServer, groups map[string]dns.NameServerGroup) (map[string]dns.NameServer, error) {
 	policy := map[string]dns.NameServer{}
 
 	for domain, server := range nsPolicy {
-		nameservers, err := parseNameServer([]string{server})
+		nameservers, err := parseNameServer([]RawNameServer{server}, groups)
 		if err != nil {
 			return nil, err
 		}
@@ -620,6 +1402,34 @@ func parseNameServerPolicy(nsPolicy map[string]string) (map[string]dns.NameServe
 	return policy, nil
 }
 
+func parseNameServerGroups(raw map[string]RawNameServerGroup) (map[string]dns.NameServerGroup, error) {
+	groups := map[string]dns.NameServerGroup{}
+
+	for name, rg := range raw {
+		group := dns.NameServerGroup{Strategy: rg.Strategy}
+		switch rg.Strategy {
+		case "":
+			group.Strategy = dns.GroupStrategyRace
+		case dns.GroupStrategyRace, dns.GroupStrategySequential:
+		default:
+			return nil, fmt.Errorf("DNS NameServerGroup %s unsupported strategy: %s", name, rg.Strategy)
+		}
+
+		if len(rg.NameServer) == 0 {
+			return nil, fmt.Errorf("DNS NameServerGroup %s cannot be empty", name)
+		}
+		// members are plain nameservers, a group doesn't nest another
+		servers, err := parseNameServer(rg.NameServer, nil)
+		if err != nil {
+			return nil, fmt.Errorf("DNS NameServerGroup %s: %w", name, err)
+		}
+		group.Servers = servers
+		groups[name] = group
+	}
+
+	return groups, nil
+}
+
 func parseFallbackIPCIDR(ips []string) ([]*net.IPNet, error) {
 	ipNets := []*net.IPNet{}
 
@@ -636,6 +1446,16 @@ func parseFallbackIPCIDR(ips []string) ([]*net.IPNet, error) {
 
 func parseDNS(rawCfg *RawConfig, hosts *trie.DomainTrie) (*DNS, error) {
 	cfg := rawCfg.DNS
+	switch cfg.Resolver {
+	case "", dnsResolverClash:
+	case dnsResolverSystem:
+		if err := checkSystemResolver(rawCfg); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported dns resolver: %s", cfg.Resolver)
+	}
+
 	if cfg.Enable && len(cfg.NameServer) == 0 {
 		return nil, fmt.Errorf("if DNS configuration is turned on, NameServer cannot be empty")
 	}
@@ -650,22 +1470,29 @@ func parseDNS(rawCfg *RawConfig, hosts *trie.DomainTrie) (*DNS, error) {
 		},
 	}
 	var err error
-	if dnsCfg.NameServer, err = parseNameServer(cfg.NameServer); err != nil {
+	if dnsCfg.NameServerGroups, err = parseNameServerGroups(cfg.NameServerGroups); err != nil {
 		return nil, err
 	}
 
-	if dnsCfg.Fallback, err = parseNameServer(cfg.Fallback); err != nil {
+	if dnsCfg.NameServer, err = parseNameServer(cfg.NameServer, dnsCfg.NameServerGroups); err != nil {
 		return nil, err
 	}
 
-	if dnsCfg.NameServerPolicy, err = parseNameServerPolicy(cfg.NameServerPolicy); err != nil {
+	if dnsCfg.Fallback, err = parseNameServer(cfg.Fallback, dnsCfg.NameServerGroups); err != nil {
+		return nil, err
+	}
+
+	if dnsCfg.NameServerPolicy, err = parseNameServerPolicy(cfg.NameServerPolicy, dnsCfg.NameServerGroups); err != nil {
 		return nil, err
 	}
 
 	if len(cfg.DefaultNameserver) == 0 {
 		return nil, errors.New("default nameserver should have at least one nameserver")
 	}
-	if dnsCfg.DefaultNameserver, err = parseNameServer(cfg.DefaultNameserver); err != nil {
+	defaultNameserver := lo.Map(cfg.DefaultNameserver, func(server string, _ int) RawNameServer {
+		return RawNameServer{URL: server}
+	})
+	if dnsCfg.DefaultNameserver, err = parseNameServer(defaultNameserver, nil); err != nil {
 		return nil, err
 	}
 	// check default nameserver is pure ip addr
@@ -727,9 +1554,237 @@ func parseDNS(rawCfg *RawConfig, hosts *trie.DomainTrie) (*DNS, error) {
 		dnsCfg.SearchDomains = cfg.SearchDomains
 	}
 
+	if dnsCfg.Views, err = parseDNSViews(cfg, dnsCfg.NameServerGroups); err != nil {
+		return nil, err
+	}
+
+	if dnsCfg.PTR, err = parsePTRRecords(cfg.PTRRecords); err != nil {
+		return nil, err
+	}
+
+	// the answers depend on everything but the listen address, the fake ip settings and the
+	// ptr records answered before the cache
+	key := cfg
+	key.Listen, key.EnhancedMode, key.FakeIPRange, key.FakeIPFilter, key.PTRRecords = "", C.DNSNormal, "", nil, nil
+	buf, err := yaml.Marshal(struct {
+		DNS   RawDNS
+		Hosts map[string]string
+	}{key, rawCfg.Hosts})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(buf)
+	dnsCfg.CacheKey = hex.EncodeToString(sum[:])
+
 	return dnsCfg, nil
 }
 
+// checkSystemResolver rejects the options needing clash's own dns with the system resolver,
+// which leaves every lookup to the Go resolver and serves no dns
+func checkSystemResolver(rawCfg *RawConfig) error {
+	cfg := rawCfg.DNS
+	if cfg.Enable {
+		return errors.New("dns resolver system requires dns enable: false")
+	}
+
+	unsupported := []struct {
+		option string
+		set    bool
+	}{
+		{"nameserver", len(cfg.NameServer) != 0},
+		{"fallback", len(cfg.Fallback) != 0},
+		{"nameserver-policy", len(cfg.NameServerPolicy) != 0},
+		{"nameserver-groups", len(cfg.NameServerGroups) != 0},
+		{"listen", cfg.Listen != ""},
+		{"enhanced-mode", cfg.EnhancedMode != C.DNSNormal},
+		{"fake-ip-filter", len(cfg.FakeIPFilter) != 0},
+		{"views", len(cfg.Views) != 0},
+		{"ptr-records", len(cfg.PTRRecords) != 0},
+		{"tun dns-listen", rawCfg.Tun.DNSListen != ""},
+		{"tun dns-hijack", len(rawCfg.Tun.DNSHijack) != 0},
+	}
+	for _, item := range unsupported {
+		if item.set {
+			return fmt.Errorf("dns resolver system doesn't support %s", item.option)
+		}
+	}
+	return nil
+}
+
+func parsePTRRecords(records map[string]string) (map[netip.Addr]string, error) {
+	ptr := map[netip.Addr]string{}
+	for ip, name := range records {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return nil, fmt.Errorf("ptr-records: invalid address %s", ip)
+		}
+		if name = strings.TrimSuffix(name, "."); name == "" || strings.ContainsAny(name, " \t/") {
+			return nil, fmt.Errorf("ptr-records: invalid name %q of %s", name, ip)
+		}
+		ptr[addr.Unmap()] = name
+	}
+	return ptr, nil
+}
+
+func parseDNSViews(cfg RawDNS, groups map[string]dns.NameServerGroup) ([]dns.View, error) {
+	views := []dns.View{}
+	names := map[string]bool{}
+
+	for idx, raw := range cfg.Views {
+		name := raw.Name
+		if name == "" {
+			name = strconv.Itoa(idx)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("DNS View[%d] duplicate name: %s", idx, name)
+		}
+		names[name] = true
+
+		if len(raw.Client) == 0 {
+			return nil, fmt.Errorf("DNS View[%d] client cannot be empty", idx)
+		}
+		view := dns.View{Name: name, FakeIP: raw.FakeIP}
+		for _, client := range raw.Client {
+			prefix, err := netip.ParsePrefix(client)
+			if err != nil {
+				return nil, fmt.Errorf("DNS View[%d] client format error: %s", idx, err.Error())
+			}
+			view.Clients = append(view.Clients, prefix.Masked())
+		}
+
+		if raw.FakeIP != nil && *raw.FakeIP && cfg.EnhancedMode != C.DNSFakeIP {
+			return nil, fmt.Errorf("DNS View[%d] fake-ip requires enhanced-mode fake-ip", idx)
+		}
+
+		if len(raw.Hosts) != 0 {
+			view.Hosts = trie.New()
+			for domain, ipStr := range raw.Hosts {
+				ip := net.ParseIP(ipStr)
+				if ip == nil {
+					return nil, fmt.Errorf("DNS View[%d] %s is not a valid IP", idx, ipStr)
+				}
+				view.Hosts.Insert(domain, ip)
+			}
+		}
+
+		var err error
+		if view.Main, err = parseNameServer(raw.NameServer, groups); err != nil {
+			return nil, fmt.Errorf("DNS View[%d]: %w", idx, err)
+		}
+		if view.Policy, err = parseNameServerPolicy(raw.NameServerPolicy, groups); err != nil {
+			return nil, fmt.Errorf("DNS View[%d]: %w", idx, err)
+		}
+
+		views = append(views, view)
+	}
+
+	return views, nil
+}
+
+func parseQUICListeners(listeners []QUICListener) ([]QUICListener, error) {
+	names := map[string]bool{}
+	for i := range listeners {
+		l := &listeners[i]
+		if l.Name == "" {
+			l.Name = l.Type + "/" + l.Listen
+		}
+		if names[l.Name] {
+			return nil, fmt.Errorf("quic-listeners: duplicate name %s", l.Name)
+		}
+		names[l.Name] = true
+
+		if _, _, err := net.SplitHostPort(l.Listen); err != nil {
+			return nil, fmt.Errorf("quic-listeners %s: invalid listen %s", l.Name, l.Listen)
+		}
+		if l.Certificate == "" || l.PrivateKey == "" {
+			return nil, fmt.Errorf("quic-listeners %s: certificate and private-key are required", l.Name)
+		}
+		if len(l.Users) == 0 {
+			return nil, fmt.Errorf("quic-listeners %s: no users", l.Name)
+		}
+
+		switch l.Type {
+		case QUICListenerHysteria2:
+			for _, bw := range []string{l.Up, l.Down} {
+				if _, err := quicconn.ParseBandwidth(bw); err != nil {
+					return nil, fmt.Errorf("quic-listeners %s: %w", l.Name, err)
+				}
+			}
+		case QUICListenerTUIC:
+			if l.Up != "" || l.Down != "" {
+				return nil, fmt.Errorf("quic-listeners %s: up and down only apply to hysteria2", l.Name)
+			}
+			for id := range l.Users {
+				if _, err := uuid.FromString(id); err != nil {
+					return nil, fmt.Errorf("quic-listeners %s: user %s is not a uuid", l.Name, id)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("quic-listeners %s: unknown type %s, expect hysteria2 or tuic", l.Name, l.Type)
+		}
+	}
+	return listeners, nil
+}
+
+func parseCapabilities(raw map[string]RawCapability) (map[string]inbound.Capability, error) {
+	capabilities := map[string]inbound.Capability{}
+	for name, rc := range raw {
+		switch name {
+		case inbound.ListenerHTTP, inbound.ListenerSocks, inbound.ListenerMixed:
+		default:
+			return nil, fmt.Errorf("listener-capabilities: unknown listener %s, expect http, socks or mixed", name)
+		}
+		if rc.HTTPConnectOnly && name == inbound.ListenerSocks {
+			return nil, errors.New("listener-capabilities: http-connect-only doesn't apply to socks")
+		}
+		if rc.SocksUDP != nil && name == inbound.ListenerHTTP {
+			return nil, errors.New("listener-capabilities: socks-udp doesn't apply to http")
+		}
+		if lo.Contains(rc.AllowedDestinationPorts, 0) {
+			return nil, fmt.Errorf("listener-capabilities %s: invalid port 0", name)
+		}
+		if (rc.ForwardHeaders != "" || len(rc.ForwardHeadersOverrides) > 0) && name == inbound.ListenerSocks {
+			return nil, errors.New("listener-capabilities: forward-headers doesn't apply to socks")
+		}
+		switch rc.ForwardHeaders {
+		case "", inbound.ForwardHeadersStrip, inbound.ForwardHeadersAppend, inbound.ForwardHeadersPreserve:
+		default:
+			return nil, fmt.Errorf("listener-capabilities %s: invalid forward-headers %s, expect strip, append or preserve", name, rc.ForwardHeaders)
+		}
+
+		capability := inbound.Capability{
+			HTTPConnectOnly:  rc.HTTPConnectOnly,
+			SocksUDPDisabled: rc.SocksUDP != nil && !*rc.SocksUDP,
+			AllowedPorts:     rc.AllowedDestinationPorts,
+			ForwardHeaders:   rc.ForwardHeaders,
+		}
+		if len(rc.ForwardHeadersOverrides) > 0 {
+			overrides, err := inbound.NewForwardOverrides(rc.ForwardHeadersOverrides)
+			if err != nil {
+				return nil, fmt.Errorf("listener-capabilities %s forward-headers-overrides %w", name, err)
+			}
+			capability.ForwardOverrides = overrides
+		}
+		if rr := rc.RejectResponse; rr.Enable {
+			if name == inbound.ListenerSocks {
+				return nil, errors.New("listener-capabilities: reject-response doesn't apply to socks")
+			}
+			if rr.Status == 0 {
+				rr.Status = inbound.DefaultRejectStatus
+			}
+			if rr.Status < 400 || rr.Status > 599 {
+				return nil, fmt.Errorf("listener-capabilities %s reject-response: invalid status %d, expect 4xx or 5xx", name, rr.Status)
+			}
+			if rr.Body == "" {
+				rr.Body = inbound.DefaultRejectBody
+			}
+			capability.RejectResponse = &inbound.RejectResponse{Status: rr.Status, Body: rr.Body, ShowRule: rr.ShowRule}
+		}
+		capabilities[name] = capability
+	}
+	return capabilities, nil
+}
+
 func parseAuthentication(rawRecords []string) []auth.AuthUser {
 	users := []auth.AuthUser{}
 	for _, line := range rawRecords {
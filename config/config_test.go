@@ -0,0 +1,385 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/dns"
+	T "github.com/Dreamacro/clash/tunnel"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDNS_SystemResolver(t *testing.T) {
+	cfg, err := Parse([]byte("dns:\n  resolver: system\n"))
+	assert.NoError(t, err)
+	assert.False(t, cfg.DNS.Enable)
+	assert.Nil(t, cfg.DNS.FakeIPRange)
+
+	for _, tt := range []struct {
+		config string
+		err    string
+	}{
+		{"dns:\n  enable: true\n  resolver: system\n  nameserver: [1.1.1.1]\n", "requires dns enable: false"},
+		{"dns:\n  resolver: system\n  enhanced-mode: fake-ip\n", "enhanced-mode"},
+		{"dns:\n  resolver: system\n  fake-ip-filter: ['*.lan']\n", "fake-ip-filter"},
+		{"dns:\n  resolver: system\n  nameserver-policy: {'+.lan': 10.0.0.1}\n", "nameserver-policy"},
+		{"dns:\n  resolver: system\ntun:\n  dns-listen: 0.0.0.0:53\n", "tun dns-listen"},
+		{"dns:\n  resolver: system\ntun:\n  dns-hijack: [any:53]\n", "tun dns-hijack"},
+		{"tun:\n  dns-hijack: [8.8.8.8]\n", "tun dns-hijack"},
+		{"dns:\n  resolver: upstream\n", "unsupported dns resolver"},
+	} {
+		_, err := Parse([]byte(tt.config))
+		if assert.Error(t, err, tt.config) {
+			assert.Contains(t, err.Error(), tt.err)
+		}
+	}
+}
+
+func TestParseTun_Sniffing(t *testing.T) {
+	cfg, err := Parse([]byte("tun:\n  sniffing:\n    enable: true\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, TunSniffing{Enable: true, Ports: []uint16{443, 80}, Timeout: 100}, cfg.General.Tun.Sniffing)
+
+	cfg, err = Parse([]byte("tun:\n  sniffing: {enable: true, ports: [8443], timeout: 50}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{8443}, cfg.General.Tun.Sniffing.Ports)
+
+	_, err = Parse([]byte("tun:\n  sniffing: {enable: true, timeout: 0}\n"))
+	assert.ErrorContains(t, err, "tun sniffing: invalid timeout")
+	_, err = Parse([]byte("tun:\n  sniffing: {enable: true, ports: []}\n"))
+	assert.ErrorContains(t, err, "tun sniffing: ports")
+}
+
+func TestParseTun_Bypass(t *testing.T) {
+	cfg, err := Parse([]byte("tun:\n  exclude-cidr: [192.168.0.0/16, '::ffff:10.0.0.0/104']\n  reject-excluded: true\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.168.0.0/16", "::ffff:10.0.0.0/104"}, cfg.General.Tun.ExcludeCIDR)
+	assert.True(t, cfg.General.Tun.RejectExcluded)
+
+	_, err = Parse([]byte("tun:\n  exclude-cidr: [192.168.0.1]\n"))
+	assert.ErrorContains(t, err, "tun exclude-cidr")
+	_, err = Parse([]byte("tun:\n  include-cidr: ['::ffff:0:0/64']\n"))
+	assert.ErrorContains(t, err, "tun include-cidr")
+}
+
+func TestParseDNS_NameServerGroups(t *testing.T) {
+	cfg, err := Parse([]byte(`
+dns:
+  enable: true
+  nameserver-groups:
+    corp: [10.0.0.53, 10.0.1.53]
+    public:
+      strategy: sequential
+      nameserver: [tls://1.1.1.1]
+  nameserver: [public]
+  fallback: [corp, 8.8.8.8]
+  nameserver-policy:
+    '+.corp.example': corp
+`))
+	assert.NoError(t, err)
+
+	groups := cfg.DNS.NameServerGroups
+	assert.Equal(t, dns.GroupStrategyRace, groups["corp"].Strategy)
+	assert.Equal(t, "10.0.1.53:53", groups["corp"].Servers[1].Addr)
+	assert.Equal(t, dns.GroupStrategySequential, groups["public"].Strategy)
+	assert.Equal(t, "tcp-tls", groups["public"].Servers[0].Net)
+
+	assert.Equal(t, []dns.NameServer{{Net: "group", Addr: "public"}}, cfg.DNS.NameServer)
+	assert.Equal(t, dns.NameServer{Net: "group", Addr: "corp"}, cfg.DNS.Fallback[0])
+	assert.Equal(t, "8.8.8.8:53", cfg.DNS.Fallback[1].Addr)
+	assert.Equal(t, dns.NameServer{Net: "group", Addr: "corp"}, cfg.DNS.NameServerPolicy["+.corp.example"])
+
+	for _, tt := range []struct {
+		config string
+		err    string
+	}{
+		{"dns:\n  nameserver-groups:\n    corp: []\n", "cannot be empty"},
+		{"dns:\n  nameserver-groups:\n    corp: {strategy: fastest, nameserver: [10.0.0.53]}\n", "unsupported strategy"},
+	} {
+		_, err := Parse([]byte(tt.config))
+		if assert.Error(t, err, tt.config) {
+			assert.Contains(t, err.Error(), tt.err)
+		}
+	}
+}
+
+func TestParseDNS_CacheKey(t *testing.T) {
+	key := func(config string) string {
+		cfg, err := Parse([]byte(config))
+		assert.NoError(t, err)
+		return cfg.DNS.CacheKey
+	}
+
+	base := key("dns:\n  enable: true\n  nameserver: [8.8.8.8]\n")
+	assert.NotEmpty(t, base)
+	assert.Equal(t, base, key("dns:\n  enable: true\n  listen: 127.0.0.1:53\n  enhanced-mode: fake-ip\n  nameserver: [8.8.8.8]\n"))
+	assert.NotEqual(t, base, key("dns:\n  enable: true\n  nameserver: [1.1.1.1]\n"))
+	assert.NotEqual(t, base, key("hosts:\n  a.example: 10.0.0.1\ndns:\n  enable: true\n  nameserver: [8.8.8.8]\n"))
+}
+
+func TestParseRules_ProviderTarget(t *testing.T) {
+	home := C.Path.HomeDir()
+	defer C.SetHomeDir(home)
+	C.SetHomeDir(t.TempDir())
+	sub := "proxies:\n  - {name: HK/03, type: socks5, server: 127.0.0.1, port: 1080}\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(C.Path.HomeDir(), "sub.yaml"), []byte(sub), 0o644))
+
+	const providers = `
+proxy-providers:
+  sub:
+    type: file
+    path: ./sub.yaml
+`
+	cfg, err := Parse([]byte(providers + `
+provider-target-fallback: REJECT
+rules:
+  - DOMAIN-SUFFIX,example.com,provider:sub/HK/03
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, "provider:sub/HK/03", cfg.Rules[0].Adapter())
+	assert.Equal(t, "REJECT", cfg.ProviderFallback)
+
+	for _, tt := range []struct {
+		config string
+		err    string
+	}{
+		{"rules:\n  - DOMAIN,a.com,provider:other/HK\n", "proxy provider [other] not found"},
+		{"rules:\n  - DOMAIN,a.com,provider:sub\n", "proxy [provider:sub] not found"},
+		{"provider-target-fallback: nowhere\n", "provider-target-fallback"},
+	} {
+		_, err := Parse([]byte(providers + tt.config))
+		if assert.Error(t, err, tt.config) {
+			assert.Contains(t, err.Error(), tt.err)
+		}
+	}
+}
+
+func TestParseProxies_ForwardReferences(t *testing.T) {
+	home := C.Path.HomeDir()
+	defer C.SetHomeDir(home)
+	C.SetHomeDir(t.TempDir())
+	sub := "proxies:\n  - {name: HK/03, type: socks5, server: 127.0.0.1, port: 1080}\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(C.Path.HomeDir(), "sub.yaml"), []byte(sub), 0o644))
+
+	// groups before the groups and providers they use, a provider tested via a group
+	cfg, err := Parse([]byte(`
+proxy-groups:
+  - {name: all, type: select, proxies: [auto, hk]}
+  - {name: auto, type: select, use: [sub, other]}
+  - {name: hk, type: select, use: [sub], proxies: [DIRECT]}
+  - {name: via, type: select, proxies: [DIRECT]}
+proxy-providers:
+  sub:
+    type: file
+    path: ./sub.yaml
+  other:
+    type: file
+    path: ./sub.yaml
+    health-check: {enable: false, url: http://www.gstatic.com/generate_204, interval: 0, test-via: via}
+`))
+	assert.NoError(t, err)
+	for _, name := range []string{"all", "auto", "hk", "via"} {
+		assert.Contains(t, cfg.Proxies, name)
+	}
+	assert.Contains(t, cfg.Providers, "other")
+
+	_, err = Parse([]byte(`
+proxy-groups:
+  - {name: a, type: select, proxies: [b]}
+  - {name: b, type: select, proxies: [a]}
+`))
+	assert.EqualError(t, err, "loop is detected in ProxyGroup: a -> b -> a")
+}
+
+func TestParseProxyGroups_ConsistentEndpoints(t *testing.T) {
+	cfg, err := Parse([]byte(`
+proxies:
+  - {name: a, type: socks5, server: 192.0.2.1, port: 1080, udp: true}
+  - {name: b, type: socks5, server: 192.0.2.2, port: 1080, udp: true}
+proxy-groups:
+  - {name: lb, type: load-balance, strategy: round-robin, proxies: [a, b], url: http://www.gstatic.com/generate_204, interval: 300, consistent-endpoints: true}
+  - {name: rr, type: load-balance, strategy: round-robin, proxies: [a, b], url: http://www.gstatic.com/generate_204, interval: 300}
+`))
+	assert.NoError(t, err)
+
+	tcp := &C.Metadata{NetWork: C.TCP, SrcIP: net.ParseIP("10.0.0.2"), Host: "facetime.example", DstIP: net.ParseIP("198.51.100.7"), DstPort: "443"}
+	udp := &C.Metadata{NetWork: C.UDP, SrcIP: net.ParseIP("10.0.0.2"), DstIP: net.ParseIP("198.51.100.7"), DstPort: "3478"}
+	other := &C.Metadata{NetWork: C.UDP, SrcIP: net.ParseIP("10.0.0.3"), DstIP: net.ParseIP("198.51.100.7"), DstPort: "3478"}
+
+	lb := cfg.Proxies["lb"]
+	pinned := lb.Unwrap(tcp)
+	assert.Same(t, pinned, lb.Unwrap(udp))
+	assert.Same(t, pinned, lb.Unwrap(tcp))
+	// another source goes on with the round robin
+	assert.NotSame(t, pinned, lb.Unwrap(other))
+
+	rr := cfg.Proxies["rr"]
+	assert.NotSame(t, rr.Unwrap(tcp), rr.Unwrap(udp))
+
+	_, err = Parse([]byte(`
+proxy-groups:
+  - {name: s, type: select, proxies: [DIRECT], consistent-endpoints: true}
+`))
+	assert.ErrorContains(t, err, "consistent-endpoints only applies to url-test, fallback and load-balance")
+}
+
+func TestParse_SecureDefaults(t *testing.T) {
+	open := "allow-lan: true\nbind-address: '*'\nmixed-port: 7890\n"
+	_, err := Parse([]byte(open))
+	assert.NoError(t, err)
+
+	_, err = Parse([]byte("config-version: 2\n" + open))
+	assert.ErrorContains(t, err, "mixed on *:7890 without authentication")
+	_, err = Parse([]byte("secure-defaults: true\nexternal-controller: 0.0.0.0:9090\n"))
+	assert.ErrorContains(t, err, "external-controller on 0.0.0.0:9090")
+
+	for _, config := range []string{
+		"config-version: 2\nsecure-defaults: false\n" + open,
+		"config-version: 2\ninsecure-allow-open: true\n" + open,
+		"config-version: 2\nauthentication: ['a:b']\n" + open,
+		"config-version: 2\nallow-lan: true\nbind-address: 127.0.0.1\nmixed-port: 7890\n",
+	} {
+		_, err := Parse([]byte(config))
+		assert.NoError(t, err, config)
+	}
+
+	cfg, err := Parse([]byte("config-version: 2\nexternal-controller: :9090\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:9090", cfg.General.ExternalController)
+}
+
+func TestParseCapabilities_RejectResponse(t *testing.T) {
+	cfg, err := Parse([]byte("listener-capabilities:\n  mixed:\n    reject-response: {enable: true, show-rule: true}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, &inbound.RejectResponse{Status: 403, Body: inbound.DefaultRejectBody, ShowRule: true}, cfg.Capabilities[inbound.ListenerMixed].RejectResponse)
+
+	_, err = Parse([]byte("listener-capabilities:\n  socks:\n    reject-response: {enable: true}\n"))
+	assert.ErrorContains(t, err, "doesn't apply to socks")
+	_, err = Parse([]byte("listener-capabilities:\n  http:\n    reject-response: {enable: true, status: 200}\n"))
+	assert.ErrorContains(t, err, "invalid status 200")
+}
+
+func TestParseQUICListeners(t *testing.T) {
+	cfg, err := Parse([]byte(`
+quic-listeners:
+  - type: hysteria2
+    listen: 0.0.0.0:8443
+    certificate: server.crt
+    private-key: server.key
+    users: {alice: secret}
+    up: 100 mbps
+  - name: tuic
+    type: tuic
+    listen: 0.0.0.0:8444
+    certificate: server.crt
+    private-key: server.key
+    users: {b831381d-6324-4d53-ad4f-8cda48b30811: secret}
+`))
+	assert.NoError(t, err)
+	assert.Len(t, cfg.QUICListeners, 2)
+	assert.Equal(t, "hysteria2/0.0.0.0:8443", cfg.QUICListeners[0].Name)
+
+	for line, msg := range map[string]string{
+		"{type: tuic, listen: ':1', certificate: a, private-key: b, users: {alice: x}}":         "is not a uuid",
+		"{type: hysteria2, listen: ':1', certificate: a, private-key: b, users: {a: x}, up: x}": "invalid bandwidth",
+		"{type: hysteria2, listen: ':1', users: {a: x}}":                                        "certificate and private-key are required",
+		"{type: hysteria, listen: ':1', certificate: a, private-key: b, users: {a: x}}":         "unknown type hysteria",
+	} {
+		_, err := Parse([]byte("quic-listeners:\n  - " + line + "\n"))
+		assert.ErrorContains(t, err, msg, line)
+	}
+}
+
+func TestParse_ProfileOverlays(t *testing.T) {
+	cfg, err := Parse([]byte(`
+rules:
+  - MATCH,DIRECT
+profiles:
+  - name: work
+    schedule: ['MON-FRI@09:00-18:00']
+    rules:
+      - DOMAIN-SUFFIX,example.com,REJECT
+      - MATCH,DIRECT
+  - name: global
+    mode: global
+`))
+	assert.NoError(t, err)
+	assert.Len(t, cfg.ProfileOverlays, 2)
+	assert.Len(t, cfg.ProfileOverlays[0].Schedule, 1)
+	assert.Len(t, cfg.ProfileOverlays[0].Rules, 2)
+	assert.Nil(t, cfg.ProfileOverlays[0].Mode)
+	assert.Nil(t, cfg.ProfileOverlays[1].Rules)
+	assert.Equal(t, T.Global, *cfg.ProfileOverlays[1].Mode)
+	assert.False(t, cfg.ProfileOverlays[1].Scheduled())
+
+	for config, msg := range map[string]string{
+		"profiles: [{name: a, rules: ['MATCH,MISSING']}]":            "profiles[a]: rules[0] [MATCH,MISSING] error: proxy [MISSING] not found",
+		"profiles: [{name: a, schedule: ['9-18'], mode: rule}]":      "profiles[a] schedule 9-18",
+		"profiles: [{name: default, mode: rule}]":                    "reserved",
+		"profiles: [{name: a, mode: rule}, {name: a, mode: global}]": "duplicate name a",
+		"profiles: [{name: a}]":                                      "neither mode nor rules",
+	} {
+		_, err := Parse([]byte(config))
+		assert.ErrorContains(t, err, msg, config)
+	}
+}
+
+func TestParseDNS_PTRRecords(t *testing.T) {
+	cfg, err := Parse([]byte("dns:\n  ptr-records:\n    198.18.0.1: gateway.clash.\n    '::ffff:198.18.0.2': dns.clash\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[netip.Addr]string{
+		netip.MustParseAddr("198.18.0.1"): "gateway.clash",
+		netip.MustParseAddr("198.18.0.2"): "dns.clash",
+	}, cfg.DNS.PTR)
+
+	_, err = Parse([]byte("dns:\n  ptr-records:\n    198.18.0: gateway.clash\n"))
+	assert.ErrorContains(t, err, "invalid address 198.18.0")
+}
+
+func TestParsePayload_HookCommand(t *testing.T) {
+	command := "hooks:\n  on-proxy-down:\n    command: [touch, /tmp/pwned]\n"
+	cfg, err := Parse([]byte(command))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"touch", "/tmp/pwned"}, cfg.Hooks.OnProxyDown.Command)
+
+	_, err = ParsePayload([]byte(command))
+	assert.ErrorContains(t, err, "hooks on-proxy-down: command is not allowed in a config payload")
+
+	cfg, err = ParsePayload([]byte("hooks:\n  on-proxy-up:\n    url: https://ntfy.example.com/clash\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://ntfy.example.com/clash", cfg.Hooks.OnProxyUp.URL)
+}
+
+func TestParseNameServerTLS_PinSHA256(t *testing.T) {
+	digest := sha256.Sum256([]byte("spki"))
+	hexPin := hex.EncodeToString(digest[:])
+	for _, pin := range []string{
+		base64.StdEncoding.EncodeToString(digest[:]),
+		hexPin,
+		strings.ToUpper(hexPin),
+		// the colon separated form of openssl
+		strings.TrimSuffix(regexp.MustCompile("..").ReplaceAllString(hexPin, "$0:"), ":"),
+	} {
+		option, hasTLS, err := parseNameServerTLS(RawNameServer{PinSHA256: []string{pin}})
+		if assert.NoError(t, err, pin) {
+			assert.True(t, hasTLS)
+			assert.Equal(t, [][]byte{digest[:]}, option.PinSHA256, pin)
+		}
+	}
+
+	for _, pin := range []string{"not a pin", hexPin[:62], base64.StdEncoding.EncodeToString(digest[:16])} {
+		_, _, err := parseNameServerTLS(RawNameServer{PinSHA256: []string{pin}})
+		assert.ErrorContains(t, err, "is not a base64 or hex SHA-256 digest", pin)
+	}
+}
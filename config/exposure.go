@@ -0,0 +1,158 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/Dreamacro/clash/log"
+)
+
+// SecureConfigVersion is the config-version from which secure-defaults is on unless it's
+// set, the initial config is written with it
+const SecureConfigVersion = 2
+
+// Surface is a listener or the controller reachable from other hosts
+type Surface struct {
+	Name    string
+	Address string
+	// Auth tells the surface asks for the authentication or the secret
+	Auth bool
+	// Guarded surfaces are refused without Auth under secure-defaults, the redir, tproxy,
+	// tunnel and health check listeners don't relay to a destination of the client
+	Guarded bool
+}
+
+func (s Surface) String() string {
+	auth := "without authentication"
+	if s.Auth {
+		auth = "with authentication"
+	}
+	return fmt.Sprintf("%s on %s %s", s.Name, s.Address, auth)
+}
+
+// ExposedSurfaces return the listeners and the controller of cfg reachable beyond the
+// loopback, the inbound listeners are only with allow-lan on a bind-address other than it
+func ExposedSurfaces(cfg *Config) []Surface {
+	general := cfg.General
+	auth := len(general.Authentication) > 0
+	surfaces := []Surface{}
+
+	if general.AllowLan {
+		for _, l := range []struct {
+			name    string
+			port    int
+			guarded bool
+		}{
+			{"http", general.Port, true},
+			{"socks", general.SocksPort, true},
+			{"mixed", general.MixedPort, true},
+			{"redir", general.RedirPort, false},
+			{"tproxy", general.TProxyPort, false},
+		} {
+			if l.port == 0 {
+				continue
+			}
+			for _, host := range general.BindAddress {
+				if host != "*" && isLoopback(host) {
+					continue
+				}
+				surfaces = append(surfaces, Surface{
+					Name:    l.name,
+					Address: net.JoinHostPort(host, strconv.Itoa(l.port)),
+					Auth:    auth && l.guarded,
+					Guarded: l.guarded,
+				})
+			}
+		}
+	}
+	for _, t := range cfg.Tunnels {
+		for _, addr := range t.Address {
+			if exposedAddress(addr) {
+				surfaces = append(surfaces, Surface{Name: "tunnel to " + t.Target, Address: addr})
+			}
+		}
+	}
+	if exposedAddress(general.HealthCheckServer) {
+		surfaces = append(surfaces, Surface{Name: "health-check-server", Address: general.HealthCheckServer})
+	}
+	if exposedAddress(general.ExternalController) {
+		surfaces = append(surfaces, Surface{
+			Name:    "external-controller",
+			Address: general.ExternalController,
+			Auth:    general.Secret != "",
+			Guarded: true,
+		})
+	}
+	return surfaces
+}
+
+// CheckSecureDefaults refuses the guarded surfaces of cfg reachable without authentication
+// under secure-defaults, unless insecure-allow-open
+func CheckSecureDefaults(cfg *Config) error {
+	general := cfg.General
+	if !general.SecureDefaults || general.InsecureAllowOpen {
+		return nil
+	}
+	errs := []error{}
+	for _, s := range ExposedSurfaces(cfg) {
+		if s.Guarded && !s.Auth {
+			errs = append(errs, errors.New(s.String()))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("secure-defaults: %w\nset authentication and secret, bind them to the loopback or set insecure-allow-open: true", errors.Join(errs...))
+}
+
+// SecureController binds an external-controller without a secret and a host to the loopback
+// under secure-defaults, then checks cfg. hub.Parse runs it again after the command line.
+func SecureController(cfg *Config) error {
+	general := cfg.General
+	if general.SecureDefaults && !general.InsecureAllowOpen && general.Secret == "" {
+		if host, port, err := net.SplitHostPort(general.ExternalController); err == nil && host == "" {
+			general.ExternalController = net.JoinHostPort("127.0.0.1", port)
+			log.Warnln("[Config] secure-defaults: external-controller without a secret binds %s", general.ExternalController)
+		}
+	}
+	return CheckSecureDefaults(cfg)
+}
+
+// LogExposure prints the surfaces of cfg reachable from other hosts
+func LogExposure(cfg *Config) {
+	surfaces := ExposedSurfaces(cfg)
+	if len(surfaces) == 0 {
+		log.Infoln("[Exposure] only reachable from this host")
+		return
+	}
+	for _, s := range surfaces {
+		if s.Guarded && !s.Auth {
+			log.Warnln("[Exposure] %s", s)
+		} else {
+			log.Infoln("[Exposure] %s", s)
+		}
+	}
+}
+
+// exposedAddress tells whether a listen address is reachable beyond the loopback
+func exposedAddress(addr string) bool {
+	if addr == "" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	return host == "" || !isLoopback(host)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.Unmap().IsLoopback()
+}
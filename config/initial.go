@@ -6,13 +6,15 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/Dreamacro/clash/component/fetch"
 	"github.com/Dreamacro/clash/component/mmdb"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/log"
 )
 
 func downloadMMDB(path string) (err error) {
-	resp, err := http.Get("https://cdn.jsdelivr.net/gh/Dreamacro/maxmind-geoip@release/Country.mmdb")
+	client := &http.Client{Transport: fetch.NewTransport(fetch.Proxy())}
+	resp, err := client.Get("https://cdn.jsdelivr.net/gh/Dreamacro/maxmind-geoip@release/Country.mmdb")
 	if err != nil {
 		return
 	}
@@ -29,6 +31,14 @@ func downloadMMDB(path string) (err error) {
 }
 
 func initMMDB() error {
+	if C.Path.CacheDisabled() {
+		// a read-only mmdb is used as it is
+		if !mmdb.Verify() {
+			log.Warnln("MMDB %s is missing or invalid and not downloaded with disable-cache, GEOIP rules can't work", C.Path.MMDB())
+		}
+		return nil
+	}
+
 	if _, err := os.Stat(C.Path.MMDB()); os.IsNotExist(err) {
 		log.Infoln("Can't find MMDB, start download")
 		if err := downloadMMDB(C.Path.MMDB()); err != nil {
@@ -50,6 +60,60 @@ func initMMDB() error {
 	return nil
 }
 
+// earlyOptions are the options taking effect before the config is parsed
+type earlyOptions struct {
+	FetchProxy   string `yaml:"fetch-proxy"`
+	DisableCache bool   `yaml:"disable-cache"`
+	StateDir     string `yaml:"state-dir"`
+	MMDBPath     string `yaml:"mmdb-path"`
+}
+
+func readEarlyOptions() *earlyOptions {
+	raw := &earlyOptions{}
+	if C.Path.Config() == StdinPath {
+		unmarshalYAML(stdinConfig, raw, true)
+	} else if buf, err := os.ReadFile(C.Path.Config()); err == nil {
+		unmarshalYAML(buf, raw, true)
+	}
+	return raw
+}
+
+func initFetchProxy(raw *earlyOptions) error {
+	if raw.FetchProxy == "" {
+		return nil
+	}
+
+	u, err := fetch.ParseProxy(raw.FetchProxy)
+	if err != nil {
+		return err
+	}
+	fetch.SetProxy(u)
+	return nil
+}
+
+// initState sets where the state is written, a failure is reported once here
+// and the state it concerns lasts for the session
+func initState(raw *earlyOptions) {
+	if raw.MMDBPath != "" {
+		C.SetMMDB(C.Path.Resolve(raw.MMDBPath))
+	}
+
+	if raw.DisableCache {
+		C.SetCacheDisabled(true)
+		log.Infoln("Cache disabled, the cache file, providers and MMDB are not written")
+		return
+	}
+
+	if raw.StateDir == "" {
+		return
+	}
+	dir := C.Path.Resolve(raw.StateDir)
+	C.SetStateDir(dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Warnln("Can't create state directory %s: %s", dir, err.Error())
+	}
+}
+
 // Init prepare necessary files
 func Init(dir string) error {
 	// initial homedir
@@ -59,17 +123,25 @@ func Init(dir string) error {
 		}
 	}
 
-	// initial config.yaml
-	if _, err := os.Stat(C.Path.Config()); os.IsNotExist(err) {
+	// initial config.yaml, there's none for the config from stdin
+	if _, err := os.Stat(C.Path.Config()); os.IsNotExist(err) && C.Path.Config() != StdinPath {
 		log.Infoln("Can't find config, create a initial config file")
 		f, err := os.OpenFile(C.Path.Config(), os.O_CREATE|os.O_WRONLY, 0o644)
 		if err != nil {
 			return fmt.Errorf("can't create file %s: %s", C.Path.Config(), err.Error())
 		}
-		f.Write([]byte(`mixed-port: 7890`))
+		f.Write([]byte(fmt.Sprintf("config-version: %d\nmixed-port: 7890\n", SecureConfigVersion)))
 		f.Close()
 	}
 
+	// the mmdb is downloaded before the config is parsed, pick the fetch proxy
+	// and the state options up early
+	raw := readEarlyOptions()
+	if err := initFetchProxy(raw); err != nil {
+		return err
+	}
+	initState(raw)
+
 	// initial mmdb
 	if err := initMMDB(); err != nil {
 		return fmt.Errorf("can't initial MMDB: %w", err)
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+
+	C "github.com/Dreamacro/clash/constant"
+	providerTypes "github.com/Dreamacro/clash/constant/provider"
+	R "github.com/Dreamacro/clash/rule"
+	T "github.com/Dreamacro/clash/tunnel"
+
+	"github.com/samber/lo"
+)
+
+// DefaultProfileOverlay is the name of the rules and the mode of the config itself
+const DefaultProfileOverlay = "default"
+
+type RawProfileOverlay struct {
+	Name     string        `yaml:"name"`
+	Schedule []string      `yaml:"schedule"`
+	Mode     *T.TunnelMode `yaml:"mode"`
+	Rules    []string      `yaml:"rules"`
+}
+
+// ProfileOverlay replaces the rules and the mode of the config while its schedule covers
+// the current time, the first scheduled overlay in order wins
+type ProfileOverlay struct {
+	Name string
+	// Schedule are TIME ranges, an empty one is only activated by hand
+	Schedule []*R.Time
+	// the unset Mode and nil Rules keep the ones of the config
+	Mode  *T.TunnelMode
+	Rules []C.Rule
+}
+
+// Scheduled reports whether one of the schedule ranges covers the current time
+func (p *ProfileOverlay) Scheduled() bool {
+	return lo.ContainsBy(p.Schedule, func(t *R.Time) bool {
+		return t.Active()
+	})
+}
+
+func parseProfileOverlays(cfg *RawConfig, proxies map[string]C.Proxy, providers map[string]providerTypes.ProxyProvider, ruleProviders map[string]providerTypes.RuleProvider) ([]*ProfileOverlay, error) {
+	overlays := []*ProfileOverlay{}
+	seen := map[string]bool{}
+	for idx, raw := range cfg.ProfileOverlay {
+		switch {
+		case raw.Name == "":
+			return nil, fmt.Errorf("profiles[%d]: missing name", idx)
+		case raw.Name == DefaultProfileOverlay:
+			return nil, fmt.Errorf("profiles[%d]: name %s is reserved for the config itself", idx, raw.Name)
+		case seen[raw.Name]:
+			return nil, fmt.Errorf("profiles[%d]: duplicate name %s", idx, raw.Name)
+		case raw.Mode == nil && raw.Rules == nil:
+			return nil, fmt.Errorf("profiles[%s]: neither mode nor rules is set", raw.Name)
+		}
+		seen[raw.Name] = true
+
+		overlay := &ProfileOverlay{Name: raw.Name, Mode: raw.Mode}
+		for _, payload := range raw.Schedule {
+			t, err := R.NewTime(payload, "")
+			if err != nil {
+				return nil, fmt.Errorf("profiles[%s] schedule %s: %w", raw.Name, payload, err)
+			}
+			overlay.Schedule = append(overlay.Schedule, t)
+		}
+		if raw.Rules != nil {
+			rules, err := parseRules(raw.Rules, proxies, providers, ruleProviders)
+			if err != nil {
+				return nil, fmt.Errorf("profiles[%s]: %w", raw.Name, err)
+			}
+			overlay.Rules = rules
+		}
+		overlays = append(overlays, overlay)
+	}
+	return overlays, nil
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileTag is the yaml tag of a string loaded from a file, `password: !file /run/secrets/pass`,
+// a relative path is of the home dir
+const fileTag = "!file"
+
+// StdinPath is the config path of `-f -`, the config read from stdin at startup
+const StdinPath = "-"
+
+// stdinConfig is the config read from stdin, stdin can't be read again so a reload parses it
+// from here, the files it refers to are read again
+var stdinConfig []byte
+
+// SetStdin keeps the config read from stdin for StdinPath
+func SetStdin(buf []byte) {
+	stdinConfig = buf
+}
+
+// Stdin returns the config kept by SetStdin
+func Stdin() []byte {
+	return stdinConfig
+}
+
+// unmarshalYAML decodes buf into v with the !file scalars replaced by the content of their file.
+// A config from the api payload is unmarshaled without load, its !file scalars are refused
+// as they would let the caller read any file clash can read
+func unmarshalYAML(buf []byte, v any, load bool) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return err
+	}
+	if err := loadFiles(&doc, "", load); err != nil {
+		return err
+	}
+	return doc.Decode(v)
+}
+
+// loadFiles replaces the !file scalars under node, path is the field path of node for the errors.
+// The aliases are left out, their anchor is loaded where it's defined
+func loadFiles(node *yaml.Node, path string, load bool) error {
+	if node.Tag == fileTag && node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("%s (line %d): %s expects the path of a file", path, node.Line, fileTag)
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			if err := loadFiles(child, path, load); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if path != "" {
+				key = path + "." + key
+			}
+			if err := loadFiles(node.Content[i+1], key, load); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			if err := loadFiles(child, path+"["+strconv.Itoa(i)+"]", load); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if node.Tag != fileTag {
+			return nil
+		}
+		if !load {
+			return fmt.Errorf("%s (line %d): %s is not allowed in a config payload", path, node.Line, fileTag)
+		}
+		if node.Value == "" {
+			return fmt.Errorf("%s (line %d): %s without a file path", path, node.Line, fileTag)
+		}
+		buf, err := os.ReadFile(C.Path.Resolve(node.Value))
+		if err != nil {
+			return fmt.Errorf("%s (line %d): %s: %w", path, node.Line, fileTag, err)
+		}
+		node.Tag = "!!str"
+		node.Value = strings.TrimRight(string(buf), "\r\n")
+	}
+	return nil
+}
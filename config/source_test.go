@@ -0,0 +1,38 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse_FileTag(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(file, []byte("s3cret\n"), 0o600))
+
+	cfg, err := Parse([]byte("secret: !file " + file + "\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", cfg.General.Secret)
+
+	// a reload reads the file again
+	require.NoError(t, os.WriteFile(file, []byte("rotated\r\n"), 0o600))
+	cfg, err = Parse([]byte("secret: !file " + file + "\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", cfg.General.Secret)
+
+	raw, err := UnmarshalRawConfig([]byte("proxies:\n  - name: a\n    password: !file " + file + "\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", raw.Proxy[0].(map[string]any)["password"])
+
+	_, err = Parse([]byte("proxies:\n  - name: a\n  - name: b\n    password: !file " + file + ".missing\n"))
+	assert.ErrorContains(t, err, "proxies[1].password (line 4): !file: open "+file+".missing")
+	_, err = Parse([]byte("secret: !file [" + file + "]\n"))
+	assert.ErrorContains(t, err, "secret (line 1): !file expects the path of a file")
+
+	// the payload of the api can't read the files of the host
+	_, err = ParsePayload([]byte("proxy-groups:\n  - name: !file " + file + "\n"))
+	assert.ErrorContains(t, err, "proxy-groups[0].name (line 2): !file is not allowed in a config payload")
+}
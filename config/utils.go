@@ -2,10 +2,13 @@ package config
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/Dreamacro/clash/adapter/outboundgroup"
 	"github.com/Dreamacro/clash/common/structure"
+
+	"github.com/samber/lo"
 )
 
 func trimArr(arr []string) (r []string) {
@@ -15,134 +18,129 @@ func trimArr(arr []string) (r []string) {
 	return
 }
 
-// Check if ProxyGroups form DAG(Directed Acyclic Graph), and sort all ProxyGroups by dependency order.
-// Meanwhile, record the original index in the config file.
-// If loop is detected, return an error with location of loop.
-func proxyGroupsDagSort(groupsConfig []map[string]any) error {
-	type graphNode struct {
-		indegree int
-		// topological order
-		topo int
-		// the original data in `groupsConfig`
-		data map[string]any
-		// `outdegree` and `from` are used in loop locating
-		outdegree int
-		option    *outboundgroup.GroupCommonOption
-		from      []string
+// declaration is a proxy group or a proxy provider of the config
+type declaration struct {
+	name     string
+	provider bool
+	mapping  map[string]any
+	// groups and providers it refers to, in the order of the config
+	groups    []string
+	providers []string
+}
+
+func (d *declaration) String() string {
+	if d.provider {
+		return "provider " + d.name
 	}
+	return d.name
+}
 
-	decoder := structure.NewDecoder(structure.Option{TagName: "group", WeaklyTypedInput: true})
-	graph := make(map[string]*graphNode)
+// resolveOrder sorts the proxy groups and the proxy providers so each one comes after the
+// groups and providers it refers to, a group to the groups of its proxies and test-via and
+// the providers of its use, a provider to the group of its health-check test-via. They are
+// visited in the order of the config, the groups first and the providers by name, so the
+// order and the errors are the same on every run. A loop is reported with its path.
+func resolveOrder(groupsConfig []map[string]any, providersConfig map[string]map[string]any) ([]*declaration, error) {
+	groups := make(map[string]*declaration, len(groupsConfig))
+	providers := make(map[string]*declaration, len(providersConfig))
+	var all []*declaration
 
-	// Step 1.1 build dependency graph
+	// Step 1 parse the declarations
+	groupDecoder := structure.NewDecoder(structure.Option{TagName: "group", WeaklyTypedInput: true})
 	for _, mapping := range groupsConfig {
 		option := &outboundgroup.GroupCommonOption{}
-		if err := decoder.Decode(mapping, option); err != nil {
-			return fmt.Errorf("ProxyGroup %s: %s", option.Name, err.Error())
+		if err := groupDecoder.Decode(mapping, option); err != nil {
+			return nil, fmt.Errorf("ProxyGroup %s: %s", option.Name, err.Error())
 		}
 
-		groupName := option.Name
-		if node, ok := graph[groupName]; ok {
-			if node.data != nil {
-				return fmt.Errorf("ProxyGroup %s: duplicate group name", groupName)
-			}
-			node.data = mapping
-			node.option = option
-		} else {
-			graph[groupName] = &graphNode{0, -1, mapping, 0, option, nil}
+		name := option.Name
+		if _, ok := groups[name]; ok {
+			return nil, fmt.Errorf("ProxyGroup %s: duplicate group name", name)
 		}
-
-		for _, proxy := range option.Proxies {
-			if node, ex := graph[proxy]; ex {
-				node.indegree++
-			} else {
-				graph[proxy] = &graphNode{1, -1, nil, 0, nil, nil}
-			}
+		if option.TestVia == name {
+			return nil, fmt.Errorf("ProxyGroup %s: test-via refers to the group itself", name)
 		}
-	}
-	// Step 1.2 Topological Sort
-	// topological index of **ProxyGroup**
-	index := 0
-	queue := make([]string, 0)
-	for name, node := range graph {
-		// in the beginning, put nodes that have `node.indegree == 0` into queue.
-		if node.indegree == 0 {
-			queue = append(queue, name)
+
+		d := &declaration{name: name, mapping: mapping, groups: option.Proxies, providers: option.Use}
+		// the proxy a group is tested via is a dependency like its proxies
+		if option.TestVia != "" && !lo.Contains(option.Proxies, option.TestVia) {
+			d.groups = append(d.groups[:len(d.groups):len(d.groups)], option.TestVia)
 		}
+		groups[name] = d
+		all = append(all, d)
 	}
-	// every element in queue have indegree == 0
-	for ; len(queue) > 0; queue = queue[1:] {
-		name := queue[0]
-		node := graph[name]
-		if node.option != nil {
-			index++
-			groupsConfig[len(groupsConfig)-index] = node.data
-			if len(node.option.Proxies) == 0 {
-				delete(graph, name)
-				continue
-			}
 
-			for _, proxy := range node.option.Proxies {
-				child := graph[proxy]
-				child.indegree--
-				if child.indegree == 0 {
-					queue = append(queue, proxy)
-				}
-			}
+	providerDecoder := structure.NewDecoder(structure.Option{TagName: "provider", WeaklyTypedInput: true})
+	names := lo.Keys(providersConfig)
+	sort.Strings(names)
+	for _, name := range names {
+		schema := &struct {
+			HealthCheck struct {
+				TestVia string `provider:"test-via,omitempty"`
+			} `provider:"health-check,omitempty"`
+		}{}
+		if err := providerDecoder.Decode(providersConfig[name], schema); err != nil {
+			return nil, fmt.Errorf("parse proxy provider %s error: %w", name, err)
 		}
-		delete(graph, name)
-	}
 
-	// no loop is detected, return sorted ProxyGroup
-	if len(graph) == 0 {
-		return nil
+		d := &declaration{name: name, provider: true, mapping: providersConfig[name]}
+		if via := schema.HealthCheck.TestVia; via != "" {
+			d.groups = []string{via}
+		}
+		providers[name] = d
+		all = append(all, d)
 	}
 
-	// if loop is detected, locate the loop and throw an error
-	// Step 2.1 rebuild the graph, fill `outdegree` and `from` filed
-	for name, node := range graph {
-		if node.option == nil {
-			continue
-		}
+	// Step 2 resolve the references, the names that are none of the groups and providers
+	// are proxies or missing ones, left to the parsers
+	const (
+		visiting = iota + 1
+		done
+	)
+	state := make(map[*declaration]int, len(all))
+	order := make([]*declaration, 0, len(all))
+	var path []*declaration
 
-		if len(node.option.Proxies) == 0 {
-			continue
+	var visit func(d *declaration) error
+	visit = func(d *declaration) error {
+		switch state[d] {
+		case done:
+			return nil
+		case visiting:
+			loop := path[lo.IndexOf(path, d):]
+			elements := make([]string, 0, len(loop)+1)
+			for _, e := range append(loop, d) {
+				elements = append(elements, e.String())
+			}
+			return fmt.Errorf("loop is detected in ProxyGroup: %s", strings.Join(elements, " -> "))
 		}
 
-		for _, proxy := range node.option.Proxies {
-			node.outdegree++
-			child := graph[proxy]
-			if child.from == nil {
-				child.from = make([]string, 0, child.indegree)
+		state[d] = visiting
+		path = append(path, d)
+		for _, name := range d.groups {
+			if dep, ok := groups[name]; ok {
+				if err := visit(dep); err != nil {
+					return err
+				}
 			}
-			child.from = append(child.from, name)
-		}
-	}
-	// Step 2.2 remove nodes outside the loop. so that we have only the loops remain in `graph`
-	queue = make([]string, 0)
-	// initialize queue with node have outdegree == 0
-	for name, node := range graph {
-		if node.outdegree == 0 {
-			queue = append(queue, name)
 		}
-	}
-	// every element in queue have outdegree == 0
-	for ; len(queue) > 0; queue = queue[1:] {
-		name := queue[0]
-		node := graph[name]
-		for _, f := range node.from {
-			graph[f].outdegree--
-			if graph[f].outdegree == 0 {
-				queue = append(queue, f)
+		for _, name := range d.providers {
+			if dep, ok := providers[name]; ok {
+				if err := visit(dep); err != nil {
+					return err
+				}
 			}
 		}
-		delete(graph, name)
+		path = path[:len(path)-1]
+		state[d] = done
+		order = append(order, d)
+		return nil
 	}
-	// Step 2.3 report the elements in loop
-	loopElements := make([]string, 0, len(graph))
-	for name := range graph {
-		loopElements = append(loopElements, name)
-		delete(graph, name)
+
+	for _, d := range all {
+		if err := visit(d); err != nil {
+			return nil, err
+		}
 	}
-	return fmt.Errorf("loop is detected in ProxyGroup, please check following ProxyGroups: %v", loopElements)
+	return order, nil
 }
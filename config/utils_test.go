@@ -0,0 +1,74 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func declarationNames(order []*declaration) []string {
+	names := make([]string, 0, len(order))
+	for _, d := range order {
+		names = append(names, d.String())
+	}
+	return names
+}
+
+func TestResolveOrder_TestVia(t *testing.T) {
+	group := func(name, via string, proxies ...string) map[string]any {
+		return map[string]any{"name": name, "type": "url-test", "proxies": proxies, "test-via": via}
+	}
+
+	order, err := resolveOrder([]map[string]any{group("b", "a", "p2"), group("a", "", "p1")}, nil)
+	require.NoError(t, err)
+	// groups are sorted so the test-via one is parsed first
+	assert.Equal(t, []string{"a", "b"}, declarationNames(order))
+
+	_, err = resolveOrder([]map[string]any{group("a", "a", "p1")}, nil)
+	assert.ErrorContains(t, err, "itself")
+
+	_, err = resolveOrder([]map[string]any{group("a", "b", "p1"), group("b", "", "a")}, nil)
+	assert.ErrorContains(t, err, "loop is detected in ProxyGroup: a -> b -> a")
+}
+
+func TestResolveOrder_ForwardReferences(t *testing.T) {
+	groups := []map[string]any{
+		{"name": "auto", "type": "select", "proxies": []string{"fast", "DIRECT"}},
+		{"name": "fast", "type": "url-test", "use": []string{"sub"}},
+		{"name": "backup", "type": "fallback", "use": []string{"sub", "local"}, "proxies": []string{"fast"}},
+	}
+	providers := map[string]map[string]any{
+		"sub":   {"type": "http"},
+		"local": {"type": "file", "health-check": map[string]any{"test-via": "auto"}},
+	}
+
+	// the same order on every run, whatever the map iteration
+	for i := 0; i < 20; i++ {
+		order, err := resolveOrder(groups, providers)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"provider sub", "fast", "auto", "provider local", "backup"}, declarationNames(order))
+	}
+}
+
+func TestResolveOrder_Loop(t *testing.T) {
+	groups := []map[string]any{
+		{"name": "a", "type": "select", "proxies": []string{"DIRECT", "b"}},
+		{"name": "b", "type": "select", "proxies": []string{"c"}},
+		{"name": "c", "type": "select", "proxies": []string{"a"}},
+	}
+	for i := 0; i < 20; i++ {
+		_, err := resolveOrder(groups, nil)
+		assert.EqualError(t, err, "loop is detected in ProxyGroup: a -> b -> c -> a")
+	}
+
+	// a provider tested via the group using it
+	groups = []map[string]any{{"name": "a", "type": "url-test", "use": []string{"sub"}}}
+	providers := map[string]map[string]any{"sub": {"type": "http", "health-check": map[string]any{"test-via": "a"}}}
+	_, err := resolveOrder(groups, providers)
+	assert.EqualError(t, err, "loop is detected in ProxyGroup: a -> provider sub -> a")
+
+	groups = []map[string]any{{"name": "a", "type": "select", "proxies": []string{"DIRECT"}}, {"name": "a", "type": "select", "proxies": []string{"DIRECT"}}}
+	_, err = resolveOrder(groups, nil)
+	assert.ErrorContains(t, err, "duplicate group name")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/Dreamacro/clash/component/dialer"
@@ -21,6 +22,8 @@ const (
 	Http
 	Vmess
 	Trojan
+	Hysteria2
+	Tuic
 
 	Relay
 	Selector
@@ -74,6 +77,32 @@ type PacketConn interface {
 	// WriteWithMetadata(p []byte, metadata *Metadata) (n int, err error)
 }
 
+// who resolves the destination hostname of a proxy, see DNSResolver
+const (
+	DNSResolveRemote = "remote"
+	DNSResolveLocal  = "local"
+)
+
+// DNSResolver is implemented by the proxies with a dns-resolve option, local resolves
+// the hostname with the resolver of clash and sends the ip to the server
+type DNSResolver interface {
+	DNSResolve() string
+}
+
+// UDPFraming is how an adapter carries a datagram to its server, Overhead is the bytes it
+// adds. A datagram relayed in udp fits the mtu of the path less the overhead, one relayed in
+// a tcp stream (Stream) isn't fragmented but may be capped at MaxPayload, 0 for no cap.
+type UDPFraming struct {
+	Overhead   int  `json:"overhead"`
+	Stream     bool `json:"stream"`
+	MaxPayload int  `json:"maxPayload,omitempty"`
+}
+
+// UDPFramer is implemented by the adapters relaying udp
+type UDPFramer interface {
+	UDPFraming() UDPFraming
+}
+
 type ProxyAdapter interface {
 	Name() string
 	Type() AdapterType
@@ -106,6 +135,17 @@ type DelayHistory struct {
 	MeanDelay uint16    `json:"meanDelay"`
 }
 
+// UDPHistory is the result of a burst of udp probes to an echo server, Loss is the share of
+// the probes lost in percent, Delay the mean round trip and Jitter its mean variation in ms
+type UDPHistory struct {
+	Time   time.Time `json:"time"`
+	Sent   int       `json:"sent"`
+	Lost   int       `json:"lost"`
+	Loss   float64   `json:"loss"`
+	Delay  uint16    `json:"delay"`
+	Jitter uint16    `json:"jitter"`
+}
+
 type Proxy interface {
 	ProxyAdapter
 	Alive() bool
@@ -144,6 +184,10 @@ func (at AdapterType) String() string {
 		return "Vmess"
 	case Trojan:
 		return "Trojan"
+	case Hysteria2:
+		return "Hysteria2"
+	case Tuic:
+		return "Tuic"
 
 	case Relay:
 		return "Relay"
@@ -157,10 +201,38 @@ func (at AdapterType) String() string {
 		return "LoadBalance"
 
 	default:
+		registeredTypesMux.RLock()
+		defer registeredTypesMux.RUnlock()
+		if name, ok := registeredTypes[at]; ok {
+			return name
+		}
 		return "Unknown"
 	}
 }
 
+// the types of RegisterAdapterType are numbered from firstRegisteredType, past the builtin ones
+const firstRegisteredType AdapterType = 1000
+
+var (
+	registeredTypesMux sync.RWMutex
+	registeredTypes    = map[AdapterType]string{}
+)
+
+// RegisterAdapterType allocates the type of an adapter added out of this repo, name is its
+// String. The same name return the same type.
+func RegisterAdapterType(name string) AdapterType {
+	registeredTypesMux.Lock()
+	defer registeredTypesMux.Unlock()
+	for at, registered := range registeredTypes {
+		if registered == name {
+			return at
+		}
+	}
+	at := firstRegisteredType + AdapterType(len(registeredTypes))
+	registeredTypes[at] = name
+	return at
+}
+
 // UDPPacket contains the data of UDP packet, and offers control/info of UDP packet's source
 type UDPPacket interface {
 	// Data get the payload of UDP Packet
@@ -178,3 +250,65 @@ type UDPPacket interface {
 	// LocalAddr returns the source IP/Port of packet
 	LocalAddr() net.Addr
 }
+
+// UDPPacketBatch is implemented by packets coalescing consecutive payloads of the same session
+type UDPPacketBatch interface {
+	// Batch returns the payloads following Data, in the order they arrived
+	Batch() [][]byte
+}
+
+// TunEndpointState is the state of the tun stack side of a tcp connection
+type TunEndpointState struct {
+	State string `json:"state"`
+	// ReceiveQueue is received from the client but not read by clash yet
+	ReceiveQueue int `json:"receiveQueue"`
+	// WritePending is written by clash but not accepted by the stack because the send buffer is full,
+	// it grows when the client stops reading
+	WritePending int64 `json:"writePending"`
+}
+
+// TunEndpoint is implemented by the tcp connections of the tun stack
+type TunEndpoint interface {
+	EndpointState() TunEndpointState
+}
+
+// TunFlow is implemented by the udp packets of the tun stack, Evict drops the session of
+// their source so the return path of a flow closed on purpose isn't kept
+type TunFlow interface {
+	Evict()
+}
+
+// BindListener accepts the connections of a Binder
+type BindListener interface {
+	Accept() (Conn, error)
+	Addr() net.Addr
+	Close() error
+}
+
+// Binder is implemented by the proxy adapters able to accept a connection for the client
+type Binder interface {
+	// ListenContext listens at address, the host of address is where the client expects the peer
+	ListenContext(ctx context.Context, metadata *Metadata, address string) (BindListener, error)
+}
+
+// BindRequest is the inbound connection of a client waiting for a connection from its
+// destination, like the socks5 BIND command. It is matched like any connection, when the
+// proxy is a Binder the listener is handed to Accept
+type BindRequest interface {
+	net.Conn
+	// BindAddress is where to listen so that the client can tell it to the peer
+	BindAddress() string
+	// Accept reports the listener to the client and return the connection of the expected peer
+	Accept(ln BindListener) (Conn, error)
+	// Reject answers the client that its request failed
+	Reject(err error)
+}
+
+// DialResponder is the inbound connection of a client waiting for an answer once its
+// destination is dialed, like the http CONNECT of a listener with a reject response.
+// Respond writes the answer to conn, the inbound as read ahead by the tunnel, rejected is
+// for a connection of REJECT and rule is nil without one. An error ends the connection
+type DialResponder interface {
+	net.Conn
+	Respond(conn net.Conn, rejected bool, rule Rule) error
+}
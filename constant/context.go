@@ -1,6 +1,7 @@
 package constant
 
 import (
+	"context"
 	"net"
 
 	"github.com/gofrs/uuid/v5"
@@ -21,3 +22,23 @@ type PacketConnContext interface {
 	Metadata() *Metadata
 	PacketConn() net.PacketConn
 }
+
+// Upstream is the destination a proxy sent to its server, Resolve is local when
+// clash resolved the hostname
+type Upstream struct {
+	Address string `json:"address"`
+	Resolve string `json:"resolve"`
+}
+
+type upstreamKey struct{}
+
+// WithUpstream return a context recording the destination sent by the proxy dialed with it into u
+func WithUpstream(ctx context.Context, u *Upstream) context.Context {
+	return context.WithValue(ctx, upstreamKey{}, u)
+}
+
+// UpstreamFromContext return the Upstream of ctx, nil if it doesn't record one
+func UpstreamFromContext(ctx context.Context) *Upstream {
+	u, _ := ctx.Value(upstreamKey{}).(*Upstream)
+	return u
+}
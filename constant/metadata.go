@@ -22,6 +22,8 @@ const (
 	TPROXY
 	TUN
 	TUNNEL
+	HYSTERIA2
+	TUIC
 )
 
 type NetWork int
@@ -55,6 +57,12 @@ func (t Type) String() string {
 		return "TProxy"
 	case TUN:
 		return "Tun"
+	case TUNNEL:
+		return "Tunnel"
+	case HYSTERIA2:
+		return "Hysteria2"
+	case TUIC:
+		return "TUIC"
 	default:
 		return "Unknown"
 	}
@@ -77,7 +85,26 @@ type Metadata struct {
 	ProcessPath  string  `json:"processPath"`
 	SpecialProxy string  `json:"specialProxy"`
 
+	// OriginDestination is the address the client asked for when a rewrite changed the destination
+	OriginDestination string `json:"originDestination,omitempty"`
+	// Upstream is the destination sent to the proxy server
+	Upstream *Upstream `json:"upstream,omitempty"`
+	// FwMark is the conntrack mark of a redir/tproxy connection, looked up by the FWMARK rules
+	FwMark *uint32 `json:"fwmark,omitempty"`
+	// DSCP is read from the client packets of a redir/tproxy connection
+	DSCP *uint8 `json:"dscp,omitempty"`
+	// SniffHost is the tls server name of a connection to a host, read by the host-mismatch
+	// check, or the host the tun sniffing set. HostMismatch tells it's not the host
+	SniffHost    string `json:"sniffHost,omitempty"`
+	HostMismatch bool   `json:"hostMismatch,omitempty"`
+
 	OriginDst netip.AddrPort `json:"-"`
+	// ProcessLooked tells the inbound looked ProcessPath up already, a miss too, the rules
+	// don't look again
+	ProcessLooked bool `json:"-"`
+	// TTL is set on the socket of a DIRECT udp flow, a traceroute probe from tun with
+	// trace-ttl, 0 leaves it to the system
+	TTL uint8 `json:"-"`
 }
 
 func (m *Metadata) RemoteAddress() string {
@@ -23,6 +23,10 @@ var Path = func() *path {
 type path struct {
 	homeDir    string
 	configFile string
+
+	stateDir      string
+	mmdbFile      string
+	cacheDisabled bool
 }
 
 // SetHomeDir is used to set the configuration path
@@ -35,6 +39,21 @@ func SetConfig(file string) {
 	Path.configFile = file
 }
 
+// SetStateDir sets where the cache file and the http providers are written, the home dir by default
+func SetStateDir(dir string) {
+	Path.stateDir = dir
+}
+
+// SetMMDB sets the mmdb file, Country.mmdb of the home dir by default
+func SetMMDB(file string) {
+	Path.mmdbFile = file
+}
+
+// SetCacheDisabled disables every write of state, for a read-only filesystem
+func SetCacheDisabled(disabled bool) {
+	Path.cacheDisabled = disabled
+}
+
 func (p *path) HomeDir() string {
 	return p.homeDir
 }
@@ -54,9 +73,38 @@ func (p *path) Resolve(path string) string {
 
 // IsSubPath return true if path is a subpath of homedir
 func (p *path) IsSubPath(path string) bool {
-	homedir := p.HomeDir()
-	path = p.Resolve(path)
-	rel, err := filepath.Rel(homedir, path)
+	return isSubPath(p.HomeDir(), p.Resolve(path))
+}
+
+// StateDir return where the state is written
+func (p *path) StateDir() string {
+	if p.stateDir != "" {
+		return p.stateDir
+	}
+	return p.homeDir
+}
+
+// ResolveState return a absolute path or a relative path with the state dir
+func (p *path) ResolveState(path string) string {
+	if !filepath.IsAbs(path) {
+		return filepath.Join(p.StateDir(), path)
+	}
+
+	return path
+}
+
+// IsStateSubPath return true if path is a subpath of the state dir
+func (p *path) IsStateSubPath(path string) bool {
+	return isSubPath(p.StateDir(), p.ResolveState(path))
+}
+
+// CacheDisabled reports whether nothing may be written, see SetCacheDisabled
+func (p *path) CacheDisabled() bool {
+	return p.cacheDisabled
+}
+
+func isSubPath(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
 	if err != nil {
 		return false
 	}
@@ -65,6 +113,9 @@ func (p *path) IsSubPath(path string) bool {
 }
 
 func (p *path) MMDB() string {
+	if p.mmdbFile != "" {
+		return p.mmdbFile
+	}
 	return P.Join(p.homeDir, "Country.mmdb")
 }
 
@@ -73,5 +124,5 @@ func (p *path) OldCache() string {
 }
 
 func (p *path) Cache() string {
-	return P.Join(p.homeDir, "cache.db")
+	return P.Join(p.StateDir(), "cache.db")
 }
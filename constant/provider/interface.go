@@ -1,6 +1,8 @@
 package provider
 
 import (
+	"context"
+
 	"github.com/Dreamacro/clash/constant"
 )
 
@@ -72,6 +74,29 @@ type ProxyProvider interface {
 	HealthCheck()
 }
 
+// HealthCheckWaiter is implemented by the proxy providers probing their proxies when
+// power save resumes
+type HealthCheckWaiter interface {
+	// WaitHealthCheck blocks until the probe burst is over or ctx is done
+	WaitHealthCheck(ctx context.Context)
+}
+
+// ProxyQuarantine is implemented by the proxy providers taking the proxies failing their
+// health checks out of Proxies
+type ProxyQuarantine interface {
+	// AllProxies return the proxies with the quarantined ones
+	AllProxies() []constant.Proxy
+	IsQuarantined(name string) bool
+	// Unquarantine restores all quarantined proxies
+	Unquarantine()
+}
+
+// ProxyAliases is implemented by the proxy providers dropping duplicated proxies
+type ProxyAliases interface {
+	// Alias return the name of the proxy kept for a dropped duplicate
+	Alias(name string) (string, bool)
+}
+
 // Rule Type
 const (
 	Domain RuleType = iota
@@ -103,3 +128,9 @@ type RuleProvider interface {
 	ShouldResolveIP() bool
 	AsRule(adaptor string) constant.Rule
 }
+
+// RuleInspector is implemented by rule providers exposing their entries
+type RuleInspector interface {
+	Payload() []string
+	Lookup(*constant.Metadata) (entry string, matched bool)
+}
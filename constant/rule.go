@@ -13,6 +13,12 @@ const (
 	Process
 	ProcessPath
 	IPSet
+	Time
+	RuleSet
+	FWMark
+	AND
+	OR
+	NOT
 	MATCH
 )
 
@@ -42,6 +48,18 @@ func (rt RuleType) String() string {
 		return "ProcessPath"
 	case IPSet:
 		return "IPSet"
+	case Time:
+		return "Time"
+	case RuleSet:
+		return "RuleSet"
+	case FWMark:
+		return "FWMark"
+	case AND:
+		return "AND"
+	case OR:
+		return "OR"
+	case NOT:
+		return "NOT"
 	case MATCH:
 		return "Match"
 	default:
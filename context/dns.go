@@ -1,6 +1,8 @@
 package context
 
 import (
+	"net/netip"
+
 	"github.com/gofrs/uuid/v5"
 	"github.com/miekg/dns"
 )
@@ -12,16 +14,18 @@ const (
 )
 
 type DNSContext struct {
-	id  uuid.UUID
-	msg *dns.Msg
-	tp  string
+	id     uuid.UUID
+	msg    *dns.Msg
+	tp     string
+	client netip.Addr
 }
 
-func NewDNSContext(msg *dns.Msg) *DNSContext {
+func NewDNSContext(msg *dns.Msg, client netip.Addr) *DNSContext {
 	id, _ := uuid.NewV4()
 	return &DNSContext{
-		id:  id,
-		msg: msg,
+		id:     id,
+		msg:    msg,
+		client: client,
 	}
 }
 
@@ -39,3 +43,8 @@ func (c *DNSContext) SetType(tp string) {
 func (c *DNSContext) Type() string {
 	return c.tp
 }
+
+// Client return the source address of the query, it is invalid when unknown
+func (c *DNSContext) Client() netip.Addr {
+	return c.client
+}
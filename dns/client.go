@@ -87,6 +87,6 @@ func (c *client) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error)
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	case ret := <-ch:
-		return ret.msg, ret.err
+		return ret.msg, checkCertError(net.JoinHostPort(c.host, c.port), ret.err)
 	}
 }
@@ -3,7 +3,6 @@ package dns
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"fmt"
 	"io"
 	"math/rand"
@@ -42,9 +41,10 @@ func (dc *dohClient) ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Msg,
 
 	req = req.WithContext(ctx)
 	msg, err = dc.doRequest(req)
-	if err == nil {
-		msg.Id = m.Id
+	if err != nil {
+		return nil, checkCertError(dc.url, err)
 	}
+	msg.Id = m.Id
 	return
 }
 
@@ -82,9 +82,13 @@ func (dc *dohClient) doRequest(req *http.Request) (msg *D.Msg, err error) {
 	return msg, err
 }
 
-func newDoHClient(url, iface string, r *Resolver) *dohClient {
+func newDoHClient(rawURL, iface string, option TLSOption, r *Resolver) *dohClient {
+	// alpn identifier, see https://tools.ietf.org/html/draft-hoffman-dprive-dns-tls-alpn-00#page-6
+	tlsConfig := option.config("")
+	tlsConfig.NextProtos = []string{"dns"}
+
 	return &dohClient{
-		url: url,
+		url: rawURL,
 		transport: &http.Transport{
 			ForceAttemptHTTP2: true,
 			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
@@ -108,10 +112,7 @@ func newDoHClient(url, iface string, r *Resolver) *dohClient {
 
 				return dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port), options...)
 			},
-			TLSClientConfig: &tls.Config{
-				// alpn identifier, see https://tools.ietf.org/html/draft-hoffman-dprive-dns-tls-alpn-00#page-6
-				NextProtos: []string{"dns"},
-			},
+			TLSClientConfig: tlsConfig,
 		},
 	}
 }
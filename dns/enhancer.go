@@ -2,6 +2,7 @@ package dns
 
 import (
 	"net"
+	"net/netip"
 
 	"github.com/Dreamacro/clash/common/cache"
 	"github.com/Dreamacro/clash/component/fakeip"
@@ -12,6 +13,8 @@ type ResolverEnhancer struct {
 	mode     C.DNSMode
 	fakePool *fakeip.Pool
 	mapping  *cache.LruCache
+	// ptr are the names the reverse lookups of the addresses are answered with
+	ptr map[netip.Addr]string
 }
 
 func (h *ResolverEnhancer) FakeIPEnabled() bool {
@@ -62,6 +65,14 @@ func (h *ResolverEnhancer) FindHostByIP(ip net.IP) (string, bool) {
 	return "", false
 }
 
+// FakeIPPool return the fake ip pool, nil if fake-ip is disabled
+func (h *ResolverEnhancer) FakeIPPool() *fakeip.Pool {
+	if !h.FakeIPEnabled() {
+		return nil
+	}
+	return h.fakePool
+}
+
 func (h *ResolverEnhancer) PatchFrom(o *ResolverEnhancer) {
 	if h.mapping != nil && o.mapping != nil {
 		o.mapping.CloneTo(h.mapping)
@@ -85,5 +96,6 @@ func NewEnhancer(cfg Config) *ResolverEnhancer {
 		mode:     cfg.EnhancedMode,
 		fakePool: fakePool,
 		mapping:  mapping,
+		ptr:      cfg.PTR,
 	}
 }
@@ -0,0 +1,206 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	D "github.com/miekg/dns"
+	"go.uber.org/atomic"
+)
+
+// strategies of a nameserver group
+const (
+	GroupStrategyRace       = "race"
+	GroupStrategySequential = "sequential"
+)
+
+const (
+	// a member failing memberMaxFailures times in a row is skipped for memberDownDuration
+	memberMaxFailures  = 3
+	memberDownDuration = 30 * time.Second
+)
+
+// NameServerGroup is a named set of nameservers, every reference to it shares the same clients
+type NameServerGroup struct {
+	Strategy string
+	Servers  []NameServer
+}
+
+type groupMember struct {
+	dnsClient
+	addr      string
+	queries   atomic.Int64
+	failures  atomic.Int64
+	failed    atomic.Int64 // consecutive failures
+	downUntil atomic.Int64 // unix nano
+}
+
+func (m *groupMember) healthy(now time.Time) bool {
+	return m.downUntil.Load() <= now.UnixNano()
+}
+
+func (m *groupMember) Exchange(msg *D.Msg) (*D.Msg, error) {
+	return m.ExchangeContext(context.Background(), msg)
+}
+
+// ExchangeContext implements dnsClient and records the health of the member
+func (m *groupMember) ExchangeContext(ctx context.Context, msg *D.Msg) (*D.Msg, error) {
+	resp, err := m.dnsClient.ExchangeContext(ctx, msg)
+	if err == nil && (resp.Rcode == D.RcodeServerFailure || resp.Rcode == D.RcodeRefused) {
+		err = errors.New("server failure")
+	}
+
+	if err != nil && ctx.Err() != nil {
+		// cancelled by a faster member or the caller, says nothing about this one
+		return nil, err
+	}
+
+	m.queries.Inc()
+	if err != nil {
+		m.failures.Inc()
+		if m.failed.Inc() >= memberMaxFailures {
+			m.downUntil.Store(time.Now().Add(memberDownDuration).UnixNano())
+		}
+		return nil, err
+	}
+
+	m.failed.Store(0)
+	m.downUntil.Store(0)
+	return resp, nil
+}
+
+type nameServerGroup struct {
+	name     string
+	strategy string
+	members  []*groupMember
+	queries  atomic.Int64
+	failures atomic.Int64
+}
+
+func newNameServerGroup(name string, group NameServerGroup, resolver *Resolver) *nameServerGroup {
+	g := &nameServerGroup{name: name, strategy: group.Strategy}
+	if g.strategy == "" {
+		g.strategy = GroupStrategyRace
+	}
+
+	for idx, client := range transform(group.Servers, resolver) {
+		g.members = append(g.members, &groupMember{dnsClient: client, addr: group.Servers[idx].Addr})
+	}
+	return g
+}
+
+// candidates return the healthy members, or all of them when none is healthy
+func (g *nameServerGroup) candidates() []dnsClient {
+	now := time.Now()
+	healthy := make([]dnsClient, 0, len(g.members))
+	for _, m := range g.members {
+		if m.healthy(now) {
+			healthy = append(healthy, m)
+		}
+	}
+
+	if len(healthy) != 0 {
+		return healthy
+	}
+
+	all := make([]dnsClient, 0, len(g.members))
+	for _, m := range g.members {
+		all = append(all, m)
+	}
+	return all
+}
+
+func (g *nameServerGroup) Exchange(m *D.Msg) (*D.Msg, error) {
+	return g.ExchangeContext(context.Background(), m)
+}
+
+// ExchangeContext implements dnsClient with the strategy of the group
+func (g *nameServerGroup) ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Msg, err error) {
+	g.queries.Inc()
+	defer func() {
+		if err != nil {
+			g.failures.Inc()
+		}
+	}()
+
+	clients := g.candidates()
+	if g.strategy != GroupStrategySequential {
+		return batchExchange(ctx, clients, m)
+	}
+
+	for _, client := range clients {
+		if msg, err = client.ExchangeContext(ctx, m); err == nil {
+			recordUpstream(ctx, msg, client)
+			return msg, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("group %s: %w", g.name, err)
+}
+
+// MemberStats is the health of a member of a nameserver group
+type MemberStats struct {
+	Addr     string `json:"addr"`
+	Healthy  bool   `json:"healthy"`
+	Queries  int64  `json:"queries"`
+	Failures int64  `json:"failures"`
+}
+
+// GroupStats is the state of a nameserver group
+type GroupStats struct {
+	Name     string        `json:"name"`
+	Strategy string        `json:"strategy"`
+	Queries  int64         `json:"queries"`
+	Failures int64         `json:"failures"`
+	Members  []MemberStats `json:"members"`
+}
+
+// clients transform servers like transform, a server of Net "group" refers to the group named by its Addr
+func (r *Resolver) clients(servers []NameServer, resolver *Resolver) []dnsClient {
+	ret := []dnsClient{}
+	for _, s := range servers {
+		if s.Net == "group" {
+			if g, ok := r.groups[s.Addr]; ok {
+				ret = append(ret, g)
+			}
+			continue
+		}
+		ret = append(ret, transform([]NameServer{s}, resolver)...)
+	}
+	return ret
+}
+
+// GroupStats return the state of the nameserver groups
+func (r *Resolver) GroupStats() []GroupStats {
+	stats := make([]GroupStats, 0, len(r.groups))
+	for _, g := range r.groups {
+		stats = append(stats, g.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+func (g *nameServerGroup) stats() GroupStats {
+	now := time.Now()
+	stats := GroupStats{
+		Name:     g.name,
+		Strategy: g.strategy,
+		Queries:  g.queries.Load(),
+		Failures: g.failures.Load(),
+		Members:  []MemberStats{},
+	}
+	for _, m := range g.members {
+		stats.Members = append(stats.Members, MemberStats{
+			Addr:     m.addr,
+			Healthy:  m.healthy(now),
+			Queries:  m.queries.Load(),
+			Failures: m.failures.Load(),
+		})
+	}
+	return stats
+}
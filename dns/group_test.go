@@ -0,0 +1,93 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+)
+
+type stubClient struct {
+	err   error
+	calls atomic.Int64
+}
+
+func (c *stubClient) Exchange(m *D.Msg) (*D.Msg, error) {
+	return c.ExchangeContext(context.Background(), m)
+}
+
+func (c *stubClient) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	c.calls.Inc()
+	if c.err != nil {
+		return nil, c.err
+	}
+	return (&D.Msg{}).SetReply(m), nil
+}
+
+func TestNameServerGroup_Sequential(t *testing.T) {
+	down := &stubClient{err: errors.New("timeout")}
+	up := &stubClient{}
+	g := &nameServerGroup{
+		name:     "corp",
+		strategy: GroupStrategySequential,
+		members: []*groupMember{
+			{dnsClient: down, addr: "10.0.0.53:53"},
+			{dnsClient: up, addr: "10.0.1.53:53"},
+		},
+	}
+
+	msg := (&D.Msg{}).SetQuestion("corp.example.", D.TypeA)
+	for i := 0; i < memberMaxFailures+2; i++ {
+		_, err := g.ExchangeContext(context.Background(), msg)
+		assert.NoError(t, err)
+	}
+
+	// the failing member is skipped once it is down
+	assert.Equal(t, int64(memberMaxFailures), down.calls.Load())
+	assert.Equal(t, int64(memberMaxFailures+2), up.calls.Load())
+
+	stats := g.stats()
+	assert.Equal(t, int64(memberMaxFailures+2), stats.Queries)
+	assert.Zero(t, stats.Failures)
+	assert.False(t, stats.Members[0].Healthy)
+	assert.Equal(t, int64(memberMaxFailures), stats.Members[0].Failures)
+	assert.True(t, stats.Members[1].Healthy)
+
+	// every member down, all of them are tried again
+	up.err = errors.New("refused")
+	for i := 0; i < memberMaxFailures; i++ {
+		_, err := g.ExchangeContext(context.Background(), msg)
+		assert.Error(t, err)
+	}
+	_, err := g.ExchangeContext(context.Background(), msg)
+	assert.ErrorContains(t, err, "group corp")
+	assert.Equal(t, int64(memberMaxFailures+1), down.calls.Load())
+}
+
+func TestResolver_Groups(t *testing.T) {
+	r := NewResolver(Config{
+		Main: []NameServer{{Net: "group", Addr: "corp"}},
+		Policy: map[string]NameServer{
+			"+.corp.example": {Net: "group", Addr: "corp"},
+		},
+		Groups: map[string]NameServerGroup{
+			"corp": {Servers: []NameServer{{Addr: "10.0.0.53:53"}, {Addr: "10.0.1.53:53"}}},
+		},
+		Views: []View{{Name: "guest"}},
+	})
+
+	g := r.groups["corp"]
+	assert.Equal(t, GroupStrategyRace, g.strategy)
+	assert.Len(t, g.members, 2)
+	assert.Same(t, g, r.main[0])
+	assert.Same(t, g, r.policy.Search("www.corp.example").Data.([]dnsClient)[0])
+	// one group for every reference, views included
+	assert.Same(t, g, r.views[0].resolver.main[0])
+
+	stats := r.GroupStats()
+	assert.Equal(t, "corp", stats[0].Name)
+	assert.Equal(t, "10.0.0.53:53", stats[0].Members[0].Addr)
+}
@@ -157,7 +157,7 @@ func withResolver(resolver *Resolver) handler {
 
 		msg, err := resolver.Exchange(r)
 		if err != nil {
-			log.Debugln("[DNS Server] Exchange %s failed: %v", q.String(), err)
+			log.Dedupln(log.DEBUG, err.Error(), "[DNS Server] Exchange %s failed: %v", q.String(), err)
 			return msg, err
 		}
 		msg.SetRcode(r, msg.Rcode)
@@ -178,17 +178,46 @@ func compose(middlewares []middleware, endpoint handler) handler {
 	return h
 }
 
-func NewHandler(resolver *Resolver, mapper *ResolverEnhancer) handler {
+func newHandler(resolver *Resolver, mapper *ResolverEnhancer, hosts *trie.DomainTrie, fakeIP bool) handler {
 	middlewares := []middleware{}
 
+	// the fake ips are answered by any view, so are the ptr records
+	if len(mapper.ptr) != 0 || mapper.FakeIPEnabled() {
+		middlewares = append(middlewares, withPTR(mapper))
+	}
+
+	// hosts of a view take precedence over the global hosts
+	if hosts != nil {
+		middlewares = append(middlewares, withHosts(hosts))
+	}
+
 	if resolver.hosts != nil {
 		middlewares = append(middlewares, withHosts(resolver.hosts))
 	}
 
-	if mapper.mode == C.DNSFakeIP {
+	if mapper.mode == C.DNSFakeIP && fakeIP {
 		middlewares = append(middlewares, withFakeIP(mapper.fakePool))
 		middlewares = append(middlewares, withMapping(mapper.mapping))
 	}
 
 	return compose(middlewares, withResolver(resolver))
 }
+
+func NewHandler(resolver *Resolver, mapper *ResolverEnhancer) handler {
+	base := newHandler(resolver, mapper, nil, true)
+	if len(resolver.views) == 0 {
+		return base
+	}
+
+	handlers := make([]handler, len(resolver.views))
+	for idx, v := range resolver.views {
+		handlers[idx] = newHandler(v.resolver, mapper, v.hosts, v.fakeIP == nil || *v.fakeIP)
+	}
+
+	return func(ctx *context.DNSContext, r *D.Msg) (*D.Msg, error) {
+		if idx := resolver.matchView(ctx.Client()); idx >= 0 {
+			return handlers[idx](ctx, r)
+		}
+		return base(ctx, r)
+	}
+}
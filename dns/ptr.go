@@ -0,0 +1,102 @@
+package dns
+
+import (
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/Dreamacro/clash/context"
+
+	D "github.com/miekg/dns"
+)
+
+// ptrAddr return the address of a reverse name, d.c.b.a.in-addr.arpa. or the 32 nibbles
+// of an ip6.arpa. one, an ipv4-mapped ipv6 address is unmapped
+func ptrAddr(name string) (netip.Addr, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if labels, ok := strings.CutSuffix(name, ".in-addr.arpa"); ok {
+		parts := strings.Split(labels, ".")
+		if len(parts) != 4 {
+			return netip.Addr{}, false
+		}
+		var ip [4]byte
+		for i, part := range parts {
+			// no leading zeros, as a reverse name is written
+			if len(part) > 1 && part[0] == '0' {
+				return netip.Addr{}, false
+			}
+			b, err := strconv.ParseUint(part, 10, 8)
+			if err != nil {
+				return netip.Addr{}, false
+			}
+			ip[3-i] = byte(b)
+		}
+		return netip.AddrFrom4(ip), true
+	}
+	if labels, ok := strings.CutSuffix(name, ".ip6.arpa"); ok {
+		parts := strings.Split(labels, ".")
+		if len(parts) != 32 {
+			return netip.Addr{}, false
+		}
+		var ip [16]byte
+		for i, part := range parts {
+			if len(part) != 1 {
+				return netip.Addr{}, false
+			}
+			nibble, err := strconv.ParseUint(part, 16, 4)
+			if err != nil {
+				return netip.Addr{}, false
+			}
+			// the first label is the low nibble of the last byte
+			pos := 31 - i
+			ip[pos/2] |= byte(nibble) << (4 * (1 - pos%2))
+		}
+		return netip.AddrFrom16(ip).Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// withPTR answers the reverse lookups of the ptr records and of the fake-ip range, an
+// unmapped fake ip is NXDOMAIN rather than a slow miss upstream
+func withPTR(mapper *ResolverEnhancer) middleware {
+	return func(next handler) handler {
+		return func(ctx *context.DNSContext, r *D.Msg) (*D.Msg, error) {
+			q := r.Question[0]
+			if q.Qtype != D.TypePTR {
+				return next(ctx, r)
+			}
+			addr, ok := ptrAddr(q.Name)
+			if !ok {
+				return next(ctx, r)
+			}
+
+			var host string
+			var ttl uint32
+			if name, ok := mapper.ptr[addr]; ok {
+				ctx.SetType(context.DNSTypeHost)
+				host, ttl = name, dnsDefaultTTL
+			} else if mapper.IsFakeIP(addr.AsSlice()) {
+				ctx.SetType(context.DNSTypeFakeIP)
+				// the mapping of a fake ip can change, its A record has a ttl of 1 too
+				host, _ = mapper.fakePool.LookBack(addr.AsSlice())
+				ttl = 1
+			} else {
+				return next(ctx, r)
+			}
+
+			msg := r.Copy()
+			if host == "" {
+				msg.SetRcode(r, D.RcodeNameError)
+			} else {
+				rr := &D.PTR{}
+				rr.Hdr = D.RR_Header{Name: q.Name, Rrtype: D.TypePTR, Class: D.ClassINET, Ttl: ttl}
+				rr.Ptr = D.Fqdn(host)
+				msg.Answer = []D.RR{rr}
+				msg.SetRcode(r, D.RcodeSuccess)
+			}
+			msg.Authoritative = true
+			msg.RecursionAvailable = true
+			return msg, nil
+		}
+	}
+}
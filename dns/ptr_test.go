@@ -0,0 +1,79 @@
+package dns
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/Dreamacro/clash/component/fakeip"
+	C "github.com/Dreamacro/clash/constant"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPTRAddr(t *testing.T) {
+	for name, addr := range map[string]string{
+		"2.0.18.198.in-addr.arpa.": "198.18.0.2",
+		"1.0.0.127.IN-ADDR.ARPA":   "127.0.0.1",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa.": "::1",
+		"b.a.9.8.7.6.5.0.4.0.0.0.3.0.0.0.2.0.0.0.1.0.0.0.0.0.0.0.1.2.3.4.ip6.arpa.": "4321:0:1:2:3:4:567:89ab",
+		// ::ffff:198.18.0.2
+		"2.0.0.0.2.1.6.c.f.f.f.f.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa.": "198.18.0.2",
+	} {
+		got, ok := ptrAddr(name)
+		require.True(t, ok, name)
+		assert.Equal(t, netip.MustParseAddr(addr), got, name)
+	}
+
+	for _, name := range []string{
+		"example.com.",
+		"0.18.198.in-addr.arpa.",
+		"256.0.18.198.in-addr.arpa.",
+		"02.0.18.198.in-addr.arpa.",
+		"1.0.0.0.ip6.arpa.",
+		"g.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa.",
+	} {
+		_, ok := ptrAddr(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestPTR_FakeIP(t *testing.T) {
+	_, ipnet, _ := net.ParseCIDR("198.18.0.1/16")
+	pool, err := fakeip.New(fakeip.Options{IPNet: ipnet, Size: 10})
+	require.NoError(t, err)
+
+	config := Config{
+		Main:         []NameServer{{Addr: "127.0.0.1:53"}},
+		EnhancedMode: C.DNSFakeIP,
+		Pool:         pool,
+		PTR:          map[netip.Addr]string{netip.MustParseAddr("198.18.0.1"): "gateway.clash"},
+	}
+	r := NewResolver(config)
+	m := NewEnhancer(config)
+
+	query := func(name string) *D.Msg {
+		msg := &D.Msg{}
+		msg.SetQuestion(name, D.TypePTR)
+		resp, err := Query(r, m, msg, netip.MustParseAddr("127.0.0.1"))
+		require.NoError(t, err)
+		return resp
+	}
+
+	ip := pool.Lookup("example.com")
+	name, _ := D.ReverseAddr(ip.String())
+	resp := query(name)
+	assert.Equal(t, D.RcodeSuccess, resp.Rcode)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, "example.com.", resp.Answer[0].(*D.PTR).Ptr)
+
+	resp = query("200.200.18.198.in-addr.arpa.")
+	assert.Equal(t, D.RcodeNameError, resp.Rcode)
+	assert.Empty(t, resp.Answer)
+
+	resp = query("1.0.18.198.in-addr.arpa.")
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, "gateway.clash.", resp.Answer[0].(*D.PTR).Ptr)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"net/netip"
 	"strings"
 	"time"
 
@@ -38,8 +39,35 @@ type Resolver struct {
 	fallbackIPFilters     []fallbackIPFilter
 	group                 singleflight.Group
 	lruCache              *cache.LruCache
+	index                 *ipIndex
 	policy                *trie.DomainTrie
 	searchDomains         []string
+	views                 []*view
+	cachePrefix           string
+	groups                map[string]*nameServerGroup
+	defaultResolver       *Resolver
+}
+
+// FlushCache drops the cached answers, of the views and the resolver of the nameserver
+// hosts too, and return how many there were. The queries in flight cache their answer.
+func (r *Resolver) FlushCache() int {
+	n := r.lruCache.Clear()
+	r.index.clear()
+	if r.defaultResolver != nil {
+		n += r.defaultResolver.lruCache.Clear()
+		r.defaultResolver.index.clear()
+	}
+	return n
+}
+
+// PatchFrom keeps the cached answers of the old resolver
+func (r *Resolver) PatchFrom(o *Resolver) {
+	o.lruCache.CloneTo(r.lruCache)
+	o.index.cloneTo(r.index)
+	if r.defaultResolver != nil && o.defaultResolver != nil {
+		o.defaultResolver.lruCache.CloneTo(r.defaultResolver.lruCache)
+		o.defaultResolver.index.cloneTo(r.defaultResolver.index)
+	}
 }
 
 // LookupIP request with TypeA and TypeAAAA, priority return TypeA
@@ -135,7 +163,7 @@ func (r *Resolver) ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Msg, e
 	}
 
 	q := m.Question[0]
-	cache, expireTime, hit := r.lruCache.GetWithExpire(q.String())
+	cache, expireTime, hit := r.lruCache.GetWithExpire(r.cacheKey(q))
 	if hit {
 		now := time.Now()
 		msg = cache.(*D.Msg).Copy()
@@ -158,8 +186,10 @@ func (r *Resolver) ExchangeContext(ctx context.Context, m *D.Msg) (msg *D.Msg, e
 // ExchangeWithoutCache a batch of dns request, and it do NOT GET from cache
 func (r *Resolver) exchangeWithoutCache(ctx context.Context, m *D.Msg) (msg *D.Msg, err error) {
 	q := m.Question[0]
+	key := r.cacheKey(q)
+	ctx, upstreams := withUpstreams(ctx)
 
-	ret, err, shared := r.group.Do(q.String(), func() (result any, err error) {
+	ret, err, shared := r.group.Do(key, func() (result any, err error) {
 		defer func() {
 			if err != nil {
 				return
@@ -167,7 +197,9 @@ func (r *Resolver) exchangeWithoutCache(ctx context.Context, m *D.Msg) (msg *D.M
 
 			msg := result.(*D.Msg)
 
-			putMsgToCache(r.lruCache, q.String(), q, msg)
+			if putMsgToCache(r.lruCache, key, q, msg) {
+				r.index.put(key, msg, upstreams.of(msg))
+			}
 		}()
 
 		isIPReq := isIPRequest(q)
@@ -191,6 +223,10 @@ func (r *Resolver) exchangeWithoutCache(ctx context.Context, m *D.Msg) (msg *D.M
 	return
 }
 
+func (r *Resolver) cacheKey(q D.Question) string {
+	return r.cachePrefix + q.String()
+}
+
 func (r *Resolver) batchExchange(ctx context.Context, clients []dnsClient, m *D.Msg) (msg *D.Msg, err error) {
 	ctx, cancel := context.WithTimeout(ctx, resolver.DefaultDNSTimeout)
 	defer cancel()
@@ -341,6 +377,7 @@ type NameServer struct {
 	Net       string
 	Addr      string
 	Interface string
+	TLS       TLSOption
 }
 
 type FallbackFilter struct {
@@ -360,30 +397,51 @@ type Config struct {
 	Hosts          *trie.DomainTrie
 	Policy         map[string]NameServer
 	SearchDomains  []string
+	Views          []View
+	// Groups are referenced by a NameServer with Net "group" and the name as Addr
+	Groups map[string]NameServerGroup
+	// PTR are the names of the reverse lookups answered locally
+	PTR map[netip.Addr]string
 }
 
 func NewResolver(config Config) *Resolver {
+	index := newIPIndex()
 	defaultResolver := &Resolver{
 		main:     transform(config.Default, nil),
-		lruCache: cache.New(cache.WithSize(4096), cache.WithStale(true)),
+		lruCache: cache.New(cache.WithSize(4096), cache.WithStale(true), cache.WithEvict(index.evicted)),
+		index:    index,
+	}
+
+	groups := map[string]*nameServerGroup{}
+	for name, group := range config.Groups {
+		groups[name] = newNameServerGroup(name, group, defaultResolver)
 	}
 
+	return newResolver(config, defaultResolver, groups)
+}
+
+func newResolver(config Config, defaultResolver *Resolver, groups map[string]*nameServerGroup) *Resolver {
+	index := newIPIndex()
 	r := &Resolver{
 		ipv6:          config.IPv6,
-		main:          transform(config.Main, defaultResolver),
-		lruCache:      cache.New(cache.WithSize(4096), cache.WithStale(true)),
+		lruCache:      cache.New(cache.WithSize(4096), cache.WithStale(true), cache.WithEvict(index.evicted)),
+		index:         index,
 		hosts:         config.Hosts,
 		searchDomains: config.SearchDomains,
+		groups:        groups,
+		// the views share it with the base resolver
+		defaultResolver: defaultResolver,
 	}
+	r.main = r.clients(config.Main, defaultResolver)
 
 	if len(config.Fallback) != 0 {
-		r.fallback = transform(config.Fallback, defaultResolver)
+		r.fallback = r.clients(config.Fallback, defaultResolver)
 	}
 
 	if len(config.Policy) != 0 {
 		r.policy = trie.New()
 		for domain, nameserver := range config.Policy {
-			r.policy.Insert(domain, transform([]NameServer{nameserver}, defaultResolver))
+			r.policy.Insert(domain, r.clients([]NameServer{nameserver}, defaultResolver))
 		}
 	}
 
@@ -403,5 +461,14 @@ func NewResolver(config Config) *Resolver {
 		r.fallbackDomainFilters = fallbackDomainFilters
 	}
 
+	for _, v := range config.Views {
+		r.views = append(r.views, &view{
+			clients:  v.Clients,
+			hosts:    v.Hosts,
+			fakeIP:   v.FakeIP,
+			resolver: newViewResolver(r, config, v, defaultResolver),
+		})
+	}
+
 	return r
 }
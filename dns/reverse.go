@@ -0,0 +1,233 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	D "github.com/miekg/dns"
+)
+
+// CachedAnswer is a record of a cached answer holding an ip, TTL is what is left of the
+// cached answer in seconds, 0 once it's stale. Upstream is the nameserver the answer came
+// from, empty for one cached before the reverse lookup was there.
+type CachedAnswer struct {
+	Question string `json:"question"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	TTL      uint32 `json:"ttl"`
+	Stale    bool   `json:"stale"`
+	Upstream string `json:"upstream,omitempty"`
+}
+
+// ipIndex is the by-ip index of the cache of a resolver, the views share it with their cache.
+// It can be behind the cache, the answers are looked up in the cache again.
+type ipIndex struct {
+	mux   sync.Mutex
+	byIP  map[netip.Addr]map[string]struct{}
+	byKey map[string]indexedAnswer
+}
+
+type indexedAnswer struct {
+	ips      []netip.Addr
+	upstream string
+}
+
+func newIPIndex() *ipIndex {
+	return &ipIndex{byIP: map[netip.Addr]map[string]struct{}{}, byKey: map[string]indexedAnswer{}}
+}
+
+// put indexes the answer of key in place of the previous one
+func (x *ipIndex) put(key string, msg *D.Msg, upstream string) {
+	ips := []netip.Addr{}
+	for _, ip := range msgToIP(msg) {
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			ips = append(ips, addr.Unmap())
+		}
+	}
+
+	x.mux.Lock()
+	defer x.mux.Unlock()
+	x.removeLocked(key)
+	if len(ips) == 0 {
+		return
+	}
+	x.byKey[key] = indexedAnswer{ips: ips, upstream: upstream}
+	for _, ip := range ips {
+		keys := x.byIP[ip]
+		if keys == nil {
+			keys = map[string]struct{}{}
+			x.byIP[ip] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// evicted is the evict callback of the cache
+func (x *ipIndex) evicted(key any, _ any) {
+	if key, ok := key.(string); ok {
+		x.mux.Lock()
+		x.removeLocked(key)
+		x.mux.Unlock()
+	}
+}
+
+func (x *ipIndex) removeLocked(key string) {
+	answer, ok := x.byKey[key]
+	if !ok {
+		return
+	}
+	delete(x.byKey, key)
+	for _, ip := range answer.ips {
+		delete(x.byIP[ip], key)
+		if len(x.byIP[ip]) == 0 {
+			delete(x.byIP, ip)
+		}
+	}
+}
+
+func (x *ipIndex) clear() {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+	x.byIP = map[netip.Addr]map[string]struct{}{}
+	x.byKey = map[string]indexedAnswer{}
+}
+
+func (x *ipIndex) cloneTo(n *ipIndex) {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.byIP = make(map[netip.Addr]map[string]struct{}, len(x.byIP))
+	for ip, keys := range x.byIP {
+		n.byIP[ip] = make(map[string]struct{}, len(keys))
+		for key := range keys {
+			n.byIP[ip][key] = struct{}{}
+		}
+	}
+	n.byKey = make(map[string]indexedAnswer, len(x.byKey))
+	for key, answer := range x.byKey {
+		n.byKey[key] = answer
+	}
+}
+
+// lookup return the cache keys of the answers holding ip with their upstream
+func (x *ipIndex) lookup(ip netip.Addr) map[string]string {
+	x.mux.Lock()
+	defer x.mux.Unlock()
+	keys := map[string]string{}
+	for key := range x.byIP[ip] {
+		keys[key] = x.byKey[key].upstream
+	}
+	return keys
+}
+
+// Reverse return the records of the cached answers holding ip, of the views and the resolver
+// of the nameserver hosts too, ordered by question
+func (r *Resolver) Reverse(ip netip.Addr) []CachedAnswer {
+	ip = ip.Unmap()
+	answers := r.reverse(ip)
+	if r.defaultResolver != nil {
+		answers = append(answers, r.defaultResolver.reverse(ip)...)
+	}
+	sort.SliceStable(answers, func(i, j int) bool { return answers[i].Question < answers[j].Question })
+	return answers
+}
+
+func (r *Resolver) reverse(ip netip.Addr) []CachedAnswer {
+	now := time.Now()
+	answers := []CachedAnswer{}
+	for key, upstream := range r.index.lookup(ip) {
+		value, expires, ok := r.lruCache.PeekWithExpire(key)
+		if !ok {
+			continue
+		}
+		msg := value.(*D.Msg)
+		var ttl uint32
+		if left := expires.Sub(now); left > 0 {
+			ttl = uint32(left.Seconds())
+		}
+		for _, rr := range msg.Answer {
+			var rrIP net.IP
+			switch a := rr.(type) {
+			case *D.A:
+				rrIP = a.A
+			case *D.AAAA:
+				rrIP = a.AAAA
+			default:
+				continue
+			}
+			if addr, ok := netip.AddrFromSlice(rrIP); !ok || addr.Unmap() != ip {
+				continue
+			}
+			answers = append(answers, CachedAnswer{
+				Question: msg.Question[0].Name,
+				Name:     rr.Header().Name,
+				Type:     D.TypeToString[rr.Header().Rrtype],
+				TTL:      ttl,
+				Stale:    ttl == 0,
+				Upstream: upstream,
+			})
+		}
+	}
+	return answers
+}
+
+type upstreamsKey struct{}
+
+// upstreams records the nameserver answering each message of an exchange, the first one
+// recorded for a message is kept, the innermost client of a group or dhcp records it first
+type upstreams struct {
+	mux   sync.Mutex
+	names map[*D.Msg]string
+}
+
+func withUpstreams(ctx context.Context) (context.Context, *upstreams) {
+	u := &upstreams{names: map[*D.Msg]string{}}
+	return context.WithValue(ctx, upstreamsKey{}, u), u
+}
+
+func recordUpstream(ctx context.Context, msg *D.Msg, client dnsClient) {
+	u, ok := ctx.Value(upstreamsKey{}).(*upstreams)
+	if !ok {
+		return
+	}
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if _, exist := u.names[msg]; !exist {
+		u.names[msg] = clientName(client)
+	}
+}
+
+func (u *upstreams) of(msg *D.Msg) string {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	return u.names[msg]
+}
+
+func clientName(c dnsClient) string {
+	switch c := c.(type) {
+	case *client:
+		addr := net.JoinHostPort(c.host, c.port)
+		switch c.Client.Net {
+		case "tcp":
+			return "tcp://" + addr
+		case "tcp-tls":
+			return "tls://" + addr
+		}
+		return addr
+	case *dohClient:
+		return c.url
+	case *dhcpClient:
+		return "dhcp://" + c.ifaceName
+	case *groupMember:
+		return c.addr
+	case *nameServerGroup:
+		return "group " + c.name
+	}
+	return fmt.Sprintf("%T", c)
+}
@@ -0,0 +1,58 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type answerClient struct {
+	ip net.IP
+}
+
+func (c *answerClient) Exchange(m *D.Msg) (*D.Msg, error) {
+	return c.ExchangeContext(context.Background(), m)
+}
+
+func (c *answerClient) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	msg := (&D.Msg{}).SetReply(m)
+	msg.Answer = []D.RR{&D.A{
+		Hdr: D.RR_Header{Name: m.Question[0].Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: 300},
+		A:   c.ip,
+	}}
+	return msg, nil
+}
+
+func TestResolver_Reverse(t *testing.T) {
+	r := NewResolver(Config{Main: []NameServer{{Addr: "127.0.0.1:53"}}})
+	assert.Equal(t, "127.0.0.1:53", clientName(r.main[0]))
+
+	r.main = []dnsClient{&groupMember{dnsClient: &answerClient{ip: net.ParseIP("198.51.100.7")}, addr: "192.0.2.53:53"}}
+	_, err := r.Exchange((&D.Msg{}).SetQuestion("example.com.", D.TypeA))
+	require.NoError(t, err)
+
+	answers := r.Reverse(netip.MustParseAddr("198.51.100.7"))
+	require.Len(t, answers, 1)
+	assert.Equal(t, "example.com.", answers[0].Question)
+	assert.Equal(t, "A", answers[0].Type)
+	assert.Equal(t, "192.0.2.53:53", answers[0].Upstream)
+	assert.False(t, answers[0].Stale)
+	assert.InDelta(t, 300, answers[0].TTL, 1)
+	assert.Empty(t, r.Reverse(netip.MustParseAddr("198.51.100.8")))
+
+	// an answer in place of the cached one
+	r.main = []dnsClient{&groupMember{dnsClient: &answerClient{ip: net.ParseIP("198.51.100.8")}, addr: "192.0.2.53:53"}}
+	_, err = r.exchangeWithoutCache(context.Background(), (&D.Msg{}).SetQuestion("example.com.", D.TypeA))
+	require.NoError(t, err)
+	assert.Empty(t, r.Reverse(netip.MustParseAddr("198.51.100.7")))
+	assert.Len(t, r.Reverse(netip.MustParseAddr("198.51.100.8")), 1)
+
+	r.FlushCache()
+	assert.Empty(t, r.Reverse(netip.MustParseAddr("198.51.100.8")))
+	assert.Empty(t, r.index.byKey)
+}
@@ -3,6 +3,7 @@ package dns
 import (
 	"errors"
 	"net"
+	"net/netip"
 
 	"github.com/Dreamacro/clash/common/sockopt"
 	"github.com/Dreamacro/clash/context"
@@ -25,7 +26,14 @@ type Server struct {
 
 // ServeDNS implement D.Handler ServeDNS
 func (s *Server) ServeDNS(w D.ResponseWriter, r *D.Msg) {
-	msg, err := handlerWithContext(s.handler, r)
+	var client netip.Addr
+	if addr, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		client = addr.AddrPort().Addr().Unmap()
+	} else if addr, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		client = addr.AddrPort().Addr().Unmap()
+	}
+
+	msg, err := handlerWithContext(s.handler, r, client)
 	if err != nil {
 		D.HandleFailed(w, r)
 		return
@@ -34,15 +42,20 @@ func (s *Server) ServeDNS(w D.ResponseWriter, r *D.Msg) {
 	w.WriteMsg(msg)
 }
 
-func handlerWithContext(handler handler, msg *D.Msg) (*D.Msg, error) {
+func handlerWithContext(handler handler, msg *D.Msg, client netip.Addr) (*D.Msg, error) {
 	if len(msg.Question) == 0 {
 		return nil, errors.New("at least one question is required")
 	}
 
-	ctx := context.NewDNSContext(msg)
+	ctx := context.NewDNSContext(msg, client)
 	return handler(ctx, msg)
 }
 
+// Query answers msg the way the dns listeners would for a query from client
+func Query(resolver *Resolver, mapper *ResolverEnhancer, msg *D.Msg, client netip.Addr) (*D.Msg, error) {
+	return handlerWithContext(NewHandler(resolver, mapper), msg, client)
+}
+
 func (s *Server) SetHandler(handler handler) {
 	s.handler = handler
 }
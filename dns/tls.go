@@ -0,0 +1,70 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/Dreamacro/clash/component/clientcert"
+	"github.com/Dreamacro/clash/log"
+)
+
+var errPinMismatch = errors.New("certificate doesn't match the pinned public keys")
+
+// TLSOption verifies the certificate of tls:// and https:// nameservers
+type TLSOption struct {
+	// RootCAs replaces the system roots when not nil
+	RootCAs        *x509.CertPool
+	SkipCertVerify bool
+	// PinSHA256 are SHA-256 digests of the SubjectPublicKeyInfo, the leaf must match one
+	// of them in addition to the chain verification
+	PinSHA256  [][]byte
+	ClientCert *clientcert.Certificate
+}
+
+func (o TLSOption) config(serverName string) *tls.Config {
+	config := o.ClientCert.Apply(&tls.Config{
+		ServerName:         serverName,
+		RootCAs:            o.RootCAs,
+		InsecureSkipVerify: o.SkipCertVerify,
+	})
+
+	if len(o.PinSHA256) != 0 {
+		pins := o.PinSHA256
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no certificate presented")
+			}
+			leaf, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if bytes.Equal(pin, sum[:]) {
+					return nil
+				}
+			}
+			return fmt.Errorf("%w: %x", errPinMismatch, sum)
+		}
+	}
+
+	return config
+}
+
+// checkCertError logs certificate failures with the nameserver, the error would
+// otherwise be hidden behind the answer of another nameserver
+func checkCertError(server string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var verifyErr *tls.CertificateVerificationError
+	if errors.As(err, &verifyErr) || errors.Is(err, errPinMismatch) {
+		log.Warnln("[DNS] nameserver %s certificate verification failed: %s", server, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,39 @@
+package dns
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSOption_Pin(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	cert := server.Certificate()
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	pin := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	handshake := func(option TLSOption) error {
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), option.config("example.com"))
+		if err == nil {
+			conn.Close()
+		}
+		return checkCertError(server.Listener.Addr().String(), err)
+	}
+
+	assert.NotNil(t, handshake(TLSOption{}))
+	assert.Nil(t, handshake(TLSOption{RootCAs: pool}))
+	assert.Nil(t, handshake(TLSOption{RootCAs: pool, PinSHA256: [][]byte{pin[:]}}))
+
+	// a rotated key fails closed even when the chain is skipped
+	err := handshake(TLSOption{SkipCertVerify: true, PinSHA256: [][]byte{make([]byte, sha256.Size)}})
+	assert.True(t, errors.Is(err, errPinMismatch))
+}
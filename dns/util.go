@@ -2,7 +2,6 @@ package dns
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -33,11 +32,12 @@ func updateTTL(records []D.RR, ttl uint32) {
 	}
 }
 
-func putMsgToCache(c *cache.LruCache, key string, q D.Question, msg *D.Msg) {
+// putMsgToCache return false for an answer it doesn't cache
+func putMsgToCache(c *cache.LruCache, key string, q D.Question, msg *D.Msg) bool {
 	// skip dns cache for acme challenge
 	if q.Qtype == D.TypeTXT && strings.HasPrefix(q.Name, "_acme-challenge.") {
 		log.Debugln("[DNS] dns cache ignored because of acme challenge for: %s", q.Name)
-		return
+		return false
 	}
 
 	var ttl uint32
@@ -50,10 +50,11 @@ func putMsgToCache(c *cache.LruCache, key string, q D.Question, msg *D.Msg) {
 		ttl = minimalTTL(msg.Extra)
 	default:
 		log.Debugln("[DNS] response msg empty: %#v", msg)
-		return
+		return false
 	}
 
 	c.SetWithExpire(key, msg.Copy(), time.Now().Add(time.Second*time.Duration(ttl)))
+	return true
 }
 
 func setMsgTTL(msg *D.Msg, ttl uint32) {
@@ -85,7 +86,7 @@ func transform(servers []NameServer, resolver *Resolver) []dnsClient {
 	for _, s := range servers {
 		switch s.Net {
 		case "https":
-			ret = append(ret, newDoHClient(s.Addr, s.Interface, resolver))
+			ret = append(ret, newDoHClient(s.Addr, s.Interface, s.TLS, resolver))
 			continue
 		case "dhcp":
 			ret = append(ret, newDHCPClient(s.Addr))
@@ -95,12 +96,10 @@ func transform(servers []NameServer, resolver *Resolver) []dnsClient {
 		host, port, _ := net.SplitHostPort(s.Addr)
 		ret = append(ret, &client{
 			Client: &D.Client{
-				Net: s.Net,
-				TLSConfig: &tls.Config{
-					ServerName: host,
-				},
-				UDPSize: 4096,
-				Timeout: 5 * time.Second,
+				Net:       s.Net,
+				TLSConfig: s.TLS.config(host),
+				UDPSize:   4096,
+				Timeout:   5 * time.Second,
 			},
 			port:  port,
 			host:  host,
@@ -148,6 +147,7 @@ func batchExchange(ctx context.Context, clients []dnsClient, m *D.Msg) (msg *D.M
 			} else if m.Rcode == D.RcodeServerFailure || m.Rcode == D.RcodeRefused {
 				return nil, errors.New("server failure")
 			}
+			recordUpstream(ctx, m, r)
 			return m, nil
 		})
 	}
@@ -0,0 +1,69 @@
+package dns
+
+import (
+	"net/netip"
+
+	"github.com/Dreamacro/clash/component/trie"
+)
+
+// View overrides the dns behavior for queries whose source falls in Clients
+type View struct {
+	Name    string
+	Clients []netip.Prefix
+	// Hosts are looked up before the global hosts
+	Hosts *trie.DomainTrie
+	// FakeIP disables fake-ip for the view when false, nil inherits
+	FakeIP *bool
+	// Main replaces the nameservers when not empty
+	Main []NameServer
+	// Policy is merged over the global nameserver policy
+	Policy map[string]NameServer
+}
+
+type view struct {
+	clients  []netip.Prefix
+	hosts    *trie.DomainTrie
+	fakeIP   *bool
+	resolver *Resolver
+}
+
+// matchView return the index of the first view containing client, -1 if none
+func (r *Resolver) matchView(client netip.Addr) int {
+	if !client.IsValid() {
+		return -1
+	}
+
+	for idx, v := range r.views {
+		for _, prefix := range v.clients {
+			if prefix.Contains(client) {
+				return idx
+			}
+		}
+	}
+	return -1
+}
+
+func newViewResolver(base *Resolver, config Config, v View, defaultResolver *Resolver) *Resolver {
+	config.Views = nil
+	if len(v.Main) != 0 {
+		config.Main = v.Main
+	}
+	if len(v.Policy) != 0 {
+		policy := make(map[string]NameServer, len(config.Policy)+len(v.Policy))
+		for domain, nameserver := range config.Policy {
+			policy[domain] = nameserver
+		}
+		for domain, nameserver := range v.Policy {
+			policy[domain] = nameserver
+		}
+		config.Policy = policy
+	}
+
+	// the groups are shared so are their health and connections
+	r := newResolver(config, defaultResolver, base.groups)
+	// the cache is shared, the prefix keeps answers from leaking across views
+	r.lruCache = base.lruCache
+	r.index = base.index
+	r.cachePrefix = "view:" + v.Name + "|"
+	return r
+}
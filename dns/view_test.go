@@ -0,0 +1,46 @@
+package dns
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/Dreamacro/clash/component/trie"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestView_Hosts(t *testing.T) {
+	hosts := trie.New()
+	hosts.Insert("example.com", net.IP{1, 2, 3, 4})
+	guestHosts := trie.New()
+	guestHosts.Insert("example.com", net.IP{10, 0, 0, 1})
+
+	config := Config{
+		Main:  []NameServer{{Addr: "127.0.0.1:53"}},
+		Hosts: hosts,
+		Views: []View{{
+			Name:    "guest",
+			Clients: []netip.Prefix{netip.MustParsePrefix("192.168.2.0/24")},
+			Hosts:   guestHosts,
+		}},
+	}
+	r := NewResolver(config)
+	m := NewEnhancer(config)
+
+	query := func(client string) net.IP {
+		msg := &D.Msg{}
+		msg.SetQuestion("example.com.", D.TypeA)
+		resp, err := Query(r, m, msg, netip.MustParseAddr(client))
+		assert.Nil(t, err)
+		return resp.Answer[0].(*D.A).A
+	}
+
+	assert.Equal(t, net.IP{10, 0, 0, 1}, query("192.168.2.10").To4())
+	assert.Equal(t, net.IP{1, 2, 3, 4}, query("192.168.1.10").To4())
+
+	q := D.Question{Name: "example.com.", Qtype: D.TypeA, Qclass: D.ClassINET}
+	assert.NotEqual(t, r.cacheKey(q), r.views[0].resolver.cacheKey(q))
+	assert.Same(t, r.lruCache, r.views[0].resolver.lruCache)
+}
@@ -0,0 +1,127 @@
+// Package forward is an example of the proxy types and plugins added out of this repo. The
+// forward type sends every connection to its server, whatever the destination, and the xor
+// plugin masks the stream of a shadowsocks server with a key. A fork links them in with a
+// blank import in its main:
+//
+//	import _ "github.com/Dreamacro/clash/examples/forward"
+//
+// and configures them like the builtin ones:
+//
+//	proxies:
+//	  - name: office
+//	    type: forward
+//	    server: 10.0.0.1
+//	    port: 3128
+//	  - name: ss-xor
+//	    type: ss
+//	    server: server
+//	    port: 443
+//	    cipher: chacha20-ietf-poly1305
+//	    password: "password"
+//	    plugin: xor
+//	    plugin-opts:
+//	      key: secret
+package forward
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/Dreamacro/clash/adapter/outbound"
+	"github.com/Dreamacro/clash/component/dialer"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport"
+)
+
+// Forward is the type of the forward adapter
+var Forward = C.RegisterAdapterType("Forward")
+
+func init() {
+	outbound.RegisterAdapter("forward", parseForward)
+	transport.RegisterPlugin("xor", newXor)
+}
+
+type Option struct {
+	outbound.BasicOption
+	Name   string `proxy:"name"`
+	Server string `proxy:"server"`
+	Port   int    `proxy:"port"`
+}
+
+// Adapter forwards the connections to its server, it doesn't relay udp
+type Adapter struct {
+	*outbound.Base
+}
+
+func parseForward(mapping map[string]any) (C.ProxyAdapter, error) {
+	option := &Option{}
+	if err := outbound.DecodeOption(mapping, option); err != nil {
+		return nil, err
+	}
+	if option.Server == "" || option.Port == 0 {
+		return nil, errors.New("forward requires server and port")
+	}
+	return &Adapter{
+		Base: outbound.NewBase(outbound.BaseOption{
+			Name:        option.Name,
+			Addr:        net.JoinHostPort(option.Server, strconv.Itoa(option.Port)),
+			Type:        Forward,
+			Interface:   option.Interface,
+			RoutingMark: option.RoutingMark,
+		}),
+	}, nil
+}
+
+// DialContext implements C.ProxyAdapter
+func (a *Adapter) DialContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.Conn, error) {
+	c, err := dialer.DialContext(ctx, "tcp", a.Addr(), a.DialOptions(opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return outbound.NewConn(c, a), nil
+}
+
+// xor masks each byte of the stream with the key, it hides nothing from anyone looking
+type xor struct {
+	key []byte
+}
+
+func newXor(server string, opts map[string]any) (transport.Plugin, error) {
+	key, _ := opts["key"].(string)
+	if key == "" {
+		return nil, errors.New("xor requires a key")
+	}
+	return &xor{key: []byte(key)}, nil
+}
+
+// StreamConn implements transport.Plugin
+func (x *xor) StreamConn(c net.Conn) (net.Conn, error) {
+	return &xorConn{Conn: c, key: x.key}, nil
+}
+
+type xorConn struct {
+	net.Conn
+	key         []byte
+	read, wrote int
+}
+
+func (c *xorConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	for i := 0; i < n; i++ {
+		b[i] ^= c.key[c.read%len(c.key)]
+		c.read++
+	}
+	return n, err
+}
+
+func (c *xorConn) Write(b []byte) (int, error) {
+	masked := make([]byte, len(b))
+	for i := range b {
+		masked[i] = b[i] ^ c.key[(c.wrote+i)%len(c.key)]
+	}
+	n, err := c.Conn.Write(masked)
+	c.wrote += n
+	return n, err
+}
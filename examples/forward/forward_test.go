@@ -0,0 +1,120 @@
+package forward
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/Dreamacro/clash/config"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoServer(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestRegistered(t *testing.T) {
+	port := echoServer(t)
+	cfg, err := config.Parse([]byte(`
+proxies:
+  - name: office
+    type: forward
+    server: 127.0.0.1
+    port: ` + strconv.Itoa(port) + `
+  - name: ss-xor
+    type: ss
+    server: 127.0.0.1
+    port: 8388
+    cipher: aes-128-gcm
+    password: password
+    plugin: xor
+    plugin-opts:
+      key: secret
+proxy-groups:
+  - name: select
+    type: select
+    proxies: [office, ss-xor]
+`))
+	require.NoError(t, err)
+
+	office := cfg.Proxies["office"]
+	require.NotNil(t, office)
+	assert.Equal(t, Forward, office.Type())
+	assert.Equal(t, "Forward", office.Type().String())
+
+	// the proxies api
+	buf, err := json.Marshal(office)
+	require.NoError(t, err)
+	var proxy map[string]any
+	require.NoError(t, json.Unmarshal(buf, &proxy))
+	assert.Equal(t, "Forward", proxy["type"])
+
+	// the group has it and goes through it
+	buf, err = json.Marshal(cfg.Proxies["select"])
+	require.NoError(t, err)
+	var group map[string]any
+	require.NoError(t, json.Unmarshal(buf, &group))
+	assert.Equal(t, []any{"office", "ss-xor"}, group["all"])
+	assert.Equal(t, "office", group["now"])
+
+	conn, err := cfg.Proxies["select"].DialContext(context.Background(), &C.Metadata{
+		NetWork: C.TCP, Host: "example.com", DstPort: "80",
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, []string{"office", "select"}, []string(conn.Chains()))
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(reply))
+
+	_, err = config.Parse([]byte("proxies:\n  - {name: office, type: forward, server: 127.0.0.1, port: 0}\n"))
+	assert.ErrorContains(t, err, "forward requires server and port")
+	_, err = config.Parse([]byte("proxies:\n  - {name: ss, type: ss, server: 127.0.0.1, port: 8388, cipher: aes-128-gcm, password: p, plugin: xor}\n"))
+	assert.ErrorContains(t, err, "xor requires a key")
+}
+
+func TestXor(t *testing.T) {
+	plugin, err := newXor("127.0.0.1:8388", map[string]any{"key": "ab"})
+	require.NoError(t, err)
+	client, server := net.Pipe()
+	defer server.Close()
+	c, err := plugin.StreamConn(client)
+	require.NoError(t, err)
+	defer c.Close()
+
+	go c.Write([]byte("abc"))
+	raw := make([]byte, 3)
+	_, err = io.ReadFull(server, raw)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 'c' ^ 'a'}, raw)
+
+	go server.Write(raw)
+	plain := make([]byte, 3)
+	_, err = io.ReadFull(c, plain)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(plain))
+}
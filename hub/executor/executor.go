@@ -1,18 +1,30 @@
 package executor
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/Dreamacro/clash/adapter"
+	"github.com/Dreamacro/clash/adapter/inbound"
 	"github.com/Dreamacro/clash/adapter/outboundgroup"
 	"github.com/Dreamacro/clash/component/auth"
 	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/fetch"
+	"github.com/Dreamacro/clash/component/hook"
 	"github.com/Dreamacro/clash/component/iface"
+	"github.com/Dreamacro/clash/component/ntp"
+	"github.com/Dreamacro/clash/component/pac"
+	"github.com/Dreamacro/clash/component/pathmtu"
+	"github.com/Dreamacro/clash/component/power"
+	"github.com/Dreamacro/clash/component/probeserver"
 	"github.com/Dreamacro/clash/component/profile"
 	"github.com/Dreamacro/clash/component/profile/cachefile"
 	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/component/stun"
 	"github.com/Dreamacro/clash/component/trie"
 	"github.com/Dreamacro/clash/config"
 	C "github.com/Dreamacro/clash/constant"
@@ -20,13 +32,37 @@ import (
 	"github.com/Dreamacro/clash/dns"
 	"github.com/Dreamacro/clash/listener"
 	authStore "github.com/Dreamacro/clash/listener/auth"
+	"github.com/Dreamacro/clash/listener/socks"
 	"github.com/Dreamacro/clash/log"
+	R "github.com/Dreamacro/clash/rule"
 	"github.com/Dreamacro/clash/tunnel"
+	"github.com/Dreamacro/clash/tunnel/statistic"
+
+	"github.com/samber/lo"
+	"go.uber.org/atomic"
 )
 
-var mux sync.Mutex
+var (
+	mux sync.Mutex
+
+	// the cache key of the dns section applied last
+	dnsCacheKey string
 
+	// the secure-defaults and insecure-allow-open of the config applied last, for the patches
+	// of the listeners
+	secureDefaults    atomic.Bool
+	insecureAllowOpen atomic.Bool
+)
+
+// readConfig reads the config at path, the one read from stdin at startup for config.StdinPath
 func readConfig(path string) ([]byte, error) {
+	if path == config.StdinPath {
+		if data := config.Stdin(); len(data) != 0 {
+			return data, nil
+		}
+		return nil, errors.New("configuration from stdin is empty")
+	}
+
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return nil, err
 	}
@@ -62,20 +98,78 @@ func ParseWithBytes(buf []byte) (*config.Config, error) {
 	return config.Parse(buf)
 }
 
-// ApplyConfig dispatch configure to all parts
-func ApplyConfig(cfg *config.Config, force bool) {
+// ParsePayload config sent to the api, see config.ParsePayload
+func ParsePayload(buf []byte) (*config.Config, error) {
+	return config.ParsePayload(buf)
+}
+
+// ApplyConfig dispatch configure to all parts, the error reports
+// the listeners which kept their old address
+func ApplyConfig(cfg *config.Config, force bool) error {
 	mux.Lock()
 	defer mux.Unlock()
 
 	updateUsers(cfg.Users)
+	inbound.SetCapabilities(cfg.Capabilities)
+	statistic.SetClosePolicy(cfg.General.ClosePolicy)
 	updateProxies(cfg.Proxies, cfg.Providers)
-	updateRules(cfg.Rules)
+	updateProfileOverlays(cfg)
+	tunnel.UpdateProviderFallback(cfg.ProviderFallback)
+	tunnel.UpdateRewrites(cfg.Rewrites)
+	tunnel.UpdateHostMismatch(cfg.HostMismatch)
+	stun.SetServers(cfg.STUNServers)
+	pac.Update(cfg.SystemProxyBypass)
 	updateHosts(cfg.Hosts)
 	updateProfile(cfg)
-	updateGeneral(cfg.General, force)
+	err := updateGeneral(cfg.General, force)
 	updateDNS(cfg.DNS)
+	updateNTP(cfg.NTP)
+	UpdatePowerSave(cfg.PowerSave)
+	tunnel.UpdateDialPool(cfg.DialPool.Size, time.Duration(cfg.DialPool.QueueTimeout)*time.Second)
+	tunnel.UpdateUDPPortReuse(cfg.UDPPortReuse.Enable, time.Duration(cfg.UDPPortReuse.Idle)*time.Second)
+	updateHooks(cfg.Hooks)
+	updateAccessLog(cfg.AccessLog)
+	updateLogDedup(cfg.LogDedup)
+	socks.SetBind(time.Duration(cfg.SocksBind.Timeout)*time.Second, cfg.SocksBind.AnyPeer)
 	updateExperimental(cfg)
-	updateTunnels(cfg.Tunnels)
+	checkTunMTU()
+	return errors.Join(err, updateTunnels(cfg.Tunnels), updateQUICListeners(cfg.QUICListeners))
+}
+
+// TunMTU checks the mtu of the running tun against the proxies and the uplink
+func TunMTU() (pathmtu.Report, bool) {
+	name, mtu, ok := listener.TunDevice()
+	if !ok {
+		return pathmtu.Report{}, false
+	}
+
+	seen := map[string]bool{}
+	proxies := []C.Proxy{}
+	add := func(proxy C.Proxy) {
+		if !seen[proxy.Name()] {
+			seen[proxy.Name()] = true
+			proxies = append(proxies, proxy)
+		}
+	}
+	for _, proxy := range tunnel.Proxies() {
+		add(proxy)
+	}
+	for _, pd := range tunnel.Providers() {
+		for _, proxy := range pd.Proxies() {
+			add(proxy)
+		}
+	}
+	return pathmtu.Check(name, mtu, proxies), true
+}
+
+func checkTunMTU() {
+	report, ok := TunMTU()
+	if !ok {
+		return
+	}
+	for _, warning := range report.Warnings {
+		log.Warnln("[TUN] %s", warning)
+	}
 }
 
 func GetGeneral() *config.General {
@@ -95,11 +189,19 @@ func GetGeneral() *config.General {
 			Tun:            listener.Tun(),
 			Authentication: authenticator,
 			AllowLan:       listener.AllowLan(),
-			BindAddress:    listener.BindAddress(),
+			BindAddress:    listener.BindAddresses(),
+			BindFailure:    listener.BindFailure(),
 		},
 		Mode:     tunnel.Mode(),
 		LogLevel: log.Level(),
 		IPv6:     !resolver.DisableIPv6,
+
+		HealthCheckServer: probeserver.Snapshot().Address,
+
+		SecureDefaults:    secureDefaults.Load(),
+		InsecureAllowOpen: insecureAllowOpen.Load(),
+
+		ActiveProfile: activeProfile.Load(),
 	}
 
 	return general
@@ -107,13 +209,91 @@ func GetGeneral() *config.General {
 
 func updateExperimental(c *config.Config) {
 	tunnel.UDPFallbackMatch.Store(c.Experimental.UDPFallbackMatch)
+	tunnel.UDPRematch.Store(c.Experimental.UDPRematch)
+	adapter.ImpairmentEnabled.Store(c.Experimental.Impairment)
+}
+
+// UpdatePowerSave applies the power save config, it's also used by PATCH /configs
+func UpdatePowerSave(c *config.PowerSave) {
+	power.Update(power.Option{
+		Enable:           c.Enable,
+		Idle:             time.Duration(c.Idle) * time.Minute,
+		SuspendKeepalive: c.SuspendKeepalive,
+	})
+}
+
+func updateAccessLog(c *config.AccessLog) {
+	if c.Path == "" {
+		statistic.UpdateAccessLog(nil)
+		return
+	}
+
+	err := statistic.UpdateAccessLog(&statistic.AccessLogOption{
+		Path:       c.Path,
+		Format:     c.Format,
+		MaxSize:    int64(c.MaxSize) << 20,
+		MaxBackups: c.MaxBackups,
+		Sample:     c.Sample,
+	})
+	if err != nil {
+		log.Errorln("Start access log error: %s", err)
+	}
+}
+
+func updateHooks(c *config.Hooks) {
+	hook.Update(&hook.Option{
+		Debounce: time.Duration(c.Debounce) * time.Second,
+		Down:     hook.Action{URL: c.OnProxyDown.URL, Command: c.OnProxyDown.Command},
+		Up:       hook.Action{URL: c.OnProxyUp.URL, Command: c.OnProxyUp.Command},
+		Groups:   groupsOf,
+	})
+}
+
+// groupsOf return the names of the groups containing the proxy
+func groupsOf(name string) []string {
+	groups := []string{}
+	for groupName, proxy := range tunnel.Proxies() {
+		p, ok := proxy.(*adapter.Proxy)
+		if !ok {
+			continue
+		}
+		if members, ok := outboundgroup.Members(p.ProxyAdapter); ok && lo.Contains(members, name) {
+			groups = append(groups, groupName)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+func updateLogDedup(c *config.LogDedup) {
+	if !c.Enable {
+		log.SetDedup(0)
+		return
+	}
+	log.SetDedup(time.Duration(c.Window) * time.Second)
+}
+
+func updateNTP(c *config.NTP) {
+	if !c.Enable {
+		ntp.Start("", 0)
+		return
+	}
+
+	interval := time.Duration(c.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ntp.Start(c.Server, interval)
 }
 
 func updateDNS(c *config.DNS) {
 	if !c.Enable {
+		// lookups fall back to resolver.System
 		resolver.DefaultResolver = nil
 		resolver.DefaultHostMapper = nil
+		dnsCacheKey = ""
 		dns.ReCreateServer("", nil, nil)
+		listener.ResetDNSResolver(nil, nil)
 		return
 	}
 
@@ -133,6 +313,9 @@ func updateDNS(c *config.DNS) {
 		Default:       c.DefaultNameserver,
 		Policy:        c.NameServerPolicy,
 		SearchDomains: c.SearchDomains,
+		Views:         c.Views,
+		Groups:        c.NameServerGroups,
+		PTR:           c.PTR,
 	}
 
 	r := dns.NewResolver(cfg)
@@ -143,6 +326,16 @@ func updateDNS(c *config.DNS) {
 		m.PatchFrom(old.(*dns.ResolverEnhancer))
 	}
 
+	// the cached answers are kept unless the settings they depend on changed
+	if old, ok := resolver.DefaultResolver.(*dns.Resolver); ok {
+		if c.CacheKey == dnsCacheKey {
+			r.PatchFrom(old)
+		} else {
+			log.Infoln("[DNS] the dns section changed, %d cached answers flushed", old.FlushCache())
+		}
+	}
+	dnsCacheKey = c.CacheKey
+
 	resolver.DefaultResolver = r
 	resolver.DefaultHostMapper = m
 
@@ -155,47 +348,81 @@ func updateHosts(tree *trie.DomainTrie) {
 }
 
 func updateProxies(proxies map[string]C.Proxy, providers map[string]provider.ProxyProvider) {
+	// the connections keep the proxies of the previous config, those gone from the new one follow the close policy
+	removed := []string{}
+	dropped := []C.Proxy{}
+	for name, old := range tunnel.Proxies() {
+		proxy, ok := proxies[name]
+		if !ok {
+			removed = append(removed, name)
+		}
+		if proxy != old {
+			dropped = append(dropped, old)
+		}
+	}
+
 	tunnel.UpdateProxies(proxies, providers)
+	tunnel.FlushUDPPorts()
+	statistic.DefaultManager.CloseRemoved(removed, statistic.DefaultClosePolicy())
+
+	// the probe clients of the dropped proxies would keep their idle connections open
+	for _, proxy := range dropped {
+		if p, ok := proxy.(interface{ CloseIdleConnections() }); ok {
+			p.CloseIdleConnections()
+		}
+	}
 }
 
-func updateRules(rules []C.Rule) {
-	tunnel.UpdateRules(rules)
+func updateRules(rules []C.Rule, ruleProviders map[string]provider.RuleProvider, final string) {
+	tunnel.UpdateRules(rules, ruleProviders)
+	tunnel.UpdateFinal(final)
 }
 
-func updateTunnels(tunnels []config.Tunnel) {
-	listener.PatchTunnel(tunnels, tunnel.TCPIn(), tunnel.UDPIn())
+func updateTunnels(tunnels []config.Tunnel) error {
+	return listener.PatchTunnel(tunnels, tunnel.TCPIn(), tunnel.UDPIn())
 }
 
-func updateGeneral(general *config.General, force bool) {
+func updateQUICListeners(listeners []config.QUICListener) error {
+	return listener.PatchQUICListeners(listeners, tunnel.TCPIn(), tunnel.UDPIn())
+}
+
+func updateGeneral(general *config.General, force bool) error {
+	secureDefaults.Store(general.SecureDefaults)
+	insecureAllowOpen.Store(general.InsecureAllowOpen)
 	log.SetLevel(general.LogLevel)
-	tunnel.SetMode(general.Mode)
+	tunnel.SetMode(overlayMode(general.Mode))
 	resolver.DisableIPv6 = !general.IPv6
 
 	dialer.DefaultInterface.Store(general.Interface)
 	dialer.DefaultRoutingMark.Store(int32(general.RoutingMark))
+	fetch.SetProxy(general.FetchProxy)
+	R.SetTimeZone(general.TimeZone)
 
 	iface.FlushCache()
 
 	if !force {
-		return
+		return nil
 	}
 
 	allowLan := general.AllowLan
 	listener.SetAllowLan(allowLan)
 
-	bindAddress := general.BindAddress
-	listener.SetBindAddress(bindAddress)
+	listener.SetBindAddresses(general.BindAddress)
+	listener.SetBindFailure(general.BindFailure)
 
 	tcpIn := tunnel.TCPIn()
 	udpIn := tunnel.UDPIn()
 
-	listener.ReCreateHTTP(general.Port, tcpIn)
-	listener.ReCreateSocks(general.SocksPort, tcpIn, udpIn)
-	listener.ReCreateRedir(general.RedirPort, tcpIn, udpIn)
-	listener.ReCreateTProxy(general.TProxyPort, tcpIn, udpIn)
-	listener.ReCreateMixed(general.MixedPort, tcpIn, udpIn)
+	err := errors.Join(
+		listener.ReCreateHTTP(general.Port, tcpIn),
+		listener.ReCreateSocks(general.SocksPort, tcpIn, udpIn),
+		listener.ReCreateRedir(general.RedirPort, tcpIn, udpIn),
+		listener.ReCreateTProxy(general.TProxyPort, tcpIn, udpIn),
+		listener.ReCreateMixed(general.MixedPort, tcpIn, udpIn),
+		probeserver.Update(general.HealthCheckServer),
+	)
 	listener.ReCreateTun(general.Tun, tcpIn, udpIn)
-
+	return err
 }
 
 func updateUsers(users []auth.AuthUser) {
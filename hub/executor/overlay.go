@@ -0,0 +1,142 @@
+package executor
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/config"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/constant/provider"
+	"github.com/Dreamacro/clash/log"
+	"github.com/Dreamacro/clash/tunnel"
+
+	"go.uber.org/atomic"
+)
+
+// ErrProfileNotFound is returned activating a profile missing from the config
+var ErrProfileNotFound = errors.New("profile not found")
+
+var (
+	// the rules of the config applied last and its profile overlays, guarded by mux
+	baseRules     []C.Rule
+	baseFinal     string
+	baseMode      tunnel.TunnelMode
+	ruleProviders map[string]provider.RuleProvider
+	overlays      []*config.ProfileOverlay
+	// the overlay in use and the one the schedule picked last, nil is the config itself
+	activeOverlay    *config.ProfileOverlay
+	scheduledOverlay *config.ProfileOverlay
+
+	activeProfile = atomic.NewString(config.DefaultProfileOverlay)
+	scheduleOnce  sync.Once
+)
+
+// updateProfileOverlays applies the rules of the overlay scheduled now, or the ones of
+// the config, a reload drops the profile activated by hand
+func updateProfileOverlays(cfg *config.Config) {
+	baseRules, baseFinal, baseMode = cfg.Rules, cfg.Final, cfg.General.Mode
+	ruleProviders = cfg.RuleProviders
+	overlays = cfg.ProfileOverlays
+
+	activeOverlay = nil
+	scheduledOverlay = pickScheduled()
+	applyOverlay(scheduledOverlay)
+
+	if len(overlays) != 0 {
+		scheduleOnce.Do(func() { go runSchedule() })
+	}
+}
+
+// overlayMode is the mode of the overlay in use, or the mode of the config
+func overlayMode(mode tunnel.TunnelMode) tunnel.TunnelMode {
+	if activeOverlay != nil && activeOverlay.Mode != nil {
+		return *activeOverlay.Mode
+	}
+	return mode
+}
+
+func pickScheduled() *config.ProfileOverlay {
+	for _, overlay := range overlays {
+		if overlay.Scheduled() {
+			return overlay
+		}
+	}
+	return nil
+}
+
+// applyOverlay swaps the rules like a rules-only reload, the mode of the config comes back
+// only when the previous overlay has replaced it
+func applyOverlay(overlay *config.ProfileOverlay) {
+	rules := baseRules
+	if overlay != nil && overlay.Rules != nil {
+		rules = overlay.Rules
+	}
+	updateRules(rules, ruleProviders, baseFinal)
+
+	switch {
+	case overlay != nil && overlay.Mode != nil:
+		tunnel.SetMode(*overlay.Mode)
+	case activeOverlay != nil && activeOverlay.Mode != nil:
+		tunnel.SetMode(baseMode)
+	}
+
+	activeOverlay = overlay
+	activeProfile.Store(overlayName(overlay))
+}
+
+func overlayName(overlay *config.ProfileOverlay) string {
+	if overlay == nil {
+		return config.DefaultProfileOverlay
+	}
+	return overlay.Name
+}
+
+// runSchedule checks the schedule at the start of every minute, a profile activated by
+// hand holds until the schedule picks another one
+func runSchedule() {
+	for {
+		now := time.Now()
+		time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+
+		mux.Lock()
+		if next := pickScheduled(); next != scheduledOverlay {
+			scheduledOverlay = next
+			if next != activeOverlay {
+				log.Infoln("[Profile] %s scheduled", overlayName(next))
+				applyOverlay(next)
+			}
+		}
+		mux.Unlock()
+	}
+}
+
+// ActivateProfile switches to the named profile overlay, default switches back to the rules
+// and the mode of the config
+func ActivateProfile(name string) error {
+	mux.Lock()
+	defer mux.Unlock()
+
+	var overlay *config.ProfileOverlay
+	if name != config.DefaultProfileOverlay {
+		for _, o := range overlays {
+			if o.Name == name {
+				overlay = o
+			}
+		}
+		if overlay == nil {
+			return ErrProfileNotFound
+		}
+	}
+
+	log.Infoln("[Profile] %s activated", name)
+	applyOverlay(overlay)
+	return nil
+}
+
+// ProfileOverlays return the profile overlays of the config and the name of the one in use
+func ProfileOverlays() ([]*config.ProfileOverlay, string) {
+	mux.Lock()
+	defer mux.Unlock()
+	return overlays, activeProfile.Load()
+}
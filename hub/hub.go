@@ -37,6 +37,12 @@ func Parse(options ...Option) error {
 		option(cfg)
 	}
 
+	// the controller of the command line is checked too
+	if err := config.SecureController(cfg); err != nil {
+		return err
+	}
+	config.LogExposure(cfg)
+
 	if cfg.General.ExternalUI != "" {
 		route.SetUIPath(cfg.General.ExternalUI)
 	}
@@ -45,6 +51,9 @@ func Parse(options ...Option) error {
 		go route.Start(cfg.General.ExternalController, cfg.General.Secret)
 	}
 
-	executor.ApplyConfig(cfg, true)
+	// the listeners failed to bind are only logged unless bind-failure is fatal
+	if err := executor.ApplyConfig(cfg, true); err != nil && cfg.General.BindFailure == config.BindFailureFatal {
+		return err
+	}
 	return nil
 }
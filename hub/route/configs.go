@@ -1,9 +1,13 @@
 package route
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"path/filepath"
 
+	"github.com/Dreamacro/clash/component/daemon"
+	"github.com/Dreamacro/clash/component/power"
 	"github.com/Dreamacro/clash/component/resolver"
 	"github.com/Dreamacro/clash/config"
 	"github.com/Dreamacro/clash/constant"
@@ -39,17 +43,19 @@ func pointerOrDefault(p *int, def int) int {
 
 func patchConfigs(w http.ResponseWriter, r *http.Request) {
 	general := struct {
-		Port        *int               `json:"port"`
-		SocksPort   *int               `json:"socks-port"`
-		RedirPort   *int               `json:"redir-port"`
-		TProxyPort  *int               `json:"tproxy-port"`
-		MixedPort   *int               `json:"mixed-port"`
-		Tun         *config.Tun        `json:"tun"`
-		AllowLan    *bool              `json:"allow-lan"`
-		BindAddress *string            `json:"bind-address"`
-		Mode        *tunnel.TunnelMode `json:"mode"`
-		LogLevel    *log.LogLevel      `json:"log-level"`
-		IPv6        *bool              `json:"ipv6"`
+		Port        *int                `json:"port"`
+		SocksPort   *int                `json:"socks-port"`
+		RedirPort   *int                `json:"redir-port"`
+		TProxyPort  *int                `json:"tproxy-port"`
+		MixedPort   *int                `json:"mixed-port"`
+		Tun         *config.Tun         `json:"tun"`
+		AllowLan    *bool               `json:"allow-lan"`
+		BindAddress *config.AddressList `json:"bind-address"`
+		BindFailure *string             `json:"bind-failure"`
+		Mode        *tunnel.TunnelMode  `json:"mode"`
+		LogLevel    *log.LogLevel       `json:"log-level"`
+		IPv6        *bool               `json:"ipv6"`
+		PowerSave   json.RawMessage     `json:"power-save"`
 	}{}
 	if err := render.DecodeJSON(r.Body, &general); err != nil {
 		render.Status(r, http.StatusBadRequest)
@@ -57,12 +63,60 @@ func patchConfigs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if p := general.BindFailure; p != nil && *p != config.BindFailureFatal && *p != config.BindFailureWarn {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError("invalid bind-failure policy "+*p))
+		return
+	}
+
+	// the fields missing from power-save keep their current value
+	var powerSave *config.PowerSave
+	if general.PowerSave != nil {
+		state := power.Snapshot()
+		powerSave = &config.PowerSave{Enable: state.Enable, Idle: state.Idle, SuspendKeepalive: state.SuspendKeepalive}
+		if err := json.Unmarshal(general.PowerSave, powerSave); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, ErrBadRequest)
+			return
+		}
+		if powerSave.Idle <= 0 {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, newError("invalid power-save idle"))
+			return
+		}
+	}
+
+	// the listeners opened to other hosts follow secure-defaults of the config
+	proposal := executor.GetGeneral()
+	if general.AllowLan != nil {
+		proposal.AllowLan = *general.AllowLan
+	}
+	if general.BindAddress != nil {
+		proposal.BindAddress = *general.BindAddress
+	}
+	proposal.Port = pointerOrDefault(general.Port, proposal.Port)
+	proposal.SocksPort = pointerOrDefault(general.SocksPort, proposal.SocksPort)
+	proposal.RedirPort = pointerOrDefault(general.RedirPort, proposal.RedirPort)
+	proposal.TProxyPort = pointerOrDefault(general.TProxyPort, proposal.TProxyPort)
+	proposal.MixedPort = pointerOrDefault(general.MixedPort, proposal.MixedPort)
+	// the health check server isn't patched
+	proposal.HealthCheckServer = ""
+	if err := config.CheckSecureDefaults(&config.Config{General: proposal}); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+
 	if general.AllowLan != nil {
 		P.SetAllowLan(*general.AllowLan)
 	}
 
 	if general.BindAddress != nil {
-		P.SetBindAddress(*general.BindAddress)
+		P.SetBindAddresses(*general.BindAddress)
+	}
+
+	if general.BindFailure != nil {
+		P.SetBindFailure(*general.BindFailure)
 	}
 
 	ports := P.GetPorts()
@@ -70,11 +124,13 @@ func patchConfigs(w http.ResponseWriter, r *http.Request) {
 	tcpIn := tunnel.TCPIn()
 	udpIn := tunnel.UDPIn()
 
-	P.ReCreateHTTP(pointerOrDefault(general.Port, ports.Port), tcpIn)
-	P.ReCreateSocks(pointerOrDefault(general.SocksPort, ports.SocksPort), tcpIn, udpIn)
-	P.ReCreateRedir(pointerOrDefault(general.RedirPort, ports.RedirPort), tcpIn, udpIn)
-	P.ReCreateTProxy(pointerOrDefault(general.TProxyPort, ports.TProxyPort), tcpIn, udpIn)
-	P.ReCreateMixed(pointerOrDefault(general.MixedPort, ports.MixedPort), tcpIn, udpIn)
+	listenerErr := errors.Join(
+		P.ReCreateHTTP(pointerOrDefault(general.Port, ports.Port), tcpIn),
+		P.ReCreateSocks(pointerOrDefault(general.SocksPort, ports.SocksPort), tcpIn, udpIn),
+		P.ReCreateRedir(pointerOrDefault(general.RedirPort, ports.RedirPort), tcpIn, udpIn),
+		P.ReCreateTProxy(pointerOrDefault(general.TProxyPort, ports.TProxyPort), tcpIn, udpIn),
+		P.ReCreateMixed(pointerOrDefault(general.MixedPort, ports.MixedPort), tcpIn, udpIn),
+	)
 
 	if general.Tun != nil {
 		P.ReCreateTun(*general.Tun, tcpIn, udpIn)
@@ -92,6 +148,16 @@ func patchConfigs(w http.ResponseWriter, r *http.Request) {
 		resolver.DisableIPv6 = !*general.IPv6
 	}
 
+	if powerSave != nil {
+		executor.UpdatePowerSave(powerSave)
+	}
+
+	if listenerErr != nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, newError(listenerErr.Error()))
+		return
+	}
+
 	render.NoContent(w, r)
 }
 
@@ -111,7 +177,7 @@ func updateConfigs(w http.ResponseWriter, r *http.Request) {
 	var err error
 
 	if req.Payload != "" {
-		cfg, err = executor.ParseWithBytes([]byte(req.Payload))
+		cfg, err = executor.ParsePayload([]byte(req.Payload))
 		if err != nil {
 			render.Status(r, http.StatusBadRequest)
 			render.JSON(w, r, newError(err.Error()))
@@ -121,7 +187,7 @@ func updateConfigs(w http.ResponseWriter, r *http.Request) {
 		if req.Path == "" {
 			req.Path = constant.Path.Config()
 		}
-		if !filepath.IsAbs(req.Path) {
+		if req.Path != config.StdinPath && !filepath.IsAbs(req.Path) {
 			render.Status(r, http.StatusBadRequest)
 			render.JSON(w, r, newError("path is not a absolute path"))
 			return
@@ -135,6 +201,14 @@ func updateConfigs(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	executor.ApplyConfig(cfg, force)
+	// the rest of the config is applied, the listeners failed to rebind keep the old address
+	daemon.Reloading()
+	err = executor.ApplyConfig(cfg, force)
+	daemon.Reloaded(err)
+	if err != nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
 	render.NoContent(w, r)
 }
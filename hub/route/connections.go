@@ -76,6 +76,7 @@ func closeConnection(w http.ResponseWriter, r *http.Request) {
 	snapshot := statistic.DefaultManager.Snapshot()
 	for _, c := range snapshot.Connections {
 		if id == c.ID() {
+			c.SetCloseReason(statistic.CloseReasonAPI)
 			c.Close()
 			break
 		}
@@ -86,6 +87,7 @@ func closeConnection(w http.ResponseWriter, r *http.Request) {
 func closeAllConnections(w http.ResponseWriter, r *http.Request) {
 	snapshot := statistic.DefaultManager.Snapshot()
 	for _, c := range snapshot.Connections {
+		c.SetCloseReason(statistic.CloseReasonAPI)
 		c.Close()
 	}
 	render.NoContent(w, r)
@@ -0,0 +1,149 @@
+package route
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter"
+	"github.com/Dreamacro/clash/log"
+	"github.com/Dreamacro/clash/tunnel"
+	"github.com/Dreamacro/clash/tunnel/statistic"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+func debugRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Post("/capture", startCapture)
+	r.Get("/capture/{id}", getCapture)
+	r.Delete("/capture/{id}", removeCapture)
+	r.Get("/udp-ports", getUDPPorts)
+	r.Route("/impair/{name}", func(r chi.Router) {
+		r.Use(impairmentEnabled, parseProxyName, findProxyByName)
+		r.Post("/", impairProxy)
+		r.Delete("/", clearImpairment)
+	})
+	return r
+}
+
+// impairmentEnabled keeps /debug/impair off unless experimental.impairment is set
+func impairmentEnabled(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adapter.ImpairmentEnabled.Load() {
+			render.Status(r, http.StatusForbidden)
+			render.JSON(w, r, newError("impairment is off, see experimental.impairment"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func impairProxy(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		LatencyMs   int64 `json:"latencyMs"`
+		LossPercent int   `json:"lossPercent"`
+		Duration    int   `json:"duration"` // seconds
+	}{}
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+
+	proxy := r.Context().Value(CtxKeyProxy).(*adapter.Proxy)
+	impairment, err := proxy.Impair(time.Duration(req.LatencyMs)*time.Millisecond, req.LossPercent, time.Duration(req.Duration)*time.Second)
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+	log.Warnln("[Impair] %s: latency %dms, loss %d%% until %s", proxy.Name(), impairment.LatencyMs, impairment.LossPercent, impairment.Until.Format(time.RFC3339))
+	render.JSON(w, r, impairment)
+}
+
+func clearImpairment(w http.ResponseWriter, r *http.Request) {
+	proxy := r.Context().Value(CtxKeyProxy).(*adapter.Proxy)
+	if !proxy.ClearImpairment() {
+		render.Status(r, http.StatusNotFound)
+		render.JSON(w, r, ErrNotFound)
+		return
+	}
+	log.Infoln("[Impair] %s: cleared", proxy.Name())
+	render.NoContent(w, r)
+}
+
+func getUDPPorts(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, render.M{"ports": tunnel.GetUDPPorts()})
+}
+
+func startCapture(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		ConnectionID string `json:"connectionId"`
+		Filter       string `json:"filter"`
+		MaxBytes     int64  `json:"maxBytes"`
+		Duration     int    `json:"duration"` // seconds
+	}{}
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+
+	c, err := statistic.DefaultManager.StartCapture(statistic.CaptureOption{
+		ConnectionID: req.ConnectionID,
+		Filter:       req.Filter,
+		MaxBytes:     req.MaxBytes,
+		Duration:     time.Duration(req.Duration) * time.Second,
+	})
+	if err != nil {
+		if errors.Is(err, statistic.ErrCaptureNoMatch) {
+			render.Status(r, http.StatusNotFound)
+		} else {
+			render.Status(r, http.StatusBadRequest)
+		}
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+
+	render.JSON(w, r, c)
+}
+
+// getCapture returns the capture state, or the pcap-ng file once it is complete
+// when requested with ?download=true
+func getCapture(w http.ResponseWriter, r *http.Request) {
+	c, exist := statistic.DefaultManager.Capture(chi.URLParam(r, "id"))
+	if !exist {
+		render.Status(r, http.StatusNotFound)
+		render.JSON(w, r, ErrNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("download") != "true" {
+		render.JSON(w, r, c)
+		return
+	}
+
+	select {
+	case <-c.Done():
+	default:
+		render.Status(r, http.StatusConflict)
+		render.JSON(w, r, newError("capture is still running"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pcapng")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+c.ID()+".pcapng\"")
+	http.ServeFile(w, r, c.Path())
+}
+
+func removeCapture(w http.ResponseWriter, r *http.Request) {
+	if !statistic.DefaultManager.RemoveCapture(chi.URLParam(r, "id")) {
+		render.Status(r, http.StatusNotFound)
+		render.JSON(w, r, ErrNotFound)
+		return
+	}
+
+	render.NoContent(w, r)
+}
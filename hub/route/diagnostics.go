@@ -0,0 +1,19 @@
+package route
+
+import (
+	"net/http"
+
+	"github.com/Dreamacro/clash/tunnel"
+
+	"github.com/go-chi/render"
+)
+
+// getDiagnostics sums up the latencies of the tunnel, measured in a build with the metrics tag
+func getDiagnostics(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, render.M{
+		"latency": render.M{
+			"enabled":   tunnel.LatencyMetrics,
+			"summaries": tunnel.LatencySummaries(),
+		},
+	})
+}
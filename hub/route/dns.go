@@ -2,10 +2,15 @@ package route
 
 import (
 	"context"
+	"errors"
 	"math"
 	"net/http"
+	"net/netip"
 
+	"github.com/Dreamacro/clash/component/fakeip"
 	"github.com/Dreamacro/clash/component/resolver"
+	clashdns "github.com/Dreamacro/clash/dns"
+	"github.com/Dreamacro/clash/log"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
@@ -16,9 +21,141 @@ import (
 func dnsRouter() http.Handler {
 	r := chi.NewRouter()
 	r.Get("/query", queryDNS)
+	r.Get("/groups", getDNSGroups)
+	r.Get("/fakeip", getFakeIP)
+	r.Get("/reverse", reverseDNS)
+	r.Post("/flush", flushDNS)
+	r.Post("/fakeip/flush", flushFakeIP)
 	return r
 }
 
+func flushDNS(w http.ResponseWriter, r *http.Request) {
+	dr, ok := resolver.DefaultResolver.(*clashdns.Resolver)
+	if !ok {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError("DNS section is disabled"))
+		return
+	}
+
+	evicted := dr.FlushCache()
+	log.Infoln("[DNS] %d cached answers flushed", evicted)
+	render.JSON(w, r, render.M{
+		"evicted": evicted,
+	})
+}
+
+// flushFakeIP drops the mappings of the pool, the connections to a flushed fake ip
+// are dropped as their host can't be found anymore
+func flushFakeIP(w http.ResponseWriter, r *http.Request) {
+	pool := fakeIPPool()
+	if pool == nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError("fake-ip is disabled"))
+		return
+	}
+
+	evicted := pool.Flush()
+	log.Warnln("[FakeIP] %d mappings flushed, new connections to their fake ips fail until the clients query again", evicted)
+	render.JSON(w, r, render.M{
+		"evicted": evicted,
+		"warning": "new connections to the flushed fake ips fail until the clients resolve them again",
+	})
+}
+
+// getFakeIP reports the usage of the fake ip pool, with the most recently
+// allocated mappings paged by offset and limit
+func getFakeIP(w http.ResponseWriter, r *http.Request) {
+	pool := fakeIPPool()
+	if pool == nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError("fake-ip is disabled"))
+		return
+	}
+
+	query := r.URL.Query()
+	offset, err := parseQueryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError("invalid offset"))
+		return
+	}
+	limit, err := parseQueryInt(query.Get("limit"), 100)
+	if err != nil || limit < 0 {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError("invalid limit"))
+		return
+	}
+	if limit > maxPayloadLimit {
+		limit = maxPayloadLimit
+	}
+
+	mappings, total := pool.Recent(offset, limit)
+	render.JSON(w, r, render.M{
+		"stats":    pool.Stats(),
+		"total":    total,
+		"offset":   offset,
+		"mappings": mappings,
+	})
+}
+
+// reverseDNS tells what ip stands for, the fake-ip mapping holding it or the cached
+// answers with it, 404 for neither
+func reverseDNS(w http.ResponseWriter, r *http.Request) {
+	ip, err := netip.ParseAddr(r.URL.Query().Get("ip"))
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError("invalid ip"))
+		return
+	}
+	ip = ip.Unmap()
+
+	inFakeIPRange := false
+	var fakeIP *fakeip.Allocation
+	if pool := fakeIPPool(); pool != nil {
+		inFakeIPRange = pool.IPNet().Contains(ip.AsSlice())
+		if allocation, ok := pool.Find(ip.AsSlice()); ok {
+			fakeIP = &allocation
+		}
+	}
+
+	answers := []clashdns.CachedAnswer{}
+	if dr, ok := resolver.DefaultResolver.(*clashdns.Resolver); ok {
+		answers = dr.Reverse(ip)
+	}
+
+	if fakeIP == nil && len(answers) == 0 {
+		render.Status(r, http.StatusNotFound)
+		render.JSON(w, r, render.M{
+			"message":       "no fake-ip mapping or cached answer holds " + ip.String(),
+			"inFakeIPRange": inFakeIPRange,
+		})
+		return
+	}
+
+	resp := render.M{
+		"ip":            ip.String(),
+		"inFakeIPRange": inFakeIPRange,
+		"answers":       answers,
+	}
+	if fakeIP != nil {
+		resp["fakeIP"] = fakeIP
+	}
+	render.JSON(w, r, resp)
+}
+
+func getDNSGroups(w http.ResponseWriter, r *http.Request) {
+	dr, ok := resolver.DefaultResolver.(*clashdns.Resolver)
+	if !ok {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, newError("DNS section is disabled"))
+		return
+	}
+
+	render.JSON(w, r, render.M{
+		"groups": dr.GroupStats(),
+	})
+}
+
 func queryDNS(w http.ResponseWriter, r *http.Request) {
 	if resolver.DefaultResolver == nil {
 		render.Status(r, http.StatusInternalServerError)
@@ -36,12 +173,32 @@ func queryDNS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var client netip.Addr
+	if clientStr := r.URL.Query().Get("client"); clientStr != "" {
+		addr, err := netip.ParseAddr(clientStr)
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, newError("invalid client address"))
+			return
+		}
+		client = addr.Unmap()
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), resolver.DefaultDNSTimeout)
 	defer cancel()
 
 	msg := dns.Msg{}
 	msg.SetQuestion(dns.Fqdn(name), qType)
-	resp, err := resolver.DefaultResolver.ExchangeContext(ctx, &msg)
+
+	var (
+		resp *dns.Msg
+		err  error
+	)
+	if client.IsValid() {
+		resp, err = queryAsClient(&msg, client)
+	} else {
+		resp, err = resolver.DefaultResolver.ExchangeContext(ctx, &msg)
+	}
 	if err != nil {
 		render.Status(r, http.StatusInternalServerError)
 		render.JSON(w, r, newError(err.Error()))
@@ -80,3 +237,17 @@ func queryDNS(w http.ResponseWriter, r *http.Request) {
 
 	render.JSON(w, r, responseData)
 }
+
+// queryAsClient answers like the dns listener would for client, with its view, hosts and fake-ip
+func queryAsClient(msg *dns.Msg, client netip.Addr) (*dns.Msg, error) {
+	r, ok := resolver.DefaultResolver.(*clashdns.Resolver)
+	if !ok {
+		return nil, errors.New("client is not supported by the current resolver")
+	}
+	mapper, _ := resolver.DefaultHostMapper.(*clashdns.ResolverEnhancer)
+	if mapper == nil {
+		mapper = clashdns.NewEnhancer(clashdns.Config{})
+	}
+
+	return clashdns.Query(r, mapper, msg, client)
+}
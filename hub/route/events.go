@@ -0,0 +1,97 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Dreamacro/clash/tunnel/statistic"
+
+	"github.com/Dreamacro/protobytes"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/gorilla/websocket"
+)
+
+func eventRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/connections", getConnectionEvents)
+	return r
+}
+
+// getConnectionEvents streams the snapshot of the connections then their create, update and
+// close events. The updates are sent every interval ms and skipped while the create and close
+// events are behind, a consumer too slow for those is disconnected.
+func getConnectionEvents(w http.ResponseWriter, r *http.Request) {
+	interval := 1000
+	if intervalStr := r.URL.Query().Get("interval"); intervalStr != "" {
+		t, err := strconv.Atoi(intervalStr)
+		if err != nil || t <= 0 {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, ErrBadRequest)
+			return
+		}
+		interval = t
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	events := statistic.DefaultManager.SubscribeConnections()
+	defer events.Close()
+
+	// a close from the client or a cut off subscription ends the blocked writes
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				events.Close()
+				return
+			}
+		}
+	}()
+	go func() {
+		<-events.Done()
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "events behind"), time.Now().Add(time.Second))
+		conn.Close()
+	}()
+
+	buf := protobytes.BytesWriter{}
+	send := func(event statistic.ConnectionEvent) error {
+		buf.Reset()
+		if err := json.NewEncoder(&buf).Encode(event); err != nil {
+			return err
+		}
+		return conn.WriteMessage(websocket.TextMessage, buf.Bytes())
+	}
+
+	if err := send(events.Snapshot()); err != nil {
+		return
+	}
+
+	tick := time.NewTicker(time.Millisecond * time.Duration(interval))
+	defer tick.Stop()
+	for {
+		var batch []statistic.ConnectionEvent
+		select {
+		case <-events.Done():
+			return
+		case <-events.Notify():
+			batch = events.Lifecycle()
+		case <-tick.C:
+			if events.Pending() > 0 {
+				continue
+			}
+			batch = events.Updates()
+		}
+		for _, event := range batch {
+			if err := send(event); err != nil {
+				return
+			}
+		}
+	}
+}
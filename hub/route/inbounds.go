@@ -0,0 +1,15 @@
+package route
+
+import (
+	"net/http"
+
+	P "github.com/Dreamacro/clash/listener"
+
+	"github.com/go-chi/render"
+)
+
+func getInbounds(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, render.M{
+		"inbounds": P.Inbounds(),
+	})
+}
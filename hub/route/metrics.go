@@ -0,0 +1,106 @@
+package route
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Dreamacro/clash/component/fakeip"
+	"github.com/Dreamacro/clash/component/resolver"
+	clashdns "github.com/Dreamacro/clash/dns"
+	"github.com/Dreamacro/clash/tunnel"
+)
+
+// getMetrics writes the metrics in the prometheus text format
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	if pool := fakeIPPool(); pool != nil {
+		writeFakeIPMetrics(w, pool.Stats())
+	}
+	writeDialPoolMetrics(w, tunnel.GetDialPoolStats())
+	writeHostMismatchMetrics(w, tunnel.GetHostMismatchStats())
+	writeLatencyMetrics(w, tunnel.LatencyHistograms())
+}
+
+// writeLatencyMetrics writes the latency histograms of a build with the metrics tag
+func writeLatencyMetrics(w io.Writer, histograms []tunnel.LatencyHistogram) {
+	if len(histograms) == 0 {
+		return
+	}
+	for _, m := range []struct {
+		stage, name, help string
+	}{
+		{tunnel.LatencyMatch, "clash_tunnel_match_seconds", "Time from the accept of a tcp connection to its rule match"},
+		{tunnel.LatencyDial, "clash_tunnel_dial_seconds", "Time from the rule match of a tcp connection to its dial through the proxy, the dial pool wait included"},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", m.name, m.help, m.name)
+		for _, h := range histograms {
+			if h.Stage != m.stage {
+				continue
+			}
+			labels := fmt.Sprintf("inbound=%q,proxy=%q", h.Inbound, h.Proxy)
+			var cumulative uint64
+			for i, bound := range h.Bounds {
+				cumulative += h.Counts[i]
+				fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", m.name, labels, bound, cumulative)
+			}
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", m.name, labels, h.Count)
+			fmt.Fprintf(w, "%s_sum{%s} %g\n%s_count{%s} %d\n", m.name, labels, h.Sum, m.name, labels, h.Count)
+		}
+	}
+}
+
+func writeHostMismatchMetrics(w io.Writer, stats tunnel.HostMismatchStats) {
+	for _, m := range []struct {
+		name, tp, help string
+		value          float64
+	}{
+		{"clash_host_mismatch_total", "counter", "Connections whose tls server name is not the requested host", float64(stats.Detected)},
+		{"clash_host_mismatch_rejected_total", "counter", "Connections rejected for a tls server name that is not the requested host", float64(stats.Rejected)},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", m.name, m.help, m.name, m.tp, m.name, m.value)
+	}
+}
+
+func writeDialPoolMetrics(w io.Writer, stats tunnel.DialPoolStats) {
+	for _, m := range []struct {
+		name, tp, help string
+		value          float64
+	}{
+		{"clash_dial_pool_size", "gauge", "Number of concurrent outbound dials allowed, 0 is unlimited", float64(stats.Size)},
+		{"clash_dial_pool_active", "gauge", "Number of outbound dials in progress", float64(stats.Active)},
+		{"clash_dial_pool_waiting", "gauge", "Number of connections waiting for a dial slot", float64(stats.Waiting)},
+		{"clash_dial_pool_rejected_total", "counter", "Connections rejected after the dial pool queue timeout", float64(stats.Rejected)},
+		{"clash_dial_pool_queue_seconds_total", "counter", "Time the connections waited for a dial slot", stats.QueueTime.Seconds()},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", m.name, m.help, m.name, m.tp, m.name, m.value)
+	}
+}
+
+func writeFakeIPMetrics(w io.Writer, stats fakeip.Stats) {
+	for _, m := range []struct {
+		name, tp, help string
+		value          float64
+	}{
+		{"clash_fakeip_pool_size", "gauge", "Number of addresses in the fake-ip range", float64(stats.Size)},
+		{"clash_fakeip_allocated", "gauge", "Number of tracked fake-ip mappings", float64(stats.Allocated)},
+		{"clash_fakeip_recycled_total", "counter", "Fake-ip mappings dropped to make room for another one", float64(stats.Recycled)},
+		{"clash_fakeip_recycle_rate", "gauge", "Fake-ip mappings recycled in the last complete minute", stats.RecycleRate},
+		{"clash_fakeip_lookup_hit_total", "counter", "Fake-ip lookups answered by an existing mapping", float64(stats.LookupHit)},
+		{"clash_fakeip_lookup_miss_total", "counter", "Fake-ip lookups that allocated a mapping", float64(stats.LookupMiss)},
+		{"clash_fakeip_lookback_hit_total", "counter", "Fake ips resolved back to their host", float64(stats.LookBackHit)},
+		{"clash_fakeip_lookback_miss_total", "counter", "Fake ips without a mapping", float64(stats.LookBackMiss)},
+		{"clash_fakeip_oldest_mapping_seconds", "gauge", "Age of the oldest tracked fake-ip mapping", stats.OldestMapping},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", m.name, m.help, m.name, m.tp, m.name, m.value)
+	}
+}
+
+func fakeIPPool() *fakeip.Pool {
+	mapper, ok := resolver.DefaultHostMapper.(*clashdns.ResolverEnhancer)
+	if !ok {
+		return nil
+	}
+	return mapper.FakeIPPool()
+}
@@ -0,0 +1,110 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/common/singledo"
+	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/component/stun"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/go-chi/render"
+)
+
+const (
+	// a result is shared by the requests for the same proxy in this window
+	natTestCacheTime      = 30 * time.Second
+	defaultNATTestTimeout = 10 * time.Second
+)
+
+var (
+	natTestsMux sync.Mutex
+	natTests    = map[string]*singledo.Single{}
+)
+
+func natTestOf(name string) *singledo.Single {
+	natTestsMux.Lock()
+	defer natTestsMux.Unlock()
+	single, ok := natTests[name]
+	if !ok {
+		single = singledo.NewSingle(natTestCacheTime)
+		natTests[name] = single
+	}
+	return single
+}
+
+func getProxyNATTest(w http.ResponseWriter, r *http.Request) {
+	proxy := r.Context().Value(CtxKeyProxy).(C.Proxy)
+	if !proxy.SupportUDP() {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(fmt.Sprintf("%s doesn't support udp, the nat test needs it", proxy.Name())))
+		return
+	}
+
+	timeout := defaultNATTestTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		ms, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || ms <= 0 {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, ErrBadRequest)
+			return
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+
+	result, err, _ := natTestOf(proxy.Name()).Do(func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return natTest(ctx, proxy)
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		render.Status(r, http.StatusGatewayTimeout)
+		render.JSON(w, r, ErrRequestTimeout)
+		return
+	}
+	if err != nil {
+		render.Status(r, http.StatusServiceUnavailable)
+		render.JSON(w, r, newError("nat test failed: "+err.Error()))
+		return
+	}
+	render.JSON(w, r, result)
+}
+
+// natTest runs the stun tests of the configured servers through a packet conn of proxy
+func natTest(ctx context.Context, proxy C.Proxy) (*stun.Result, error) {
+	var servers []*net.UDPAddr
+	for _, server := range stun.Servers() {
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			continue
+		}
+		ip, err := resolver.ResolveIP(host)
+		if err != nil {
+			continue
+		}
+		p, _ := strconv.Atoi(port)
+		servers = append(servers, &net.UDPAddr{IP: ip, Port: p})
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("none of the stun servers resolves")
+	}
+
+	metadata := &C.Metadata{
+		NetWork: C.UDP,
+		DstIP:   servers[0].IP,
+		DstPort: strconv.Itoa(servers[0].Port),
+	}
+	pc, err := proxy.ListenPacketContext(ctx, metadata)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+	return stun.Test(ctx, pc, servers)
+}
@@ -0,0 +1,33 @@
+package route
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/Dreamacro/clash/component/pac"
+	P "github.com/Dreamacro/clash/listener"
+)
+
+// getPAC serves the proxy auto-config of system-proxy-bypass without the secret, as the
+// clients fetching a pac can't send it. The proxy is the host the pac is fetched from.
+func getPAC(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	ports := P.GetPorts()
+	content, etag := pac.Render(pac.Proxy(host, ports.MixedPort, ports.Port, ports.SocksPort))
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Write(content)
+}
@@ -0,0 +1,13 @@
+package route
+
+import (
+	"net/http"
+
+	"github.com/Dreamacro/clash/component/power"
+
+	"github.com/go-chi/render"
+)
+
+func getPower(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, power.Snapshot())
+}
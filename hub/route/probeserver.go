@@ -0,0 +1,13 @@
+package route
+
+import (
+	"net/http"
+
+	"github.com/Dreamacro/clash/component/probeserver"
+
+	"github.com/go-chi/render"
+)
+
+func getProbeServer(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, probeserver.Snapshot())
+}
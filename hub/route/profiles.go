@@ -0,0 +1,66 @@
+package route
+
+import (
+	"net/http"
+
+	"github.com/Dreamacro/clash/config"
+	"github.com/Dreamacro/clash/hub/executor"
+	"github.com/Dreamacro/clash/tunnel"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+func profileRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", getProfiles)
+	r.Put("/{name}/activate", activateProfile)
+	return r
+}
+
+type ProfileOverlay struct {
+	Name     string             `json:"name"`
+	Schedule []string           `json:"schedule"`
+	Mode     *tunnel.TunnelMode `json:"mode,omitempty"`
+	// the number of rules of the overlay, nil keeps the rules of the config
+	Rules     *int `json:"rules,omitempty"`
+	Scheduled bool `json:"scheduled"`
+}
+
+func getProfiles(w http.ResponseWriter, r *http.Request) {
+	overlays, active := executor.ProfileOverlays()
+
+	profiles := []ProfileOverlay{}
+	for _, overlay := range overlays {
+		p := ProfileOverlay{
+			Name:      overlay.Name,
+			Schedule:  []string{},
+			Mode:      overlay.Mode,
+			Scheduled: overlay.Scheduled(),
+		}
+		for _, t := range overlay.Schedule {
+			p.Schedule = append(p.Schedule, t.Payload())
+		}
+		if overlay.Rules != nil {
+			rules := len(overlay.Rules)
+			p.Rules = &rules
+		}
+		profiles = append(profiles, p)
+	}
+
+	render.JSON(w, r, render.M{
+		"active":   active,
+		"default":  config.DefaultProfileOverlay,
+		"profiles": profiles,
+	})
+}
+
+func activateProfile(w http.ResponseWriter, r *http.Request) {
+	name := getEscapeParam(r, "name")
+	if err := executor.ActivateProfile(name); err != nil {
+		render.Status(r, http.StatusNotFound)
+		render.JSON(w, r, ErrNotFound)
+		return
+	}
+	render.NoContent(w, r)
+}
@@ -22,6 +22,7 @@ func proxyProviderRouter() http.Handler {
 		r.Get("/", getProvider)
 		r.Put("/", updateProvider)
 		r.Get("/healthcheck", healthCheckProvider)
+		r.Post("/unquarantine", unquarantineProvider)
 		r.Mount("/", proxyProviderProxyRouter())
 	})
 	return r
@@ -65,6 +66,18 @@ func healthCheckProvider(w http.ResponseWriter, r *http.Request) {
 	render.NoContent(w, r)
 }
 
+func unquarantineProvider(w http.ResponseWriter, r *http.Request) {
+	pd, ok := r.Context().Value(CtxKeyProvider).(provider.ProxyQuarantine)
+	if !ok {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError("provider does not support quarantine"))
+		return
+	}
+
+	pd.Unquarantine()
+	render.NoContent(w, r)
+}
+
 func parseProviderName(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		name := getEscapeParam(r, "providerName")
@@ -95,7 +108,12 @@ func findProviderProxyByName(next http.Handler) http.Handler {
 			name = r.Context().Value(CtxKeyProxyName).(string)
 			pd   = r.Context().Value(CtxKeyProvider).(provider.ProxyProvider)
 		)
-		proxy, exist := lo.Find(pd.Proxies(), func(proxy C.Proxy) bool {
+		// the quarantined proxies can still be looked at and tested
+		proxies := pd.Proxies()
+		if pq, ok := pd.(provider.ProxyQuarantine); ok {
+			proxies = pq.AllProxies()
+		}
+		proxy, exist := lo.Find(proxies, func(proxy C.Proxy) bool {
 			return proxy.Name() == name
 		})
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/Dreamacro/clash/adapter"
@@ -12,6 +13,7 @@ import (
 	"github.com/Dreamacro/clash/component/profile/cachefile"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/tunnel"
+	"github.com/Dreamacro/clash/tunnel/statistic"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
@@ -20,16 +22,21 @@ import (
 func proxyRouter() http.Handler {
 	r := chi.NewRouter()
 	r.Get("/", getProxies)
+	r.Put("/", updateProxies)
 
 	r.Route("/{name}", func(r chi.Router) {
 		r.Use(parseProxyName, findProxyByName)
 		r.Get("/", getProxy)
 		r.Get("/delay", getProxyDelay)
+		r.Get("/nat-test", getProxyNATTest)
 		r.Put("/", updateProxy)
 	})
 	return r
 }
 
+// selectMux keeps a batch update from interleaving with other selections
+var selectMux sync.Mutex
+
 func parseProxyName(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		name := getEscapeParam(r, "name")
@@ -76,6 +83,13 @@ func updateProxy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	policy, err := statistic.ParseClosePolicy(r.URL.Query().Get("breakConnections"))
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+
 	proxy := r.Context().Value(CtxKeyProxy).(*adapter.Proxy)
 	selector, ok := proxy.ProxyAdapter.(*outboundgroup.Selector)
 	if !ok {
@@ -84,6 +98,8 @@ func updateProxy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	selectMux.Lock()
+	defer selectMux.Unlock()
 	if err := selector.Set(req.Name); err != nil {
 		render.Status(r, http.StatusBadRequest)
 		render.JSON(w, r, newError(fmt.Sprintf("Selector update error: %s", err.Error())))
@@ -91,6 +107,70 @@ func updateProxy(w http.ResponseWriter, r *http.Request) {
 	}
 
 	cachefile.Cache().SetSelected(proxy.Name(), req.Name)
+	statistic.DefaultManager.CloseSwitched(proxy.Name(), req.Name, policy)
+	render.NoContent(w, r)
+}
+
+// updateProxies selects proxies of several selectors, nothing is changed unless every entry is valid
+func updateProxies(w http.ResponseWriter, r *http.Request) {
+	req := map[string]string{}
+	if err := render.DecodeJSON(r.Body, &req); err != nil || len(req) == 0 {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+
+	policy, err := statistic.ParseClosePolicy(r.URL.Query().Get("breakConnections"))
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+
+	selectMux.Lock()
+	defer selectMux.Unlock()
+
+	proxies := tunnel.Proxies()
+	selectors := map[string]*outboundgroup.Selector{}
+	invalid := map[string]string{}
+	for group, name := range req {
+		proxy, exist := proxies[group]
+		if !exist {
+			invalid[group] = "group not exist"
+			continue
+		}
+
+		var selector *outboundgroup.Selector
+		if p, ok := proxy.(*adapter.Proxy); ok {
+			selector, _ = p.ProxyAdapter.(*outboundgroup.Selector)
+		}
+		if selector == nil {
+			invalid[group] = "must be a Selector"
+			continue
+		}
+		if !selector.Selectable(name) {
+			invalid[group] = fmt.Sprintf("proxy %s not exist", name)
+			continue
+		}
+		selectors[group] = selector
+	}
+
+	if len(invalid) != 0 {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, render.M{
+			"message": "Selector update error",
+			"errors":  invalid,
+		})
+		return
+	}
+
+	for group, selector := range selectors {
+		selector.Set(req[group])
+	}
+	cachefile.Cache().SetSelectedMap(req)
+	for group := range selectors {
+		statistic.DefaultManager.CloseSwitched(group, req[group], policy)
+	}
 	render.NoContent(w, r)
 }
 
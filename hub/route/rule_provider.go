@@ -0,0 +1,167 @@
+package route
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/constant/provider"
+	"github.com/Dreamacro/clash/tunnel"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+const (
+	defaultPayloadLimit = 1000
+	maxPayloadLimit     = 10000
+)
+
+func ruleProviderRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", getRuleProviders)
+
+	r.Route("/{providerName}", func(r chi.Router) {
+		r.Use(parseProviderName, findRuleProviderByName)
+		r.Get("/", getRuleProvider)
+		r.Put("/", updateRuleProvider)
+		r.Get("/payload", getRuleProviderPayload)
+		r.Get("/match", matchRuleProvider)
+	})
+	return r
+}
+
+func getRuleProviders(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, render.M{
+		"providers": tunnel.RuleProviders(),
+	})
+}
+
+func getRuleProvider(w http.ResponseWriter, r *http.Request) {
+	provider := r.Context().Value(CtxKeyProvider).(provider.RuleProvider)
+	render.JSON(w, r, provider)
+}
+
+func updateRuleProvider(w http.ResponseWriter, r *http.Request) {
+	provider := r.Context().Value(CtxKeyProvider).(provider.RuleProvider)
+	if err := provider.Update(); err != nil {
+		render.Status(r, http.StatusServiceUnavailable)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+	render.NoContent(w, r)
+}
+
+// getRuleProviderPayload pages the entries with offset and limit, a list may
+// hold hundreds of thousands of lines
+func getRuleProviderPayload(w http.ResponseWriter, r *http.Request) {
+	inspector, ok := ruleInspectorOf(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	offset, err := parseQueryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError("invalid offset"))
+		return
+	}
+	limit, err := parseQueryInt(query.Get("limit"), defaultPayloadLimit)
+	if err != nil || limit <= 0 {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError("invalid limit"))
+		return
+	}
+	if limit > maxPayloadLimit {
+		limit = maxPayloadLimit
+	}
+
+	payload := inspector.Payload()
+	total := len(payload)
+	start := offset
+	if start > total {
+		start = total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+
+	page := payload[start:end]
+	if page == nil {
+		page = []string{}
+	}
+	render.JSON(w, r, render.M{
+		"total":   total,
+		"offset":  start,
+		"payload": page,
+	})
+}
+
+// matchRuleProvider tests a domain or an ip against the provider, for a classical
+// provider the value fills the host or the destination ip of the metadata
+func matchRuleProvider(w http.ResponseWriter, r *http.Request) {
+	inspector, ok := ruleInspectorOf(w, r)
+	if !ok {
+		return
+	}
+
+	value := strings.TrimSpace(r.URL.Query().Get("value"))
+	if value == "" {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError("value is required"))
+		return
+	}
+
+	metadata := &C.Metadata{}
+	if ip := net.ParseIP(value); ip != nil {
+		metadata.DstIP = ip
+	} else {
+		metadata.Host = strings.TrimSuffix(value, ".")
+	}
+
+	entry, matched := inspector.Lookup(metadata)
+	resp := render.M{
+		"value":   value,
+		"matched": matched,
+	}
+	if matched {
+		resp["entry"] = entry
+	}
+	render.JSON(w, r, resp)
+}
+
+func ruleInspectorOf(w http.ResponseWriter, r *http.Request) (provider.RuleInspector, bool) {
+	inspector, ok := r.Context().Value(CtxKeyProvider).(provider.RuleInspector)
+	if !ok {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError("provider does not support inspection"))
+	}
+	return inspector, ok
+}
+
+func parseQueryInt(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func findRuleProviderByName(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Context().Value(CtxKeyProviderName).(string)
+		provider, exist := tunnel.RuleProviders()[name]
+		if !exist {
+			render.Status(r, http.StatusNotFound)
+			render.JSON(w, r, ErrNotFound)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), CtxKeyProvider, provider)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
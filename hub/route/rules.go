@@ -3,6 +3,7 @@ package route
 import (
 	"net/http"
 
+	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/tunnel"
 
 	"github.com/go-chi/chi/v5"
@@ -19,6 +20,9 @@ type Rule struct {
 	Type    string `json:"type"`
 	Payload string `json:"payload"`
 	Proxy   string `json:"proxy"`
+
+	// whether a schedule rule such as TIME currently applies
+	Active *bool `json:"active,omitempty"`
 }
 
 func getRules(w http.ResponseWriter, r *http.Request) {
@@ -26,10 +30,23 @@ func getRules(w http.ResponseWriter, r *http.Request) {
 
 	rules := []Rule{}
 	for _, rule := range rawRules {
-		rules = append(rules, Rule{
+		r := Rule{
 			Type:    rule.RuleType().String(),
 			Payload: rule.Payload(),
 			Proxy:   rule.Adapter(),
+		}
+		if scheduled, ok := rule.(interface{ Active() bool }); ok && isScheduled(rule) {
+			active := scheduled.Active()
+			r.Active = &active
+		}
+		rules = append(rules, r)
+	}
+
+	// unmatched traffic goes to the final policy unless the rules end with MATCH
+	if len(rawRules) == 0 || rawRules[len(rawRules)-1].RuleType() != C.MATCH {
+		rules = append(rules, Rule{
+			Type:  "Final",
+			Proxy: tunnel.Final(),
 		})
 	}
 
@@ -37,3 +54,11 @@ func getRules(w http.ResponseWriter, r *http.Request) {
 		"rules": rules,
 	})
 }
+
+// isScheduled tells apart the logic rules without TIME sub rules
+func isScheduled(rule C.Rule) bool {
+	if s, ok := rule.(interface{ Scheduled() bool }); ok {
+		return s.Scheduled()
+	}
+	return true
+}
@@ -61,6 +61,7 @@ func Start(addr string, secret string) {
 	})
 
 	r.Use(cors.Handler)
+	r.Get("/proxy.pac", getPAC)
 	r.Group(func(r chi.Router) {
 		r.Use(authentication)
 
@@ -68,12 +69,26 @@ func Start(addr string, secret string) {
 		r.Get("/logs", getLogs)
 		r.Get("/traffic", traffic)
 		r.Get("/version", version)
+		r.Get("/metrics", getMetrics)
+		r.Get("/diagnostics", getDiagnostics)
 		r.Mount("/configs", configRouter())
 		r.Mount("/proxies", proxyRouter())
 		r.Mount("/rules", ruleRouter())
+		r.Mount("/profiles", profileRouter())
 		r.Mount("/connections", connectionRouter())
+		r.Mount("/events", eventRouter())
 		r.Mount("/providers/proxies", proxyProviderRouter())
+		r.Mount("/providers/rules", ruleProviderRouter())
 		r.Mount("/dns", dnsRouter())
+		r.Mount("/tun", tunRouter())
+		r.Get("/inbounds", getInbounds)
+		r.Get("/power", getPower)
+		r.Get("/health-check-server", getProbeServer)
+
+		// packet capture exposes traffic content, only offer it behind a secret
+		if serverSecret != "" {
+			r.Mount("/debug", debugRouter())
+		}
 	})
 
 	if uiPath != "" {
@@ -259,7 +274,3 @@ func getLogs(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
-
-func version(w http.ResponseWriter, r *http.Request) {
-	render.JSON(w, r, render.M{"version": C.Version})
-}
@@ -0,0 +1,83 @@
+package route
+
+import (
+	"net/http"
+
+	"github.com/Dreamacro/clash/hub/executor"
+	P "github.com/Dreamacro/clash/listener"
+	"github.com/Dreamacro/clash/tunnel"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+func tunRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", getTun)
+	r.Put("/", updateTun)
+	r.Get("/stats", getTunStats)
+	return r
+}
+
+func tunStatus() render.M {
+	tun := P.Tun()
+	status := render.M{
+		"enable":       tun.Enable,
+		"running":      tun.Running,
+		"device-url":   tun.DeviceURL,
+		"dns-listen":   tun.DNSListen,
+		"dns-hijack":   tun.DNSHijack,
+		"sniffing":     tun.Sniffing,
+		"find-process": tun.FindProcess,
+
+		"exclude-cidr":    tun.ExcludeCIDR,
+		"include-cidr":    tun.IncludeCIDR,
+		"reject-excluded": tun.RejectExcluded,
+	}
+	if stats, ok := P.TunStats(); ok {
+		status["stats"] = stats
+	}
+	if addressing, ok := P.TunAddressing(); ok {
+		status["addressing"] = addressing
+	}
+	if report, ok := executor.TunMTU(); ok {
+		status["mtu"] = report
+	}
+	if err := P.TunError(); err != nil {
+		status["error"] = err.Error()
+	}
+	return status
+}
+
+func getTun(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, tunStatus())
+}
+
+func getTunStats(w http.ResponseWriter, r *http.Request) {
+	stats, ok := P.TunStats()
+	if !ok {
+		render.Status(r, http.StatusNotFound)
+		render.JSON(w, r, newError("tun is not running"))
+		return
+	}
+	render.JSON(w, r, stats)
+}
+
+func updateTun(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Enable *bool `json:"enable"`
+	}{}
+	if err := render.DecodeJSON(r.Body, &req); err != nil || req.Enable == nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+
+	if err := P.SetTunEnable(*req.Enable, tunnel.TCPIn(), tunnel.UDPIn()); err != nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+
+	render.JSON(w, r, tunStatus())
+}
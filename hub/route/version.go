@@ -0,0 +1,37 @@
+package route
+
+import (
+	"net/http"
+	"runtime"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/listener/tun/dev"
+
+	"github.com/go-chi/render"
+)
+
+// features lists the optional features that can work in this build on this platform,
+// GUIs use it to hide switches instead of parsing the version string
+func features() render.M {
+	goos := runtime.GOOS
+	return render.M{
+		"tun":     dev.Supported,
+		"redir":   goos == "linux" || goos == "android" || goos == "darwin" || goos == "freebsd",
+		"tproxy":  goos == "linux" || goos == "android",
+		"ipset":   goos == "linux" || goos == "android",
+		"process": goos == "linux" || goos == "android" || goos == "darwin" || goos == "windows" || goos == "freebsd",
+		"ebpf":    false,
+	}
+}
+
+func version(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, render.M{
+		"version":   C.Version,
+		"buildTime": C.BuildTime,
+		"go":        runtime.Version(),
+		"platform":  runtime.GOOS,
+		"arch":      runtime.GOARCH,
+		"features":  features(),
+		"process":   processInfo(),
+	})
+}
@@ -0,0 +1,53 @@
+//go:build !windows
+
+package route
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/go-chi/render"
+)
+
+const capNetAdmin = 12
+
+func processInfo() render.M {
+	info := render.M{
+		"root":        os.Geteuid() == 0,
+		"netAdmin":    hasNetAdmin(),
+		"maxOpenFile": uint64(0),
+	}
+
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err == nil {
+		info["maxOpenFile"] = uint64(limit.Cur)
+	}
+	return info
+}
+
+// hasNetAdmin reads the effective capabilities, only available with procfs
+func hasNetAdmin() bool {
+	if os.Geteuid() == 0 {
+		return true
+	}
+
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, found := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !found {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		return err == nil && caps&(1<<capNetAdmin) != 0
+	}
+	return false
+}
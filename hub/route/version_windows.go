@@ -0,0 +1,13 @@
+package route
+
+import (
+	"github.com/go-chi/render"
+)
+
+func processInfo() render.M {
+	return render.M{
+		"root":        false,
+		"netAdmin":    false,
+		"maxOpenFile": uint64(0),
+	}
+}
@@ -12,7 +12,9 @@ import (
 	"github.com/Dreamacro/clash/transport/socks5"
 )
 
-func newClient(source net.Addr, originTarget net.Addr, in chan<- C.ConnContext) *http.Client {
+// newClient relays the requests through the tunnel, a request of REJECT is answered with
+// reject when it isn't nil
+func newClient(source net.Addr, originTarget net.Addr, in chan<- C.ConnContext, reject *inbound.RejectResponse) *http.Client {
 	return &http.Client{
 		Transport: &http.Transport{
 			// from http.DefaultTransport
@@ -32,7 +34,11 @@ func newClient(source net.Addr, originTarget net.Addr, in chan<- C.ConnContext)
 
 				left, right := net.Pipe()
 
-				in <- inbound.NewHTTP(dstAddr, source, originTarget, right)
+				var conn net.Conn = right
+				if reject != nil {
+					conn = &rejectConn{Conn: right, reject: reject}
+				}
+				in <- inbound.NewHTTP(dstAddr, source, originTarget, conn)
 
 				return left, nil
 			},
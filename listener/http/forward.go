@@ -0,0 +1,40 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+)
+
+// forwardHeaders applies the forward header mode of the listener to a plain http request
+// from client, the CONNECT tunnels are left alone
+func forwardHeaders(request *http.Request, capability inbound.Capability, client net.Addr) {
+	switch capability.ForwardHeadersFor(request.URL.Hostname()) {
+	case inbound.ForwardHeadersStrip:
+		request.Header.Del("X-Forwarded-For")
+		request.Header.Del("X-Real-IP")
+		request.Header.Del("Via")
+	case inbound.ForwardHeadersAppend:
+		ip := client.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+
+		if prior := request.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			request.Header.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+ip)
+		} else {
+			request.Header.Set("X-Forwarded-For", ip)
+		}
+		if request.Header.Get("X-Real-IP") == "" {
+			request.Header.Set("X-Real-IP", ip)
+		}
+		via := fmt.Sprintf("%d.%d clash", request.ProtoMajor, request.ProtoMinor)
+		if prior := request.Header.Values("Via"); len(prior) > 0 {
+			via = strings.Join(prior, ", ") + ", " + via
+		}
+		request.Header.Set("Via", via)
+	}
+}
@@ -0,0 +1,122 @@
+package http
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	N "github.com/Dreamacro/clash/common/net"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// forwardedHeaders sends a request for host through the http listener and return the
+// forward headers the server got, every destination is relayed to the test server
+func forwardedHeaders(t *testing.T, host string, capability inbound.Capability) http.Header {
+	inbound.SetCapabilities(map[string]inbound.Capability{inbound.ListenerHTTP: capability})
+	defer inbound.SetCapabilities(map[string]inbound.Capability{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, key := range []string{"X-Forwarded-For", "X-Real-Ip", "Via"} {
+			w.Header()["Got-"+key] = r.Header[key]
+		}
+	}))
+	defer server.Close()
+
+	in := make(chan C.ConnContext)
+	defer close(in)
+	go func() {
+		for ctx := range in {
+			go func(conn net.Conn) {
+				upstream, err := net.Dial("tcp", server.Listener.Addr().String())
+				if err != nil {
+					conn.Close()
+					return
+				}
+				N.Relay(conn, upstream)
+			}(ctx.Conn())
+		}
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			HandleConn(c, in, nil, inbound.ListenerHTTP)
+		}
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	fmt.Fprintf(client, "GET http://%s/ HTTP/1.1\r\nHost: %s\r\nX-Forwarded-For: 192.0.2.1\r\nVia: 1.1 upstream\r\n\r\n", host, host)
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	got := http.Header{}
+	for _, key := range []string{"X-Forwarded-For", "X-Real-Ip", "Via"} {
+		if values := resp.Header["Got-"+key]; len(values) > 0 {
+			got[key] = values
+		}
+	}
+	return got
+}
+
+func TestForwardHeaders_Strip(t *testing.T) {
+	got := forwardedHeaders(t, "10.0.0.1", inbound.Capability{ForwardHeaders: inbound.ForwardHeadersStrip})
+	assert.Empty(t, got)
+}
+
+func TestForwardHeaders_Append(t *testing.T) {
+	got := forwardedHeaders(t, "example.com", inbound.Capability{ForwardHeaders: inbound.ForwardHeadersAppend})
+	assert.Equal(t, "192.0.2.1, 127.0.0.1", got.Get("X-Forwarded-For"))
+	assert.Equal(t, "127.0.0.1", got.Get("X-Real-Ip"))
+	assert.Equal(t, "1.1 upstream, 1.1 clash", got.Get("Via"))
+}
+
+func TestForwardHeaders_Preserve(t *testing.T) {
+	got := forwardedHeaders(t, "example.com", inbound.Capability{ForwardHeaders: inbound.ForwardHeadersPreserve})
+	assert.Equal(t, "192.0.2.1", got.Get("X-Forwarded-For"))
+	assert.Empty(t, got.Get("X-Real-Ip"))
+	assert.Equal(t, "1.1 upstream", got.Get("Via"))
+}
+
+func TestForwardHeaders_Auto(t *testing.T) {
+	got := forwardedHeaders(t, "example.com", inbound.Capability{})
+	assert.Empty(t, got)
+
+	got = forwardedHeaders(t, "192.168.1.1:8080", inbound.Capability{})
+	assert.Equal(t, "192.0.2.1, 127.0.0.1", got.Get("X-Forwarded-For"))
+}
+
+func TestForwardHeaders_Overrides(t *testing.T) {
+	overrides, err := inbound.NewForwardOverrides(map[string]string{
+		"+.corp.example.com": inbound.ForwardHeadersAppend,
+		"10.0.0.0/8":         inbound.ForwardHeadersPreserve,
+		"10.1.0.0/16":        inbound.ForwardHeadersStrip,
+	})
+	require.NoError(t, err)
+	capability := inbound.Capability{ForwardHeaders: inbound.ForwardHeadersStrip, ForwardOverrides: overrides}
+
+	got := forwardedHeaders(t, "wiki.corp.example.com", capability)
+	assert.Equal(t, "1.1 upstream, 1.1 clash", got.Get("Via"))
+
+	got = forwardedHeaders(t, "10.2.0.1", capability)
+	assert.Equal(t, "1.1 upstream", got.Get("Via"))
+
+	got = forwardedHeaders(t, "10.1.0.1", capability)
+	assert.Empty(t, got)
+
+	_, err = inbound.NewForwardOverrides(map[string]string{"example.com": "drop"})
+	assert.Error(t, err)
+}
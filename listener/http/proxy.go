@@ -1,7 +1,6 @@
 package http
 
 import (
-	"fmt"
 	"net"
 	"net/http"
 	"strings"
@@ -14,8 +13,10 @@ import (
 	"github.com/Dreamacro/clash/log"
 )
 
-func HandleConn(c net.Conn, in chan<- C.ConnContext, cache *cache.LruCache) {
-	client := newClient(c.RemoteAddr(), c.LocalAddr(), in)
+// HandleConn serves a http proxy client of the listener, see inbound.Capability
+func HandleConn(c net.Conn, in chan<- C.ConnContext, cache *cache.LruCache, listener string) {
+	capability := inbound.CapabilityOf(listener)
+	client := newClient(c.RemoteAddr(), c.LocalAddr(), in, capability.RejectResponse)
 	defer client.CloseIdleConnections()
 
 	conn := N.NewBufferedConn(c)
@@ -42,9 +43,19 @@ func HandleConn(c net.Conn, in chan<- C.ConnContext, cache *cache.LruCache) {
 		}
 
 		if trusted {
+			resp = checkCapability(request, capability, listener, c.RemoteAddr())
+		}
+
+		if trusted && resp == nil {
 			if request.Method == http.MethodConnect {
-				// Manual writing to support CONNECT for http 1.0 (workaround for uplay client)
-				if _, err = fmt.Fprintf(conn, "HTTP/%d.%d %03d %s\r\n\r\n", request.ProtoMajor, request.ProtoMinor, http.StatusOK, "Connection established"); err != nil {
+				// answered by the tunnel, 403 for REJECT
+				if capability.RejectResponse != nil {
+					in <- inbound.NewHTTPS(request, &connectConn{Conn: conn, request: request, reject: capability.RejectResponse})
+
+					return // hijack connection
+				}
+
+				if err = writeEstablished(conn, request); err != nil {
 					break // close connection
 				}
 
@@ -61,13 +72,14 @@ func HandleConn(c net.Conn, in chan<- C.ConnContext, cache *cache.LruCache) {
 			request.RequestURI = ""
 
 			if isUpgradeRequest(request) {
-				handleUpgrade(conn, request, in)
+				handleUpgrade(conn, request, in, capability)
 
 				return // hijack connection
 			}
 
 			removeHopByHopHeaders(request.Header)
 			removeExtraHTTPHostPort(request)
+			forwardHeaders(request, capability, c.RemoteAddr())
 
 			if request.URL.Scheme == "" || request.URL.Host == "" {
 				resp = responseWith(request, http.StatusBadRequest)
@@ -98,6 +110,30 @@ func HandleConn(c net.Conn, in chan<- C.ConnContext, cache *cache.LruCache) {
 	conn.Close()
 }
 
+// checkCapability return the refusal of a request outside the capability of the listener
+func checkCapability(request *http.Request, capability inbound.Capability, listener string, src net.Addr) *http.Response {
+	if request.Method != http.MethodConnect && capability.HTTPConnectOnly {
+		inbound.LogDenied(listener, src, "http "+request.Method)
+		resp := responseWith(request, http.StatusMethodNotAllowed)
+		resp.Header.Set("Allow", http.MethodConnect)
+		return resp
+	}
+
+	port := request.URL.Port()
+	if port == "" {
+		port = "80"
+		if request.URL.Scheme == "https" || request.URL.Scheme == "wss" {
+			port = "443"
+		}
+	}
+	if !capability.AllowPort(port) {
+		inbound.LogDenied(listener, src, "http port "+port)
+		return responseWith(request, http.StatusForbidden)
+	}
+
+	return nil
+}
+
 func authenticate(request *http.Request, cache *cache.LruCache) *http.Response {
 	authenticator := authStore.Authenticator()
 	if authenticator != nil {
@@ -0,0 +1,96 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+
+	"go.uber.org/atomic"
+)
+
+// errRejected ends a connection answered with the reject response
+var errRejected = errors.New("answered with the reject response")
+
+// connectConn is a CONNECT of a listener with a reject response, it's established once the
+// tunnel dialed its destination instead of before
+type connectConn struct {
+	net.Conn
+	request  *http.Request
+	reject   *inbound.RejectResponse
+	answered atomic.Bool
+}
+
+// Respond implements C.DialResponder
+func (c *connectConn) Respond(conn net.Conn, rejected bool, rule C.Rule) error {
+	if !c.answered.CompareAndSwap(false, true) {
+		return nil
+	}
+	if rejected {
+		writeReject(conn, c.request, c.reject, rule)
+		return errRejected
+	}
+	return writeEstablished(conn, c.request)
+}
+
+// Close answers a CONNECT whose destination couldn't be dialed with 502
+func (c *connectConn) Close() error {
+	if c.answered.CompareAndSwap(false, true) {
+		resp := responseWith(c.request, http.StatusBadGateway)
+		resp.Close = true
+		resp.Write(c.Conn)
+	}
+	return c.Conn.Close()
+}
+
+// rejectConn is the end of the pipe of a plain http request in the tunnel, it reads the
+// request and answers it itself when it's of REJECT
+type rejectConn struct {
+	net.Conn
+	reject *inbound.RejectResponse
+}
+
+// Respond implements C.DialResponder
+func (c *rejectConn) Respond(conn net.Conn, rejected bool, rule C.Rule) error {
+	if !rejected {
+		return nil
+	}
+	request, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return err
+	}
+	// the transport writes the whole request before it's done with the pipe
+	io.Copy(io.Discard, request.Body)
+	request.Body.Close()
+	writeReject(conn, request, c.reject, rule)
+	return errRejected
+}
+
+// writeEstablished answers a CONNECT, written by hand to support http 1.0 (workaround for
+// uplay client)
+func writeEstablished(w io.Writer, request *http.Request) error {
+	_, err := fmt.Fprintf(w, "HTTP/%d.%d %03d %s\r\n\r\n", request.ProtoMajor, request.ProtoMinor, http.StatusOK, "Connection established")
+	return err
+}
+
+func writeReject(w io.Writer, request *http.Request, reject *inbound.RejectResponse, rule C.Rule) error {
+	text := ""
+	if reject.ShowRule && rule != nil {
+		text = " rule " + html.EscapeString(rule.RuleType().String()+","+rule.Payload())
+	}
+	body := strings.ReplaceAll(reject.Body, "{rule}", text)
+
+	resp := responseWith(request, reject.Status)
+	resp.Header.Set("Content-Type", "text/html; charset=utf-8")
+	resp.ContentLength = int64(len(body))
+	resp.Body = io.NopCloser(strings.NewReader(body))
+	resp.Close = true
+	return resp.Write(w)
+}
@@ -0,0 +1,103 @@
+package http
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+	R "github.com/Dreamacro/clash/rule"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rejectedResponse sends request through the http listener with reject, the tunnel answers
+// every connection as REJECT of a DOMAIN-SUFFIX rule
+func rejectedResponse(t *testing.T, reject *inbound.RejectResponse, request string) *http.Response {
+	inbound.SetCapabilities(map[string]inbound.Capability{inbound.ListenerHTTP: {RejectResponse: reject}})
+	defer inbound.SetCapabilities(map[string]inbound.Capability{})
+
+	in := make(chan C.ConnContext)
+	defer close(in)
+	go func() {
+		for ctx := range in {
+			go func(conn net.Conn) {
+				defer conn.Close()
+				if responder, ok := conn.(C.DialResponder); ok {
+					assert.ErrorIs(t, responder.Respond(conn, true, R.NewDomainSuffix("ads.example.com", "REJECT")), errRejected)
+				}
+			}(ctx.Conn())
+		}
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			HandleConn(c, in, nil, inbound.ListenerHTTP)
+		}
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	fmt.Fprint(client, request)
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestHandleConn_RejectResponse(t *testing.T) {
+	reject := &inbound.RejectResponse{Status: http.StatusForbidden, Body: inbound.DefaultRejectBody}
+	for _, request := range []string{
+		"GET http://ads.example.com/ HTTP/1.1\r\nHost: ads.example.com\r\n\r\n",
+		"POST http://ads.example.com/ HTTP/1.1\r\nHost: ads.example.com\r\nContent-Length: 4\r\n\r\nbody",
+		"CONNECT ads.example.com:443 HTTP/1.1\r\nHost: ads.example.com:443\r\n\r\n",
+	} {
+		resp := rejectedResponse(t, reject, request)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, request)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "<html><body>blocked by clash</body></html>", string(body), request)
+	}
+
+	// the rule only with show-rule
+	reject = &inbound.RejectResponse{Status: http.StatusTeapot, Body: "blocked by clash{rule}", ShowRule: true}
+	resp := rejectedResponse(t, reject, "GET http://ads.example.com/ HTTP/1.1\r\nHost: ads.example.com\r\n\r\n")
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "blocked by clash rule DomainSuffix,ads.example.com", string(body))
+}
+
+func TestConnectConn(t *testing.T) {
+	request, err := http.ReadRequest(bufio.NewReader(strings.NewReader("CONNECT example.com:443 HTTP/1.0\r\n\r\n")))
+	require.NoError(t, err)
+
+	// established once the destination is dialed
+	client, server := net.Pipe()
+	conn := &connectConn{Conn: server, request: request, reject: &inbound.RejectResponse{Status: http.StatusForbidden}}
+	go func() {
+		assert.NoError(t, conn.Respond(conn, false, nil))
+		conn.Close()
+	}()
+	resp, err := http.ReadResponse(bufio.NewReader(client), request)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	client.Close()
+
+	// 502 when it couldn't be
+	client, server = net.Pipe()
+	conn = &connectConn{Conn: server, request: request, reject: &inbound.RejectResponse{Status: http.StatusForbidden}}
+	go conn.Close()
+	resp, err = http.ReadResponse(bufio.NewReader(client), request)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	client.Close()
+}
@@ -3,6 +3,7 @@ package http
 import (
 	"net"
 
+	"github.com/Dreamacro/clash/adapter/inbound"
 	"github.com/Dreamacro/clash/common/cache"
 	C "github.com/Dreamacro/clash/constant"
 )
@@ -34,15 +35,20 @@ func New(addr string, in chan<- C.ConnContext) (*Listener, error) {
 }
 
 func NewWithAuthenticate(addr string, in chan<- C.ConnContext, authenticate bool) (*Listener, error) {
-	l, err := net.Listen("tcp", addr)
-	if err != nil {
-		return nil, err
-	}
-
 	var c *cache.LruCache
 	if authenticate {
 		c = cache.New(cache.WithAge(30))
 	}
+	return NewWithCache(addr, in, c)
+}
+
+// NewWithCache return a listener sharing the authentication cache c with the others
+// bound by the same inbound, a nil cache disables the authentication
+func NewWithCache(addr string, in chan<- C.ConnContext, c *cache.LruCache) (*Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
 
 	hl := &Listener{
 		listener: l,
@@ -57,7 +63,11 @@ func NewWithAuthenticate(addr string, in chan<- C.ConnContext, authenticate bool
 				}
 				continue
 			}
-			go HandleConn(conn, in, c)
+			if !inbound.IsRemoteAllowed(conn.RemoteAddr()) {
+				conn.Close()
+				continue
+			}
+			go HandleConn(conn, in, c, inbound.ListenerHTTP)
 		}
 	}()
 
@@ -23,11 +23,12 @@ func isUpgradeRequest(req *http.Request) bool {
 	return false
 }
 
-func handleUpgrade(conn net.Conn, request *http.Request, in chan<- C.ConnContext) {
+func handleUpgrade(conn net.Conn, request *http.Request, in chan<- C.ConnContext, capability inbound.Capability) {
 	defer conn.Close()
 
 	removeProxyHeaders(request.Header)
 	removeExtraHTTPHostPort(request)
+	forwardHeaders(request, capability, conn.RemoteAddr())
 
 	address := request.Host
 	if _, _, err := net.SplitHostPort(address); err != nil {
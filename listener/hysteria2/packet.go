@@ -0,0 +1,38 @@
+package hysteria2
+
+import (
+	"net"
+)
+
+// packet is a udp packet of a session of a client
+type packet struct {
+	session *session
+	id      uint32
+	addr    string
+	local   net.Addr
+	payload []byte
+}
+
+func (c *packet) Data() []byte {
+	return c.payload
+}
+
+// WriteBack sends b to the session as from addr, the target of the packet when addr is nil
+func (c *packet) WriteBack(b []byte, addr net.Addr) (n int, err error) {
+	from := c.addr
+	if addr != nil {
+		from = addr.String()
+	}
+	if err := c.session.writeBack(c.id, from, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// LocalAddr returns the address of the client with the session id, so the sessions
+// of a connection don't share a nat entry
+func (c *packet) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *packet) Drop() {}
@@ -0,0 +1,296 @@
+package hysteria2
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+	"github.com/Dreamacro/clash/transport/hysteria2"
+	"github.com/Dreamacro/clash/transport/quicconn"
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
+)
+
+type Option struct {
+	Name        string
+	Listen      string
+	Certificate string
+	PrivateKey  string
+	// Users maps the user names to their passwords, a client authenticates with the password
+	Users map[string]string
+	ALPN  []string
+	// Up and Down are the bandwidth of the server in bytes per second, zero
+	// leaves the sending to the congestion control of quic
+	Up   uint64
+	Down uint64
+}
+
+type Listener struct {
+	option Option
+	pc     net.PacketConn
+	ln     *quic.Listener
+	tcpIn  chan<- C.ConnContext
+	udpIn  chan<- *inbound.PacketAdapter
+	closed atomic.Bool
+
+	mux   sync.Mutex
+	conns map[quic.Connection]struct{}
+}
+
+// RawAddress implements C.Listener
+func (l *Listener) RawAddress() string {
+	return l.option.Listen
+}
+
+// Address implements C.Listener
+func (l *Listener) Address() string {
+	return l.pc.LocalAddr().String()
+}
+
+// Close implements C.Listener, the connections of the clients are closed too
+func (l *Listener) Close() error {
+	l.closed.Store(true)
+	err := l.ln.Close()
+	l.mux.Lock()
+	for conn := range l.conns {
+		conn.CloseWithError(0, "")
+	}
+	l.mux.Unlock()
+	l.pc.Close()
+	return err
+}
+
+func New(option Option, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) (*Listener, error) {
+	cert, err := quicconn.LoadKeyPair(option.Certificate, option.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	alpn := option.ALPN
+	if len(alpn) == 0 {
+		alpn = []string{"h3"}
+	}
+
+	pc, err := net.ListenPacket("udp", option.Listen)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := quic.Listen(pc, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   alpn,
+	}, quicconn.ServerConfig())
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	l := &Listener{
+		option: option,
+		pc:     pc,
+		ln:     ln,
+		tcpIn:  tcpIn,
+		udpIn:  udpIn,
+		conns:  map[quic.Connection]struct{}{},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				if l.closed.Load() {
+					return
+				}
+				log.Dedupln(log.WARNING, option.Name, "[Hysteria2] %s accept error: %s", option.Name, err.Error())
+				continue
+			}
+			go l.serve(conn)
+		}
+	}()
+	return l, nil
+}
+
+func (l *Listener) serve(conn quic.Connection) {
+	l.mux.Lock()
+	if l.closed.Load() {
+		l.mux.Unlock()
+		conn.CloseWithError(0, "")
+		return
+	}
+	l.conns[conn] = struct{}{}
+	l.mux.Unlock()
+	defer func() {
+		l.mux.Lock()
+		delete(l.conns, conn)
+		l.mux.Unlock()
+	}()
+
+	s := &session{listener: l, conn: conn, sessions: map[uint32]*hysteria2.Defragger{}}
+	server := &http3.Server{
+		Handler: http.HandlerFunc(s.serveHTTP),
+		StreamHijacker: func(ft http3.FrameType, _ quic.Connection, stream quic.Stream, err error) (bool, error) {
+			if err != nil || ft != hysteria2.FrameTypeTCPRequest || !s.authed.Load() {
+				return false, nil
+			}
+			go s.handleStream(stream)
+			return true, nil
+		},
+	}
+	server.ServeQUICConn(conn)
+	conn.CloseWithError(0, "")
+}
+
+// session is an authenticated quic connection of a client
+type session struct {
+	listener *Listener
+	conn     quic.Connection
+	authed   atomic.Bool
+	user     string
+	pacer    *rate.Limiter
+
+	mux      sync.Mutex
+	sessions map[uint32]*hysteria2.Defragger
+	packetID uint16
+}
+
+// serveHTTP authenticates, anything else is answered as a plain web server would
+func (s *session) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Host != hysteria2.URLHost || r.URL.Path != hysteria2.URLPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	opt := s.listener.option
+	req := hysteria2.ParseAuthRequest(r.Header)
+	user, ok := s.verify(req.Auth)
+	if !ok {
+		log.Dedupln(log.WARNING, opt.Name, "[Hysteria2] %s authentication failed from %s", opt.Name, s.conn.RemoteAddr().String())
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mux.Lock()
+	if !s.authed.Load() {
+		// send no faster than the client receives
+		tx := opt.Up
+		if req.RX != 0 && (tx == 0 || req.RX < tx) {
+			tx = req.RX
+		}
+		s.user, s.pacer = user, quicconn.NewPacer(tx)
+		s.authed.Store(true)
+		go s.receive()
+		log.Debugln("[Hysteria2] %s user %s connected from %s", opt.Name, user, s.conn.RemoteAddr().String())
+	}
+	s.mux.Unlock()
+	hysteria2.SetAuthResponse(w.Header(), true, opt.Down)
+	w.WriteHeader(hysteria2.StatusAuthOK)
+}
+
+func (s *session) verify(auth string) (string, bool) {
+	for user, password := range s.listener.option.Users {
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(password)) == 1 {
+			return user, true
+		}
+	}
+	return "", false
+}
+
+func (s *session) handleStream(stream quic.Stream) {
+	addr, err := hysteria2.ReadTCPRequest(stream)
+	if err != nil {
+		stream.CancelRead(0)
+		stream.Close()
+		return
+	}
+	target := socks5.ParseAddr(addr)
+	if target == nil {
+		hysteria2.WriteTCPResponse(stream, false, "invalid address")
+		stream.Close()
+		return
+	}
+	if err := hysteria2.WriteTCPResponse(stream, true, ""); err != nil {
+		stream.CancelRead(0)
+		stream.Close()
+		return
+	}
+
+	conn := quicconn.NewStreamConn(stream, s.conn.LocalAddr(), s.conn.RemoteAddr(), s.pacer)
+	s.listener.tcpIn <- inbound.NewSocket(target, conn, C.HYSTERIA2)
+}
+
+func (s *session) receive() {
+	for {
+		b, err := s.conn.ReceiveDatagram(context.Background())
+		if err != nil {
+			return
+		}
+		m, err := hysteria2.ParseUDPMessage(b)
+		if err != nil {
+			continue
+		}
+
+		s.mux.Lock()
+		defrag, ok := s.sessions[m.SessionID]
+		if !ok {
+			defrag = &hysteria2.Defragger{}
+			s.sessions[m.SessionID] = defrag
+		}
+		full := defrag.Feed(m)
+		s.mux.Unlock()
+		if full == nil {
+			continue
+		}
+		target := socks5.ParseAddr(full.Addr)
+		if target == nil {
+			continue
+		}
+
+		packet := &packet{
+			session: s,
+			id:      full.SessionID,
+			addr:    full.Addr,
+			local:   &quicconn.SessionAddr{Addr: s.conn.RemoteAddr(), ID: full.SessionID},
+			payload: append([]byte(nil), full.Data...),
+		}
+		adapter := inbound.NewPacket(target, s.conn.LocalAddr(), packet, C.HYSTERIA2)
+		if udpAddr, ok := s.conn.RemoteAddr().(*net.UDPAddr); ok {
+			adapter.Metadata().SrcIP = udpAddr.IP
+			adapter.Metadata().SrcPort = strconv.Itoa(udpAddr.Port)
+		}
+		select {
+		case s.listener.udpIn <- adapter:
+		default:
+		}
+	}
+}
+
+func (s *session) writeBack(id uint32, addr string, b []byte) error {
+	s.mux.Lock()
+	s.packetID++
+	packetID := s.packetID
+	s.mux.Unlock()
+
+	m := &hysteria2.UDPMessage{SessionID: id, PacketID: packetID, FragCount: 1, Addr: addr, Data: b}
+	frags := hysteria2.FragUDPMessage(m, quicconn.MaxDatagramSize)
+	if len(frags) == 0 {
+		return errors.New("packet too large")
+	}
+	for _, frag := range frags {
+		buf := make([]byte, frag.Size())
+		frag.Marshal(buf)
+		quicconn.WaitPacer(s.pacer, len(buf))
+		if err := s.conn.SendDatagram(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,44 +1,60 @@
 package listener
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Dreamacro/clash/adapter/inbound"
+	"github.com/Dreamacro/clash/common/cache"
 	"github.com/Dreamacro/clash/config"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/dns"
 	"github.com/Dreamacro/clash/listener/http"
+	"github.com/Dreamacro/clash/listener/hysteria2"
 	"github.com/Dreamacro/clash/listener/mixed"
 	"github.com/Dreamacro/clash/listener/redir"
 	"github.com/Dreamacro/clash/listener/socks"
 	"github.com/Dreamacro/clash/listener/tproxy"
+	"github.com/Dreamacro/clash/listener/tuic"
 	"github.com/Dreamacro/clash/listener/tun"
+	"github.com/Dreamacro/clash/listener/tun/dev"
 	"github.com/Dreamacro/clash/listener/tunnel"
 	"github.com/Dreamacro/clash/log"
+	"github.com/Dreamacro/clash/transport/quicconn"
 
 	"github.com/samber/lo"
 )
 
 var (
-	allowLan    = false
-	bindAddress = "*"
+	allowLan      = false
+	bindAddresses = []string{"*"}
+	bindFatal     = false
 
-	socksListener      *socks.Listener
-	socksUDPListener   *socks.UDPListener
-	httpListener       *http.Listener
+	socksSockets       []*socket
+	httpSockets        []*socket
+	httpCache          *cache.LruCache
 	redirListener      *redir.Listener
 	redirUDPListener   *tproxy.UDPListener
 	tproxyListener     *tproxy.Listener
 	tproxyUDPListener  *tproxy.UDPListener
-	mixedListener      *mixed.Listener
-	mixedUDPLister     *socks.UDPListener
+	mixedSockets       []*socket
+	mixedCache         *cache.LruCache
 	tunAdapter         tun.TunAdapter
+	tunConf            config.Tun
+	tunErr             error
+	tunResolver        *dns.Resolver
+	tunMapper          *dns.ResolverEnhancer
 	tunnelTCPListeners = map[string]*tunnel.Listener{}
 	tunnelUDPListeners = map[string]*tunnel.PacketConn{}
+	quicListeners      = map[string]*quicListener{}
 
 	// lock for recreate function
 	socksMux  sync.Mutex
@@ -48,6 +64,7 @@ var (
 	mixedMux  sync.Mutex
 	tunMux    sync.Mutex
 	tunnelMux sync.Mutex
+	quicMux   sync.Mutex
 )
 
 type Ports struct {
@@ -62,266 +79,294 @@ func AllowLan() bool {
 	return allowLan
 }
 
-func BindAddress() string {
-	return bindAddress
+func BindAddresses() []string {
+	return append([]string(nil), bindAddresses...)
+}
+
+func BindFailure() string {
+	if bindFatal {
+		return config.BindFailureFatal
+	}
+	return config.BindFailureWarn
 }
 
 func SetAllowLan(al bool) {
 	allowLan = al
+	inbound.SetAllowLan(al)
 }
 
+// Tun return the tun config with the state of the adapter, the last device stays visible
+// while it's down so it can be enabled again
 func Tun() config.Tun {
+	tunMux.Lock()
+	defer tunMux.Unlock()
 	if tunAdapter == nil {
-		return config.Tun{}
+		return config.Tun{
+			Enable:      tunConf.Enable,
+			DeviceURL:   tunConf.DeviceURL,
+			DNSListen:   tunConf.DNSListen,
+			DNSHijack:   tunConf.DNSHijack,
+			Sniffing:    tunConf.Sniffing,
+			FindProcess: tunConf.FindProcess,
+
+			ExcludeCIDR:    tunConf.ExcludeCIDR,
+			IncludeCIDR:    tunConf.IncludeCIDR,
+			RejectExcluded: tunConf.RejectExcluded,
+		}
 	}
 	return config.Tun{
-		Enable:    true,
-		DeviceURL: tunAdapter.DeviceURL(),
-		DNSListen: tunAdapter.DNSListen(),
+		Enable:      true,
+		DeviceURL:   tunAdapter.DeviceURL(),
+		DNSListen:   tunAdapter.DNSListen(),
+		DNSHijack:   tunAdapter.DNSHijack(),
+		Sniffing:    tunConf.Sniffing,
+		FindProcess: tunAdapter.FindProcess(),
+		Running:     true,
+
+		ExcludeCIDR:    tunConf.ExcludeCIDR,
+		IncludeCIDR:    tunConf.IncludeCIDR,
+		RejectExcluded: tunConf.RejectExcluded,
+	}
+}
+
+func tunSniffOptions(conf config.TunSniffing) *tun.SniffOptions {
+	if !conf.Enable {
+		return nil
+	}
+	return &tun.SniffOptions{
+		Ports:   conf.Ports,
+		Timeout: time.Duration(conf.Timeout) * time.Millisecond,
 	}
 }
 
-func SetBindAddress(host string) {
-	bindAddress = host
+// SetBindAddresses sets the hosts the http, socks and mixed listeners bind on one
+// socket each, the redir and tproxy listeners only bind the first one
+func SetBindAddresses(hosts []string) {
+	if len(hosts) == 0 {
+		hosts = []string{"*"}
+	}
+	bindAddresses = append([]string(nil), hosts...)
 }
 
-func ReCreateHTTP(port int, tcpIn chan<- C.ConnContext) {
+// SetBindFailure sets whether a listener failing to bind one of its addresses keeps its
+// old sockets and reports an error, or binds the others and logs the failure
+func SetBindFailure(policy string) {
+	bindFatal = policy == config.BindFailureFatal
+}
+
+func ReCreateHTTP(port int, tcpIn chan<- C.ConnContext) (err error) {
 	httpMux.Lock()
 	defer httpMux.Unlock()
 
-	var err error
 	defer func() {
 		if err != nil {
 			log.Errorln("Start HTTP server error: %s", err.Error())
 		}
 	}()
 
-	addr := genAddr(bindAddress, port, allowLan)
-
-	if httpListener != nil {
-		if httpListener.RawAddress() == addr {
-			return
-		}
-		httpListener.Close()
-		httpListener = nil
-	}
-
-	if portIsZero(addr) {
-		return
+	// the sockets of the listener share the cache of the authenticated clients
+	if len(httpSockets) == 0 {
+		httpCache = cache.New(cache.WithAge(30))
 	}
 
-	httpListener, err = http.New(addr, tcpIn)
-	if err != nil {
-		return
+	var added []*socket
+	httpSockets, added, err = rebindSockets(httpSockets, proxyAddrs(port), bindFatal, func(addr string) (*socket, error) {
+		l, err := http.NewWithCache(addr, tcpIn, httpCache)
+		if err != nil {
+			return nil, err
+		}
+		return &socket{addr: addr, tcp: l}, nil
+	})
+	for _, s := range added {
+		log.Infoln("HTTP proxy listening at: %s", s.tcp.Address())
 	}
-
-	log.Infoln("HTTP proxy listening at: %s", httpListener.Address())
+	return
 }
 
-func ReCreateSocks(port int, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) {
+func ReCreateSocks(port int, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) (err error) {
 	socksMux.Lock()
 	defer socksMux.Unlock()
 
-	var err error
 	defer func() {
 		if err != nil {
 			log.Errorln("Start SOCKS server error: %s", err.Error())
 		}
 	}()
 
-	addr := genAddr(bindAddress, port, allowLan)
-
-	shouldTCPIgnore := false
-	shouldUDPIgnore := false
-
-	if socksListener != nil {
-		if socksListener.RawAddress() != addr {
-			socksListener.Close()
-			socksListener = nil
-		} else {
-			shouldTCPIgnore = true
+	var added []*socket
+	socksSockets, added, err = rebindSockets(socksSockets, proxyAddrs(port), bindFatal, func(addr string) (*socket, error) {
+		t, err := socks.New(addr, tcpIn)
+		if err != nil {
+			return nil, err
 		}
-	}
-
-	if socksUDPListener != nil {
-		if socksUDPListener.RawAddress() != addr {
-			socksUDPListener.Close()
-			socksUDPListener = nil
-		} else {
-			shouldUDPIgnore = true
+		u, err := socks.NewUDP(addr, udpIn, inbound.ListenerSocks)
+		if err != nil {
+			t.Close()
+			return nil, err
 		}
+		return &socket{addr: addr, tcp: t, udp: u}, nil
+	})
+	for _, s := range added {
+		log.Infoln("SOCKS proxy listening at: %s", s.tcp.Address())
 	}
-
-	if shouldTCPIgnore && shouldUDPIgnore {
-		return
-	}
-
-	if portIsZero(addr) {
-		return
-	}
-
-	tcpListener, err := socks.New(addr, tcpIn)
-	if err != nil {
-		return
-	}
-
-	udpListener, err := socks.NewUDP(addr, udpIn)
-	if err != nil {
-		tcpListener.Close()
-		return
-	}
-
-	socksListener = tcpListener
-	socksUDPListener = udpListener
-
-	log.Infoln("SOCKS proxy listening at: %s", socksListener.Address())
+	return
 }
 
-func ReCreateRedir(port int, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) {
+func ReCreateRedir(port int, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) (err error) {
 	redirMux.Lock()
 	defer redirMux.Unlock()
 
-	var err error
 	defer func() {
 		if err != nil {
 			log.Errorln("Start Redir server error: %s", err.Error())
 		}
 	}()
 
-	addr := genAddr(bindAddress, port, allowLan)
+	addr := genAddr(bindAddresses[0], port, allowLan)
 
-	if redirListener != nil {
-		if redirListener.RawAddress() == addr {
-			return
-		}
-		redirListener.Close()
-		redirListener = nil
+	if redirListener != nil && redirListener.RawAddress() == addr {
+		return
 	}
 
+	old, oldAddr := closers{}, ""
+	if redirListener != nil {
+		old, oldAddr = append(old, redirListener), redirListener.RawAddress()
+	}
 	if redirUDPListener != nil {
-		if redirUDPListener.RawAddress() == addr {
-			return
-		}
-		redirUDPListener.Close()
-		redirUDPListener = nil
+		old = append(old, redirUDPListener)
 	}
 
 	if portIsZero(addr) {
+		old.Close()
+		redirListener, redirUDPListener = nil, nil
 		return
 	}
 
-	redirListener, err = redir.New(addr, tcpIn)
-	if err != nil {
+	var (
+		tcpListener *redir.Listener
+		udpListener *tproxy.UDPListener
+	)
+	var closed bool
+	closed, err = rebind(old, oldAddr, addr, func(addr string) error {
+		t, err := redir.New(addr, tcpIn)
+		if err != nil {
+			return err
+		}
+		// the udp listener is optional
+		u, udpErr := tproxy.NewUDP(addr, udpIn)
+		if udpErr != nil {
+			log.Warnln("Failed to start Redir UDP Listener: %s", udpErr)
+		}
+		tcpListener, udpListener = t, u
+		return nil
+	})
+	if tcpListener == nil {
+		// the old listeners are closed and couldn't be restored
+		if closed {
+			redirListener, redirUDPListener = nil, nil
+		}
 		return
 	}
 
-	redirUDPListener, err = tproxy.NewUDP(addr, udpIn)
-	if err != nil {
-		log.Warnln("Failed to start Redir UDP Listener: %s", err)
-	}
-
+	redirListener = tcpListener
+	redirUDPListener = udpListener
 	log.Infoln("Redirect proxy listening at: %s", redirListener.Address())
+	return
 }
 
-func ReCreateTProxy(port int, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) {
+func ReCreateTProxy(port int, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) (err error) {
 	tproxyMux.Lock()
 	defer tproxyMux.Unlock()
 
-	var err error
 	defer func() {
 		if err != nil {
 			log.Errorln("Start TProxy server error: %s", err.Error())
 		}
 	}()
 
-	addr := genAddr(bindAddress, port, allowLan)
+	addr := genAddr(bindAddresses[0], port, allowLan)
 
-	if tproxyListener != nil {
-		if tproxyListener.RawAddress() == addr {
-			return
-		}
-		tproxyListener.Close()
-		tproxyListener = nil
+	if tproxyListener != nil && tproxyListener.RawAddress() == addr {
+		return
 	}
 
+	old, oldAddr := closers{}, ""
+	if tproxyListener != nil {
+		old, oldAddr = append(old, tproxyListener), tproxyListener.RawAddress()
+	}
 	if tproxyUDPListener != nil {
-		if tproxyUDPListener.RawAddress() == addr {
-			return
-		}
-		tproxyUDPListener.Close()
-		tproxyUDPListener = nil
+		old = append(old, tproxyUDPListener)
 	}
 
 	if portIsZero(addr) {
+		old.Close()
+		tproxyListener, tproxyUDPListener = nil, nil
 		return
 	}
 
-	tproxyListener, err = tproxy.New(addr, tcpIn)
-	if err != nil {
+	var (
+		tcpListener *tproxy.Listener
+		udpListener *tproxy.UDPListener
+	)
+	var closed bool
+	closed, err = rebind(old, oldAddr, addr, func(addr string) error {
+		t, err := tproxy.New(addr, tcpIn)
+		if err != nil {
+			return err
+		}
+		// the udp listener is optional
+		u, udpErr := tproxy.NewUDP(addr, udpIn)
+		if udpErr != nil {
+			log.Warnln("Failed to start TProxy UDP Listener: %s", udpErr)
+		}
+		tcpListener, udpListener = t, u
+		return nil
+	})
+	if tcpListener == nil {
+		// the old listeners are closed and couldn't be restored
+		if closed {
+			tproxyListener, tproxyUDPListener = nil, nil
+		}
 		return
 	}
 
-	tproxyUDPListener, err = tproxy.NewUDP(addr, udpIn)
-	if err != nil {
-		log.Warnln("Failed to start TProxy UDP Listener: %s", err)
-	}
-
+	tproxyListener = tcpListener
+	tproxyUDPListener = udpListener
 	log.Infoln("TProxy server listening at: %s", tproxyListener.Address())
+	return
 }
 
-func ReCreateMixed(port int, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) {
+func ReCreateMixed(port int, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) (err error) {
 	mixedMux.Lock()
 	defer mixedMux.Unlock()
 
-	var err error
 	defer func() {
 		if err != nil {
 			log.Errorln("Start Mixed(http+socks) server error: %s", err.Error())
 		}
 	}()
 
-	addr := genAddr(bindAddress, port, allowLan)
-
-	shouldTCPIgnore := false
-	shouldUDPIgnore := false
+	if len(mixedSockets) == 0 {
+		mixedCache = cache.New(cache.WithAge(30))
+	}
 
-	if mixedListener != nil {
-		if mixedListener.RawAddress() != addr {
-			mixedListener.Close()
-			mixedListener = nil
-		} else {
-			shouldTCPIgnore = true
+	var added []*socket
+	mixedSockets, added, err = rebindSockets(mixedSockets, proxyAddrs(port), bindFatal, func(addr string) (*socket, error) {
+		t, err := mixed.NewWithCache(addr, tcpIn, mixedCache)
+		if err != nil {
+			return nil, err
 		}
-	}
-	if mixedUDPLister != nil {
-		if mixedUDPLister.RawAddress() != addr {
-			mixedUDPLister.Close()
-			mixedUDPLister = nil
-		} else {
-			shouldUDPIgnore = true
+		u, err := socks.NewUDP(addr, udpIn, inbound.ListenerMixed)
+		if err != nil {
+			t.Close()
+			return nil, err
 		}
+		return &socket{addr: addr, tcp: t, udp: u}, nil
+	})
+	for _, s := range added {
+		log.Infoln("Mixed(http+socks) proxy listening at: %s", s.tcp.Address())
 	}
-
-	if shouldTCPIgnore && shouldUDPIgnore {
-		return
-	}
-
-	if portIsZero(addr) {
-		return
-	}
-
-	mixedListener, err = mixed.New(addr, tcpIn)
-	if err != nil {
-		return
-	}
-
-	mixedUDPLister, err = socks.NewUDP(addr, udpIn)
-	if err != nil {
-		mixedListener.Close()
-		return
-	}
-
-	log.Infoln("Mixed(http+socks) proxy listening at: %s", mixedListener.Address())
+	return
 }
 
 func ReCreateTun(conf config.Tun, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) {
@@ -330,6 +375,8 @@ func ReCreateTun(conf config.Tun, tcpIn chan<- C.ConnContext, udpIn chan<- *inbo
 
 	var err error
 	defer func() {
+		// keep the error of the last attempt readable for the API
+		tunErr = err
 		if err != nil {
 			log.Errorln("Start Tun interface error: %s", err.Error())
 		}
@@ -338,10 +385,33 @@ func ReCreateTun(conf config.Tun, tcpIn chan<- C.ConnContext, udpIn chan<- *inbo
 	enable := conf.Enable
 	url := conf.DeviceURL
 
+	// remember the device so that it can be toggled without a config
+	tunConf.Enable = enable
+	if url != "" {
+		tunConf.DeviceURL = url
+		tunConf.DNSListen = conf.DNSListen
+		tunConf.DNSHijack = conf.DNSHijack
+		tunConf.Sniffing = conf.Sniffing
+		tunConf.FindProcess = conf.FindProcess
+		tunConf.ExcludeCIDR = conf.ExcludeCIDR
+		tunConf.IncludeCIDR = conf.IncludeCIDR
+		tunConf.RejectExcluded = conf.RejectExcluded
+	}
+
 	if tunAdapter != nil {
 		if enable && (url == "" || url == tunAdapter.DeviceURL()) {
-			// Though we don't need to recreate tun device, we should update tun DNSServer
-			err = tunAdapter.ReCreateDNSServer(conf.DNSListen)
+			// Though we don't need to recreate tun device, we should update tun DNSServer and the sniffing
+			err = recreateTunOptions(conf)
+			return
+		}
+		// a new device under the running ipstack keeps the connections
+		if enable {
+			replaceErr := tunAdapter.ReplaceDevice(url)
+			if replaceErr == nil {
+				err = recreateTunOptions(conf)
+				return
+			}
+			log.Warnln("[TUN] replace the device by %s: %s, restart the adapter", url, replaceErr)
 		}
 		tunAdapter.Close()
 		tunAdapter = nil
@@ -353,16 +423,128 @@ func ReCreateTun(conf config.Tun, tcpIn chan<- C.ConnContext, udpIn chan<- *inbo
 	if err != nil {
 		return
 	}
-	tunAdapter.ReCreateDNSServer(conf.DNSListen)
+	if tunResolver != nil {
+		tunAdapter.ResetDNSResolver(tunResolver, tunMapper)
+	}
+	err = recreateTunOptions(conf)
+}
+
+// recreateTunOptions applies the dns, the sniffing, find-process and the bypass of conf to the
+// running adapter
+func recreateTunOptions(conf config.Tun) error {
+	tunAdapter.SetSniffing(tunSniffOptions(conf.Sniffing))
+	tunAdapter.SetFindProcess(conf.FindProcess)
+	bypass, err := tunBypassOptions(conf)
+	if err == nil {
+		tunAdapter.SetBypass(bypass)
+	}
+	return errors.Join(
+		tunAdapter.ReCreateDNSServer(conf.DNSListen),
+		tunAdapter.ReCreateDNSHijack(conf.DNSHijack),
+		err,
+	)
+}
+
+// tunBypassOptions parses the exclude-cidr and include-cidr of conf, nil without any
+func tunBypassOptions(conf config.Tun) (*tun.BypassOptions, error) {
+	if len(conf.ExcludeCIDR)+len(conf.IncludeCIDR) == 0 {
+		return nil, nil
+	}
+	opts := &tun.BypassOptions{Reject: conf.RejectExcluded}
+	for _, cidr := range conf.ExcludeCIDR {
+		prefix, err := tun.ParseBypassCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("tun exclude-cidr: %w", err)
+		}
+		opts.Exclude = append(opts.Exclude, prefix)
+	}
+	for _, cidr := range conf.IncludeCIDR {
+		prefix, err := tun.ParseBypassCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("tun include-cidr: %w", err)
+		}
+		opts.Include = append(opts.Include, prefix)
+	}
+	return opts, nil
+}
+
+// SetTunEnable creates or tears down the tun adapter with the last device config
+func SetTunEnable(enable bool, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) error {
+	tunMux.Lock()
+	conf := tunConf
+	tunMux.Unlock()
+
+	if enable && conf.DeviceURL == "" {
+		return errors.New("tun device-url is not configured")
+	}
+
+	conf.Enable = enable
+	ReCreateTun(conf, tcpIn, udpIn)
+	return TunError()
+}
+
+// TunStats return the stats of the running tun adapter
+func TunStats() (tun.Stats, bool) {
+	tunMux.Lock()
+	defer tunMux.Unlock()
+	if tunAdapter == nil {
+		return tun.Stats{}, false
+	}
+	return tunAdapter.Stats(), true
+}
+
+// TunAddressing return the addresses set on the tun device from its url
+func TunAddressing() (dev.Addressing, bool) {
+	tunMux.Lock()
+	defer tunMux.Unlock()
+	if tunAdapter == nil {
+		return dev.Addressing{}, false
+	}
+	return tunAdapter.Addressing(), true
+}
+
+// TunDevice return the name and the mtu of the running tun device
+func TunDevice() (string, int, bool) {
+	tunMux.Lock()
+	defer tunMux.Unlock()
+	if tunAdapter == nil {
+		return "", 0, false
+	}
+	name, mtu := tunAdapter.Device()
+	return name, mtu, true
+}
+
+// CloseTun tears down the tun adapter on exit, the device removes the routes of autoroute
+// as it's closed
+func CloseTun() {
+	tunMux.Lock()
+	defer tunMux.Unlock()
+	if tunAdapter != nil {
+		tunAdapter.Close()
+		tunAdapter = nil
+	}
+}
+
+// TunError return the error of the last attempt to change the tun adapter
+func TunError() error {
+	tunMux.Lock()
+	defer tunMux.Unlock()
+	return tunErr
 }
 
 func ResetDNSResolver(resolver *dns.Resolver, mapper *dns.ResolverEnhancer) {
+	tunMux.Lock()
+	defer tunMux.Unlock()
+
+	tunResolver, tunMapper = resolver, mapper
 	if tunAdapter != nil {
 		tunAdapter.ResetDNSResolver(resolver, mapper)
 	}
 }
 
-func PatchTunnel(tunnels []config.Tunnel, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) {
+// PatchTunnel only touches the tunnels whose address, target or proxy changed, the
+// error reports the addresses failed to bind when bind-failure is fatal
+func PatchTunnel(tunnels []config.Tunnel, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) error {
 	tunnelMux.Lock()
 	defer tunnelMux.Unlock()
 
@@ -402,15 +584,20 @@ func PatchTunnel(tunnels []config.Tunnel, tcpIn chan<- C.ConnContext, udpIn chan
 	newElm := lo.FlatMap(
 		tunnels,
 		func(tunnel config.Tunnel, _ int) []addrProxy {
-			return lo.Map(
+			return lo.FlatMap(
 				tunnel.Network,
-				func(network string, _ int) addrProxy {
-					return addrProxy{
-						network: network,
-						addr:    tunnel.Address,
-						target:  tunnel.Target,
-						proxy:   tunnel.Proxy,
-					}
+				func(network string, _ int) []addrProxy {
+					return lo.Map(
+						tunnel.Address,
+						func(addr string, _ int) addrProxy {
+							return addrProxy{
+								network: network,
+								addr:    addr,
+								target:  tunnel.Target,
+								proxy:   tunnel.Proxy,
+							}
+						},
+					)
 				},
 			)
 		},
@@ -429,12 +616,14 @@ func PatchTunnel(tunnels []config.Tunnel, tcpIn chan<- C.ConnContext, udpIn chan
 		}
 	}
 
+	failed := []error{}
 	for _, elm := range needCreate {
 		key := fmt.Sprintf("%s/%s/%s", elm.addr, elm.target, elm.proxy)
 		if elm.network == "tcp" {
 			l, err := tunnel.New(elm.addr, elm.target, elm.proxy, tcpIn)
 			if err != nil {
 				log.Errorln("Start tunnel %s error: %s", elm.target, err.Error())
+				failed = append(failed, fmt.Errorf("tunnel %s/%s: %w", elm.network, elm.addr, err))
 				continue
 			}
 			tunnelTCPListeners[key] = l
@@ -443,26 +632,167 @@ func PatchTunnel(tunnels []config.Tunnel, tcpIn chan<- C.ConnContext, udpIn chan
 			l, err := tunnel.NewUDP(elm.addr, elm.target, elm.proxy, udpIn)
 			if err != nil {
 				log.Errorln("Start tunnel %s error: %s", elm.target, err.Error())
+				failed = append(failed, fmt.Errorf("tunnel %s/%s: %w", elm.network, elm.addr, err))
 				continue
 			}
 			tunnelUDPListeners[key] = l
 			log.Infoln("Tunnel(udp/%s) proxy %s listening at: %s", elm.target, elm.proxy, tunnelUDPListeners[key].Address())
 		}
 	}
+
+	if !bindFatal {
+		return nil
+	}
+	return errors.Join(failed...)
+}
+
+type quicListener struct {
+	config   config.QUICListener
+	listener C.Listener
+}
+
+// PatchQUICListeners only restarts the hysteria2 and tuic listeners whose config changed, the
+// error reports the listeners failed to start when bind-failure is fatal
+func PatchQUICListeners(listeners []config.QUICListener, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) error {
+	quicMux.Lock()
+	defer quicMux.Unlock()
+
+	configs := map[string]config.QUICListener{}
+	for _, conf := range listeners {
+		configs[conf.Name] = conf
+	}
+	for name, ql := range quicListeners {
+		if conf, ok := configs[name]; ok && reflect.DeepEqual(conf, ql.config) {
+			continue
+		}
+		ql.listener.Close()
+		delete(quicListeners, name)
+	}
+
+	failed := []error{}
+	for _, conf := range listeners {
+		if _, ok := quicListeners[conf.Name]; ok {
+			continue
+		}
+		l, err := newQUICListener(conf, tcpIn, udpIn)
+		if err != nil {
+			log.Errorln("Start %s listener %s error: %s", conf.Type, conf.Name, err.Error())
+			failed = append(failed, fmt.Errorf("%s listener %s: %w", conf.Type, conf.Name, err))
+			continue
+		}
+		quicListeners[conf.Name] = &quicListener{config: conf, listener: l}
+		log.Infoln("%s listener %s listening at: %s", conf.Type, conf.Name, l.Address())
+	}
+
+	if !bindFatal {
+		return nil
+	}
+	return errors.Join(failed...)
+}
+
+func newQUICListener(conf config.QUICListener, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) (C.Listener, error) {
+	if conf.Type == config.QUICListenerTUIC {
+		return tuic.New(tuic.Option{
+			Name:        conf.Name,
+			Listen:      conf.Listen,
+			Certificate: conf.Certificate,
+			PrivateKey:  conf.PrivateKey,
+			Users:       conf.Users,
+			ALPN:        conf.ALPN,
+		}, tcpIn, udpIn)
+	}
+
+	// the bandwidth was checked when the config was parsed
+	up, _ := quicconn.ParseBandwidth(conf.Up)
+	down, _ := quicconn.ParseBandwidth(conf.Down)
+	return hysteria2.New(hysteria2.Option{
+		Name:        conf.Name,
+		Listen:      conf.Listen,
+		Certificate: conf.Certificate,
+		PrivateKey:  conf.PrivateKey,
+		Users:       conf.Users,
+		ALPN:        conf.ALPN,
+		Up:          up,
+		Down:        down,
+	}, tcpIn, udpIn)
+}
+
+// Inbounds return every socket bound by the http, socks, mixed, tunnel and quic listeners
+func Inbounds() []Inbound {
+	inbounds := []Inbound{}
+
+	httpMux.Lock()
+	inbounds = append(inbounds, socketInbounds(inbound.ListenerHTTP, httpSockets)...)
+	httpMux.Unlock()
+
+	socksMux.Lock()
+	inbounds = append(inbounds, socketInbounds(inbound.ListenerSocks, socksSockets)...)
+	socksMux.Unlock()
+
+	mixedMux.Lock()
+	inbounds = append(inbounds, socketInbounds(inbound.ListenerMixed, mixedSockets)...)
+	mixedMux.Unlock()
+
+	tunnelMux.Lock()
+	defer tunnelMux.Unlock()
+
+	inbounds = append(inbounds, tunnelInbounds("tcp", tunnelTCPListeners)...)
+	inbounds = append(inbounds, tunnelInbounds("udp", tunnelUDPListeners)...)
+	return append(inbounds, quicInbounds()...)
+}
+
+func quicInbounds() []Inbound {
+	quicMux.Lock()
+	defer quicMux.Unlock()
+
+	names := lo.Keys(quicListeners)
+	sort.Strings(names)
+
+	inbounds := []Inbound{}
+	for _, name := range names {
+		ql := quicListeners[name]
+		inbounds = append(inbounds, Inbound{
+			Type:    ql.config.Type,
+			Name:    name,
+			Network: "udp",
+			Bind:    ql.listener.RawAddress(),
+			Address: ql.listener.Address(),
+		})
+	}
+	return inbounds
+}
+
+func tunnelInbounds[L C.Listener](network string, listeners map[string]L) []Inbound {
+	keys := lo.Keys(listeners)
+	sort.Strings(keys)
+
+	inbounds := []Inbound{}
+	for _, key := range keys {
+		l, parts := listeners[key], strings.Split(key, "/")
+		inbounds = append(inbounds, Inbound{
+			Type:    "tunnel",
+			Network: network,
+			Bind:    l.RawAddress(),
+			Address: l.Address(),
+			Target:  parts[1],
+			Proxy:   parts[2],
+		})
+	}
+	return inbounds
 }
 
 // GetPorts return the ports of proxy servers
 func GetPorts() *Ports {
 	ports := &Ports{}
 
-	if httpListener != nil {
-		_, portStr, _ := net.SplitHostPort(httpListener.Address())
+	if len(httpSockets) != 0 {
+		_, portStr, _ := net.SplitHostPort(httpSockets[0].tcp.Address())
 		port, _ := strconv.Atoi(portStr)
 		ports.Port = port
 	}
 
-	if socksListener != nil {
-		_, portStr, _ := net.SplitHostPort(socksListener.Address())
+	if len(socksSockets) != 0 {
+		_, portStr, _ := net.SplitHostPort(socksSockets[0].tcp.Address())
 		port, _ := strconv.Atoi(portStr)
 		ports.SocksPort = port
 	}
@@ -479,8 +809,8 @@ func GetPorts() *Ports {
 		ports.TProxyPort = port
 	}
 
-	if mixedListener != nil {
-		_, portStr, _ := net.SplitHostPort(mixedListener.Address())
+	if len(mixedSockets) != 0 {
+		_, portStr, _ := net.SplitHostPort(mixedSockets[0].tcp.Address())
 		port, _ := strconv.Atoi(portStr)
 		ports.MixedPort = port
 	}
@@ -496,6 +826,57 @@ func portIsZero(addr string) bool {
 	return false
 }
 
+// proxyAddrs is the addresses of the http, socks and mixed listeners, they check allow-lan
+// for every client so that toggling it doesn't rebind them
+func proxyAddrs(port int) []string {
+	if port == 0 {
+		return nil
+	}
+	return lo.Uniq(lo.Map(bindAddresses, func(host string, _ int) string {
+		return genAddr(host, port, true)
+	}))
+}
+
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	for _, c := range cs {
+		c.Close()
+	}
+	return nil
+}
+
+// rebind binds addr with create before the old listeners are closed, so that one is
+// always accepting. The old listeners are closed first only when addr can't be bound
+// while they hold the same port, and oldAddr is bound again when addr still fails.
+// The old listeners are kept as is when create fails otherwise, closed reports
+// whether they are gone.
+func rebind(old closers, oldAddr, addr string, create func(addr string) error) (closed bool, err error) {
+	err = create(addr)
+	if err == nil {
+		old.Close()
+		return true, nil
+	}
+	if len(old) == 0 || !samePort(oldAddr, addr) {
+		return false, err
+	}
+
+	old.Close()
+	if err = create(addr); err == nil {
+		return true, nil
+	}
+	if rErr := create(oldAddr); rErr != nil {
+		log.Warnln("Failed to restore the listener at %s: %s", oldAddr, rErr)
+	}
+	return true, err
+}
+
+func samePort(a, b string) bool {
+	_, portA, _ := net.SplitHostPort(a)
+	_, portB, _ := net.SplitHostPort(b)
+	return portA == portB
+}
+
 func genAddr(host string, port int, allowLan bool) string {
 	if allowLan {
 		if host == "*" {
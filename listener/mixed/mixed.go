@@ -3,6 +3,7 @@ package mixed
 import (
 	"net"
 
+	"github.com/Dreamacro/clash/adapter/inbound"
 	"github.com/Dreamacro/clash/common/cache"
 	N "github.com/Dreamacro/clash/common/net"
 	C "github.com/Dreamacro/clash/constant"
@@ -36,6 +37,12 @@ func (l *Listener) Close() error {
 }
 
 func New(addr string, in chan<- C.ConnContext) (*Listener, error) {
+	return NewWithCache(addr, in, cache.New(cache.WithAge(30)))
+}
+
+// NewWithCache return a listener sharing the authentication cache c with the others
+// bound by the same inbound
+func NewWithCache(addr string, in chan<- C.ConnContext, c *cache.LruCache) (*Listener, error) {
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
@@ -44,7 +51,7 @@ func New(addr string, in chan<- C.ConnContext) (*Listener, error) {
 	ml := &Listener{
 		listener: l,
 		addr:     addr,
-		cache:    cache.New(cache.WithAge(30)),
+		cache:    c,
 	}
 	go func() {
 		for {
@@ -55,6 +62,10 @@ func New(addr string, in chan<- C.ConnContext) (*Listener, error) {
 				}
 				continue
 			}
+			if !inbound.IsRemoteAllowed(c.RemoteAddr()) {
+				c.Close()
+				continue
+			}
 			go handleConn(c, in, ml.cache)
 		}
 	}()
@@ -73,10 +84,10 @@ func handleConn(conn net.Conn, in chan<- C.ConnContext, cache *cache.LruCache) {
 
 	switch head[0] {
 	case socks4.Version:
-		socks.HandleSocks4(bufConn, in)
+		socks.HandleSocks4(bufConn, in, inbound.ListenerMixed)
 	case socks5.Version:
-		socks.HandleSocks5(bufConn, in)
+		socks.HandleSocks5(bufConn, in, inbound.ListenerMixed)
 	default:
-		http.HandleConn(bufConn, in, cache)
+		http.HandleConn(bufConn, in, cache, inbound.ListenerMixed)
 	}
 }
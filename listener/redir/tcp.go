@@ -4,7 +4,10 @@ import (
 	"net"
 
 	"github.com/Dreamacro/clash/adapter/inbound"
+	"github.com/Dreamacro/clash/component/nfmeta"
 	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+	"github.com/Dreamacro/clash/transport/socks5"
 )
 
 type Listener struct {
@@ -56,11 +59,19 @@ func New(addr string, in chan<- C.ConnContext) (*Listener, error) {
 }
 
 func handleRedir(conn net.Conn, in chan<- C.ConnContext) {
-	target, err := parserPacket(conn)
+	origDst, err := parserPacket(conn)
 	if err != nil {
+		log.Debugln("[Redir] get original destination of %s failed: %s", conn.RemoteAddr(), err.Error())
 		conn.Close()
 		return
 	}
 	conn.(*net.TCPConn).SetKeepAlive(true)
-	in <- inbound.NewSocket(target, conn, C.REDIR)
+
+	ctx := inbound.NewSocket(socks5.AddrFromStdAddrPort(origDst), conn, C.REDIR)
+	// LocalAddr is the redir port itself, keep the real destination like tproxy does
+	ctx.Metadata().OriginDst = origDst
+	if dscp, err := nfmeta.DSCP(conn); err == nil {
+		ctx.Metadata().DSCP = &dscp
+	}
+	in <- ctx
 }
@@ -2,13 +2,12 @@ package redir
 
 import (
 	"net"
+	"net/netip"
 	"syscall"
 	"unsafe"
-
-	"github.com/Dreamacro/clash/transport/socks5"
 )
 
-func parserPacket(c net.Conn) (socks5.Addr, error) {
+func parserPacket(c net.Conn) (netip.AddrPort, error) {
 	const (
 		PfInout     = 0
 		PfIn        = 1
@@ -26,7 +25,7 @@ func parserPacket(c net.Conn) (socks5.Addr, error) {
 
 	fd, err := syscall.Open("/dev/pf", 0, syscall.O_RDONLY)
 	if err != nil {
-		return nil, err
+		return netip.AddrPort{}, err
 	}
 	defer syscall.Close(fd)
 
@@ -47,12 +46,9 @@ func parserPacket(c net.Conn) (socks5.Addr, error) {
 	nl.dxport[0], nl.dxport[1] = byte(daddr.Port>>8), byte(daddr.Port)
 
 	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), DIOCNATLOOK, uintptr(unsafe.Pointer(&nl))); errno != 0 {
-		return nil, errno
+		return netip.AddrPort{}, errno
 	}
 
-	addr := make([]byte, 1+net.IPv4len+2)
-	addr[0] = socks5.AtypIPv4
-	copy(addr[1:1+net.IPv4len], nl.rdaddr[:4])
-	copy(addr[1+net.IPv4len:], nl.rdxport[:2])
-	return addr, nil
+	port := uint16(nl.rdxport[0])<<8 | uint16(nl.rdxport[1])
+	return netip.AddrPortFrom(netip.AddrFrom4(*(*[4]byte)(nl.rdaddr[:4])), port), nil
 }
@@ -8,8 +8,6 @@ import (
 	"syscall"
 	"unsafe"
 
-	"github.com/Dreamacro/clash/transport/socks5"
-
 	"golang.org/x/sys/unix"
 )
 
@@ -18,15 +16,15 @@ const (
 	IP6T_SO_ORIGINAL_DST = 80 // from linux/include/uapi/linux/netfilter_ipv6/ip6_tables.h
 )
 
-func parserPacket(conn net.Conn) (socks5.Addr, error) {
+func parserPacket(conn net.Conn) (netip.AddrPort, error) {
 	c, ok := conn.(*net.TCPConn)
 	if !ok {
-		return nil, errors.New("only work with TCP connection")
+		return netip.AddrPort{}, errors.New("only work with TCP connection")
 	}
 
 	rc, err := c.SyscallConn()
 	if err != nil {
-		return nil, err
+		return netip.AddrPort{}, err
 	}
 
 	var addr netip.AddrPort
@@ -39,7 +37,7 @@ func parserPacket(conn net.Conn) (socks5.Addr, error) {
 		}
 	})
 
-	return socks5.AddrFromStdAddrPort(addr), err
+	return addr, err
 }
 
 // Call getorigdst() from linux/net/ipv4/netfilter/nf_conntrack_l3proto_ipv4.c
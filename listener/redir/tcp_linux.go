@@ -5,11 +5,10 @@ import (
 	"errors"
 	"net"
 	"net/netip"
+	"strconv"
 	"syscall"
 	"unsafe"
 
-	"github.com/Dreamacro/clash/transport/socks5"
-
 	"golang.org/x/sys/unix"
 )
 
@@ -18,28 +17,51 @@ const (
 	IP6T_SO_ORIGINAL_DST = 80 // from linux/include/uapi/linux/netfilter_ipv6/ip6_tables.h
 )
 
-func parserPacket(conn net.Conn) (socks5.Addr, error) {
+// overridden in tests
+var (
+	getOrigDst4 = getorigdst
+	getOrigDst6 = getorigdst6
+)
+
+func parserPacket(conn net.Conn) (netip.AddrPort, error) {
 	c, ok := conn.(*net.TCPConn)
 	if !ok {
-		return nil, errors.New("only work with TCP connection")
+		return netip.AddrPort{}, errors.New("only work with TCP connection")
 	}
 
 	rc, err := c.SyscallConn()
 	if err != nil {
-		return nil, err
+		return netip.AddrPort{}, err
 	}
 
-	var addr netip.AddrPort
+	return origDst(rc, c.LocalAddr().(*net.TCPAddr).IP.To4() != nil)
+}
 
-	rc.Control(func(fd uintptr) {
-		if ip4 := c.LocalAddr().(*net.TCPAddr).IP.To4(); ip4 != nil {
-			addr, err = getorigdst(fd)
-		} else {
-			addr, err = getorigdst6(fd)
+// origDst query the original destination of a REDIRECT/DNAT connection.
+// A dual-stack socket may carry conntrack entries of either family,
+// so the other family is tried when the preferred one fails.
+func origDst(rc syscall.RawConn, preferIPv4 bool) (addr netip.AddrPort, err error) {
+	first, second := getOrigDst6, getOrigDst4
+	if preferIPv4 {
+		first, second = getOrigDst4, getOrigDst6
+	}
+
+	ctrlErr := rc.Control(func(fd uintptr) {
+		addr, err = first(fd)
+		if err != nil {
+			if fallback, fallbackErr := second(fd); fallbackErr == nil {
+				addr, err = fallback, nil
+			}
 		}
 	})
+	if ctrlErr != nil {
+		return netip.AddrPort{}, ctrlErr
+	}
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
 
-	return socks5.AddrFromStdAddrPort(addr), err
+	return netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port()), nil
 }
 
 // Call getorigdst() from linux/net/ipv4/netfilter/nf_conntrack_l3proto_ipv4.c
@@ -49,16 +71,31 @@ func getorigdst(fd uintptr) (netip.AddrPort, error) {
 	if err := socketcall(GETSOCKOPT, fd, syscall.IPPROTO_IP, SO_ORIGINAL_DST, uintptr(unsafe.Pointer(&addr)), uintptr(unsafe.Pointer(&size)), 0); err != nil {
 		return netip.AddrPort{}, err
 	}
-	port := binary.BigEndian.Uint16((*(*[2]byte)(unsafe.Pointer(&addr.Port)))[:])
-	return netip.AddrPortFrom(netip.AddrFrom4(addr.Addr), port), nil
+	return parseSockaddrInet4(&addr), nil
 }
 
+// Call ipv6_getorigdst() from linux/net/netfilter/nf_conntrack_proto.c
 func getorigdst6(fd uintptr) (netip.AddrPort, error) {
 	addr := unix.RawSockaddrInet6{}
 	size := uint32(unsafe.Sizeof(addr))
 	if err := socketcall(GETSOCKOPT, fd, syscall.IPPROTO_IPV6, IP6T_SO_ORIGINAL_DST, uintptr(unsafe.Pointer(&addr)), uintptr(unsafe.Pointer(&size)), 0); err != nil {
 		return netip.AddrPort{}, err
 	}
+	return parseSockaddrInet6(&addr), nil
+}
+
+func parseSockaddrInet4(addr *unix.RawSockaddrInet4) netip.AddrPort {
 	port := binary.BigEndian.Uint16((*(*[2]byte)(unsafe.Pointer(&addr.Port)))[:])
-	return netip.AddrPortFrom(netip.AddrFrom16(addr.Addr), port), nil
+	return netip.AddrPortFrom(netip.AddrFrom4(addr.Addr), port)
+}
+
+// sockaddr_in6 keeps sin6_port in network byte order and carries the
+// scope id for link-local destinations
+func parseSockaddrInet6(addr *unix.RawSockaddrInet6) netip.AddrPort {
+	port := binary.BigEndian.Uint16((*(*[2]byte)(unsafe.Pointer(&addr.Port)))[:])
+	ip := netip.AddrFrom16(addr.Addr)
+	if addr.Scope_id != 0 && ip.IsLinkLocalUnicast() {
+		ip = ip.WithZone(strconv.FormatUint(uint64(addr.Scope_id), 10))
+	}
+	return netip.AddrPortFrom(ip, port)
 }
@@ -0,0 +1,91 @@
+package redir
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+type mockRawConn struct{}
+
+func (mockRawConn) Control(f func(fd uintptr)) error {
+	f(0)
+	return nil
+}
+
+func (mockRawConn) Read(func(fd uintptr) bool) error {
+	return errors.New("not implemented")
+}
+
+func (mockRawConn) Write(func(fd uintptr) bool) error {
+	return errors.New("not implemented")
+}
+
+func mockOrigDst(t *testing.T, v4, v6 func(uintptr) (netip.AddrPort, error)) {
+	oldV4, oldV6 := getOrigDst4, getOrigDst6
+	getOrigDst4, getOrigDst6 = v4, v6
+	t.Cleanup(func() {
+		getOrigDst4, getOrigDst6 = oldV4, oldV6
+	})
+}
+
+func fixedOrigDst(addr string) func(uintptr) (netip.AddrPort, error) {
+	return func(uintptr) (netip.AddrPort, error) {
+		return netip.MustParseAddrPort(addr), nil
+	}
+}
+
+func failedOrigDst(uintptr) (netip.AddrPort, error) {
+	return netip.AddrPort{}, unix.ENOENT
+}
+
+func TestOrigDst_IPv6(t *testing.T) {
+	mockOrigDst(t, failedOrigDst, fixedOrigDst("[2001:db8::1]:443"))
+
+	addr, err := origDst(mockRawConn{}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, netip.MustParseAddrPort("[2001:db8::1]:443"), addr)
+}
+
+func TestOrigDst_FallbackFamily(t *testing.T) {
+	mockOrigDst(t, fixedOrigDst("1.1.1.1:80"), failedOrigDst)
+
+	addr, err := origDst(mockRawConn{}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, netip.MustParseAddrPort("1.1.1.1:80"), addr)
+}
+
+func TestOrigDst_Unmap(t *testing.T) {
+	mockOrigDst(t, failedOrigDst, fixedOrigDst("[::ffff:1.2.3.4]:8080"))
+
+	addr, err := origDst(mockRawConn{}, false)
+	assert.Nil(t, err)
+	assert.True(t, addr.Addr().Is4())
+	assert.Equal(t, "1.2.3.4:8080", addr.String())
+}
+
+func TestOrigDst_Failed(t *testing.T) {
+	mockOrigDst(t, failedOrigDst, failedOrigDst)
+
+	_, err := origDst(mockRawConn{}, true)
+	assert.ErrorIs(t, err, unix.ENOENT)
+}
+
+func TestParseSockaddrInet6(t *testing.T) {
+	raw := unix.RawSockaddrInet6{
+		Family: unix.AF_INET6,
+		Addr:   netip.MustParseAddr("fe80::1").As16(),
+	}
+	// sin6_port is stored in network byte order
+	port := (*[2]byte)(unsafe.Pointer(&raw.Port))
+	port[0], port[1] = 0x1f, 0x90
+	raw.Scope_id = 3
+
+	addr := parseSockaddrInet6(&raw)
+	assert.Equal(t, uint16(8080), addr.Port())
+	assert.Equal(t, "fe80::1%3", addr.Addr().String())
+}
@@ -5,10 +5,9 @@ package redir
 import (
 	"errors"
 	"net"
-
-	"github.com/Dreamacro/clash/transport/socks5"
+	"net/netip"
 )
 
-func parserPacket(conn net.Conn) (socks5.Addr, error) {
-	return nil, errors.New("system not support yet")
+func parserPacket(conn net.Conn) (netip.AddrPort, error) {
+	return netip.AddrPort{}, errors.New("system not support yet")
 }
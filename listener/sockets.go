@@ -0,0 +1,150 @@
+package listener
+
+import (
+	"errors"
+	"fmt"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+
+	"github.com/samber/lo"
+)
+
+// socket is what a listener binds on one of its addresses, udp is nil for the tcp only ones
+type socket struct {
+	addr string
+	tcp  C.Listener
+	udp  C.Listener
+}
+
+func (s *socket) Close() error {
+	s.tcp.Close()
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	return nil
+}
+
+func (s *socket) network() string {
+	if s.udp != nil {
+		return "tcp/udp"
+	}
+	return "tcp"
+}
+
+// Inbound is a socket bound by an inbound listener, tunnels report their target and proxy,
+// the quic listeners their name
+type Inbound struct {
+	Type    string `json:"type"`
+	Name    string `json:"name,omitempty"`
+	Network string `json:"network"`
+	Bind    string `json:"bind"`
+	Address string `json:"address"`
+	Target  string `json:"target,omitempty"`
+	Proxy   string `json:"proxy,omitempty"`
+}
+
+// rebindSockets binds the addresses of addrs missing from cur and closes the sockets on
+// the other addresses, the sockets on an address still in addrs are kept as is.
+// An address failing while a closing socket holds its port is retried once they are
+// closed, binding all interfaces conflicts with a socket on one of them. When addresses
+// still fail the new sockets are dropped and cur restored if fatal or nothing could be
+// bound, the failures are only logged otherwise.
+func rebindSockets(cur []*socket, addrs []string, fatal bool, create func(addr string) (*socket, error)) (next, added []*socket, err error) {
+	kept := map[string]*socket{}
+	stale := []*socket{}
+	for _, s := range cur {
+		if lo.Contains(addrs, s.addr) {
+			kept[s.addr] = s
+		} else {
+			stale = append(stale, s)
+		}
+	}
+
+	bound := map[string]*socket{}
+	failed := map[string]error{}
+	bind := func(addr string) {
+		s, err := create(addr)
+		if err != nil {
+			failed[addr] = err
+			return
+		}
+		delete(failed, addr)
+		bound[addr] = s
+	}
+	for _, addr := range addrs {
+		if _, ok := kept[addr]; !ok {
+			bind(addr)
+		}
+	}
+
+	staleClosed := false
+	if conflict := lo.SomeBy(stale, func(s *socket) bool {
+		return lo.SomeBy(lo.Keys(failed), func(addr string) bool { return samePort(s.addr, addr) })
+	}); conflict {
+		closeSockets(stale)
+		staleClosed = true
+		for _, addr := range lo.Keys(failed) {
+			bind(addr)
+		}
+	}
+
+	if len(failed) > 0 && (fatal || len(kept)+len(bound) == 0) {
+		closeSockets(lo.Values(bound))
+		for _, s := range cur {
+			if _, ok := kept[s.addr]; ok || !staleClosed {
+				next = append(next, s)
+				continue
+			}
+			restored, rErr := create(s.addr)
+			if rErr != nil {
+				log.Warnln("Failed to restore the listener at %s: %s", s.addr, rErr)
+				continue
+			}
+			next = append(next, restored)
+		}
+		return next, nil, bindError(addrs, failed)
+	}
+
+	if !staleClosed {
+		closeSockets(stale)
+	}
+	for _, addr := range addrs {
+		if s, ok := kept[addr]; ok {
+			next = append(next, s)
+		} else if s, ok := bound[addr]; ok {
+			next = append(next, s)
+			added = append(added, s)
+		} else {
+			log.Warnln("Failed to bind %s: %s", addr, failed[addr])
+		}
+	}
+	return next, added, nil
+}
+
+func bindError(addrs []string, failed map[string]error) error {
+	errs := []error{}
+	for _, addr := range addrs {
+		if err, ok := failed[addr]; ok {
+			errs = append(errs, fmt.Errorf("bind %s: %w", addr, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func closeSockets(sockets []*socket) {
+	for _, s := range sockets {
+		s.Close()
+	}
+}
+
+func socketInbounds(typ string, sockets []*socket) []Inbound {
+	return lo.Map(sockets, func(s *socket, _ int) Inbound {
+		return Inbound{
+			Type:    typ,
+			Network: s.network(),
+			Bind:    s.addr,
+			Address: s.tcp.Address(),
+		}
+	})
+}
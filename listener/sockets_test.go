@@ -0,0 +1,136 @@
+package listener
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeListener struct {
+	addr   string
+	closed bool
+	bound  map[string]bool
+}
+
+func (l *fakeListener) RawAddress() string { return l.addr }
+func (l *fakeListener) Address() string    { return l.addr }
+
+func (l *fakeListener) Close() error {
+	l.closed = true
+	delete(l.bound, l.addr)
+	return nil
+}
+
+// fakeBinder fails the addresses in refused, and ":port" while an address on the port is bound
+type fakeBinder struct {
+	bound   map[string]bool
+	refused map[string]bool
+}
+
+func (b *fakeBinder) create(addr string) (*socket, error) {
+	if b.refused[addr] {
+		return nil, errors.New("refused")
+	}
+	for bound := range b.bound {
+		if samePort(bound, addr) && (addr[0] == ':' || bound[0] == ':') {
+			return nil, errors.New("address in use")
+		}
+	}
+	b.bound[addr] = true
+	return &socket{addr: addr, tcp: &fakeListener{addr: addr, bound: b.bound}}, nil
+}
+
+func newFakeBinder(refused ...string) *fakeBinder {
+	b := &fakeBinder{bound: map[string]bool{}, refused: map[string]bool{}}
+	for _, addr := range refused {
+		b.refused[addr] = true
+	}
+	return b
+}
+
+func TestRebindSockets_KeepUnchanged(t *testing.T) {
+	b := newFakeBinder()
+	cur, _, err := rebindSockets(nil, []string{"127.0.0.1:7890", "10.0.5.2:7890"}, true, b.create)
+	require.NoError(t, err)
+	require.Len(t, cur, 2)
+
+	next, added, err := rebindSockets(cur, []string{"127.0.0.1:7890", "10.0.6.2:7890"}, true, b.create)
+	require.NoError(t, err)
+	require.Len(t, next, 2)
+	assert.Same(t, cur[0], next[0])
+	assert.Equal(t, "10.0.6.2:7890", next[1].addr)
+	assert.Equal(t, []*socket{next[1]}, added)
+	assert.True(t, cur[1].tcp.(*fakeListener).closed)
+	assert.False(t, cur[0].tcp.(*fakeListener).closed)
+}
+
+func TestRebindSockets_Failure(t *testing.T) {
+	b := newFakeBinder("10.0.5.2:7890", "10.0.5.2:7891")
+	cur, _, err := rebindSockets(nil, []string{"127.0.0.1:7890"}, true, b.create)
+	require.NoError(t, err)
+
+	// on the same port the old socket is closed for the retry and bound again
+	next, added, err := rebindSockets(cur, []string{"10.0.5.2:7890", "10.0.6.2:7890"}, true, b.create)
+	assert.Error(t, err)
+	assert.Empty(t, added)
+	require.Len(t, next, 1)
+	assert.Equal(t, "127.0.0.1:7890", next[0].addr)
+	assert.True(t, b.bound["127.0.0.1:7890"])
+	assert.False(t, b.bound["10.0.6.2:7890"])
+
+	cur = next
+	next, added, err = rebindSockets(cur, []string{"10.0.5.2:7890", "10.0.6.2:7890"}, false, b.create)
+	assert.NoError(t, err)
+	require.Len(t, next, 1)
+	assert.Equal(t, "10.0.6.2:7890", next[0].addr)
+	assert.Equal(t, next, added)
+	assert.True(t, cur[0].tcp.(*fakeListener).closed)
+
+	// a failure on another port doesn't touch the kept sockets
+	kept, _, err := rebindSockets(next, []string{"10.0.6.2:7890", "10.0.5.2:7891"}, true, b.create)
+	assert.Error(t, err)
+	assert.Equal(t, next, kept)
+	assert.False(t, next[0].tcp.(*fakeListener).closed)
+
+	// nothing bound keeps the old sockets with warn too
+	_, _, err = rebindSockets(next, []string{"10.0.5.2:7890"}, false, b.create)
+	assert.Error(t, err)
+	assert.True(t, b.bound["10.0.6.2:7890"])
+}
+
+func TestRebindSockets_SamePort(t *testing.T) {
+	b := newFakeBinder()
+	cur, _, err := rebindSockets(nil, []string{"127.0.0.1:7890"}, true, b.create)
+	require.NoError(t, err)
+
+	next, _, err := rebindSockets(cur, []string{":7890"}, true, b.create)
+	require.NoError(t, err)
+	require.Len(t, next, 1)
+	assert.Equal(t, ":7890", next[0].addr)
+	assert.True(t, cur[0].tcp.(*fakeListener).closed)
+
+	// restored when the retry fails
+	b.refused["10.0.5.2:7890"] = true
+	b.refused["127.0.0.1:7890"] = false
+	_, _, err = rebindSockets(next, []string{"127.0.0.1:7890", "10.0.5.2:7890"}, true, b.create)
+	assert.Error(t, err)
+	assert.True(t, b.bound[":7890"])
+	assert.False(t, b.bound["127.0.0.1:7890"])
+}
+
+func TestProxyAddrs_AllowLan(t *testing.T) {
+	defer SetAllowLan(false)
+	defer SetBindAddresses(nil)
+
+	// allow-lan is checked for every client, toggling it keeps the addresses
+	SetBindAddresses([]string{"*", "127.0.0.1"})
+	SetAllowLan(false)
+	addrs := proxyAddrs(7890)
+	assert.Equal(t, []string{":7890", "127.0.0.1:7890"}, addrs)
+	SetAllowLan(true)
+	assert.Equal(t, addrs, proxyAddrs(7890))
+
+	assert.Nil(t, proxyAddrs(0))
+}
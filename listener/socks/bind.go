@@ -0,0 +1,125 @@
+package socks
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/Dreamacro/clash/component/resolver"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	"go.uber.org/atomic"
+)
+
+// DefaultBindTimeout is how long a BIND waits for the peer by default
+const DefaultBindTimeout = 60 * time.Second
+
+var (
+	bindTimeout = atomic.NewDuration(DefaultBindTimeout)
+	bindAnyPeer = atomic.NewBool(false)
+)
+
+// SetBind sets how long a BIND waits for the peer, and whether a peer other than the
+// DST.ADDR of the request is accepted
+func SetBind(timeout time.Duration, anyPeer bool) {
+	if timeout <= 0 {
+		timeout = DefaultBindTimeout
+	}
+	bindTimeout.Store(timeout)
+	bindAnyPeer.Store(anyPeer)
+}
+
+// bindRequest is the connection of a client sending the BIND command, see C.BindRequest
+type bindRequest struct {
+	net.Conn
+	target socks5.Addr
+}
+
+// BindAddress implements C.BindRequest, the listener is on the address the client
+// connected to, so it follows bind-address and allow-lan like the socks listener
+func (b *bindRequest) BindAddress() string {
+	host, _, err := net.SplitHostPort(b.Conn.LocalAddr().String())
+	if err != nil {
+		host = ""
+	}
+	return net.JoinHostPort(host, "0")
+}
+
+// Reject implements C.BindRequest
+func (b *bindRequest) Reject(err error) {
+	socks5.WriteReply(b.Conn, socks5.ReplyCode(err), nil)
+}
+
+// Accept implements C.BindRequest, peers other than the expected one are closed
+// until it connects or the timeout is over
+func (b *bindRequest) Accept(ln C.BindListener) (C.Conn, error) {
+	defer ln.Close()
+
+	expected, err := b.expectedPeer()
+	if err != nil {
+		b.Reject(socks5.ErrHostUnreachable)
+		return nil, err
+	}
+
+	// the first reply tells where the peer should connect
+	if err := socks5.WriteReply(b.Conn, 0, socks5.ParseAddrToSocksAddr(ln.Addr())); err != nil {
+		return nil, err
+	}
+
+	timer := time.AfterFunc(bindTimeout.Load(), func() {
+		ln.Close()
+	})
+	defer timer.Stop()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			b.Reject(socks5.ErrTTLExpired)
+			return nil, err
+		}
+
+		addrPort, err := netip.ParseAddrPort(c.RemoteAddr().String())
+		if err != nil || (expected.IsValid() && addrPort.Addr().Unmap() != expected) {
+			c.Close()
+			continue
+		}
+
+		// the second reply tells who connected
+		if err := socks5.WriteReply(b.Conn, 0, socks5.ParseAddrToSocksAddr(c.RemoteAddr())); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+// expectedPeer return the address of the peer to accept, an invalid one accepts any
+func (b *bindRequest) expectedPeer() (netip.Addr, error) {
+	if bindAnyPeer.Load() {
+		return netip.Addr{}, nil
+	}
+
+	host, _, err := net.SplitHostPort(b.target.String())
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		resolved, err := resolver.ResolveIP(host)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		ip, _ = netip.AddrFromSlice(resolved)
+	}
+	ip = ip.Unmap()
+	if ip.IsUnspecified() {
+		// the client doesn't know the peer
+		return netip.Addr{}, nil
+	}
+	if !ip.IsValid() {
+		return netip.Addr{}, errors.New("invalid peer address")
+	}
+	return ip, nil
+}
@@ -0,0 +1,99 @@
+package socks
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/outbound"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func bindPair(t *testing.T, target string) (client net.Conn, req *bindRequest) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	client, err = net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	server, err := ln.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, &bindRequest{Conn: server, target: socks5.ParseAddr(target)}
+}
+
+func readReply(t *testing.T, r io.Reader) (byte, socks5.Addr) {
+	buf := make([]byte, socks5.MaxAddrLen)
+	_, err := io.ReadFull(r, buf[:3])
+	require.NoError(t, err)
+	code := buf[1]
+	addr, err := socks5.ReadAddr(r, buf)
+	require.NoError(t, err)
+	return code, addr
+}
+
+func TestBindRequest_Accept(t *testing.T) {
+	SetBind(200*time.Millisecond, false)
+	defer SetBind(DefaultBindTimeout, false)
+
+	client, req := bindPair(t, "127.0.0.1:21")
+	assert.Equal(t, "127.0.0.1:0", req.BindAddress())
+
+	ln, err := outbound.NewDirect().ListenContext(context.Background(), &C.Metadata{}, req.BindAddress())
+	require.NoError(t, err)
+
+	accepted := make(chan C.Conn, 1)
+	go func() {
+		peer, _ := req.Accept(ln)
+		accepted <- peer
+	}()
+
+	code, bound := readReply(t, client)
+	assert.Zero(t, code)
+	assert.Equal(t, ln.Addr().String(), bound.String())
+
+	peer, err := net.Dial("tcp", bound.String())
+	require.NoError(t, err)
+	defer peer.Close()
+
+	code, from := readReply(t, client)
+	assert.Zero(t, code)
+	assert.Equal(t, peer.LocalAddr().String(), from.String())
+	conn := <-accepted
+	if assert.NotNil(t, conn) {
+		assert.Equal(t, C.Chain{"DIRECT"}, conn.Chains())
+		conn.Close()
+	}
+}
+
+func TestBindRequest_Timeout(t *testing.T) {
+	SetBind(200*time.Millisecond, false)
+	defer SetBind(DefaultBindTimeout, false)
+
+	client, req := bindPair(t, "127.0.0.2:21")
+	ln, err := outbound.NewDirect().ListenContext(context.Background(), &C.Metadata{}, req.BindAddress())
+	require.NoError(t, err)
+	go req.Accept(ln)
+
+	_, bound := readReply(t, client)
+
+	// a connection from another peer is refused
+	other, err := net.Dial("tcp", bound.String())
+	require.NoError(t, err)
+	defer other.Close()
+	other.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = other.Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	code, _ := readReply(t, client)
+	assert.Equal(t, byte(socks5.ErrTTLExpired), code)
+}
@@ -53,14 +53,18 @@ func New(addr string, in chan<- C.ConnContext) (*Listener, error) {
 				}
 				continue
 			}
-			go handleSocks(c, in)
+			if !inbound.IsRemoteAllowed(c.RemoteAddr()) {
+				c.Close()
+				continue
+			}
+			go handleSocks(c, in, inbound.ListenerSocks)
 		}
 	}()
 
 	return sl, nil
 }
 
-func handleSocks(conn net.Conn, in chan<- C.ConnContext) {
+func handleSocks(conn net.Conn, in chan<- C.ConnContext, listener string) {
 	conn.(*net.TCPConn).SetKeepAlive(true)
 	bufConn := N.NewBufferedConn(conn)
 	head, err := bufConn.Peek(1)
@@ -71,16 +75,27 @@ func handleSocks(conn net.Conn, in chan<- C.ConnContext) {
 
 	switch head[0] {
 	case socks4.Version:
-		HandleSocks4(bufConn, in)
+		HandleSocks4(bufConn, in, listener)
 	case socks5.Version:
-		HandleSocks5(bufConn, in)
+		HandleSocks5(bufConn, in, listener)
 	default:
 		conn.Close()
 	}
 }
 
-func HandleSocks4(conn net.Conn, in chan<- C.ConnContext) {
-	addr, _, err := socks4.ServerHandshake(conn, authStore.Authenticator())
+// HandleSocks4 serves a socks4 client of the listener, see inbound.Capability
+func HandleSocks4(conn net.Conn, in chan<- C.ConnContext, listener string) {
+	capability := inbound.CapabilityOf(listener)
+	allow := func(addr string) bool {
+		_, port, _ := net.SplitHostPort(addr)
+		if !capability.AllowPort(port) {
+			inbound.LogDenied(listener, conn.RemoteAddr(), "socks4 port "+port)
+			return false
+		}
+		return true
+	}
+
+	addr, _, err := socks4.ServerHandshake(conn, authStore.Authenticator(), allow)
 	if err != nil {
 		conn.Close()
 		return
@@ -88,16 +103,43 @@ func HandleSocks4(conn net.Conn, in chan<- C.ConnContext) {
 	in <- inbound.NewSocket(socks5.ParseAddr(addr), conn, C.SOCKS4)
 }
 
-func HandleSocks5(conn net.Conn, in chan<- C.ConnContext) {
-	target, command, err := socks5.ServerHandshake(conn, authStore.Authenticator())
+// HandleSocks5 serves a socks5 client of the listener, see inbound.Capability
+func HandleSocks5(conn net.Conn, in chan<- C.ConnContext, listener string) {
+	capability := inbound.CapabilityOf(listener)
+	allow := func(command socks5.Command, addr socks5.Addr) error {
+		if command == socks5.CmdUDPAssociate {
+			if capability.SocksUDPDisabled {
+				inbound.LogDenied(listener, conn.RemoteAddr(), "socks udp associate")
+				return socks5.ErrCommandNotSupported
+			}
+			return nil
+		}
+		if command == socks5.CmdBind {
+			// nothing is dialed, the port is the one of the expected peer
+			return nil
+		}
+
+		_, port, _ := net.SplitHostPort(addr.String())
+		if !capability.AllowPort(port) {
+			inbound.LogDenied(listener, conn.RemoteAddr(), "socks5 port "+port)
+			return socks5.ErrConnectionNotAllowed
+		}
+		return nil
+	}
+
+	target, command, err := socks5.ServerHandshake(conn, authStore.Authenticator(), allow)
 	if err != nil {
 		conn.Close()
 		return
 	}
-	if command == socks5.CmdUDPAssociate {
+	switch command {
+	case socks5.CmdUDPAssociate:
 		defer conn.Close()
 		io.Copy(io.Discard, conn)
 		return
+	case socks5.CmdBind:
+		in <- inbound.NewSocket(target, &bindRequest{Conn: conn, target: target}, C.SOCKS5)
+		return
 	}
 	in <- inbound.NewSocket(target, conn, C.SOCKS5)
 }
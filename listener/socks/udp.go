@@ -33,7 +33,8 @@ func (l *UDPListener) Close() error {
 	return l.packetConn.Close()
 }
 
-func NewUDP(addr string, in chan<- *inbound.PacketAdapter) (*UDPListener, error) {
+// NewUDP serves the udp relay of a socks or mixed listener, listener names its capability
+func NewUDP(addr string, in chan<- *inbound.PacketAdapter, listener string) (*UDPListener, error) {
 	l, err := net.ListenPacket("udp", addr)
 	if err != nil {
 		return nil, err
@@ -58,20 +59,36 @@ func NewUDP(addr string, in chan<- *inbound.PacketAdapter) (*UDPListener, error)
 				}
 				continue
 			}
-			handleSocksUDP(l, in, buf[:n], remoteAddr)
+			if !inbound.IsRemoteAllowed(remoteAddr) {
+				pool.Put(buf)
+				continue
+			}
+			handleSocksUDP(l, in, buf[:n], remoteAddr, listener)
 		}
 	}()
 
 	return sl, nil
 }
 
-func handleSocksUDP(pc net.PacketConn, in chan<- *inbound.PacketAdapter, buf []byte, addr net.Addr) {
+func handleSocksUDP(pc net.PacketConn, in chan<- *inbound.PacketAdapter, buf []byte, addr net.Addr, listener string) {
 	target, payload, err := socks5.DecodeUDPPacket(buf)
 	if err != nil {
 		// Unresolved UDP packet, return buffer to the pool
 		pool.Put(buf)
 		return
 	}
+
+	capability := inbound.CapabilityOf(listener)
+	if capability.SocksUDPDisabled {
+		inbound.LogDenied(listener, addr, "socks udp")
+		pool.Put(buf)
+		return
+	}
+	if _, port, _ := net.SplitHostPort(target.String()); !capability.AllowPort(port) {
+		inbound.LogDenied(listener, addr, "socks udp port "+port)
+		pool.Put(buf)
+		return
+	}
 	packet := &packet{
 		pc:      pc,
 		rAddr:   addr,
@@ -4,6 +4,7 @@ import (
 	"net"
 
 	"github.com/Dreamacro/clash/adapter/inbound"
+	"github.com/Dreamacro/clash/component/nfmeta"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/transport/socks5"
 )
@@ -33,7 +34,11 @@ func (l *Listener) Close() error {
 func (l *Listener) handleTProxy(conn net.Conn, in chan<- C.ConnContext) {
 	target := socks5.ParseAddrToSocksAddr(conn.LocalAddr())
 	conn.(*net.TCPConn).SetKeepAlive(true)
-	in <- inbound.NewSocket(target, conn, C.TPROXY)
+	ctx := inbound.NewSocket(target, conn, C.TPROXY)
+	if dscp, err := nfmeta.DSCP(conn); err == nil {
+		ctx.Metadata().DSCP = &dscp
+	}
+	in <- ctx
 }
 
 func New(addr string, in chan<- C.ConnContext) (*Listener, error) {
@@ -6,6 +6,7 @@ import (
 
 	"github.com/Dreamacro/clash/adapter/inbound"
 	"github.com/Dreamacro/clash/common/pool"
+	"github.com/Dreamacro/clash/component/nfmeta"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/transport/socks5"
 )
@@ -77,21 +78,27 @@ func NewUDP(addr string, in chan<- *inbound.PacketAdapter) (*UDPListener, error)
 				// try to unmap 4in6 address
 				lAddr = netip.AddrPortFrom(lAddr.Addr().Unmap(), lAddr.Port())
 			}
-			handlePacketConn(in, buf[:n], lAddr, rAddr)
+			tos, hasTOS := nfmeta.ParseTOS(oob[:oobn])
+			handlePacketConn(in, buf[:n], lAddr, rAddr, tos, hasTOS)
 		}
 	}()
 
 	return rl, nil
 }
 
-func handlePacketConn(in chan<- *inbound.PacketAdapter, buf []byte, lAddr, rAddr netip.AddrPort) {
+func handlePacketConn(in chan<- *inbound.PacketAdapter, buf []byte, lAddr, rAddr netip.AddrPort, tos uint8, hasTOS bool) {
 	target := socks5.AddrFromStdAddrPort(rAddr)
 	pkt := &packet{
 		lAddr: lAddr,
 		buf:   buf,
 	}
+	adapter := inbound.NewPacket(target, target.UDPAddr(), pkt, C.TPROXY)
+	if hasTOS {
+		dscp := tos >> 2
+		adapter.Metadata().DSCP = &dscp
+	}
 	select {
-	case in <- inbound.NewPacket(target, target.UDPAddr(), pkt, C.TPROXY):
+	case in <- adapter:
 	default:
 	}
 }
@@ -103,8 +103,13 @@ func getOrigDst(oob []byte) (netip.AddrPort, error) {
 		return netip.AddrPort{}, fmt.Errorf("parse control message: %w", err)
 	}
 
-	// retrieve the destination address from the SCM.
-	sa, err := unix.ParseOrigDstAddr(&scms[0])
+	// retrieve the destination address from the SCM, the TOS one may come first
+	var sa unix.Sockaddr
+	for i := range scms {
+		if sa, err = unix.ParseOrigDstAddr(&scms[i]); err == nil {
+			break
+		}
+	}
 	if err != nil {
 		return netip.AddrPort{}, fmt.Errorf("retrieve destination: %w", err)
 	}
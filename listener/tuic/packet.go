@@ -0,0 +1,43 @@
+package tuic
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/Dreamacro/clash/transport/socks5"
+)
+
+// packet is a udp packet of an association of a client
+type packet struct {
+	session *session
+	assoc   uint16
+	addr    socks5.Addr
+	local   net.Addr
+	payload []byte
+}
+
+func (c *packet) Data() []byte {
+	return c.payload
+}
+
+// WriteBack sends b to the association as from addr, the target of the packet when addr is nil
+func (c *packet) WriteBack(b []byte, addr net.Addr) (n int, err error) {
+	from := c.addr
+	if addr != nil {
+		if from = socks5.ParseAddr(addr.String()); from == nil {
+			return 0, fmt.Errorf("invalid address %s", addr.String())
+		}
+	}
+	if err := c.session.writeBack(c.assoc, from, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// LocalAddr returns the address of the client with the association id, so the
+// associations of a connection don't share a nat entry
+func (c *packet) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *packet) Drop() {}
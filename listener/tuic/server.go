@@ -0,0 +1,349 @@
+package tuic
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+	"github.com/Dreamacro/clash/transport/quicconn"
+	"github.com/Dreamacro/clash/transport/socks5"
+	"github.com/Dreamacro/clash/transport/tuic"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/quic-go/quic-go"
+	"go.uber.org/atomic"
+)
+
+// authTimeout is how long the commands of a connection wait for its authentication
+const authTimeout = 3 * time.Second
+
+const (
+	errorCodeAuthFailed  quic.ApplicationErrorCode = 0x01
+	errorCodeAuthTimeout quic.ApplicationErrorCode = 0x02
+)
+
+type Option struct {
+	Name        string
+	Listen      string
+	Certificate string
+	PrivateKey  string
+	// Users maps the uuids of the users to their passwords
+	Users map[string]string
+	ALPN  []string
+}
+
+type Listener struct {
+	option Option
+	users  map[[16]byte]string
+	pc     net.PacketConn
+	ln     *quic.Listener
+	tcpIn  chan<- C.ConnContext
+	udpIn  chan<- *inbound.PacketAdapter
+	closed atomic.Bool
+
+	mux   sync.Mutex
+	conns map[quic.Connection]struct{}
+}
+
+// RawAddress implements C.Listener
+func (l *Listener) RawAddress() string {
+	return l.option.Listen
+}
+
+// Address implements C.Listener
+func (l *Listener) Address() string {
+	return l.pc.LocalAddr().String()
+}
+
+// Close implements C.Listener, the connections of the clients are closed too
+func (l *Listener) Close() error {
+	l.closed.Store(true)
+	err := l.ln.Close()
+	l.mux.Lock()
+	for conn := range l.conns {
+		conn.CloseWithError(0, "")
+	}
+	l.mux.Unlock()
+	l.pc.Close()
+	return err
+}
+
+func New(option Option, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) (*Listener, error) {
+	users := map[[16]byte]string{}
+	for id, password := range option.Users {
+		u, err := uuid.FromString(id)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", id, err)
+		}
+		users[u] = password
+	}
+	cert, err := quicconn.LoadKeyPair(option.Certificate, option.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	alpn := option.ALPN
+	if len(alpn) == 0 {
+		alpn = []string{"h3"}
+	}
+
+	pc, err := net.ListenPacket("udp", option.Listen)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := quic.Listen(pc, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   alpn,
+	}, quicconn.ServerConfig())
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	l := &Listener{
+		option: option,
+		users:  users,
+		pc:     pc,
+		ln:     ln,
+		tcpIn:  tcpIn,
+		udpIn:  udpIn,
+		conns:  map[quic.Connection]struct{}{},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				if l.closed.Load() {
+					return
+				}
+				log.Dedupln(log.WARNING, option.Name, "[TUIC] %s accept error: %s", option.Name, err.Error())
+				continue
+			}
+			go l.serve(conn)
+		}
+	}()
+	return l, nil
+}
+
+func (l *Listener) serve(conn quic.Connection) {
+	l.mux.Lock()
+	if l.closed.Load() {
+		l.mux.Unlock()
+		conn.CloseWithError(0, "")
+		return
+	}
+	l.conns[conn] = struct{}{}
+	l.mux.Unlock()
+	defer func() {
+		l.mux.Lock()
+		delete(l.conns, conn)
+		l.mux.Unlock()
+	}()
+
+	s := &session{
+		listener: l,
+		conn:     conn,
+		authed:   make(chan struct{}),
+		assocs:   map[uint16]*association{},
+	}
+	go s.acceptUniStreams()
+	go s.acceptStreams()
+	go s.receiveDatagrams()
+
+	select {
+	case <-s.authed:
+	case <-conn.Context().Done():
+	case <-time.After(authTimeout):
+		log.Dedupln(log.WARNING, l.option.Name, "[TUIC] %s authentication timeout from %s", l.option.Name, conn.RemoteAddr().String())
+		conn.CloseWithError(errorCodeAuthTimeout, "authentication timeout")
+	}
+	<-conn.Context().Done()
+}
+
+// session is a quic connection of a client
+type session struct {
+	listener *Listener
+	conn     quic.Connection
+	authOnce sync.Once
+	authed   chan struct{}
+
+	mux    sync.Mutex
+	assocs map[uint16]*association
+}
+
+// association is a udp session, the packets are sent back in the relay mode they came in
+type association struct {
+	defrag   tuic.Defragger
+	mode     string
+	packetID uint16
+}
+
+func (s *session) waitAuth() bool {
+	select {
+	case <-s.authed:
+		return true
+	case <-s.conn.Context().Done():
+		return false
+	}
+}
+
+func (s *session) authenticate(r io.Reader) {
+	auth, err := tuic.ReadAuthenticate(r)
+	if err != nil {
+		return
+	}
+	opt := s.listener.option
+	password, ok := s.listener.users[auth.UUID]
+	if ok {
+		token, err := tuic.Token(s.conn.ConnectionState().TLS, auth.UUID, password)
+		ok = err == nil && subtle.ConstantTimeCompare(token, auth.Token) == 1
+	}
+	if !ok {
+		log.Dedupln(log.WARNING, opt.Name, "[TUIC] %s authentication failed from %s", opt.Name, s.conn.RemoteAddr().String())
+		s.conn.CloseWithError(errorCodeAuthFailed, "authentication failed")
+		return
+	}
+	s.authOnce.Do(func() {
+		close(s.authed)
+		log.Debugln("[TUIC] %s user %s connected from %s", opt.Name, uuid.UUID(auth.UUID).String(), s.conn.RemoteAddr().String())
+	})
+}
+
+func (s *session) acceptUniStreams() {
+	for {
+		stream, err := s.conn.AcceptUniStream(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			cmd, err := tuic.ReadCommand(stream)
+			if err != nil {
+				stream.CancelRead(0)
+				return
+			}
+			switch cmd {
+			case tuic.CommandAuthenticate:
+				s.authenticate(stream)
+			case tuic.CommandPacket:
+				if s.waitAuth() {
+					s.handlePacket(stream, tuic.RelayQUIC)
+				}
+			case tuic.CommandDissociate:
+				id, err := tuic.ReadAssocID(stream)
+				if err == nil && s.waitAuth() {
+					s.mux.Lock()
+					delete(s.assocs, id)
+					s.mux.Unlock()
+				}
+			}
+			stream.CancelRead(0)
+		}()
+	}
+}
+
+func (s *session) acceptStreams() {
+	for {
+		stream, err := s.conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go s.handleStream(stream)
+	}
+}
+
+func (s *session) handleStream(stream quic.Stream) {
+	cmd, err := tuic.ReadCommand(stream)
+	if err != nil || cmd != tuic.CommandConnect || !s.waitAuth() {
+		stream.CancelRead(0)
+		stream.Close()
+		return
+	}
+	target, err := tuic.ReadAddress(stream)
+	if err != nil || target == nil {
+		stream.CancelRead(0)
+		stream.Close()
+		return
+	}
+
+	conn := quicconn.NewStreamConn(stream, s.conn.LocalAddr(), s.conn.RemoteAddr(), nil)
+	s.listener.tcpIn <- inbound.NewSocket(target, conn, C.TUIC)
+}
+
+func (s *session) receiveDatagrams() {
+	for {
+		b, err := s.conn.ReceiveDatagram(context.Background())
+		if err != nil {
+			return
+		}
+		r := bytes.NewReader(b)
+		cmd, err := tuic.ReadCommand(r)
+		if err != nil || cmd != tuic.CommandPacket {
+			// a heartbeat only keeps the connection alive
+			continue
+		}
+		if s.waitAuth() {
+			s.handlePacket(r, tuic.RelayNative)
+		}
+	}
+}
+
+func (s *session) handlePacket(r io.Reader, mode string) {
+	p, err := tuic.ReadPacket(r)
+	if err != nil {
+		return
+	}
+
+	s.mux.Lock()
+	assoc, ok := s.assocs[p.AssocID]
+	if !ok {
+		assoc = &association{}
+		s.assocs[p.AssocID] = assoc
+	}
+	assoc.mode = mode
+	full := assoc.defrag.Feed(p)
+	s.mux.Unlock()
+	if full == nil || full.Addr == nil {
+		return
+	}
+
+	packet := &packet{
+		session: s,
+		assoc:   full.AssocID,
+		addr:    full.Addr,
+		local:   &quicconn.SessionAddr{Addr: s.conn.RemoteAddr(), ID: uint32(full.AssocID)},
+		payload: full.Data,
+	}
+	adapter := inbound.NewPacket(full.Addr, s.conn.LocalAddr(), packet, C.TUIC)
+	if udpAddr, ok := s.conn.RemoteAddr().(*net.UDPAddr); ok {
+		adapter.Metadata().SrcIP = udpAddr.IP
+		adapter.Metadata().SrcPort = strconv.Itoa(udpAddr.Port)
+	}
+	select {
+	case s.listener.udpIn <- adapter:
+	default:
+	}
+}
+
+func (s *session) writeBack(id uint16, addr socks5.Addr, b []byte) error {
+	s.mux.Lock()
+	assoc, ok := s.assocs[id]
+	if !ok {
+		s.mux.Unlock()
+		return net.ErrClosed
+	}
+	assoc.packetID++
+	p := &tuic.Packet{AssocID: id, PacketID: assoc.packetID, FragTotal: 1, Addr: addr, Data: b}
+	mode := assoc.mode
+	s.mux.Unlock()
+
+	return tuic.SendPacket(s.conn, mode, p)
+}
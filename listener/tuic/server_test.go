@@ -0,0 +1,160 @@
+package tuic
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport/socks5"
+	"github.com/Dreamacro/clash/transport/tuic"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var user = uuid.Must(uuid.FromString("b831381d-6324-4d53-ad4f-8cda48b30811"))
+
+func newKeyPair(t *testing.T) (certPEM, keyPEM string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func newServer(t *testing.T) (*Listener, chan C.ConnContext, chan *inbound.PacketAdapter) {
+	certPEM, keyPEM := newKeyPair(t)
+	tcpIn, udpIn := make(chan C.ConnContext, 1), make(chan *inbound.PacketAdapter, 1)
+	l, err := New(Option{
+		Name:        "test",
+		Listen:      "127.0.0.1:0",
+		Certificate: certPEM,
+		PrivateKey:  keyPEM,
+		Users:       map[string]string{user.String(): "secret"},
+	}, tcpIn, udpIn)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	return l, tcpIn, udpIn
+}
+
+func dial(t *testing.T, l *Listener, password, mode string) *tuic.Client {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr, err := net.ResolveUDPAddr("udp", l.Address())
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := tuic.NewClient(ctx, pc, addr, tuic.Option{
+		UUID:           user,
+		Password:       password,
+		ServerName:     "localhost",
+		SkipCertVerify: true,
+		UDPRelayMode:   mode,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestListener_TCP(t *testing.T) {
+	l, tcpIn, _ := newServer(t)
+
+	// tuic doesn't answer the authentication, the server closes the connection
+	bad := dial(t, l, "wrong", tuic.RelayNative)
+	select {
+	case <-bad.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection with a wrong password kept open")
+	}
+
+	client := dial(t, l, "secret", tuic.RelayNative)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := client.DialTCP(ctx, socks5.ParseAddr("example.com:443"))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	var connCtx C.ConnContext
+	select {
+	case connCtx = <-tcpIn:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection from the listener")
+	}
+	metadata := connCtx.Metadata()
+	assert.Equal(t, C.TUIC, metadata.Type)
+	assert.Equal(t, "example.com", metadata.Host)
+	assert.Equal(t, "443", metadata.DstPort)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(connCtx.Conn(), buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	_, err = connCtx.Conn().Write([]byte("pong"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(buf))
+}
+
+func TestListener_UDP(t *testing.T) {
+	for _, mode := range []string{tuic.RelayNative, tuic.RelayQUIC} {
+		t.Run(mode, func(t *testing.T) {
+			l, _, udpIn := newServer(t)
+			client := dial(t, l, "secret", mode)
+
+			pc, err := client.ListenUDP()
+			require.NoError(t, err)
+			defer pc.Close()
+
+			target := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 53}
+			payload := make([]byte, 3000)
+			_, err = pc.WriteTo(payload, target)
+			require.NoError(t, err)
+
+			var packet *inbound.PacketAdapter
+			select {
+			case packet = <-udpIn:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no packet from the listener")
+			}
+			assert.Equal(t, C.TUIC, packet.Metadata().Type)
+			assert.Equal(t, "1.1.1.1", packet.Metadata().DstIP.String())
+			assert.Equal(t, "127.0.0.1", packet.Metadata().SrcIP.String())
+			assert.Len(t, packet.Data(), 3000)
+
+			_, err = packet.WriteBack([]byte("answer"), nil)
+			require.NoError(t, err)
+			pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 64)
+			n, from, err := pc.ReadFrom(buf)
+			require.NoError(t, err)
+			assert.Equal(t, "answer", string(buf[:n]))
+			assert.Equal(t, target.String(), from.String())
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package tun
+
+import (
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	// a packet waits at most udpBatchWindow for more packets of its session
+	udpBatchWindow = 500 * time.Microsecond
+	udpBatchSize   = 8
+)
+
+type udpBatch struct {
+	head  *fakeConn
+	timer *time.Timer
+}
+
+// udpCoalescer merges consecutive packets of the same session into one enqueue,
+// so a burst of datagrams costs the tunnel queue a single slot
+type udpCoalescer struct {
+	mux     sync.Mutex
+	pending map[stack.TransportEndpointID]*udpBatch
+	window  time.Duration
+	size    int
+	emit    func(*fakeConn)
+}
+
+func newUDPCoalescer(window time.Duration, size int, emit func(*fakeConn)) *udpCoalescer {
+	return &udpCoalescer{
+		pending: map[stack.TransportEndpointID]*udpBatch{},
+		window:  window,
+		size:    size,
+		emit:    emit,
+	}
+}
+
+func (c *udpCoalescer) add(packet *fakeConn) {
+	c.mux.Lock()
+	batch, ok := c.pending[packet.id]
+	if !ok {
+		batch = &udpBatch{head: packet}
+		c.pending[packet.id] = batch
+		batch.timer = time.AfterFunc(c.window, func() {
+			c.flush(packet.id, batch)
+		})
+		c.mux.Unlock()
+		return
+	}
+
+	batch.head.batch = append(batch.head.batch, packet.payload)
+	if len(batch.head.batch)+1 < c.size {
+		c.mux.Unlock()
+		return
+	}
+
+	// full, don't wait for the window
+	batch.timer.Stop()
+	delete(c.pending, packet.id)
+	c.mux.Unlock()
+	c.emit(batch.head)
+}
+
+func (c *udpCoalescer) flush(id stack.TransportEndpointID, batch *udpBatch) {
+	c.mux.Lock()
+	if c.pending[id] != batch {
+		c.mux.Unlock()
+		return
+	}
+	delete(c.pending, id)
+	c.mux.Unlock()
+	c.emit(batch.head)
+}
@@ -0,0 +1,114 @@
+package tun
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func testEndpoint(port uint16) stack.TransportEndpointID {
+	return stack.TransportEndpointID{
+		LocalAddress:  tcpip.AddrFrom4([4]byte{1, 1, 1, 1}),
+		LocalPort:     443,
+		RemoteAddress: tcpip.AddrFrom4([4]byte{198, 18, 0, 1}),
+		RemotePort:    port,
+	}
+}
+
+func TestUDPCoalescer(t *testing.T) {
+	emitted := make(chan *fakeConn, 8)
+	c := newUDPCoalescer(20*time.Millisecond, 3, func(packet *fakeConn) {
+		emitted <- packet
+	})
+
+	for i := byte(0); i < 4; i++ {
+		c.add(&fakeConn{id: testEndpoint(1000), payload: []byte{i}})
+	}
+	c.add(&fakeConn{id: testEndpoint(2000), payload: []byte{9}})
+
+	// a full batch is sent without waiting for the window
+	select {
+	case packet := <-emitted:
+		assert.Equal(t, []byte{0}, packet.Data())
+		assert.Equal(t, [][]byte{{1}, {2}}, packet.Batch())
+	case <-time.After(10 * time.Millisecond):
+		t.Fatal("full batch is delayed")
+	}
+
+	// the rest leaves when the window ends
+	got := map[uint16]*fakeConn{}
+	for i := 0; i < 2; i++ {
+		select {
+		case packet := <-emitted:
+			got[packet.id.RemotePort] = packet
+		case <-time.After(time.Second):
+			t.Fatal("window not flushed")
+		}
+	}
+	assert.Equal(t, []byte{3}, got[1000].Data())
+	assert.Empty(t, got[1000].Batch())
+	assert.Equal(t, []byte{9}, got[2000].Data())
+}
+
+// BenchmarkUDPEnqueue compares handing every datagram to the tunnel queue with
+// coalescing them per session, the consumer does a nat lookup per enqueue like handleUDPConn
+func BenchmarkUDPEnqueue(b *testing.B) {
+	const sessions = 4
+
+	run := func(b *testing.B, enqueue func(emit func(*fakeConn)) func(*fakeConn)) {
+		queue := make(chan *inbound.PacketAdapter, 200)
+		emit := func(packet *fakeConn) {
+			target := getAddr(packet.id)
+			queue <- inbound.NewPacket(target, target.UDPAddr(), packet, C.TUN)
+		}
+		add := enqueue(emit)
+
+		var (
+			mux sync.Mutex
+			nat = map[string]int{}
+			wg  sync.WaitGroup
+		)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for received := 0; received < b.N; {
+				packet := <-queue
+				mux.Lock()
+				nat[packet.LocalAddr().String()]++
+				mux.Unlock()
+				received += 1 + len(packet.Batch())
+			}
+		}()
+
+		payload := make([]byte, 1200)
+		ids := make([]stack.TransportEndpointID, sessions)
+		for i := range ids {
+			ids[i] = testEndpoint(uint16(1000 + i))
+		}
+
+		b.SetBytes(int64(len(payload)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			add(&fakeConn{id: ids[i/udpBatchSize%sessions], payload: payload})
+		}
+		wg.Wait()
+	}
+
+	b.Run("Direct", func(b *testing.B) {
+		run(b, func(emit func(*fakeConn)) func(*fakeConn) {
+			return emit
+		})
+	})
+	b.Run("Coalesced", func(b *testing.B) {
+		run(b, func(emit func(*fakeConn)) func(*fakeConn) {
+			return newUDPCoalescer(udpBatchWindow, udpBatchSize, emit).add
+		})
+	})
+}
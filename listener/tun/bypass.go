@@ -0,0 +1,114 @@
+package tun
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+
+	N "github.com/Dreamacro/clash/common/net"
+	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/component/trie"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// BypassOptions are the destinations from the tun that skip the tunnel, they are dialed
+// directly on the outbound interface, or refused with Reject. A destination is matched by its
+// longest prefix of Exclude and Include, one of neither is excluded when Include isn't empty.
+type BypassOptions struct {
+	Exclude []netip.Prefix
+	Include []netip.Prefix
+	Reject  bool
+}
+
+// bypassTable is the trie of BypassOptions, a prefix carries whether it's excluded
+type bypassTable struct {
+	opts *BypassOptions
+	trie *trie.IPTrie
+	// the destinations out of every prefix, excluded when there's an Include
+	rest bool
+}
+
+func newBypassTable(opts *BypassOptions) *bypassTable {
+	b := &bypassTable{opts: opts, trie: trie.NewIPTrie(), rest: len(opts.Include) > 0}
+	// an include and an exclude of the same prefix, the include is kept
+	for _, prefix := range opts.Include {
+		b.trie.Insert(prefix, false)
+	}
+	for _, prefix := range opts.Exclude {
+		b.trie.Insert(prefix, true)
+	}
+	return b
+}
+
+// excludes tells whether addr skips the tunnel, in the steps of its prefix length
+func (b *bypassTable) excludes(addr tcpip.Address) bool {
+	if b == nil {
+		return false
+	}
+	var ip netip.Addr
+	if addr.Len() == 4 {
+		ip = netip.AddrFrom4(addr.As4())
+	} else {
+		ip = netip.AddrFrom16(addr.As16())
+	}
+	if excluded, ok := b.trie.Search(ip).(bool); ok {
+		return excluded
+	}
+	return b.rest
+}
+
+// ParseBypassCIDR parses a prefix of exclude-cidr or include-cidr, an ipv4-mapped ipv6 one is
+// taken as its ipv4 prefix
+func ParseBypassCIDR(cidr string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return prefix, err
+	}
+	if addr := prefix.Addr(); addr.Is4In6() {
+		if prefix.Bits() < 96 {
+			return prefix, fmt.Errorf("%s: an ipv4-mapped prefix is at least 96 bits", cidr)
+		}
+		prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// Bypass return the options of the bypass, nil when every destination goes to the tunnel
+func (t *tunAdapter) Bypass() *BypassOptions {
+	if b := t.bypass.Load(); b != nil {
+		return b.opts
+	}
+	return nil
+}
+
+// SetBypass sets the destinations of the new connections and udp packets skipping the
+// tunnel, nil turns it off
+func (t *tunAdapter) SetBypass(opts *BypassOptions) {
+	if opts == nil || len(opts.Exclude)+len(opts.Include) == 0 {
+		t.bypass.Store(nil)
+		return
+	}
+	t.bypass.Store(newBypassTable(opts))
+}
+
+// bypassTCP relays conn accepted for an excluded destination through a direct connection
+func (t *tunAdapter) bypassTCP(id stack.TransportEndpointID, conn net.Conn) {
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), C.DefaultTCPTimeout)
+	defer cancel()
+	dst := net.JoinHostPort(id.LocalAddress.String(), strconv.Itoa(int(id.LocalPort)))
+	remote, err := dialer.DialContext(ctx, "tcp", dst)
+	if err != nil {
+		log.Debugln("[TUN] bypass %s --> %s: %v", conn.RemoteAddr(), dst, err)
+		return
+	}
+	defer remote.Close()
+	N.Relay(conn, remote)
+}
@@ -0,0 +1,177 @@
+package tun
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func mustPrefixes(t *testing.T, cidrs ...string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := ParseBypassCIDR(cidr)
+		require.NoError(t, err)
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+func addr(s string) tcpip.Address {
+	return tcpip.AddrFromSlice(netip.MustParseAddr(s).AsSlice())
+}
+
+func TestBypassTable(t *testing.T) {
+	// the longest prefix decides
+	b := newBypassTable(&BypassOptions{
+		Exclude: mustPrefixes(t, "192.168.0.0/16", "192.168.1.128/25", "fd00::/8"),
+		Include: mustPrefixes(t, "192.168.1.0/24", "fd00:1::/32"),
+	})
+	assert.True(t, b.excludes(addr("192.168.2.1")))
+	assert.False(t, b.excludes(addr("192.168.1.1")))
+	assert.True(t, b.excludes(addr("192.168.1.200")))
+	assert.True(t, b.excludes(addr("fd00::1")))
+	assert.False(t, b.excludes(addr("fd00:1::1")))
+	// with an include, a destination of neither skips the tunnel
+	assert.True(t, b.excludes(addr("1.1.1.1")))
+	assert.True(t, b.excludes(addr("2001:db8::1")))
+
+	// the ipv4-mapped ipv6 address of a socket of both families
+	assert.True(t, b.excludes(addr("::ffff:192.168.2.1")))
+	assert.False(t, b.excludes(addr("::ffff:192.168.1.1")))
+
+	// without an include, a destination out of the excludes goes to the tunnel
+	b = newBypassTable(&BypassOptions{Exclude: mustPrefixes(t, "10.0.0.0/8")})
+	assert.True(t, b.excludes(addr("10.1.2.3")))
+	assert.False(t, b.excludes(addr("192.168.1.1")))
+
+	// the include wins over an exclude of the same prefix
+	b = newBypassTable(&BypassOptions{Exclude: mustPrefixes(t, "10.0.0.0/8"), Include: mustPrefixes(t, "10.0.0.0/8")})
+	assert.False(t, b.excludes(addr("10.1.2.3")))
+
+	var none *bypassTable
+	assert.False(t, none.excludes(addr("10.1.2.3")))
+
+	ip := addr("192.168.2.1")
+	assert.Zero(t, testing.AllocsPerRun(100, func() { b.excludes(ip) }))
+}
+
+func TestParseBypassCIDR(t *testing.T) {
+	prefix, err := ParseBypassCIDR("::ffff:192.168.0.0/112")
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParsePrefix("192.168.0.0/16"), prefix)
+
+	prefix, err = ParseBypassCIDR("10.1.2.3/8")
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParsePrefix("10.0.0.0/8"), prefix)
+
+	_, err = ParseBypassCIDR("::ffff:0:0/90")
+	assert.Error(t, err)
+	_, err = ParseBypassCIDR("192.168.0.0")
+	assert.Error(t, err)
+}
+
+func TestUDPSession_WriteDirect(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+	go func() {
+		buf := make([]byte, 64)
+		n, from, err := server.ReadFrom(buf)
+		if err == nil {
+			server.WriteTo(buf[:n], from)
+		}
+	}()
+
+	ipstack, linkEP := udpStack(t)
+	defer ipstack.Close()
+	sessions := newUDPSessions(ipstack, udpSessionOptions{timeout: time.Minute, maxSessions: 2})
+	serverAddr := server.LocalAddr().(*net.UDPAddr)
+	id, pkt := udpFlow(5000)
+	id.LocalAddress, id.LocalPort = addr("127.0.0.1"), uint16(serverAddr.Port)
+	session := sessions.get(id, pkt)
+	require.NoError(t, session.writeDirect([]byte("ping"), id))
+
+	// the reply comes back to the tun from the destination
+	var reply stack.PacketBufferPtr
+	for deadline := time.Now().Add(5 * time.Second); reply == nil && time.Now().Before(deadline); {
+		if reply = linkEP.Read(); reply == nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	require.NotNil(t, reply)
+	ip := header.IPv4(reply.ToView().AsSlice())
+	reply.DecRef()
+	assert.Equal(t, id.LocalAddress, ip.SourceAddress())
+	assert.Equal(t, uint16(serverAddr.Port), header.UDP(ip.Payload()).SourcePort())
+	assert.Equal(t, []byte("ping"), header.UDP(ip.Payload()).Payload())
+
+	// the socket goes with the session
+	sessions.close()
+	assert.ErrorIs(t, session.writeDirect([]byte("ping"), id), errUDPSessionClosed)
+}
+
+func TestTunAdapter_BypassTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			conn.Write([]byte("direct"))
+			conn.Close()
+		}
+	}()
+
+	client, conn := net.Pipe()
+	id := stack.TransportEndpointID{LocalAddress: addr("127.0.0.1"), LocalPort: uint16(l.Addr().(*net.TCPAddr).Port)}
+	go (&tunAdapter{}).bypassTCP(id, conn)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 6)
+	_, err = client.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "direct", string(buf))
+	client.Close()
+}
+
+// TestTunProxy_RejectExcluded refuses a connection to an excluded destination through a tun
+// device and takes one to another, it needs CAP_NET_ADMIN
+func TestTunProxy_RejectExcluded(t *testing.T) {
+	tcpIn := make(chan C.ConnContext, 1)
+	adapter, err := NewTunProxy("dev://clashbypass?addr=10.251.0.1/24", tcpIn, make(chan *inbound.PacketAdapter))
+	if err != nil {
+		t.Skipf("open tun: %s", err)
+	}
+	defer adapter.Close()
+	opts := &BypassOptions{Exclude: mustPrefixes(t, "10.251.0.0/25"), Reject: true}
+	adapter.SetBypass(opts)
+	assert.Same(t, opts, adapter.Bypass())
+
+	_, err = net.DialTimeout("tcp", "10.251.0.80:80", 5*time.Second)
+	assert.Error(t, err)
+
+	go func() {
+		if conn, err := net.DialTimeout("tcp", "10.251.0.200:80", 5*time.Second); err == nil {
+			conn.Read(make([]byte, 1))
+			conn.Close()
+		}
+	}()
+	select {
+	case conn := <-tcpIn:
+		assert.Equal(t, "10.251.0.200", conn.Metadata().DstIP.String())
+		conn.Conn().Close()
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no tcp connection from the tun")
+	}
+
+	adapter.SetBypass(nil)
+	assert.Nil(t, adapter.Bypass())
+}
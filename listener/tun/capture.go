@@ -0,0 +1,99 @@
+package tun
+
+import (
+	"net/netip"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/tunnel/statistic"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/nested"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// captureEndpoint hands the packets of the device both ways to the captures of the
+// controller, so a tun connection is captured as it is on the wire
+type captureEndpoint struct {
+	nested.Endpoint
+}
+
+func newCaptureEndpoint(child stack.LinkEndpoint) *captureEndpoint {
+	e := &captureEndpoint{}
+	e.Endpoint.Init(child, e)
+	return e
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher
+func (e *captureEndpoint) DeliverNetworkPacket(protocol tcpip.NetworkProtocolNumber, pkt stack.PacketBufferPtr) {
+	if statistic.DefaultManager.TappingPackets() {
+		packet, _ := pkt.Data().PullUp(pkt.Data().Size())
+		tapPacket(packet)
+	}
+	e.Endpoint.DeliverNetworkPacket(protocol, pkt)
+}
+
+// WritePackets implements stack.LinkEndpoint
+func (e *captureEndpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Error) {
+	if statistic.DefaultManager.TappingPackets() {
+		for _, pkt := range pkts.AsSlice() {
+			view := pkt.ToView()
+			tapPacket(view.AsSlice())
+			view.Release()
+		}
+	}
+	return e.Endpoint.WritePackets(pkts)
+}
+
+// tapPacket passes a tcp or udp packet to the captures with its addresses, the ipv6 packets
+// with extension headers and the fragments but the first are left out
+func tapPacket(packet []byte) {
+	if len(packet) == 0 {
+		return
+	}
+
+	var (
+		src, dst  tcpip.Address
+		transport tcpip.TransportProtocolNumber
+		payload   []byte
+	)
+	switch header.IPVersion(packet) {
+	case header.IPv4Version:
+		ip := header.IPv4(packet)
+		if !ip.IsValid(len(packet)) || ip.FragmentOffset() != 0 {
+			return
+		}
+		src, dst, transport, payload = ip.SourceAddress(), ip.DestinationAddress(), ip.TransportProtocol(), ip.Payload()
+	case header.IPv6Version:
+		ip := header.IPv6(packet)
+		if !ip.IsValid(len(packet)) {
+			return
+		}
+		src, dst, transport, payload = ip.SourceAddress(), ip.DestinationAddress(), ip.TransportProtocol(), ip.Payload()
+	default:
+		return
+	}
+
+	var network C.NetWork
+	var srcPort, dstPort uint16
+	switch transport {
+	case header.TCPProtocolNumber:
+		if len(payload) < header.TCPMinimumSize {
+			return
+		}
+		tcp := header.TCP(payload)
+		network, srcPort, dstPort = C.TCP, tcp.SourcePort(), tcp.DestinationPort()
+	case header.UDPProtocolNumber:
+		if len(payload) < header.UDPMinimumSize {
+			return
+		}
+		udp := header.UDP(payload)
+		network, srcPort, dstPort = C.UDP, udp.SourcePort(), udp.DestinationPort()
+	default:
+		return
+	}
+
+	srcAddr, _ := netip.AddrFromSlice(src.AsSlice())
+	dstAddr, _ := netip.AddrFromSlice(dst.AsSlice())
+	statistic.DefaultManager.TapPacket(network, netip.AddrPortFrom(srcAddr, srcPort), netip.AddrPortFrom(dstAddr, dstPort), packet)
+}
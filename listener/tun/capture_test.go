@@ -0,0 +1,45 @@
+package tun
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/Dreamacro/clash/adapter/outbound"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/tunnel/statistic"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTapPacket(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	metadata := &C.Metadata{
+		NetWork:   C.TCP,
+		Type:      C.TUN,
+		SrcIP:     net.ParseIP("198.18.0.1"),
+		SrcPort:   "40000",
+		DstIP:     net.ParseIP("1.1.1.1"),
+		DstPort:   "443",
+		OriginDst: netip.MustParseAddrPort("1.1.1.1:443"),
+	}
+	conn := statistic.NewTCPTracker(outbound.NewConn(client, outbound.NewDirect()), statistic.DefaultManager, metadata, nil, nil)
+	defer conn.Close()
+
+	c, err := statistic.DefaultManager.StartCapture(statistic.CaptureOption{ConnectionID: conn.ID()})
+	require.NoError(t, err)
+	defer statistic.DefaultManager.RemoveCapture(c.ID())
+
+	syn := tcpSyn(1460)
+	tapPacket(syn)
+	tapPacket(syn[:10])
+	c.Stop()
+	<-c.Done()
+
+	data, err := os.ReadFile(c.Path())
+	require.NoError(t, err)
+	assert.Contains(t, string(data), string(syn))
+}
@@ -0,0 +1,106 @@
+package dev
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Addressing is the point-to-point addressing from the query of a device url,
+// addr=10.0.0.2&peer=10.0.0.1&prefix=30&addr6=fdfe::2/126, the prefix may be given with the
+// addr as in addr=198.18.0.1/16. It is set on the device as soon as it's attached, the prefix
+// is 32 when not given, the one of addr6 is 64. ip6 is the former name of addr6, with its
+// prefix required.
+type Addressing struct {
+	Addr   netip.Addr
+	Peer   netip.Addr
+	Prefix int
+	IP6    netip.Prefix
+}
+
+func (a Addressing) empty() bool {
+	return !a.Addr.IsValid() && !a.IP6.IsValid()
+}
+
+// MarshalJSON omits the unset addresses
+func (a Addressing) MarshalJSON() ([]byte, error) {
+	m := map[string]any{}
+	if a.Addr.IsValid() {
+		m["addr"] = a.Addr.String()
+		m["prefix"] = a.Prefix
+	}
+	if a.Peer.IsValid() {
+		m["peer"] = a.Peer.String()
+	}
+	if a.IP6.IsValid() {
+		m["ip6"] = a.IP6.String()
+	}
+	return json.Marshal(m)
+}
+
+func parseAddressing(query url.Values) (a Addressing, err error) {
+	addr, peer, prefix, ip6 := query.Get("addr"), query.Get("peer"), query.Get("prefix"), query.Get("ip6")
+	addr6 := query.Get("addr6")
+
+	if addr == "" {
+		if peer != "" {
+			return a, errors.New("peer requires addr")
+		}
+		if prefix != "" {
+			return a, errors.New("prefix requires addr")
+		}
+	} else {
+		a.Prefix = 32
+		if strings.Contains(addr, "/") {
+			p, err := netip.ParsePrefix(addr)
+			if err != nil || !p.Addr().Is4() {
+				return a, fmt.Errorf("addr %s is not an IPv4 address, IPv6 goes to addr6", addr)
+			}
+			if prefix != "" {
+				return a, fmt.Errorf("addr %s has a prefix, prefix %s is one too many", addr, prefix)
+			}
+			if p.Bits() < 1 {
+				return a, fmt.Errorf("prefix of addr %s is out of range 1-32", addr)
+			}
+			a.Addr, a.Prefix = p.Addr(), p.Bits()
+		} else if a.Addr, err = netip.ParseAddr(addr); err != nil || !a.Addr.Is4() {
+			return a, fmt.Errorf("addr %s is not an IPv4 address, IPv6 goes to addr6", addr)
+		}
+
+		if prefix != "" {
+			if a.Prefix, err = strconv.Atoi(prefix); err != nil || a.Prefix < 1 || a.Prefix > 32 {
+				return a, fmt.Errorf("prefix %s is out of range 1-32", prefix)
+			}
+		}
+
+		if peer != "" {
+			if a.Peer, err = netip.ParseAddr(peer); err != nil || !a.Peer.Is4() {
+				return a, fmt.Errorf("peer %s is not an IPv4 address", peer)
+			}
+			if a.Peer == a.Addr {
+				return a, fmt.Errorf("peer %s is the same as addr", peer)
+			}
+		}
+	}
+
+	if addr6 != "" {
+		if ip6 != "" {
+			return a, errors.New("addr6 and ip6 are the same option, ip6 is its former name")
+		}
+		if !strings.Contains(addr6, "/") {
+			addr6 += "/64"
+		}
+		if a.IP6, err = netip.ParsePrefix(addr6); err != nil || !a.IP6.Addr().Is6() || a.IP6.Addr().Is4In6() {
+			return a, fmt.Errorf("addr6 %s is not an IPv6 address like fd00:c1a5::1[/64]", query.Get("addr6"))
+		}
+	} else if ip6 != "" {
+		if a.IP6, err = netip.ParsePrefix(ip6); err != nil || !a.IP6.Addr().Is6() || a.IP6.Addr().Is4In6() {
+			return a, fmt.Errorf("ip6 %s is not an IPv6 address with its prefix like fdfe::2/126", ip6)
+		}
+	}
+	return a, nil
+}
@@ -0,0 +1,65 @@
+package dev
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddressing(t *testing.T) {
+	query, _ := url.ParseQuery("addr=10.0.0.2&peer=10.0.0.1&prefix=30&ip6=fdfe::2/126")
+	a, err := parseAddressing(query)
+	require.NoError(t, err)
+	buf, _ := json.Marshal(a)
+	assert.JSONEq(t, `{"addr":"10.0.0.2","peer":"10.0.0.1","prefix":30,"ip6":"fdfe::2/126"}`, string(buf))
+
+	query, _ = url.ParseQuery("addr=10.0.0.2")
+	a, err = parseAddressing(query)
+	require.NoError(t, err)
+	assert.Equal(t, 32, a.Prefix)
+
+	query, _ = url.ParseQuery("addr=198.18.0.1/16")
+	a, err = parseAddressing(query)
+	require.NoError(t, err)
+	assert.Equal(t, "198.18.0.1", a.Addr.String())
+	assert.Equal(t, 16, a.Prefix)
+
+	query, _ = url.ParseQuery("addr6=fd00:c1a5::1")
+	a, err = parseAddressing(query)
+	require.NoError(t, err)
+	assert.Equal(t, "fd00:c1a5::1/64", a.IP6.String())
+
+	query, _ = url.ParseQuery("addr6=fd00:c1a5::1/120")
+	a, err = parseAddressing(query)
+	require.NoError(t, err)
+	assert.Equal(t, "fd00:c1a5::1/120", a.IP6.String())
+
+	a, err = parseAddressing(url.Values{})
+	require.NoError(t, err)
+	assert.True(t, a.empty())
+
+	for raw, msg := range map[string]string{
+		"peer=10.0.0.1":                "peer requires addr",
+		"prefix=30":                    "prefix requires addr",
+		"addr=10.0.0.2&prefix=33":      "prefix 33 is out of range 1-32",
+		"addr=10.0.0.2&prefix=0":       "prefix 0 is out of range 1-32",
+		"addr=fdfe::2":                 "addr fdfe::2 is not an IPv4 address, IPv6 goes to addr6",
+		"addr=fdfe::2/126":             "addr fdfe::2/126 is not an IPv4 address, IPv6 goes to addr6",
+		"addr=10.0.0.2/30&prefix=30":   "addr 10.0.0.2/30 has a prefix, prefix 30 is one too many",
+		"addr=10.0.0.2/0":              "prefix of addr 10.0.0.2/0 is out of range 1-32",
+		"addr=10.0.0.2&peer=foo":       "peer foo is not an IPv4 address",
+		"addr=10.0.0.2&peer=10.0.0.2":  "peer 10.0.0.2 is the same as addr",
+		"ip6=fdfe::2":                  "ip6 fdfe::2 is not an IPv6 address with its prefix like fdfe::2/126",
+		"ip6=10.0.0.2/30":              "ip6 10.0.0.2/30 is not an IPv6 address with its prefix like fdfe::2/126",
+		"addr6=10.0.0.2":               "addr6 10.0.0.2 is not an IPv6 address like fd00:c1a5::1[/64]",
+		"addr6=fd00::1/129":            "addr6 fd00::1/129 is not an IPv6 address like fd00:c1a5::1[/64]",
+		"addr6=fd00::1&ip6=fd00::1/64": "addr6 and ip6 are the same option, ip6 is its former name",
+	} {
+		query, _ := url.ParseQuery(raw)
+		_, err := parseAddressing(query)
+		assert.EqualError(t, err, msg, raw)
+	}
+}
@@ -0,0 +1,162 @@
+//go:build linux || android
+// +build linux android
+
+package dev
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/Dreamacro/clash/log"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// autoRoute is the autoroute option of a dev:// url
+type autoRoute int
+
+const (
+	autoRouteOff autoRoute = iota
+	// autoRouteOn adds a default route through the device, a default route of the system
+	// is an error
+	autoRouteOn
+	// autoRouteForce routes the two halves of the address space through the device when
+	// the system has a default route, they win over it without removing it
+	autoRouteForce
+)
+
+const (
+	// autoRouteMetric is the metric of the default route added by autoroute, higher than
+	// the ones of dhcp and most setups, so a default route added later takes over
+	autoRouteMetric = 9000
+
+	// autoRouteProtocol tags the routes of autoroute, a route left by one of them isn't
+	// taken for a default route of the system and is replaced
+	autoRouteProtocol netlink.RouteProtocol = 0xc1
+)
+
+func parseAutoRoute(value string) (autoRoute, error) {
+	switch value {
+	case "", "false":
+		return autoRouteOff, nil
+	case "true":
+		return autoRouteOn, nil
+	case "force":
+		return autoRouteForce, nil
+	}
+	return autoRouteOff, fmt.Errorf("autoroute %s: expect true, false or force", value)
+}
+
+// defaultRoutes return the default routes of the main table but the ones of autoroute
+func defaultRoutes(family int) ([]netlink.Route, error) {
+	routes, err := netlink.RouteList(nil, family)
+	if err != nil {
+		return nil, err
+	}
+	var result []netlink.Route
+	for _, r := range routes {
+		if r.Protocol == autoRouteProtocol {
+			continue
+		}
+		if r.Dst == nil {
+			result = append(result, r)
+		} else if ones, _ := r.Dst.Mask.Size(); ones == 0 {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+func describeRoute(r netlink.Route) string {
+	name := fmt.Sprintf("if%d", r.LinkIndex)
+	if link, err := netlink.LinkByIndex(r.LinkIndex); err == nil {
+		name = link.Attrs().Name
+	}
+	if r.Gw != nil {
+		return fmt.Sprintf("via %s dev %s", r.Gw, name)
+	}
+	return "dev " + name
+}
+
+// addAutoRoutes routes the families addressed on the device through it, the routes are
+// kept for removeAutoRoutes
+func (t *tunLinux) addAutoRoutes(mode autoRoute) error {
+	link, err := netlink.LinkByName(t.name)
+	if err != nil {
+		return fmt.Errorf("find tun %s: %w", t.name, err)
+	}
+
+	type familyRoutes struct {
+		family int
+		all    *net.IPNet
+		halves []*net.IPNet
+	}
+	var families []familyRoutes
+	if t.addressing.Addr.IsValid() {
+		families = append(families, familyRoutes{
+			family: netlink.FAMILY_V4,
+			all:    &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+			halves: []*net.IPNet{
+				{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(1, 32)},
+				{IP: net.IPv4(128, 0, 0, 0).To4(), Mask: net.CIDRMask(1, 32)},
+			},
+		})
+	}
+	if t.addressing.IP6.IsValid() {
+		families = append(families, familyRoutes{
+			family: netlink.FAMILY_V6,
+			all:    &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+			halves: []*net.IPNet{
+				{IP: net.IPv6zero, Mask: net.CIDRMask(1, 128)},
+				{IP: net.ParseIP("8000::"), Mask: net.CIDRMask(1, 128)},
+			},
+		})
+	}
+
+	for _, f := range families {
+		existing, err := defaultRoutes(f.family)
+		if err != nil {
+			t.removeAutoRoutes()
+			return fmt.Errorf("list routes of tun %s: %w", t.name, err)
+		}
+
+		dsts := []*net.IPNet{f.all}
+		if len(existing) > 0 {
+			if mode != autoRouteForce {
+				t.removeAutoRoutes()
+				return fmt.Errorf("autoroute of tun %s: the default route %s exists, autoroute=force routes around it", t.name, describeRoute(existing[0]))
+			}
+			dsts = f.halves
+		}
+
+		for _, dst := range dsts {
+			route := netlink.Route{
+				LinkIndex: link.Attrs().Index,
+				Dst:       dst,
+				Scope:     netlink.SCOPE_LINK,
+				Priority:  autoRouteMetric,
+				Protocol:  autoRouteProtocol,
+			}
+			if err := netlink.RouteReplace(&route); err != nil {
+				t.removeAutoRoutes()
+				if errors.Is(err, unix.EPERM) {
+					return fmt.Errorf("add route %s to tun %s: %w, changing it needs CAP_NET_ADMIN", dst, t.name, err)
+				}
+				return fmt.Errorf("add route %s to tun %s: %w", dst, t.name, err)
+			}
+			t.routes = append(t.routes, route)
+		}
+	}
+	return nil
+}
+
+// removeAutoRoutes deletes the routes of addAutoRoutes, one gone already is skipped
+func (t *tunLinux) removeAutoRoutes() {
+	for i := range t.routes {
+		if err := netlink.RouteDel(&t.routes[i]); err != nil && !errors.Is(err, unix.ESRCH) {
+			log.Warnln("remove route %s of tun %s: %v", t.routes[i].Dst, t.name, err)
+		}
+	}
+	t.routes = nil
+}
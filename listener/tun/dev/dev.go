@@ -1,11 +1,30 @@
 package dev
 
-import "gvisor.dev/gvisor/pkg/tcpip/stack"
+import (
+	"net/url"
+
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
 
 // TunDevice is cross-platform tun interface
 type TunDevice interface {
 	Name() string
 	URL() string
+	// Addressing return the addresses set on the device from its url
+	Addressing() Addressing
+	// Stats return the counters of the packets read from and written to the device
+	Stats() DeviceStats
 	AsLinkEndpoint() (stack.LinkEndpoint, error)
 	Close()
+	// Wait waits for the read loops and the writer to exit after Close
+	Wait()
+}
+
+// OpenTunDevice return a TunDevice according a URL, mem:// is a MemoryTunDevice on every
+// platform and the other schemes are of the platform
+func OpenTunDevice(deviceURL url.URL) (TunDevice, error) {
+	if deviceURL.Scheme == "mem" {
+		return openMemoryDevice(deviceURL)
+	}
+	return openDevice(deviceURL)
 }
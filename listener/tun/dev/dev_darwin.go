@@ -4,24 +4,25 @@
 package dev
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/url"
 	"os"
+	"strconv"
 	"sync"
 	"syscall"
 	"unsafe"
 
-	"golang.org/x/net/ipv6"
 	"golang.org/x/sys/unix"
 
 	"github.com/Dreamacro/clash/common/pool"
 	"github.com/Dreamacro/clash/log"
+	"go.uber.org/atomic"
 	"gvisor.dev/gvisor/pkg/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
-	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
@@ -62,14 +63,17 @@ type tunDarwin struct {
 	url       string
 	name      string
 	tunFile   *os.File
-	linkCache *channel.Endpoint
+	linkCache *queueEndpoint
+	qlen      int
 	errors    chan error
-
-	closed   bool
-	stopOnce sync.Once
-	wg       sync.WaitGroup // wait for goroutines to stop
-
-	writeHandle *channel.NotificationHandle
+	counters  deviceCounters
+
+	closed atomic.Bool
+	// writeMux keeps Close from closing the file under a write of the writer
+	writeMux   sync.RWMutex
+	stopWriter context.CancelFunc
+	stopOnce   sync.Once
+	wg         sync.WaitGroup // wait for goroutines to stop
 }
 
 // sockaddr_ctl specifeid in /usr/include/sys/kern_control.h
@@ -92,16 +96,46 @@ type ifreqAddr struct {
 
 var sockaddrCtlSize uintptr = 32
 
-// OpenTunDevice return a TunDevice according a URL
-func OpenTunDevice(deviceURL url.URL) (TunDevice, error) {
-	if deviceURL.Scheme != "dev" {
-		return nil, errors.New("unsupported device type " + deviceURL.Scheme)
+// Supported reports whether a tun device can be opened on this platform
+const Supported = true
+
+// openDevice return a TunDevice according a URL, dev://utun takes the next free utun,
+// dev://utunN the given one and fd://N a utun socket opened by another process
+func openDevice(deviceURL url.URL) (TunDevice, error) {
+	if addressing, err := parseAddressing(deviceURL.Query()); err != nil || !addressing.empty() {
+		return nil, errors.New("addr, peer, prefix and addr6 of the device url are only supported on linux")
+	}
+	qlen, err := parseQueueLength(deviceURL.Query())
+	if err != nil {
+		return nil, fmt.Errorf("invalid tun device url %s: %w", deviceURL.String(), err)
+	}
 
+	var device TunDevice
+	switch deviceURL.Scheme {
+	case "dev":
+		device, err = openDeviceByName(deviceURL.Host)
+	case "fd":
+		fd, parseErr := strconv.ParseInt(deviceURL.Host, 10, 32)
+		if parseErr != nil {
+			return nil, fmt.Errorf("invalid tun device url %s: %w", deviceURL.String(), parseErr)
+		}
+		// the process handing the socket over has set up the addresses and the mtu
+		device, err = newTunDarwin(os.NewFile(uintptr(fd), ""))
+	default:
+		return nil, errors.New("unsupported device type " + deviceURL.Scheme)
 	}
-	name := deviceURL.Host
+	if err != nil {
+		return nil, err
+	}
+	device.(*tunDarwin).qlen = qlen
+	return device, nil
+}
+
+func openDeviceByName(name string) (TunDevice, error) {
 	// TODO: configure the MTU
 	mtu := 9000
 
+	// unit 0 lets the kernel pick the next free utun
 	ifIndex := -1
 	if name != "utun" {
 		_, err := fmt.Sscanf(name, "utun%d", &ifIndex)
@@ -131,6 +165,7 @@ func OpenTunDevice(deviceURL url.URL) (TunDevice, error) {
 	)
 
 	if errno != 0 {
+		unix.Close(fd)
 		return nil, fmt.Errorf("_CTLIOCGINFO: %v", errno)
 	}
 
@@ -152,11 +187,13 @@ func OpenTunDevice(deviceURL url.URL) (TunDevice, error) {
 	)
 
 	if errno != 0 {
+		unix.Close(fd)
 		return nil, fmt.Errorf("SYS_CONNECT: %v", errno)
 	}
 
 	err = syscall.SetNonblock(fd, true)
 	if err != nil {
+		unix.Close(fd)
 		return nil, err
 	}
 	tun, err := CreateTUNFromFile(os.NewFile(uintptr(fd), ""), mtu)
@@ -164,9 +201,10 @@ func OpenTunDevice(deviceURL url.URL) (TunDevice, error) {
 	return tun, err
 }
 
-func CreateTUNFromFile(file *os.File, mtu int) (TunDevice, error) {
+func newTunDarwin(file *os.File) (*tunDarwin, error) {
 	tun := &tunDarwin{
 		tunFile: file,
+		qlen:    defaultQueueLength,
 		errors:  make(chan error, 5),
 	}
 
@@ -176,9 +214,12 @@ func CreateTUNFromFile(file *os.File, mtu int) (TunDevice, error) {
 		return nil, err
 	}
 	tun.name = name
+	return tun, nil
+}
 
+func CreateTUNFromFile(file *os.File, mtu int) (TunDevice, error) {
+	tun, err := newTunDarwin(file)
 	if err != nil {
-		tun.tunFile.Close()
 		return nil, err
 	}
 
@@ -215,8 +256,16 @@ func (t *tunDarwin) URL() string {
 	return fmt.Sprintf("dev://%s", t.Name())
 }
 
+func (t *tunDarwin) Addressing() Addressing {
+	return Addressing{}
+}
+
+func (t *tunDarwin) Stats() DeviceStats {
+	return t.counters.stats()
+}
+
 func (t *tunDarwin) AsLinkEndpoint() (result stack.LinkEndpoint, err error) {
-	if t.closed {
+	if t.closed.Load() {
 		return nil, fmt.Errorf("device closed.")
 	}
 	if t.linkCache != nil {
@@ -227,20 +276,24 @@ func (t *tunDarwin) AsLinkEndpoint() (result stack.LinkEndpoint, err error) {
 	if err != nil {
 		return nil, errors.New("unable to get device mtu")
 	}
-	linkEP := channel.New(512, uint32(mtu), "")
+	linkEP := newQueueEndpoint(t.qlen, uint32(mtu), &t.counters)
 
 	// start Read loop. read ip packet from tun and write it to ipstack
 	t.wg.Add(1)
 	go func() {
-		readBuf := make([]byte, mtu)
+		readBuf := make([]byte, utunHeaderSize+mtu)
 		for {
 			n, err := t.Read(readBuf)
 			if err != nil {
-				if !t.closed {
-					log.Errorln("can not read from tun: %v", err)
+				if !t.closed.Load() {
+					log.Dedupln(log.ERROR, err.Error(), "can not read from tun: %v", err)
 				}
 				break
 			}
+			if n == 0 {
+				continue
+			}
+			t.counters.read(n)
 
 			var p tcpip.NetworkProtocolNumber
 			switch header.IPVersion(readBuf) {
@@ -249,13 +302,13 @@ func (t *tunDarwin) AsLinkEndpoint() (result stack.LinkEndpoint, err error) {
 			case header.IPv6Version:
 				p = header.IPv6ProtocolNumber
 			}
-			if linkEP.IsAttached() {
-				linkEP.InjectInbound(p, stack.NewPacketBuffer(stack.PacketBufferOptions{
-					Payload: buffer.MakeWithData(readBuf[:n]),
-				}))
-			} else {
+			pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Payload: buffer.MakeWithData(readBuf[:n]),
+			})
+			if !linkEP.deliver(p, pkt) {
 				log.Debugln("received packet from tun when %s is not attached to any dispatcher.", t.Name())
 			}
+			pkt.DecRef()
 
 		}
 		t.wg.Done()
@@ -263,8 +316,14 @@ func (t *tunDarwin) AsLinkEndpoint() (result stack.LinkEndpoint, err error) {
 		log.Debugln("%v stop read loop", t.Name())
 	}()
 
-	// start write notification
-	t.writeHandle = linkEP.AddNotify(t)
+	// the writer drains the queue of the ipstack into the device
+	ctx, cancel := context.WithCancel(context.Background())
+	t.stopWriter = cancel
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		linkEP.runWriter(ctx, t.writePacket)
+	}()
 
 	t.linkCache = linkEP
 	return t.linkCache, nil
@@ -276,49 +335,61 @@ func (t *tunDarwin) Read(buff []byte) (int, error) {
 		return 0, err
 	default:
 		n, err := t.tunFile.Read(buff)
-		if n < 4 {
+		if err != nil {
 			return 0, err
 		}
 
-		copy(buff[:], buff[4:])
-		return n - 4, err
+		// a bad frame is dropped, not the read loop
+		n, err = utunPacket(buff, n)
+		if err != nil {
+			log.Debugln("drop a frame of %s: %v", t.Name(), err)
+			return 0, nil
+		}
+		return n, nil
 	}
 }
 
 func (t *tunDarwin) Write(buff []byte) (int, error) {
-	// reserve space for header
 	buf := pool.Get(pool.RelayBufferSize)
 	defer pool.Put(buf[:cap(buf)])
 
-	buf[0] = 0x00
-	buf[1] = 0x00
-	buf[2] = 0x00
-
-	copy(buf[4:], buff)
-	if buf[4]>>4 == ipv6.Version {
-		buf[3] = unix.AF_INET6
-	} else {
-		buf[3] = unix.AF_INET
+	frame, err := utunFrame(buf, buff)
+	if err != nil {
+		return 0, err
 	}
-
-	// write
-	return t.tunFile.Write(buf[:4+len(buff)])
+	n, err := t.tunFile.Write(frame)
+	if n >= utunHeaderSize {
+		n -= utunHeaderSize
+	}
+	return n, err
 }
 
-func (t *tunDarwin) WriteNotify() {
-	packet := t.linkCache.Read()
+// writePacket writes a packet queued by the ipstack to the device
+func (t *tunDarwin) writePacket(packet stack.PacketBufferPtr) {
+	// a packet left for a closed device, replaced by another one, is dropped
+	t.writeMux.RLock()
+	defer t.writeMux.RUnlock()
+	if t.closed.Load() {
+		return
+	}
 
-	_, err := t.Write(packet.ToView().AsSlice())
-	packet.DecRef()
+	n, err := t.Write(packet.ToView().AsSlice())
+	t.counters.write(n, err)
 	if err != nil {
-		log.Errorln("can not read from tun: %v", err)
+		log.Dedupln(log.ERROR, err.Error(), "can not write to tun: %v", err)
 	}
 
 }
 
 func (t *tunDarwin) Close() {
 	t.stopOnce.Do(func() {
-		t.closed = true
+		t.writeMux.Lock()
+		t.closed.Store(true)
+		t.writeMux.Unlock()
+		if t.linkCache != nil {
+			t.stopWriter()
+			t.linkCache.Drain()
+		}
 		t.tunFile.Close()
 	})
 }
@@ -5,60 +5,200 @@ package dev
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"net"
 	"net/url"
 	"os"
+	"os/user"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"unsafe"
 
 	"github.com/Dreamacro/clash/log"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/atomic"
 	"golang.org/x/sys/unix"
 	"gvisor.dev/gvisor/pkg/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
-	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
 const (
 	cloneDevicePath = "/dev/net/tun"
 	ifReqSize       = unix.IFNAMSIZ + 64
+
+	// deviceURLFormat is shown by the errors of a bad dev:// url
+	deviceURLFormat = "dev://NAME?mtu=MTU&qlen=PACKETS&queues=1-256&persist=true|false&user|owner=USER|UID&group=GROUP|GID&addr=IPV4[/PREFIX]&peer=IPV4&prefix=1-32&addr6=IPV6[/PREFIX]&autoroute=true|false|force"
+
+	// maxQueues is MAX_TAP_QUEUES of the kernel
+	maxQueues = 256
+
+	// readBatch is the most packets a read loop takes from its queue per wakeup
+	readBatch = 32
 )
 
-type tunLinux struct {
-	url       string
-	name      string
-	tunFile   *os.File
-	linkCache *channel.Endpoint
-	mtu       int
+// deviceOptions are applied after attaching the device, the zero value changes nothing
+type deviceOptions struct {
+	persist *bool
+	uid     int // -1 leaves the owner unchanged
+	gid     int // -1 leaves the group unchanged
+	// queues > 1 opens the device with IFF_MULTI_QUEUE, one fd and read loop per queue
+	queues int
+
+	addressing Addressing
+	autoRoute  autoRoute
+}
+
+func parseDeviceOptions(query url.Values) (opts deviceOptions, err error) {
+	opts.uid, opts.gid, opts.queues = -1, -1, 1
+
+	if value := query.Get("queues"); value != "" {
+		if opts.queues, err = strconv.Atoi(value); err != nil || opts.queues < 1 || opts.queues > maxQueues {
+			return opts, fmt.Errorf("queues %s: expect 1-%d", value, maxQueues)
+		}
+	}
+
+	if opts.addressing, err = parseAddressing(query); err != nil {
+		return opts, err
+	}
+
+	if opts.autoRoute, err = parseAutoRoute(query.Get("autoroute")); err != nil {
+		return opts, err
+	}
+	if opts.autoRoute != autoRouteOff && opts.addressing.empty() {
+		return opts, errors.New("autoroute requires addr or addr6")
+	}
+
+	if value := query.Get("persist"); value != "" {
+		persist, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("persist: %w", err)
+		}
+		opts.persist = &persist
+	}
+
+	// owner is the name of TUNSETOWNER and of the owner of the sysfs, the same as user
+	key, value := "user", query.Get("user")
+	if owner := query.Get("owner"); owner != "" {
+		if value != "" && value != owner {
+			return opts, fmt.Errorf("user %s and owner %s: set one of them", value, owner)
+		}
+		key, value = "owner", owner
+	}
+	if value != "" {
+		if opts.uid, err = strconv.Atoi(value); err != nil {
+			u, err := user.Lookup(value)
+			if err != nil {
+				return opts, fmt.Errorf("%s: %w", key, err)
+			}
+			opts.uid, _ = strconv.Atoi(u.Uid)
+		} else if opts.uid < 0 {
+			return opts, fmt.Errorf("%s %s: invalid uid", key, value)
+		}
+	}
 
-	closed   bool
-	stopOnce sync.Once
-	wg       sync.WaitGroup // wait for goroutines to stop
+	if value := query.Get("group"); value != "" {
+		if opts.gid, err = strconv.Atoi(value); err != nil {
+			g, err := user.LookupGroup(value)
+			if err != nil {
+				return opts, fmt.Errorf("group: %w", err)
+			}
+			opts.gid, _ = strconv.Atoi(g.Gid)
+		} else if opts.gid < 0 {
+			return opts, fmt.Errorf("group %s: invalid gid", value)
+		}
+	}
+	return opts, nil
+}
 
-	writeHandle *channel.NotificationHandle
+type tunLinux struct {
+	url     string
+	name    string
+	tunFile *os.File
+	// queues are the fds of the device, tunFile is the first one
+	queues []*os.File
+	// queueConns are the raw conns of the queues, the packets are written to them with writev
+	queueConns []syscall.RawConn
+	nextQueue  atomic.Uint32
+	linkCache  *queueEndpoint
+	qlen       int
+	mtu        int
+	addressing Addressing
+	counters   deviceCounters
+	// routes are the ones added by autoroute, Close removes them
+	routes []netlink.Route
+
+	closed atomic.Bool
+	// writeMux keeps Close from closing the queues under a write of the writer
+	writeMux   sync.RWMutex
+	stopWriter context.CancelFunc
+	stopOnce   sync.Once
+	wg         sync.WaitGroup // wait for goroutines to stop
 }
 
-// OpenTunDevice return a TunDevice according a URL
-func OpenTunDevice(deviceURL url.URL) (TunDevice, error) {
+// Supported reports whether a tun device can be opened on this platform
+const Supported = true
+
+// openDevice return a TunDevice according a URL
+func openDevice(deviceURL url.URL) (TunDevice, error) {
 	mtu, _ := strconv.ParseInt(deviceURL.Query().Get("mtu"), 0, 32)
+	qlen, err := parseQueueLength(deviceURL.Query())
+	if err != nil {
+		return nil, fmt.Errorf("invalid tun device url %s: %w", deviceURL.String(), err)
+	}
 
 	t := &tunLinux{
-		url: deviceURL.String(),
-		mtu: int(mtu),
+		url:  deviceURL.String(),
+		mtu:  int(mtu),
+		qlen: qlen,
 	}
 	switch deviceURL.Scheme {
 	case "dev":
-		return t.openDeviceByName(deviceURL.Host)
+		opts, err := parseDeviceOptions(deviceURL.Query())
+		if err != nil {
+			return nil, fmt.Errorf("invalid tun device url %s, the format is %s: %w", deviceURL.String(), deviceURLFormat, err)
+		}
+		return t.openDeviceByName(deviceURL.Host, opts)
 	case "fd":
 		fd, err := strconv.ParseInt(deviceURL.Host, 10, 32)
 		if err != nil {
 			return nil, err
 		}
-		return t.openDeviceByFd(int(fd))
+		addressing, err := parseAddressing(deviceURL.Query())
+		if err != nil {
+			return nil, fmt.Errorf("invalid tun device url %s: %w", deviceURL.String(), err)
+		}
+		if _, err := t.openDeviceByFd(int(fd)); err != nil {
+			return nil, err
+		}
+		if err := t.applyAddressing(addressing); err != nil {
+			t.closeQueues()
+			return nil, err
+		}
+		return t, nil
+	case "unix":
+		address, err := fdSocketAddress(deviceURL)
+		if err != nil {
+			return nil, err
+		}
+		addressing, err := parseAddressing(deviceURL.Query())
+		if err != nil {
+			return nil, fmt.Errorf("invalid tun device url %s, the format is %s: %w", deviceURL.String(), fdSocketURLFormat, err)
+		}
+		if _, err := t.openDeviceBySocket(address); err != nil {
+			return nil, err
+		}
+		if err := t.applyAddressing(addressing); err != nil {
+			t.closeQueues()
+			return nil, err
+		}
+		return t, nil
 	}
 	return nil, fmt.Errorf("unsupported device type `%s`", deviceURL.Scheme)
 }
@@ -71,6 +211,14 @@ func (t *tunLinux) URL() string {
 	return t.url
 }
 
+func (t *tunLinux) Addressing() Addressing {
+	return t.addressing
+}
+
+func (t *tunLinux) Stats() DeviceStats {
+	return t.counters.stats()
+}
+
 func (t *tunLinux) AsLinkEndpoint() (result stack.LinkEndpoint, err error) {
 	if t.linkCache != nil {
 		return t.linkCache, nil
@@ -82,46 +230,125 @@ func (t *tunLinux) AsLinkEndpoint() (result stack.LinkEndpoint, err error) {
 		return nil, errors.New("unable to get device mtu")
 	}
 
-	linkEP := channel.New(512, uint32(mtu), "")
+	linkEP := newQueueEndpoint(t.qlen, uint32(mtu), &t.counters)
 
-	// start Read loop. read ip packet from tun and write it to ipstack
+	t.queueConns = make([]syscall.RawConn, len(t.queues))
+	for i, queue := range t.queues {
+		if t.queueConns[i], err = queue.SyscallConn(); err != nil {
+			return nil, err
+		}
+	}
+
+	// start a Read loop per queue. read ip packet from tun and write it to ipstack
+	for i := range t.queues {
+		t.wg.Add(1)
+		go t.readLoop(i, t.queueConns[i], linkEP, mtu)
+	}
+
+	// the writer drains the queue of the ipstack into the device
+	ctx, cancel := context.WithCancel(context.Background())
+	t.stopWriter = cancel
 	t.wg.Add(1)
 	go func() {
-		readBuf := make([]byte, mtu)
-		for {
-			n, err := t.Read(readBuf)
-			if err != nil {
-				if !t.closed {
-					log.Errorln("can not read from tun: %v", err)
+		defer t.wg.Done()
+		linkEP.runWriter(ctx, t.writePacket)
+	}()
+	t.linkCache = linkEP
+	return t.linkCache, nil
+}
+
+// readLoop reads up to readBatch packets each time the queue is readable, every packet is
+// read into a view of its own from the pool of gvisor and handed to the ipstack without a copy
+func (t *tunLinux) readLoop(index int, queue syscall.RawConn, linkEP *queueEndpoint, mtu int) {
+	defer t.wg.Done()
+
+	views := make([]*buffer.View, 0, readBatch)
+	for {
+		var readErr error
+		err := queue.Read(func(fd uintptr) bool {
+			for len(views) < readBatch {
+				v := buffer.NewViewSize(mtu)
+				n, err := unix.Read(int(fd), v.AsSlice())
+				if err == unix.EINTR {
+					v.Release()
+					continue
+				}
+				if err != nil || n == 0 {
+					v.Release()
+					if err == unix.EAGAIN {
+						// wait for the queue only when nothing was read
+						return len(views) > 0
+					}
+					readErr = err
+					return true
 				}
-				break
+				v.CapLength(n)
+				views = append(views, v)
 			}
+			return true
+		})
+		if err == nil {
+			err = readErr
+		}
 
-			var p tcpip.NetworkProtocolNumber
-			switch header.IPVersion(readBuf) {
-			case header.IPv4Version:
-				p = header.IPv4ProtocolNumber
-			case header.IPv6Version:
-				p = header.IPv6ProtocolNumber
-			}
-			if linkEP.IsAttached() {
-				linkEP.InjectInbound(p, stack.NewPacketBuffer(stack.PacketBufferOptions{
-					Payload: buffer.MakeWithData(readBuf[:n]),
-				}))
-			} else {
-				log.Debugln("received packet from tun when %s is not attached to any dispatcher.", t.Name())
+		for _, v := range views {
+			t.counters.read(v.Size())
+			t.deliver(linkEP, v)
+		}
+		views = views[:0]
+
+		if err != nil {
+			if !t.closed.Load() {
+				log.Dedupln(log.ERROR, err.Error(), "can not read from tun: %v", err)
 			}
+			break
+		}
+	}
+	t.Close()
+	log.Debugln("%v stop read loop of queue %d", t.Name(), index)
+}
+
+// deliver hands a packet read from the device to the ipstack, which takes the view
+func (t *tunLinux) deliver(linkEP *queueEndpoint, v *buffer.View) {
+	var p tcpip.NetworkProtocolNumber
+	switch header.IPVersion(v.AsSlice()) {
+	case header.IPv4Version:
+		p = header.IPv4ProtocolNumber
+	case header.IPv6Version:
+		p = header.IPv6ProtocolNumber
+	}
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithView(v)})
+	if !linkEP.deliver(p, pkt) {
+		log.Debugln("received packet from tun when %s is not attached to any dispatcher.", t.Name())
+	}
+	pkt.DecRef()
+}
+
+// writeQueue picks the queue of a packet by the addresses in its first slice, so a flow stays
+// on one queue and isn't reordered
+func (t *tunLinux) writeQueue(packet []byte) syscall.RawConn {
+	if len(t.queueConns) == 1 {
+		return t.queueConns[0]
+	}
 
+	var addrs []byte
+	switch header.IPVersion(packet) {
+	case header.IPv4Version:
+		if len(packet) >= header.IPv4MinimumSize {
+			addrs = packet[12:20]
 		}
-		t.wg.Done()
-		t.Close()
-		log.Debugln("%v stop read loop", t.Name())
-	}()
+	case header.IPv6Version:
+		if len(packet) >= header.IPv6MinimumSize {
+			addrs = packet[8:40]
+		}
+	}
+	if addrs == nil {
+		return t.queueConns[t.nextQueue.Inc()%uint32(len(t.queueConns))]
+	}
 
-	// start write notification
-	t.writeHandle = linkEP.AddNotify(t)
-	t.linkCache = linkEP
-	return t.linkCache, nil
+	h := fnv.New32a()
+	h.Write(addrs)
+	return t.queueConns[h.Sum32()%uint32(len(t.queueConns))]
 }
 
 func (t *tunLinux) Write(buff []byte) (int, error) {
@@ -132,26 +359,54 @@ func (t *tunLinux) Read(buff []byte) (int, error) {
 	return t.tunFile.Read(buff)
 }
 
-// WriteNotify implements channel.Notification.WriteNotify.
-func (t *tunLinux) WriteNotify() {
-	packet := t.linkCache.Read()
+// writePacket writes the slices of a packet, the headers and the payload, with one writev
+func (t *tunLinux) writePacket(packet stack.PacketBufferPtr) {
+	// a packet left for a closed device, replaced by another one, is dropped
+	t.writeMux.RLock()
+	defer t.writeMux.RUnlock()
+	if t.closed.Load() {
+		return
+	}
 
-	_, err := t.Write(packet.ToView().AsSlice())
-	packet.DecRef()
+	slices := packet.AsSlices()
+	if len(slices) == 0 {
+		return
+	}
+	var n int
+	var writeErr error
+	err := t.writeQueue(slices[0]).Write(func(fd uintptr) bool {
+		n, writeErr = unix.Writev(int(fd), slices)
+		return writeErr != unix.EAGAIN
+	})
+	if err == nil {
+		err = writeErr
+	}
+	t.counters.write(n, err)
 	if err != nil {
-		log.Errorln("can not read from tun: %v", err)
+		log.Dedupln(log.ERROR, err.Error(), "can not write to tun: %v", err)
 	}
-
 }
 
 func (t *tunLinux) Close() {
 	t.stopOnce.Do(func() {
-		t.closed = true
-		t.linkCache.RemoveNotify(t.writeHandle)
-		t.tunFile.Close()
+		t.writeMux.Lock()
+		t.closed.Store(true)
+		t.writeMux.Unlock()
+		if t.linkCache != nil {
+			t.stopWriter()
+			t.linkCache.Drain()
+		}
+		t.removeAutoRoutes()
+		t.closeQueues()
 	})
 }
 
+func (t *tunLinux) closeQueues() {
+	for _, queue := range t.queues {
+		queue.Close()
+	}
+}
+
 // Wait wait goroutines to exit
 func (t *tunLinux) Wait() {
 	t.wg.Wait()
@@ -166,21 +421,59 @@ func (t *tunLinux) MTU() (int, error) {
 	return int(mtu), err
 }
 
-func (t *tunLinux) openDeviceByName(name string) (TunDevice, error) {
-	nfd, err := unix.Open(cloneDevicePath, os.O_RDWR, 0)
+func (t *tunLinux) openDeviceByName(name string, opts deviceOptions) (TunDevice, error) {
+	nameBytes := []byte(name)
+	if len(nameBytes) >= unix.IFNAMSIZ {
+		return nil, fmt.Errorf("interface name too long, the format is %s", deviceURLFormat)
+	}
+
+	for i := 0; i < opts.queues; i++ {
+		queue, err := openQueue(name, opts, i == 0)
+		if err != nil {
+			t.closeQueues()
+			return nil, err
+		}
+		t.queues = append(t.queues, queue)
+	}
+
+	t.tunFile = t.queues[0]
+	var err error
+	t.name, err = t.getName()
 	if err != nil {
+		t.closeQueues()
 		return nil, err
 	}
 
+	if err := t.applyAddressing(opts.addressing); err != nil {
+		t.closeQueues()
+		return nil, err
+	}
+
+	if opts.autoRoute != autoRouteOff {
+		if err := t.addAutoRoutes(opts.autoRoute); err != nil {
+			t.closeQueues()
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// openQueue attaches a fd to the device, the device options are applied by the first one
+func openQueue(name string, opts deviceOptions, first bool) (*os.File, error) {
 	var ifr [ifReqSize]byte
 	var flags uint16 = unix.IFF_TUN | unix.IFF_NO_PI
-	nameBytes := []byte(name)
-	if len(nameBytes) >= unix.IFNAMSIZ {
-		return nil, errors.New("interface name too long")
+	if opts.queues > 1 {
+		flags |= unix.IFF_MULTI_QUEUE
 	}
-	copy(ifr[:], nameBytes)
+	copy(ifr[:], name)
 	*(*uint16)(unsafe.Pointer(&ifr[unix.IFNAMSIZ])) = flags
 
+	nfd, err := unix.Open(cloneDevicePath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", cloneDevicePath, err)
+	}
+
 	_, _, errno := unix.Syscall(
 		unix.SYS_IOCTL,
 		uintptr(nfd),
@@ -188,23 +481,143 @@ func (t *tunLinux) openDeviceByName(name string) (TunDevice, error) {
 		uintptr(unsafe.Pointer(&ifr[0])),
 	)
 	if errno != 0 {
-		return nil, errno
+		unix.Close(nfd)
+		return nil, attachError(name, opts.queues > 1, errno)
+	}
+
+	if first {
+		if err := applyDeviceOptions(nfd, name, opts); err != nil {
+			unix.Close(nfd)
+			return nil, err
+		}
 	}
+
 	err = unix.SetNonblock(nfd, true)
 	if err != nil {
+		unix.Close(nfd)
 		return nil, err
 	}
 
 	// Note that the above -- open,ioctl,nonblock -- must happen prior to handing it to netpoll as below this line.
 
-	t.tunFile = os.NewFile(uintptr(nfd), cloneDevicePath)
-	t.name, err = t.getName()
+	return os.NewFile(uintptr(nfd), cloneDevicePath), nil
+}
+
+// tunAttr reads an attribute of an existing tun device from sysfs
+func tunAttr(name, attr string) (int64, bool) {
+	b, err := os.ReadFile("/sys/class/net/" + name + "/" + attr)
 	if err != nil {
-		t.tunFile.Close()
-		return nil, err
+		return 0, false
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(string(b)), 0, 64)
+	return v, err == nil
+}
+
+// attachError tells a device with the wrong flags from a permission problem
+func attachError(name string, multiQueue bool, errno syscall.Errno) error {
+	flags, exist := tunAttr(name, "tun_flags")
+	switch {
+	case errno == unix.EPERM && exist:
+		owner, _ := tunAttr(name, "owner")
+		group, _ := tunAttr(name, "group")
+		return fmt.Errorf("attach tun %s owned by user %d group %d: %w, run as its owner or with CAP_NET_ADMIN", name, owner, group, errno)
+	case errno == unix.EPERM:
+		return fmt.Errorf("create tun %s: %w, creating a device needs CAP_NET_ADMIN, or create it persistent with %s", name, errno, deviceURLFormat)
+	case errno == unix.EBUSY:
+		return fmt.Errorf("attach tun %s: %w, it is attached by another process", name, errno)
+	case errno == unix.EINVAL && exist && multiQueue:
+		return fmt.Errorf("tun %s exists with wrong flags %#x, queues > 1 needs a tun device with multi_queue: %w", name, flags, errno)
+	case errno == unix.EINVAL && exist:
+		return fmt.Errorf("tun %s exists with wrong flags %#x, it must be a tun device without multi_queue: %w", name, flags, errno)
+	default:
+		return fmt.Errorf("attach tun %s: %w", name, errno)
 	}
+}
 
-	return t, nil
+// applyDeviceOptions sets the persist flag and the owner of the attached device, an option already
+// in effect is skipped, so attaching to a prepared persistent device needs no CAP_NET_ADMIN
+func applyDeviceOptions(fd int, name string, opts deviceOptions) error {
+	ioctl := func(option string, req, arg uintptr) error {
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), req, arg); errno != 0 {
+			if errno == unix.EPERM {
+				return fmt.Errorf("set %s of tun %s: %w, changing it needs CAP_NET_ADMIN", option, name, errno)
+			}
+			return fmt.Errorf("set %s of tun %s: %w", option, name, errno)
+		}
+		return nil
+	}
+
+	if opts.uid >= 0 {
+		if owner, ok := tunAttr(name, "owner"); !ok || owner != int64(opts.uid) {
+			if err := ioctl("user", unix.TUNSETOWNER, uintptr(opts.uid)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.gid >= 0 {
+		if group, ok := tunAttr(name, "group"); !ok || group != int64(opts.gid) {
+			if err := ioctl("group", unix.TUNSETGROUP, uintptr(opts.gid)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.persist != nil {
+		flags, ok := tunAttr(name, "tun_flags")
+		if !ok || (flags&unix.IFF_PERSIST != 0) != *opts.persist {
+			var arg uintptr
+			if *opts.persist {
+				arg = 1
+			}
+			if err := ioctl("persist", unix.TUNSETPERSIST, arg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyAddressing sets the addresses through netlink and brings the device up,
+// an address already on the device is replaced so reopening it succeeds
+func (t *tunLinux) applyAddressing(a Addressing) error {
+	if a.empty() {
+		return nil
+	}
+
+	link, err := netlink.LinkByName(t.name)
+	if err != nil {
+		return fmt.Errorf("find tun %s: %w", t.name, err)
+	}
+	wrap := func(what string, err error) error {
+		if errors.Is(err, unix.EPERM) {
+			return fmt.Errorf("set %s of tun %s: %w, changing it needs CAP_NET_ADMIN", what, t.name, err)
+		}
+		return fmt.Errorf("set %s of tun %s: %w", what, t.name, err)
+	}
+
+	if a.Addr.IsValid() {
+		mask := net.CIDRMask(a.Prefix, 32)
+		addr := &netlink.Addr{IPNet: &net.IPNet{IP: a.Addr.AsSlice(), Mask: mask}}
+		if a.Peer.IsValid() {
+			addr.Peer = &net.IPNet{IP: a.Peer.AsSlice(), Mask: mask}
+		}
+		if err := netlink.AddrReplace(link, addr); err != nil {
+			return wrap("addr "+a.Addr.String(), err)
+		}
+	}
+	if a.IP6.IsValid() {
+		addr := &netlink.Addr{IPNet: &net.IPNet{IP: a.IP6.Addr().AsSlice(), Mask: net.CIDRMask(a.IP6.Bits(), 128)}}
+		if err := netlink.AddrReplace(link, addr); err != nil {
+			return wrap("addr6 "+a.IP6.String(), err)
+		}
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return wrap("state up", err)
+	}
+
+	t.addressing = a
+	return nil
 }
 
 func (t *tunLinux) openDeviceByFd(fd int) (TunDevice, error) {
@@ -221,10 +634,12 @@ func (t *tunLinux) openDeviceByFd(fd int) (TunDevice, error) {
 
 	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TUNGETIFF, uintptr(unsafe.Pointer(&ifr)))
 	if errno != 0 {
+		syscall.Close(fd)
 		return nil, errno
 	}
 
 	if ifr.flags&syscall.IFF_TUN == 0 || ifr.flags&syscall.IFF_NO_PI == 0 {
+		syscall.Close(fd)
 		return nil, errors.New("only tun device and no pi mode supported")
 	}
 
@@ -233,12 +648,25 @@ func (t *tunLinux) openDeviceByFd(fd int) (TunDevice, error) {
 	if i != -1 {
 		nullStr = nullStr[:i]
 	}
-	t.name = string(nullStr)
-	t.tunFile = os.NewFile(uintptr(fd), "/dev/tun")
-
+	if err := t.adoptFd(fd, string(nullStr)); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
 	return t, nil
 }
 
+// adoptFd makes fd the single queue of the device
+func (t *tunLinux) adoptFd(fd int, name string) error {
+	// the read loop and the writes wait for the fd in the poller
+	if err := unix.SetNonblock(fd, true); err != nil {
+		return err
+	}
+	t.name = name
+	t.tunFile = os.NewFile(uintptr(fd), "/dev/tun")
+	t.queues = []*os.File{t.tunFile}
+	return nil
+}
+
 func (t *tunLinux) getInterfaceMtu() (uint32, error) {
 	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
 	if err != nil {
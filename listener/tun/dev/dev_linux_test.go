@@ -0,0 +1,534 @@
+//go:build linux
+
+package dev
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"go.uber.org/atomic"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func TestParseDeviceOptions_Queues(t *testing.T) {
+	opts, err := parseDeviceOptions(url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, opts.queues)
+
+	query, _ := url.ParseQuery("queues=4")
+	opts, err = parseDeviceOptions(query)
+	require.NoError(t, err)
+	assert.Equal(t, 4, opts.queues)
+
+	for _, raw := range []string{"queues=0", "queues=257", "queues=many"} {
+		query, _ := url.ParseQuery(raw)
+		_, err := parseDeviceOptions(query)
+		assert.ErrorContains(t, err, "expect 1-256", raw)
+	}
+}
+
+func TestParseDeviceOptions_AutoRoute(t *testing.T) {
+	query, _ := url.ParseQuery("addr=198.18.0.1/16&autoroute=force")
+	opts, err := parseDeviceOptions(query)
+	require.NoError(t, err)
+	assert.Equal(t, autoRouteForce, opts.autoRoute)
+
+	query, _ = url.ParseQuery("autoroute=true")
+	_, err = parseDeviceOptions(query)
+	assert.EqualError(t, err, "autoroute requires addr or addr6")
+
+	query, _ = url.ParseQuery("addr=198.18.0.1&autoroute=yes")
+	_, err = parseDeviceOptions(query)
+	assert.EqualError(t, err, "autoroute yes: expect true, false or force")
+}
+
+func TestParseDeviceOptions_Owner(t *testing.T) {
+	opts, err := parseDeviceOptions(url.Values{})
+	require.NoError(t, err)
+	assert.Nil(t, opts.persist)
+	assert.Equal(t, -1, opts.uid)
+	assert.Equal(t, -1, opts.gid)
+
+	query, _ := url.ParseQuery("persist=true&user=1000&group=1001")
+	opts, err = parseDeviceOptions(query)
+	require.NoError(t, err)
+	require.NotNil(t, opts.persist)
+	assert.True(t, *opts.persist)
+	assert.Equal(t, 1000, opts.uid)
+	assert.Equal(t, 1001, opts.gid)
+
+	query, _ = url.ParseQuery("persist=false&owner=root&group=root")
+	opts, err = parseDeviceOptions(query)
+	require.NoError(t, err)
+	require.NotNil(t, opts.persist)
+	assert.False(t, *opts.persist)
+	assert.Equal(t, 0, opts.uid)
+	assert.Equal(t, 0, opts.gid)
+
+	for raw, message := range map[string]string{
+		"persist=maybe":             "persist:",
+		"user=-1":                   "user -1: invalid uid",
+		"group=-1":                  "group -1: invalid gid",
+		"owner=clash-no-such-user":  "owner:",
+		"group=clash-no-such-group": "group:",
+		"user=1000&owner=1001":      "user 1000 and owner 1001",
+	} {
+		query, _ := url.ParseQuery(raw)
+		_, err := parseDeviceOptions(query)
+		assert.ErrorContains(t, err, message, raw)
+	}
+}
+
+// TestDeviceOptions_Persist provisions a persistent device owned by nobody, as root does once
+// for clash to attach to it unprivileged, then clears the persistence. It needs CAP_NET_ADMIN
+// and CLASH_TEST_TUN_PERSIST=1, a failure may leave clashpersist behind
+func TestDeviceOptions_Persist(t *testing.T) {
+	if os.Getenv("CLASH_TEST_TUN_PERSIST") == "" {
+		t.Skip("CLASH_TEST_TUN_PERSIST is not set")
+	}
+	open := func(query string) {
+		deviceURL, _ := url.Parse("dev://clashpersist?" + query)
+		device, err := OpenTunDevice(*deviceURL)
+		require.NoError(t, err)
+		device.Close()
+		device.Wait()
+	}
+
+	open("persist=true&owner=65534&group=65534")
+	flags, ok := tunAttr("clashpersist", "tun_flags")
+	require.True(t, ok, "the device is gone once closed")
+	assert.NotZero(t, flags&unix.IFF_PERSIST)
+	owner, _ := tunAttr("clashpersist", "owner")
+	group, _ := tunAttr("clashpersist", "group")
+	assert.Equal(t, int64(65534), owner)
+	assert.Equal(t, int64(65534), group)
+
+	open("persist=false")
+	_, ok = tunAttr("clashpersist", "tun_flags")
+	assert.False(t, ok, "the device is kept once closed")
+}
+
+type countDispatcher struct {
+	packets atomic.Int64
+}
+
+func (d *countDispatcher) DeliverNetworkPacket(tcpip.NetworkProtocolNumber, stack.PacketBufferPtr) {
+	d.packets.Inc()
+}
+
+func (d *countDispatcher) DeliverLinkPacket(tcpip.NetworkProtocolNumber, stack.PacketBufferPtr) {}
+
+// benchmarkQueues sends udp packets routed to the device from parallel sockets and reports
+// the share of them the read loops delivered, it needs CAP_NET_ADMIN
+func benchmarkQueues(b *testing.B, queues int) {
+	deviceURL, _ := url.Parse(fmt.Sprintf("dev://clashbench%d?queues=%d&addr=10.253.%d.1&prefix=24", queues, queues, queues))
+	device, err := OpenTunDevice(*deviceURL)
+	if err != nil {
+		b.Skipf("open tun: %s", err)
+	}
+	tun := device.(*tunLinux)
+	defer tun.Wait()
+	defer tun.Close()
+
+	ep, err := tun.AsLinkEndpoint()
+	require.NoError(b, err)
+	dispatcher := &countDispatcher{}
+	ep.Attach(dispatcher)
+
+	payload := make([]byte, 1200)
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		conn, err := net.Dial("udp", fmt.Sprintf("10.253.%d.2:9", queues))
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		defer conn.Close()
+		for pb.Next() {
+			conn.Write(payload)
+		}
+	})
+
+	// wait for the read loops to drain the queues
+	for last := int64(-1); last != dispatcher.packets.Load(); {
+		last = dispatcher.packets.Load()
+		time.Sleep(20 * time.Millisecond)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(dispatcher.packets.Load())/float64(b.N), "delivered/op")
+}
+
+func BenchmarkReadQueues1(b *testing.B) {
+	benchmarkQueues(b, 1)
+}
+
+func BenchmarkReadQueues4(b *testing.B) {
+	benchmarkQueues(b, 4)
+}
+
+// TestDeviceStats counts a udp packet routed to the device and one written to it, it needs CAP_NET_ADMIN
+func TestDeviceStats(t *testing.T) {
+	deviceURL, _ := url.Parse("dev://clashstats?addr=10.252.0.1&prefix=24")
+	device, err := OpenTunDevice(*deviceURL)
+	if err != nil {
+		t.Skipf("open tun: %s", err)
+	}
+	tun := device.(*tunLinux)
+	defer tun.Wait()
+	defer tun.Close()
+
+	ep, err := tun.AsLinkEndpoint()
+	require.NoError(t, err)
+	dispatcher := &countDispatcher{}
+	ep.Attach(dispatcher)
+
+	conn, err := net.Dial("udp", "10.252.0.2:9")
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write(make([]byte, 100))
+	// 128 bytes with the ip and udp headers, the kernel may send some packets of its own
+	require.Eventually(t, func() bool { return tun.Stats().ReadBytes >= 128 }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return uint64(dispatcher.packets.Load()) == tun.Stats().ReadPackets }, time.Second, 10*time.Millisecond)
+
+	// an ipv4 header to the device address
+	packet := make([]byte, 20)
+	packet[0], packet[3], packet[8], packet[9] = 0x45, 20, 64, 17
+	copy(packet[12:], net.IPv4(10, 252, 0, 2).To4())
+	copy(packet[16:], net.IPv4(10, 252, 0, 1).To4())
+	var pkts stack.PacketBufferList
+	pkts.PushBack(stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(packet)}))
+	ep.WritePackets(pkts)
+	pkts.DecRef()
+	require.Eventually(t, func() bool { return tun.Stats().WritePackets == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(20), tun.Stats().WriteBytes)
+	assert.Equal(t, uint64(0), tun.Stats().WriteErrors)
+}
+
+// autoRoutes return the destinations of the routes of autoroute
+func autoRoutes(t *testing.T, family int) (dsts []string) {
+	routes, err := netlink.RouteList(nil, family)
+	require.NoError(t, err)
+	for _, r := range routes {
+		if r.Protocol != autoRouteProtocol {
+			continue
+		}
+		if r.Dst == nil {
+			dsts = append(dsts, "default")
+		} else {
+			dsts = append(dsts, r.Dst.String())
+		}
+	}
+	return dsts
+}
+
+// TestAutoRoute adds and removes the routes of autoroute in a network namespace of its own,
+// it needs CAP_SYS_ADMIN and CAP_NET_ADMIN
+func TestAutoRoute(t *testing.T) {
+	// the thread stays in the namespace and exits with the test
+	runtime.LockOSThread()
+	if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+		t.Skipf("new network namespace: %s", err)
+	}
+
+	open := func(query string) (*tunLinux, error) {
+		deviceURL, _ := url.Parse("dev://clashroute?persist=true&" + query)
+		device, err := OpenTunDevice(*deviceURL)
+		if err != nil {
+			return nil, err
+		}
+		return device.(*tunLinux), nil
+	}
+	defer func() {
+		if link, err := netlink.LinkByName("clashroute"); err == nil {
+			netlink.LinkDel(link)
+		}
+	}()
+
+	// without a default route of the system the default routes go through the device
+	tun, err := open("addr=10.251.0.1/24&addr6=fdfe::1&autoroute=true")
+	if err != nil {
+		t.Skipf("open tun: %s", err)
+	}
+	assert.Equal(t, []string{"default"}, autoRoutes(t, netlink.FAMILY_V4))
+	assert.Equal(t, []string{"default"}, autoRoutes(t, netlink.FAMILY_V6))
+	tun.Close()
+	assert.Empty(t, autoRoutes(t, netlink.FAMILY_V4))
+	assert.Empty(t, autoRoutes(t, netlink.FAMILY_V6))
+
+	// another device stands for the uplink of the system
+	deviceURL, _ := url.Parse("dev://clashuplink?addr=192.0.2.2/24")
+	uplink, err := OpenTunDevice(*deviceURL)
+	require.NoError(t, err)
+	defer uplink.Close()
+	link, err := netlink.LinkByName("clashuplink")
+	require.NoError(t, err)
+	require.NoError(t, netlink.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Gw: net.IPv4(192, 0, 2, 1)}))
+
+	_, err = open("addr=10.251.0.1/24&autoroute=true")
+	assert.EqualError(t, err, "autoroute of tun clashroute: the default route via 192.0.2.1 dev clashuplink exists, autoroute=force routes around it")
+
+	tun, err = open("addr=10.251.0.1/24&autoroute=force")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"0.0.0.0/1", "128.0.0.0/1"}, autoRoutes(t, netlink.FAMILY_V4))
+	tun.Close()
+	assert.Empty(t, autoRoutes(t, netlink.FAMILY_V4))
+
+	defaults, err := defaultRoutes(netlink.FAMILY_V4)
+	require.NoError(t, err)
+	assert.Len(t, defaults, 1)
+}
+
+// copyDispatcher keeps a copy of the packets delivered, the ipstack doesn't own them after
+type copyDispatcher struct {
+	packets chan []byte
+}
+
+func (d *copyDispatcher) DeliverNetworkPacket(_ tcpip.NetworkProtocolNumber, pkt stack.PacketBufferPtr) {
+	d.packets <- pkt.ToView().AsSlice()
+}
+
+func (d *copyDispatcher) DeliverLinkPacket(tcpip.NetworkProtocolNumber, stack.PacketBufferPtr) {}
+
+// flowPayload fills the payload of a packet of a flow, so a packet mixed with another one
+// doesn't check
+func flowPayload(flow, seq int) []byte {
+	payload := make([]byte, 1000)
+	payload[0], payload[1], payload[2] = byte(flow), byte(seq>>8), byte(seq)
+	for i := 3; i < len(payload); i++ {
+		payload[i] = byte(flow*31 + seq + i)
+	}
+	return payload
+}
+
+func checkFlowPayload(payload []byte) (flow, seq int, ok bool) {
+	if len(payload) != 1000 {
+		return 0, 0, false
+	}
+	flow, seq = int(payload[0]), int(payload[1])<<8|int(payload[2])
+	return flow, seq, bytes.Equal(payload, flowPayload(flow, seq))
+}
+
+// TestDeviceConcurrentFlows reads and writes the packets of parallel flows through the
+// batched read loop and writev, none may be mixed up, it needs CAP_NET_ADMIN
+func TestDeviceConcurrentFlows(t *testing.T) {
+	const flows, perFlow = 8, 128
+
+	deviceURL, _ := url.Parse("dev://clashflows?addr=10.249.0.1/24")
+	device, err := OpenTunDevice(*deviceURL)
+	if err != nil {
+		t.Skipf("open tun: %s", err)
+	}
+	tun := device.(*tunLinux)
+	defer tun.Wait()
+	defer tun.Close()
+
+	ep, err := tun.AsLinkEndpoint()
+	require.NoError(t, err)
+	dispatcher := &copyDispatcher{packets: make(chan []byte, flows*perFlow*2)}
+	ep.Attach(dispatcher)
+	// the kernel drops the packets over the queue of the device and over the receive buffer
+	// of a socket, a sender takes a token per packet and the reader gives it back
+	tokens := make(chan struct{}, 32)
+	for i := 0; i < cap(tokens); i++ {
+		tokens <- struct{}{}
+	}
+
+	// the flows to the tun arrive at the read loops
+	var wg sync.WaitGroup
+	for flow := 0; flow < flows; flow++ {
+		wg.Add(1)
+		go func(flow int) {
+			defer wg.Done()
+			conn, err := net.Dial("udp", fmt.Sprintf("10.249.0.2:%d", 9000+flow))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			for seq := 0; seq < perFlow; seq++ {
+				<-tokens
+				if _, err := conn.Write(flowPayload(flow, seq)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(flow)
+	}
+
+	received := 0
+	timeout := time.After(5 * time.Second)
+	for received < flows*perFlow {
+		select {
+		case packet := <-dispatcher.packets:
+			ip := header.IPv4(packet)
+			if !ip.IsValid(len(packet)) || ip.TransportProtocol() != header.UDPProtocolNumber {
+				continue
+			}
+			_, _, ok := checkFlowPayload(header.UDP(ip.Payload()).Payload())
+			require.True(t, ok, "read a corrupted packet")
+			received++
+			tokens <- struct{}{}
+		case <-timeout:
+			require.FailNow(t, "packets lost", "received %d of %d", received, flows*perFlow)
+		}
+	}
+	wg.Wait()
+
+	// the flows from the ipstack are written with their header and payload in two slices
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(10, 249, 0, 1), Port: 9100})
+	require.NoError(t, err)
+	defer listener.Close()
+	for flow := 0; flow < flows; flow++ {
+		wg.Add(1)
+		go func(flow int) {
+			defer wg.Done()
+			for seq := 0; seq < perFlow; seq++ {
+				<-tokens
+				pkts := flowPacket(flow, seq, 9100)
+				ep.WritePackets(pkts)
+				pkts.DecRef()
+			}
+		}(flow)
+	}
+
+	buf := make([]byte, 2048)
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for received = 0; received < flows*perFlow; received++ {
+		n, _, err := listener.ReadFromUDP(buf)
+		require.NoError(t, err, "received %d of %d", received, flows*perFlow)
+		_, _, ok := checkFlowPayload(buf[:n])
+		require.True(t, ok, "wrote a corrupted packet")
+		tokens <- struct{}{}
+	}
+	wg.Wait()
+}
+
+// flowPacket is a udp packet from 10.249.0.2 to the device, with the ip and udp headers in a
+// view of their own
+func flowPacket(flow, seq int, port uint16) stack.PacketBufferList {
+	payload := flowPayload(flow, seq)
+	hdr := make([]byte, header.IPv4MinimumSize+header.UDPMinimumSize)
+	ip := header.IPv4(hdr)
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(hdr) + len(payload)),
+		TTL:         64,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		SrcAddr:     tcpip.AddrFrom4([4]byte{10, 249, 0, 2}),
+		DstAddr:     tcpip.AddrFrom4([4]byte{10, 249, 0, 1}),
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	udp := header.UDP(hdr[header.IPv4MinimumSize:])
+	udp.Encode(&header.UDPFields{SrcPort: uint16(9000 + flow), DstPort: port, Length: uint16(header.UDPMinimumSize + len(payload))})
+
+	data := buffer.MakeWithData(hdr)
+	data.Append(buffer.NewViewWithData(payload))
+	var pkts stack.PacketBufferList
+	pkts.PushBack(stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: data}))
+	return pkts
+}
+
+// BenchmarkWriter queues udp packets for the writer of the device in parallel, the kernel
+// drops them as they aren't for it, it needs CAP_NET_ADMIN
+func BenchmarkWriter(b *testing.B) {
+	deviceURL, _ := url.Parse("dev://clashwrite?addr=10.247.0.1/24")
+	device, err := OpenTunDevice(*deviceURL)
+	if err != nil {
+		b.Skipf("open tun: %s", err)
+	}
+	tun := device.(*tunLinux)
+	defer tun.Wait()
+	defer tun.Close()
+
+	ep, err := tun.AsLinkEndpoint()
+	require.NoError(b, err)
+	ep.Attach(&countDispatcher{})
+
+	b.SetBytes(1028)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for seq := 0; pb.Next(); seq++ {
+			pkts := flowPacket(0, seq, 9)
+			ep.WritePackets(pkts)
+			pkts.DecRef()
+		}
+	})
+	b.StopTimer()
+	stats := tun.Stats()
+	b.ReportMetric(float64(stats.WritePackets)/float64(b.N), "written/op")
+	b.ReportMetric(float64(stats.QueueDrops)/float64(b.N), "dropped/op")
+}
+
+func TestFdSocketAddress(t *testing.T) {
+	for raw, address := range map[string]string{
+		"unix://@clash-tun?mtu=1500":  "@clash-tun",
+		"unix:///data/clash/tun.sock": "/data/clash/tun.sock",
+	} {
+		u, _ := url.Parse(raw)
+		got, err := fdSocketAddress(*u)
+		require.NoError(t, err, raw)
+		assert.Equal(t, address, got)
+	}
+	for _, raw := range []string{"unix://clash-tun", "unix://", "unix://user@host/path"} {
+		u, _ := url.Parse(raw)
+		_, err := fdSocketAddress(*u)
+		assert.ErrorContains(t, err, "the format is", raw)
+	}
+}
+
+func TestReceiveTunFd(t *testing.T) {
+	address := fmt.Sprintf("@clash-test-tun-%d", time.Now().UnixNano())
+	l, err := net.Listen("unix", address)
+	require.NoError(t, err)
+	defer l.Close()
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+	defer w.Close()
+
+	send := func(handshake string, fds ...int) {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.(*net.UnixConn).WriteMsgUnix([]byte(handshake), unix.UnixRights(fds...), nil)
+	}
+
+	go send("name=tun0&mtu=1400", int(w.Fd()))
+	fd, handshake, err := receiveTunFd(address)
+	require.NoError(t, err)
+	assert.Equal(t, fdHandshake{name: "tun0", mtu: 1400}, handshake)
+
+	// the received fd is the write end of the pipe
+	_, err = unix.Write(fd, []byte("ping"))
+	require.NoError(t, err)
+	unix.Close(fd)
+	buf := make([]byte, 4)
+	_, err = r.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	go send("name=tun0&mtu=1400")
+	_, _, err = receiveTunFd(address)
+	assert.ErrorContains(t, err, "expect a single fd")
+
+	go send("mtu=1400", int(w.Fd()))
+	_, _, err = receiveTunFd(address)
+	assert.ErrorContains(t, err, "invalid interface name")
+}
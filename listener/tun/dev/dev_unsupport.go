@@ -1,4 +1,5 @@
-// +build !linux,!android,!darwin
+//go:build !linux && !android && !darwin && !windows
+// +build !linux,!android,!darwin,!windows
 
 package dev
 
@@ -9,6 +10,9 @@ import (
 	"net/url"
 )
 
-func OpenTunDevice(_ url.URL) (TunDevice, error) {
+// Supported reports whether a tun device can be opened on this platform
+const Supported = false
+
+func openDevice(_ url.URL) (TunDevice, error) {
 	return nil, errors.New("Unsupported platform " + runtime.GOOS + "/" + runtime.GOARCH)
 }
@@ -0,0 +1,403 @@
+//go:build windows
+// +build windows
+
+package dev
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+	"go.uber.org/atomic"
+	"golang.org/x/sys/windows"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	// deviceURLFormat is shown by the errors of a bad dev:// url
+	deviceURLFormat = "dev://NAME?mtu=MTU&qlen=PACKETS"
+
+	// ringCapacity is the size of the wintun session rings, a power of 2 between 128 KiB and 64 MiB
+	ringCapacity = 0x800000
+
+	tunnelType = "Clash"
+)
+
+// wintun is wintun.dll, loaded from the home directory, the directory of the executable or
+// system32 on the first device opened
+var wintun struct {
+	once sync.Once
+	err  error
+
+	createAdapter        uintptr
+	openAdapter          uintptr
+	closeAdapter         uintptr
+	startSession         uintptr
+	endSession           uintptr
+	getReadWaitEvent     uintptr
+	receivePacket        uintptr
+	releaseReceivePacket uintptr
+	allocateSendPacket   uintptr
+	sendPacket           uintptr
+}
+
+func loadWintun() error {
+	wintun.once.Do(func() {
+		path, flags := "wintun.dll", uintptr(windows.LOAD_LIBRARY_SEARCH_APPLICATION_DIR|windows.LOAD_LIBRARY_SEARCH_SYSTEM32)
+		if home := filepath.Join(C.Path.HomeDir(), "wintun.dll"); fileExists(home) {
+			path, flags = home, windows.LOAD_LIBRARY_SEARCH_DLL_LOAD_DIR|windows.LOAD_LIBRARY_SEARCH_SYSTEM32
+		}
+		dll, err := windows.LoadLibraryEx(path, 0, flags)
+		if err != nil {
+			wintun.err = fmt.Errorf("load wintun.dll: %w, download it from https://www.wintun.net and put it in the home directory", err)
+			return
+		}
+		for name, proc := range map[string]*uintptr{
+			"WintunCreateAdapter":        &wintun.createAdapter,
+			"WintunOpenAdapter":          &wintun.openAdapter,
+			"WintunCloseAdapter":         &wintun.closeAdapter,
+			"WintunStartSession":         &wintun.startSession,
+			"WintunEndSession":           &wintun.endSession,
+			"WintunGetReadWaitEvent":     &wintun.getReadWaitEvent,
+			"WintunReceivePacket":        &wintun.receivePacket,
+			"WintunReleaseReceivePacket": &wintun.releaseReceivePacket,
+			"WintunAllocateSendPacket":   &wintun.allocateSendPacket,
+			"WintunSendPacket":           &wintun.sendPacket,
+		} {
+			if *proc, err = windows.GetProcAddress(dll, name); err != nil {
+				wintun.err = fmt.Errorf("wintun.dll: %s: %w", name, err)
+				return
+			}
+		}
+	})
+	return wintun.err
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// adapterGUID derives the guid of an adapter from its name, so windows sees the same network
+// on each start instead of a new one
+func adapterGUID(name string) windows.GUID {
+	sum := sha256.Sum256([]byte("clash wintun " + name))
+	guid := windows.GUID{
+		Data1: uint32(sum[0])<<24 | uint32(sum[1])<<16 | uint32(sum[2])<<8 | uint32(sum[3]),
+		Data2: uint16(sum[4])<<8 | uint16(sum[5]),
+		Data3: uint16(sum[6])<<8 | uint16(sum[7]),
+	}
+	copy(guid.Data4[:], sum[8:16])
+	return guid
+}
+
+// bytesAt return the size bytes of a packet in a wintun ring
+func bytesAt(ptr uintptr, size uint32) []byte {
+	return unsafe.Slice(*(**byte)(unsafe.Pointer(&ptr)), size)
+}
+
+type tunWindows struct {
+	url       string
+	name      string
+	mtu       int
+	adapter   uintptr
+	session   uintptr
+	readWait  windows.Handle
+	stop      windows.Handle
+	linkCache *queueEndpoint
+	qlen      int
+	counters  deviceCounters
+
+	closed atomic.Bool
+	// writeMux keeps Close from ending the session under a write of the writer
+	writeMux    sync.RWMutex
+	stopWriter  context.CancelFunc
+	stopOnce    sync.Once
+	releaseOnce sync.Once
+	wg          sync.WaitGroup // wait for goroutines to stop
+}
+
+// Supported reports whether a tun device can be opened on this platform
+const Supported = true
+
+// openDevice return a TunDevice according a URL, dev://NAME opens the wintun adapter of
+// the name or creates it, a created adapter is removed on close
+func openDevice(deviceURL url.URL) (TunDevice, error) {
+	if deviceURL.Scheme != "dev" {
+		return nil, fmt.Errorf("unsupported device type `%s`", deviceURL.Scheme)
+	}
+	if addressing, err := parseAddressing(deviceURL.Query()); err != nil || !addressing.empty() {
+		return nil, errors.New("addr, peer, prefix and addr6 of the device url are only supported on linux")
+	}
+	if deviceURL.Host == "" {
+		return nil, fmt.Errorf("invalid tun device url %s, the format is %s", deviceURL.String(), deviceURLFormat)
+	}
+
+	var mtu int
+	if value := deviceURL.Query().Get("mtu"); value != "" {
+		var err error
+		if mtu, err = strconv.Atoi(value); err != nil || mtu < 576 || mtu > 65535 {
+			return nil, fmt.Errorf("invalid tun device url %s, mtu %s: expect 576-65535", deviceURL.String(), value)
+		}
+	}
+
+	qlen, err := parseQueueLength(deviceURL.Query())
+	if err != nil {
+		return nil, fmt.Errorf("invalid tun device url %s: %w", deviceURL.String(), err)
+	}
+
+	if err := loadWintun(); err != nil {
+		return nil, err
+	}
+
+	t := &tunWindows{
+		url:  deviceURL.String(),
+		name: deviceURL.Host,
+		mtu:  mtu,
+		qlen: qlen,
+	}
+	if err := t.open(); err != nil {
+		return nil, err
+	}
+	if mtu > 0 {
+		if err := t.setMTU(mtu); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *tunWindows) open() error {
+	name, err := windows.UTF16PtrFromString(t.name)
+	if err != nil {
+		return err
+	}
+	typ, _ := windows.UTF16PtrFromString(tunnelType)
+
+	adapter, _, _ := syscall.SyscallN(wintun.openAdapter, uintptr(unsafe.Pointer(name)))
+	if adapter == 0 {
+		guid := adapterGUID(t.name)
+		var errno syscall.Errno
+		adapter, _, errno = syscall.SyscallN(wintun.createAdapter,
+			uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(typ)), uintptr(unsafe.Pointer(&guid)))
+		if adapter == 0 {
+			if errno == windows.ERROR_ACCESS_DENIED {
+				return fmt.Errorf("create wintun adapter %s: %w, creating an adapter needs administrator", t.name, errno)
+			}
+			return fmt.Errorf("create wintun adapter %s: %w", t.name, errno)
+		}
+	}
+	t.adapter = adapter
+
+	session, _, errno := syscall.SyscallN(wintun.startSession, adapter, ringCapacity)
+	if session == 0 {
+		syscall.SyscallN(wintun.closeAdapter, adapter)
+		return fmt.Errorf("start session of wintun adapter %s: %w", t.name, errno)
+	}
+	t.session = session
+	event, _, _ := syscall.SyscallN(wintun.getReadWaitEvent, session)
+	t.readWait = windows.Handle(event)
+
+	if t.stop, err = windows.CreateEvent(nil, 1, 0, nil); err != nil {
+		t.release()
+		return err
+	}
+	return nil
+}
+
+func (t *tunWindows) Name() string {
+	return t.name
+}
+
+func (t *tunWindows) URL() string {
+	return t.url
+}
+
+func (t *tunWindows) Addressing() Addressing {
+	return Addressing{}
+}
+
+func (t *tunWindows) Stats() DeviceStats {
+	return t.counters.stats()
+}
+
+// MTU return the mtu of the url, or the one of the adapter
+func (t *tunWindows) MTU() (int, error) {
+	if t.mtu > 0 {
+		return t.mtu, nil
+	}
+	iface, err := net.InterfaceByName(t.name)
+	if err != nil {
+		return 0, err
+	}
+	return iface.MTU, nil
+}
+
+func (t *tunWindows) AsLinkEndpoint() (result stack.LinkEndpoint, err error) {
+	if t.closed.Load() {
+		return nil, errors.New("device closed")
+	}
+	if t.linkCache != nil {
+		return t.linkCache, nil
+	}
+
+	mtu, err := t.MTU()
+	if err != nil {
+		return nil, errors.New("unable to get device mtu")
+	}
+
+	linkEP := newQueueEndpoint(t.qlen, uint32(mtu), &t.counters)
+
+	// the session is released by the read loop once it stopped reading the ring
+	t.wg.Add(1)
+	go t.readLoop(linkEP)
+
+	// the writer drains the queue of the ipstack into the send ring
+	ctx, cancel := context.WithCancel(context.Background())
+	t.stopWriter = cancel
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		linkEP.runWriter(ctx, t.writePacket)
+	}()
+	t.linkCache = linkEP
+	return t.linkCache, nil
+}
+
+// readLoop takes the packets of the receive ring until it is empty, then waits for the read
+// event of the session or the stop of Close
+func (t *tunWindows) readLoop(linkEP *queueEndpoint) {
+	defer t.wg.Done()
+	defer t.release()
+
+	handles := []windows.Handle{t.readWait, t.stop}
+	for !t.closed.Load() {
+		var size uint32
+		packet, _, errno := syscall.SyscallN(wintun.receivePacket, t.session, uintptr(unsafe.Pointer(&size)))
+		if packet == 0 {
+			switch errno {
+			case windows.ERROR_NO_MORE_ITEMS:
+				if _, err := windows.WaitForMultipleObjects(handles, false, windows.INFINITE); err != nil {
+					log.Errorln("can not wait for tun: %v", err)
+					t.Close()
+				}
+				continue
+			case windows.ERROR_HANDLE_EOF:
+			default:
+				if !t.closed.Load() {
+					log.Dedupln(log.ERROR, errno.Error(), "can not read from tun: %v", errno)
+				}
+			}
+			break
+		}
+
+		buf := bytesAt(packet, size)
+		t.counters.read(len(buf))
+		var p tcpip.NetworkProtocolNumber
+		switch header.IPVersion(buf) {
+		case header.IPv4Version:
+			p = header.IPv4ProtocolNumber
+		case header.IPv6Version:
+			p = header.IPv6ProtocolNumber
+		}
+		// the payload is copied out of the ring before the packet is released
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Payload: buffer.MakeWithData(buf),
+		})
+		if !linkEP.deliver(p, pkt) {
+			log.Debugln("received packet from tun when %s is not attached to any dispatcher.", t.Name())
+		}
+		pkt.DecRef()
+		syscall.SyscallN(wintun.releaseReceivePacket, t.session, packet)
+	}
+	t.Close()
+	log.Debugln("%v stop read loop", t.Name())
+}
+
+func (t *tunWindows) Write(buff []byte) (int, error) {
+	packet, _, errno := syscall.SyscallN(wintun.allocateSendPacket, t.session, uintptr(len(buff)))
+	if packet == 0 {
+		// ERROR_BUFFER_OVERFLOW is a full send ring, the packet is dropped like a full tun queue does
+		return 0, errno
+	}
+	copy(bytesAt(packet, uint32(len(buff))), buff)
+	syscall.SyscallN(wintun.sendPacket, t.session, packet)
+	return len(buff), nil
+}
+
+// writePacket writes a packet queued by the ipstack to the send ring
+func (t *tunWindows) writePacket(packet stack.PacketBufferPtr) {
+	// a packet left for a closed device, replaced by another one, is dropped
+	t.writeMux.RLock()
+	defer t.writeMux.RUnlock()
+	if t.closed.Load() {
+		return
+	}
+
+	n, err := t.Write(packet.ToView().AsSlice())
+	t.counters.write(n, err)
+	if err != nil {
+		log.Dedupln(log.ERROR, err.Error(), "can not write to tun: %v", err)
+	}
+}
+
+func (t *tunWindows) Close() {
+	t.stopOnce.Do(func() {
+		t.writeMux.Lock()
+		t.closed.Store(true)
+		t.writeMux.Unlock()
+		if t.linkCache != nil {
+			t.stopWriter()
+			t.linkCache.Drain()
+			windows.SetEvent(t.stop)
+			return
+		}
+		t.release()
+	})
+}
+
+// release ends the session and closes the adapter, which removes it if it was created by
+// open. It runs once nothing reads or writes the rings anymore.
+func (t *tunWindows) release() {
+	t.releaseOnce.Do(func() {
+		if t.session != 0 {
+			syscall.SyscallN(wintun.endSession, t.session)
+		}
+		syscall.SyscallN(wintun.closeAdapter, t.adapter)
+		if t.stop != 0 {
+			windows.CloseHandle(t.stop)
+		}
+	})
+}
+
+// Wait wait goroutines to exit
+func (t *tunWindows) Wait() {
+	t.wg.Wait()
+}
+
+// setMTU sets the mtu of both the ipv4 and the ipv6 interface of the adapter
+func (t *tunWindows) setMTU(mtu int) error {
+	for _, family := range []string{"ipv4", "ipv6"} {
+		cmd := exec.Command("netsh", "interface", family, "set", "subinterface", t.name, "mtu="+strconv.Itoa(mtu), "store=active")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("set mtu of tun %s: %w: %s", t.name, err, out)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+//go:build linux || android
+// +build linux android
+
+package dev
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// fdSocketURLFormat is shown by the errors of a bad unix:// url
+	fdSocketURLFormat = "unix://@ABSTRACT-NAME or unix:///PATH?mtu=MTU&qlen=PACKETS&addr=IPV4[/PREFIX]&addr6=IPV6[/PREFIX]"
+
+	// fdSocketTimeout bounds the connect and the handshake of a unix:// device
+	fdSocketTimeout = 10 * time.Second
+)
+
+// fdHandshake is the message the tun fd comes with over the socket of a unix:// url,
+// a url query name=NAME&mtu=MTU. The fd of a VpnService isn't a clone of /dev/net/tun
+// and fails TUNGETIFF, the handshake tells what the probe would
+type fdHandshake struct {
+	name string
+	mtu  int
+}
+
+// fdSocketAddress is the unix socket of unix://@NAME, abstract, or unix:///PATH
+func fdSocketAddress(u url.URL) (string, error) {
+	switch {
+	case u.User != nil && u.Host != "" && u.Path == "":
+		return "@" + u.Host, nil
+	case u.User == nil && u.Host == "" && u.Path != "":
+		return u.Path, nil
+	}
+	return "", fmt.Errorf("invalid tun device url %s, the format is %s", u.String(), fdSocketURLFormat)
+}
+
+func parseFdHandshake(b []byte) (h fdHandshake, err error) {
+	query, err := url.ParseQuery(string(b))
+	if err != nil {
+		return h, fmt.Errorf("handshake %q: %w", b, err)
+	}
+	if h.name = query.Get("name"); h.name == "" || len(h.name) >= unix.IFNAMSIZ {
+		return h, fmt.Errorf("handshake %q: invalid interface name", b)
+	}
+	if value := query.Get("mtu"); value != "" {
+		if h.mtu, err = strconv.Atoi(value); err != nil || h.mtu <= 0 {
+			return h, fmt.Errorf("handshake %q: invalid mtu %s", b, value)
+		}
+	}
+	return h, nil
+}
+
+// receiveTunFd connects to the socket and takes the fd sent with SCM_RIGHTS along the
+// handshake, the first message
+func receiveTunFd(address string) (int, fdHandshake, error) {
+	conn, err := net.DialTimeout("unix", address, fdSocketTimeout)
+	if err != nil {
+		return -1, fdHandshake{}, err
+	}
+	defer conn.Close()
+	unixConn := conn.(*net.UnixConn)
+	unixConn.SetDeadline(time.Now().Add(fdSocketTimeout))
+
+	buf := make([]byte, 512)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, flags, _, err := unixConn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return -1, fdHandshake{}, fmt.Errorf("receive tun fd from %s: %w", address, err)
+	}
+
+	fds := []int{}
+	if msgs, err := unix.ParseSocketControlMessage(oob[:oobn]); err == nil {
+		for i := range msgs {
+			if rights, err := unix.ParseUnixRights(&msgs[i]); err == nil {
+				fds = append(fds, rights...)
+			}
+		}
+	}
+	closeAll := func() {
+		for _, fd := range fds {
+			unix.Close(fd)
+		}
+	}
+	if flags&unix.MSG_CTRUNC != 0 || len(fds) != 1 {
+		closeAll()
+		return -1, fdHandshake{}, fmt.Errorf("receive tun fd from %s: expect a single fd with the handshake", address)
+	}
+
+	handshake, err := parseFdHandshake(buf[:n])
+	if err != nil {
+		closeAll()
+		return -1, fdHandshake{}, fmt.Errorf("receive tun fd from %s: %w", address, err)
+	}
+	return fds[0], handshake, nil
+}
+
+// openDeviceBySocket takes the device fd from the socket, it isn't probed with TUNGETIFF
+// and its flags aren't checked, the sender vouches for a tun without packet information
+func (t *tunLinux) openDeviceBySocket(address string) (TunDevice, error) {
+	fd, handshake, err := receiveTunFd(address)
+	if err != nil {
+		return nil, err
+	}
+	// the mtu of the url wins over the handshake
+	if t.mtu <= 0 {
+		t.mtu = handshake.mtu
+	}
+	if t.mtu <= 0 {
+		unix.Close(fd)
+		return nil, fmt.Errorf("tun fd from %s: no mtu in the handshake or the url", address)
+	}
+
+	if err := t.adoptFd(fd, handshake.name); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,220 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/Dreamacro/clash/log"
+	"go.uber.org/atomic"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	// memoryURLFormat is shown by the errors of a bad mem:// url
+	memoryURLFormat = "mem://[NAME]?mtu=MTU&qlen=PACKETS"
+
+	defaultMemoryName = "mem"
+	defaultMemoryMTU  = 1500
+)
+
+// MemoryTunDevice is a TunDevice over channels, a packet of Inject is read by the ipstack
+// and the packets the ipstack writes come out of Output. It needs no privilege, mem:// opens
+// one on every platform for the tests of the ipstack
+type MemoryTunDevice struct {
+	url       string
+	name      string
+	mtu       int
+	inbound   chan []byte
+	outbound  chan []byte
+	linkCache *queueEndpoint
+	qlen      int
+	counters  deviceCounters
+
+	closed     atomic.Bool
+	done       chan struct{}
+	stopWriter context.CancelFunc
+	stopOnce   sync.Once
+	wg         sync.WaitGroup // wait for goroutines to stop
+}
+
+// NewMemoryTunDevice return a memory device of the mtu, as mem://?mtu=MTU
+func NewMemoryTunDevice(mtu int) *MemoryTunDevice {
+	return newMemoryTunDevice(fmt.Sprintf("mem://?mtu=%d", mtu), defaultMemoryName, mtu, defaultQueueLength)
+}
+
+func newMemoryTunDevice(url, name string, mtu, qlen int) *MemoryTunDevice {
+	return &MemoryTunDevice{
+		url:      url,
+		name:     name,
+		mtu:      mtu,
+		qlen:     qlen,
+		inbound:  make(chan []byte, qlen),
+		outbound: make(chan []byte, qlen),
+		done:     make(chan struct{}),
+	}
+}
+
+// openMemoryDevice opens mem://NAME?mtu=MTU&qlen=PACKETS, the name is mem when not given
+// and the mtu 1500
+func openMemoryDevice(deviceURL url.URL) (TunDevice, error) {
+	mtu := defaultMemoryMTU
+	if value := deviceURL.Query().Get("mtu"); value != "" {
+		var err error
+		if mtu, err = strconv.Atoi(value); err != nil || mtu < header.IPv4MinimumMTU || mtu > 65535 {
+			return nil, fmt.Errorf("invalid tun device url %s, the format is %s: mtu %s", deviceURL.String(), memoryURLFormat, value)
+		}
+	}
+	qlen, err := parseQueueLength(deviceURL.Query())
+	if err != nil {
+		return nil, fmt.Errorf("invalid tun device url %s: %w", deviceURL.String(), err)
+	}
+	name := deviceURL.Host
+	if name == "" {
+		name = defaultMemoryName
+	}
+	return newMemoryTunDevice(deviceURL.String(), name, mtu, qlen), nil
+}
+
+func (t *MemoryTunDevice) Name() string {
+	return t.name
+}
+
+func (t *MemoryTunDevice) URL() string {
+	return t.url
+}
+
+// Addressing is empty, nothing is set on a memory device
+func (t *MemoryTunDevice) Addressing() Addressing {
+	return Addressing{}
+}
+
+func (t *MemoryTunDevice) Stats() DeviceStats {
+	return t.counters.stats()
+}
+
+// Inject queues a packet to be read by the ipstack, as if sent to the tun by the system,
+// it blocks on a full queue
+func (t *MemoryTunDevice) Inject(packet []byte) error {
+	if t.closed.Load() {
+		return io.ErrClosedPipe
+	}
+	select {
+	case t.inbound <- packet:
+		return nil
+	case <-t.done:
+		return io.ErrClosedPipe
+	}
+}
+
+// Output is the packets written by the ipstack, a full channel stalls the writer and the
+// queue of the ipstack fills up, as with a slow tun
+func (t *MemoryTunDevice) Output() <-chan []byte {
+	return t.outbound
+}
+
+// Read blocks for an injected packet, a packet beyond buff is truncated like on a tun
+func (t *MemoryTunDevice) Read(buff []byte) (int, error) {
+	select {
+	case packet := <-t.inbound:
+		return copy(buff, packet), nil
+	case <-t.done:
+		return 0, io.EOF
+	}
+}
+
+// Write puts a copy of the packet on Output, it blocks while Output is full
+func (t *MemoryTunDevice) Write(buff []byte) (int, error) {
+	return t.output(append([]byte(nil), buff...))
+}
+
+func (t *MemoryTunDevice) output(packet []byte) (int, error) {
+	select {
+	case t.outbound <- packet:
+		return len(packet), nil
+	case <-t.done:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (t *MemoryTunDevice) AsLinkEndpoint() (result stack.LinkEndpoint, err error) {
+	if t.linkCache != nil {
+		return t.linkCache, nil
+	}
+
+	linkEP := newQueueEndpoint(t.qlen, uint32(t.mtu), &t.counters)
+
+	t.wg.Add(1)
+	go t.readLoop(linkEP)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.stopWriter = cancel
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		linkEP.runWriter(ctx, t.writePacket)
+	}()
+	t.linkCache = linkEP
+	return t.linkCache, nil
+}
+
+func (t *MemoryTunDevice) readLoop(linkEP *queueEndpoint) {
+	defer t.wg.Done()
+
+	for {
+		v := buffer.NewViewSize(t.mtu)
+		n, err := t.Read(v.AsSlice())
+		if err != nil {
+			v.Release()
+			break
+		}
+		v.CapLength(n)
+		t.counters.read(n)
+
+		var p tcpip.NetworkProtocolNumber
+		switch header.IPVersion(v.AsSlice()) {
+		case header.IPv4Version:
+			p = header.IPv4ProtocolNumber
+		case header.IPv6Version:
+			p = header.IPv6ProtocolNumber
+		}
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithView(v)})
+		if !linkEP.deliver(p, pkt) {
+			log.Debugln("received packet from tun when %s is not attached to any dispatcher.", t.Name())
+		}
+		pkt.DecRef()
+	}
+	log.Debugln("%v stop read loop", t.Name())
+}
+
+func (t *MemoryTunDevice) writePacket(packet stack.PacketBufferPtr) {
+	if t.closed.Load() {
+		return
+	}
+	b := make([]byte, 0, packet.Size())
+	for _, slice := range packet.AsSlices() {
+		b = append(b, slice...)
+	}
+	t.counters.write(t.output(b))
+}
+
+func (t *MemoryTunDevice) Close() {
+	t.stopOnce.Do(func() {
+		t.closed.Store(true)
+		close(t.done)
+		if t.linkCache != nil {
+			t.stopWriter()
+			t.linkCache.Drain()
+		}
+	})
+}
+
+func (t *MemoryTunDevice) Wait() {
+	t.wg.Wait()
+}
@@ -0,0 +1,62 @@
+package dev
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func TestOpenTunDevice_Memory(t *testing.T) {
+	u, _ := url.Parse("mem://clash0?mtu=9000&qlen=64")
+	device, err := OpenTunDevice(*u)
+	require.NoError(t, err)
+	defer device.Close()
+	memory := device.(*MemoryTunDevice)
+	assert.Equal(t, "clash0", memory.Name())
+	assert.Equal(t, 9000, memory.mtu)
+	assert.Equal(t, 64, memory.qlen)
+
+	assert.Equal(t, "mem", NewMemoryTunDevice(1500).Name())
+
+	for _, deviceURL := range []string{"mem://?mtu=1", "mem://?mtu=many", "mem://?qlen=0"} {
+		u, _ := url.Parse(deviceURL)
+		_, err := OpenTunDevice(*u)
+		assert.Error(t, err, deviceURL)
+	}
+}
+
+func TestMemoryTunDevice(t *testing.T) {
+	device := NewMemoryTunDevice(1500)
+	linkEP, err := device.AsLinkEndpoint()
+	require.NoError(t, err)
+
+	// nothing attached, the injected packet is dropped
+	packet := make([]byte, header.IPv4MinimumSize)
+	packet[0] = header.IPv4Version << 4
+	require.NoError(t, device.Inject(packet))
+	assert.Eventually(t, func() bool { return device.Stats().ReadDrops == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(1), device.Stats().ReadPackets)
+
+	var pkts stack.PacketBufferList
+	pkts.PushBack(stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData([]byte("packet"))}))
+	n, _ := linkEP.WritePackets(pkts)
+	assert.Equal(t, 1, n)
+	pkts.DecRef()
+	select {
+	case out := <-device.Output():
+		assert.Equal(t, []byte("packet"), out)
+	case <-time.After(time.Second):
+		require.FailNow(t, "no packet out of the device")
+	}
+	assert.Equal(t, uint64(6), device.Stats().WriteBytes)
+
+	device.Close()
+	device.Wait()
+	assert.Error(t, device.Inject(packet))
+}
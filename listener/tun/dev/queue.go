@@ -0,0 +1,75 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	// defaultQueueLength is the packets the ipstack queues for the writer of a device
+	defaultQueueLength = 512
+	maxQueueLength     = 65536
+)
+
+// parseQueueLength reads qlen=PACKETS of a device url
+func parseQueueLength(query url.Values) (int, error) {
+	value := query.Get("qlen")
+	if value == "" {
+		return defaultQueueLength, nil
+	}
+	qlen, err := strconv.Atoi(value)
+	if err != nil || qlen < 1 || qlen > maxQueueLength {
+		return 0, fmt.Errorf("qlen %s: expect 1-%d", value, maxQueueLength)
+	}
+	return qlen, nil
+}
+
+// queueEndpoint is the link endpoint of a device, it counts the packets the ipstack drops
+// because the queue to the writer is full
+type queueEndpoint struct {
+	*channel.Endpoint
+	counters *deviceCounters
+}
+
+func newQueueEndpoint(qlen int, mtu uint32, counters *deviceCounters) *queueEndpoint {
+	return &queueEndpoint{Endpoint: channel.New(qlen, mtu, ""), counters: counters}
+}
+
+// WritePackets implements stack.LinkEndpoint.WritePackets
+func (e *queueEndpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Error) {
+	n, err := e.Endpoint.WritePackets(pkts)
+	if dropped := pkts.Len() - n; dropped > 0 {
+		e.counters.queueDrops.Add(uint64(dropped))
+	}
+	return n, err
+}
+
+// deliver hands a packet read from the device to the ipstack, a packet read while nothing
+// is attached is dropped and counted
+func (e *queueEndpoint) deliver(protocol tcpip.NetworkProtocolNumber, pkt stack.PacketBufferPtr) bool {
+	if !e.IsAttached() {
+		e.counters.readDrops.Inc()
+		return false
+	}
+	e.InjectInbound(protocol, pkt)
+	return true
+}
+
+// runWriter writes the packets queued by the ipstack until ctx is done, off the write path
+// of the ipstack so a slow write to the device only backs up the queue
+func (e *queueEndpoint) runWriter(ctx context.Context, write func(stack.PacketBufferPtr)) {
+	for {
+		packet := e.ReadContext(ctx)
+		if packet.IsNil() {
+			return
+		}
+		write(packet)
+		packet.DecRef()
+	}
+}
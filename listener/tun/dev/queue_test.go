@@ -0,0 +1,82 @@
+package dev
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func TestParseQueueLength(t *testing.T) {
+	qlen, err := parseQueueLength(url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, defaultQueueLength, qlen)
+
+	qlen, err = parseQueueLength(url.Values{"qlen": {"4096"}})
+	require.NoError(t, err)
+	assert.Equal(t, 4096, qlen)
+
+	for _, value := range []string{"0", "65537", "many"} {
+		_, err := parseQueueLength(url.Values{"qlen": {value}})
+		assert.ErrorContains(t, err, "expect 1-65536", value)
+	}
+}
+
+func queuedPackets(n int) stack.PacketBufferList {
+	var pkts stack.PacketBufferList
+	for i := 0; i < n; i++ {
+		pkts.PushBack(stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(make([]byte, 20))}))
+	}
+	return pkts
+}
+
+func TestQueueEndpoint_Drops(t *testing.T) {
+	counters := &deviceCounters{}
+	ep := newQueueEndpoint(2, 1500, counters)
+
+	pkts := queuedPackets(5)
+	n, err := ep.WritePackets(pkts)
+	pkts.DecRef()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.EqualValues(t, 3, counters.stats().QueueDrops)
+	ep.Drain()
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(make([]byte, 20))})
+	assert.False(t, ep.deliver(header.IPv4ProtocolNumber, pkt))
+	pkt.DecRef()
+	assert.EqualValues(t, 1, counters.stats().ReadDrops)
+}
+
+// TestQueueEndpoint_Writer drains the queue without a notification and stops with ctx
+func TestQueueEndpoint_Writer(t *testing.T) {
+	ep := newQueueEndpoint(8, 1500, &deviceCounters{})
+	written := make(chan int, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ep.runWriter(ctx, func(pkt stack.PacketBufferPtr) {
+			written <- pkt.Size()
+		})
+	}()
+
+	pkts := queuedPackets(3)
+	n, _ := ep.WritePackets(pkts)
+	pkts.DecRef()
+	assert.Equal(t, 3, n)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 20, <-written)
+	}
+
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,53 @@
+package dev
+
+import "go.uber.org/atomic"
+
+// DeviceStats are the packets and bytes between the device and the ipstack. ReadDrops are
+// the packets read with no ipstack attached, QueueDrops the ones of the ipstack dropped on
+// the full queue to the device, see qlen of the device url
+type DeviceStats struct {
+	ReadPackets  uint64 `json:"readPackets"`
+	ReadBytes    uint64 `json:"readBytes"`
+	ReadDrops    uint64 `json:"readDrops"`
+	WritePackets uint64 `json:"writePackets"`
+	WriteBytes   uint64 `json:"writeBytes"`
+	WriteErrors  uint64 `json:"writeErrors"`
+	QueueDrops   uint64 `json:"queueDrops"`
+}
+
+// deviceCounters are updated by the read loops, the writer and the ipstack without a lock
+type deviceCounters struct {
+	readPackets  atomic.Uint64
+	readBytes    atomic.Uint64
+	readDrops    atomic.Uint64
+	writePackets atomic.Uint64
+	writeBytes   atomic.Uint64
+	writeErrors  atomic.Uint64
+	queueDrops   atomic.Uint64
+}
+
+func (c *deviceCounters) read(n int) {
+	c.readPackets.Inc()
+	c.readBytes.Add(uint64(n))
+}
+
+func (c *deviceCounters) write(n int, err error) {
+	if err != nil {
+		c.writeErrors.Inc()
+		return
+	}
+	c.writePackets.Inc()
+	c.writeBytes.Add(uint64(n))
+}
+
+func (c *deviceCounters) stats() DeviceStats {
+	return DeviceStats{
+		ReadPackets:  c.readPackets.Load(),
+		ReadBytes:    c.readBytes.Load(),
+		ReadDrops:    c.readDrops.Load(),
+		WritePackets: c.writePackets.Load(),
+		WriteBytes:   c.writeBytes.Load(),
+		WriteErrors:  c.writeErrors.Load(),
+		QueueDrops:   c.queueDrops.Load(),
+	}
+}
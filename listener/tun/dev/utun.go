@@ -0,0 +1,47 @@
+package dev
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// the utun devices of darwin put the address family of the packet before it, in 4 bytes of
+// network order, the values are the darwin ones and not the AF_* of the building platform
+const (
+	utunHeaderSize = 4
+	utunAFInet     = 2
+	utunAFInet6    = 30
+)
+
+// utunFrame writes packet with its utun header to buf and return the frame, buf must have
+// utunHeaderSize bytes more than the packet
+func utunFrame(buf, packet []byte) ([]byte, error) {
+	if len(buf) < utunHeaderSize+len(packet) {
+		return nil, fmt.Errorf("packet of %d bytes is too large for the utun buffer", len(packet))
+	}
+	if len(packet) == 0 {
+		return nil, fmt.Errorf("empty packet")
+	}
+
+	family := uint32(utunAFInet)
+	if packet[0]>>4 == 6 {
+		family = utunAFInet6
+	}
+	binary.BigEndian.PutUint32(buf, family)
+	copy(buf[utunHeaderSize:], packet)
+	return buf[:utunHeaderSize+len(packet)], nil
+}
+
+// utunPacket moves the packet of the n bytes utun frame in buf to the start of buf and
+// return its length
+func utunPacket(buf []byte, n int) (int, error) {
+	if n < utunHeaderSize {
+		return 0, fmt.Errorf("short utun frame of %d bytes", n)
+	}
+	switch family := binary.BigEndian.Uint32(buf); family {
+	case utunAFInet, utunAFInet6:
+	default:
+		return 0, fmt.Errorf("unknown address family %d of the utun frame", family)
+	}
+	return copy(buf, buf[utunHeaderSize:n]), nil
+}
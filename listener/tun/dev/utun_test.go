@@ -0,0 +1,52 @@
+package dev
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echo requests 198.18.0.1 > 1.1.1.1 and fd00::1 > 2001:4860:4860::8888 read from a tun
+const (
+	capturedIPv4 = "4500002c3a1f40004001389dc6120001010101010800dff91c2d0001636c6173682d70696e672d6461746121"
+	capturedIPv6 = "6000000000183a40fd000000000000000000000000000001200148604860000000000000000088888000315b1c2d0001636c6173682d70696e672d6461746121"
+)
+
+func TestUtunFrame_RoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		packet string
+		header []byte
+	}{
+		{"ipv4", capturedIPv4, []byte{0, 0, 0, 2}},
+		{"ipv6", capturedIPv6, []byte{0, 0, 0, 30}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			packet, _ := hex.DecodeString(tt.packet)
+
+			frame, err := utunFrame(make([]byte, 1500), packet)
+			require.NoError(t, err)
+			assert.Equal(t, tt.header, frame[:utunHeaderSize])
+			assert.Equal(t, packet, frame[utunHeaderSize:])
+
+			buf := make([]byte, 1500)
+			n, err := utunPacket(buf, copy(buf, frame))
+			require.NoError(t, err)
+			assert.Equal(t, packet, buf[:n])
+		})
+	}
+}
+
+func TestUtunFrame_Bad(t *testing.T) {
+	packet, _ := hex.DecodeString(capturedIPv4)
+	_, err := utunFrame(make([]byte, len(packet)), packet)
+	assert.Error(t, err)
+
+	_, err = utunPacket([]byte{0, 0}, 2)
+	assert.Error(t, err)
+
+	_, err = utunPacket(append([]byte{0, 0, 0, 17}, packet...), utunHeaderSize+len(packet))
+	assert.Error(t, err)
+}
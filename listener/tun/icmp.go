@@ -0,0 +1,137 @@
+package tun
+
+import (
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/nested"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// icmpEndpoint answers the icmp echo requests read from the tun for any destination, the
+// fake ips included, before they reach the ipstack. None of the outbounds carries icmp, so
+// the reply is made up locally, with the ttl, identifier, sequence and data of the request.
+// With a hop in trace a packet arriving with a ttl of 1 is answered by a time exceeded instead.
+// The neighbor solicitations of the addr6 prefix are answered here too.
+type icmpEndpoint struct {
+	nested.Endpoint
+	trace     traceOptions
+	neighbors *neighbors6
+}
+
+func newICMPEndpoint(child stack.LinkEndpoint, trace traceOptions, neighbors *neighbors6) *icmpEndpoint {
+	e := &icmpEndpoint{trace: trace, neighbors: neighbors}
+	e.Endpoint.Init(child, e)
+	return e
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher
+func (e *icmpEndpoint) DeliverNetworkPacket(protocol tcpip.NetworkProtocolNumber, pkt stack.PacketBufferPtr) {
+	var reply []byte
+	switch protocol {
+	case header.IPv4ProtocolNumber:
+		packet := pkt.ToView().AsSlice()
+		if reply = timeExceededV4(packet, e.trace.hop); reply == nil {
+			reply = echoReplyV4(packet)
+		}
+	case header.IPv6ProtocolNumber:
+		packet := pkt.ToView().AsSlice()
+		if reply = timeExceededV6(packet, e.trace.hop6); reply == nil {
+			reply = echoReplyV6(packet)
+		}
+		if reply == nil {
+			reply = neighborAdvertV6(packet, e.neighbors)
+		}
+	}
+	if reply == nil {
+		e.Endpoint.DeliverNetworkPacket(protocol, pkt)
+		return
+	}
+
+	out := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(reply)})
+	out.NetworkProtocolNumber = protocol
+	var pkts stack.PacketBufferList
+	pkts.PushBack(out)
+	e.Endpoint.WritePackets(pkts)
+	pkts.DecRef()
+}
+
+// echoReplyV4 return the reply of an unfragmented echo request, nil for any other packet
+func echoReplyV4(packet []byte) []byte {
+	ip := header.IPv4(packet)
+	if !ip.IsValid(len(packet)) || ip.TransportProtocol() != header.ICMPv4ProtocolNumber ||
+		ip.More() || ip.FragmentOffset() != 0 {
+		return nil
+	}
+	icmp := header.ICMPv4(ip.Payload())
+	if len(icmp) < header.ICMPv4MinimumSize || icmp.Type() != header.ICMPv4Echo || checksum.Checksum(icmp, 0) != 0xffff {
+		return nil
+	}
+	if dst := ip.DestinationAddress(); header.IsV4MulticastAddress(dst) || dst == header.IPv4Broadcast {
+		return nil
+	}
+
+	// the options of the request are left out
+	reply := make([]byte, header.IPv4MinimumSize+len(icmp))
+	ipReply := header.IPv4(reply)
+	tos, _ := ip.TOS()
+	ipReply.Encode(&header.IPv4Fields{
+		TOS:         tos,
+		TotalLength: uint16(len(reply)),
+		ID:          ip.ID(),
+		TTL:         ip.TTL(),
+		Protocol:    uint8(header.ICMPv4ProtocolNumber),
+		SrcAddr:     ip.DestinationAddress(),
+		DstAddr:     ip.SourceAddress(),
+	})
+	ipReply.SetChecksum(^ipReply.CalculateChecksum())
+
+	icmpReply := header.ICMPv4(ipReply.Payload())
+	copy(icmpReply, icmp)
+	icmpReply.SetType(header.ICMPv4EchoReply)
+	icmpReply.SetCode(0)
+	icmpReply.SetChecksum(0)
+	icmpReply.SetChecksum(^checksum.Checksum(icmpReply, 0))
+	return reply
+}
+
+// echoReplyV6 return the reply of an echo request without extension headers, nil for any other packet
+func echoReplyV6(packet []byte) []byte {
+	ip := header.IPv6(packet)
+	if !ip.IsValid(len(packet)) || ip.TransportProtocol() != header.ICMPv6ProtocolNumber {
+		return nil
+	}
+	icmp := header.ICMPv6(ip.Payload())
+	if len(icmp) < header.ICMPv6EchoMinimumSize || icmp.Type() != header.ICMPv6EchoRequest {
+		return nil
+	}
+	src, dst := ip.SourceAddress(), ip.DestinationAddress()
+	if header.ICMPv6Checksum(header.ICMPv6ChecksumParams{Header: icmp, Src: src, Dst: dst}) != icmp.Checksum() {
+		return nil
+	}
+	// the multicast echo, like the ping of all the nodes, is the ipstack's
+	if header.IsV6MulticastAddress(dst) {
+		return nil
+	}
+
+	reply := make([]byte, header.IPv6MinimumSize+len(icmp))
+	ipReply := header.IPv6(reply)
+	tc, _ := ip.TOS()
+	ipReply.Encode(&header.IPv6Fields{
+		TrafficClass:      tc,
+		PayloadLength:     uint16(len(icmp)),
+		TransportProtocol: header.ICMPv6ProtocolNumber,
+		HopLimit:          ip.HopLimit(),
+		SrcAddr:           dst,
+		DstAddr:           src,
+	})
+
+	icmpReply := header.ICMPv6(ipReply.Payload())
+	copy(icmpReply, icmp)
+	icmpReply.SetType(header.ICMPv6EchoReply)
+	icmpReply.SetCode(0)
+	icmpReply.SetChecksum(0)
+	icmpReply.SetChecksum(header.ICMPv6Checksum(header.ICMPv6ChecksumParams{Header: icmpReply, Src: dst, Dst: src}))
+	return reply
+}
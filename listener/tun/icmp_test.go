@@ -0,0 +1,127 @@
+package tun
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// writeNotify collects what the ipstack writes to the tun, like the device does
+type writeNotify struct {
+	ep      *channel.Endpoint
+	packets chan []byte
+}
+
+func (w *writeNotify) WriteNotify() {
+	pkt := w.ep.Read()
+	w.packets <- pkt.ToView().AsSlice()
+	pkt.DecRef()
+}
+
+func icmpStack(t *testing.T, trace traceOptions) (*channel.Endpoint, chan []byte) {
+	linkEP := channel.New(16, 1500, "")
+	notify := &writeNotify{ep: linkEP, packets: make(chan []byte, 16)}
+	linkEP.AddNotify(notify)
+
+	ipstack := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+	})
+	t.Cleanup(ipstack.Close)
+	require.Nil(t, ipstack.CreateNIC(nicID, newICMPEndpoint(linkEP, trace, &neighbors6{})))
+	return linkEP, notify.packets
+}
+
+func readReply(t *testing.T, packets chan []byte) []byte {
+	select {
+	case packet := <-packets:
+		return packet
+	case <-time.After(time.Second):
+		require.FailNow(t, "no echo reply")
+		return nil
+	}
+}
+
+func TestICMPEndpoint_EchoV4(t *testing.T) {
+	linkEP, packets := icmpStack(t, traceOptions{})
+
+	src, dst := tcpip.AddrFrom4([4]byte{198, 18, 0, 1}), tcpip.AddrFrom4([4]byte{1, 1, 1, 1})
+	icmp := header.ICMPv4(make([]byte, header.ICMPv4MinimumSize+4))
+	icmp.SetType(header.ICMPv4Echo)
+	icmp.SetIdent(7)
+	icmp.SetSequence(9)
+	copy(icmp.Payload(), "ping")
+	icmp.SetChecksum(^checksum.Checksum(icmp, 0))
+	ip := header.IPv4(make([]byte, header.IPv4MinimumSize, header.IPv4MinimumSize+len(icmp)))
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(header.IPv4MinimumSize + len(icmp)),
+		TTL:         37,
+		Protocol:    uint8(header.ICMPv4ProtocolNumber),
+		SrcAddr:     src,
+		DstAddr:     dst,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	linkEP.InjectInbound(header.IPv4ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(append(ip, icmp...)),
+	}))
+
+	reply := header.IPv4(readReply(t, packets))
+	require.True(t, reply.IsValid(len(reply)))
+	assert.True(t, reply.IsChecksumValid())
+	assert.Equal(t, dst, reply.SourceAddress())
+	assert.Equal(t, src, reply.DestinationAddress())
+	assert.Equal(t, uint8(37), reply.TTL())
+
+	icmpReply := header.ICMPv4(reply.Payload())
+	assert.Equal(t, header.ICMPv4EchoReply, icmpReply.Type())
+	assert.Equal(t, uint16(7), icmpReply.Ident())
+	assert.Equal(t, uint16(9), icmpReply.Sequence())
+	assert.Equal(t, []byte("ping"), icmpReply.Payload())
+	assert.Equal(t, uint16(0xffff), checksum.Checksum(icmpReply, 0))
+}
+
+func TestICMPEndpoint_EchoV6(t *testing.T) {
+	linkEP, packets := icmpStack(t, traceOptions{})
+
+	src := tcpip.AddrFrom16([16]byte{0xfd, 15: 1})
+	dst := tcpip.AddrFrom16([16]byte{0x20, 0x01, 0x48, 0x60, 15: 0x88})
+	icmp := header.ICMPv6(make([]byte, header.ICMPv6EchoMinimumSize+4))
+	icmp.SetType(header.ICMPv6EchoRequest)
+	icmp.SetIdent(7)
+	icmp.SetSequence(9)
+	copy(icmp.Payload(), "ping")
+	icmp.SetChecksum(header.ICMPv6Checksum(header.ICMPv6ChecksumParams{Header: icmp, Src: src, Dst: dst}))
+	ip := header.IPv6(make([]byte, header.IPv6MinimumSize, header.IPv6MinimumSize+len(icmp)))
+	ip.Encode(&header.IPv6Fields{
+		PayloadLength:     uint16(len(icmp)),
+		TransportProtocol: header.ICMPv6ProtocolNumber,
+		HopLimit:          37,
+		SrcAddr:           src,
+		DstAddr:           dst,
+	})
+	linkEP.InjectInbound(header.IPv6ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(append(ip, icmp...)),
+	}))
+
+	reply := header.IPv6(readReply(t, packets))
+	require.True(t, reply.IsValid(len(reply)))
+	assert.Equal(t, dst, reply.SourceAddress())
+	assert.Equal(t, src, reply.DestinationAddress())
+	assert.Equal(t, uint8(37), reply.HopLimit())
+
+	icmpReply := header.ICMPv6(reply.Payload())
+	assert.Equal(t, header.ICMPv6EchoReply, icmpReply.Type())
+	assert.Equal(t, uint16(7), icmpReply.Ident())
+	assert.Equal(t, uint16(9), icmpReply.Sequence())
+	assert.Equal(t, []byte("ping"), []byte(icmpReply.Payload()))
+	assert.Equal(t, header.ICMPv6Checksum(header.ICMPv6ChecksumParams{Header: icmpReply, Src: dst, Dst: src}), icmpReply.Checksum())
+}
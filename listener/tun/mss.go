@@ -0,0 +1,177 @@
+package tun
+
+import (
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/nested"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// mssClamp is the largest mss of the tcp connections of the tun by family
+type mssClamp struct {
+	v4 uint16
+	v6 uint16
+}
+
+// newMSSClamp return the clamp of mss, or of the mtu less the ip and tcp headers when it's 0
+func newMSSClamp(mss int, mtu uint32) mssClamp {
+	if mss != 0 {
+		return mssClamp{v4: uint16(mss), v6: uint16(mss)}
+	}
+	clamp := mssClamp{}
+	if v4 := int(mtu) - header.IPv4MinimumSize - header.TCPMinimumSize; v4 > 0 {
+		clamp.v4 = uint16(v4)
+	}
+	if v6 := int(mtu) - header.IPv6MinimumSize - header.TCPMinimumSize; v6 > 0 {
+		clamp.v6 = uint16(v6)
+	}
+	return clamp
+}
+
+// mssEndpoint clamps the mss option of the syns through the tun both ways, as the tcp
+// endpoints of the forwarder advertise the one of the mtu and take the one of the client.
+// A segment of a connection then fits the clamp when an outbound adds its overhead
+type mssEndpoint struct {
+	nested.Endpoint
+	clamp mssClamp
+}
+
+func newMSSEndpoint(child stack.LinkEndpoint, clamp mssClamp) *mssEndpoint {
+	e := &mssEndpoint{clamp: clamp}
+	e.Endpoint.Init(child, e)
+	return e
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher
+func (e *mssEndpoint) DeliverNetworkPacket(protocol tcpip.NetworkProtocolNumber, pkt stack.PacketBufferPtr) {
+	packet, _ := pkt.Data().PullUp(pkt.Data().Size())
+	clamped := clampMSS(protocol, packet, e.clamp)
+	if clamped == nil {
+		e.Endpoint.DeliverNetworkPacket(protocol, pkt)
+		return
+	}
+
+	in := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(clamped)})
+	in.NICID, in.PktType = pkt.NICID, pkt.PktType
+	e.Endpoint.DeliverNetworkPacket(protocol, in)
+	in.DecRef()
+}
+
+// WritePackets implements stack.LinkEndpoint
+func (e *mssEndpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Error) {
+	if !hasSyn(pkts) {
+		return e.Endpoint.WritePackets(pkts)
+	}
+
+	// the list of the caller is left as it is, the clamped packets are of this one
+	var out stack.PacketBufferList
+	defer out.DecRef()
+	for _, pkt := range pkts.AsSlice() {
+		clamped := clampMSS(pkt.NetworkProtocolNumber, pkt.ToView().AsSlice(), e.clamp)
+		if clamped == nil {
+			pkt.IncRef()
+			out.PushBack(pkt)
+			continue
+		}
+		syn := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(clamped)})
+		syn.NetworkProtocolNumber = pkt.NetworkProtocolNumber
+		out.PushBack(syn)
+	}
+	return e.Endpoint.WritePackets(out)
+}
+
+// hasSyn reports whether a packet written by the ipstack is a tcp syn
+func hasSyn(pkts stack.PacketBufferList) bool {
+	for _, pkt := range pkts.AsSlice() {
+		if pkt.TransportProtocolNumber != header.TCPProtocolNumber {
+			continue
+		}
+		if tcp := header.TCP(pkt.TransportHeader().Slice()); len(tcp) >= header.TCPMinimumSize && tcp.Flags()&header.TCPFlagSyn != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// clampMSS return a copy of a tcp syn packet with its mss option lowered to the clamp, nil
+// for any other packet or a syn within it. The ipv6 packets with extension headers and the
+// fragments are left as they are
+func clampMSS(protocol tcpip.NetworkProtocolNumber, packet []byte, clamp mssClamp) []byte {
+	var payload []byte
+	var max uint16
+	switch protocol {
+	case header.IPv4ProtocolNumber:
+		ip := header.IPv4(packet)
+		if !ip.IsValid(len(packet)) || ip.TransportProtocol() != header.TCPProtocolNumber || ip.More() || ip.FragmentOffset() != 0 {
+			return nil
+		}
+		payload, max = ip.Payload(), clamp.v4
+	case header.IPv6ProtocolNumber:
+		ip := header.IPv6(packet)
+		if !ip.IsValid(len(packet)) || ip.TransportProtocol() != header.TCPProtocolNumber {
+			return nil
+		}
+		payload, max = ip.Payload(), clamp.v6
+	default:
+		return nil
+	}
+
+	tcp := header.TCP(payload)
+	if max == 0 || len(tcp) < header.TCPMinimumSize || tcp.Flags()&header.TCPFlagSyn == 0 {
+		return nil
+	}
+	offset := int(tcp.DataOffset())
+	if offset < header.TCPMinimumSize || offset > len(tcp) {
+		return nil
+	}
+	at := mssOptionAt(tcp[header.TCPMinimumSize:offset])
+	if at < 0 {
+		return nil
+	}
+	at += len(packet) - len(tcp) + header.TCPMinimumSize
+	if uint16(packet[at+2])<<8|uint16(packet[at+3]) <= max {
+		return nil
+	}
+
+	clamped := make([]byte, len(packet))
+	copy(clamped, packet)
+	clamped[at+2], clamped[at+3] = byte(max>>8), byte(max)
+
+	var src, dst tcpip.Address
+	if protocol == header.IPv4ProtocolNumber {
+		src, dst = header.IPv4(clamped).SourceAddress(), header.IPv4(clamped).DestinationAddress()
+	} else {
+		src, dst = header.IPv6(clamped).SourceAddress(), header.IPv6(clamped).DestinationAddress()
+	}
+	tcp = header.TCP(clamped[len(clamped)-len(tcp):])
+	tcp.SetChecksum(0)
+	xsum := header.PseudoHeaderChecksum(header.TCPProtocolNumber, src, dst, uint16(len(tcp)))
+	tcp.SetChecksum(^checksum.Checksum(tcp, xsum))
+	return clamped
+}
+
+// mssOptionAt return the offset of the mss option in the tcp options, -1 without one
+func mssOptionAt(opts []byte) int {
+	for i := 0; i < len(opts); {
+		switch opts[i] {
+		case header.TCPOptionEOL:
+			return -1
+		case header.TCPOptionNOP:
+			i++
+			continue
+		}
+		if i+1 >= len(opts) || opts[i+1] < 2 || i+int(opts[i+1]) > len(opts) {
+			return -1
+		}
+		if opts[i] == header.TCPOptionMSS {
+			if opts[i+1] != header.TCPOptionMSSLength {
+				return -1
+			}
+			return i
+		}
+		i += int(opts[i+1])
+	}
+	return -1
+}
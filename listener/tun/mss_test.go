@@ -0,0 +1,117 @@
+package tun
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// tcpSyn return an ipv4 syn of the client with mss
+func tcpSyn(mss uint16) []byte {
+	src, dst := addr("198.18.0.1"), addr("1.1.1.1")
+	opts := make([]byte, 4)
+	header.EncodeMSSOption(uint32(mss), opts)
+	packet := make([]byte, header.IPv4MinimumSize+header.TCPMinimumSize+len(opts))
+	ip := header.IPv4(packet)
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(packet)),
+		TTL:         64,
+		Protocol:    uint8(header.TCPProtocolNumber),
+		SrcAddr:     src,
+		DstAddr:     dst,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	syn := header.TCP(ip.Payload())
+	syn.Encode(&header.TCPFields{
+		SrcPort:    40000,
+		DstPort:    443,
+		SeqNum:     1,
+		DataOffset: uint8(header.TCPMinimumSize + len(opts)),
+		Flags:      header.TCPFlagSyn,
+		WindowSize: 65535,
+	})
+	copy(syn[header.TCPMinimumSize:], opts)
+	xsum := header.PseudoHeaderChecksum(header.TCPProtocolNumber, src, dst, uint16(len(syn)))
+	syn.SetChecksum(^checksum.Checksum(syn, xsum))
+	return packet
+}
+
+func synMSS(t *testing.T, packet []byte) uint16 {
+	ip := header.IPv4(packet)
+	syn := header.TCP(ip.Payload())
+	xsum := header.PseudoHeaderChecksum(header.TCPProtocolNumber, ip.SourceAddress(), ip.DestinationAddress(), uint16(len(syn)))
+	assert.Equal(t, uint16(0xffff), checksum.Checksum(syn, xsum), "tcp checksum")
+	return header.ParseSynOptions(syn.Options(), syn.Flags()&header.TCPFlagAck != 0).MSS
+}
+
+func TestClampMSS(t *testing.T) {
+	clamp := newMSSClamp(0, 1500)
+	assert.Equal(t, mssClamp{v4: 1460, v6: 1440}, clamp)
+	assert.Equal(t, mssClamp{v4: 1200, v6: 1200}, newMSSClamp(1200, 1500))
+
+	packet := tcpSyn(1460)
+	assert.Nil(t, clampMSS(header.IPv4ProtocolNumber, packet, clamp))
+	clamped := clampMSS(header.IPv4ProtocolNumber, packet, mssClamp{v4: 1200})
+	require.NotNil(t, clamped)
+	assert.Equal(t, uint16(1200), synMSS(t, clamped))
+	// the packet itself is left as it is
+	assert.Equal(t, uint16(1460), synMSS(t, packet))
+
+	// not a syn
+	header.TCP(header.IPv4(packet).Payload()).SetFlags(uint8(header.TCPFlagAck))
+	assert.Nil(t, clampMSS(header.IPv4ProtocolNumber, packet, mssClamp{v4: 1200}))
+}
+
+// TestMSSEndpoint_SynAck sends a syn with the mss of a 1500 mtu to the forwarder, the syn-ack
+// through the link endpoint advertises the clamp
+func TestMSSEndpoint_SynAck(t *testing.T) {
+	linkEP := channel.New(16, 1500, "")
+	ipstack := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol},
+	})
+	defer ipstack.Close()
+	require.Nil(t, ipstack.CreateNIC(nicID, newMSSEndpoint(linkEP, mssClamp{v4: 1200, v6: 1180})))
+	ipstack.SetPromiscuousMode(nicID, true)
+	ipstack.SetSpoofing(nicID, true)
+	ipstack.AddRoute(tcpip.Route{Destination: header.IPv4EmptySubnet, NIC: nicID})
+
+	accepted := make(chan struct{}, 1)
+	fwd := tcp.NewForwarder(ipstack, 0, 16, func(r *tcp.ForwarderRequest) {
+		accepted <- struct{}{}
+		r.CreateEndpoint(new(waiter.Queue))
+	})
+	ipstack.SetTransportProtocolHandler(tcp.ProtocolNumber, fwd.HandlePacket)
+
+	linkEP.InjectInbound(header.IPv4ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(tcpSyn(1460)),
+	}))
+	select {
+	case <-accepted:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no request of the syn")
+	}
+
+	var synAck stack.PacketBufferPtr
+	for deadline := time.Now().Add(5 * time.Second); synAck == nil && time.Now().Before(deadline); {
+		if synAck = linkEP.Read(); synAck == nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	require.NotNil(t, synAck)
+	defer synAck.DecRef()
+	packet := synAck.ToView().AsSlice()
+	assert.Equal(t, header.TCPFlagSyn|header.TCPFlagAck, header.TCP(header.IPv4(packet).Payload()).Flags())
+	assert.Equal(t, uint16(1200), synMSS(t, packet))
+}
@@ -0,0 +1,123 @@
+package tun
+
+import (
+	"net/netip"
+
+	"github.com/Dreamacro/clash/log"
+
+	"go.uber.org/atomic"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// linkLocal6 is the link-local address of the nic while the device has an addr6, a route
+// of the system via fe80::1 dev NAME goes through the tun
+var linkLocal6 = netip.MustParseAddr("fe80::1")
+
+// neighbors6 holds the addr6 prefix of the device. The nic answers the neighbor solicitations
+// for the addresses inside it but the one of the device, and for linkLocal6, as the peer the
+// whole prefix is routed to.
+type neighbors6 struct {
+	prefix atomic.Pointer[netip.Prefix]
+}
+
+func (n *neighbors6) answers(target netip.Addr) bool {
+	if target == linkLocal6 {
+		return n.prefix.Load() != nil
+	}
+	prefix := n.prefix.Load()
+	return prefix != nil && prefix.Contains(target) && target != prefix.Addr()
+}
+
+// stackAddr6 return the address of the nic inside the prefix, the one after the device's or
+// before it for the last one, false for a /128
+func stackAddr6(prefix netip.Prefix) (netip.Addr, bool) {
+	if prefix.Bits() == 128 {
+		return netip.Addr{}, false
+	}
+	if next := prefix.Addr().Next(); next.IsValid() && prefix.Contains(next) {
+		return next, true
+	}
+	return prefix.Addr().Prev(), true
+}
+
+// setAddressing6 puts the link-local and the addr6 prefix of the device on the nic, an
+// invalid prefix takes them off. The addresses only source what the ipstack sends itself, the
+// forwarders take the packets to any address already.
+func (t *tunAdapter) setAddressing6(prefix netip.Prefix) {
+	if old := t.neighbors.prefix.Load(); old != nil {
+		if *old == prefix {
+			return
+		}
+		t.ipstack.RemoveAddress(nicID, tcpip.AddrFrom16(linkLocal6.As16()))
+		if addr, ok := stackAddr6(*old); ok {
+			t.ipstack.RemoveAddress(nicID, tcpip.AddrFrom16(addr.As16()))
+		}
+	}
+	if !prefix.IsValid() {
+		t.neighbors.prefix.Store(nil)
+		return
+	}
+
+	addrs := []tcpip.AddressWithPrefix{{Address: tcpip.AddrFrom16(linkLocal6.As16()), PrefixLen: 64}}
+	if addr, ok := stackAddr6(prefix); ok {
+		addrs = append(addrs, tcpip.AddressWithPrefix{Address: tcpip.AddrFrom16(addr.As16()), PrefixLen: prefix.Bits()})
+	}
+	for _, addr := range addrs {
+		protocolAddr := tcpip.ProtocolAddress{Protocol: ipv6.ProtocolNumber, AddressWithPrefix: addr}
+		// the dns-listen address may be the same one
+		if err := t.ipstack.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+			if _, ok := err.(*tcpip.ErrDuplicateAddress); !ok {
+				log.Warnln("[TUN] add %s to the ipstack: %s", addr, err)
+			}
+		}
+	}
+	t.neighbors.prefix.Store(&prefix)
+}
+
+// neighborAdvertV6 return the advertisement answering a neighbor solicitation whose target
+// the nic answers, nil for any other packet. A solicitation of the duplicate address
+// detection, from ::, isn't answered, the device keeps its address.
+func neighborAdvertV6(packet []byte, neighbors *neighbors6) []byte {
+	ip := header.IPv6(packet)
+	if !ip.IsValid(len(packet)) || ip.TransportProtocol() != header.ICMPv6ProtocolNumber ||
+		ip.HopLimit() != header.NDPHopLimit {
+		return nil
+	}
+	icmp := header.ICMPv6(ip.Payload())
+	if len(icmp) < header.ICMPv6NeighborSolicitMinimumSize || icmp.Type() != header.ICMPv6NeighborSolicit || icmp.Code() != 0 {
+		return nil
+	}
+	src := ip.SourceAddress()
+	if src == header.IPv6Any || header.IsV6MulticastAddress(src) {
+		return nil
+	}
+	if header.ICMPv6Checksum(header.ICMPv6ChecksumParams{Header: icmp, Src: src, Dst: ip.DestinationAddress()}) != icmp.Checksum() {
+		return nil
+	}
+	target := header.NDPNeighborSolicit(icmp.MessageBody()).TargetAddress()
+	if !neighbors.answers(netip.AddrFrom16(target.As16())) {
+		return nil
+	}
+
+	reply := make([]byte, header.IPv6MinimumSize+header.ICMPv6NeighborAdvertMinimumSize)
+	ipReply := header.IPv6(reply)
+	ipReply.Encode(&header.IPv6Fields{
+		PayloadLength:     header.ICMPv6NeighborAdvertMinimumSize,
+		TransportProtocol: header.ICMPv6ProtocolNumber,
+		HopLimit:          header.NDPHopLimit,
+		SrcAddr:           target,
+		DstAddr:           src,
+	})
+	advert := header.ICMPv6(ipReply.Payload())
+	advert.SetType(header.ICMPv6NeighborAdvert)
+	na := header.NDPNeighborAdvert(advert.MessageBody())
+	na.SetTargetAddress(target)
+	na.SetRouterFlag(true)
+	na.SetSolicitedFlag(true)
+	na.SetOverrideFlag(true)
+	advert.SetChecksum(header.ICMPv6Checksum(header.ICMPv6ChecksumParams{Header: advert, Src: target, Dst: src}))
+	return reply
+}
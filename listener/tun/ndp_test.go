@@ -0,0 +1,147 @@
+package tun
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func neighborSolicit(src, target tcpip.Address) []byte {
+	dst := header.SolicitedNodeAddr(target)
+	icmp := header.ICMPv6(make([]byte, header.ICMPv6NeighborSolicitMinimumSize))
+	icmp.SetType(header.ICMPv6NeighborSolicit)
+	header.NDPNeighborSolicit(icmp.MessageBody()).SetTargetAddress(target)
+	icmp.SetChecksum(header.ICMPv6Checksum(header.ICMPv6ChecksumParams{Header: icmp, Src: src, Dst: dst}))
+	ip := header.IPv6(make([]byte, header.IPv6MinimumSize, header.IPv6MinimumSize+len(icmp)))
+	ip.Encode(&header.IPv6Fields{
+		PayloadLength:     uint16(len(icmp)),
+		TransportProtocol: header.ICMPv6ProtocolNumber,
+		HopLimit:          header.NDPHopLimit,
+		SrcAddr:           src,
+		DstAddr:           dst,
+	})
+	return append(ip, icmp...)
+}
+
+func TestNeighborAdvertV6(t *testing.T) {
+	neighbors := &neighbors6{}
+	device := tcpip.AddrFrom16(netip.MustParseAddr("fd00:c1a5::1").As16())
+	target := tcpip.AddrFrom16(netip.MustParseAddr("fd00:c1a5::9").As16())
+	assert.Nil(t, neighborAdvertV6(neighborSolicit(device, target), neighbors))
+
+	prefix := netip.MustParsePrefix("fd00:c1a5::1/64")
+	neighbors.prefix.Store(&prefix)
+	reply := header.IPv6(neighborAdvertV6(neighborSolicit(device, target), neighbors))
+	require.True(t, reply.IsValid(len(reply)))
+	assert.Equal(t, target, reply.SourceAddress())
+	assert.Equal(t, device, reply.DestinationAddress())
+	assert.Equal(t, uint8(header.NDPHopLimit), reply.HopLimit())
+
+	advert := header.ICMPv6(reply.Payload())
+	assert.Equal(t, header.ICMPv6NeighborAdvert, advert.Type())
+	assert.Equal(t, header.ICMPv6Checksum(header.ICMPv6ChecksumParams{Header: advert, Src: target, Dst: device}), advert.Checksum())
+	na := header.NDPNeighborAdvert(advert.MessageBody())
+	assert.Equal(t, target, na.TargetAddress())
+	assert.True(t, na.SolicitedFlag())
+	assert.True(t, na.OverrideFlag())
+
+	linkLocal := tcpip.AddrFrom16(linkLocal6.As16())
+	assert.NotNil(t, neighborAdvertV6(neighborSolicit(device, linkLocal), neighbors))
+
+	// the device's own address, one outside the prefix and the duplicate address detection
+	assert.Nil(t, neighborAdvertV6(neighborSolicit(target, device), neighbors))
+	outside := tcpip.AddrFrom16(netip.MustParseAddr("fd00:c1a6::9").As16())
+	assert.Nil(t, neighborAdvertV6(neighborSolicit(device, outside), neighbors))
+	assert.Nil(t, neighborAdvertV6(neighborSolicit(header.IPv6Any, target), neighbors))
+}
+
+func TestStackAddr6(t *testing.T) {
+	addr, ok := stackAddr6(netip.MustParsePrefix("fd00:c1a5::1/64"))
+	assert.True(t, ok)
+	assert.Equal(t, "fd00:c1a5::2", addr.String())
+
+	addr, ok = stackAddr6(netip.MustParsePrefix("fd00:c1a5::3/126"))
+	assert.True(t, ok)
+	assert.Equal(t, "fd00:c1a5::2", addr.String())
+
+	_, ok = stackAddr6(netip.MustParsePrefix("fd00:c1a5::1/128"))
+	assert.False(t, ok)
+}
+
+func TestGetAddr_V6(t *testing.T) {
+	id := stack.TransportEndpointID{
+		LocalAddress:  tcpip.AddrFrom16(netip.MustParseAddr("2001:db8::53").As16()),
+		LocalPort:     53,
+		RemoteAddress: tcpip.AddrFrom16(netip.MustParseAddr("fd00:c1a5::1").As16()),
+		RemotePort:    5000,
+	}
+	addr := getAddr(id)
+	assert.Equal(t, byte(socks5.AtypIPv6), addr[0])
+	assert.Equal(t, "[2001:db8::53]:53", addr.String())
+}
+
+// TestTunProxy_IPv6 goes through a tun device with an addr6 from the system, the tcp
+// forwarder and the udp handler see the v6 destinations and the udp reply comes back, it
+// needs CAP_NET_ADMIN
+func TestTunProxy_IPv6(t *testing.T) {
+	tcpIn, udpIn := make(chan C.ConnContext, 1), make(chan *inbound.PacketAdapter, 1)
+	adapter, err := NewTunProxy("dev://clashv6?addr6=fd00:c1a5::1", tcpIn, udpIn)
+	if err != nil {
+		t.Skipf("open tun: %s", err)
+	}
+	defer adapter.Close()
+
+	addrs := adapter.(*tunAdapter).ipstack.AllAddresses()[nicID]
+	assert.Contains(t, addrs, tcpip.ProtocolAddress{
+		Protocol:          header.IPv6ProtocolNumber,
+		AddressWithPrefix: tcpip.AddressWithPrefix{Address: tcpip.AddrFrom16(netip.MustParseAddr("fd00:c1a5::2").As16()), PrefixLen: 64},
+	})
+
+	go func() {
+		if conn, err := net.DialTimeout("tcp", "[fd00:c1a5::80]:80", 5*time.Second); err == nil {
+			conn.Close()
+		}
+	}()
+	select {
+	case conn := <-tcpIn:
+		assert.Equal(t, "[fd00:c1a5::80]:80", conn.Metadata().RemoteAddress())
+		conn.Conn().Close()
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no tcp connection from the tun")
+	}
+
+	pc, err := net.ListenPacket("udp6", "[fd00:c1a5::1]:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	dst := &net.UDPAddr{IP: net.ParseIP("fd00:c1a5::53"), Port: 53}
+	_, err = pc.WriteTo([]byte("query"), dst)
+	require.NoError(t, err)
+	select {
+	case packet := <-udpIn:
+		assert.Equal(t, "[fd00:c1a5::53]:53", packet.Metadata().RemoteAddress())
+		assert.Equal(t, []byte("query"), packet.Data())
+		_, err := packet.WriteBack([]byte("answer"), dst)
+		require.NoError(t, err)
+		packet.Drop()
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no udp packet from the tun")
+	}
+
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, from, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "answer", string(buf[:n]))
+	assert.Equal(t, dst.String(), from.String())
+}
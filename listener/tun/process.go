@@ -0,0 +1,135 @@
+package tun
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/common/cache"
+	P "github.com/Dreamacro/clash/component/process"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	// a tcp connection waits this long for its process and a udp packet doesn't wait, the
+	// rules look up the process of one going on without it
+	processWait = 5 * time.Millisecond
+	// the lookups going at once, a connection beyond them goes without a process
+	processLookups = 4
+
+	processTTL    = 30 * time.Second
+	processMissed = 5 * time.Second
+)
+
+// processFinder looks up the local process owning the socket of a connection through the tun,
+// by the socket diag of the kernel and the fds of /proc on linux. The results are cached by
+// the source and destination of the connection, a miss too.
+type processFinder struct {
+	cache *cache.LruCache
+
+	mux     sync.Mutex
+	pending map[string]chan struct{}
+	slots   chan struct{}
+}
+
+func newProcessFinder() *processFinder {
+	return &processFinder{
+		cache:   cache.New(cache.WithSize(4096)),
+		pending: map[string]chan struct{}{},
+		slots:   make(chan struct{}, processLookups),
+	}
+}
+
+// find return the path of the process of a connection from src to dst, empty for a miss.
+// ok is false when the lookup didn't end within wait, the rules look the process up again.
+func (f *processFinder) find(network string, src, dst netip.AddrPort, wait time.Duration) (string, bool) {
+	key := network + " " + src.String() + " " + dst.String()
+	if path, ok := f.cache.Get(key); ok {
+		return path.(string), true
+	}
+
+	f.mux.Lock()
+	done, ok := f.pending[key]
+	if !ok {
+		select {
+		case f.slots <- struct{}{}:
+		default:
+			f.mux.Unlock()
+			return "", false
+		}
+		done = make(chan struct{})
+		f.pending[key] = done
+		go f.lookup(key, network, src, dst, done)
+	}
+	f.mux.Unlock()
+
+	if wait <= 0 {
+		return "", false
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-done:
+		path, ok := f.cache.Get(key)
+		if !ok {
+			return "", false
+		}
+		return path.(string), true
+	case <-timer.C:
+		return "", false
+	}
+}
+
+func (f *processFinder) lookup(key, network string, src, dst netip.AddrPort, done chan struct{}) {
+	path, err := P.FindProcessPath(network, src, dst)
+	if err != nil {
+		log.Debugln("[TUN] find process of %s %s --> %s: %v", network, src, dst, err)
+		f.cache.SetWithExpire(key, "", time.Now().Add(processMissed))
+	} else {
+		f.cache.SetWithExpire(key, path, time.Now().Add(processTTL))
+	}
+
+	f.mux.Lock()
+	delete(f.pending, key)
+	f.mux.Unlock()
+	<-f.slots
+	close(done)
+}
+
+// endpointAddrs return the source and the destination of a connection read from the tun
+func endpointAddrs(id stack.TransportEndpointID) (src, dst netip.AddrPort) {
+	srcIP, _ := netip.AddrFromSlice(id.RemoteAddress.AsSlice())
+	dstIP, _ := netip.AddrFromSlice(id.LocalAddress.AsSlice())
+	return netip.AddrPortFrom(srcIP.Unmap(), id.RemotePort), netip.AddrPortFrom(dstIP.Unmap(), id.LocalPort)
+}
+
+// setProcess looks up the process of a connection from the tun when FindProcess is on
+func (t *tunAdapter) setProcess(metadata *C.Metadata, id stack.TransportEndpointID, wait time.Duration) {
+	finder := t.processes.Load()
+	if finder == nil {
+		return
+	}
+	src, dst := endpointAddrs(id)
+	if path, ok := finder.find(metadata.NetWork.String(), src, dst, wait); ok {
+		metadata.ProcessPath = path
+		metadata.ProcessLooked = true
+	}
+}
+
+// FindProcess tells whether the connections from the tun look up their process
+func (t *tunAdapter) FindProcess() bool {
+	return t.processes.Load() != nil
+}
+
+// SetFindProcess turns the lookup of the process of the new connections on or off
+func (t *tunAdapter) SetFindProcess(enable bool) {
+	switch {
+	case !enable:
+		t.processes.Store(nil)
+	case t.processes.Load() == nil:
+		t.processes.CompareAndSwap(nil, newProcessFinder())
+	}
+}
@@ -0,0 +1,87 @@
+package tun
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessFinder(t *testing.T) {
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	finder := newProcessFinder()
+	src, dst := netip.MustParseAddrPort(conn.LocalAddr().String()), netip.MustParseAddrPort(conn.RemoteAddr().String())
+	path, ok := finder.find("tcp", src, dst, time.Second)
+	if !ok || path == "" {
+		t.Skip("find process isn't supported here")
+	}
+	assert.Equal(t, exe, path)
+
+	// the misses are cached as well
+	missing := netip.MustParseAddrPort("127.0.0.1:1")
+	_, ok = finder.find("tcp", missing, dst, time.Second)
+	assert.True(t, ok)
+	_, ok = finder.cache.Get("tcp 127.0.0.1:1 " + dst.String())
+	assert.True(t, ok)
+
+	// a udp packet doesn't wait
+	_, ok = finder.find("udp", src, dst, 0)
+	assert.False(t, ok)
+}
+
+// TestTunProxy_FindProcess looks up the process of a connection through a tun device, it
+// needs CAP_NET_ADMIN
+func TestTunProxy_FindProcess(t *testing.T) {
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	tcpIn := make(chan C.ConnContext, 1)
+	adapter, err := NewTunProxy("dev://clashproc?addr=10.253.0.1/24", tcpIn, make(chan *inbound.PacketAdapter))
+	if err != nil {
+		t.Skipf("open tun: %s", err)
+	}
+	defer adapter.Close()
+	adapter.SetFindProcess(true)
+	assert.True(t, adapter.FindProcess())
+
+	go func() {
+		if conn, err := net.DialTimeout("tcp", "10.253.0.80:80", 5*time.Second); err == nil {
+			conn.Read(make([]byte, 1))
+			conn.Close()
+		}
+	}()
+	var conn C.ConnContext
+	select {
+	case conn = <-tcpIn:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no tcp connection from the tun")
+	}
+	defer conn.Conn().Close()
+
+	metadata := conn.Metadata()
+	if metadata.ProcessLooked {
+		assert.Equal(t, exe, metadata.ProcessPath)
+	}
+	// the lookup goes on past the wait of the connection
+	src := netip.MustParseAddrPort(metadata.SourceAddress())
+	path, ok := adapter.(*tunAdapter).processes.Load().find("tcp", src, metadata.OriginDst, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, exe, path)
+
+	adapter.SetFindProcess(false)
+	assert.False(t, adapter.FindProcess())
+}
@@ -0,0 +1,83 @@
+package tun
+
+import (
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	tcpQueueSize    = 256
+	tcpQueueWorkers = 8
+	udpQueueSize    = 1024
+	udpQueueWorkers = 2
+)
+
+// QueueStats is the state of a queue between the ipstack and the tunnel
+type QueueStats struct {
+	Length   int    `json:"length"`
+	Capacity int    `json:"capacity"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+// inboundQueue decouples the ipstack from the tunnel, the stack only enqueues and
+// a worker pool drains into the tunnel, so a stalled tunnel rejects new flows
+// instead of blocking the stack
+type inboundQueue[T any] struct {
+	ch      chan T
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+func newInboundQueue[T any](size, workers int, handle func(T)) *inboundQueue[T] {
+	q := &inboundQueue[T]{
+		ch:   make(chan T, size),
+		done: make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case v := <-q.ch:
+					handle(v)
+				case <-q.done:
+					return
+				}
+			}
+		}()
+	}
+	return q
+}
+
+// offer enqueues v without blocking, it returns false and counts a drop when the queue is full
+func (q *inboundQueue[T]) offer(v T) bool {
+	select {
+	case q.ch <- v:
+		return true
+	default:
+		q.dropped.Inc()
+		return false
+	}
+}
+
+func (q *inboundQueue[T]) full() bool {
+	return len(q.ch) == cap(q.ch)
+}
+
+// drop counts an item refused before it was offered
+func (q *inboundQueue[T]) drop() {
+	q.dropped.Inc()
+}
+
+func (q *inboundQueue[T]) stats() QueueStats {
+	return QueueStats{
+		Length:   len(q.ch),
+		Capacity: cap(q.ch),
+		Dropped:  q.dropped.Load(),
+	}
+}
+
+func (q *inboundQueue[T]) close() {
+	q.once.Do(func() { close(q.done) })
+}
@@ -0,0 +1,34 @@
+package tun
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInboundQueue_Full(t *testing.T) {
+	var (
+		release = make(chan struct{})
+		handled = make(chan int, 4)
+	)
+	q := newInboundQueue(2, 1, func(v int) {
+		<-release
+		handled <- v
+	})
+	defer q.close()
+
+	// one item is held by the worker, two fill the queue
+	assert.True(t, q.offer(1))
+	assert.Eventually(t, func() bool { return len(q.ch) == 0 }, time.Second, time.Millisecond)
+	assert.True(t, q.offer(2))
+	assert.True(t, q.offer(3))
+	assert.True(t, q.full())
+	assert.False(t, q.offer(4))
+	assert.Equal(t, QueueStats{Length: 2, Capacity: 2, Dropped: 1}, q.stats())
+
+	close(release)
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, i, <-handled)
+	}
+}
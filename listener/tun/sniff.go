@@ -0,0 +1,92 @@
+package tun
+
+import (
+	"net"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	N "github.com/Dreamacro/clash/common/net"
+	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/component/sniff"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// tcpSniffers read the host of the tcp connections, a quic one for the udp sessions goes
+// along the same interface
+var tcpSniffers = []sniff.Sniffer{sniff.TLS, sniff.HTTP}
+
+// SniffOptions are the tcp connections from the tun whose host is read from the first bytes
+// the client sends, the tls server name or the http host
+type SniffOptions struct {
+	Ports []uint16
+	// Timeout is how long a connection waits for the first bytes, a protocol where the
+	// server speaks first is held that long
+	Timeout time.Duration
+}
+
+func (o *SniffOptions) sniffs(port uint16) bool {
+	for _, p := range o.Ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// Sniffing return the options of the sniffing, nil when it's off
+func (t *tunAdapter) Sniffing() *SniffOptions {
+	return t.sniffing.Load()
+}
+
+// SetSniffing sets the sniffing of the new tcp connections, nil turns it off
+func (t *tunAdapter) SetSniffing(opts *SniffOptions) {
+	t.sniffing.Store(opts)
+}
+
+// sniffTCP peeks the host of conn and offers it to the tunnel with the host on its metadata,
+// it runs aside the forwarder as a client may take the whole timeout to speak
+func (t *tunAdapter) sniffTCP(id stack.TransportEndpointID, target socks5.Addr, conn *tcpConn, timeout time.Duration) {
+	bufConn := N.NewBufferedConn(conn)
+	host, protocol := sniff.Stream(bufConn, tcpSniffers, timeout)
+
+	ctx := inbound.NewSocket(target, &sniffedConn{BufferedConn: bufConn, conn: conn}, C.TUN)
+	setSource(ctx.Metadata(), id)
+	t.setProcess(ctx.Metadata(), id, processWait)
+	if metadata := ctx.Metadata(); host != "" {
+		// the rules see the host, the connection still goes to the ip
+		metadata.Host = host
+		metadata.SniffHost = host
+		metadata.DNSMode = C.DNSMapping
+		log.Debugln("[TUN] %s --> %s sniffed %s host %s", metadata.SourceAddress(), net.JoinHostPort(metadata.DstIP.String(), metadata.DstPort), protocol, host)
+	}
+	if !t.tcpQueue.offer(ctx) {
+		log.Dedupln(log.WARNING, "tun-tcp-queue", "[TUN] tcp accept queue is full, connection reset")
+		conn.Close()
+	}
+}
+
+// shouldSniff tells whether a connection to ip and port is sniffed, a fake ip names its
+// host already
+func (t *tunAdapter) shouldSniff(ip net.IP, port uint16) (*SniffOptions, bool) {
+	opts := t.sniffing.Load()
+	if opts == nil || !opts.sniffs(port) || resolver.IsFakeIP(ip) {
+		return nil, false
+	}
+	return opts, true
+}
+
+// sniffedConn reads the bytes peeked by the sniffing first and keeps the endpoint state of
+// the tun connection
+type sniffedConn struct {
+	*N.BufferedConn
+	conn *tcpConn
+}
+
+// EndpointState implements C.TunEndpoint
+func (c *sniffedConn) EndpointState() C.TunEndpointState {
+	return c.conn.EndpointState()
+}
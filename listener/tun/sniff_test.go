@@ -0,0 +1,82 @@
+package tun
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTunProxy_Sniffing reads the hosts of a tls and a http connection through a tun device,
+// the connection to a port off the list isn't held, it needs CAP_NET_ADMIN
+func TestTunProxy_Sniffing(t *testing.T) {
+	tcpIn := make(chan C.ConnContext, 1)
+	adapter, err := NewTunProxy("dev://clashsniff?addr=10.252.0.1/24", tcpIn, make(chan *inbound.PacketAdapter))
+	if err != nil {
+		t.Skipf("open tun: %s", err)
+	}
+	defer adapter.Close()
+	adapter.SetSniffing(&SniffOptions{Ports: []uint16{443, 80}, Timeout: time.Second})
+
+	accept := func() C.ConnContext {
+		select {
+		case conn := <-tcpIn:
+			return conn
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "no tcp connection from the tun")
+		}
+		return nil
+	}
+
+	go func() {
+		conn, err := net.DialTimeout("tcp", "10.252.0.80:443", 5*time.Second)
+		if err == nil {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			tls.Client(conn, &tls.Config{ServerName: "www.example.com"}).Handshake()
+		}
+	}()
+	conn := accept()
+	assert.Equal(t, "www.example.com", conn.Metadata().Host)
+	assert.Equal(t, C.DNSMapping, conn.Metadata().DNSMode)
+	assert.Equal(t, "10.252.0.80:443", conn.Metadata().Pure().RemoteAddress())
+	// the client hello is still there for the relay
+	record := make([]byte, 1)
+	_, err = io.ReadFull(conn.Conn(), record)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x16), record[0])
+	_, ok := conn.Conn().(C.TunEndpoint)
+	assert.True(t, ok)
+	conn.Conn().Close()
+
+	go func() {
+		conn, err := net.DialTimeout("tcp", "10.252.0.80:80", 5*time.Second)
+		if err == nil {
+			defer conn.Close()
+			conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+			conn.Read(make([]byte, 1))
+		}
+	}()
+	conn = accept()
+	assert.Equal(t, "example.com", conn.Metadata().Host)
+	conn.Conn().Close()
+
+	start := time.Now()
+	go func() {
+		if conn, err := net.DialTimeout("tcp", "10.252.0.80:22", 5*time.Second); err == nil {
+			conn.Read(make([]byte, 1))
+			conn.Close()
+		}
+	}()
+	conn = accept()
+	assert.Empty(t, conn.Metadata().Host)
+	assert.Less(t, time.Since(start), time.Second)
+	conn.Conn().Close()
+}
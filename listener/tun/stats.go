@@ -0,0 +1,40 @@
+package tun
+
+import (
+	"github.com/Dreamacro/clash/listener/tun/dev"
+
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// Stats is reported by the tun api
+type Stats struct {
+	TCP    QueueStats      `json:"tcp"`
+	UDP    QueueStats      `json:"udp"`
+	Stack  StackStats      `json:"stack"`
+	Device dev.DeviceStats `json:"device"`
+}
+
+// StackStats are the counters of the ipstack. TCPSynDropped counts the syns ignored
+// for tcp-max-in-flight, a syn reset for a full accept queue is a drop of the tcp queue.
+// TransportMalformed counts the tcp segments with a bad checksum among others.
+type StackStats struct {
+	IPPacketsReceived  uint64 `json:"ipPacketsReceived"`
+	IPPacketsSent      uint64 `json:"ipPacketsSent"`
+	IPMalformed        uint64 `json:"ipMalformed"`
+	TransportMalformed uint64 `json:"transportMalformed"`
+	UDPMalformed       uint64 `json:"udpMalformed"`
+	UDPChecksumErrors  uint64 `json:"udpChecksumErrors"`
+	TCPSynDropped      uint64 `json:"tcpSynDropped"`
+}
+
+func stackStats(s *stack.Stack) StackStats {
+	stats := s.Stats()
+	return StackStats{
+		IPPacketsReceived:  stats.IP.PacketsReceived.Value(),
+		IPPacketsSent:      stats.IP.PacketsSent.Value(),
+		IPMalformed:        stats.IP.MalformedPacketsReceived.Value(),
+		TransportMalformed: stats.NICs.MalformedL4RcvdPackets.Value(),
+		UDPMalformed:       stats.UDP.MalformedPacketsReceived.Value(),
+		UDPChecksumErrors:  stats.UDP.ChecksumErrors.Value(),
+	}
+}
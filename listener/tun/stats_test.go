@@ -0,0 +1,112 @@
+package tun
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+var (
+	statsSrc = tcpip.AddrFrom4([4]byte{198, 18, 0, 1})
+	statsDst = tcpip.AddrFrom4([4]byte{1, 1, 1, 1})
+)
+
+// ipv4Packet wraps a transport header and payload whose checksum is set by fill
+func ipv4Packet(protocol tcpip.TransportProtocolNumber, transport []byte, fill func(xsum uint16)) []byte {
+	fill(header.PseudoHeaderChecksum(protocol, statsSrc, statsDst, uint16(len(transport))))
+	ip := header.IPv4(make([]byte, header.IPv4MinimumSize, header.IPv4MinimumSize+len(transport)))
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(header.IPv4MinimumSize + len(transport)),
+		TTL:         64,
+		Protocol:    uint8(protocol),
+		SrcAddr:     statsSrc,
+		DstAddr:     statsDst,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	return append(ip, transport...)
+}
+
+func udpPacket(badChecksum bool) []byte {
+	u := header.UDP(make([]byte, header.UDPMinimumSize+4))
+	u.Encode(&header.UDPFields{SrcPort: 5000, DstPort: 53, Length: uint16(len(u))})
+	copy(u.Payload(), "ping")
+	return ipv4Packet(header.UDPProtocolNumber, u, func(xsum uint16) {
+		xsum = ^u.CalculateChecksum(checksum.Checksum(u.Payload(), xsum))
+		if badChecksum {
+			xsum++
+		}
+		u.SetChecksum(xsum)
+	})
+}
+
+func synPacket(srcPort uint16, badChecksum bool) []byte {
+	s := header.TCP(make([]byte, header.TCPMinimumSize))
+	s.Encode(&header.TCPFields{SrcPort: srcPort, DstPort: 443, SeqNum: 1, DataOffset: header.TCPMinimumSize, Flags: header.TCPFlagSyn, WindowSize: 65535})
+	return ipv4Packet(header.TCPProtocolNumber, s, func(xsum uint16) {
+		xsum = ^s.CalculateChecksum(xsum)
+		if badChecksum {
+			xsum++
+		}
+		s.SetChecksum(xsum)
+	})
+}
+
+func TestStats_Stack(t *testing.T) {
+	linkEP := channel.New(16, 1500, "")
+	ipstack := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+	})
+	t.Cleanup(ipstack.Close)
+	require.Nil(t, ipstack.CreateNIC(nicID, linkEP))
+	ipstack.SetPromiscuousMode(nicID, true)
+	ipstack.AddRoute(tcpip.Route{Destination: header.IPv4EmptySubnet, NIC: nicID})
+
+	// the requests are held in flight until the end
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	tl := &tunAdapter{ipstack: ipstack, maxInFlight: 1}
+	tl.tcpFwd = tcp.NewForwarder(ipstack, 0, tl.maxInFlight, func(r *tcp.ForwarderRequest) {
+		tl.inFlight.Inc()
+		defer tl.inFlight.Dec()
+		<-release
+		r.Complete(true)
+	})
+	ipstack.SetTransportProtocolHandler(tcp.ProtocolNumber, tl.tcpHandlePacket)
+	ipstack.SetTransportProtocolHandler(udp.ProtocolNumber, tl.udpHandlePacket)
+
+	inject := func(packet []byte) {
+		linkEP.InjectInbound(header.IPv4ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Payload: buffer.MakeWithData(packet),
+		}))
+	}
+
+	badIP := udpPacket(false)
+	badIP[10]++
+	inject(badIP)
+	inject(udpPacket(true))
+	inject(synPacket(40000, true))
+	inject(synPacket(40001, false))
+	require.Eventually(t, func() bool { return tl.inFlight.Load() == 1 }, time.Second, 10*time.Millisecond)
+	inject(synPacket(40002, false))
+	inject(synPacket(40003, false))
+
+	stats := stackStats(ipstack)
+	assert.Equal(t, uint64(6), stats.IPPacketsReceived)
+	assert.Equal(t, uint64(1), stats.IPMalformed)
+	assert.Equal(t, uint64(1), stats.UDPChecksumErrors)
+	assert.Equal(t, uint64(0), stats.UDPMalformed)
+	assert.Equal(t, uint64(1), stats.TransportMalformed)
+	assert.Equal(t, uint64(2), tl.synDropped.Load())
+}
@@ -0,0 +1,134 @@
+package tun
+
+import (
+	"sync"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// swapEndpoint is the link endpoint of the nic over the endpoint of the device, the later
+// is replaced without the nic noticing, so the endpoints of the ipstack keep their state
+type swapEndpoint struct {
+	mux        sync.RWMutex
+	child      stack.LinkEndpoint
+	dispatcher stack.NetworkDispatcher
+}
+
+var (
+	_ stack.LinkEndpoint      = (*swapEndpoint)(nil)
+	_ stack.NetworkDispatcher = (*swapEndpoint)(nil)
+)
+
+func newSwapEndpoint(child stack.LinkEndpoint) *swapEndpoint {
+	return &swapEndpoint{child: child}
+}
+
+// swap attaches child and writes to it from now on, the old child is detached and returned.
+// The packets the old one reads after that are dropped.
+func (e *swapEndpoint) swap(child stack.LinkEndpoint) stack.LinkEndpoint {
+	e.mux.Lock()
+	old := e.child
+	e.child = child
+	attached := e.dispatcher != nil
+	e.mux.Unlock()
+
+	if attached {
+		child.Attach(e)
+	}
+	old.Attach(nil)
+	return old
+}
+
+func (e *swapEndpoint) current() stack.LinkEndpoint {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+	return e.child
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher
+func (e *swapEndpoint) DeliverNetworkPacket(protocol tcpip.NetworkProtocolNumber, pkt stack.PacketBufferPtr) {
+	e.mux.RLock()
+	d := e.dispatcher
+	e.mux.RUnlock()
+	if d != nil {
+		d.DeliverNetworkPacket(protocol, pkt)
+	}
+}
+
+// DeliverLinkPacket implements stack.NetworkDispatcher
+func (e *swapEndpoint) DeliverLinkPacket(protocol tcpip.NetworkProtocolNumber, pkt stack.PacketBufferPtr) {
+	e.mux.RLock()
+	d := e.dispatcher
+	e.mux.RUnlock()
+	if d != nil {
+		d.DeliverLinkPacket(protocol, pkt)
+	}
+}
+
+// Attach implements stack.LinkEndpoint
+func (e *swapEndpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.mux.Lock()
+	e.dispatcher = dispatcher
+	child := e.child
+	e.mux.Unlock()
+
+	if dispatcher == nil {
+		child.Attach(nil)
+		return
+	}
+	child.Attach(e)
+}
+
+// IsAttached implements stack.LinkEndpoint
+func (e *swapEndpoint) IsAttached() bool {
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+	return e.dispatcher != nil
+}
+
+// MTU implements stack.LinkEndpoint, a tcp connection keeps the mss of the mtu it started with
+func (e *swapEndpoint) MTU() uint32 {
+	return e.current().MTU()
+}
+
+// Capabilities implements stack.LinkEndpoint
+func (e *swapEndpoint) Capabilities() stack.LinkEndpointCapabilities {
+	return e.current().Capabilities()
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint
+func (e *swapEndpoint) MaxHeaderLength() uint16 {
+	return e.current().MaxHeaderLength()
+}
+
+// LinkAddress implements stack.LinkEndpoint
+func (e *swapEndpoint) LinkAddress() tcpip.LinkAddress {
+	return e.current().LinkAddress()
+}
+
+// WritePackets implements stack.LinkEndpoint
+func (e *swapEndpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Error) {
+	return e.current().WritePackets(pkts)
+}
+
+// Wait implements stack.LinkEndpoint
+func (e *swapEndpoint) Wait() {
+	e.current().Wait()
+}
+
+// ARPHardwareType implements stack.LinkEndpoint
+func (e *swapEndpoint) ARPHardwareType() header.ARPHardwareType {
+	return e.current().ARPHardwareType()
+}
+
+// AddHeader implements stack.LinkEndpoint
+func (e *swapEndpoint) AddHeader(pkt stack.PacketBufferPtr) {
+	e.current().AddHeader(pkt)
+}
+
+// ParseHeader implements stack.LinkEndpoint
+func (e *swapEndpoint) ParseHeader(pkt stack.PacketBufferPtr) bool {
+	return e.current().ParseHeader(pkt)
+}
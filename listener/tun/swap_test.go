@@ -0,0 +1,129 @@
+package tun
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+)
+
+func swapTestStack(t *testing.T, ep stack.LinkEndpoint, addr tcpip.Address) *stack.Stack {
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol},
+	})
+	t.Cleanup(s.Close)
+	require.Nil(t, s.CreateNIC(nicID, ep))
+	require.Nil(t, s.AddProtocolAddress(nicID, tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: addr.WithPrefix(),
+	}, stack.AddressProperties{}))
+	s.AddRoute(tcpip.Route{Destination: header.IPv4EmptySubnet, NIC: nicID})
+	return s
+}
+
+// pump moves the packets written to from into what to return, until ctx is done
+func pump(ctx context.Context, from *channel.Endpoint, to func() *channel.Endpoint) {
+	for {
+		pkt := from.ReadContext(ctx)
+		if pkt.IsNil() {
+			return
+		}
+		to().InjectInbound(header.IPv4ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Payload: buffer.MakeWithData(pkt.ToView().AsSlice()),
+		}))
+		pkt.DecRef()
+	}
+}
+
+func TestSwapEndpoint_KeepsConnection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the device of the adapter is first, then second, the peer stands for the system
+	first, second, peerEP := channel.New(64, 1500, ""), channel.New(64, 1400, ""), channel.New(64, 1500, "")
+	link := newSwapEndpoint(first)
+	local, peer := tcpip.AddrFrom4([4]byte{10, 0, 0, 1}), tcpip.AddrFrom4([4]byte{10, 0, 0, 2})
+	s := swapTestStack(t, link, local)
+	p := swapTestStack(t, peerEP, peer)
+
+	var device atomic.Pointer[channel.Endpoint]
+	device.Store(first)
+	go pump(ctx, first, func() *channel.Endpoint { return peerEP })
+	go pump(ctx, second, func() *channel.Endpoint { return peerEP })
+	go pump(ctx, peerEP, device.Load)
+
+	ln, err := gonet.ListenTCP(p, tcpip.FullAddress{NIC: nicID, Addr: peer, Port: 80}, ipv4.ProtocolNumber)
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	conn, err := gonet.DialContextTCP(ctx, s, tcpip.FullAddress{NIC: nicID, Addr: peer, Port: 80}, ipv4.ProtocolNumber)
+	require.NoError(t, err)
+	defer conn.Close()
+	echo := func(msg string) {
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err := conn.Write([]byte(msg))
+		require.NoError(t, err)
+		buf := make([]byte, len(msg))
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, msg, string(buf))
+	}
+	echo("before")
+
+	// the old device is detached, the writes go to the new one
+	assert.Equal(t, first, link.swap(second))
+	device.Store(second)
+	assert.False(t, first.IsAttached())
+	assert.True(t, second.IsAttached())
+	assert.Equal(t, uint32(1400), link.MTU())
+	echo("after")
+	assert.Equal(t, 0, first.NumQueued())
+}
+
+// TestReplaceDevice replaces a tun device by another one and by one of the same name, it
+// needs CAP_NET_ADMIN
+func TestReplaceDevice(t *testing.T) {
+	adapter, err := NewTunProxy("dev://clashswapa?addr=10.251.0.1&prefix=24", make(chan C.ConnContext), make(chan *inbound.PacketAdapter))
+	if err != nil {
+		t.Skipf("open tun: %s", err)
+	}
+	defer adapter.Close()
+
+	require.NoError(t, adapter.ReplaceDevice("dev://clashswapb?addr=10.251.1.1&prefix=24"))
+	assert.Equal(t, "dev://clashswapb?addr=10.251.1.1&prefix=24", adapter.DeviceURL())
+	_, err = net.InterfaceByName("clashswapa")
+	assert.Error(t, err)
+
+	require.NoError(t, adapter.ReplaceDevice("dev://clashswapb?addr=10.251.2.1&prefix=24&mtu=1400"))
+	assert.Equal(t, "10.251.2.1", adapter.Addressing().Addr.String())
+
+	err = adapter.ReplaceDevice("dev://clashswapb?tcp-sack=false")
+	assert.True(t, errors.Is(err, errStackOptionsChanged))
+	assert.Equal(t, "dev://clashswapb?addr=10.251.2.1&prefix=24&mtu=1400", adapter.DeviceURL())
+}
@@ -0,0 +1,59 @@
+package tun
+
+import (
+	"sync"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"go.uber.org/atomic"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+)
+
+// tcpConn keeps the endpoint of the gonet conn to report its state to the connections api
+type tcpConn struct {
+	*gonet.TCPConn
+	ep      tcpip.Endpoint
+	pending *atomic.Int64
+
+	mux    sync.RWMutex
+	closed bool
+}
+
+func newTCPConn(conn *gonet.TCPConn, ep tcpip.Endpoint) *tcpConn {
+	return &tcpConn{TCPConn: conn, ep: ep, pending: atomic.NewInt64(0)}
+}
+
+func (c *tcpConn) Write(b []byte) (int, error) {
+	// gonet blocks until the stack accepts all of b
+	c.pending.Add(int64(len(b)))
+	n, err := c.TCPConn.Write(b)
+	c.pending.Sub(int64(len(b)))
+	return n, err
+}
+
+func (c *tcpConn) Close() error {
+	c.mux.Lock()
+	c.closed = true
+	c.mux.Unlock()
+	return c.TCPConn.Close()
+}
+
+// EndpointState implements C.TunEndpoint
+func (c *tcpConn) EndpointState() C.TunEndpointState {
+	state := C.TunEndpointState{
+		State:        tcp.EndpointState(c.ep.State()).String(),
+		WritePending: c.pending.Load(),
+	}
+
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	// the queues are released with the endpoint
+	if !c.closed {
+		if n, err := c.ep.GetSockOptInt(tcpip.ReceiveQueueSizeOption); err == nil {
+			state.ReceiveQueue = n
+		}
+	}
+	return state
+}
@@ -0,0 +1,149 @@
+package tun
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+)
+
+const (
+	defaultTCPRcvBuffer   = 20 << 10
+	defaultTCPMaxInFlight = 1024
+	maxTCPBuffer          = 64 << 20
+	// the mss of the largest ipv4 packet
+	maxTCPMSS = 0xffff - header.IPv4MinimumSize - header.TCPMinimumSize
+)
+
+// tcpOptions are the tcp parameters of the ipstack from the query of the device url,
+// tcp-rcv-buffer=BYTES&tcp-snd-buffer=BYTES&tcp-max-in-flight=N&tcp-sack=true|false&tcp-moderate-rcv-buffer=true|false&mss=BYTES,
+// the unset ones keep the defaults of the ipstack and the forwarder
+type tcpOptions struct {
+	// rcvBuffer is the forwarder window and the default of the receive buffer range,
+	// 0 leaves the range of the ipstack and a 20k window
+	rcvBuffer   int
+	sndBuffer   int // 0 leaves the send buffer range of the ipstack
+	maxInFlight int
+	// mss clamps the syns through the tun, 0 derives it from the mtu of the device
+	mss int
+
+	sack              *bool
+	moderateRcvBuffer *bool
+}
+
+func parseTCPOptions(query url.Values) (opts tcpOptions, err error) {
+	opts.maxInFlight = defaultTCPMaxInFlight
+
+	if value := query.Get("tcp-rcv-buffer"); value != "" {
+		if opts.rcvBuffer, err = strconv.Atoi(value); err != nil || opts.rcvBuffer < tcp.MinBufferSize || opts.rcvBuffer > maxTCPBuffer {
+			return opts, fmt.Errorf("tcp-rcv-buffer %s: expect %d-%d bytes", value, tcp.MinBufferSize, maxTCPBuffer)
+		}
+	}
+	if value := query.Get("tcp-snd-buffer"); value != "" {
+		if opts.sndBuffer, err = strconv.Atoi(value); err != nil || opts.sndBuffer < tcp.MinBufferSize || opts.sndBuffer > maxTCPBuffer {
+			return opts, fmt.Errorf("tcp-snd-buffer %s: expect %d-%d bytes", value, tcp.MinBufferSize, maxTCPBuffer)
+		}
+	}
+	if value := query.Get("tcp-max-in-flight"); value != "" {
+		if opts.maxInFlight, err = strconv.Atoi(value); err != nil || opts.maxInFlight < 1 || opts.maxInFlight > 65535 {
+			return opts, fmt.Errorf("tcp-max-in-flight %s: expect 1-65535", value)
+		}
+	}
+	if value := query.Get("mss"); value != "" {
+		if opts.mss, err = strconv.Atoi(value); err != nil || opts.mss < header.TCPMinimumMSS || opts.mss > maxTCPMSS {
+			return opts, fmt.Errorf("mss %s: expect %d-%d bytes", value, header.TCPMinimumMSS, maxTCPMSS)
+		}
+	}
+	if opts.sack, err = parseToggle(query, "tcp-sack"); err != nil {
+		return opts, err
+	}
+	if opts.moderateRcvBuffer, err = parseToggle(query, "tcp-moderate-rcv-buffer"); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+func parseToggle(query url.Values, key string) (*bool, error) {
+	value := query.Get(key)
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: expect true or false", key, value)
+	}
+	return &b, nil
+}
+
+// bufferRange keeps the lower bound of the ipstack and lets the buffer grow past size
+func bufferRange(size int) (min, def, max int) {
+	max = tcp.MaxBufferSize
+	if size > max {
+		max = size
+	}
+	return tcp.MinBufferSize, size, max
+}
+
+// apply sets the options on the ipstack, it goes before the forwarder is registered
+func (o tcpOptions) apply(ipstack *stack.Stack) error {
+	if o.rcvBuffer != 0 {
+		min, def, max := bufferRange(o.rcvBuffer)
+		opt := tcpip.TCPReceiveBufferSizeRangeOption{Min: min, Default: def, Max: max}
+		if err := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+			return fmt.Errorf("set tcp-rcv-buffer %d: %s", o.rcvBuffer, err)
+		}
+	}
+	if o.sndBuffer != 0 {
+		min, def, max := bufferRange(o.sndBuffer)
+		opt := tcpip.TCPSendBufferSizeRangeOption{Min: min, Default: def, Max: max}
+		if err := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+			return fmt.Errorf("set tcp-snd-buffer %d: %s", o.sndBuffer, err)
+		}
+	}
+	if o.sack != nil {
+		opt := tcpip.TCPSACKEnabled(*o.sack)
+		if err := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+			return fmt.Errorf("set tcp-sack: %s", err)
+		}
+	}
+	if o.moderateRcvBuffer != nil {
+		opt := tcpip.TCPModerateReceiveBufferOption(*o.moderateRcvBuffer)
+		if err := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+			return fmt.Errorf("set tcp-moderate-rcv-buffer: %s", err)
+		}
+	}
+	return nil
+}
+
+func (o tcpOptions) forwarderWindow() int {
+	if o.rcvBuffer == 0 {
+		return defaultTCPRcvBuffer
+	}
+	return o.rcvBuffer
+}
+
+// describe the applied values read back from the ipstack, for the log
+func (o tcpOptions) describe(ipstack *stack.Stack) string {
+	var rcv tcpip.TCPReceiveBufferSizeRangeOption
+	var snd tcpip.TCPSendBufferSizeRangeOption
+	var sack tcpip.TCPSACKEnabled
+	var moderate tcpip.TCPModerateReceiveBufferOption
+	ipstack.TransportProtocolOption(tcp.ProtocolNumber, &rcv)
+	ipstack.TransportProtocolOption(tcp.ProtocolNumber, &snd)
+	ipstack.TransportProtocolOption(tcp.ProtocolNumber, &sack)
+	ipstack.TransportProtocolOption(tcp.ProtocolNumber, &moderate)
+
+	return strings.Join([]string{
+		fmt.Sprintf("forwarder rcv-window %d", o.forwarderWindow()),
+		fmt.Sprintf("max-in-flight %d", o.maxInFlight),
+		fmt.Sprintf("rcv-buffer %d-%d-%d", rcv.Min, rcv.Default, rcv.Max),
+		fmt.Sprintf("snd-buffer %d-%d-%d", snd.Min, snd.Default, snd.Max),
+		fmt.Sprintf("sack %t", bool(sack)),
+		fmt.Sprintf("moderate-rcv-buffer %t", bool(moderate)),
+	}, ", ")
+}
@@ -0,0 +1,69 @@
+package tun
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+)
+
+func TestParseTCPOptions(t *testing.T) {
+	opts, err := parseTCPOptions(url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, 20<<10, opts.forwarderWindow())
+	assert.Equal(t, 1024, opts.maxInFlight)
+	assert.Nil(t, opts.sack)
+	assert.Zero(t, opts.mss)
+
+	query, _ := url.ParseQuery("tcp-rcv-buffer=8388608&tcp-snd-buffer=1048576&tcp-max-in-flight=4096&tcp-sack=true&tcp-moderate-rcv-buffer=false&mss=1200")
+	opts, err = parseTCPOptions(query)
+	require.NoError(t, err)
+	assert.Equal(t, 8<<20, opts.forwarderWindow())
+	assert.Equal(t, 1<<20, opts.sndBuffer)
+	assert.Equal(t, 4096, opts.maxInFlight)
+	assert.True(t, *opts.sack)
+	assert.False(t, *opts.moderateRcvBuffer)
+	assert.Equal(t, 1200, opts.mss)
+
+	for _, raw := range []string{
+		"tcp-rcv-buffer=1024", "tcp-rcv-buffer=1m", "tcp-snd-buffer=134217728",
+		"tcp-max-in-flight=0", "tcp-sack=yes-please", "tcp-moderate-rcv-buffer=2",
+		"mss=64", "mss=65500",
+	} {
+		query, _ := url.ParseQuery(raw)
+		_, err := parseTCPOptions(query)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestTCPOptions_Apply(t *testing.T) {
+	ipstack := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol},
+	})
+	defer ipstack.Close()
+
+	query, _ := url.ParseQuery("tcp-rcv-buffer=8388608&tcp-snd-buffer=65536&tcp-sack=true&tcp-moderate-rcv-buffer=true")
+	opts, err := parseTCPOptions(query)
+	require.NoError(t, err)
+	require.NoError(t, opts.apply(ipstack))
+
+	var rcv tcpip.TCPReceiveBufferSizeRangeOption
+	require.Nil(t, ipstack.TransportProtocolOption(tcp.ProtocolNumber, &rcv))
+	assert.Equal(t, tcpip.TCPReceiveBufferSizeRangeOption{Min: tcp.MinBufferSize, Default: 8 << 20, Max: 8 << 20}, rcv)
+
+	var snd tcpip.TCPSendBufferSizeRangeOption
+	require.Nil(t, ipstack.TransportProtocolOption(tcp.ProtocolNumber, &snd))
+	assert.Equal(t, tcpip.TCPSendBufferSizeRangeOption{Min: tcp.MinBufferSize, Default: 64 << 10, Max: tcp.MaxBufferSize}, snd)
+
+	var sack tcpip.TCPSACKEnabled
+	require.Nil(t, ipstack.TransportProtocolOption(tcp.ProtocolNumber, &sack))
+	assert.True(t, bool(sack))
+
+	assert.Contains(t, opts.describe(ipstack), "rcv-buffer 4096-8388608-8388608")
+}
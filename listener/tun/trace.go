@@ -0,0 +1,147 @@
+package tun
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	// the time exceeded of ipv6 carries as much of the packet as fits in the minimum mtu
+	icmpv6ErrorMaxSize = header.IPv6MinimumMTU - header.IPv6MinimumSize
+
+	timeExceededTTL = 64
+)
+
+// traceOptions are the traceroute options from the query of the device url,
+// trace-hop=IPV4&trace-hop6=IPV6&trace-ttl=true|false. A packet arriving with a ttl of 1 is
+// answered by a time exceeded from the hop of its family, so the tun is the first hop of a
+// traceroute. trace-ttl copies the ttl of a udp flow, less that hop, onto the socket of DIRECT.
+type traceOptions struct {
+	hop     netip.Addr
+	hop6    netip.Addr
+	copyTTL bool
+}
+
+func parseTraceOptions(query url.Values) (opts traceOptions, err error) {
+	if value := query.Get("trace-hop"); value != "" {
+		if opts.hop, err = netip.ParseAddr(value); err != nil || !opts.hop.Is4() {
+			return opts, fmt.Errorf("trace-hop %s: expect an ipv4 address", value)
+		}
+	}
+	if value := query.Get("trace-hop6"); value != "" {
+		if opts.hop6, err = netip.ParseAddr(value); err != nil || !opts.hop6.Is6() || opts.hop6.Is4In6() {
+			return opts, fmt.Errorf("trace-hop6 %s: expect an ipv6 address", value)
+		}
+	}
+	copyTTL, err := parseToggle(query, "trace-ttl")
+	if err != nil {
+		return opts, err
+	}
+	opts.copyTTL = copyTTL != nil && *copyTTL
+	return opts, nil
+}
+
+// outboundTTL return the ttl a packet leaves DIRECT with, the tun is a hop when it answers
+// the ttl of 1, 0 leaves it to the system
+func (o traceOptions) outboundTTL(ttl uint8, v4 bool) uint8 {
+	if !o.copyTTL {
+		return 0
+	}
+	hop := o.hop6
+	if v4 {
+		hop = o.hop
+	}
+	if hop.IsValid() && ttl > 1 {
+		return ttl - 1
+	}
+	return ttl
+}
+
+// timeExceededV4 return the time exceeded from hop of a unicast packet arriving with a ttl of
+// 1, nil for any other packet. Like a router it doesn't answer an icmp error or a fragment
+// after the first one.
+func timeExceededV4(packet []byte, hop netip.Addr) []byte {
+	ip := header.IPv4(packet)
+	if !hop.IsValid() || !ip.IsValid(len(packet)) || ip.TTL() > 1 || ip.FragmentOffset() != 0 {
+		return nil
+	}
+	src, dst := ip.SourceAddress(), ip.DestinationAddress()
+	if header.IsV4MulticastAddress(dst) || dst == header.IPv4Broadcast || src == header.IPv4Any || header.IsV4MulticastAddress(src) {
+		return nil
+	}
+	if ip.TransportProtocol() == header.ICMPv4ProtocolNumber {
+		icmp := header.ICMPv4(ip.Payload())
+		if len(icmp) < header.ICMPv4MinimumSize || icmp.Type() != header.ICMPv4Echo {
+			return nil
+		}
+	}
+
+	// the header and the first 8 bytes of the data, enough for the ports or the echo id
+	quoted := packet
+	if size := int(ip.HeaderLength()) + 8; len(quoted) > size {
+		quoted = quoted[:size]
+	}
+	reply := make([]byte, header.IPv4MinimumSize+header.ICMPv4MinimumSize+len(quoted))
+	ipReply := header.IPv4(reply)
+	ipReply.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(reply)),
+		TTL:         timeExceededTTL,
+		Protocol:    uint8(header.ICMPv4ProtocolNumber),
+		SrcAddr:     tcpip.AddrFrom4(hop.As4()),
+		DstAddr:     src,
+	})
+	ipReply.SetChecksum(^ipReply.CalculateChecksum())
+
+	icmp := header.ICMPv4(ipReply.Payload())
+	icmp.SetType(header.ICMPv4TimeExceeded)
+	icmp.SetCode(header.ICMPv4TTLExceeded)
+	copy(icmp[header.ICMPv4MinimumSize:], quoted)
+	icmp.SetChecksum(^checksum.Checksum(icmp, 0))
+	return reply
+}
+
+// timeExceededV6 return the time exceeded from hop of a unicast packet arriving with a hop
+// limit of 1, nil for any other packet. An icmpv6 error or neighbor discovery isn't answered.
+func timeExceededV6(packet []byte, hop netip.Addr) []byte {
+	ip := header.IPv6(packet)
+	if !hop.IsValid() || !ip.IsValid(len(packet)) || ip.HopLimit() > 1 {
+		return nil
+	}
+	src, dst := ip.SourceAddress(), ip.DestinationAddress()
+	if header.IsV6MulticastAddress(dst) || src == header.IPv6Any || header.IsV6MulticastAddress(src) {
+		return nil
+	}
+	if ip.TransportProtocol() == header.ICMPv6ProtocolNumber {
+		icmp := header.ICMPv6(ip.Payload())
+		if len(icmp) < header.ICMPv6MinimumSize || icmp.Type() != header.ICMPv6EchoRequest {
+			return nil
+		}
+	}
+
+	quoted := packet
+	if size := icmpv6ErrorMaxSize - header.ICMPv6ErrorHeaderSize; len(quoted) > size {
+		quoted = quoted[:size]
+	}
+	reply := make([]byte, header.IPv6MinimumSize+header.ICMPv6ErrorHeaderSize+len(quoted))
+	ipReply := header.IPv6(reply)
+	hopAddr := tcpip.AddrFrom16(hop.As16())
+	ipReply.Encode(&header.IPv6Fields{
+		PayloadLength:     uint16(header.ICMPv6ErrorHeaderSize + len(quoted)),
+		TransportProtocol: header.ICMPv6ProtocolNumber,
+		HopLimit:          timeExceededTTL,
+		SrcAddr:           hopAddr,
+		DstAddr:           src,
+	})
+
+	icmp := header.ICMPv6(ipReply.Payload())
+	icmp.SetType(header.ICMPv6TimeExceeded)
+	icmp.SetCode(header.ICMPv6HopLimitExceeded)
+	copy(icmp[header.ICMPv6ErrorHeaderSize:], quoted)
+	icmp.SetChecksum(header.ICMPv6Checksum(header.ICMPv6ChecksumParams{Header: icmp, Src: hopAddr, Dst: src}))
+	return reply
+}
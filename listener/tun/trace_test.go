@@ -0,0 +1,158 @@
+package tun
+
+import (
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func TestParseTraceOptions(t *testing.T) {
+	opts, err := parseTraceOptions(url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, traceOptions{}, opts)
+
+	query, _ := url.ParseQuery("trace-hop=198.18.0.2&trace-hop6=fdfe::2&trace-ttl=true")
+	opts, err = parseTraceOptions(query)
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("198.18.0.2"), opts.hop)
+	assert.Equal(t, netip.MustParseAddr("fdfe::2"), opts.hop6)
+	assert.True(t, opts.copyTTL)
+
+	for _, raw := range []string{"trace-hop=fdfe::2", "trace-hop6=198.18.0.2", "trace-hop=x", "trace-ttl=maybe"} {
+		query, _ := url.ParseQuery(raw)
+		_, err := parseTraceOptions(query)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestTraceOptions_OutboundTTL(t *testing.T) {
+	assert.Equal(t, uint8(0), traceOptions{}.outboundTTL(5, true))
+
+	opts := traceOptions{hop: netip.MustParseAddr("198.18.0.2"), copyTTL: true}
+	// the tun is the first hop of ipv4, not of ipv6
+	assert.Equal(t, uint8(4), opts.outboundTTL(5, true))
+	assert.Equal(t, uint8(5), opts.outboundTTL(5, false))
+	assert.Equal(t, uint8(1), opts.outboundTTL(1, true))
+}
+
+var (
+	traceHop  = netip.MustParseAddr("198.18.0.2")
+	traceHop6 = netip.MustParseAddr("fdfe::2")
+)
+
+func injectV4(linkEP *channel.Endpoint, ttl uint8, dst tcpip.Address, protocol tcpip.TransportProtocolNumber, transport []byte) []byte {
+	ip := header.IPv4(make([]byte, header.IPv4MinimumSize, header.IPv4MinimumSize+len(transport)))
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(header.IPv4MinimumSize + len(transport)),
+		TTL:         ttl,
+		Protocol:    uint8(protocol),
+		SrcAddr:     statsSrc,
+		DstAddr:     dst,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	packet := append(ip, transport...)
+	linkEP.InjectInbound(header.IPv4ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(packet),
+	}))
+	return packet
+}
+
+func echoRequestV4() header.ICMPv4 {
+	icmp := header.ICMPv4(make([]byte, header.ICMPv4MinimumSize+4))
+	icmp.SetType(header.ICMPv4Echo)
+	icmp.SetIdent(7)
+	icmp.SetSequence(1)
+	copy(icmp.Payload(), "ping")
+	icmp.SetChecksum(^checksum.Checksum(icmp, 0))
+	return icmp
+}
+
+func noReply(t *testing.T, packets chan []byte) {
+	select {
+	case <-packets:
+		assert.Fail(t, "unexpected reply")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestTimeExceededV4(t *testing.T) {
+	linkEP, packets := icmpStack(t, traceOptions{hop: traceHop})
+
+	// a udp probe of traceroute, the quote carries its ports
+	probe := injectV4(linkEP, 1, statsDst, header.UDPProtocolNumber, header.UDP(udpPacket(false)[header.IPv4MinimumSize:]))
+	reply := header.IPv4(readReply(t, packets))
+	require.True(t, reply.IsValid(len(reply)))
+	assert.True(t, reply.IsChecksumValid())
+	assert.Equal(t, tcpip.AddrFrom4(traceHop.As4()), reply.SourceAddress())
+	assert.Equal(t, statsSrc, reply.DestinationAddress())
+	icmp := header.ICMPv4(reply.Payload())
+	assert.Equal(t, header.ICMPv4TimeExceeded, icmp.Type())
+	assert.Equal(t, header.ICMPv4TTLExceeded, icmp.Code())
+	assert.Equal(t, uint16(0xffff), checksum.Checksum(icmp, 0))
+	assert.Equal(t, probe[:header.IPv4MinimumSize+8], []byte(icmp[header.ICMPv4MinimumSize:]))
+
+	// an echo with a ttl of 1 is a hop, with 2 it's answered by the destination
+	injectV4(linkEP, 1, statsDst, header.ICMPv4ProtocolNumber, echoRequestV4())
+	assert.Equal(t, header.ICMPv4TimeExceeded, header.ICMPv4(header.IPv4(readReply(t, packets)).Payload()).Type())
+	injectV4(linkEP, 2, statsDst, header.ICMPv4ProtocolNumber, echoRequestV4())
+	reply = header.IPv4(readReply(t, packets))
+	assert.Equal(t, statsDst, reply.SourceAddress())
+	assert.Equal(t, header.ICMPv4EchoReply, header.ICMPv4(reply.Payload()).Type())
+
+	// neither a multicast nor an icmp error is answered
+	injectV4(linkEP, 1, tcpip.AddrFrom4([4]byte{239, 255, 255, 250}), header.UDPProtocolNumber, make([]byte, header.UDPMinimumSize))
+	unreachable := header.ICMPv4(make([]byte, header.ICMPv4MinimumSize))
+	unreachable.SetType(header.ICMPv4DstUnreachable)
+	unreachable.SetChecksum(^checksum.Checksum(unreachable, 0))
+	injectV4(linkEP, 1, statsDst, header.ICMPv4ProtocolNumber, unreachable)
+	noReply(t, packets)
+}
+
+func TestTimeExceededV4_NoHop(t *testing.T) {
+	linkEP, packets := icmpStack(t, traceOptions{hop6: traceHop6})
+	injectV4(linkEP, 1, statsDst, header.ICMPv4ProtocolNumber, echoRequestV4())
+	assert.Equal(t, header.ICMPv4EchoReply, header.ICMPv4(header.IPv4(readReply(t, packets)).Payload()).Type())
+}
+
+func TestTimeExceededV6(t *testing.T) {
+	linkEP, packets := icmpStack(t, traceOptions{hop6: traceHop6})
+
+	src := tcpip.AddrFrom16([16]byte{0xfd, 15: 1})
+	dst := tcpip.AddrFrom16([16]byte{0x20, 0x01, 0x48, 0x60, 15: 0x88})
+	u := header.UDP(make([]byte, header.UDPMinimumSize+1400))
+	u.Encode(&header.UDPFields{SrcPort: 5000, DstPort: 33434, Length: uint16(len(u))})
+	ip := header.IPv6(make([]byte, header.IPv6MinimumSize, header.IPv6MinimumSize+len(u)))
+	ip.Encode(&header.IPv6Fields{
+		PayloadLength:     uint16(len(u)),
+		TransportProtocol: header.UDPProtocolNumber,
+		HopLimit:          1,
+		SrcAddr:           src,
+		DstAddr:           dst,
+	})
+	probe := append(ip, u...)
+	linkEP.InjectInbound(header.IPv6ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(probe),
+	}))
+
+	reply := header.IPv6(readReply(t, packets))
+	require.True(t, reply.IsValid(len(reply)))
+	assert.Equal(t, tcpip.AddrFrom16(traceHop6.As16()), reply.SourceAddress())
+	assert.Equal(t, src, reply.DestinationAddress())
+	// cut to the minimum mtu
+	assert.Equal(t, header.IPv6MinimumMTU, len(reply))
+	icmp := header.ICMPv6(reply.Payload())
+	assert.Equal(t, header.ICMPv6TimeExceeded, icmp.Type())
+	assert.Equal(t, header.ICMPv6HopLimitExceeded, icmp.Code())
+	assert.Equal(t, header.ICMPv6Checksum(header.ICMPv6ChecksumParams{Header: icmp, Src: reply.SourceAddress(), Dst: src}), icmp.Checksum())
+	assert.Equal(t, []byte(probe[:len(icmp)-header.ICMPv6ErrorHeaderSize]), []byte(icmp[header.ICMPv6ErrorHeaderSize:]))
+}
@@ -1,15 +1,45 @@
 package tun
 
-import "github.com/Dreamacro/clash/dns"
+import (
+	"github.com/Dreamacro/clash/dns"
+	"github.com/Dreamacro/clash/listener/tun/dev"
+)
 
 // TunAdapter hold the state of tun/tap interface
 type TunAdapter interface {
+	// Close tears the adapter down and waits for the reads of the device, its fd is released
+	// on return so the interface can be opened again
 	Close()
 	DeviceURL() string
+	// Replace the device under the ipstack, the connections through it are kept
+	ReplaceDevice(deviceURL string) error
 	// Creates dns server on tun device
 	ReCreateDNSServer(addr string) error
 	// Set the resolver to serve DNS request
 	ResetDNSResolver(resolver *dns.Resolver, mapper *dns.ResolverEnhancer) error
 	// Get the current listening address of DNS Server
 	DNSListen() string
+	// Answers the udp dns queries to the addresses read from the tun
+	ReCreateDNSHijack(addrs []string) error
+	// Get the addresses whose udp dns queries are answered
+	DNSHijack() []string
+	// Get the state of the queues to the tunnel and the counters of the ipstack and the device
+	Stats() Stats
+	// Get the addresses set on the device from its url
+	Addressing() dev.Addressing
+	// Get the name and the mtu of the device
+	Device() (name string, mtu int)
+	// Sets the sniffing of the host of the new tcp connections, nil turns it off
+	SetSniffing(opts *SniffOptions)
+	// Get the options of the sniffing, nil when it's off
+	Sniffing() *SniffOptions
+	// Turns the lookup of the process of the new connections on or off
+	SetFindProcess(enable bool)
+	// Get whether the connections look up their process
+	FindProcess() bool
+	// Sets the destinations of the new connections and udp packets skipping the tunnel, nil
+	// sends every one to it
+	SetBypass(opts *BypassOptions)
+	// Get the options of the bypass, nil when it's off
+	Bypass() *BypassOptions
 }
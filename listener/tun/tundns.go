@@ -3,6 +3,8 @@ package tun
 import (
 	"fmt"
 	"net"
+	"net/netip"
+	"strconv"
 
 	"github.com/Dreamacro/clash/dns"
 	"github.com/Dreamacro/clash/log"
@@ -47,9 +49,11 @@ type dnsUDPEndpoint struct {
 
 // Keep track of the source of DNS request
 type dnsResponseWriter struct {
-	s   *stack.Stack
-	pkt *stack.PacketBuffer // The request packet
-	id  stack.TransportEndpointID
+	s *stack.Stack
+	// the nic and protocol of the request packet, it is released once HandlePacket returns
+	nicID    tcpip.NICID
+	protocol tcpip.NetworkProtocolNumber
+	id       stack.TransportEndpointID
 }
 
 func (e *dnsUDPEndpoint) UniqueID() uint64 {
@@ -71,7 +75,7 @@ func (e *dnsUDPEndpoint) HandlePacket(id stack.TransportEndpointID, pkt *stack.P
 	// server DNS
 	var msg D.Msg
 	msg.Unpack(pkt.Data().AsRange().ToView().AsSlice())
-	writer := dnsResponseWriter{s: e.stack, pkt: pkt, id: id}
+	writer := dnsResponseWriter{s: e.stack, nicID: pkt.NICID, protocol: pkt.NetworkProtocolNumber, id: id}
 	go e.ServeDNS(&writer, &msg)
 }
 
@@ -122,7 +126,11 @@ func (w *dnsResponseWriter) Hijack() {
 func (w *dnsResponseWriter) Write(b []byte) (int, error) {
 	data := buffer.NewViewWithData(b)
 	// w.id.LocalAddress is the source ip of DNS response
-	r, _ := w.s.FindRoute(w.pkt.NICID, w.id.LocalAddress, w.id.RemoteAddress, w.pkt.NetworkProtocolNumber, false /* multicastLoop */)
+	r, err := w.s.FindRoute(w.nicID, w.id.LocalAddress, w.id.RemoteAddress, w.protocol, false /* multicastLoop */)
+	if err != nil {
+		return 0, fmt.Errorf("route the dns response to %s: %s", w.id.RemoteAddress, err)
+	}
+	defer r.Release()
 	return writeUDP(r, data, w.id.LocalPort, w.id.RemotePort)
 }
 
@@ -235,9 +243,6 @@ func (s *DNSServer) Stop() {
 
 // Set the resolver to serve DNS request
 func (s *DNSServer) ResetResolver(resolver *dns.Resolver, mapper *dns.ResolverEnhancer) error {
-	if resolver == nil {
-		return fmt.Errorf("failed to create DNS server on tun: resolver not provided")
-	}
 	if resolver == s.resolver && mapper == s.mapper {
 		return nil
 	}
@@ -247,6 +252,13 @@ func (s *DNSServer) ResetResolver(resolver *dns.Resolver, mapper *dns.ResolverEn
 	// Stop the old server
 	if s.Server != nil {
 		s.Server.Shutdown()
+		s.Server = nil
+	}
+
+	// dns is disabled, don't answer with a stale resolver
+	if resolver == nil {
+		s.udpEndpoint.ServeDNS = nil
+		return nil
 	}
 	// Create a new server
 	handler := dns.NewHandler(resolver, mapper)
@@ -306,12 +318,150 @@ func (t *tunAdapter) ReCreateDNSServer(addr string) error {
 	}
 	t.dnsserver = server
 	log.Infoln("Tun DNS server listening at: %s", addr)
+	if t.dnsResolver != nil {
+		return server.ResetResolver(t.dnsResolver, t.dnsMapper)
+	}
 	return nil
 }
 
 func (t *tunAdapter) ResetDNSResolver(resolver *dns.Resolver, mapper *dns.ResolverEnhancer) error {
+	t.dnsResolver, t.dnsMapper = resolver, mapper
+	if t.dnsHijack != nil {
+		t.dnsHijack.setResolver(resolver, mapper)
+	}
 	if t.dnsserver != nil {
 		return t.dnsserver.ResetResolver(resolver, mapper)
 	}
 	return nil
 }
+
+// DNSHijack return the addresses whose udp dns queries are answered
+func (t *tunAdapter) DNSHijack() []string {
+	if t.dnsHijack != nil {
+		return t.dnsHijack.addrs
+	}
+	return nil
+}
+
+// ReCreateDNSHijack answers the udp dns queries to addrs read from the tun, an address is
+// IP:PORT or any:PORT for every destination
+func (t *tunAdapter) ReCreateDNSHijack(addrs []string) error {
+	if t.dnsHijack != nil {
+		if equalStrings(addrs, t.dnsHijack.addrs) {
+			return nil
+		}
+		t.dnsHijack.stop()
+		t.dnsHijack = nil
+	}
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	hijack, err := newDNSHijack(t.ipstack, addrs)
+	if err != nil {
+		return err
+	}
+	if t.dnsResolver != nil {
+		hijack.setResolver(t.dnsResolver, t.dnsMapper)
+	}
+	t.dnsHijack = hijack
+	log.Infoln("Tun DNS hijack: %v", addrs)
+	return nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseDNSHijack parses an address of dns-hijack, IP:PORT or any:PORT, an unspecified ip
+// takes every destination of its family
+func ParseDNSHijack(addr string) ([]tcpip.NetworkProtocolNumber, stack.TransportEndpointID, error) {
+	var id stack.TransportEndpointID
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, id, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return nil, id, fmt.Errorf("%s: invalid port %s", addr, portStr)
+	}
+	id.LocalPort = uint16(port)
+
+	if host == "any" {
+		return []tcpip.NetworkProtocolNumber{ipv4.ProtocolNumber, ipv6.ProtocolNumber}, id, nil
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil, id, fmt.Errorf("%s: expect IP:PORT or any:PORT", addr)
+	}
+	ip = ip.Unmap()
+	protocol := ipv6.ProtocolNumber
+	if ip.Is4() {
+		protocol = ipv4.ProtocolNumber
+	}
+	if !ip.IsUnspecified() {
+		id.LocalAddress = tcpip.AddrFromSlice(ip.AsSlice())
+	}
+	return []tcpip.NetworkProtocolNumber{protocol}, id, nil
+}
+
+// dnsHijack answers the udp dns queries to its addresses with the resolver, the response
+// comes from the address the query went to. The queries are taken before the udp sessions
+// to the tunnel, no rule sees them.
+type dnsHijack struct {
+	stack     *stack.Stack
+	addrs     []string
+	endpoints []hijackEndpoint
+}
+
+type hijackEndpoint struct {
+	protocols []tcpip.NetworkProtocolNumber
+	id        stack.TransportEndpointID
+	endpoint  *dnsUDPEndpoint
+}
+
+func newDNSHijack(s *stack.Stack, addrs []string) (*dnsHijack, error) {
+	h := &dnsHijack{stack: s, addrs: append([]string(nil), addrs...)}
+	for _, addr := range addrs {
+		protocols, id, err := ParseDNSHijack(addr)
+		if err != nil {
+			h.stop()
+			return nil, err
+		}
+		endpoint := &dnsUDPEndpoint{stack: s, uniqueID: s.UniqueID()}
+		if err := s.RegisterTransportEndpoint(protocols, udp.ProtocolNumber, id, endpoint, ports.Flags{LoadBalanced: true}, nicID); err != nil {
+			h.stop()
+			return nil, fmt.Errorf("dns hijack %s: %s", addr, err)
+		}
+		h.endpoints = append(h.endpoints, hijackEndpoint{protocols: protocols, id: id, endpoint: endpoint})
+	}
+	return h, nil
+}
+
+// setResolver answers the queries with resolver, nil leaves them unanswered
+func (h *dnsHijack) setResolver(resolver *dns.Resolver, mapper *dns.ResolverEnhancer) {
+	var serve func(w D.ResponseWriter, r *D.Msg)
+	if resolver != nil {
+		server := &dns.Server{}
+		server.SetHandler(dns.NewHandler(resolver, mapper))
+		serve = server.ServeDNS
+	}
+	for _, e := range h.endpoints {
+		e.endpoint.ServeDNS = serve
+	}
+}
+
+func (h *dnsHijack) stop() {
+	for _, e := range h.endpoints {
+		h.stack.UnregisterTransportEndpoint(e.protocols, udp.ProtocolNumber, e.id, e.endpoint, ports.Flags{LoadBalanced: true}, nicID)
+	}
+	h.endpoints = nil
+}
@@ -0,0 +1,190 @@
+package tun
+
+import (
+	"testing"
+	"time"
+
+	D "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+// injectQuery sends a query for example.com from 198.18.0.1:5000 to dst:port through the tun
+func injectQuery(t *testing.T, linkEP *channel.Endpoint, dst tcpip.Address, port uint16) {
+	msg := &D.Msg{}
+	msg.SetQuestion("example.com.", D.TypeA)
+	query, err := msg.Pack()
+	require.NoError(t, err)
+
+	src := tcpip.AddrFrom4([4]byte{198, 18, 0, 1})
+	u := header.UDP(make([]byte, header.UDPMinimumSize+len(query)))
+	u.Encode(&header.UDPFields{SrcPort: 5000, DstPort: port, Length: uint16(len(u))})
+	copy(u.Payload(), query)
+	xsum := header.PseudoHeaderChecksum(header.UDPProtocolNumber, src, dst, uint16(len(u)))
+	u.SetChecksum(^u.CalculateChecksum(checksum.Checksum(u.Payload(), xsum)))
+
+	ip := header.IPv4(make([]byte, header.IPv4MinimumSize))
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(header.IPv4MinimumSize + len(u)),
+		TTL:         64,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		SrcAddr:     src,
+		DstAddr:     dst,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	linkEP.InjectInbound(header.IPv4ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(append(ip, u...)),
+	}))
+}
+
+func TestDNSHijack(t *testing.T) {
+	linkEP := channel.New(16, 1500, "")
+	ipstack := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+	})
+	defer ipstack.Close()
+	require.Nil(t, ipstack.CreateNIC(nicID, linkEP))
+	ipstack.SetPromiscuousMode(nicID, true)
+	ipstack.SetSpoofing(nicID, true)
+	ipstack.AddRoute(tcpip.Route{Destination: header.IPv4EmptySubnet, NIC: nicID})
+	passed := make(chan uint16, 4)
+	ipstack.SetTransportProtocolHandler(udp.ProtocolNumber, func(id stack.TransportEndpointID, pkt stack.PacketBufferPtr) bool {
+		passed <- id.LocalPort
+		return true
+	})
+
+	hijack, err := newDNSHijack(ipstack, []string{"any:53"})
+	require.NoError(t, err)
+	for _, e := range hijack.endpoints {
+		e.endpoint.ServeDNS = func(w D.ResponseWriter, r *D.Msg) {
+			reply := &D.Msg{}
+			reply.SetReply(r)
+			rr, _ := D.NewRR("example.com. 60 IN A 198.18.0.9")
+			reply.Answer = append(reply.Answer, rr)
+			w.WriteMsg(reply)
+		}
+	}
+
+	dst := tcpip.AddrFrom4([4]byte{8, 8, 8, 8})
+	injectQuery(t, linkEP, dst, 53)
+	var pkt stack.PacketBufferPtr
+	require.Eventually(t, func() bool {
+		pkt = linkEP.Read()
+		return !pkt.IsNil()
+	}, time.Second, 10*time.Millisecond)
+	ip := header.IPv4(pkt.ToView().AsSlice())
+	pkt.DecRef()
+	assert.Equal(t, dst, ip.SourceAddress())
+	u := header.UDP(ip.Payload())
+	assert.Equal(t, uint16(53), u.SourcePort())
+	assert.Equal(t, uint16(5000), u.DestinationPort())
+	reply := &D.Msg{}
+	require.NoError(t, reply.Unpack(u.Payload()))
+	require.Len(t, reply.Answer, 1)
+	assert.Equal(t, "198.18.0.9", reply.Answer[0].(*D.A).A.String())
+
+	// another port goes to the tunnel
+	injectQuery(t, linkEP, dst, 5353)
+	assert.Equal(t, uint16(5353), <-passed)
+
+	// stopped, port 53 too
+	hijack.stop()
+	injectQuery(t, linkEP, dst, 53)
+	assert.Equal(t, uint16(53), <-passed)
+}
+
+func TestParseDNSHijack(t *testing.T) {
+	protocols, id, err := ParseDNSHijack("any:53")
+	require.NoError(t, err)
+	assert.Equal(t, []tcpip.NetworkProtocolNumber{ipv4.ProtocolNumber, ipv6.ProtocolNumber}, protocols)
+	assert.Equal(t, stack.TransportEndpointID{LocalPort: 53}, id)
+
+	protocols, id, err = ParseDNSHijack("198.18.0.2:53")
+	require.NoError(t, err)
+	assert.Equal(t, []tcpip.NetworkProtocolNumber{ipv4.ProtocolNumber}, protocols)
+	assert.Equal(t, tcpip.AddrFrom4([4]byte{198, 18, 0, 2}), id.LocalAddress)
+
+	protocols, id, err = ParseDNSHijack("[::]:53")
+	require.NoError(t, err)
+	assert.Equal(t, []tcpip.NetworkProtocolNumber{ipv6.ProtocolNumber}, protocols)
+	assert.Equal(t, tcpip.Address{}, id.LocalAddress)
+
+	for _, addr := range []string{"198.18.0.2", "any:0", "any:dns", "example.com:53"} {
+		_, _, err := ParseDNSHijack(addr)
+		assert.Error(t, err, addr)
+	}
+}
+
+// TestDNSHijack_IPv6 answers an AAAA query sent over ipv6 from the address it went to
+func TestDNSHijack_IPv6(t *testing.T) {
+	linkEP := channel.New(16, 1500, "")
+	ipstack := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+	})
+	defer ipstack.Close()
+	require.Nil(t, ipstack.CreateNIC(nicID, linkEP))
+	ipstack.SetPromiscuousMode(nicID, true)
+	ipstack.SetSpoofing(nicID, true)
+	ipstack.AddRoute(tcpip.Route{Destination: header.IPv6EmptySubnet, NIC: nicID})
+
+	hijack, err := newDNSHijack(ipstack, []string{"any:53"})
+	require.NoError(t, err)
+	defer hijack.stop()
+	for _, e := range hijack.endpoints {
+		e.endpoint.ServeDNS = func(w D.ResponseWriter, r *D.Msg) {
+			reply := &D.Msg{}
+			reply.SetReply(r)
+			rr, _ := D.NewRR("example.com. 60 IN AAAA fd00:c1a5::9")
+			reply.Answer = append(reply.Answer, rr)
+			w.WriteMsg(reply)
+		}
+	}
+
+	msg := &D.Msg{}
+	msg.SetQuestion("example.com.", D.TypeAAAA)
+	query, err := msg.Pack()
+	require.NoError(t, err)
+	src := tcpip.AddrFrom16([16]byte{0xfd, 0x00, 0xc1, 0xa5, 15: 1})
+	dst := tcpip.AddrFrom16([16]byte{0x20, 0x01, 0x48, 0x60, 0x48, 0x60, 14: 0x88, 15: 0x88})
+	u := header.UDP(make([]byte, header.UDPMinimumSize+len(query)))
+	u.Encode(&header.UDPFields{SrcPort: 5000, DstPort: 53, Length: uint16(len(u))})
+	copy(u.Payload(), query)
+	xsum := header.PseudoHeaderChecksum(header.UDPProtocolNumber, src, dst, uint16(len(u)))
+	u.SetChecksum(^u.CalculateChecksum(checksum.Checksum(u.Payload(), xsum)))
+	ip := header.IPv6(make([]byte, header.IPv6MinimumSize))
+	ip.Encode(&header.IPv6Fields{
+		PayloadLength:     uint16(len(u)),
+		TransportProtocol: header.UDPProtocolNumber,
+		HopLimit:          64,
+		SrcAddr:           src,
+		DstAddr:           dst,
+	})
+	linkEP.InjectInbound(header.IPv6ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(append(ip, u...)),
+	}))
+
+	var pkt stack.PacketBufferPtr
+	require.Eventually(t, func() bool {
+		pkt = linkEP.Read()
+		return !pkt.IsNil()
+	}, time.Second, 10*time.Millisecond)
+	replyIP := header.IPv6(pkt.ToView().AsSlice())
+	pkt.DecRef()
+	assert.Equal(t, dst, replyIP.SourceAddress())
+	assert.Equal(t, src, replyIP.DestinationAddress())
+	reply := &D.Msg{}
+	require.NoError(t, reply.Unpack(header.UDP(replyIP.Payload()).Payload()))
+	require.Len(t, reply.Answer, 1)
+	assert.Equal(t, "fd00:c1a5::9", reply.Answer[0].(*D.AAAA).AAAA.String())
+}
@@ -1,19 +1,25 @@
 package tun
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
+	"reflect"
 	"strconv"
+	"sync"
 
 	"github.com/Dreamacro/clash/adapter/inbound"
 	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/dns"
 	"github.com/Dreamacro/clash/listener/tun/dev"
 	"github.com/Dreamacro/clash/log"
 	"github.com/Dreamacro/clash/transport/socks5"
 
 	"encoding/binary"
 
+	"go.uber.org/atomic"
+
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
@@ -27,50 +33,98 @@ import (
 
 const nicID tcpip.NICID = 1
 
+// errStackOptionsChanged is returned by ReplaceDevice for a url with other tcp, udp or trace
+// options, only a new adapter applies them
+var errStackOptionsChanged = errors.New("the tcp, udp or trace options of the tun device url changed")
+
 // tunAdapter is the wraper of tun
 type tunAdapter struct {
-	device  dev.TunDevice
-	ipstack *stack.Stack
-
+	// deviceMux guards device, ReplaceDevice swaps it along with the endpoint under link
+	deviceMux sync.RWMutex
+	device    dev.TunDevice
+	link      *swapEndpoint
+	ipstack   *stack.Stack
+	// the options of the ipstack from the device url, a replacing device can't change them
+	opts stackOptions
+
+	tcpInbound chan<- C.ConnContext
+	tcpQueue   *inboundQueue[C.ConnContext]
 	udpInbound chan<- *inbound.PacketAdapter
+	udpQueue   *inboundQueue[*inbound.PacketAdapter]
+	udpBatcher *udpCoalescer
+	udpFlows   *udpSessions
+
+	tcpFwd *tcp.Forwarder
+	// the forwarder ignores a syn beyond maxInFlight requests without a counter
+	maxInFlight int
+	inFlight    atomic.Int32
+	synDropped  atomic.Uint64
+
+	// the lookup of the process of the connections, nil when it's off
+	processes atomic.Pointer[processFinder]
+	// the sniffing of the tcp connections, nil when it's off
+	sniffing atomic.Pointer[SniffOptions]
+	// the destinations skipping the tunnel, nil when every one goes to it
+	bypass atomic.Pointer[bypassTable]
+
+	// the addr6 prefix of the device, for the neighbor solicitations
+	neighbors neighbors6
 
 	dnsserver *DNSServer
+	dnsHijack *dnsHijack
+	// the resolver of ResetDNSResolver, for the dns servers created after it
+	dnsResolver *dns.Resolver
+	dnsMapper   *dns.ResolverEnhancer
 }
 
 // NewTunProxy create TunProxy under Linux OS.
 func NewTunProxy(deviceURL string, tcpIn chan<- C.ConnContext, udpIn chan<- *inbound.PacketAdapter) (TunAdapter, error) {
 
-	var err error
-
-	url, err := url.Parse(deviceURL)
+	url, opts, err := parseDeviceURL(deviceURL)
 	if err != nil {
-		return nil, fmt.Errorf("invalid tun device url: %v", err)
+		return nil, err
 	}
+	tcpOpts, udpOpts := opts.tcp, opts.udp
 
-	tundev, err := dev.OpenTunDevice(*url)
+	tundev, linkEP, err := openLink(url)
 	if err != nil {
-		return nil, fmt.Errorf("can't open tun: %v", err)
+		return nil, err
 	}
 
 	ipstack := stack.New(stack.Options{
 		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
 		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
 	})
+	if err := tcpOpts.apply(ipstack); err != nil {
+		ipstack.Close()
+		tundev.Close()
+		return nil, err
+	}
 
 	tl := &tunAdapter{
 		device:     tundev,
+		link:       newSwapEndpoint(linkEP),
 		ipstack:    ipstack,
+		opts:       opts,
+		tcpInbound: tcpIn,
 		udpInbound: udpIn,
 	}
+	tl.tcpQueue = newInboundQueue(tcpQueueSize, tcpQueueWorkers, func(conn C.ConnContext) {
+		tl.tcpInbound <- conn
+	})
+	tl.udpQueue = newInboundQueue(udpQueueSize, udpQueueWorkers, func(packet *inbound.PacketAdapter) {
+		tl.udpInbound <- packet
+	})
+	tl.udpBatcher = newUDPCoalescer(udpBatchWindow, udpBatchSize, tl.enqueueUDP)
+	tl.udpFlows = newUDPSessions(ipstack, udpOpts)
 
-	linkEP, err := tundev.AsLinkEndpoint()
-	if err != nil {
-		return nil, fmt.Errorf("unable to create virtual endpoint: %v", err)
-	}
-
-	if err := ipstack.CreateNIC(nicID, linkEP); err != nil {
+	// the clamp of a device replacing this one is still of its mtu
+	clamp := newMSSClamp(tcpOpts.mss, tl.link.MTU())
+	nic := newICMPEndpoint(newMSSEndpoint(newCaptureEndpoint(tl.link), clamp), opts.trace, &tl.neighbors)
+	if err := ipstack.CreateNIC(nicID, nic); err != nil {
 		return nil, fmt.Errorf("fail to create NIC in ipstack: %v", err)
 	}
+	tl.setAddressing6(tundev.Addressing().IP6)
 
 	ipstack.SetPromiscuousMode(nicID, true) // Accept all the traffice from this NIC
 	ipstack.SetSpoofing(nicID, true)        // Otherwise our TCP connection can not find the route backward
@@ -81,81 +135,309 @@ func NewTunProxy(deviceURL string, tcpIn chan<- C.ConnContext, udpIn chan<- *inb
 	ipstack.AddRoute(tcpip.Route{Destination: header.IPv6EmptySubnet, Gateway: tcpip.Address{}, NIC: nicID})
 
 	// TCP handler
-	// maximum number of half-open tcp connection set to 1024
-	// receive buffer size set to 20k
-	tcpFwd := tcp.NewForwarder(ipstack, 20*1024, 1024, func(r *tcp.ForwarderRequest) {
-		src := net.JoinHostPort(r.ID().RemoteAddress.String(), strconv.Itoa((int)(r.ID().RemotePort)))
-		dst := net.JoinHostPort(r.ID().LocalAddress.String(), strconv.Itoa((int)(r.ID().LocalPort)))
-		log.Debugln("Get TCP Syn %v -> %s in ipstack", src, dst)
-		var wq waiter.Queue
-		ep, err := r.CreateEndpoint(&wq)
-		if err != nil {
-			log.Warnln("Can't create TCP Endpoint(%s -> %s) in ipstack: %v", src, dst, err)
-			r.Complete(true)
-			return
+	// maximum number of half-open tcp connection and the receive window default to 1024 and 20k
+	tl.tcpFwd = tcp.NewForwarder(ipstack, tcpOpts.forwarderWindow(), tcpOpts.maxInFlight, tl.acceptTCP)
+	tl.maxInFlight = tcpOpts.maxInFlight
+	ipstack.SetTransportProtocolHandler(tcp.ProtocolNumber, tl.tcpHandlePacket)
+	log.Infoln("[TUN] tcp %s, mss %d, ipv6 mss %d", tcpOpts.describe(ipstack), clamp.v4, clamp.v6)
+	log.Infoln("[TUN] udp sessions idle timeout %s, max %d", udpOpts.timeout, udpOpts.maxSessions)
+	if opts.trace.hop.IsValid() || opts.trace.hop6.IsValid() || opts.trace.copyTTL {
+		log.Infoln("[TUN] trace hop %s, hop6 %s, copy ttl %t", opts.trace.hop, opts.trace.hop6, opts.trace.copyTTL)
+	}
+
+	// UDP handler
+	ipstack.SetTransportProtocolHandler(udp.ProtocolNumber, tl.udpHandlePacket)
+
+	log.Infoln("Tun adapter have interface name: %s", tundev.Name())
+	return tl, nil
+
+}
+
+// stackOptions are the options of the ipstack in the query of the device url
+type stackOptions struct {
+	tcp   tcpOptions
+	udp   udpSessionOptions
+	trace traceOptions
+}
+
+// parseDeviceURL parses the url and the options of the ipstack in its query
+func parseDeviceURL(deviceURL string) (u *url.URL, opts stackOptions, err error) {
+	if u, err = url.Parse(deviceURL); err != nil {
+		return nil, opts, fmt.Errorf("invalid tun device url: %v", err)
+	}
+	query := u.Query()
+	if opts.tcp, err = parseTCPOptions(query); err != nil {
+		return nil, opts, fmt.Errorf("invalid tun device url %s: %v", deviceURL, err)
+	}
+	if opts.udp, err = parseUDPSessionOptions(query); err != nil {
+		return nil, opts, fmt.Errorf("invalid tun device url %s: %v", deviceURL, err)
+	}
+	if opts.trace, err = parseTraceOptions(query); err != nil {
+		return nil, opts, fmt.Errorf("invalid tun device url %s: %v", deviceURL, err)
+	}
+	return u, opts, nil
+}
+
+// openLink opens the device of the url with its link endpoint, the read loop is running
+func openLink(u *url.URL) (dev.TunDevice, stack.LinkEndpoint, error) {
+	tundev, err := dev.OpenTunDevice(*u)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't open tun: %v", err)
+	}
+	linkEP, err := tundev.AsLinkEndpoint()
+	if err != nil {
+		tundev.Close()
+		return nil, nil, fmt.Errorf("unable to create virtual endpoint: %v", err)
+	}
+	return tundev, linkEP, nil
+}
+
+// ReplaceDevice puts the device of deviceURL under the nic in place of the current one, the
+// ipstack and its connections are kept. The new device is opened first and the old one
+// closed after the swap, except for a device of the same name which is closed first, the
+// packets in between are lost and tcp retransmits them. If the new one can't be opened the
+// old one is reopened. The tcp, udp and trace options of the url are of the ipstack, a
+// change of them is errStackOptionsChanged.
+func (t *tunAdapter) ReplaceDevice(deviceURL string) error {
+	u, opts, err := parseDeviceURL(deviceURL)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(opts, t.opts) {
+		return errStackOptionsChanged
+	}
+
+	old := t.currentDevice()
+	sameName := u.Host == old.Name()
+	if sameName {
+		old.Close()
+		old.Wait()
+	}
+
+	tundev, linkEP, err := openLink(u)
+	if err != nil {
+		if !sameName {
+			return err
 		}
-		r.Complete(false)
+		oldURL, _ := url.Parse(old.URL())
+		tundev, linkEP, reopenErr := openLink(oldURL)
+		if reopenErr != nil {
+			return fmt.Errorf("%w, reopen %s: %v", err, old.URL(), reopenErr)
+		}
+		t.swapDevice(tundev, linkEP)
+		return err
+	}
 
-		conn := gonet.NewTCPConn(&wq, ep)
+	t.swapDevice(tundev, linkEP)
+	if !sameName {
+		old.Close()
+		old.Wait()
+	}
+	log.Infoln("[TUN] device %s replaced by %s", old.Name(), tundev.Name())
+	return nil
+}
+
+func (t *tunAdapter) swapDevice(tundev dev.TunDevice, linkEP stack.LinkEndpoint) {
+	t.link.swap(linkEP)
+	t.deviceMux.Lock()
+	t.device = tundev
+	t.deviceMux.Unlock()
+	t.setAddressing6(tundev.Addressing().IP6)
+}
+
+func (t *tunAdapter) currentDevice() dev.TunDevice {
+	t.deviceMux.RLock()
+	defer t.deviceMux.RUnlock()
+	return t.device
+}
 
-		// if the endpoint is not in connected state, conn.RemoteAddr() will return nil
-		// this protection may be not enough, but will help us debug the panic
-		if conn.RemoteAddr() == nil {
-			log.Warnln("TCP endpoint is not connected, current state: %v", tcp.EndpointState(ep.State()))
-			conn.Close()
-			return
+// tcpHandlePacket counts the syns arriving with maxInFlight requests in the forwarder, a
+// retransmitted syn of a request in flight is counted too
+func (t *tunAdapter) tcpHandlePacket(id stack.TransportEndpointID, pkt *stack.PacketBuffer) bool {
+	if int(t.inFlight.Load()) >= t.maxInFlight {
+		if hdr := header.TCP(pkt.TransportHeader().Slice()); len(hdr) >= header.TCPMinimumSize &&
+			hdr.Flags()&(header.TCPFlagSyn|header.TCPFlagAck) == header.TCPFlagSyn {
+			t.synDropped.Inc()
 		}
+	}
+	return t.tcpFwd.HandlePacket(id, pkt)
+}
 
-		target := getAddr(ep.Info().(*stack.TransportEndpointInfo).ID)
-		tcpIn <- inbound.NewSocket(target, conn, C.TUN)
+// acceptTCP runs in a goroutine of the forwarder, it must not block on the tunnel
+// or the in-flight requests of the forwarder pile up and new syns are dropped silently
+func (t *tunAdapter) acceptTCP(r *tcp.ForwarderRequest) {
+	t.inFlight.Inc()
+	defer t.inFlight.Dec()
+
+	bypass := t.bypass.Load()
+	excluded := bypass.excludes(r.ID().LocalAddress)
+	if excluded && bypass.opts.Reject {
+		r.Complete(true)
+		return
+	}
 
-	})
-	ipstack.SetTransportProtocolHandler(tcp.ProtocolNumber, tcpFwd.HandlePacket)
+	// a syn is answered with a rst when the accept queue is full
+	if !excluded && t.tcpQueue.full() {
+		t.tcpQueue.drop()
+		log.Dedupln(log.WARNING, "tun-tcp-queue", "[TUN] tcp accept queue is full, connection reset")
+		r.Complete(true)
+		return
+	}
 
-	// UDP handler
-	ipstack.SetTransportProtocolHandler(udp.ProtocolNumber, tl.udpHandlePacket)
+	src := net.JoinHostPort(r.ID().RemoteAddress.String(), strconv.Itoa((int)(r.ID().RemotePort)))
+	dst := net.JoinHostPort(r.ID().LocalAddress.String(), strconv.Itoa((int)(r.ID().LocalPort)))
+	log.Debugln("Get TCP Syn %v -> %s in ipstack", src, dst)
+	var wq waiter.Queue
+	ep, err := r.CreateEndpoint(&wq)
+	if err != nil {
+		log.Warnln("Can't create TCP Endpoint(%s -> %s) in ipstack: %v", src, dst, err)
+		r.Complete(true)
+		return
+	}
+	r.Complete(false)
 
-	log.Infoln("Tun adapter have interface name: %s", tundev.Name())
-	return tl, nil
+	conn := gonet.NewTCPConn(&wq, ep)
 
+	// if the endpoint is not in connected state, conn.RemoteAddr() will return nil
+	// this protection may be not enough, but will help us debug the panic
+	if conn.RemoteAddr() == nil {
+		log.Warnln("TCP endpoint is not connected, current state: %v", tcp.EndpointState(ep.State()))
+		conn.Close()
+		return
+	}
+
+	id := ep.Info().(*stack.TransportEndpointInfo).ID
+	if excluded {
+		go t.bypassTCP(id, conn)
+		return
+	}
+	target := getAddr(id)
+	if opts, ok := t.shouldSniff(net.IP(id.LocalAddress.AsSlice()), id.LocalPort); ok {
+		go t.sniffTCP(id, target, newTCPConn(conn, ep), opts.Timeout)
+		return
+	}
+	connCtx := inbound.NewSocket(target, newTCPConn(conn, ep), C.TUN)
+	setSource(connCtx.Metadata(), id)
+	t.setProcess(connCtx.Metadata(), id, processWait)
+	if !t.tcpQueue.offer(connCtx) {
+		// filled up during the handshake
+		log.Dedupln(log.WARNING, "tun-tcp-queue", "[TUN] tcp accept queue is full, connection reset")
+		conn.Close()
+	}
 }
 
 // Close close the TunAdapter
 func (t *tunAdapter) Close() {
-	t.device.Close()
+	device := t.currentDevice()
+	device.Close()
 	if t.dnsserver != nil {
 		t.dnsserver.Stop()
 	}
+	if t.dnsHijack != nil {
+		t.dnsHijack.stop()
+	}
+	t.udpFlows.close()
 	t.ipstack.Close()
+	t.tcpQueue.close()
+	t.udpQueue.close()
+	device.Wait()
+	t.ipstack.Wait()
+}
+
+// Stats return the state of the queues to the tunnel and the counters of the ipstack and the device
+func (t *tunAdapter) Stats() Stats {
+	stats := Stats{
+		TCP:    t.tcpQueue.stats(),
+		UDP:    t.udpQueue.stats(),
+		Stack:  stackStats(t.ipstack),
+		Device: t.currentDevice().Stats(),
+	}
+	stats.Stack.TCPSynDropped = t.synDropped.Load()
+	return stats
+}
+
+// Addressing return the addresses set on the device from its url
+func (t *tunAdapter) Addressing() dev.Addressing {
+	return t.currentDevice().Addressing()
+}
+
+func (t *tunAdapter) Device() (string, int) {
+	return t.currentDevice().Name(), int(t.link.MTU())
 }
 
 // IfName return device URL of tun
 func (t *tunAdapter) DeviceURL() string {
-	return t.device.URL()
+	return t.currentDevice().URL()
 }
 
 func (t *tunAdapter) udpHandlePacket(id stack.TransportEndpointID, pkt *stack.PacketBuffer) bool {
 	// ref: gvisor pkg/tcpip/transport/udp/endpoint.go HandlePacket
 	hdr := header.UDP(pkt.TransportHeader().Slice())
-	if int(hdr.Length()) > pkt.Data().Size()+header.UDPMinimumSize {
+	netHdr := pkt.Network()
+	lengthValid, csumValid := header.UDPValid(
+		hdr,
+		func() uint16 { return pkt.Data().Checksum() },
+		uint16(pkt.Data().Size()),
+		pkt.NetworkProtocolNumber,
+		netHdr.SourceAddress(),
+		netHdr.DestinationAddress(),
+		pkt.RXChecksumValidated)
+	if !lengthValid {
 		// Malformed packet.
 		t.ipstack.Stats().UDP.MalformedPacketsReceived.Increment()
 		return true
 	}
+	if !csumValid {
+		t.ipstack.Stats().UDP.ChecksumErrors.Increment()
+		return true
+	}
+	t.ipstack.Stats().UDP.PacketsReceived.Increment()
 
-	target := getAddr(id)
+	if bypass := t.bypass.Load(); bypass.excludes(id.LocalAddress) {
+		if bypass.opts.Reject {
+			return true
+		}
+		if err := t.udpFlows.get(id, pkt).writeDirect(pkt.Data().AsRange().ToSlice(), id); err != nil {
+			log.Debugln("[TUN] bypass %s --> %s: %v", id.RemoteAddress, id.LocalAddress, err)
+		}
+		return true
+	}
 
 	packet := &fakeConn{
 		id:      id,
-		pkt:     pkt,
-		s:       t.ipstack,
+		session: t.udpFlows.get(id, pkt),
 		payload: pkt.Data().AsRange().ToSlice(),
 	}
-	t.udpInbound <- inbound.NewPacket(target, target.UDPAddr(), packet, C.TUN)
+	if t.opts.trace.copyTTL {
+		if pkt.NetworkProtocolNumber == header.IPv4ProtocolNumber {
+			packet.ttl = t.opts.trace.outboundTTL(header.IPv4(pkt.NetworkHeader().Slice()).TTL(), true)
+		} else {
+			packet.ttl = t.opts.trace.outboundTTL(header.IPv6(pkt.NetworkHeader().Slice()).HopLimit(), false)
+		}
+	}
+	t.udpBatcher.add(packet)
 
 	return true
 }
 
+func (t *tunAdapter) enqueueUDP(packet *fakeConn) {
+	target := getAddr(packet.id)
+	adapter := inbound.NewPacket(target, target.UDPAddr(), packet, C.TUN)
+	adapter.Metadata().TTL = packet.ttl
+	setSource(adapter.Metadata(), packet.id)
+	t.setProcess(adapter.Metadata(), packet.id, 0)
+	if !t.udpQueue.offer(adapter) {
+		log.Dedupln(log.WARNING, "tun-udp-queue", "[TUN] udp queue is full, packet dropped")
+	}
+}
+
+// setSource sets the source of metadata to the client of the flow, the lan device behind the
+// tun, rather than what the address of the conn or packet says
+func setSource(metadata *C.Metadata, id stack.TransportEndpointID) {
+	metadata.SrcIP = net.IP(id.RemoteAddress.AsSlice())
+	metadata.SrcPort = strconv.Itoa(int(id.RemotePort))
+}
+
 func getAddr(id stack.TransportEndpointID) socks5.Addr {
 	local_addr := id.LocalAddress
 
@@ -0,0 +1,223 @@
+package tun
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/listener/tun/dev"
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// memoryProxy is a tun adapter on a memory device, it needs no privilege
+func memoryProxy(t *testing.T) (*dev.MemoryTunDevice, chan C.ConnContext, chan *inbound.PacketAdapter) {
+	tcpIn, udpIn := make(chan C.ConnContext, 1), make(chan *inbound.PacketAdapter, 1)
+	adapter, err := NewTunProxy("mem://?mtu=1500", tcpIn, udpIn)
+	require.NoError(t, err)
+	t.Cleanup(adapter.Close)
+	return adapter.(*tunAdapter).currentDevice().(*dev.MemoryTunDevice), tcpIn, udpIn
+}
+
+func tcpipAddr(addr netip.Addr) tcpip.Address {
+	return tcpip.AddrFromSlice(addr.AsSlice())
+}
+
+// memoryPacket wraps a tcp or udp header and payload in an ipv4 or ipv6 header of the
+// family of src, the checksums are filled
+func memoryPacket(src, dst netip.AddrPort, protocol tcpip.TransportProtocolNumber, transport []byte) []byte {
+	srcAddr, dstAddr := tcpipAddr(src.Addr()), tcpipAddr(dst.Addr())
+	xsum := header.PseudoHeaderChecksum(protocol, srcAddr, dstAddr, uint16(len(transport)))
+	switch protocol {
+	case header.TCPProtocolNumber:
+		tcp := header.TCP(transport)
+		tcp.SetChecksum(^tcp.CalculateChecksum(checksum.Checksum(tcp.Payload(), xsum)))
+	case header.UDPProtocolNumber:
+		udp := header.UDP(transport)
+		udp.SetChecksum(^udp.CalculateChecksum(checksum.Checksum(udp.Payload(), xsum)))
+	}
+
+	if src.Addr().Is4() {
+		ip := header.IPv4(make([]byte, header.IPv4MinimumSize))
+		ip.Encode(&header.IPv4Fields{
+			TotalLength: uint16(header.IPv4MinimumSize + len(transport)),
+			TTL:         64,
+			Protocol:    uint8(protocol),
+			SrcAddr:     srcAddr,
+			DstAddr:     dstAddr,
+		})
+		ip.SetChecksum(^ip.CalculateChecksum())
+		return append(ip, transport...)
+	}
+	ip := header.IPv6(make([]byte, header.IPv6MinimumSize))
+	ip.Encode(&header.IPv6Fields{
+		PayloadLength:     uint16(len(transport)),
+		TransportProtocol: protocol,
+		HopLimit:          64,
+		SrcAddr:           srcAddr,
+		DstAddr:           dstAddr,
+	})
+	return append(ip, transport...)
+}
+
+func tcpSegment(src, dst netip.AddrPort, seq, ack uint32, flags header.TCPFlags) []byte {
+	tcp := header.TCP(make([]byte, header.TCPMinimumSize))
+	tcp.Encode(&header.TCPFields{
+		SrcPort:    src.Port(),
+		DstPort:    dst.Port(),
+		SeqNum:     seq,
+		AckNum:     ack,
+		DataOffset: header.TCPMinimumSize,
+		Flags:      flags,
+		WindowSize: 65535,
+	})
+	return memoryPacket(src, dst, header.TCPProtocolNumber, tcp)
+}
+
+func udpDatagram(src, dst netip.AddrPort, payload string) []byte {
+	udp := header.UDP(make([]byte, header.UDPMinimumSize+len(payload)))
+	udp.Encode(&header.UDPFields{SrcPort: src.Port(), DstPort: dst.Port(), Length: uint16(len(udp))})
+	copy(udp.Payload(), payload)
+	return memoryPacket(src, dst, header.UDPProtocolNumber, udp)
+}
+
+// nextTransport reads the next packet of the protocol out of the device, the others, like
+// the icmpv6 of the ipstack, are skipped. The checksums of the packet are checked and its
+// transport header and payload returned along with its addresses
+func nextTransport(t *testing.T, device *dev.MemoryTunDevice, protocol tcpip.TransportProtocolNumber) (netip.Addr, netip.Addr, []byte) {
+	timeout := time.After(5 * time.Second)
+	for {
+		var packet []byte
+		select {
+		case packet = <-device.Output():
+		case <-timeout:
+			require.FailNow(t, "no packet out of the device")
+		}
+
+		var src, dst tcpip.Address
+		var transport []byte
+		switch header.IPVersion(packet) {
+		case header.IPv4Version:
+			ip := header.IPv4(packet)
+			require.True(t, ip.IsValid(len(packet)))
+			assert.True(t, ip.IsChecksumValid(), "ipv4 checksum")
+			if ip.TransportProtocol() != protocol {
+				continue
+			}
+			src, dst, transport = ip.SourceAddress(), ip.DestinationAddress(), ip.Payload()
+		case header.IPv6Version:
+			ip := header.IPv6(packet)
+			require.True(t, ip.IsValid(len(packet)))
+			if ip.TransportProtocol() != protocol {
+				continue
+			}
+			src, dst, transport = ip.SourceAddress(), ip.DestinationAddress(), ip.Payload()
+		default:
+			require.FailNow(t, "not an ip packet out of the device")
+		}
+
+		xsum := header.PseudoHeaderChecksum(protocol, src, dst, uint16(len(transport)))
+		assert.Equal(t, uint16(0xffff), checksum.Checksum(transport, xsum), "transport checksum")
+		srcAddr, _ := netip.AddrFromSlice(src.AsSlice())
+		dstAddr, _ := netip.AddrFromSlice(dst.AsSlice())
+		return srcAddr, dstAddr, transport
+	}
+}
+
+// TestTunProxy_MemoryTCP does the handshake with the forwarder through a memory device, the
+// connection reaches tcpIn with the metadata of the syn and the data written to it comes
+// back out of the device
+func TestTunProxy_MemoryTCP(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		src, dst netip.AddrPort
+		atyp     int
+	}{
+		{"ipv4", netip.MustParseAddrPort("198.18.0.2:40000"), netip.MustParseAddrPort("1.2.3.4:443"), socks5.AtypIPv4},
+		{"ipv6", netip.MustParseAddrPort("[fd00::2]:40000"), netip.MustParseAddrPort("[2001:db8::1]:443"), socks5.AtypIPv6},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			device, tcpIn, _ := memoryProxy(t)
+
+			require.NoError(t, device.Inject(tcpSegment(tt.src, tt.dst, 1000, 0, header.TCPFlagSyn)))
+			src, dst, transport := nextTransport(t, device, header.TCPProtocolNumber)
+			assert.Equal(t, tt.dst.Addr(), src)
+			assert.Equal(t, tt.src.Addr(), dst)
+			synAck := header.TCP(transport)
+			assert.Equal(t, header.TCPFlagSyn|header.TCPFlagAck, synAck.Flags())
+			assert.Equal(t, tt.dst.Port(), synAck.SourcePort())
+			assert.Equal(t, tt.src.Port(), synAck.DestinationPort())
+			assert.Equal(t, uint32(1001), synAck.AckNumber())
+
+			require.NoError(t, device.Inject(tcpSegment(tt.src, tt.dst, 1001, synAck.SequenceNumber()+1, header.TCPFlagAck)))
+			var conn C.ConnContext
+			select {
+			case conn = <-tcpIn:
+			case <-time.After(5 * time.Second):
+				require.FailNow(t, "no tcp connection from the memory device")
+			}
+			defer conn.Conn().Close()
+			metadata := conn.Metadata()
+			assert.Equal(t, C.TCP, metadata.NetWork)
+			assert.Equal(t, C.TUN, metadata.Type)
+			assert.Equal(t, tt.atyp, metadata.AddrType())
+			assert.Equal(t, tt.dst.String(), metadata.RemoteAddress())
+			assert.Equal(t, tt.src.String(), metadata.SourceAddress())
+
+			_, err := conn.Conn().Write([]byte("hello"))
+			require.NoError(t, err)
+			_, _, transport = nextTransport(t, device, header.TCPProtocolNumber)
+			assert.Equal(t, []byte("hello"), header.TCP(transport).Payload())
+		})
+	}
+}
+
+// TestTunProxy_MemoryUDP sends a datagram through a memory device, it reaches udpIn with
+// the metadata of its addresses and the reply written back comes out of the device
+func TestTunProxy_MemoryUDP(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		src, dst netip.AddrPort
+		atyp     int
+	}{
+		{"ipv4", netip.MustParseAddrPort("198.18.0.2:5000"), netip.MustParseAddrPort("8.8.8.8:53"), socks5.AtypIPv4},
+		{"ipv6", netip.MustParseAddrPort("[fd00::2]:5000"), netip.MustParseAddrPort("[2001:4860:4860::8888]:53"), socks5.AtypIPv6},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			device, _, udpIn := memoryProxy(t)
+
+			require.NoError(t, device.Inject(udpDatagram(tt.src, tt.dst, "query")))
+			var packet *inbound.PacketAdapter
+			select {
+			case packet = <-udpIn:
+			case <-time.After(5 * time.Second):
+				require.FailNow(t, "no udp packet from the memory device")
+			}
+			defer packet.Drop()
+			metadata := packet.Metadata()
+			assert.Equal(t, C.UDP, metadata.NetWork)
+			assert.Equal(t, C.TUN, metadata.Type)
+			assert.Equal(t, tt.atyp, metadata.AddrType())
+			assert.Equal(t, tt.dst.String(), metadata.RemoteAddress())
+			assert.Equal(t, tt.src.String(), metadata.SourceAddress())
+			assert.Equal(t, []byte("query"), packet.Data())
+
+			_, err := packet.WriteBack([]byte("answer"), metadata.UDPAddr())
+			require.NoError(t, err)
+			src, dst, transport := nextTransport(t, device, header.UDPProtocolNumber)
+			assert.Equal(t, tt.dst.Addr(), src)
+			assert.Equal(t, tt.src.Addr(), dst)
+			reply := header.UDP(transport)
+			assert.Equal(t, tt.dst.Port(), reply.SourcePort())
+			assert.Equal(t, tt.src.Port(), reply.DestinationPort())
+			assert.Equal(t, []byte("answer"), reply.Payload())
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package tun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/common/cache"
+	"github.com/Dreamacro/clash/common/pool"
+	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/log"
+
+	"go.uber.org/atomic"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	defaultUDPSessionTimeout = 60 * time.Second
+	defaultUDPMaxSessions    = 16384
+)
+
+var errUDPSessionClosed = errors.New("tun udp session closed")
+
+// udpSessionOptions are from the query of the device url, udp-timeout=SECONDS&udp-max-sessions=N
+type udpSessionOptions struct {
+	timeout     time.Duration
+	maxSessions int
+}
+
+func parseUDPSessionOptions(query url.Values) (opts udpSessionOptions, err error) {
+	opts.timeout, opts.maxSessions = defaultUDPSessionTimeout, defaultUDPMaxSessions
+
+	if value := query.Get("udp-timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 {
+			return opts, fmt.Errorf("udp-timeout %s: expect a number of seconds over 0", value)
+		}
+		opts.timeout = time.Duration(seconds) * time.Second
+	}
+	if value := query.Get("udp-max-sessions"); value != "" {
+		if opts.maxSessions, err = strconv.Atoi(value); err != nil || opts.maxSessions < 1 {
+			return opts, fmt.Errorf("udp-max-sessions %s: expect a number over 0", value)
+		}
+	}
+	return opts, nil
+}
+
+// udpSource is the internal socket a udp flow is sent from, the key of its session
+type udpSource struct {
+	addr tcpip.Address
+	port uint16
+}
+
+// udpSession is the return path of an internal udp socket of the tun. It's endpoint
+// independent: the flows of the socket to any destination share it, as they share the
+// association of the tunnel keyed by the source, and a reply from any remote address is
+// written back to the socket from that address
+type udpSession struct {
+	// id is of the first flow of the socket, its destination is the one without an address
+	id     stack.TransportEndpointID
+	source udpSource
+	nicID  tcpip.NICID
+	proto  tcpip.NetworkProtocolNumber
+	table  *udpSessions
+
+	// route from the first destination, nil once the session is evicted
+	mux      sync.RWMutex
+	route    *stack.Route
+	released bool
+	// the socket of the flows to the excluded destinations, it lives as long as the session
+	direct net.PacketConn
+}
+
+// udpSessions are the udp sockets of the tun by their source, a socket idle for the timeout
+// or the least recently used one over the limit is evicted
+type udpSessions struct {
+	stack  *stack.Stack
+	mux    sync.Mutex
+	cache  *cache.LruCache
+	closed atomic.Bool
+}
+
+func newUDPSessions(s *stack.Stack, opts udpSessionOptions) *udpSessions {
+	t := &udpSessions{stack: s}
+	t.cache = cache.New(
+		cache.WithSize(opts.maxSessions),
+		cache.WithAge(int64(opts.timeout/time.Second)),
+		cache.WithUpdateAgeOnGet(),
+		cache.WithEvict(func(_ any, value any) {
+			value.(*udpSession).release()
+		}),
+	)
+	return t
+}
+
+// get return the session of the source of the packet, creating it for a new socket
+func (t *udpSessions) get(id stack.TransportEndpointID, pkt stack.PacketBufferPtr) *udpSession {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	source := udpSource{addr: id.RemoteAddress, port: id.RemotePort}
+	if value, ok := t.cache.Get(source); ok {
+		return value.(*udpSession)
+	}
+
+	s := &udpSession{
+		id:     id,
+		source: source,
+		nicID:  pkt.NICID,
+		proto:  pkt.NetworkProtocolNumber,
+		table:  t,
+	}
+	if !t.closed.Load() {
+		// the replies from the first destination, the common case, use this route
+		if r, err := t.stack.FindRoute(s.nicID, id.LocalAddress, id.RemoteAddress, s.proto, false /* multicastLoop */); err == nil {
+			s.route = r
+		}
+		t.cache.Set(source, s)
+	}
+	return s
+}
+
+// close evicts the sessions, their packets still in the tunnel can't write any more
+func (t *udpSessions) close() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.closed.Store(true)
+	t.cache.Purge()
+}
+
+// evict drops the session from the table if it's still the one of its source, the next
+// packet of the source starts a new one
+func (s *udpSession) evict() {
+	s.table.mux.Lock()
+	defer s.table.mux.Unlock()
+
+	if value, _, ok := s.table.cache.PeekWithExpire(s.source); ok && value == s {
+		s.table.cache.Delete(s.source)
+	}
+}
+
+func (s *udpSession) release() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.released = true
+	if s.route != nil {
+		s.route.Release()
+		s.route = nil
+	}
+	if s.direct != nil {
+		s.direct.Close()
+	}
+}
+
+// writeDirect sends b to the excluded destination dst from the socket of the session on the
+// outbound interface, the replies from any address are written back to the tun
+func (s *udpSession) writeDirect(b []byte, dst stack.TransportEndpointID) error {
+	s.mux.Lock()
+	if s.released || s.table.closed.Load() {
+		s.mux.Unlock()
+		return errUDPSessionClosed
+	}
+	direct := s.direct
+	if direct == nil {
+		pc, err := dialer.ListenPacket(context.Background(), "udp", "")
+		if err != nil {
+			s.mux.Unlock()
+			return err
+		}
+		s.direct, direct = pc, pc
+		go s.readDirect(pc)
+	}
+	s.mux.Unlock()
+
+	_, err := direct.WriteTo(b, &net.UDPAddr{IP: net.IP(dst.LocalAddress.AsSlice()), Port: int(dst.LocalPort)})
+	return err
+}
+
+// readDirect writes the replies to the socket of writeDirect back until the session closes it
+func (s *udpSession) readDirect(pc net.PacketConn) {
+	buf := pool.Get(pool.UDPBufferSize)
+	defer pool.Put(buf)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if _, err := s.writeBack(buf[:n], from); err != nil {
+			log.Debugln("[TUN] bypass reply from %s: %v", from, err)
+		}
+	}
+}
+
+// writeBack sends b to the source of the session from addr, from the first destination when
+// addr is nil. The replies of a fake ip are from it already, rewritten by the tunnel
+func (s *udpSession) writeBack(b []byte, addr net.Addr) (int, error) {
+	localAddress, localPort := s.id.LocalAddress, s.id.LocalPort
+	if addr != nil {
+		udpaddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			return 0, fmt.Errorf("write back from %s: not a udp address", addr)
+		}
+		localAddress = tcpip.AddrFromSlice(udpaddr.IP)
+		// We may get 4-in-6 IP here, but should not use 16 bytes IP in FindRoute
+		if s.proto == header.IPv4ProtocolNumber {
+			localAddress = localAddress.To4()
+		}
+		localPort = uint16(udpaddr.Port)
+	}
+
+	// the replies keep the session too, it's touched before the session lock as the
+	// eviction takes that lock with the one of the cache held
+	s.table.cache.Get(s.source)
+
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	// closed before the sessions are released, the stack may be gone after that
+	if s.table.closed.Load() {
+		return 0, errUDPSessionClosed
+	}
+
+	data := buffer.NewViewWithData(b)
+	if s.route != nil && localAddress == s.id.LocalAddress {
+		return writeUDP(s.route, data, localPort, s.id.RemotePort)
+	}
+
+	// another remote address, or an evicted session still used by the tunnel
+	r, err := s.table.stack.FindRoute(s.nicID, localAddress, s.id.RemoteAddress, s.proto, false /* multicastLoop */)
+	if err != nil {
+		return 0, fmt.Errorf("write back to %s: %s", s.id.RemoteAddress, err)
+	}
+	defer r.Release()
+	return writeUDP(r, data, localPort, s.id.RemotePort)
+}
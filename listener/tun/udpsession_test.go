@@ -0,0 +1,233 @@
+package tun
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+// discardNotify drops what the ipstack writes to the tun, counting it
+type discardNotify struct {
+	ep      *channel.Endpoint
+	packets int
+}
+
+func (d *discardNotify) WriteNotify() {
+	pkt := d.ep.Read()
+	pkt.DecRef()
+	d.packets++
+}
+
+func udpStack(tb testing.TB) (*stack.Stack, *channel.Endpoint) {
+	linkEP := channel.New(16, 1500, "")
+	ipstack := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+	})
+	require.Nil(tb, ipstack.CreateNIC(nicID, linkEP))
+	ipstack.SetPromiscuousMode(nicID, true)
+	ipstack.SetSpoofing(nicID, true)
+	ipstack.AddRoute(tcpip.Route{Destination: header.IPv4EmptySubnet, NIC: nicID})
+	return ipstack, linkEP
+}
+
+func udpFlow(port uint16) (stack.TransportEndpointID, stack.PacketBufferPtr) {
+	id := stack.TransportEndpointID{
+		LocalAddress:  tcpip.AddrFrom4([4]byte{1, 1, 1, 1}),
+		LocalPort:     53,
+		RemoteAddress: tcpip.AddrFrom4([4]byte{198, 18, 0, 1}),
+		RemotePort:    port,
+	}
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData([]byte("q"))})
+	pkt.NICID = nicID
+	pkt.NetworkProtocolNumber = header.IPv4ProtocolNumber
+	return id, pkt
+}
+
+func TestUDPSessions(t *testing.T) {
+	ipstack, linkEP := udpStack(t)
+	defer ipstack.Close()
+	sessions := newUDPSessions(ipstack, udpSessionOptions{timeout: time.Minute, maxSessions: 2})
+
+	id, pkt := udpFlow(5000)
+	session := sessions.get(id, pkt)
+	assert.Same(t, session, sessions.get(id, pkt))
+	require.NotNil(t, session.route)
+
+	_, err := session.writeBack([]byte("answer"), nil)
+	require.NoError(t, err)
+	reply := linkEP.Read()
+	ip := header.IPv4(reply.ToView().AsSlice())
+	reply.DecRef()
+	assert.Equal(t, id.LocalAddress, ip.SourceAddress())
+	assert.Equal(t, id.RemoteAddress, ip.DestinationAddress())
+	assert.Equal(t, uint16(5000), header.UDP(ip.Payload()).DestinationPort())
+
+	// the least recently used session goes over the limit, it can still write for the tunnel
+	for port := uint16(5001); port <= 5002; port++ {
+		id, pkt := udpFlow(port)
+		sessions.get(id, pkt)
+	}
+	assert.Nil(t, session.route)
+	_, err = session.writeBack([]byte("late"), &net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 53})
+	require.NoError(t, err)
+	reply = linkEP.Read()
+	assert.Equal(t, tcpip.AddrFrom4([4]byte{8, 8, 8, 8}), header.IPv4(reply.ToView().AsSlice()).SourceAddress())
+	reply.DecRef()
+
+	// a closed adapter, the stack is gone too
+	sessions.close()
+	ipstack.Close()
+	_, err = session.writeBack([]byte("stale"), nil)
+	assert.ErrorIs(t, err, errUDPSessionClosed)
+	id, pkt = udpFlow(6000)
+	_, err = sessions.get(id, pkt).writeBack([]byte("stale"), nil)
+	assert.ErrorIs(t, err, errUDPSessionClosed)
+}
+
+// TestUDPSessions_EndpointIndependent runs a stun binding exchange of one internal socket with
+// two servers, then a reply comes from a third address it never sent to
+func TestUDPSessions_EndpointIndependent(t *testing.T) {
+	ipstack, linkEP := udpStack(t)
+	defer ipstack.Close()
+	sessions := newUDPSessions(ipstack, udpSessionOptions{timeout: time.Minute, maxSessions: 16})
+
+	// the same mapping for both destinations
+	idA, pkt := udpFlow(5000)
+	idA.LocalAddress, idA.LocalPort = addr("1.1.1.1"), 3478
+	idB := idA
+	idB.LocalAddress = addr("2.2.2.2")
+	session := sessions.get(idA, pkt)
+	assert.Same(t, session, sessions.get(idB, pkt))
+
+	readReply := func() header.IPv4 {
+		reply := linkEP.Read()
+		require.NotNil(t, reply)
+		defer reply.DecRef()
+		return header.IPv4(reply.ToView().ToSlice())
+	}
+	for _, from := range []*net.UDPAddr{
+		{IP: net.IPv4(1, 1, 1, 1), Port: 3478},
+		{IP: net.IPv4(2, 2, 2, 2), Port: 3478},
+		// the mapping filters no remote address or port
+		{IP: net.IPv4(3, 3, 3, 3), Port: 3479},
+	} {
+		packet := &fakeConn{id: idB, session: session}
+		_, err := packet.WriteBack([]byte("binding success"), from)
+		require.NoError(t, err)
+		ip := readReply()
+		assert.Equal(t, tcpip.AddrFromSlice(from.IP.To4()), ip.SourceAddress())
+		assert.Equal(t, idA.RemoteAddress, ip.DestinationAddress())
+		assert.Equal(t, uint16(from.Port), header.UDP(ip.Payload()).SourcePort())
+		assert.Equal(t, uint16(5000), header.UDP(ip.Payload()).DestinationPort())
+	}
+
+	// without an address, the reply is from the destination of the packet
+	_, err := (&fakeConn{id: idB, session: session}).WriteBack([]byte("answer"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, idB.LocalAddress, readReply().SourceAddress())
+
+	// another port of the same host is another mapping
+	idC, pkt := udpFlow(5001)
+	assert.NotSame(t, session, sessions.get(idC, pkt))
+}
+
+func TestUDPSessions_IdleTimeout(t *testing.T) {
+	ipstack, _ := udpStack(t)
+	defer ipstack.Close()
+	sessions := newUDPSessions(ipstack, udpSessionOptions{timeout: time.Second, maxSessions: 16})
+
+	id, pkt := udpFlow(5000)
+	session := sessions.get(id, pkt)
+	require.NotNil(t, session.route)
+	time.Sleep(2100 * time.Millisecond)
+
+	// idle over the timeout, the next packet of the socket starts another session
+	assert.NotSame(t, session, sessions.get(id, pkt))
+	assert.Nil(t, session.route)
+}
+
+func TestUDPSessions_Evict(t *testing.T) {
+	ipstack, _ := udpStack(t)
+	defer ipstack.Close()
+	sessions := newUDPSessions(ipstack, udpSessionOptions{timeout: time.Minute, maxSessions: 16})
+
+	id, pkt := udpFlow(5000)
+	session := sessions.get(id, pkt)
+	(&fakeConn{id: id, session: session}).Evict()
+	assert.Nil(t, session.route)
+
+	// the evicted session of a late tracker doesn't drop the one after it
+	next := sessions.get(id, pkt)
+	assert.NotSame(t, session, next)
+	session.evict()
+	assert.Same(t, next, sessions.get(id, pkt))
+	assert.NotNil(t, next.route)
+}
+
+func TestParseUDPSessionOptions(t *testing.T) {
+	opts, err := parseUDPSessionOptions(url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, udpSessionOptions{timeout: 60 * time.Second, maxSessions: 16384}, opts)
+
+	query, _ := url.ParseQuery("udp-timeout=300&udp-max-sessions=100")
+	opts, err = parseUDPSessionOptions(query)
+	require.NoError(t, err)
+	assert.Equal(t, udpSessionOptions{timeout: 300 * time.Second, maxSessions: 100}, opts)
+
+	for _, raw := range []string{"udp-timeout=0", "udp-timeout=1m", "udp-max-sessions=-1"} {
+		query, _ := url.ParseQuery(raw)
+		_, err := parseUDPSessionOptions(query)
+		assert.Error(t, err, raw)
+	}
+}
+
+// BenchmarkUDPReply compares the replies of a flow finding their route per packet, as done
+// before the session table, and the ones through the session of the flow
+func BenchmarkUDPReply(b *testing.B) {
+	payload := make([]byte, 1200)
+
+	b.Run("route-per-packet", func(b *testing.B) {
+		ipstack, linkEP := udpStack(b)
+		defer ipstack.Close()
+		linkEP.AddNotify(&discardNotify{ep: linkEP})
+		id, pkt := udpFlow(5000)
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r, err := ipstack.FindRoute(pkt.NICID, id.LocalAddress, id.RemoteAddress, pkt.NetworkProtocolNumber, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+			writeUDP(r, buffer.NewViewWithData(payload), id.LocalPort, id.RemotePort)
+			r.Release()
+		}
+	})
+
+	b.Run("session", func(b *testing.B) {
+		ipstack, linkEP := udpStack(b)
+		defer ipstack.Close()
+		linkEP.AddNotify(&discardNotify{ep: linkEP})
+		sessions := newUDPSessions(ipstack, udpSessionOptions{timeout: time.Minute, maxSessions: 16})
+		id, pkt := udpFlow(5000)
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			session := sessions.get(id, pkt)
+			if _, err := session.writeBack(payload, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
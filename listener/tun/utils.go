@@ -5,8 +5,8 @@ import (
 	"net"
 
 	"github.com/Dreamacro/clash/component/resolver"
+
 	"gvisor.dev/gvisor/pkg/buffer"
-	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/checksum"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
@@ -15,37 +15,28 @@ import (
 
 type fakeConn struct {
 	id      stack.TransportEndpointID // The endpoint of incoming packet, it's remote address is the source address it sent from
-	pkt     *stack.PacketBuffer       // The original packet coming from tun
-	s       *stack.Stack
+	session *udpSession
 	payload []byte
-	fakeip  *bool
+	batch   [][]byte // payloads coalesced after payload
+	ttl     uint8    // the ttl for DIRECT with trace-ttl, 0 leaves it to the system
 }
 
 func (c *fakeConn) Data() []byte {
 	return c.payload
 }
 
+// Batch implements C.UDPPacketBatch
+func (c *fakeConn) Batch() [][]byte {
+	return c.batch
+}
+
+// WriteBack writes from addr, or from the original dst Addr if addr is not provided. The
+// session is of the source, addr may be any remote address
 func (c *fakeConn) WriteBack(b []byte, addr net.Addr) (n int, err error) {
-	data := buffer.NewViewWithData(b)
-
-	var localAddress tcpip.Address
-	var localPort uint16
-	// if addr is not provided, write back use original dst Addr as src Addr
-	if c.FakeIP() || addr == nil {
-		localAddress = c.id.LocalAddress
-		localPort = c.id.LocalPort
-	} else {
-		udpaddr, _ := addr.(*net.UDPAddr)
-		localAddress = tcpip.AddrFromSlice(udpaddr.IP)
-		// We may get 4-in-6 IP here, but should not use 16 bytes IP in FindRoute
-		if c.pkt.NetworkProtocolNumber == header.IPv4ProtocolNumber {
-			localAddress = localAddress.To4()
-		}
-		localPort = uint16(udpaddr.Port)
+	if addr == nil {
+		addr = &net.UDPAddr{IP: net.IP(c.id.LocalAddress.AsSlice()), Port: int(c.id.LocalPort)}
 	}
-
-	r, _ := c.s.FindRoute(c.pkt.NICID, localAddress, c.id.RemoteAddress, c.pkt.NetworkProtocolNumber, false /* multicastLoop */)
-	return writeUDP(r, data, localPort, c.id.RemotePort)
+	return c.session.writeBack(b, addr)
 }
 
 func (c *fakeConn) LocalAddr() net.Addr {
@@ -60,13 +51,13 @@ func (c *fakeConn) Drop() {
 
 }
 
+// Evict implements C.TunFlow
+func (c *fakeConn) Evict() {
+	c.session.evict()
+}
+
 func (c *fakeConn) FakeIP() bool {
-	if c.fakeip != nil {
-		return *c.fakeip
-	}
-	fakeip := resolver.IsFakeIP(net.IP(c.id.LocalAddress.AsSlice()))
-	c.fakeip = &fakeip
-	return fakeip
+	return resolver.IsFakeIP(net.IP(c.id.LocalAddress.AsSlice()))
 }
 
 func writeUDP(r *stack.Route, data *buffer.View, localPort, remotePort uint16) (int, error) {
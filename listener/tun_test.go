@@ -0,0 +1,43 @@
+package listener
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Dreamacro/clash/adapter/inbound"
+	"github.com/Dreamacro/clash/config"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReCreateTun turns a tun device off and on again with the same name, the fd of the
+// closed one is released by then, it needs CAP_NET_ADMIN
+func TestReCreateTun(t *testing.T) {
+	tcpIn, udpIn := make(chan C.ConnContext), make(chan *inbound.PacketAdapter)
+	conf := config.Tun{Enable: true, DeviceURL: "dev://clashrecreate"}
+	ReCreateTun(conf, tcpIn, udpIn)
+	if TunError() != nil {
+		t.Skipf("open tun: %s", TunError())
+	}
+	defer ReCreateTun(config.Tun{}, tcpIn, udpIn)
+	assert.True(t, Tun().Running)
+
+	conf.Enable = false
+	ReCreateTun(conf, tcpIn, udpIn)
+	require.NoError(t, TunError())
+	tun := Tun()
+	assert.False(t, tun.Running)
+	assert.False(t, tun.Enable)
+	assert.Equal(t, "dev://clashrecreate", tun.DeviceURL)
+	// the interface goes with the last fd
+	_, err := net.InterfaceByName("clashrecreate")
+	assert.Error(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, SetTunEnable(true, tcpIn, udpIn))
+		assert.True(t, Tun().Running)
+		require.NoError(t, SetTunEnable(false, tcpIn, udpIn))
+	}
+}
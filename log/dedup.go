@@ -0,0 +1,88 @@
+package log
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// DefaultDedupWindow is how long repeats of a message are collapsed by default
+const DefaultDedupWindow = 10 * time.Second
+
+type repeated struct {
+	level  LogLevel
+	format string
+	args   []any
+	count  int
+}
+
+var (
+	dedupWindow = atomic.NewDuration(DefaultDedupWindow)
+
+	dedupMux     sync.Mutex
+	dedupPending = map[string]*repeated{}
+)
+
+// SetDedup sets the window of Dedupln, zero or less logs every message
+func SetDedup(window time.Duration) {
+	dedupWindow.Store(window)
+}
+
+// Dedupln logs the message like Warnln and friends for its level, but the messages of the
+// same level, format and key within a window are collapsed into one line with the count of
+// the repeats, which is logged when the window ends. key holds the args telling messages apart,
+// the line of the repeats shows the args of the last one
+func Dedupln(logLevel LogLevel, key string, format string, v ...any) {
+	window := dedupWindow.Load()
+	if window <= 0 {
+		emit(newLog(logLevel, format, v...))
+		return
+	}
+
+	k := logLevel.String() + "\x00" + format + "\x00" + key
+	dedupMux.Lock()
+	if r, ok := dedupPending[k]; ok {
+		r.count++
+		r.args = v
+		dedupMux.Unlock()
+		return
+	}
+	r := &repeated{level: logLevel, format: format}
+	dedupPending[k] = r
+	dedupMux.Unlock()
+
+	emit(newLog(logLevel, format, v...))
+	time.AfterFunc(window, func() {
+		flushRepeated(k, r, window)
+	})
+}
+
+// flushRepeated logs the repeats of the window, a message still repeating keeps its entry
+// so that a flood costs a line per window
+func flushRepeated(k string, r *repeated, window time.Duration) {
+	dedupMux.Lock()
+	if r.count == 0 {
+		if dedupPending[k] == r {
+			delete(dedupPending, k)
+		}
+		dedupMux.Unlock()
+		return
+	}
+	count, args := r.count, r.args
+	r.count, r.args = 0, nil
+	dedupMux.Unlock()
+
+	event := newLog(r.level, r.format, args...)
+	event.Payload += fmt.Sprintf(" (repeated %d times)", count)
+	emit(event)
+	time.AfterFunc(window, func() {
+		flushRepeated(k, r, window)
+	})
+}
+
+func emit(event Event) {
+	logCh <- event
+	print(event)
+}
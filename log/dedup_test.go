@@ -0,0 +1,53 @@
+package log
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupln(t *testing.T) {
+	SetDedup(50 * time.Millisecond)
+	defer SetDedup(DefaultDedupWindow)
+	dedupMux.Lock()
+	dedupPending = map[string]*repeated{}
+	dedupMux.Unlock()
+
+	sub := Subscribe()
+	defer UnSubscribe(sub)
+
+	next := func() string {
+		select {
+		case item := <-sub:
+			return item.(Event).Payload
+		case <-time.After(time.Second):
+			t.Fatal("no log")
+			return ""
+		}
+	}
+
+	for port := 1000; port < 1005; port++ {
+		Dedupln(WARNING, "proxy timeout", "dial proxy 127.0.0.1:%d error: %s", port, "timeout")
+	}
+	Dedupln(WARNING, "proxy refused", "dial proxy 127.0.0.1:%d error: %s", 2000, "refused")
+
+	assert.Equal(t, "dial proxy 127.0.0.1:1000 error: timeout", next())
+	assert.Equal(t, "dial proxy 127.0.0.1:2000 error: refused", next())
+	// the repeats are collapsed with the args of the last one
+	assert.Equal(t, "dial proxy 127.0.0.1:1004 error: timeout (repeated 4 times)", next())
+
+	select {
+	case item := <-sub:
+		t.Fatalf("unexpected log %v", item)
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	// the window is over, the next one is logged right away
+	Dedupln(WARNING, "proxy timeout", "dial proxy 127.0.0.1:%d error: %s", 1005, "timeout")
+	assert.Equal(t, "dial proxy 127.0.0.1:1005 error: timeout", next())
+
+	SetDedup(0)
+	Dedupln(WARNING, "proxy timeout", "dial proxy 127.0.0.1:%d error: %s", 1006, "timeout")
+	assert.Equal(t, "dial proxy 127.0.0.1:1006 error: timeout", next())
+}
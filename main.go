@@ -3,17 +3,23 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"syscall"
 
+	"github.com/Dreamacro/clash/cmd/convert"
+	"github.com/Dreamacro/clash/component/daemon"
 	"github.com/Dreamacro/clash/config"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/hub"
 	"github.com/Dreamacro/clash/hub/executor"
+	"github.com/Dreamacro/clash/listener"
 	"github.com/Dreamacro/clash/log"
+	"github.com/Dreamacro/clash/tunnel"
+	"github.com/Dreamacro/clash/tunnel/statistic"
 
 	"go.uber.org/automaxprocs/maxprocs"
 )
@@ -31,7 +37,7 @@ var (
 
 func init() {
 	flag.StringVar(&homeDir, "d", "", "set configuration directory")
-	flag.StringVar(&configFile, "f", "", "specify configuration file")
+	flag.StringVar(&configFile, "f", "", "specify configuration file, - reads it from stdin")
 	flag.StringVar(&externalUI, "ext-ui", "", "override external ui directory")
 	flag.StringVar(&externalController, "ext-ctl", "", "override external controller address")
 	flag.StringVar(&secret, "secret", "", "override secret for RESTful API")
@@ -47,11 +53,59 @@ func init() {
 
 func main() {
 	maxprocs.Set(maxprocs.Logger(func(string, ...any) {}))
+	if flag.Arg(0) == "convert" {
+		os.Exit(convert.Main(flag.Args()[1:]))
+	}
+
 	if version {
 		fmt.Printf("Clash %s %s %s with %s %s\n", C.Version, runtime.GOOS, runtime.GOARCH, runtime.Version(), C.BuildTime)
 		return
 	}
 
+	setPaths()
+
+	if flag.Arg(0) == "service" {
+		os.Exit(serviceMain(flag.Args()[1:]))
+	}
+
+	if configFile == config.StdinPath {
+		buf, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalln("Read configuration from stdin error: %s", err.Error())
+		}
+		config.SetStdin(buf)
+	}
+
+	if testConfig {
+		if err := config.Init(C.Path.HomeDir()); err != nil {
+			log.Fatalln("Initial configuration directory error: %s", err.Error())
+		}
+		if _, err := executor.Parse(); err != nil {
+			log.Errorln(err.Error())
+			fmt.Printf("configuration file %s test failed\n", C.Path.Config())
+			os.Exit(1)
+		}
+		fmt.Printf("configuration file %s test is successful\n", C.Path.Config())
+		return
+	}
+
+	if err := start(); err != nil {
+		daemon.Failed(err)
+		log.Fatalln("%s", err.Error())
+	}
+	daemon.Ready()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+	daemon.Stopping()
+	listener.CloseTun()
+
+	// write the queued access log entries
+	statistic.UpdateAccessLog(nil)
+}
+
+func setPaths() {
 	if homeDir != "" {
 		if !filepath.IsAbs(homeDir) {
 			currentDir, _ := os.Getwd()
@@ -60,7 +114,9 @@ func main() {
 		C.SetHomeDir(homeDir)
 	}
 
-	if configFile != "" {
+	if configFile == config.StdinPath {
+		C.SetConfig(configFile)
+	} else if configFile != "" {
 		if !filepath.IsAbs(configFile) {
 			currentDir, _ := os.Getwd()
 			configFile = filepath.Join(currentDir, configFile)
@@ -70,19 +126,12 @@ func main() {
 		configFile := filepath.Join(C.Path.HomeDir(), C.Path.Config())
 		C.SetConfig(configFile)
 	}
+}
 
+// start applies the config, it returns once the listeners are bound
+func start() error {
 	if err := config.Init(C.Path.HomeDir()); err != nil {
-		log.Fatalln("Initial configuration directory error: %s", err.Error())
-	}
-
-	if testConfig {
-		if _, err := executor.Parse(); err != nil {
-			log.Errorln(err.Error())
-			fmt.Printf("configuration file %s test failed\n", C.Path.Config())
-			os.Exit(1)
-		}
-		fmt.Printf("configuration file %s test is successful\n", C.Path.Config())
-		return
+		return fmt.Errorf("Initial configuration directory error: %w", err)
 	}
 
 	var options []hub.Option
@@ -97,10 +146,8 @@ func main() {
 	}
 
 	if err := hub.Parse(options...); err != nil {
-		log.Fatalln("Parse config error: %s", err.Error())
+		return fmt.Errorf("Parse config error: %w", err)
 	}
-
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
+	daemon.SetHealthCheck(tunnel.Health)
+	return nil
 }
@@ -0,0 +1,109 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Dreamacro/clash/component/nfmeta"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+)
+
+var (
+	// overridden in tests
+	lookupMark = nfmeta.Mark
+
+	markWarnOnce sync.Once
+)
+
+// FWMark matches the conntrack mark of the redir and tproxy connections, value/mask
+// matches the masked bits only. It never matches when the mark can't be read.
+type FWMark struct {
+	adapter string
+	payload string
+	value   uint32
+	mask    uint32
+}
+
+func (f *FWMark) RuleType() C.RuleType {
+	return C.FWMark
+}
+
+func (f *FWMark) Match(metadata *C.Metadata) bool {
+	if metadata.FwMark == nil {
+		resolveMark(metadata)
+		if metadata.FwMark == nil {
+			return false
+		}
+	}
+	return *metadata.FwMark&f.mask == f.value
+}
+
+// resolveMark looks the mark up once, the result is kept in metadata for the rules after
+func resolveMark(metadata *C.Metadata) {
+	if metadata.Type != C.REDIR && metadata.Type != C.TPROXY {
+		return
+	}
+
+	srcIP, ok := netip.AddrFromSlice(metadata.SrcIP)
+	srcPort, err := strconv.ParseUint(metadata.SrcPort, 10, 16)
+	if !ok || err != nil || !metadata.OriginDst.IsValid() {
+		return
+	}
+
+	mark, err := lookupMark(metadata.NetWork.String(), netip.AddrPortFrom(srcIP.Unmap(), uint16(srcPort)), metadata.OriginDst)
+	if err != nil {
+		if errors.Is(err, nfmeta.ErrNotFound) {
+			log.Debugln("[FWMark] %s: %s", metadata.SourceAddress(), err)
+		} else {
+			markWarnOnce.Do(func() {
+				log.Warnln("[FWMark] can't read the conntrack mark, FWMARK rules won't match: %s", err)
+			})
+		}
+		return
+	}
+	metadata.FwMark = &mark
+}
+
+func (f *FWMark) Adapter() string {
+	return f.adapter
+}
+
+func (f *FWMark) Payload() string {
+	return f.payload
+}
+
+func (f *FWMark) ShouldResolveIP() bool {
+	return false
+}
+
+func (f *FWMark) ShouldFindProcess() bool {
+	return false
+}
+
+// NewFWMark parses 0x10 or 0x10/0xf0, the numbers can also be decimal
+func NewFWMark(payload string, adapter string) (*FWMark, error) {
+	value, mask, hasMask := strings.Cut(payload, "/")
+	v, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mark %s", value)
+	}
+
+	m := uint64(0xffffffff)
+	if hasMask {
+		if m, err = strconv.ParseUint(mask, 0, 32); err != nil {
+			return nil, fmt.Errorf("invalid mask %s", mask)
+		}
+	}
+
+	return &FWMark{
+		adapter: adapter,
+		payload: payload,
+		value:   uint32(v & m),
+		mask:    uint32(m),
+	}, nil
+}
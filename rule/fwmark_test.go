@@ -0,0 +1,51 @@
+package rules
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFWMark(t *testing.T) {
+	lookups := 0
+	defer func(old func(string, netip.AddrPort, netip.AddrPort) (uint32, error)) { lookupMark = old }(lookupMark)
+	lookupMark = func(network string, from, to netip.AddrPort) (uint32, error) {
+		lookups++
+		assert.Equal(t, "tcp", network)
+		assert.Equal(t, "192.168.1.2:5000", from.String())
+		assert.Equal(t, "1.1.1.1:443", to.String())
+		return 0x1234, nil
+	}
+
+	exact, err := NewFWMark("0x1234", "Proxy")
+	assert.NoError(t, err)
+	masked, err := NewFWMark("0x30/0xf0", "Proxy")
+	assert.NoError(t, err)
+	other, err := NewFWMark("16", "Proxy")
+	assert.NoError(t, err)
+
+	metadata := &C.Metadata{
+		NetWork:   C.TCP,
+		Type:      C.TPROXY,
+		SrcIP:     net.ParseIP("192.168.1.2"),
+		SrcPort:   "5000",
+		OriginDst: netip.MustParseAddrPort("1.1.1.1:443"),
+	}
+	assert.False(t, other.Match(metadata))
+	assert.True(t, exact.Match(metadata))
+	assert.True(t, masked.Match(metadata))
+	// the mark is kept in metadata
+	assert.Equal(t, 1, lookups)
+
+	// only redir and tproxy connections are looked up
+	metadata = &C.Metadata{NetWork: C.TCP, Type: C.SOCKS5, SrcIP: net.ParseIP("192.168.1.2"), SrcPort: "5000"}
+	assert.False(t, exact.Match(metadata))
+	assert.Equal(t, 1, lookups)
+
+	_, err = NewFWMark("0x10/mask", "Proxy")
+	assert.Error(t, err)
+}
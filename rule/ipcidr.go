@@ -2,6 +2,7 @@ package rules
 
 import (
 	"net"
+	"net/netip"
 
 	C "github.com/Dreamacro/clash/constant"
 )
@@ -58,6 +59,16 @@ func (i *IPCIDR) ShouldFindProcess() bool {
 	return false
 }
 
+func (i *IPCIDR) prefix() netip.Prefix {
+	addr, _ := netip.AddrFromSlice(i.ipnet.IP)
+	bits, _ := i.ipnet.Mask.Size()
+	return netip.PrefixFrom(addr, bits)
+}
+
+func (i *IPCIDR) sameRun(other *IPCIDR) bool {
+	return i.adapter == other.adapter && i.isSourceIP == other.isSourceIP && i.noResolveIP == other.noResolveIP
+}
+
 func NewIPCIDR(s string, adapter string, opts ...IPCIDROption) (*IPCIDR, error) {
 	_, ipnet, err := net.ParseCIDR(s)
 	if err != nil {
@@ -0,0 +1,116 @@
+package rules
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/Dreamacro/clash/component/trie"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+// runs shorter than this are cheap enough to match one by one
+const minIPCIDRSetSize = 4
+
+// IPCIDRSet matches a run of consecutive IP-CIDR rules sharing the target
+// and options with a single trie lookup
+type IPCIDRSet struct {
+	rules []*IPCIDR
+	trie  *trie.IPTrie
+}
+
+func (s *IPCIDRSet) RuleType() C.RuleType {
+	return s.rules[0].RuleType()
+}
+
+func (s *IPCIDRSet) Match(metadata *C.Metadata) bool {
+	return s.Lookup(metadata) != nil
+}
+
+// Lookup return the first rule of the run matching the metadata, or nil
+func (s *IPCIDRSet) Lookup(metadata *C.Metadata) *IPCIDR {
+	ip := metadata.DstIP
+	if s.rules[0].isSourceIP {
+		ip = metadata.SrcIP
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return nil
+	}
+
+	first := -1
+	s.trie.Walk(addr, func(data any) bool {
+		if idx := data.(int); first == -1 || idx < first {
+			first = idx
+		}
+		return true
+	})
+	if first == -1 {
+		return nil
+	}
+	return s.rules[first]
+}
+
+func (s *IPCIDRSet) Adapter() string {
+	return s.rules[0].adapter
+}
+
+func (s *IPCIDRSet) Payload() string {
+	return fmt.Sprintf("%s and %d more", s.rules[0].Payload(), len(s.rules)-1)
+}
+
+func (s *IPCIDRSet) ShouldResolveIP() bool {
+	return s.rules[0].ShouldResolveIP()
+}
+
+func (s *IPCIDRSet) ShouldFindProcess() bool {
+	return false
+}
+
+// Compact merges runs of consecutive IP-CIDR rules with the same target and options
+// into IPCIDRSets. Their verdict is the one of the first matching rule of the run,
+// so rule order semantics are kept.
+func Compact(rules []C.Rule) []C.Rule {
+	compacted := make([]C.Rule, 0, len(rules))
+
+	run := []*IPCIDR{}
+	flush := func() {
+		if len(run) < minIPCIDRSetSize {
+			for _, rule := range run {
+				compacted = append(compacted, rule)
+			}
+		} else {
+			compacted = append(compacted, newIPCIDRSet(run))
+		}
+		run = []*IPCIDR{}
+	}
+
+	for _, rule := range rules {
+		cidr, ok := rule.(*IPCIDR)
+		if !ok {
+			flush()
+			compacted = append(compacted, rule)
+			continue
+		}
+
+		if len(run) != 0 && !run[0].sameRun(cidr) {
+			flush()
+		}
+		run = append(run, cidr)
+	}
+	flush()
+
+	return compacted
+}
+
+func newIPCIDRSet(rules []*IPCIDR) *IPCIDRSet {
+	set := &IPCIDRSet{
+		rules: rules,
+		trie:  trie.NewIPTrie(),
+	}
+
+	// duplicated prefixes keep the index of the first rule
+	for idx, rule := range rules {
+		set.trie.Insert(rule.prefix(), idx)
+	}
+	return set
+}
@@ -0,0 +1,94 @@
+package rules
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustIPCIDR(t testing.TB, cidr, adapter string, opts ...IPCIDROption) *IPCIDR {
+	rule, err := NewIPCIDR(cidr, adapter, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rule
+}
+
+func firstMatch(rules []C.Rule, metadata *C.Metadata) C.Rule {
+	for _, rule := range rules {
+		if set, ok := rule.(*IPCIDRSet); ok {
+			if matched := set.Lookup(metadata); matched != nil {
+				return matched
+			}
+			continue
+		}
+		if rule.Match(metadata) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func TestCompact_KeepOrder(t *testing.T) {
+	rules := []C.Rule{
+		mustIPCIDR(t, "10.0.0.0/8", "A"),
+		mustIPCIDR(t, "10.1.0.0/16", "A"),
+		mustIPCIDR(t, "192.168.0.0/16", "A"),
+		mustIPCIDR(t, "2001:db8::/32", "A"),
+		NewDomain("example.com", "B"),
+		mustIPCIDR(t, "10.1.1.0/24", "B"),
+		mustIPCIDR(t, "172.16.0.0/12", "C"),
+		mustIPCIDR(t, "172.16.0.0/12", "C"),
+		mustIPCIDR(t, "172.17.0.0/16", "C"),
+		mustIPCIDR(t, "0.0.0.0/0", "C"),
+		mustIPCIDR(t, "172.18.0.0/16", "D"),
+		mustIPCIDR(t, "1.1.1.1/32", "E", WithIPCIDRSourceIP(true)),
+	}
+
+	compacted := Compact(rules)
+	assert.Len(t, compacted, 6)
+	assert.IsType(t, &IPCIDRSet{}, compacted[0])
+	assert.IsType(t, &IPCIDRSet{}, compacted[3])
+
+	for _, ip := range []string{"10.1.1.1", "10.2.0.1", "172.16.1.1", "172.17.0.1", "172.18.0.1", "8.8.8.8", "2001:db8::1", "::1"} {
+		metadata := &C.Metadata{DstIP: net.ParseIP(ip), SrcIP: net.ParseIP("1.1.1.1")}
+		assert.Same(t, firstMatch(rules, metadata), firstMatch(compacted, metadata), ip)
+	}
+}
+
+func benchmarkRules(b *testing.B) ([]C.Rule, []*C.Metadata) {
+	r := rand.New(rand.NewSource(1))
+	rules := make([]C.Rule, 0, 50000)
+	for i := 0; i < 50000; i++ {
+		cidr := fmt.Sprintf("%d.%d.%d.0/24", r.Intn(224), r.Intn(256), r.Intn(256))
+		rules = append(rules, mustIPCIDR(b, cidr, "PROXY"))
+	}
+
+	metadatas := make([]*C.Metadata, 1024)
+	for i := range metadatas {
+		metadatas[i] = &C.Metadata{DstIP: net.IPv4(byte(r.Intn(224)), byte(r.Intn(256)), byte(r.Intn(256)), 1)}
+	}
+	return rules, metadatas
+}
+
+func BenchmarkIPCIDR_Linear(b *testing.B) {
+	rules, metadatas := benchmarkRules(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		firstMatch(rules, metadatas[i%len(metadatas)])
+	}
+}
+
+func BenchmarkIPCIDR_Compact(b *testing.B) {
+	rules, metadatas := benchmarkRules(b)
+	compacted := Compact(rules)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		firstMatch(compacted, metadatas[i%len(metadatas)])
+	}
+}
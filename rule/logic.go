@@ -0,0 +1,218 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	C "github.com/Dreamacro/clash/constant"
+)
+
+// Logic combines sub rules with AND, OR or NOT,
+// the payload looks like ((DOMAIN,example.com),(DST-PORT,443))
+type Logic struct {
+	ruleType C.RuleType
+	payload  string
+	adapter  string
+	rules    []C.Rule
+}
+
+func (l *Logic) RuleType() C.RuleType {
+	return l.ruleType
+}
+
+func (l *Logic) Match(metadata *C.Metadata) bool {
+	switch l.ruleType {
+	case C.AND:
+		for _, rule := range l.rules {
+			if !rule.Match(metadata) {
+				return false
+			}
+		}
+		return true
+	case C.OR:
+		for _, rule := range l.rules {
+			if rule.Match(metadata) {
+				return true
+			}
+		}
+		return false
+	default:
+		return !l.rules[0].Match(metadata)
+	}
+}
+
+func (l *Logic) Adapter() string {
+	return l.adapter
+}
+
+func (l *Logic) Payload() string {
+	return l.payload
+}
+
+func (l *Logic) ShouldResolveIP() bool {
+	for _, rule := range l.rules {
+		if rule.ShouldResolveIP() {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Logic) ShouldFindProcess() bool {
+	for _, rule := range l.rules {
+		if rule.ShouldFindProcess() {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the TIME sub rules currently let the rule match,
+// a rule without TIME sub rules is always active
+func (l *Logic) Active() bool {
+	may, _, _ := l.schedule()
+	return may
+}
+
+// Scheduled reports whether the rule carries TIME sub rules
+func (l *Logic) Scheduled() bool {
+	_, _, scheduled := l.schedule()
+	return scheduled
+}
+
+// schedule tells whether the rule may match and whether it must match
+// at this time, a plain sub rule may or may not match
+func (l *Logic) schedule() (may bool, must bool, scheduled bool) {
+	switch l.ruleType {
+	case C.AND:
+		may, must = true, true
+		for _, rule := range l.rules {
+			m, n, s := scheduleOf(rule)
+			may, must, scheduled = may && m, must && n, scheduled || s
+		}
+	case C.OR:
+		for _, rule := range l.rules {
+			m, n, s := scheduleOf(rule)
+			may, must, scheduled = may || m, must || n, scheduled || s
+		}
+	default:
+		m, n, s := scheduleOf(l.rules[0])
+		may, must, scheduled = !n, !m, s
+	}
+	return
+}
+
+func scheduleOf(rule C.Rule) (may bool, must bool, scheduled bool) {
+	switch r := rule.(type) {
+	case *Time:
+		active := r.Active()
+		return active, active, true
+	case *Logic:
+		return r.schedule()
+	default:
+		return true, false, false
+	}
+}
+
+func NewLogic(tp C.RuleType, payload string, adapter string) (*Logic, error) {
+	subs, err := splitSubRules(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(subs) == 0:
+		return nil, errors.New("logic rule needs at least one sub rule")
+	case tp == C.NOT && len(subs) != 1:
+		return nil, errors.New("NOT accepts exactly one sub rule")
+	}
+
+	rules := make([]C.Rule, 0, len(subs))
+	for _, sub := range subs {
+		rule, err := ParseLine(sub, "")
+		if err != nil {
+			return nil, fmt.Errorf("sub rule (%s): %w", sub, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return &Logic{
+		ruleType: tp,
+		payload:  payload,
+		adapter:  adapter,
+		rules:    rules,
+	}, nil
+}
+
+// splitSubRules turns ((A,a),(B,b)) into A,a and B,b
+func splitSubRules(payload string) ([]string, error) {
+	payload = strings.TrimSpace(payload)
+	if len(payload) < 2 || payload[0] != '(' || payload[len(payload)-1] != ')' {
+		return nil, errPayload
+	}
+	inner := payload[1 : len(payload)-1]
+
+	subs := []string{}
+	depth, start := 0, 0
+	for i, c := range inner {
+		switch c {
+		case '(':
+			if depth == 0 {
+				start = i + 1
+			}
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, errPayload
+			}
+			if depth == 0 {
+				subs = append(subs, strings.TrimSpace(inner[start:i]))
+			}
+		case ',', ' ':
+		default:
+			if depth == 0 {
+				return nil, errPayload
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, errPayload
+	}
+
+	return subs, nil
+}
+
+// SplitLogicPayload splits ((A,a),(B,b)),target,... into the
+// parenthesized payload and what follows the next comma
+func SplitLogicPayload(s string) (payload string, rest string, err error) {
+	s = strings.TrimSpace(s)
+	depth := 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				payload, rest = s[:i+1], strings.TrimSpace(s[i+1:])
+				if rest != "" {
+					if rest[0] != ',' {
+						return "", "", errPayload
+					}
+					rest = rest[1:]
+				}
+				return payload, rest, nil
+			}
+		}
+		if depth <= 0 {
+			return "", "", errPayload
+		}
+	}
+	return "", "", errPayload
+}
+
+func isLogic(tp string) bool {
+	return tp == "AND" || tp == "OR" || tp == "NOT"
+}
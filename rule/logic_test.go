@@ -0,0 +1,101 @@
+package rules
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogic_Match(t *testing.T) {
+	metadata := &C.Metadata{Host: "www.example.com", DstIP: net.ParseIP("1.1.1.1"), DstPort: "443"}
+
+	and, err := ParseLine("AND,((DOMAIN-SUFFIX,example.com),(DST-PORT,443))", "PROXY")
+	assert.Nil(t, err)
+	assert.True(t, and.Match(metadata))
+	assert.Equal(t, "PROXY", and.Adapter())
+
+	or, err := ParseLine("OR,((DOMAIN,other.com),(IP-CIDR,1.1.1.0/24,no-resolve))", "")
+	assert.Nil(t, err)
+	assert.True(t, or.Match(metadata))
+	assert.False(t, or.ShouldResolveIP())
+
+	not, err := ParseLine("NOT,((AND,((DST-PORT,443),(DOMAIN,www.example.com))))", "")
+	assert.Nil(t, err)
+	assert.False(t, not.Match(metadata))
+}
+
+func TestLogic_Invalid(t *testing.T) {
+	for _, line := range []string{
+		"NOT,((DOMAIN,a.com),(DOMAIN,b.com))",
+		"AND,((DOMAIN,a.com)",
+		"AND,(DOMAIN,a.com),(DOMAIN,b.com)",
+		"OR,((MATCH))",
+		"AND,()",
+	} {
+		_, err := ParseLine(line, "")
+		assert.NotNil(t, err, line)
+	}
+}
+
+func TestLogic_Active(t *testing.T) {
+	SetTimeZone(time.UTC)
+	defer SetTimeZone(nil)
+
+	now, err := time.Parse("Mon 2006-01-02 15:04", "Sun 2023-09-03 12:00")
+	assert.NoError(t, err)
+	parse := func(line string) *Logic {
+		rule, err := ParseLine(line, "REJECT")
+		assert.NoError(t, err)
+		logic := rule.(*Logic)
+		var inject func(rules []C.Rule)
+		inject = func(rules []C.Rule) {
+			for _, rule := range rules {
+				switch r := rule.(type) {
+				case *Time:
+					r.now = func() time.Time { return now }
+				case *Logic:
+					inject(r.rules)
+				}
+			}
+		}
+		inject(logic.rules)
+		return logic
+	}
+
+	plain := parse("AND,((DOMAIN,a.com),(DST-PORT,443))")
+	assert.False(t, plain.Scheduled())
+	assert.True(t, plain.Active())
+
+	weekend := parse("AND,((DOMAIN-SUFFIX,game.com),(TIME,SAT|SUN@10:00-20:00))")
+	assert.True(t, weekend.Scheduled())
+	assert.True(t, weekend.Active())
+
+	night := parse("AND,((DOMAIN-SUFFIX,game.com),(TIME,22:00-06:00))")
+	assert.False(t, night.Active())
+
+	either := parse("OR,((TIME,22:00-06:00),(TIME,SAT|SUN@10:00-20:00))")
+	assert.True(t, either.Active())
+
+	// a plain sub rule keeps OR matching off schedule
+	loose := parse("OR,((DOMAIN,a.com),(TIME,22:00-06:00))")
+	assert.True(t, loose.Scheduled())
+	assert.True(t, loose.Active())
+
+	outside := parse("NOT,((TIME,22:00-06:00))")
+	assert.True(t, outside.Active())
+
+	inside := parse("NOT,((TIME,SAT|SUN@10:00-20:00))")
+	assert.False(t, inside.Active())
+
+	// other domains still match on the weekend
+	nested := parse("NOT,((AND,((DOMAIN,a.com),(TIME,SAT|SUN@10:00-20:00))))")
+	assert.True(t, nested.Scheduled())
+	assert.True(t, nested.Active())
+
+	blocked := parse("NOT,((OR,((DOMAIN,a.com),(TIME,SAT|SUN@10:00-20:00))))")
+	assert.False(t, blocked.Active())
+}
@@ -1,7 +1,9 @@
 package rules
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	C "github.com/Dreamacro/clash/constant"
 )
@@ -38,6 +40,16 @@ func ParseRule(tp, payload, target string, params []string) (C.Rule, error) {
 	case "IPSET":
 		noResolve := HasNoResolve(params)
 		parsed, parseErr = NewIPSet(payload, target, noResolve)
+	case "TIME":
+		parsed, parseErr = NewTime(payload, target)
+	case "FWMARK":
+		parsed, parseErr = NewFWMark(payload, target)
+	case "AND":
+		parsed, parseErr = NewLogic(C.AND, payload, target)
+	case "OR":
+		parsed, parseErr = NewLogic(C.OR, payload, target)
+	case "NOT":
+		parsed, parseErr = NewLogic(C.NOT, payload, target)
 	case "MATCH":
 		parsed = NewMatch(target)
 	default:
@@ -46,3 +58,39 @@ func ParseRule(tp, payload, target string, params []string) (C.Rule, error) {
 
 	return parsed, parseErr
 }
+
+// ParseLine parses a rule line without its target, TYPE,payload[,params],
+// as used in logic sub rules and classical rule providers
+func ParseLine(line string, target string) (C.Rule, error) {
+	tp, rest, _ := strings.Cut(line, ",")
+	tp = strings.TrimSpace(tp)
+
+	if tp == "MATCH" {
+		return nil, errors.New("MATCH is only allowed in rules")
+	}
+
+	if isLogic(tp) {
+		payload, extra, err := SplitLogicPayload(rest)
+		if err != nil {
+			return nil, err
+		}
+		if extra != "" {
+			return nil, fmt.Errorf("unexpected %s after %s payload", extra, tp)
+		}
+		return ParseRule(tp, payload, target, nil)
+	}
+
+	var (
+		payload string
+		params  []string
+	)
+	if rest != "" {
+		parts := strings.Split(rest, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		payload, params = parts[0], parts[1:]
+	}
+
+	return ParseRule(tp, payload, target, params)
+}
@@ -0,0 +1,47 @@
+package rules
+
+import (
+	C "github.com/Dreamacro/clash/constant"
+	P "github.com/Dreamacro/clash/constant/provider"
+)
+
+type RuleSet struct {
+	provider  P.RuleProvider
+	adapter   string
+	noResolve bool
+}
+
+func (rs *RuleSet) RuleType() C.RuleType {
+	return C.RuleSet
+}
+
+func (rs *RuleSet) Match(metadata *C.Metadata) bool {
+	return rs.provider.Match(metadata)
+}
+
+func (rs *RuleSet) Adapter() string {
+	return rs.adapter
+}
+
+func (rs *RuleSet) Payload() string {
+	return rs.provider.Name()
+}
+
+func (rs *RuleSet) ShouldResolveIP() bool {
+	return !rs.noResolve && rs.provider.ShouldResolveIP()
+}
+
+func (rs *RuleSet) ShouldFindProcess() bool {
+	if pf, ok := rs.provider.(interface{ ShouldFindProcess() bool }); ok {
+		return pf.ShouldFindProcess()
+	}
+	return false
+}
+
+func NewRuleSet(provider P.RuleProvider, adapter string, noResolve bool) *RuleSet {
+	return &RuleSet{
+		provider:  provider,
+		adapter:   adapter,
+		noResolve: noResolve,
+	}
+}
@@ -0,0 +1,175 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	minutesPerDay  = 24 * 60
+	minutesPerWeek = 7 * minutesPerDay
+)
+
+var (
+	timeZone = atomic.NewPointer(time.Local)
+
+	weekdays = map[string]time.Weekday{
+		"SUN": time.Sunday,
+		"MON": time.Monday,
+		"TUE": time.Tuesday,
+		"WED": time.Wednesday,
+		"THU": time.Thursday,
+		"FRI": time.Friday,
+		"SAT": time.Saturday,
+	}
+)
+
+// SetTimeZone sets the time zone the TIME rules are evaluated in, nil means the local one
+func SetTimeZone(loc *time.Location) {
+	if loc == nil {
+		loc = time.Local
+	}
+	timeZone.Store(loc)
+}
+
+// Time matches when the current minute of the week is in the schedule,
+// the schedule is precomputed into a bitset so a match is a single lookup
+type Time struct {
+	adapter  string
+	payload  string
+	schedule [(minutesPerWeek + 63) / 64]uint64
+	now      func() time.Time
+}
+
+func (t *Time) RuleType() C.RuleType {
+	return C.Time
+}
+
+func (t *Time) Match(metadata *C.Metadata) bool {
+	return t.Active()
+}
+
+// Active reports whether the schedule covers the current time
+func (t *Time) Active() bool {
+	now := t.now().In(timeZone.Load())
+	minute := int(now.Weekday())*minutesPerDay + now.Hour()*60 + now.Minute()
+	return t.schedule[minute/64]&(1<<(minute%64)) != 0
+}
+
+func (t *Time) Adapter() string {
+	return t.adapter
+}
+
+func (t *Time) Payload() string {
+	return t.payload
+}
+
+func (t *Time) ShouldResolveIP() bool {
+	return false
+}
+
+func (t *Time) ShouldFindProcess() bool {
+	return false
+}
+
+func (t *Time) set(from, to int) {
+	for m := from; m < to; m++ {
+		n := m % minutesPerWeek
+		t.schedule[n/64] |= 1 << (n % 64)
+	}
+}
+
+// NewTime parses [DAYS@]HH:MM-HH:MM, DAYS is a | separated list of weekdays or
+// ranges of them such as MON-FRI|SUN. A range ending before it starts runs
+// overnight into the next day, the end is exclusive and may be 24:00.
+func NewTime(payload string, adapter string) (*Time, error) {
+	t := &Time{adapter: adapter, payload: payload, now: time.Now}
+
+	days := []time.Weekday{}
+	span := payload
+	if d, s, ok := strings.Cut(payload, "@"); ok {
+		parsed, err := parseWeekdays(d)
+		if err != nil {
+			return nil, err
+		}
+		days, span = parsed, s
+	} else {
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			days = append(days, d)
+		}
+	}
+
+	fromStr, toStr, ok := strings.Cut(span, "-")
+	if !ok {
+		return nil, fmt.Errorf("time range %s should be HH:MM-HH:MM", span)
+	}
+	from, err := parseClock(fromStr)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseClock(toStr)
+	if err != nil {
+		return nil, err
+	}
+	if from == to {
+		return nil, fmt.Errorf("time range %s is empty", span)
+	}
+	if from >= minutesPerDay {
+		return nil, fmt.Errorf("time range %s should not start at 24:00", span)
+	}
+	if to < from {
+		to += minutesPerDay
+	}
+
+	for _, d := range days {
+		start := int(d) * minutesPerDay
+		t.set(start+from, start+to)
+	}
+	return t, nil
+}
+
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	days := []time.Weekday{}
+	for _, part := range strings.Split(s, "|") {
+		fromStr, toStr, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		from, ok := weekdays[strings.ToUpper(fromStr)]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %s", fromStr)
+		}
+		if !isRange {
+			days = append(days, from)
+			continue
+		}
+
+		to, ok := weekdays[strings.ToUpper(toStr)]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %s", toStr)
+		}
+		// SAT-MON wraps over the week
+		for d := from; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock return the minute of the day of HH:MM
+func parseClock(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "24:00" {
+		return minutesPerDay, nil
+	}
+	clock, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %s", s)
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
@@ -0,0 +1,56 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTime_Schedule(t *testing.T) {
+	SetTimeZone(time.UTC)
+	defer SetTimeZone(nil)
+
+	at := func(rule *Time, s string) bool {
+		now, err := time.Parse("Mon 2006-01-02 15:04", s)
+		assert.NoError(t, err)
+		rule.now = func() time.Time { return now }
+		return rule.Active()
+	}
+
+	overnight, err := NewTime("22:00-06:00", "REJECT")
+	assert.NoError(t, err)
+	assert.True(t, at(overnight, "Sat 2023-09-02 23:30"))
+	assert.True(t, at(overnight, "Sun 2023-09-03 05:59"))
+	assert.False(t, at(overnight, "Sun 2023-09-03 06:00"))
+	assert.False(t, at(overnight, "Sun 2023-09-03 21:59"))
+
+	weekend, err := NewTime("SAT|SUN@10:00-20:00", "REJECT")
+	assert.NoError(t, err)
+	assert.True(t, at(weekend, "Sun 2023-09-03 10:00"))
+	assert.False(t, at(weekend, "Mon 2023-09-04 12:00"))
+
+	// a friday night runs into saturday, a saturday night wraps into sunday
+	nights, err := NewTime("FRI-SAT@23:00-01:00", "REJECT")
+	assert.NoError(t, err)
+	assert.True(t, at(nights, "Sat 2023-09-02 00:30"))
+	assert.True(t, at(nights, "Sun 2023-09-03 00:30"))
+	assert.False(t, at(nights, "Mon 2023-09-04 00:30"))
+
+	// the time zone applies at match time
+	SetTimeZone(time.FixedZone("UTC+8", 8*3600))
+	assert.True(t, at(weekend, "Sun 2023-09-03 02:00"))
+}
+
+func TestTime_Parse(t *testing.T) {
+	for _, payload := range []string{"22:00", "10:00-10:00", "25:00-26:00", "24:00-01:00", "FUNDAY@10:00-11:00", "MON@10:00-1100"} {
+		_, err := NewTime(payload, "")
+		assert.Error(t, err, payload)
+	}
+
+	and, err := ParseLine("AND,((TIME,MON-SUN@00:00-24:00),(DOMAIN,a.com))", "REJECT")
+	assert.NoError(t, err)
+	assert.True(t, and.Match(&C.Metadata{Host: "a.com"}))
+}
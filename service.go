@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Dreamacro/clash/component/daemon"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/listener"
+	"github.com/Dreamacro/clash/tunnel/statistic"
+)
+
+const serviceName = "clash"
+
+// serviceMain runs the service subcommands of the Windows service control manager
+func serviceMain(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: clash [-d dir] [-f file] service install|uninstall|run")
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "install":
+		// the service runs as another user, the paths are fixed at install
+		err = daemon.Install(serviceName, []string{"-d", C.Path.HomeDir(), "-f", C.Path.Config(), "service", "run"})
+	case "uninstall":
+		err = daemon.Uninstall(serviceName)
+	case "run":
+		err = daemon.RunService(serviceName, start)
+		listener.CloseTun()
+		// write the queued access log entries
+		statistic.UpdateAccessLog(nil)
+	default:
+		err = fmt.Errorf("unknown service command %s, expect install, uninstall or run", args[0])
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "service %s: %s\n", args[0], err)
+		return 1
+	}
+	return 0
+}
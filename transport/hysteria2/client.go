@@ -0,0 +1,324 @@
+package hysteria2
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/transport/quicconn"
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/time/rate"
+)
+
+const udpQueueSize = 64
+
+var (
+	errUDPDisabled = errors.New("hysteria2 server disabled udp")
+	errClosed      = errors.New("hysteria2 connection closed")
+)
+
+type Option struct {
+	Password       string
+	ServerName     string
+	SkipCertVerify bool
+	ALPN           []string
+	// Up and Down are the bandwidth of the client in bytes per second, zero
+	// leaves the sending to the congestion control of quic
+	Up   uint64
+	Down uint64
+}
+
+// Client is an authenticated connection to a hysteria2 server, the tcp streams
+// and the udp sessions share it
+type Client struct {
+	conn  quic.EarlyConnection
+	rt    *http3.RoundTripper
+	udp   bool
+	pacer *rate.Limiter
+
+	mux      sync.Mutex
+	sessions map[uint32]*PacketConn
+	nextID   uint32
+}
+
+// NewClient dials the server on pc and authenticates, pc belongs to the client afterwards
+func NewClient(ctx context.Context, pc net.PacketConn, addr net.Addr, opt Option) (*Client, error) {
+	alpn := opt.ALPN
+	if len(alpn) == 0 {
+		alpn = []string{"h3"}
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         opt.ServerName,
+		InsecureSkipVerify: opt.SkipCertVerify,
+		NextProtos:         alpn,
+	}
+	conn, err := quic.DialEarly(ctx, pc, addr, tlsConfig, quicconn.ClientConfig())
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	rt := &http3.RoundTripper{
+		TLSClientConfig: tlsConfig,
+		QuicConfig:      quicconn.ClientConfig(),
+		Dial: func(context.Context, string, *tls.Config, *quic.Config) (quic.EarlyConnection, error) {
+			return conn, nil
+		},
+	}
+	req := &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: "https", Host: URLHost, Path: URLPath},
+		Header: http.Header{},
+	}
+	SetAuthRequest(req.Header, opt.Password, opt.Down)
+	resp, err := rt.RoundTripOpt(req.WithContext(ctx), http3.RoundTripOpt{})
+	if err != nil {
+		conn.CloseWithError(0, "")
+		pc.Close()
+		return nil, fmt.Errorf("hysteria2 authentication: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != StatusAuthOK {
+		conn.CloseWithError(0, "")
+		pc.Close()
+		return nil, fmt.Errorf("hysteria2 authentication failed, status %d", resp.StatusCode)
+	}
+
+	// send no faster than the server receives
+	auth := ParseAuthResponse(resp.Header)
+	tx := opt.Up
+	if !auth.RXAuto && auth.RX != 0 && (tx == 0 || auth.RX < tx) {
+		tx = auth.RX
+	}
+	c := &Client{
+		conn:     conn,
+		rt:       rt,
+		udp:      auth.UDP,
+		pacer:    quicconn.NewPacer(tx),
+		sessions: map[uint32]*PacketConn{},
+	}
+	go func() {
+		<-conn.Context().Done()
+		pc.Close()
+	}()
+	if c.udp {
+		go c.receive()
+	}
+	return c, nil
+}
+
+// Done is closed with the connection
+func (c *Client) Done() <-chan struct{} {
+	return c.conn.Context().Done()
+}
+
+func (c *Client) Close() error {
+	c.rt.Close()
+	return c.conn.CloseWithError(0, "")
+}
+
+// Ping opens and finishes a unidirectional stream, the server acknowledges it
+func (c *Client) Ping() error {
+	s, err := c.conn.OpenUniStream()
+	if err != nil {
+		return err
+	}
+	return s.Close()
+}
+
+// DialTCP opens a stream to addr, host:port
+func (c *Client) DialTCP(ctx context.Context, addr string) (net.Conn, error) {
+	s, err := c.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetReadDeadline(deadline)
+	}
+	if err := WriteTCPRequest(s, addr); err != nil {
+		s.CancelRead(0)
+		s.Close()
+		return nil, err
+	}
+	if err := ReadTCPResponse(s); err != nil {
+		s.CancelRead(0)
+		s.Close()
+		return nil, err
+	}
+	s.SetReadDeadline(time.Time{})
+	return quicconn.NewStreamConn(s, c.conn.LocalAddr(), c.conn.RemoteAddr(), c.pacer), nil
+}
+
+// ListenUDP opens a udp session
+func (c *Client) ListenUDP() (*PacketConn, error) {
+	if !c.udp {
+		return nil, errUDPDisabled
+	}
+	select {
+	case <-c.Done():
+		return nil, errClosed
+	default:
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.nextID++
+	pc := &PacketConn{
+		client: c,
+		id:     c.nextID,
+		in:     make(chan *UDPMessage, udpQueueSize),
+		closed: make(chan struct{}),
+	}
+	c.sessions[pc.id] = pc
+	return pc, nil
+}
+
+func (c *Client) receive() {
+	for {
+		b, err := c.conn.ReceiveDatagram(context.Background())
+		if err != nil {
+			return
+		}
+		m, err := ParseUDPMessage(b)
+		if err != nil {
+			continue
+		}
+		c.mux.Lock()
+		pc := c.sessions[m.SessionID]
+		c.mux.Unlock()
+		if pc != nil {
+			pc.feed(m)
+		}
+	}
+}
+
+func (c *Client) send(m *UDPMessage) error {
+	for _, frag := range FragUDPMessage(m, quicconn.MaxDatagramSize) {
+		b := make([]byte, frag.Size())
+		frag.Marshal(b)
+		quicconn.WaitPacer(c.pacer, len(b))
+		if err := c.conn.SendDatagram(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PacketConn is a udp session of a Client
+type PacketConn struct {
+	client   *Client
+	id       uint32
+	packetID uint16
+	defrag   Defragger
+	in       chan *UDPMessage
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	mux      sync.Mutex
+	deadline time.Time
+}
+
+func (pc *PacketConn) feed(m *UDPMessage) {
+	pc.mux.Lock()
+	full := pc.defrag.Feed(m)
+	pc.mux.Unlock()
+	if full == nil {
+		return
+	}
+	full.Data = append([]byte(nil), full.Data...)
+	select {
+	case pc.in <- full:
+	default:
+	}
+}
+
+func (pc *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	pc.mux.Lock()
+	deadline := pc.deadline
+	pc.mux.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		select {
+		case m := <-pc.in:
+			addr := socks5.ParseAddr(m.Addr)
+			if addr == nil {
+				continue
+			}
+			udpAddr := addr.UDPAddr()
+			if udpAddr == nil {
+				continue
+			}
+			return copy(b, m.Data), udpAddr, nil
+		case <-pc.closed:
+			return 0, nil, net.ErrClosed
+		case <-pc.client.Done():
+			return 0, nil, errClosed
+		case <-timeout:
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (pc *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	pc.mux.Lock()
+	pc.packetID++
+	id := pc.packetID
+	pc.mux.Unlock()
+	m := &UDPMessage{
+		SessionID: pc.id,
+		PacketID:  id,
+		FragCount: 1,
+		Addr:      addr.String(),
+		Data:      b,
+	}
+	if err := pc.client.send(m); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (pc *PacketConn) Close() error {
+	pc.closeOnce.Do(func() {
+		close(pc.closed)
+		pc.client.mux.Lock()
+		delete(pc.client.sessions, pc.id)
+		pc.client.mux.Unlock()
+	})
+	return nil
+}
+
+func (pc *PacketConn) LocalAddr() net.Addr {
+	return pc.client.conn.LocalAddr()
+}
+
+func (pc *PacketConn) SetDeadline(t time.Time) error {
+	return pc.SetReadDeadline(t)
+}
+
+func (pc *PacketConn) SetReadDeadline(t time.Time) error {
+	pc.mux.Lock()
+	pc.deadline = t
+	pc.mux.Unlock()
+	return nil
+}
+
+func (pc *PacketConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
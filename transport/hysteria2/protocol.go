@@ -0,0 +1,304 @@
+package hysteria2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+const (
+	// FrameTypeTCPRequest opens the proxied tcp stream in place of an http/3 frame
+	FrameTypeTCPRequest = 0x401
+
+	// StatusAuthOK is the status the server answers a successful authentication with
+	StatusAuthOK = 233
+
+	URLHost = "hysteria"
+	URLPath = "/auth"
+
+	HeaderAuth    = "Hysteria-Auth"
+	HeaderUDP     = "Hysteria-UDP"
+	HeaderCCRX    = "Hysteria-CC-RX"
+	HeaderPadding = "Hysteria-Padding"
+
+	maxAddressLength = 2048
+	maxMessageLength = 2048
+	maxPaddingLength = 4096
+
+	tcpStatusOK    = 0x00
+	tcpStatusError = 0x01
+
+	udpHeaderSize = 4 + 2 + 1 + 1
+)
+
+var errInvalidMessage = errors.New("invalid hysteria2 message")
+
+// padding is the random filler of the requests and responses, it hides their length
+func padding(min, max int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, min+rand.Intn(max-min))
+	for i := range b {
+		b[i] = letters[rand.Intn(len(letters))]
+	}
+	return string(b)
+}
+
+// SetAuthRequest sets the headers of the authentication request, rx is the
+// download bandwidth of the client in bytes per second, zero when unknown
+func SetAuthRequest(header http.Header, auth string, rx uint64) {
+	header.Set(HeaderAuth, auth)
+	header.Set(HeaderCCRX, strconv.FormatUint(rx, 10))
+	header.Set(HeaderPadding, padding(256, 2048))
+}
+
+// AuthRequest is the authentication request as read by the server
+type AuthRequest struct {
+	Auth string
+	RX   uint64
+}
+
+func ParseAuthRequest(header http.Header) AuthRequest {
+	rx, _ := strconv.ParseUint(header.Get(HeaderCCRX), 10, 64)
+	return AuthRequest{Auth: header.Get(HeaderAuth), RX: rx}
+}
+
+// SetAuthResponse sets the headers of a successful authentication, rx is the
+// download bandwidth of the server, zero leaves the choice to the client
+func SetAuthResponse(header http.Header, udp bool, rx uint64) {
+	header.Set(HeaderUDP, strconv.FormatBool(udp))
+	if rx == 0 {
+		header.Set(HeaderCCRX, "auto")
+	} else {
+		header.Set(HeaderCCRX, strconv.FormatUint(rx, 10))
+	}
+	header.Set(HeaderPadding, padding(256, 2048))
+}
+
+// AuthResponse is the answer of the server as read by the client, RXAuto
+// tells the server leaves the congestion control to the client
+type AuthResponse struct {
+	UDP    bool
+	RX     uint64
+	RXAuto bool
+}
+
+func ParseAuthResponse(header http.Header) AuthResponse {
+	resp := AuthResponse{}
+	resp.UDP, _ = strconv.ParseBool(header.Get(HeaderUDP))
+	if rx := header.Get(HeaderCCRX); rx == "auto" {
+		resp.RXAuto = true
+	} else {
+		resp.RX, _ = strconv.ParseUint(rx, 10, 64)
+	}
+	return resp
+}
+
+// WriteTCPRequest writes the request of a tcp stream to addr as host:port
+func WriteTCPRequest(w io.Writer, addr string) error {
+	pad := padding(64, 512)
+	b := make([]byte, 0, 16+len(addr)+len(pad))
+	b = quicvarint.Append(b, FrameTypeTCPRequest)
+	b = quicvarint.Append(b, uint64(len(addr)))
+	b = append(b, addr...)
+	b = quicvarint.Append(b, uint64(len(pad)))
+	b = append(b, pad...)
+	_, err := w.Write(b)
+	return err
+}
+
+// ReadTCPRequest reads the request of a tcp stream after its frame type
+func ReadTCPRequest(r io.Reader) (string, error) {
+	vr := quicvarint.NewReader(r)
+	addr, err := readVarBytes(vr, maxAddressLength)
+	if err != nil {
+		return "", err
+	}
+	if _, err := readVarBytes(vr, maxPaddingLength); err != nil {
+		return "", err
+	}
+	return string(addr), nil
+}
+
+// WriteTCPResponse answers a tcp request, msg tells the client why it failed
+func WriteTCPResponse(w io.Writer, ok bool, msg string) error {
+	pad := padding(64, 512)
+	b := make([]byte, 0, 16+len(msg)+len(pad))
+	if ok {
+		b = append(b, tcpStatusOK)
+	} else {
+		b = append(b, tcpStatusError)
+	}
+	b = quicvarint.Append(b, uint64(len(msg)))
+	b = append(b, msg...)
+	b = quicvarint.Append(b, uint64(len(pad)))
+	b = append(b, pad...)
+	_, err := w.Write(b)
+	return err
+}
+
+// ReadTCPResponse reads the answer of a tcp request, a refused request is an error
+func ReadTCPResponse(r io.Reader) error {
+	vr := quicvarint.NewReader(r)
+	status, err := vr.ReadByte()
+	if err != nil {
+		return err
+	}
+	msg, err := readVarBytes(vr, maxMessageLength)
+	if err != nil {
+		return err
+	}
+	if _, err := readVarBytes(vr, maxPaddingLength); err != nil {
+		return err
+	}
+	if status != tcpStatusOK {
+		return fmt.Errorf("hysteria2 server refused: %s", msg)
+	}
+	return nil
+}
+
+func readVarBytes(r quicvarint.Reader, max int) ([]byte, error) {
+	l, err := quicvarint.Read(r)
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(max) {
+		return nil, errInvalidMessage
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// UDPMessage is a udp datagram of a session, a payload larger than the datagram
+// frame is split into fragments of the same packet id
+type UDPMessage struct {
+	SessionID uint32
+	PacketID  uint16
+	FragID    uint8
+	FragCount uint8
+	Addr      string
+	Data      []byte
+}
+
+func (m *UDPMessage) headerSize() int {
+	return udpHeaderSize + int(quicvarint.Len(uint64(len(m.Addr)))) + len(m.Addr)
+}
+
+func (m *UDPMessage) Size() int {
+	return m.headerSize() + len(m.Data)
+}
+
+// Marshal encodes the message into b, it returns the bytes written
+func (m *UDPMessage) Marshal(b []byte) int {
+	if len(b) < m.Size() {
+		return 0
+	}
+	binary.BigEndian.PutUint32(b, m.SessionID)
+	binary.BigEndian.PutUint16(b[4:], m.PacketID)
+	b[6], b[7] = m.FragID, m.FragCount
+	n := len(quicvarint.Append(b[8:8], uint64(len(m.Addr))))
+	n += 8
+	n += copy(b[n:], m.Addr)
+	n += copy(b[n:], m.Data)
+	return n
+}
+
+// ParseUDPMessage decodes a datagram, Data points into b
+func ParseUDPMessage(b []byte) (*UDPMessage, error) {
+	if len(b) < udpHeaderSize {
+		return nil, errInvalidMessage
+	}
+	m := &UDPMessage{
+		SessionID: binary.BigEndian.Uint32(b),
+		PacketID:  binary.BigEndian.Uint16(b[4:]),
+		FragID:    b[6],
+		FragCount: b[7],
+	}
+	r := bytes.NewReader(b[8:])
+	l, err := quicvarint.Read(r)
+	if err != nil || l == 0 || l > maxAddressLength || int(l) > r.Len() {
+		return nil, errInvalidMessage
+	}
+	start := len(b) - r.Len()
+	m.Addr = string(b[start : start+int(l)])
+	m.Data = b[start+int(l):]
+	if m.FragCount == 0 || m.FragID >= m.FragCount {
+		return nil, errInvalidMessage
+	}
+	return m, nil
+}
+
+// FragUDPMessage splits m so every fragment fits maxSize, m is returned as is when it fits
+func FragUDPMessage(m *UDPMessage, maxSize int) []*UDPMessage {
+	if m.Size() <= maxSize {
+		return []*UDPMessage{m}
+	}
+	room := maxSize - m.headerSize()
+	if room <= 0 {
+		return nil
+	}
+	count := (len(m.Data) + room - 1) / room
+	if count > 255 {
+		return nil
+	}
+	frags := make([]*UDPMessage, 0, count)
+	for i, off := 0, 0; off < len(m.Data); i, off = i+1, off+room {
+		end := off + room
+		if end > len(m.Data) {
+			end = len(m.Data)
+		}
+		frag := *m
+		frag.FragID, frag.FragCount = uint8(i), uint8(count)
+		frag.Data = m.Data[off:end]
+		frags = append(frags, &frag)
+	}
+	return frags
+}
+
+// Defragger reassembles the fragments of a session, only the latest packet is
+// kept so a lost fragment costs at most one packet
+type Defragger struct {
+	packetID uint16
+	frags    [][]byte
+	count    int
+	size     int
+}
+
+// Feed returns the message once all of its fragments arrived
+func (d *Defragger) Feed(m *UDPMessage) *UDPMessage {
+	if m.FragCount <= 1 {
+		return m
+	}
+	if d.frags == nil || m.PacketID != d.packetID || len(d.frags) != int(m.FragCount) {
+		d.packetID = m.PacketID
+		d.frags = make([][]byte, m.FragCount)
+		d.count, d.size = 0, 0
+	}
+	if d.frags[m.FragID] != nil {
+		return nil
+	}
+	d.frags[m.FragID] = append([]byte(nil), m.Data...)
+	d.count++
+	d.size += len(m.Data)
+	if d.count != len(d.frags) {
+		return nil
+	}
+
+	data := make([]byte, 0, d.size)
+	for _, frag := range d.frags {
+		data = append(data, frag...)
+	}
+	d.frags = nil
+	full := *m
+	full.FragID, full.FragCount, full.Data = 0, 1, data
+	return &full
+}
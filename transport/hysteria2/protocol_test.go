@@ -0,0 +1,77 @@
+package hysteria2
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTCPRequest(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteTCPRequest(buf, "example.com:443"))
+	// the frame type is read by the http/3 server before the hijack
+	assert.Equal(t, []byte{0x44, 0x01}, buf.Next(2))
+	addr, err := ReadTCPRequest(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com:443", addr)
+	assert.Zero(t, buf.Len())
+
+	require.NoError(t, WriteTCPResponse(buf, true, ""))
+	assert.NoError(t, ReadTCPResponse(buf))
+	require.NoError(t, WriteTCPResponse(buf, false, "blocked"))
+	assert.ErrorContains(t, ReadTCPResponse(buf), "blocked")
+}
+
+func TestAuth(t *testing.T) {
+	header := http.Header{}
+	SetAuthRequest(header, "secret", 1250000)
+	assert.Equal(t, AuthRequest{Auth: "secret", RX: 1250000}, ParseAuthRequest(header))
+	assert.NotEmpty(t, header.Get(HeaderPadding))
+
+	header = http.Header{}
+	SetAuthResponse(header, true, 0)
+	assert.Equal(t, AuthResponse{UDP: true, RXAuto: true}, ParseAuthResponse(header))
+	SetAuthResponse(header, false, 100)
+	assert.Equal(t, AuthResponse{RX: 100}, ParseAuthResponse(header))
+}
+
+func TestUDPMessage(t *testing.T) {
+	m := &UDPMessage{SessionID: 7, PacketID: 3, FragCount: 1, Addr: "1.1.1.1:53", Data: []byte("query")}
+	b := make([]byte, m.Size())
+	assert.Equal(t, len(b), m.Marshal(b))
+	parsed, err := ParseUDPMessage(b)
+	assert.NoError(t, err)
+	assert.Equal(t, m, parsed)
+
+	for _, b := range [][]byte{{}, b[:9], append(b[:6:6], 1, 1)} {
+		_, err := ParseUDPMessage(b)
+		assert.Error(t, err)
+	}
+}
+
+func TestUDPMessage_Fragment(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 300)
+	m := &UDPMessage{SessionID: 1, PacketID: 9, FragCount: 1, Addr: "example.com:443", Data: data}
+	frags := FragUDPMessage(m, 1200)
+	assert.Len(t, frags, 3)
+
+	d := &Defragger{}
+	// a fragment of an older packet is dropped when a new one starts
+	assert.Nil(t, d.Feed(&UDPMessage{PacketID: 8, FragID: 0, FragCount: 2, Data: []byte("x")}))
+	var full *UDPMessage
+	for i := len(frags) - 1; i >= 0; i-- {
+		b := make([]byte, frags[i].Size())
+		assert.LessOrEqual(t, frags[i].Marshal(b), 1200)
+		parsed, err := ParseUDPMessage(b)
+		require.NoError(t, err)
+		full = d.Feed(parsed)
+	}
+	require.NotNil(t, full)
+	assert.Equal(t, data, full.Data)
+	assert.Equal(t, "example.com:443", full.Addr)
+
+	assert.Nil(t, FragUDPMessage(m, 10))
+}
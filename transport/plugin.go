@@ -0,0 +1,41 @@
+// Package transport holds the plugins added out of this repo to wrap the stream of an
+// outbound, the ones of shadowsocks are looked up here before obfs and v2ray-plugin.
+package transport
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Plugin wraps the stream to the server of an outbound
+type Plugin interface {
+	StreamConn(c net.Conn) (net.Conn, error)
+}
+
+// PluginConstructor builds the plugin of an outbound to server, HOST:PORT, from its plugin-opts
+type PluginConstructor func(server string, opts map[string]any) (Plugin, error)
+
+var (
+	pluginsMux sync.RWMutex
+	plugins    = map[string]PluginConstructor{}
+)
+
+// RegisterPlugin adds the plugin name, a package out of this repo calls it from its init.
+// Registering a plugin twice panics.
+func RegisterPlugin(name string, constructor PluginConstructor) {
+	pluginsMux.Lock()
+	defer pluginsMux.Unlock()
+	if _, exist := plugins[name]; exist {
+		panic(fmt.Sprintf("plugin %s is registered twice", name))
+	}
+	plugins[name] = constructor
+}
+
+// RegisteredPlugin return the constructor of a plugin of RegisterPlugin
+func RegisteredPlugin(name string) (PluginConstructor, bool) {
+	pluginsMux.RLock()
+	defer pluginsMux.RUnlock()
+	constructor, ok := plugins[name]
+	return constructor, ok
+}
@@ -0,0 +1,41 @@
+package quicconn
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var bandwidthUnits = []struct {
+	suffix string
+	bits   uint64
+}{
+	{"tbps", 1e12},
+	{"gbps", 1e9},
+	{"mbps", 1e6},
+	{"kbps", 1e3},
+	{"bps", 1},
+}
+
+// ParseBandwidth parses a bandwidth like "100 mbps" into bytes per second, a bare
+// number is in mbps and an empty string is zero
+func ParseBandwidth(s string) (uint64, error) {
+	raw := s
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+
+	bits := uint64(1e6)
+	for _, unit := range bandwidthUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, bits = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), unit.bits
+			break
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth %s", raw)
+	}
+	return n * bits / 8, nil
+}
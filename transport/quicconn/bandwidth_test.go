@@ -0,0 +1,26 @@
+package quicconn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	for s, expected := range map[string]uint64{
+		"":          0,
+		"100":       12500000,
+		"100 mbps":  12500000,
+		"1Gbps":     125000000,
+		"800 kbps":  100000,
+		"8000 bps":  1000,
+		" 10 MBPS ": 1250000,
+	} {
+		bw, err := ParseBandwidth(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, bw, s)
+	}
+
+	_, err := ParseBandwidth("fast")
+	assert.Error(t, err)
+}
@@ -0,0 +1,268 @@
+package quicconn
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// degradedAfter is how long a HopConn sends without hearing back from the server before
+// it takes another port
+const degradedAfter = 3 * time.Second
+
+type portRange struct {
+	from, to uint16
+}
+
+// Ports are the ports a server listens on, a range like 20000-40000 or a list of ports
+// and ranges separated by commas
+type Ports []portRange
+
+func ParsePorts(s string) (Ports, error) {
+	var ports Ports
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := strconv.ParseUint(strings.TrimSpace(from), 10, 16)
+		if err != nil || first == 0 {
+			return nil, fmt.Errorf("invalid port %s", part)
+		}
+		last := first
+		if isRange {
+			last, err = strconv.ParseUint(strings.TrimSpace(to), 10, 16)
+			if err != nil || last < first {
+				return nil, fmt.Errorf("invalid port range %s", part)
+			}
+		}
+		ports = append(ports, portRange{uint16(first), uint16(last)})
+	}
+	if len(ports) == 0 {
+		return nil, errors.New("no ports")
+	}
+	return ports, nil
+}
+
+func (p Ports) count() int {
+	n := 0
+	for _, r := range p {
+		n += int(r.to-r.from) + 1
+	}
+	return n
+}
+
+// pick return a random port other than current, unless it's the only one
+func (p Ports) pick(current uint16) uint16 {
+	n := p.count()
+	for {
+		i := rand.Intn(n)
+		var port uint16
+		for _, r := range p {
+			size := int(r.to-r.from) + 1
+			if i < size {
+				port = r.from + uint16(i)
+				break
+			}
+			i -= size
+		}
+		if port != current || n == 1 {
+			return port
+		}
+	}
+}
+
+// HopConn is the socket of a quic client to a server listening on Ports. quic sees the
+// fixed address Addr while the packets go to the port of the current hop, so the
+// connection carries on over a hop without another handshake. The local address stays
+// over a hop, quic-go servers don't follow a client to another one, it only moves with
+// Rebind.
+type HopConn struct {
+	path  atomic.Pointer[path]
+	ip    net.IP
+	ports Ports
+	addr  *net.UDPAddr
+
+	port atomic.Uint32
+	hops atomic.Uint32
+	// waiting is the time of the first packet sent after the last one read, zero when
+	// the server answered them all
+	waiting atomic.Int64
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// path is a local socket of a HopConn, answered is closed with the first packet of the
+// server read from it
+type path struct {
+	net.PacketConn
+	answerOnce sync.Once
+	answered   chan struct{}
+}
+
+func newPath(pc net.PacketConn) *path {
+	return &path{PacketConn: pc, answered: make(chan struct{})}
+}
+
+// NewHopConn takes another port every interval and when the server stops answering,
+// zero interval only hops on the latter
+func NewHopConn(pc net.PacketConn, ip net.IP, ports Ports, interval time.Duration) *HopConn {
+	port := ports.pick(0)
+	c := &HopConn{
+		ip:     ip,
+		ports:  ports,
+		addr:   &net.UDPAddr{IP: ip, Port: int(port)},
+		closed: make(chan struct{}),
+	}
+	c.port.Store(uint32(port))
+	c.path.Store(newPath(pc))
+	go c.loop(interval)
+	return c
+}
+
+// Addr is the address of the server quic dials
+func (c *HopConn) Addr() net.Addr {
+	return c.addr
+}
+
+// Port is the port the packets go to
+func (c *HopConn) Port() int {
+	return int(c.port.Load())
+}
+
+// Hops counts the ports taken after the first
+func (c *HopConn) Hops() uint32 {
+	return c.hops.Load()
+}
+
+// Hop takes another port
+func (c *HopConn) Hop() {
+	if c.ports.count() < 2 {
+		return
+	}
+	c.port.Store(uint32(c.ports.pick(uint16(c.port.Load()))))
+	c.hops.Inc()
+	// the new port gets the time of a degradation to answer
+	c.waiting.Store(0)
+}
+
+// Rebind moves the conn to the socket pc and closes the one before, the returned channel
+// is closed once the server answers on pc
+func (c *HopConn) Rebind(pc net.PacketConn) <-chan struct{} {
+	p := newPath(pc)
+	old := c.path.Swap(p)
+	select {
+	case <-c.closed:
+		// Close may have missed the new socket
+		pc.Close()
+	default:
+	}
+	old.Close()
+	return p.answered
+}
+
+func (c *HopConn) loop(interval time.Duration) {
+	check := time.NewTicker(time.Second)
+	defer check.Stop()
+	var hop <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		hop = ticker.C
+	}
+
+	for {
+		select {
+		case <-hop:
+			c.Hop()
+		case now := <-check.C:
+			if c.degraded(now) {
+				c.Hop()
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// degraded tells the server hasn't answered the packets sent for degradedAfter
+func (c *HopConn) degraded(now time.Time) bool {
+	waiting := c.waiting.Load()
+	return waiting != 0 && now.Sub(time.Unix(0, waiting)) > degradedAfter
+}
+
+// ReadFrom implements net.PacketConn, the packets of the server come from Addr whatever
+// port they came from
+func (c *HopConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		p := c.path.Load()
+		n, addr, err := p.ReadFrom(b)
+		if err != nil {
+			// the socket was closed by Rebind, the reads go on with the next one
+			if c.path.Load() != p {
+				continue
+			}
+			return n, addr, err
+		}
+		if udpAddr, ok := addr.(*net.UDPAddr); !ok || !udpAddr.IP.Equal(c.ip) {
+			continue
+		}
+		c.waiting.Store(0)
+		p.answerOnce.Do(func() { close(p.answered) })
+		return n, c.addr, nil
+	}
+}
+
+// WriteTo implements net.PacketConn, quic only writes to Addr
+func (c *HopConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	c.waiting.CompareAndSwap(0, time.Now().UnixNano())
+	return c.path.Load().WriteTo(b, &net.UDPAddr{IP: c.ip, Port: c.Port()})
+}
+
+func (c *HopConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return c.path.Load().Close()
+}
+
+func (c *HopConn) LocalAddr() net.Addr {
+	return c.path.Load().LocalAddr()
+}
+
+func (c *HopConn) SetDeadline(t time.Time) error {
+	return c.path.Load().SetDeadline(t)
+}
+
+func (c *HopConn) SetReadDeadline(t time.Time) error {
+	return c.path.Load().SetReadDeadline(t)
+}
+
+func (c *HopConn) SetWriteDeadline(t time.Time) error {
+	return c.path.Load().SetWriteDeadline(t)
+}
+
+// SetReadBuffer lets quic grow the buffer of the socket
+func (c *HopConn) SetReadBuffer(bytes int) error {
+	if conn, ok := c.path.Load().PacketConn.(interface{ SetReadBuffer(int) error }); ok {
+		return conn.SetReadBuffer(bytes)
+	}
+	return nil
+}
+
+// SetWriteBuffer lets quic grow the buffer of the socket
+func (c *HopConn) SetWriteBuffer(bytes int) error {
+	if conn, ok := c.path.Load().PacketConn.(interface{ SetWriteBuffer(int) error }); ok {
+		return conn.SetWriteBuffer(bytes)
+	}
+	return nil
+}
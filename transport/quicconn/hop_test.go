@@ -0,0 +1,82 @@
+package quicconn
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePorts(t *testing.T) {
+	ports, err := ParsePorts("20000-40000")
+	require.NoError(t, err)
+	assert.Equal(t, 20001, ports.count())
+
+	ports, err = ParsePorts("443, 8443,10000-10009")
+	require.NoError(t, err)
+	assert.Equal(t, 12, ports.count())
+	for i := 0; i < 100; i++ {
+		port := ports.pick(443)
+		assert.NotEqual(t, uint16(443), port)
+		assert.True(t, port == 8443 || port >= 10000 && port <= 10009, port)
+	}
+
+	for _, s := range []string{"", "0", "40000-20000", "1-70000", "a-b"} {
+		_, err := ParsePorts(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestHopConn(t *testing.T) {
+	servers := map[int]net.PacketConn{}
+	var list string
+	for i := 0; i < 2; i++ {
+		server, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer server.Close()
+		port := server.LocalAddr().(*net.UDPAddr).Port
+		servers[port] = server
+		if list != "" {
+			list += ","
+		}
+		list += strconv.Itoa(port)
+	}
+	ports, err := ParsePorts(list)
+	require.NoError(t, err)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	c := NewHopConn(pc, net.IPv4(127, 0, 0, 1), ports, 0)
+	defer c.Close()
+
+	buf := make([]byte, 16)
+	for i := 0; i < 2; i++ {
+		port := c.Port()
+		_, err := c.WriteTo([]byte("ping"), c.Addr())
+		require.NoError(t, err)
+		assert.True(t, c.degraded(time.Now().Add(degradedAfter+time.Second)))
+
+		server := servers[port]
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		n, from, err := server.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(buf[:n]))
+
+		// the answer comes from Addr whatever port it came from
+		_, err = server.WriteTo([]byte("pong"), from)
+		require.NoError(t, err)
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := c.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "pong", string(buf[:n]))
+		assert.Equal(t, c.Addr(), addr)
+		assert.False(t, c.degraded(time.Now().Add(degradedAfter+time.Second)))
+
+		c.Hop()
+		assert.NotEqual(t, port, c.Port())
+	}
+	assert.Equal(t, uint32(2), c.Hops())
+}
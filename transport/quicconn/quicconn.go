@@ -0,0 +1,75 @@
+package quicconn
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// MaxDatagramSize keeps a datagram within the smallest packet a quic path carries
+	MaxDatagramSize = 1150
+
+	idleTimeout     = 30 * time.Second
+	keepAlivePeriod = 10 * time.Second
+)
+
+// ServerConfig is the quic config of the inbounds
+func ServerConfig() *quic.Config {
+	return &quic.Config{
+		MaxIdleTimeout:  idleTimeout,
+		KeepAlivePeriod: keepAlivePeriod,
+		EnableDatagrams: true,
+		// the clients open a stream per proxied tcp connection
+		MaxIncomingStreams:    1 << 10,
+		MaxIncomingUniStreams: 1 << 10,
+	}
+}
+
+// ClientConfig is the quic config of the outbounds
+func ClientConfig() *quic.Config {
+	return &quic.Config{
+		MaxIdleTimeout:  idleTimeout,
+		KeepAlivePeriod: keepAlivePeriod,
+		EnableDatagrams: true,
+	}
+}
+
+// LoadKeyPair loads the certificate and the private key of an inbound, either is
+// a path relative to the home directory or inline PEM
+func LoadKeyPair(certificate, privateKey string) (tls.Certificate, error) {
+	certPEM, err := readPEM(certificate)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("certificate: %w", err)
+	}
+	keyPEM, err := readPEM(privateKey)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("private-key: %w", err)
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+func readPEM(s string) ([]byte, error) {
+	if strings.Contains(s, "-----BEGIN") {
+		return []byte(s), nil
+	}
+	return os.ReadFile(C.Path.Resolve(s))
+}
+
+// SessionAddr tells the udp sessions of a quic connection apart, the nat table of
+// the tunnel is keyed by the source address of the packets
+type SessionAddr struct {
+	net.Addr
+	ID uint32
+}
+
+func (a *SessionAddr) String() string {
+	return fmt.Sprintf("%s#%d", a.Addr.String(), a.ID)
+}
@@ -0,0 +1,83 @@
+package quicconn
+
+import (
+	"context"
+	"net"
+
+	"github.com/quic-go/quic-go"
+	"golang.org/x/time/rate"
+)
+
+// StreamConn is a bidirectional quic stream used as a net.Conn, the addresses
+// are the ones of its connection
+type StreamConn struct {
+	quic.Stream
+	local  net.Addr
+	remote net.Addr
+	pacer  *rate.Limiter
+}
+
+// NewStreamConn wraps s, a non-nil pacer paces the writes
+func NewStreamConn(s quic.Stream, local, remote net.Addr, pacer *rate.Limiter) *StreamConn {
+	return &StreamConn{Stream: s, local: local, remote: remote, pacer: pacer}
+}
+
+func (c *StreamConn) Write(b []byte) (n int, err error) {
+	if c.pacer == nil {
+		return c.Stream.Write(b)
+	}
+	for len(b) > 0 {
+		chunk := len(b)
+		if burst := c.pacer.Burst(); chunk > burst {
+			chunk = burst
+		}
+		if err := c.pacer.WaitN(c.Stream.Context(), chunk); err != nil {
+			return n, err
+		}
+		m, err := c.Stream.Write(b[:chunk])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		b = b[chunk:]
+	}
+	return n, nil
+}
+
+// Close closes both directions, quic.Stream.Close only closes the write side
+func (c *StreamConn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
+
+func (c *StreamConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *StreamConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// NewPacer limits the sending to bytesPerSecond, zero returns nil which doesn't limit
+func NewPacer(bytesPerSecond uint64) *rate.Limiter {
+	if bytesPerSecond == 0 {
+		return nil
+	}
+	// a burst of 1/20 second smooths the sending without starving large writes
+	burst := int(bytesPerSecond / 20)
+	if burst < 16<<10 {
+		burst = 16 << 10
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+}
+
+// WaitPacer takes n bytes from pacer before a datagram is sent, a nil pacer doesn't wait
+func WaitPacer(pacer *rate.Limiter, n int) {
+	if pacer == nil {
+		return
+	}
+	if n > pacer.Burst() {
+		n = pacer.Burst()
+	}
+	pacer.WaitN(context.Background(), n)
+}
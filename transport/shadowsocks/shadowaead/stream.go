@@ -6,8 +6,6 @@ import (
 	"errors"
 	"io"
 	"net"
-
-	"github.com/Dreamacro/clash/common/pool"
 )
 
 const (
@@ -18,29 +16,55 @@ const (
 
 var ErrZeroChunk = errors.New("zero chunk")
 
+// Writer and Reader keep their buffers for the connection instead of taking
+// them from the pool per call, the writer grows its buffer to the largest
+// record written so connections carrying small writes stay small
 type Writer struct {
 	io.Writer
 	cipher.AEAD
 	nonce [32]byte // should be sufficient for most nonce sizes
+	buf   []byte
+
+	// cached to keep the interface calls out of the hot path
+	nonceSize int
+	tag       int
 }
 
 // NewWriter wraps an io.Writer with authenticated encryption.
-func NewWriter(w io.Writer, aead cipher.AEAD) *Writer { return &Writer{Writer: w, AEAD: aead} }
+func NewWriter(w io.Writer, aead cipher.AEAD) *Writer {
+	return &Writer{Writer: w, AEAD: aead, nonceSize: aead.NonceSize(), tag: aead.Overhead()}
+}
+
+func (w *Writer) buffer(size int) []byte {
+	if cap(w.buf) < size {
+		w.buf = make([]byte, size)
+	}
+	return w.buf[:size]
+}
+
+// seal encrypts the record of buf[off:off+nr] in place, buf[:off] receives the size
+func (w *Writer) seal(buf []byte, nr int) []byte {
+	nonce := w.nonce[:w.nonceSize]
+	off := 2 + w.tag
+
+	buf[0], buf[1] = byte(nr>>8), byte(nr) // big-endian payload size
+	w.Seal(buf[:0], nonce, buf[:2], nil)
+	increment(nonce)
+	w.Seal(buf[:off], nonce, buf[off:off+nr], nil)
+	increment(nonce)
+	return buf[:off+nr+w.tag]
+}
 
 // Write encrypts p and writes to the embedded io.Writer.
 func (w *Writer) Write(p []byte) (n int, err error) {
-	buf := pool.Get(bufSize)
-	defer pool.Put(buf)
-	nonce := w.nonce[:w.NonceSize()]
-	tag := w.Overhead()
-	off := 2 + tag
+	off := 2 + w.tag
 
 	// compatible with snell
 	if len(p) == 0 {
-		buf = buf[:off]
+		buf := w.buffer(off)
 		buf[0], buf[1] = byte(0), byte(0)
-		w.Seal(buf[:0], nonce, buf[:2], nil)
-		increment(nonce)
+		w.Seal(buf[:0], w.nonce[:w.nonceSize], buf[:2], nil)
+		increment(w.nonce[:w.nonceSize])
 		_, err = w.Writer.Write(buf)
 		return
 	}
@@ -50,13 +74,9 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 		if n+nr > len(p) {
 			nr = len(p) - n
 		}
-		buf = buf[:off+nr+tag]
-		buf[0], buf[1] = byte(nr>>8), byte(nr) // big-endian payload size
-		w.Seal(buf[:0], nonce, buf[:2], nil)
-		increment(nonce)
-		w.Seal(buf[:off], nonce, p[n:n+nr], nil)
-		increment(nonce)
-		_, err = w.Writer.Write(buf)
+		buf := w.buffer(off + nr + w.tag)
+		copy(buf[off:], p[n:n+nr])
+		_, err = w.Writer.Write(w.seal(buf, nr))
 	}
 	return
 }
@@ -65,20 +85,12 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 // writes to the embedded io.Writer. Returns number of bytes read from r and
 // any error encountered.
 func (w *Writer) ReadFrom(r io.Reader) (n int64, err error) {
-	buf := pool.Get(bufSize)
-	defer pool.Put(buf)
-	nonce := w.nonce[:w.NonceSize()]
-	tag := w.Overhead()
-	off := 2 + tag
+	buf := w.buffer(bufSize)
+	off := 2 + w.tag
 	for {
 		nr, er := r.Read(buf[off : off+payloadSizeMask])
 		n += int64(nr)
-		buf[0], buf[1] = byte(nr>>8), byte(nr)
-		w.Seal(buf[:0], nonce, buf[:2], nil)
-		increment(nonce)
-		w.Seal(buf[:off], nonce, buf[off:off+nr], nil)
-		increment(nonce)
-		if _, ew := w.Writer.Write(buf[:off+nr+tag]); ew != nil {
+		if _, ew := w.Writer.Write(w.seal(buf, nr)); ew != nil {
 			err = ew
 			return
 		}
@@ -91,72 +103,100 @@ func (w *Writer) ReadFrom(r io.Reader) (n int64, err error) {
 	}
 }
 
+// Reader reads ahead into its buffer, so a record usually costs one read of
+// the embedded io.Reader instead of two, and several small records share one
 type Reader struct {
 	io.Reader
 	cipher.AEAD
 	nonce [32]byte // should be sufficient for most nonce sizes
-	buf   []byte   // to be put back into bufPool
-	off   int      // offset to unconsumed part of buf
+
+	// buf[start:end] is the ciphertext read ahead, plain the decrypted
+	// record not consumed yet, it lives in buf as well
+	buf        []byte
+	start, end int
+	plain      []byte
+
+	nonceSize int
+	tag       int
 }
 
 // NewReader wraps an io.Reader with authenticated decryption.
-func NewReader(r io.Reader, aead cipher.AEAD) *Reader { return &Reader{Reader: r, AEAD: aead} }
+func NewReader(r io.Reader, aead cipher.AEAD) *Reader {
+	return &Reader{Reader: r, AEAD: aead, nonceSize: aead.NonceSize(), tag: aead.Overhead()}
+}
+
+// fill makes sure at least n bytes of ciphertext are buffered
+func (r *Reader) fill(n int) error {
+	if r.buf == nil {
+		r.buf = make([]byte, bufSize)
+	}
+	if r.end-r.start >= n {
+		return nil
+	}
+	if r.start+n > len(r.buf) {
+		r.end = copy(r.buf, r.buf[r.start:r.end])
+		r.start = 0
+	}
+	nr, err := io.ReadAtLeast(r.Reader, r.buf[r.end:], n-(r.end-r.start))
+	r.end += nr
+	if err == io.ErrUnexpectedEOF && r.end == r.start {
+		err = io.EOF
+	}
+	return err
+}
 
-// Read and decrypt a record into p. len(p) >= max payload size + AEAD overhead.
-func (r *Reader) read(p []byte) (int, error) {
-	nonce := r.nonce[:r.NonceSize()]
-	tag := r.Overhead()
+// next decrypts the next record, into dst when it is large enough or else in place.
+// The result is only valid until the following call.
+func (r *Reader) next(dst []byte) ([]byte, error) {
+	nonce := r.nonce[:r.nonceSize]
+	tag := r.tag
 
 	// decrypt payload size
-	p = p[:2+tag]
-	if _, err := io.ReadFull(r.Reader, p); err != nil {
-		return 0, err
+	if err := r.fill(2 + tag); err != nil {
+		return nil, err
 	}
-	_, err := r.Open(p[:0], nonce, p, nil)
+	header := r.buf[r.start : r.start+2+tag]
+	_, err := r.Open(header[:0], nonce, header, nil)
 	increment(nonce)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	r.start += 2 + tag
 
-	// decrypt payload
-	size := (int(p[0])<<8 + int(p[1])) & payloadSizeMask
+	size := (int(header[0])<<8 + int(header[1])) & payloadSizeMask
 	if size == 0 {
-		return 0, ErrZeroChunk
+		return nil, ErrZeroChunk
 	}
 
-	p = p[:size+tag]
-	if _, err := io.ReadFull(r.Reader, p); err != nil {
-		return 0, err
+	// decrypt payload
+	if err := r.fill(size + tag); err != nil {
+		return nil, err
 	}
-	_, err = r.Open(p[:0], nonce, p, nil)
-	increment(nonce)
-	if err != nil {
-		return 0, err
+	ciphertext := r.buf[r.start : r.start+size+tag]
+	r.start += size + tag
+	if len(dst) < size {
+		dst = ciphertext
 	}
-	return size, nil
+	plain, err := r.Open(dst[:0], nonce, ciphertext, nil)
+	increment(nonce)
+	return plain, err
 }
 
 // Read reads from the embedded io.Reader, decrypts and writes to p.
 func (r *Reader) Read(p []byte) (int, error) {
-	if r.buf == nil {
-		if len(p) >= payloadSizeMask+r.Overhead() {
-			return r.read(p)
-		}
-		b := pool.Get(bufSize)
-		n, err := r.read(b)
+	if len(r.plain) == 0 {
+		plain, err := r.next(p)
 		if err != nil {
 			return 0, err
 		}
-		r.buf = b[:n]
-		r.off = 0
+		if len(p) >= len(plain) && &plain[0] == &p[0] {
+			return len(plain), nil
+		}
+		r.plain = plain
 	}
 
-	n := copy(p, r.buf[r.off:])
-	r.off += n
-	if r.off == len(r.buf) {
-		pool.Put(r.buf[:cap(r.buf)])
-		r.buf = nil
-	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
 	return n, nil
 }
 
@@ -164,35 +204,25 @@ func (r *Reader) Read(p []byte) (int, error) {
 // there's no more data to write or when an error occurs. Return number of
 // bytes written to w and any error encountered.
 func (r *Reader) WriteTo(w io.Writer) (n int64, err error) {
-	if r.buf == nil {
-		r.buf = pool.Get(bufSize)
-		r.off = len(r.buf)
-	}
-
 	for {
-		for r.off < len(r.buf) {
-			nw, ew := w.Write(r.buf[r.off:])
-			r.off += nw
+		for len(r.plain) > 0 {
+			nw, ew := w.Write(r.plain)
+			r.plain = r.plain[nw:]
 			n += int64(nw)
 			if ew != nil {
-				if r.off == len(r.buf) {
-					pool.Put(r.buf[:cap(r.buf)])
-					r.buf = nil
-				}
 				err = ew
 				return
 			}
 		}
 
-		nr, er := r.read(r.buf)
+		plain, er := r.next(nil)
 		if er != nil {
 			if er != io.EOF {
 				err = er
 			}
 			return
 		}
-		r.buf = r.buf[:nr]
-		r.off = 0
+		r.plain = plain
 	}
 }
 
@@ -0,0 +1,168 @@
+package shadowaead
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testCiphers = map[string]func([]byte) (Cipher, error){
+	"AES-128-GCM":       func(psk []byte) (Cipher, error) { return AESGCM(psk[:16]) },
+	"CHACHA20-POLY1305": Chacha20Poly1305,
+}
+
+func testPayload(n int) []byte {
+	payload := make([]byte, n)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	return payload
+}
+
+func encrypt(t testing.TB, ciph Cipher, salt []byte, writes ...[]byte) []byte {
+	aead, err := ciph.Encrypter(salt)
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, aead)
+	for _, p := range writes {
+		_, err := w.Write(p)
+		require.NoError(t, err)
+	}
+	return buf.Bytes()
+}
+
+// the digests were taken from the stream before the reader and writer were reworked
+func TestStream_Vector(t *testing.T) {
+	psk := bytes.Repeat([]byte{0x5a}, 32)
+	salt := bytes.Repeat([]byte{0xa5}, 32)
+
+	for name, digest := range map[string]string{
+		"AES-128-GCM":       "8581879533daae5f076658138e2712784e650434006814ded843f7d3c1a59812",
+		"CHACHA20-POLY1305": "d64aa7c8b0cf423901c868dbcd2f266c1332fd765233b5511f340cb337d62c78",
+	} {
+		ciph, err := testCiphers[name](psk)
+		require.NoError(t, err)
+
+		stream := encrypt(t, ciph, salt[:ciph.SaltSize()], testPayload(100), nil, testPayload(40000))
+		sum := sha256.Sum256(stream)
+		assert.Equal(t, digest, hex.EncodeToString(sum[:]), name)
+	}
+}
+
+func TestStream_Read(t *testing.T) {
+	psk := bytes.Repeat([]byte{0x5a}, 32)
+	for name, newCipher := range testCiphers {
+		ciph, err := newCipher(psk)
+		require.NoError(t, err)
+		salt := make([]byte, ciph.SaltSize())
+
+		first, second := testPayload(100), testPayload(40000)
+		stream := encrypt(t, ciph, salt, first, nil, second)
+
+		aead, err := ciph.Decrypter(salt)
+		require.NoError(t, err)
+		r := NewReader(&chunkedReader{buf: stream, size: 1000}, aead)
+
+		// small reads are served from the decrypted record
+		got := make([]byte, len(first))
+		_, err = io.ReadFull(r, got)
+		require.NoError(t, err, name)
+		assert.Equal(t, first, got, name)
+
+		// the zero chunk of snell ends a session, the records after it stay readable
+		_, err = r.Read(make([]byte, 10))
+		assert.ErrorIs(t, err, ErrZeroChunk, name)
+
+		got = make([]byte, len(second))
+		_, err = io.ReadFull(r, got)
+		require.NoError(t, err, name)
+		assert.Equal(t, second, got, name)
+	}
+}
+
+func TestStream_WriteTo(t *testing.T) {
+	ciph, err := Chacha20Poly1305(bytes.Repeat([]byte{0x5a}, 32))
+	require.NoError(t, err)
+	salt := make([]byte, ciph.SaltSize())
+
+	payload := testPayload(100000)
+	stream := encrypt(t, ciph, salt, payload)
+
+	aead, err := ciph.Decrypter(salt)
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	n, err := NewReader(&chunkedReader{buf: stream, size: 3000}, aead).WriteTo(buf)
+	require.NoError(t, err)
+	assert.EqualValues(t, len(payload), n)
+	assert.Equal(t, payload, buf.Bytes())
+}
+
+// chunkedReader returns at most size bytes a read like a tcp conn
+type chunkedReader struct {
+	buf  []byte
+	size int
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		return 0, io.EOF
+	}
+	if len(p) > r.size {
+		p = p[:r.size]
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// BenchmarkConn relays through a pipe, run it with -cpu 1 to see a single core router
+func BenchmarkConn(b *testing.B) {
+	psk := bytes.Repeat([]byte{0x5a}, 32)
+	for name, newCipher := range testCiphers {
+		ciph, err := newCipher(psk)
+		require.NoError(b, err)
+
+		for _, size := range []int{1024, 16 * 1024} {
+			b.Run(name+"/"+byteSize(size), func(b *testing.B) {
+				left, right := net.Pipe()
+				client, server := NewConn(left, ciph), NewConn(right, ciph)
+				defer client.Close()
+				defer server.Close()
+
+				payload := testPayload(size)
+				go func() {
+					for {
+						if _, err := client.Write(payload); err != nil {
+							return
+						}
+					}
+				}()
+
+				buf := make([]byte, size)
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := io.ReadFull(server, buf); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func byteSize(n int) string {
+	if n >= 1024 {
+		return strconv.Itoa(n/1024) + "K"
+	}
+	return strconv.Itoa(n)
+}
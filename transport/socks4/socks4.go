@@ -41,7 +41,8 @@ var (
 	ErrRequestUnknownCode      = errors.New("request failed with unknown code")
 )
 
-func ServerHandshake(rw io.ReadWriter, authenticator auth.Authenticator) (addr string, command Command, err error) {
+// ServerHandshake reads a CONNECT request, allow may reject the target before it is granted
+func ServerHandshake(rw io.ReadWriter, authenticator auth.Authenticator, allow func(addr string) bool) (addr string, command Command, err error) {
 	var req [8]byte
 	if _, err = io.ReadFull(rw, req[:]); err != nil {
 		return
@@ -86,11 +87,15 @@ func ServerHandshake(rw io.ReadWriter, authenticator auth.Authenticator) (addr s
 	}
 
 	// SOCKS4 only support USERID auth.
-	if authenticator == nil || authenticator.Verify(string(userID), "") {
-		code = RequestGranted
-	} else {
+	switch {
+	case authenticator != nil && !authenticator.Verify(string(userID), ""):
 		code = RequestIdentdMismatched
 		err = ErrRequestIdentdMismatched
+	case allow != nil && !allow(addr):
+		code = RequestRejected
+		err = ErrRequestRejected
+	default:
+		code = RequestGranted
 	}
 
 	reply := protobytes.BytesWriter(make([]byte, 0, 8))
@@ -108,7 +108,9 @@ type User struct {
 }
 
 // ServerHandshake fast-tracks SOCKS initialization to get target address to connect on server side.
-func ServerHandshake(rw net.Conn, authenticator auth.Authenticator) (addr Addr, command Command, err error) {
+// allow may refuse the request before it is acknowledged, a returned Error is sent as the reply code.
+// The replies of CmdBind are left to the caller, see WriteReply.
+func ServerHandshake(rw net.Conn, authenticator auth.Authenticator, allow func(command Command, addr Addr) error) (addr Addr, command Command, err error) {
 	// Read RFC 1928 for request and reply structure and sizes.
 	buf := make([]byte, MaxAddrLen)
 	// read VER, NMETHODS, METHODS
@@ -189,7 +191,17 @@ func ServerHandshake(rw net.Conn, authenticator auth.Authenticator) (addr Addr,
 	}
 
 	switch command {
-	case CmdConnect, CmdUDPAssociate:
+	case CmdConnect, CmdUDPAssociate, CmdBind:
+		if allow != nil {
+			if err = allow(command, addr); err != nil {
+				WriteReply(rw, ReplyCode(err), nil)
+				return
+			}
+		}
+		if command == CmdBind {
+			return
+		}
+
 		// Acquire server listened address info
 		localAddr := ParseAddr(rw.LocalAddr().String())
 		if localAddr == nil {
@@ -198,8 +210,6 @@ func ServerHandshake(rw net.Conn, authenticator auth.Authenticator) (addr Addr,
 			// write VER REP RSV ATYP BND.ADDR BND.PORT
 			_, err = rw.Write(bytes.Join([][]byte{{5, 0, 0}, localAddr}, []byte{}))
 		}
-	case CmdBind:
-		fallthrough
 	default:
 		err = ErrCommandNotSupported
 	}
@@ -207,6 +217,24 @@ func ServerHandshake(rw net.Conn, authenticator auth.Authenticator) (addr Addr,
 	return
 }
 
+// ReplyCode return the reply code of a failed request, an Error is sent as it is
+func ReplyCode(err error) Error {
+	code := ErrGeneralFailure
+	errors.As(err, &code)
+	return code
+}
+
+// WriteReply writes a reply with BND.ADDR and BND.PORT from addr, code 0 means succeeded.
+// A nil addr is sent as 0.0.0.0:0
+func WriteReply(w io.Writer, code Error, addr Addr) error {
+	if addr == nil {
+		addr = Addr{AtypIPv4, 0, 0, 0, 0, 0, 0}
+	}
+	// write VER REP RSV ATYP BND.ADDR BND.PORT
+	_, err := w.Write(bytes.Join([][]byte{{5, byte(code), 0}, addr}, []byte{}))
+	return err
+}
+
 // ClientHandshake fast-tracks SOCKS initialization to get target address to connect on client side.
 func ClientHandshake(rw io.ReadWriter, addr Addr, command Command, user *User) (Addr, error) {
 	buf := make([]byte, MaxAddrLen)
@@ -12,11 +12,13 @@ import (
 	"net/http"
 	"sync"
 
+	"github.com/Dreamacro/clash/component/clientcert"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/transport/socks5"
 	"github.com/Dreamacro/clash/transport/vmess"
 
 	"github.com/Dreamacro/protobytes"
+	"go.uber.org/atomic"
 )
 
 const (
@@ -43,6 +45,10 @@ type Option struct {
 	ALPN           []string
 	ServerName     string
 	SkipCertVerify bool
+
+	// ClientSessionCache enables tls session resumption, nil disables it
+	ClientSessionCache tls.ClientSessionCache
+	ClientCert         *clientcert.Certificate
 }
 
 type WebsocketOption struct {
@@ -55,6 +61,14 @@ type WebsocketOption struct {
 type Trojan struct {
 	option      *Option
 	hexPassword []byte
+
+	handshakes atomic.Int64
+	resumed    atomic.Int64
+}
+
+// ResumeStats return the number of tls handshakes and how many of them resumed a session
+func (t *Trojan) ResumeStats() (handshakes, resumed int64) {
+	return t.handshakes.Load(), t.resumed.Load()
 }
 
 func (t *Trojan) StreamConn(conn net.Conn) (net.Conn, error) {
@@ -63,12 +77,13 @@ func (t *Trojan) StreamConn(conn net.Conn) (net.Conn, error) {
 		alpn = t.option.ALPN
 	}
 
-	tlsConfig := &tls.Config{
+	tlsConfig := t.option.ClientCert.Apply(&tls.Config{
 		NextProtos:         alpn,
 		MinVersion:         tls.VersionTLS12,
 		InsecureSkipVerify: t.option.SkipCertVerify,
 		ServerName:         t.option.ServerName,
-	}
+		ClientSessionCache: t.option.ClientSessionCache,
+	})
 
 	tlsConn := tls.Client(conn, tlsConfig)
 
@@ -79,6 +94,10 @@ func (t *Trojan) StreamConn(conn net.Conn) (net.Conn, error) {
 		return nil, err
 	}
 
+	t.handshakes.Inc()
+	if tlsConn.ConnectionState().DidResume {
+		t.resumed.Inc()
+	}
 	return tlsConn, nil
 }
 
@@ -88,12 +107,13 @@ func (t *Trojan) StreamWebsocketConn(conn net.Conn, wsOptions *WebsocketOption)
 		alpn = t.option.ALPN
 	}
 
-	tlsConfig := &tls.Config{
+	tlsConfig := t.option.ClientCert.Apply(&tls.Config{
 		NextProtos:         alpn,
 		MinVersion:         tls.VersionTLS12,
 		InsecureSkipVerify: t.option.SkipCertVerify,
 		ServerName:         t.option.ServerName,
-	}
+		ClientSessionCache: t.option.ClientSessionCache,
+	})
 
 	return vmess.StreamWebsocketConn(conn, &vmess.WebsocketConfig{
 		Host:      wsOptions.Host,
@@ -197,7 +217,7 @@ func ReadPacket(r io.Reader, payload []byte) (net.Addr, int, int, error) {
 }
 
 func New(option *Option) *Trojan {
-	return &Trojan{option, hexSha224([]byte(option.Password))}
+	return &Trojan{option: option, hexPassword: hexSha224([]byte(option.Password))}
 }
 
 type PacketConn struct {
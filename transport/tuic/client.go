@@ -0,0 +1,347 @@
+package tuic
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/transport/quicconn"
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	RelayNative = "native"
+	RelayQUIC   = "quic"
+
+	udpQueueSize     = 64
+	heartbeatDefault = 10 * time.Second
+)
+
+var errClosed = errors.New("tuic connection closed")
+
+type Option struct {
+	UUID           [16]byte
+	Password       string
+	ServerName     string
+	SkipCertVerify bool
+	ALPN           []string
+	// UDPRelayMode sends the udp packets as datagrams with native, or a stream
+	// each with quic
+	UDPRelayMode      string
+	HeartbeatInterval time.Duration
+}
+
+// Client is an authenticated connection to a tuic server, the tcp streams and the
+// udp associations share it
+type Client struct {
+	conn   quic.Connection
+	option Option
+
+	mux    sync.Mutex
+	assocs map[uint16]*PacketConn
+	nextID uint16
+}
+
+// NewClient dials the server on pc and authenticates, pc belongs to the client afterwards
+func NewClient(ctx context.Context, pc net.PacketConn, addr net.Addr, opt Option) (*Client, error) {
+	alpn := opt.ALPN
+	if len(alpn) == 0 {
+		alpn = []string{"h3"}
+	}
+	conn, err := quic.Dial(ctx, pc, addr, &tls.Config{
+		ServerName:         opt.ServerName,
+		InsecureSkipVerify: opt.SkipCertVerify,
+		NextProtos:         alpn,
+	}, quicconn.ClientConfig())
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	if err := authenticate(conn, opt); err != nil {
+		conn.CloseWithError(0, "")
+		pc.Close()
+		return nil, fmt.Errorf("tuic authentication: %w", err)
+	}
+
+	if opt.HeartbeatInterval <= 0 {
+		opt.HeartbeatInterval = heartbeatDefault
+	}
+	c := &Client{conn: conn, option: opt, assocs: map[uint16]*PacketConn{}}
+	go func() {
+		<-conn.Context().Done()
+		pc.Close()
+	}()
+	go c.receiveDatagrams()
+	go c.receiveStreams()
+	go c.heartbeat()
+	return c, nil
+}
+
+func authenticate(conn quic.Connection, opt Option) error {
+	token, err := Token(conn.ConnectionState().TLS, opt.UUID, opt.Password)
+	if err != nil {
+		return err
+	}
+	s, err := conn.OpenUniStream()
+	if err != nil {
+		return err
+	}
+	if _, err := s.Write((&Authenticate{UUID: opt.UUID, Token: token}).Bytes()); err != nil {
+		return err
+	}
+	return s.Close()
+}
+
+// Done is closed with the connection
+func (c *Client) Done() <-chan struct{} {
+	return c.conn.Context().Done()
+}
+
+func (c *Client) Close() error {
+	return c.conn.CloseWithError(0, "")
+}
+
+// Ping sends a heartbeat, the server acknowledges it
+func (c *Client) Ping() error {
+	return c.conn.SendDatagram(HeartbeatBytes())
+}
+
+// DialTCP opens a stream to addr, the server doesn't answer before relaying
+func (c *Client) DialTCP(ctx context.Context, addr socks5.Addr) (net.Conn, error) {
+	s, err := c.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.Write(ConnectBytes(addr)); err != nil {
+		s.CancelRead(0)
+		s.Close()
+		return nil, err
+	}
+	return quicconn.NewStreamConn(s, c.conn.LocalAddr(), c.conn.RemoteAddr(), nil), nil
+}
+
+// ListenUDP opens a udp association
+func (c *Client) ListenUDP() (*PacketConn, error) {
+	select {
+	case <-c.Done():
+		return nil, errClosed
+	default:
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for {
+		c.nextID++
+		if _, ok := c.assocs[c.nextID]; !ok {
+			break
+		}
+	}
+	pc := &PacketConn{
+		client: c,
+		id:     c.nextID,
+		in:     make(chan *Packet, udpQueueSize),
+		closed: make(chan struct{}),
+	}
+	c.assocs[pc.id] = pc
+	return pc, nil
+}
+
+func (c *Client) receiveDatagrams() {
+	for {
+		b, err := c.conn.ReceiveDatagram(context.Background())
+		if err != nil {
+			return
+		}
+		c.handlePacket(bytes.NewReader(b))
+	}
+}
+
+func (c *Client) receiveStreams() {
+	for {
+		s, err := c.conn.AcceptUniStream(context.Background())
+		if err != nil {
+			return
+		}
+		go c.handlePacket(s)
+	}
+}
+
+func (c *Client) handlePacket(r io.Reader) {
+	cmd, err := ReadCommand(r)
+	if err != nil || cmd != CommandPacket {
+		return
+	}
+	p, err := ReadPacket(r)
+	if err != nil {
+		return
+	}
+	c.mux.Lock()
+	pc := c.assocs[p.AssocID]
+	c.mux.Unlock()
+	if pc != nil {
+		pc.feed(p)
+	}
+}
+
+func (c *Client) heartbeat() {
+	ticker := time.NewTicker(c.option.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mux.Lock()
+			active := len(c.assocs) != 0
+			c.mux.Unlock()
+			if active {
+				c.conn.SendDatagram(HeartbeatBytes())
+			}
+		case <-c.Done():
+			return
+		}
+	}
+}
+
+// SendPacket sends p on conn in the relay mode
+func SendPacket(conn quic.Connection, mode string, p *Packet) error {
+	if mode == RelayQUIC {
+		s, err := conn.OpenUniStream()
+		if err != nil {
+			return err
+		}
+		if _, err := s.Write(p.Bytes()); err != nil {
+			s.CancelWrite(0)
+			return err
+		}
+		return s.Close()
+	}
+
+	frags := p.Fragment(quicconn.MaxDatagramSize)
+	if len(frags) == 0 {
+		return errors.New("packet too large")
+	}
+	for _, frag := range frags {
+		if err := conn.SendDatagram(frag.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PacketConn is a udp association of a Client
+type PacketConn struct {
+	client   *Client
+	id       uint16
+	packetID uint16
+	defrag   Defragger
+	in       chan *Packet
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	mux      sync.Mutex
+	deadline time.Time
+}
+
+func (pc *PacketConn) feed(p *Packet) {
+	pc.mux.Lock()
+	full := pc.defrag.Feed(p)
+	pc.mux.Unlock()
+	if full == nil {
+		return
+	}
+	select {
+	case pc.in <- full:
+	default:
+	}
+}
+
+func (pc *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	pc.mux.Lock()
+	deadline := pc.deadline
+	pc.mux.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		select {
+		case p := <-pc.in:
+			udpAddr := p.Addr.UDPAddr()
+			if udpAddr == nil {
+				continue
+			}
+			return copy(b, p.Data), udpAddr, nil
+		case <-pc.closed:
+			return 0, nil, net.ErrClosed
+		case <-pc.client.Done():
+			return 0, nil, errClosed
+		case <-timeout:
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (pc *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	target := socks5.ParseAddr(addr.String())
+	if target == nil {
+		return 0, fmt.Errorf("invalid address %s", addr.String())
+	}
+	pc.mux.Lock()
+	pc.packetID++
+	id := pc.packetID
+	pc.mux.Unlock()
+
+	p := &Packet{AssocID: pc.id, PacketID: id, FragTotal: 1, Addr: target, Data: b}
+	if err := SendPacket(pc.client.conn, pc.client.option.UDPRelayMode, p); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close dissociates, the server drops the udp session
+func (pc *PacketConn) Close() error {
+	pc.closeOnce.Do(func() {
+		close(pc.closed)
+		c := pc.client
+		c.mux.Lock()
+		delete(c.assocs, pc.id)
+		c.mux.Unlock()
+		if s, err := c.conn.OpenUniStream(); err == nil {
+			s.Write(DissociateBytes(pc.id))
+			s.Close()
+		}
+	})
+	return nil
+}
+
+func (pc *PacketConn) LocalAddr() net.Addr {
+	return pc.client.conn.LocalAddr()
+}
+
+func (pc *PacketConn) SetDeadline(t time.Time) error {
+	return pc.SetReadDeadline(t)
+}
+
+func (pc *PacketConn) SetReadDeadline(t time.Time) error {
+	pc.mux.Lock()
+	pc.deadline = t
+	pc.mux.Unlock()
+	return nil
+}
+
+func (pc *PacketConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
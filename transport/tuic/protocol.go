@@ -0,0 +1,275 @@
+package tuic
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/Dreamacro/clash/transport/socks5"
+)
+
+// Version is the tuic version spoken, v5
+const Version = 0x05
+
+const (
+	CommandAuthenticate = 0x00
+	CommandConnect      = 0x01
+	CommandPacket       = 0x02
+	CommandDissociate   = 0x03
+	CommandHeartbeat    = 0x04
+)
+
+const (
+	addrNone   = 0xff
+	addrDomain = 0x00
+	addrIPv4   = 0x01
+	addrIPv6   = 0x02
+
+	tokenLength = 32
+)
+
+var errInvalidCommand = errors.New("invalid tuic command")
+
+// Token is the credential of the authenticate command, the keying material
+// exported from the tls session of the connection
+func Token(state tls.ConnectionState, uuid [16]byte, password string) ([]byte, error) {
+	return state.ExportKeyingMaterial(string(uuid[:]), []byte(password), tokenLength)
+}
+
+// ReadCommand reads the version and the type of a command
+func ReadCommand(r io.Reader) (byte, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	if b[0] != Version {
+		return 0, fmt.Errorf("unsupported tuic version %d", b[0])
+	}
+	return b[1], nil
+}
+
+// Authenticate is the first command of a connection, on a unidirectional stream
+type Authenticate struct {
+	UUID  [16]byte
+	Token []byte
+}
+
+func (a *Authenticate) Bytes() []byte {
+	b := make([]byte, 0, 2+16+tokenLength)
+	b = append(b, Version, CommandAuthenticate)
+	b = append(b, a.UUID[:]...)
+	return append(b, a.Token...)
+}
+
+// ReadAuthenticate reads an authenticate command after its type
+func ReadAuthenticate(r io.Reader) (*Authenticate, error) {
+	b := make([]byte, 16+tokenLength)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	a := &Authenticate{Token: b[16:]}
+	copy(a.UUID[:], b)
+	return a, nil
+}
+
+// ConnectBytes is the header of a bidirectional stream relaying tcp to addr
+func ConnectBytes(addr socks5.Addr) []byte {
+	return append([]byte{Version, CommandConnect}, encodeAddr(addr)...)
+}
+
+// DissociateBytes ends the udp session of an association
+func DissociateBytes(assocID uint16) []byte {
+	return binary.BigEndian.AppendUint16([]byte{Version, CommandDissociate}, assocID)
+}
+
+// HeartbeatBytes keeps a connection with udp sessions alive
+func HeartbeatBytes() []byte {
+	return []byte{Version, CommandHeartbeat}
+}
+
+// ReadAssocID reads the association of a dissociate command after its type
+func ReadAssocID(r io.Reader) (uint16, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b[:]), nil
+}
+
+// ReadAddress reads an address, nil for none
+func ReadAddress(r io.Reader) (socks5.Addr, error) {
+	var tp [1]byte
+	if _, err := io.ReadFull(r, tp[:]); err != nil {
+		return nil, err
+	}
+
+	var addr socks5.Addr
+	switch tp[0] {
+	case addrNone:
+		return nil, nil
+	case addrDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return nil, err
+		}
+		addr = make([]byte, 2+int(l[0])+2)
+		addr[0], addr[1] = socks5.AtypDomainName, l[0]
+	case addrIPv4:
+		addr = make([]byte, 1+net.IPv4len+2)
+		addr[0] = socks5.AtypIPv4
+	case addrIPv6:
+		addr = make([]byte, 1+net.IPv6len+2)
+		addr[0] = socks5.AtypIPv6
+	default:
+		return nil, fmt.Errorf("invalid tuic address type %d", tp[0])
+	}
+
+	start := 1
+	if addr[0] == socks5.AtypDomainName {
+		start = 2
+	}
+	if _, err := io.ReadFull(r, addr[start:]); err != nil {
+		return nil, err
+	}
+	return addr, nil
+}
+
+// encodeAddr turns a socks address into the tuic encoding, which differs in the type byte
+func encodeAddr(addr socks5.Addr) []byte {
+	if len(addr) == 0 {
+		return []byte{addrNone}
+	}
+	b := append([]byte(nil), addr...)
+	switch addr[0] {
+	case socks5.AtypDomainName:
+		b[0] = addrDomain
+	case socks5.AtypIPv4:
+		b[0] = addrIPv4
+	case socks5.AtypIPv6:
+		b[0] = addrIPv6
+	}
+	return b
+}
+
+// Packet is a udp packet of an association, a payload larger than a datagram is split
+// into fragments, only the first of them carries the address
+type Packet struct {
+	AssocID   uint16
+	PacketID  uint16
+	FragTotal uint8
+	FragID    uint8
+	Addr      socks5.Addr
+	Data      []byte
+}
+
+func (p *Packet) Bytes() []byte {
+	addr := encodeAddr(p.Addr)
+	b := make([]byte, 0, 10+len(addr)+len(p.Data))
+	b = append(b, Version, CommandPacket)
+	b = binary.BigEndian.AppendUint16(b, p.AssocID)
+	b = binary.BigEndian.AppendUint16(b, p.PacketID)
+	b = append(b, p.FragTotal, p.FragID)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(p.Data)))
+	b = append(b, addr...)
+	return append(b, p.Data...)
+}
+
+// ReadPacket reads a packet command after its type
+func ReadPacket(r io.Reader) (*Packet, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, err
+	}
+	p := &Packet{
+		AssocID:   binary.BigEndian.Uint16(b[0:]),
+		PacketID:  binary.BigEndian.Uint16(b[2:]),
+		FragTotal: b[4],
+		FragID:    b[5],
+	}
+	if p.FragTotal == 0 || p.FragID >= p.FragTotal {
+		return nil, errInvalidCommand
+	}
+	size := binary.BigEndian.Uint16(b[6:])
+	addr, err := ReadAddress(r)
+	if err != nil {
+		return nil, err
+	}
+	p.Addr = addr
+	p.Data = make([]byte, size)
+	if _, err := io.ReadFull(r, p.Data); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Fragment splits p so every fragment encodes within maxSize
+func (p *Packet) Fragment(maxSize int) []*Packet {
+	if len(p.Bytes()) <= maxSize {
+		return []*Packet{p}
+	}
+	room := maxSize - 10 - len(encodeAddr(p.Addr))
+	if room <= 0 {
+		return nil
+	}
+	total := (len(p.Data) + room - 1) / room
+	if total > 255 {
+		return nil
+	}
+	frags := make([]*Packet, 0, total)
+	for i, off := 0, 0; off < len(p.Data); i, off = i+1, off+room {
+		end := off + room
+		if end > len(p.Data) {
+			end = len(p.Data)
+		}
+		frag := *p
+		frag.FragTotal, frag.FragID = uint8(total), uint8(i)
+		frag.Data = p.Data[off:end]
+		if i != 0 {
+			frag.Addr = nil
+		}
+		frags = append(frags, &frag)
+	}
+	return frags
+}
+
+// Defragger reassembles the fragments of an association, only the latest
+// packet is kept so a lost fragment costs at most one packet
+type Defragger struct {
+	packetID uint16
+	frags    [][]byte
+	addr     socks5.Addr
+	count    int
+}
+
+// Feed returns the packet once all of its fragments arrived
+func (d *Defragger) Feed(p *Packet) *Packet {
+	if p.FragTotal <= 1 {
+		return p
+	}
+	if d.frags == nil || p.PacketID != d.packetID || len(d.frags) != int(p.FragTotal) {
+		d.packetID = p.PacketID
+		d.frags = make([][]byte, p.FragTotal)
+		d.addr, d.count = nil, 0
+	}
+	if d.frags[p.FragID] != nil {
+		return nil
+	}
+	d.frags[p.FragID] = append([]byte(nil), p.Data...)
+	d.count++
+	if p.FragID == 0 {
+		d.addr = p.Addr
+	}
+	if d.count != len(d.frags) {
+		return nil
+	}
+
+	full := &Packet{AssocID: p.AssocID, PacketID: p.PacketID, FragTotal: 1, Addr: d.addr}
+	for _, frag := range d.frags {
+		full.Data = append(full.Data, frag...)
+	}
+	d.frags = nil
+	return full
+}
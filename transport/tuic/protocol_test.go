@@ -0,0 +1,87 @@
+package tuic
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Dreamacro/clash/transport/socks5"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddress(t *testing.T) {
+	for _, s := range []string{"example.com:443", "1.1.1.1:53", "[2001:db8::1]:8080"} {
+		addr := socks5.ParseAddr(s)
+		b := encodeAddr(addr)
+
+		parsed, err := ReadAddress(bytes.NewReader(b))
+		assert.NoError(t, err)
+		assert.Equal(t, s, parsed.String())
+	}
+
+	// the domain and ipv6 types differ from socks
+	assert.Equal(t, byte(addrDomain), encodeAddr(socks5.ParseAddr("example.com:443"))[0])
+	assert.Equal(t, byte(addrIPv6), encodeAddr(socks5.ParseAddr("[::1]:443"))[0])
+
+	none, err := ReadAddress(bytes.NewReader(encodeAddr(nil)))
+	assert.NoError(t, err)
+	assert.Nil(t, none)
+
+	_, err = ReadAddress(bytes.NewReader([]byte{0x03}))
+	assert.Error(t, err)
+}
+
+func TestCommand(t *testing.T) {
+	r := bytes.NewReader(ConnectBytes(socks5.ParseAddr("example.com:80")))
+	cmd, err := ReadCommand(r)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(CommandConnect), cmd)
+	addr, err := ReadAddress(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com:80", addr.String())
+
+	auth := &Authenticate{UUID: [16]byte{1, 2, 3}, Token: bytes.Repeat([]byte{9}, tokenLength)}
+	r = bytes.NewReader(auth.Bytes())
+	cmd, err = ReadCommand(r)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(CommandAuthenticate), cmd)
+	parsed, err := ReadAuthenticate(r)
+	assert.NoError(t, err)
+	assert.Equal(t, auth, parsed)
+
+	r = bytes.NewReader(DissociateBytes(513))
+	_, err = ReadCommand(r)
+	assert.NoError(t, err)
+	id, err := ReadAssocID(r)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(513), id)
+
+	_, err = ReadCommand(bytes.NewReader([]byte{0x04, CommandHeartbeat}))
+	assert.Error(t, err)
+}
+
+func TestPacket_Fragment(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 300)
+	p := &Packet{AssocID: 1, PacketID: 5, FragTotal: 1, Addr: socks5.ParseAddr("1.1.1.1:53"), Data: data}
+	frags := p.Fragment(1200)
+	require.Len(t, frags, 3)
+	assert.Nil(t, frags[1].Addr)
+
+	d := &Defragger{}
+	var full *Packet
+	for i := len(frags) - 1; i >= 0; i-- {
+		b := frags[i].Bytes()
+		assert.LessOrEqual(t, len(b), 1200)
+		r := bytes.NewReader(b)
+		cmd, err := ReadCommand(r)
+		require.NoError(t, err)
+		require.Equal(t, byte(CommandPacket), cmd)
+		parsed, err := ReadPacket(r)
+		require.NoError(t, err)
+		full = d.Feed(parsed)
+	}
+	require.NotNil(t, full)
+	assert.Equal(t, data, full.Data)
+	assert.Equal(t, "1.1.1.1:53", full.Addr.String())
+}
@@ -0,0 +1,96 @@
+package obfs
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Dreamacro/clash/transport/quicconn"
+
+	"github.com/quic-go/quic-go"
+	"golang.org/x/time/rate"
+)
+
+// quicALPN are the protocols the quic transport of v2ray offers with its default tls
+var quicALPN = []string{"h2", "http/1.1"}
+
+// QUICOption is the quic mode of the plugin, the certificate options of Option apply to
+// its tls
+type QUICOption struct {
+	// Up paces the sending to bytes per second under the congestion control of quic,
+	// zero leaves it to the latter
+	Up uint64
+	// ReceiveWindow fixes the flow control windows of the connection and of its streams
+	// in bytes, zero lets quic grow them
+	ReceiveWindow uint64
+	IdleTimeout   time.Duration
+}
+
+// QUICClient is a quic connection to the plugin, the proxied connections are its streams
+type QUICClient struct {
+	conn  quic.Connection
+	mux   bool
+	pacer *rate.Limiter
+}
+
+// DialQUIC dials the plugin on pc, pc belongs to the client afterwards
+func DialQUIC(ctx context.Context, pc net.PacketConn, addr net.Addr, option *Option, quicOption *QUICOption) (*QUICClient, error) {
+	config := quicconn.ClientConfig()
+	if quicOption.IdleTimeout > 0 {
+		config.MaxIdleTimeout = quicOption.IdleTimeout
+	}
+	if window := quicOption.ReceiveWindow; window > 0 {
+		config.InitialStreamReceiveWindow = window
+		config.MaxStreamReceiveWindow = window
+		config.InitialConnectionReceiveWindow = window
+		config.MaxConnectionReceiveWindow = window
+	}
+
+	conn, err := quic.Dial(ctx, pc, addr, tlsConfig(option, quicALPN), config)
+	if err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("v2ray-plugin quic handshake: %w", err)
+	}
+	go func() {
+		<-conn.Context().Done()
+		pc.Close()
+	}()
+	return &QUICClient{
+		conn:  conn,
+		mux:   option.Mux,
+		pacer: quicconn.NewPacer(quicOption.Up),
+	}, nil
+}
+
+// Dial opens the stream of a proxied connection
+func (c *QUICClient) Dial(ctx context.Context) (net.Conn, error) {
+	s, err := c.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("v2ray-plugin quic stream: %w", err)
+	}
+	var conn net.Conn = quicconn.NewStreamConn(s, c.conn.LocalAddr(), c.conn.RemoteAddr(), c.pacer)
+	if c.mux {
+		conn = newMux(conn)
+	}
+	return conn, nil
+}
+
+// Done is closed with the connection
+func (c *QUICClient) Done() <-chan struct{} {
+	return c.conn.Context().Done()
+}
+
+func (c *QUICClient) Close() error {
+	return c.conn.CloseWithError(0, "")
+}
+
+// Ping opens and finishes an empty stream, the server acknowledges it
+func (c *QUICClient) Ping() error {
+	s, err := c.conn.OpenStream()
+	if err != nil {
+		return err
+	}
+	s.CancelRead(0)
+	return s.Close()
+}
@@ -0,0 +1,149 @@
+package obfs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newQUICServer serves each stream of a connection with handle, it return the address and
+// the certificate of the server
+func newQUICServer(t *testing.T, handle func(quic.Stream)) (*net.UDPAddr, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "v2ray"},
+		DNSNames:              []string{"v2ray"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pair := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+
+	ln, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		NextProtos:   []string{"h2"},
+	}, &quic.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					s, err := conn.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+					go handle(s)
+				}
+			}()
+		}
+	}()
+	return ln.Addr().(*net.UDPAddr), cert
+}
+
+func dialQUIC(t *testing.T, addr net.Addr, option *Option) (*QUICClient, error) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return DialQUIC(ctx, pc, addr, option, &QUICOption{Up: 1 << 20, ReceiveWindow: 1 << 20})
+}
+
+func TestQUIC(t *testing.T) {
+	addr, cert := newQUICServer(t, func(s quic.Stream) {
+		io.Copy(s, s)
+		s.Close()
+	})
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	client, err := dialQUIC(t, addr, &Option{Host: "v2ray", TLS: true, RootCAs: pool})
+	require.NoError(t, err)
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		conn, err := client.Dial(context.Background())
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(buf))
+		conn.Close()
+	}
+	assert.NoError(t, client.Ping())
+
+	// the handshake error names the plugin
+	sum := sha256.Sum256([]byte("another certificate"))
+	_, err = dialQUIC(t, addr, &Option{Host: "v2ray", TLS: true, Fingerprint: sum[:]})
+	assert.ErrorContains(t, err, "v2ray-plugin quic handshake")
+	assert.ErrorContains(t, err, "fingerprint mismatch")
+}
+
+func TestQUIC_Mux(t *testing.T) {
+	frames := make(chan []byte, 2)
+	addr, _ := newQUICServer(t, func(s quic.Stream) {
+		length := make([]byte, 2)
+		// the new session, then the data of the session
+		for i := 0; i < 2; i++ {
+			if _, err := io.ReadFull(s, length); err != nil {
+				return
+			}
+			frame := make([]byte, binary.BigEndian.Uint16(length))
+			if _, err := io.ReadFull(s, frame); err != nil {
+				return
+			}
+			frames <- frame
+		}
+		data := make([]byte, 6)
+		io.ReadFull(s, data)
+		s.Write([]byte{0, 4, 0, 0, SessionStatusKeep, OptionData, 0, 4})
+		s.Write([]byte("pong"))
+	})
+
+	client, err := dialQUIC(t, addr, &Option{Host: "v2ray", TLS: true, SkipCertVerify: true, Mux: true})
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := client.Dial(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	assert.Equal(t, SessionStatusNew, (<-frames)[2])
+	keep := <-frames
+	assert.Equal(t, []byte{0, 0, SessionStatusKeep, OptionData}, keep)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(buf))
+}
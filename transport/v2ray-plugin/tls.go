@@ -0,0 +1,55 @@
+package obfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadCertificate accepts a PEM encoded certificate or the path to a PEM file,
+// the certificates are used as the only trusted roots
+func LoadCertificate(certificate string) (*x509.CertPool, error) {
+	data := []byte(certificate)
+	if !strings.Contains(certificate, "-----BEGIN") {
+		var err error
+		if data, err = os.ReadFile(certificate); err != nil {
+			return nil, err
+		}
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no valid certificate found")
+	}
+	return pool, nil
+}
+
+// ParseFingerprint parses a hex SHA-256 fingerprint, colons are allowed between bytes
+func ParseFingerprint(fingerprint string) ([]byte, error) {
+	fp, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", ""))
+	if err != nil {
+		return nil, err
+	}
+	if len(fp) != sha256.Size {
+		return nil, fmt.Errorf("fingerprint length %d, expected %d bytes of SHA-256", len(fp), sha256.Size)
+	}
+	return fp, nil
+}
+
+func verifyFingerprint(fingerprint []byte) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no certificate presented")
+		}
+		sum := sha256.Sum256(rawCerts[0])
+		if !bytes.Equal(sum[:], fingerprint) {
+			return fmt.Errorf("certificate fingerprint mismatch: %x", sum)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,96 @@
+package obfs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCertificate(t *testing.T, name string) (der []byte, certPEM string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err = x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return der, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestLoadCertificate(t *testing.T) {
+	der, certPEM := newCertificate(t, "v2ray")
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool, err := LoadCertificate(certPEM)
+	require.NoError(t, err)
+	_, err = cert.Verify(x509.VerifyOptions{Roots: pool})
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(path, []byte(certPEM), 0o600))
+	pool, err = LoadCertificate(path)
+	require.NoError(t, err)
+	_, err = cert.Verify(x509.VerifyOptions{Roots: pool})
+	assert.NoError(t, err)
+
+	_, err = LoadCertificate(path + ".missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = LoadCertificate("-----BEGIN CERTIFICATE-----\nbm90IGEgY2VydA==\n-----END CERTIFICATE-----\n")
+	assert.ErrorContains(t, err, "no valid certificate found")
+}
+
+func TestParseFingerprint(t *testing.T) {
+	sum := sha256.Sum256([]byte("certificate"))
+	plain := hex.EncodeToString(sum[:])
+	// the AB:CD:... form of openssl
+	pairs := []string{}
+	for i := 0; i < len(plain); i += 2 {
+		pairs = append(pairs, strings.ToUpper(plain[i:i+2]))
+	}
+	colons := strings.Join(pairs, ":")
+
+	for _, fingerprint := range []string{plain, colons} {
+		fp, err := ParseFingerprint(fingerprint)
+		if assert.NoError(t, err, fingerprint) {
+			assert.Equal(t, sum[:], fp)
+		}
+	}
+
+	_, err := ParseFingerprint("not hex")
+	assert.Error(t, err)
+	_, err = ParseFingerprint(plain[:40])
+	assert.ErrorContains(t, err, "fingerprint length 20, expected 32 bytes of SHA-256")
+}
+
+func TestVerifyFingerprint(t *testing.T) {
+	der, _ := newCertificate(t, "v2ray")
+	other, _ := newCertificate(t, "other")
+	sum := sha256.Sum256(der)
+	verify := verifyFingerprint(sum[:])
+
+	// the leaf is checked, the rest of the chain isn't
+	assert.NoError(t, verify([][]byte{der}, nil))
+	assert.NoError(t, verify([][]byte{der, other}, nil))
+	assert.ErrorContains(t, verify([][]byte{other, der}, nil), "certificate fingerprint mismatch")
+	assert.ErrorContains(t, verify(nil, nil), "no certificate presented")
+}
@@ -2,6 +2,8 @@ package obfs
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"net"
 	"net/http"
 
@@ -17,6 +19,11 @@ type Option struct {
 	TLS            bool
 	SkipCertVerify bool
 	Mux            bool
+
+	// RootCAs replaces the system roots when set
+	RootCAs *x509.CertPool
+	// Fingerprint pins the SHA-256 of the server leaf certificate, chain verification is skipped
+	Fingerprint []byte
 }
 
 // NewV2rayObfs return a HTTPObfs
@@ -35,11 +42,7 @@ func NewV2rayObfs(conn net.Conn, option *Option) (net.Conn, error) {
 
 	if option.TLS {
 		config.TLS = true
-		config.TLSConfig = &tls.Config{
-			ServerName:         option.Host,
-			InsecureSkipVerify: option.SkipCertVerify,
-			NextProtos:         []string{"http/1.1"},
-		}
+		config.TLSConfig = tlsConfig(option, []string{"http/1.1"})
 		if host := config.Headers.Get("Host"); host != "" {
 			config.TLSConfig.ServerName = host
 		}
@@ -48,15 +51,35 @@ func NewV2rayObfs(conn net.Conn, option *Option) (net.Conn, error) {
 	var err error
 	conn, err = vmess.StreamWebsocketConn(conn, config)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("v2ray-plugin handshake: %w", err)
 	}
 
 	if option.Mux {
-		conn = NewMux(conn, MuxOption{
-			ID:   [2]byte{0, 0},
-			Host: "127.0.0.1",
-			Port: 0,
-		})
+		conn = newMux(conn)
 	}
 	return conn, nil
 }
+
+// tlsConfig is the tls of the websocket with tls and of quic
+func tlsConfig(option *Option, nextProtos []string) *tls.Config {
+	config := &tls.Config{
+		ServerName:         option.Host,
+		InsecureSkipVerify: option.SkipCertVerify,
+		NextProtos:         nextProtos,
+		RootCAs:            option.RootCAs,
+	}
+	if len(option.Fingerprint) != 0 {
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = verifyFingerprint(option.Fingerprint)
+	}
+	return config
+}
+
+// newMux opens the only session of the mux of the plugin on conn
+func newMux(conn net.Conn) net.Conn {
+	return NewMux(conn, MuxOption{
+		ID:   [2]byte{0, 0},
+		Host: "127.0.0.1",
+		Port: 0,
+	})
+}
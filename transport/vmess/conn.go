@@ -15,6 +15,8 @@ import (
 	"net"
 	"time"
 
+	"github.com/Dreamacro/clash/component/ntp"
+
 	"github.com/Dreamacro/protobytes"
 	"golang.org/x/crypto/chacha20poly1305"
 )
@@ -55,7 +57,7 @@ func (vc *Conn) Read(b []byte) (int, error) {
 }
 
 func (vc *Conn) sendRequest() error {
-	timestamp := time.Now()
+	timestamp := ntp.Now()
 
 	mbuf := protobytes.BytesWriter{}
 
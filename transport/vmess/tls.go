@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"net"
 
+	"github.com/Dreamacro/clash/component/clientcert"
 	C "github.com/Dreamacro/clash/constant"
 )
 
@@ -12,14 +13,15 @@ type TLSConfig struct {
 	Host           string
 	SkipCertVerify bool
 	NextProtos     []string
+	ClientCert     *clientcert.Certificate
 }
 
 func StreamTLSConn(conn net.Conn, cfg *TLSConfig) (net.Conn, error) {
-	tlsConfig := &tls.Config{
+	tlsConfig := cfg.ClientCert.Apply(&tls.Config{
 		ServerName:         cfg.Host,
 		InsecureSkipVerify: cfg.SkipCertVerify,
 		NextProtos:         cfg.NextProtos,
-	}
+	})
 
 	tlsConn := tls.Client(conn, tlsConfig)
 
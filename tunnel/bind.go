@@ -0,0 +1,75 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Dreamacro/clash/adapter"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+	"github.com/Dreamacro/clash/tunnel/statistic"
+)
+
+var errBindNotSupported = errors.New("proxy doesn't accept inbound connections")
+
+// binderOf unwraps the groups of proxy down to the adapter accepting the connection,
+// the groups are returned from the outermost
+func binderOf(proxy C.Proxy, metadata *C.Metadata) (C.Binder, []C.Proxy) {
+	groups := []C.Proxy{}
+	for {
+		next := proxy.Unwrap(metadata)
+		if next == nil {
+			break
+		}
+		groups = append(groups, proxy)
+		proxy = next
+	}
+
+	p, ok := proxy.(*adapter.Proxy)
+	if !ok {
+		return nil, nil
+	}
+	binder, _ := p.ProxyAdapter.(C.Binder)
+	return binder, groups
+}
+
+func handleBind(req C.BindRequest, metadata *C.Metadata, proxy C.Proxy, rule C.Rule) {
+	binder, groups := binderOf(proxy, metadata)
+	if binder == nil {
+		log.Warnln("[TCP] bind %s <-- %s rejected, %s: %s", metadata.SourceAddress(), metadata.RemoteAddress(), proxy.Name(), errBindNotSupported.Error())
+		req.Reject(errBindNotSupported)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), C.DefaultTCPTimeout)
+	ln, err := binder.ListenContext(ctx, metadata, req.BindAddress())
+	cancel()
+	if err != nil {
+		log.Warnln("[TCP] bind %s <-- %s listen error: %s", metadata.SourceAddress(), metadata.RemoteAddress(), err.Error())
+		req.Reject(err)
+		return
+	}
+
+	peer, err := req.Accept(ln)
+	if err != nil {
+		log.Debugln("[TCP] bind %s <-- %s at %s: %s", metadata.SourceAddress(), metadata.RemoteAddress(), ln.Addr().String(), err.Error())
+		return
+	}
+	for i := len(groups) - 1; i >= 0; i-- {
+		peer.AppendToChains(groups[i])
+	}
+
+	remoteConn := statistic.NewTCPTracker(peer, statistic.DefaultManager, metadata, rule, req)
+	defer remoteConn.Close()
+
+	log.Infoln(
+		"[TCP] %s <-- %s bind at %s match %s using %s",
+		metadata.SourceAddress(),
+		peer.RemoteAddr().String(),
+		ln.Addr().String(),
+		ruleString(rule),
+		remoteConn.Chains().String(),
+	)
+
+	handleSocket(req, remoteConn)
+}
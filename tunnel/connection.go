@@ -1,9 +1,12 @@
 package tunnel
 
 import (
+	"context"
 	"errors"
+	"io"
 	"net"
 	"net/netip"
+	"syscall"
 	"time"
 
 	N "github.com/Dreamacro/clash/common/net"
@@ -11,6 +14,16 @@ import (
 	C "github.com/Dreamacro/clash/constant"
 )
 
+// sessionBroken reports whether a write error means the packet conn can't be used anymore,
+// e.g. the stream carrying a vmess or trojan udp session was closed or reset
+func sessionBroken(err error) bool {
+	return errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
 func handleUDPToRemote(packet C.UDPPacket, pc C.PacketConn, metadata *C.Metadata) error {
 	addr := metadata.UDPAddr()
 	if addr == nil {
@@ -20,16 +33,25 @@ func handleUDPToRemote(packet C.UDPPacket, pc C.PacketConn, metadata *C.Metadata
 	if _, err := pc.WriteTo(packet.Data(), addr); err != nil {
 		return err
 	}
+	if batch, ok := packet.(C.UDPPacketBatch); ok {
+		for _, payload := range batch.Batch() {
+			if _, err := pc.WriteTo(payload, addr); err != nil {
+				return err
+			}
+		}
+	}
 	// reset timeout
 	pc.SetReadDeadline(time.Now().Add(udpTimeout))
 
 	return nil
 }
 
-func handleUDPToLocal(packet C.UDPPacket, pc net.PacketConn, key string, oAddr, fAddr netip.Addr) {
+// handleUDPToLocal relays replies back to the client, replies from oAddr are
+// rewritten to rAddr when it is valid, its port is only restored when not zero
+func handleUDPToLocal(packet C.UDPPacket, pc C.PacketConn, key string, oAddr, rAddr netip.AddrPort) {
 	buf := pool.Get(pool.UDPBufferSize)
 	defer pool.Put(buf)
-	defer natTable.Delete(key)
+	defer natTable.DeleteIfEqual(key, pc)
 	defer pc.Close()
 
 	for {
@@ -40,11 +62,14 @@ func handleUDPToLocal(packet C.UDPPacket, pc net.PacketConn, key string, oAddr,
 		}
 
 		fromUDPAddr := *from.(*net.UDPAddr)
-		if fAddr.IsValid() {
+		if rAddr.IsValid() {
 			fromAddr, _ := netip.AddrFromSlice(fromUDPAddr.IP)
 			fromAddr = fromAddr.Unmap()
-			if oAddr == fromAddr {
-				fromUDPAddr.IP = fAddr.AsSlice()
+			if oAddr.Addr() == fromAddr {
+				fromUDPAddr.IP = rAddr.Addr().AsSlice()
+				if rAddr.Port() != 0 && uint16(fromUDPAddr.Port) == oAddr.Port() {
+					fromUDPAddr.Port = int(rAddr.Port())
+				}
 			}
 		}
 
@@ -55,6 +80,79 @@ func handleUDPToLocal(packet C.UDPPacket, pc net.PacketConn, key string, oAddr,
 	}
 }
 
-func handleSocket(ctx C.ConnContext, outbound net.Conn) {
-	N.Relay(ctx.Conn(), outbound)
+// leafTyper is implemented by the connections of the outbounds
+type leafTyper interface {
+	LeafType() C.AdapterType
+}
+
+// rejected reports whether conn is of REJECT, through any group
+func rejected(conn C.Conn) bool {
+	leaf, ok := conn.(leafTyper)
+	return ok && leaf.LeafType() == C.Reject
+}
+
+func handleSocket(inbound, outbound net.Conn) {
+	N.Relay(inbound, outbound)
+}
+
+// abandonWatcher cancels a pending dial when the client closes the inbound conn,
+// the byte read while watching is replayed to the relay
+type abandonWatcher struct {
+	conn      net.Conn
+	done      chan struct{}
+	head      []byte
+	abandoned bool
+}
+
+// watchAbandon returns nil when the conn can't be interrupted by a read deadline
+func watchAbandon(conn net.Conn, cancel context.CancelFunc) *abandonWatcher {
+	if conn.SetReadDeadline(time.Time{}) != nil {
+		return nil
+	}
+
+	w := &abandonWatcher{conn: conn, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		buf := make([]byte, 1)
+		n, err := conn.Read(buf)
+		w.head = buf[:n]
+		// a client that is sending data is still there, stop watching
+		if n == 0 && err != nil && !isTimeout(err) {
+			w.abandoned = true
+			cancel()
+		}
+	}()
+	return w
+}
+
+// stop ends the watch and returns the conn to relay, its read deadline is cleared
+func (w *abandonWatcher) stop() (conn net.Conn, abandoned bool) {
+	w.conn.SetReadDeadline(time.Now())
+	<-w.done
+	w.conn.SetReadDeadline(time.Time{})
+
+	if len(w.head) != 0 {
+		return &prefixConn{Conn: w.conn, head: w.head}, false
+	}
+	return w.conn, w.abandoned
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// prefixConn replays head before reading from the Conn
+type prefixConn struct {
+	net.Conn
+	head []byte
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) {
+	if len(c.head) != 0 {
+		n := copy(b, c.head)
+		c.head = c.head[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
 }
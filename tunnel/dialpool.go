@@ -0,0 +1,106 @@
+package tunnel
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	DefaultDialPoolSize         = 4096
+	DefaultDialPoolQueueTimeout = 5 * time.Second
+)
+
+var (
+	errDialPoolFull = errors.New("dial pool is full")
+
+	dialPool = atomic.NewPointer(newDialPool(DefaultDialPoolSize, DefaultDialPoolQueueTimeout))
+
+	// kept across the pool updates
+	dialWaiting   = atomic.NewInt64(0)
+	dialRejected  = atomic.NewInt64(0)
+	dialQueueTime = atomic.NewInt64(0)
+)
+
+// DialPoolStats is a snapshot of the dial pool, QueueTime is the total time the dials
+// waited for a slot, the rejected ones included
+type DialPoolStats struct {
+	Size      int
+	Active    int
+	Waiting   int64
+	Rejected  int64
+	QueueTime time.Duration
+}
+
+// dialLimiter caps the concurrent outbound dials, a dial over it waits for a slot up to
+// timeout and is rejected after. The relays of the established connections don't count.
+type dialLimiter struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+func newDialPool(size int, timeout time.Duration) *dialLimiter {
+	if size <= 0 {
+		return nil
+	}
+	return &dialLimiter{slots: make(chan struct{}, size), timeout: timeout}
+}
+
+// UpdateDialPool replaces the dial pool, a size of 0 disables it. The dials holding
+// a slot of the old pool give it back there.
+func UpdateDialPool(size int, queueTimeout time.Duration) {
+	if p := dialPool.Load(); p != nil && cap(p.slots) == size && p.timeout == queueTimeout {
+		return
+	}
+	dialPool.Store(newDialPool(size, queueTimeout))
+}
+
+// GetDialPoolStats return the state of the dial pool
+func GetDialPoolStats() DialPoolStats {
+	stats := DialPoolStats{
+		Waiting:   dialWaiting.Load(),
+		Rejected:  dialRejected.Load(),
+		QueueTime: time.Duration(dialQueueTime.Load()),
+	}
+	if p := dialPool.Load(); p != nil {
+		stats.Size = cap(p.slots)
+		stats.Active = len(p.slots)
+	}
+	return stats
+}
+
+// acquireDial takes a slot for a dial, release gives it back once the dial is done
+func acquireDial() (release func(), err error) {
+	p := dialPool.Load()
+	if p == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+		return p.release, nil
+	default:
+	}
+
+	start := time.Now()
+	dialWaiting.Inc()
+	defer func() {
+		dialWaiting.Dec()
+		dialQueueTime.Add(int64(time.Since(start)))
+	}()
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case p.slots <- struct{}{}:
+		return p.release, nil
+	case <-timer.C:
+		dialRejected.Inc()
+		return nil, errDialPoolFull
+	}
+}
+
+func (p *dialLimiter) release() {
+	<-p.slots
+}
@@ -0,0 +1,46 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialPool(t *testing.T) {
+	defer UpdateDialPool(DefaultDialPoolSize, DefaultDialPoolQueueTimeout)
+	UpdateDialPool(1, 50*time.Millisecond)
+	rejected := GetDialPoolStats().Rejected
+
+	release, err := acquireDial()
+	require.NoError(t, err)
+	assert.Equal(t, 1, GetDialPoolStats().Active)
+
+	// the second dial waits for the slot and is rejected after the queue timeout
+	_, err = acquireDial()
+	assert.ErrorIs(t, err, errDialPoolFull)
+	stats := GetDialPoolStats()
+	assert.Equal(t, rejected+1, stats.Rejected)
+	assert.GreaterOrEqual(t, stats.QueueTime, 50*time.Millisecond)
+
+	// a released slot is taken by the waiting one
+	done := make(chan error)
+	go func() {
+		next, err := acquireDial()
+		if err == nil {
+			next()
+		}
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	release()
+	assert.NoError(t, <-done)
+	assert.Equal(t, 0, GetDialPoolStats().Active)
+
+	UpdateDialPool(0, time.Second)
+	release, err = acquireDial()
+	assert.NoError(t, err)
+	release()
+	assert.Equal(t, 0, GetDialPoolStats().Size)
+}
@@ -0,0 +1,125 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	N "github.com/Dreamacro/clash/common/net"
+	"github.com/Dreamacro/clash/component/sniff"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+
+	"go.uber.org/atomic"
+)
+
+// modes of host-mismatch, the check of the tls server name against the host of a connection
+const (
+	HostMismatchOff    = "off"
+	HostMismatchLog    = "log"
+	HostMismatchReject = "reject"
+
+	// a client hello is sent right after the handshake, a client waiting for the server
+	// to speak first is held this long
+	hostCheckTimeout = 200 * time.Millisecond
+)
+
+var (
+	hostMismatchMode = atomic.NewString(HostMismatchOff)
+
+	hostMismatchDetected atomic.Int64
+	hostMismatchRejected atomic.Int64
+)
+
+// HostMismatchStats are the counters of the host-mismatch check since the start
+type HostMismatchStats struct {
+	Detected int64
+	Rejected int64
+}
+
+// ParseHostMismatch checks a host-mismatch mode, empty is off
+func ParseHostMismatch(mode string) (string, error) {
+	switch mode {
+	case "":
+		return HostMismatchOff, nil
+	case HostMismatchOff, HostMismatchLog, HostMismatchReject:
+		return mode, nil
+	}
+	return "", fmt.Errorf("host-mismatch %s: expect off, log or reject", mode)
+}
+
+// UpdateHostMismatch sets the host-mismatch mode of the new tcp connections
+func UpdateHostMismatch(mode string) {
+	hostMismatchMode.Store(mode)
+}
+
+// GetHostMismatchStats return the counters of the host-mismatch check
+func GetHostMismatchStats() HostMismatchStats {
+	return HostMismatchStats{
+		Detected: hostMismatchDetected.Load(),
+		Rejected: hostMismatchRejected.Load(),
+	}
+}
+
+// checkHost reads the tls server name of a connection to a host, from the fake ip mapping or
+// the proxy request, and records it on the metadata. It return the conn to go on with and
+// whether the connection is to be rejected for a mismatch.
+func checkHost(conn net.Conn, metadata *C.Metadata) (net.Conn, bool) {
+	mode := hostMismatchMode.Load()
+	if mode == HostMismatchOff || metadata.NetWork != C.TCP || metadata.Host == "" {
+		return conn, false
+	}
+	// the client of a socks bind waits for the peer, the one of a DialResponder for its answer
+	if _, ok := conn.(C.BindRequest); ok {
+		return conn, false
+	}
+	if _, ok := conn.(C.DialResponder); ok {
+		return conn, false
+	}
+
+	bufConn := N.NewBufferedConn(conn)
+	serverName := peekServerName(bufConn)
+	if serverName == "" {
+		return bufConn, false
+	}
+
+	metadata.SniffHost = serverName
+	if strings.EqualFold(strings.TrimSuffix(metadata.Host, "."), serverName) {
+		return bufConn, false
+	}
+	metadata.HostMismatch = true
+	hostMismatchDetected.Inc()
+	if mode != HostMismatchReject {
+		log.Warnln("[TCP] %s --> %s tls server name %s is not the host", metadata.SourceAddress(), metadata.RemoteAddress(), serverName)
+		return bufConn, false
+	}
+	hostMismatchRejected.Inc()
+	log.Warnln("[TCP] %s --> %s rejected: tls server name %s is not the host", metadata.SourceAddress(), metadata.RemoteAddress(), serverName)
+	return bufConn, true
+}
+
+// peekServerName return the server name of the client hello at the start of conn, the
+// bytes are left in the buffer for the relay
+func peekServerName(conn *N.BufferedConn) string {
+	if conn.SetReadDeadline(time.Now().Add(hostCheckTimeout)) != nil {
+		return ""
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	header, err := conn.Peek(sniff.RecordHeaderSize)
+	if err != nil {
+		return ""
+	}
+	size, err := sniff.RecordSize(header)
+	if err != nil {
+		return ""
+	}
+	// a record larger than the buffer is read as far as it goes
+	if buffered := conn.Reader().Size(); size > buffered {
+		size = buffered
+	}
+	record, _ := conn.Peek(size)
+	serverName, _ := sniff.ServerName(record)
+	return serverName
+}
@@ -0,0 +1,94 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// helloConn return the server side of a tls client to serverName, with its client hello
+// waiting to be read
+func helloConn(t *testing.T, serverName string) net.Conn {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	go tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+	return server
+}
+
+func TestCheckHost(t *testing.T) {
+	defer UpdateHostMismatch(HostMismatchOff)
+	UpdateHostMismatch(HostMismatchLog)
+	before := GetHostMismatchStats()
+
+	metadata := &C.Metadata{NetWork: C.TCP, Host: "example.com", DstPort: "443"}
+	conn, reject := checkHost(helloConn(t, "example.com"), metadata)
+	assert.False(t, reject)
+	assert.Equal(t, "example.com", metadata.SniffHost)
+	assert.False(t, metadata.HostMismatch)
+
+	// the client hello is still there for the relay
+	head := make([]byte, 1)
+	_, err := io.ReadFull(conn, head)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x16), head[0])
+
+	metadata = &C.Metadata{NetWork: C.TCP, Host: "example.com", DstPort: "443"}
+	_, reject = checkHost(helloConn(t, "fronted.example.org"), metadata)
+	assert.False(t, reject)
+	assert.Equal(t, "fronted.example.org", metadata.SniffHost)
+	assert.True(t, metadata.HostMismatch)
+
+	UpdateHostMismatch(HostMismatchReject)
+	metadata = &C.Metadata{NetWork: C.TCP, Host: "example.com", DstPort: "443"}
+	_, reject = checkHost(helloConn(t, "fronted.example.org"), metadata)
+	assert.True(t, reject)
+
+	stats := GetHostMismatchStats()
+	assert.Equal(t, int64(2), stats.Detected-before.Detected)
+	assert.Equal(t, int64(1), stats.Rejected-before.Rejected)
+}
+
+func TestCheckHost_Skipped(t *testing.T) {
+	defer UpdateHostMismatch(HostMismatchOff)
+
+	// off doesn't touch the conn
+	_, server := net.Pipe()
+	defer server.Close()
+	conn, _ := checkHost(server, &C.Metadata{NetWork: C.TCP, Host: "example.com"})
+	assert.Equal(t, server, conn)
+
+	// a client waiting for the server is held for the timeout only
+	UpdateHostMismatch(HostMismatchReject)
+	metadata := &C.Metadata{NetWork: C.TCP, Host: "example.com", DstPort: "25"}
+	start := time.Now()
+	_, reject := checkHost(server, metadata)
+	assert.False(t, reject)
+	assert.Empty(t, metadata.SniffHost)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// no host to compare to
+	metadata = &C.Metadata{NetWork: C.TCP, DstIP: net.IPv4(1, 1, 1, 1), DstPort: "443"}
+	hello := helloConn(t, "example.com")
+	conn, _ = checkHost(hello, metadata)
+	assert.Empty(t, metadata.SniffHost)
+	assert.Equal(t, hello, conn)
+}
+
+func TestParseHostMismatch(t *testing.T) {
+	mode, err := ParseHostMismatch("")
+	require.NoError(t, err)
+	assert.Equal(t, HostMismatchOff, mode)
+
+	_, err = ParseHostMismatch("drop")
+	assert.Error(t, err)
+}
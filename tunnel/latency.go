@@ -0,0 +1,76 @@
+package tunnel
+
+// the stages of a tcp connection whose latency is measured in a build with the metrics tag
+const (
+	// LatencyMatch is the preparation of the metadata and the rule matching
+	LatencyMatch = "match"
+	// LatencyDial is the dial through the proxy, the wait for the dial pool included
+	LatencyDial = "dial"
+)
+
+// latencyBounds are the upper bounds in seconds of the buckets of the latency histograms
+var latencyBounds = [...]float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// LatencyHistogram is the latency of a stage of the connections from an inbound type dialed
+// by a proxy type, the leaf of the groups. Counts has a count per bucket of Bounds, not
+// cumulative, and the one of the connections past the last bound.
+type LatencyHistogram struct {
+	Stage   string
+	Inbound string
+	Proxy   string
+	Bounds  []float64
+	Counts  []uint64
+	Sum     float64
+	Count   uint64
+}
+
+// LatencySummary is the p50 and the p95 of a LatencyHistogram in milliseconds
+type LatencySummary struct {
+	Stage   string  `json:"stage"`
+	Inbound string  `json:"inbound"`
+	Proxy   string  `json:"proxy"`
+	Count   uint64  `json:"count"`
+	P50     float64 `json:"p50"`
+	P95     float64 `json:"p95"`
+}
+
+// Quantile return the q quantile in seconds, interpolated inside its bucket, the last bound
+// for one past it
+func (h LatencyHistogram) Quantile(q float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := q * float64(h.Count)
+	var seen uint64
+	for i, count := range h.Counts {
+		if count == 0 || float64(seen+count) < rank {
+			seen += count
+			continue
+		}
+		if i == len(h.Bounds) {
+			break
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = h.Bounds[i-1]
+		}
+		return lower + (h.Bounds[i]-lower)*(rank-float64(seen))/float64(count)
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// LatencySummaries return the summaries of LatencyHistograms, empty without the metrics tag
+func LatencySummaries() []LatencySummary {
+	summaries := []LatencySummary{}
+	for _, h := range LatencyHistograms() {
+		summaries = append(summaries, LatencySummary{
+			Stage:   h.Stage,
+			Inbound: h.Inbound,
+			Proxy:   h.Proxy,
+			Count:   h.Count,
+			P50:     h.Quantile(0.5) * 1000,
+			P95:     h.Quantile(0.95) * 1000,
+		})
+	}
+	return summaries
+}
@@ -0,0 +1,113 @@
+//go:build metrics
+
+package tunnel
+
+import (
+	"sort"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"go.uber.org/atomic"
+)
+
+// LatencyMetrics tells the build measures the latency of the connections
+const LatencyMetrics = true
+
+const (
+	latencyInbounds = int(C.TUIC) + 1
+	// the proxy types built in, the registered ones share the last
+	latencyProxies = int(C.LoadBalance) + 2
+	latencyBuckets = len(latencyBounds) + 1
+)
+
+var latencyStages = [...]string{LatencyMatch, LatencyDial}
+
+type latencyHistogram struct {
+	counts [latencyBuckets]atomic.Uint64
+	sum    atomic.Int64
+	count  atomic.Uint64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	h.counts[sort.SearchFloat64s(latencyBounds[:], d.Seconds())].Inc()
+	h.sum.Add(int64(d))
+	h.count.Inc()
+}
+
+// latencies are indexed by stage, inbound type and proxy type, a connection only adds to
+// the counters of its labels
+var latencies [len(latencyStages)][latencyInbounds][latencyProxies]latencyHistogram
+
+// latencyTimer measures the stages of a tcp connection, it lives on the stack of the handler
+type latencyTimer struct {
+	start   time.Time
+	matched time.Time
+	held    time.Time
+}
+
+func startLatency() latencyTimer {
+	return latencyTimer{start: time.Now()}
+}
+
+// hold and resume leave a wait for the client out of the match
+func (t *latencyTimer) hold() {
+	t.held = time.Now()
+}
+
+func (t *latencyTimer) resume() {
+	t.start = t.start.Add(time.Since(t.held))
+}
+
+func (t *latencyTimer) match() {
+	t.matched = time.Now()
+}
+
+// dialed records the stages of a connection from inbound dialed as conn
+func (t *latencyTimer) dialed(inbound C.Type, conn C.Conn) {
+	dial := time.Since(t.matched)
+	in := int(inbound)
+	if in < 0 || in >= latencyInbounds {
+		return
+	}
+	proxy := latencyProxies - 1
+	if leaf, ok := conn.(leafTyper); ok && int(leaf.LeafType()) < latencyProxies-1 {
+		proxy = int(leaf.LeafType())
+	}
+	latencies[0][in][proxy].observe(t.matched.Sub(t.start))
+	latencies[1][in][proxy].observe(dial)
+}
+
+// LatencyHistograms return the histograms with a connection, by stage, inbound and proxy
+func LatencyHistograms() []LatencyHistogram {
+	histograms := []LatencyHistogram{}
+	for stage := range latencies {
+		for in := range latencies[stage] {
+			for proxy := range latencies[stage][in] {
+				h := &latencies[stage][in][proxy]
+				count := h.count.Load()
+				if count == 0 {
+					continue
+				}
+				proxyLabel := "Other"
+				if proxy < latencyProxies-1 {
+					proxyLabel = C.AdapterType(proxy).String()
+				}
+				histogram := LatencyHistogram{
+					Stage:   latencyStages[stage],
+					Inbound: C.Type(in).String(),
+					Proxy:   proxyLabel,
+					Bounds:  latencyBounds[:],
+					Counts:  make([]uint64, latencyBuckets),
+					Sum:     time.Duration(h.sum.Load()).Seconds(),
+				}
+				for i := range h.counts {
+					histogram.Counts[i] = h.counts[i].Load()
+					histogram.Count += histogram.Counts[i]
+				}
+				histograms = append(histograms, histogram)
+			}
+		}
+	}
+	return histograms
+}
@@ -0,0 +1,46 @@
+//go:build metrics
+
+package tunnel
+
+import (
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type leafConn struct {
+	C.Conn
+	leaf C.AdapterType
+}
+
+func (c *leafConn) LeafType() C.AdapterType { return c.leaf }
+
+func TestLatencyTimer(t *testing.T) {
+	latency := startLatency()
+	latency.hold()
+	time.Sleep(20 * time.Millisecond)
+	latency.resume()
+	latency.match()
+	latency.dialed(C.SOCKS5, &leafConn{leaf: C.Shadowsocks})
+	latency.dialed(C.SOCKS5, &leafConn{leaf: C.AdapterType(1000)})
+
+	stages := map[string]LatencyHistogram{}
+	for _, h := range LatencyHistograms() {
+		if h.Inbound == "Socks5" && h.Proxy == "Shadowsocks" {
+			stages[h.Stage] = h
+		}
+	}
+	assert.EqualValues(t, 1, stages[LatencyMatch].Count)
+	assert.EqualValues(t, 1, stages[LatencyDial].Count)
+	// the hold is out of the match
+	assert.Less(t, stages[LatencyMatch].Sum, 0.01)
+
+	var other bool
+	for _, h := range LatencySummaries() {
+		other = other || h.Proxy == "Other"
+	}
+	assert.True(t, other)
+}
@@ -0,0 +1,26 @@
+//go:build !metrics
+
+package tunnel
+
+import (
+	C "github.com/Dreamacro/clash/constant"
+)
+
+// LatencyMetrics tells the build measures the latency of the connections, it takes the
+// metrics build tag
+const LatencyMetrics = false
+
+type latencyTimer struct{}
+
+func startLatency() latencyTimer { return latencyTimer{} }
+
+func (*latencyTimer) hold() {}
+
+func (*latencyTimer) resume() {}
+
+func (*latencyTimer) match() {}
+
+func (*latencyTimer) dialed(C.Type, C.Conn) {}
+
+// LatencyHistograms return nil, the build has no metrics tag
+func LatencyHistograms() []LatencyHistogram { return nil }
@@ -0,0 +1,24 @@
+package tunnel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyHistogram_Quantile(t *testing.T) {
+	h := LatencyHistogram{
+		Bounds: []float64{0.001, 0.01, 0.1},
+		Counts: []uint64{0, 10, 10, 0},
+		Count:  20,
+	}
+	assert.InDelta(t, 0.01, h.Quantile(0.5), 1e-9)
+	assert.InDelta(t, 0.091, h.Quantile(0.95), 1e-9)
+
+	// past the last bound is the last bound
+	h.Counts = []uint64{0, 0, 0, 4}
+	h.Count = 4
+	assert.Equal(t, 0.1, h.Quantile(0.5))
+
+	assert.Zero(t, LatencyHistogram{}.Quantile(0.5))
+}
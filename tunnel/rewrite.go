@@ -0,0 +1,233 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Dreamacro/clash/component/resolver"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+)
+
+var (
+	rewrites   []*Rewrite
+	rewriteMux sync.RWMutex
+)
+
+// Rewrite overrides the destination of a connection after rule matching
+type Rewrite struct {
+	match  string
+	target string
+
+	// one of domain, suffix, ip or cidr is set
+	domain string
+	suffix string
+	ip     netip.Addr
+	cidr   netip.Prefix
+	port   string
+
+	host    string
+	newPort string
+}
+
+// NewRewrite parses a match of the form host[:port] where host is a domain,
+// +.domain (including subdomains), an ip or a cidr, and a target of the
+// form host:port, host or :port
+func NewRewrite(match, target string) (*Rewrite, error) {
+	r := &Rewrite{match: match, target: target}
+
+	host, port := splitHostPort(match)
+	if port != "" {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid port %s", port)
+		}
+	}
+	r.port = port
+
+	switch {
+	case host == "":
+		return nil, errors.New("match host is required")
+	case strings.Contains(host, "/"):
+		prefix, err := netip.ParsePrefix(host)
+		if err != nil {
+			return nil, err
+		}
+		r.cidr = prefix.Masked()
+	case strings.HasPrefix(host, "+."):
+		r.suffix = strings.ToLower(host[2:])
+	default:
+		if ip, err := netip.ParseAddr(host); err == nil {
+			r.ip = ip.Unmap()
+		} else {
+			r.domain = strings.ToLower(host)
+		}
+	}
+
+	r.host, r.newPort = splitHostPort(target)
+	if r.host == "" && r.newPort == "" {
+		return nil, errors.New("target host or port is required")
+	}
+	if r.newPort != "" {
+		if _, err := strconv.ParseUint(r.newPort, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid target port %s", r.newPort)
+		}
+	}
+
+	return r, nil
+}
+
+func (r *Rewrite) String() string {
+	return r.match + " -> " + r.target
+}
+
+func (r *Rewrite) Match(metadata *C.Metadata) bool {
+	if r.port != "" && r.port != metadata.DstPort {
+		return false
+	}
+
+	switch {
+	case r.domain != "":
+		return strings.ToLower(metadata.Host) == r.domain
+	case r.suffix != "":
+		host := strings.ToLower(metadata.Host)
+		return host == r.suffix || strings.HasSuffix(host, "."+r.suffix)
+	}
+
+	// fake ips are not real destinations, they are matched by host after the mapping
+	if metadata.DstIP == nil || resolver.IsFakeIP(metadata.DstIP) {
+		return false
+	}
+	ip, _ := netip.AddrFromSlice(metadata.DstIP)
+	ip = ip.Unmap()
+	if r.ip.IsValid() {
+		return r.ip == ip
+	}
+	return r.cidr.Contains(ip)
+}
+
+func (r *Rewrite) apply(metadata *C.Metadata) {
+	if r.host != "" {
+		if ip := net.ParseIP(r.host); ip != nil {
+			metadata.Host = ""
+			metadata.DstIP = ip
+		} else {
+			metadata.Host = r.host
+			metadata.DstIP = nil
+		}
+		metadata.DNSMode = C.DNSNormal
+	}
+	if r.newPort != "" {
+		metadata.DstPort = r.newPort
+	}
+}
+
+func splitHostPort(s string) (string, string) {
+	if host, port, err := net.SplitHostPort(s); err == nil {
+		return host, port
+	}
+	return s, ""
+}
+
+// Rewrites return all rewrites
+func Rewrites() []*Rewrite {
+	rewriteMux.RLock()
+	defer rewriteMux.RUnlock()
+	return rewrites
+}
+
+// UpdateRewrites handle update rewrites
+func UpdateRewrites(newRewrites []*Rewrite) {
+	rewriteMux.Lock()
+	rewrites = newRewrites
+	rewriteMux.Unlock()
+}
+
+// CheckRewriteLoop returns an error if following the targets of the rewrites ever
+// comes back to a rewrite that was already applied
+func CheckRewriteLoop(rs []*Rewrite) error {
+	for _, r := range rs {
+		metadata := &C.Metadata{DstPort: r.port}
+		r.apply(metadata)
+
+		visited := map[*Rewrite]bool{r: true}
+		chain := []string{r.String()}
+		for {
+			next := findRewrite(rs, metadata)
+			if next == nil {
+				break
+			}
+			chain = append(chain, next.String())
+			if visited[next] {
+				return fmt.Errorf("rewrite loop: %s", strings.Join(chain, ", "))
+			}
+			visited[next] = true
+			next.apply(metadata)
+		}
+	}
+	return nil
+}
+
+func findRewrite(rs []*Rewrite, metadata *C.Metadata) *Rewrite {
+	for _, r := range rs {
+		if r.Match(metadata) {
+			return r
+		}
+	}
+	return nil
+}
+
+// rewriteMetadata return the metadata to dial with. The input is returned as is
+// if no rewrite matches, otherwise a copy with the new destination and the
+// original one kept in OriginDestination.
+func rewriteMetadata(metadata *C.Metadata) *C.Metadata {
+	rs := Rewrites()
+	if len(rs) == 0 {
+		return metadata
+	}
+
+	r := findRewrite(rs, metadata)
+	if r == nil {
+		return metadata
+	}
+
+	target := *metadata
+	target.OriginDestination = metadata.RemoteAddress()
+
+	// loops are rejected when the config is parsed, rewrites may still chain
+	for i := 0; r != nil && i < len(rs); i++ {
+		r.apply(&target)
+		r = findRewrite(rs, &target)
+	}
+
+	if target.DstIP != nil && resolver.IsFakeIP(target.DstIP) {
+		log.Warnln("[Rewrite] %s target %s is a fake ip, ignored", target.OriginDestination, target.RemoteAddress())
+		return metadata
+	}
+
+	log.Debugln("[Rewrite] %s --> %s", target.OriginDestination, target.RemoteAddress())
+	return &target
+}
+
+// udpTarget returns the rewritten metadata of a udp packet with the ip to send it to, it's
+// resolved once for the destination of a nat session
+func udpTarget(metadata *C.Metadata) (*C.Metadata, error) {
+	target := rewriteMetadata(metadata)
+	if target.Resolved() {
+		return target, nil
+	}
+
+	ips, err := resolver.LookupIP(context.Background(), target.Host)
+	if err != nil {
+		return nil, err
+	} else if len(ips) == 0 {
+		return nil, fmt.Errorf("%s: no ip found", target.Host)
+	}
+	target.DstIP = ips[0]
+	return target, nil
+}
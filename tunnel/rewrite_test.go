@@ -0,0 +1,136 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustRewrite(t *testing.T, match, target string) *Rewrite {
+	r, err := NewRewrite(match, target)
+	require.NoError(t, err)
+	return r
+}
+
+func withRewrites(t *testing.T, rs ...*Rewrite) {
+	old := Rewrites()
+	UpdateRewrites(rs)
+	t.Cleanup(func() { UpdateRewrites(old) })
+}
+
+func TestNewRewrite_Invalid(t *testing.T) {
+	for _, c := range []struct{ match, target string }{
+		{"", "1.1.1.1"},
+		{":53", "1.1.1.1"},
+		{"example.com:99999", "1.1.1.1"},
+		{"10.0.0.0/33", "1.1.1.1"},
+		{"example.com", ""},
+		{"example.com", "1.1.1.1:dns"},
+	} {
+		_, err := NewRewrite(c.match, c.target)
+		assert.Error(t, err, "%s -> %s", c.match, c.target)
+	}
+}
+
+func TestRewrite_Match(t *testing.T) {
+	for _, c := range []struct {
+		match    string
+		metadata C.Metadata
+		ok       bool
+	}{
+		{"example.com", C.Metadata{Host: "Example.COM", DstPort: "443"}, true},
+		{"example.com", C.Metadata{Host: "www.example.com", DstPort: "443"}, false},
+		{"example.com:53", C.Metadata{Host: "example.com", DstPort: "53"}, true},
+		{"example.com:53", C.Metadata{Host: "example.com", DstPort: "443"}, false},
+		{"+.example.com", C.Metadata{Host: "example.com", DstPort: "443"}, true},
+		{"+.example.com", C.Metadata{Host: "a.b.example.com", DstPort: "443"}, true},
+		{"+.example.com", C.Metadata{Host: "badexample.com", DstPort: "443"}, false},
+		{"10.0.0.1", C.Metadata{DstIP: net.ParseIP("10.0.0.1"), DstPort: "80"}, true},
+		{"10.0.0.1", C.Metadata{DstIP: net.ParseIP("::ffff:10.0.0.1"), DstPort: "80"}, true},
+		{"10.0.0.1", C.Metadata{DstIP: net.ParseIP("10.0.0.2"), DstPort: "80"}, false},
+		{"10.0.0.1", C.Metadata{Host: "10.0.0.1", DstPort: "80"}, false},
+		{"10.0.0.0/8:80", C.Metadata{DstIP: net.ParseIP("10.1.2.3"), DstPort: "80"}, true},
+		{"10.0.0.0/8:80", C.Metadata{DstIP: net.ParseIP("10.1.2.3"), DstPort: "81"}, false},
+		{"[fd00::]:53", C.Metadata{DstIP: net.ParseIP("fd00::"), DstPort: "53"}, true},
+		// an ip match doesn't look at the host of a resolved connection
+		{"10.0.0.1", C.Metadata{Host: "example.com", DstIP: net.ParseIP("10.0.0.1"), DstPort: "80"}, true},
+	} {
+		metadata := c.metadata
+		assert.Equal(t, c.ok, mustRewrite(t, c.match, "1.1.1.1").Match(&metadata), "%s: %s", c.match, metadata.RemoteAddress())
+	}
+}
+
+func TestRewrite_Apply(t *testing.T) {
+	for _, c := range []struct {
+		target string
+		host   string
+		ip     string
+		port   string
+	}{
+		{"1.1.1.1:5353", "", "1.1.1.1", "5353"},
+		{"2606:4700::1111", "", "2606:4700::1111", "53"},
+		{"dns.example.net", "dns.example.net", "<nil>", "53"},
+		{":5353", "example.com", "10.0.0.1", "5353"},
+	} {
+		metadata := &C.Metadata{Host: "example.com", DstIP: net.ParseIP("10.0.0.1"), DstPort: "53", DNSMode: C.DNSMapping}
+		mustRewrite(t, "example.com", c.target).apply(metadata)
+		assert.Equal(t, c.host, metadata.Host, c.target)
+		assert.Equal(t, c.ip, metadata.DstIP.String(), c.target)
+		assert.Equal(t, c.port, metadata.DstPort, c.target)
+		if c.target[0] != ':' {
+			assert.Equal(t, C.DNSNormal, metadata.DNSMode, c.target)
+		}
+	}
+}
+
+func TestRewriteMetadata(t *testing.T) {
+	withRewrites(t,
+		mustRewrite(t, "example.com:53", "10.0.0.53"),
+		mustRewrite(t, "10.0.0.53:53", ":5353"),
+	)
+
+	metadata := &C.Metadata{Host: "example.org", DstPort: "53"}
+	assert.Same(t, metadata, rewriteMetadata(metadata))
+
+	// the rewrites chain, the metadata of the client is left as is
+	metadata = &C.Metadata{Host: "example.com", DstPort: "53"}
+	target := rewriteMetadata(metadata)
+	assert.NotSame(t, metadata, target)
+	assert.Equal(t, "10.0.0.53:5353", target.RemoteAddress())
+	assert.Equal(t, "example.com:53", target.OriginDestination)
+	assert.Equal(t, "example.com:53", metadata.RemoteAddress())
+	assert.Empty(t, metadata.OriginDestination)
+}
+
+func TestCheckRewriteLoop(t *testing.T) {
+	assert.NoError(t, CheckRewriteLoop([]*Rewrite{
+		mustRewrite(t, "a.example.com", "b.example.com"),
+		mustRewrite(t, "b.example.com", "10.0.0.1"),
+	}))
+	assert.Error(t, CheckRewriteLoop([]*Rewrite{
+		mustRewrite(t, "a.example.com", "b.example.com"),
+		mustRewrite(t, "b.example.com", "a.example.com"),
+	}))
+	assert.Error(t, CheckRewriteLoop([]*Rewrite{
+		mustRewrite(t, "10.0.0.1:53", ":5353"),
+		mustRewrite(t, "10.0.0.0/8:5353", "10.0.0.1:53"),
+	}))
+}
+
+func TestUDPTarget(t *testing.T) {
+	withRewrites(t, mustRewrite(t, "example.com", "10.0.0.1:5353"))
+
+	metadata := &C.Metadata{NetWork: C.UDP, Host: "example.com", DstIP: net.ParseIP("192.0.2.1"), DstPort: "53"}
+	target, err := udpTarget(metadata)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:5353", target.UDPAddr().String())
+
+	metadata = &C.Metadata{NetWork: C.UDP, Host: "example.org", DstIP: net.ParseIP("192.0.2.1"), DstPort: "53"}
+	target, err = udpTarget(metadata)
+	require.NoError(t, err)
+	assert.Same(t, metadata, target)
+}
@@ -0,0 +1,239 @@
+package statistic
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/log"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	AccessLogJSON = "json"
+	AccessLogTSV  = "tsv"
+
+	// CloseReasonClosed is a connection ended by the client or the remote
+	CloseReasonClosed = "closed"
+	// CloseReasonAPI is a connection closed by DELETE /connections
+	CloseReasonAPI = "api"
+	// CloseReasonProxyChange is a connection closed by the close policy on a proxy change
+	CloseReasonProxyChange = "proxy-change"
+
+	accessLogQueueSize = 1024
+)
+
+// AccessLogOption writes a line per closed connection to Path, the file is rotated to
+// Path.1 ... Path.MaxBackups once it grows over MaxSize bytes, 0 never rotates.
+// Sample is the share of the connections written, in (0, 1].
+type AccessLogOption struct {
+	Path       string
+	Format     string
+	MaxSize    int64
+	MaxBackups int
+	Sample     float64
+}
+
+// AccessEntry is a line of the access log, Duration is in milliseconds
+type AccessEntry struct {
+	Time        time.Time `json:"time"`
+	ID          string    `json:"id"`
+	Network     string    `json:"network"`
+	Inbound     string    `json:"inbound"`
+	Source      string    `json:"source"`
+	Host        string    `json:"host"`
+	Destination string    `json:"destination"`
+	Rule        string    `json:"rule"`
+	RulePayload string    `json:"rulePayload"`
+	Chain       []string  `json:"chain"`
+	Upload      int64     `json:"upload"`
+	Download    int64     `json:"download"`
+	Duration    int64     `json:"duration"`
+	Reason      string    `json:"reason"`
+	// SniffHost is the tls server name read by host-mismatch
+	SniffHost    string `json:"sniffHost,omitempty"`
+	HostMismatch bool   `json:"hostMismatch,omitempty"`
+}
+
+// tsv return the fields of the entry in the json order, tabs and newlines can't appear in them
+func (e *AccessEntry) tsv() string {
+	fields := []string{
+		e.Time.Format(time.RFC3339Nano), e.ID, e.Network, e.Inbound, e.Source, e.Host, e.Destination,
+		e.Rule, e.RulePayload, strings.Join(e.Chain, ","),
+		strconv.FormatInt(e.Upload, 10), strconv.FormatInt(e.Download, 10), strconv.FormatInt(e.Duration, 10), e.Reason,
+		e.SniffHost, strconv.FormatBool(e.HostMismatch),
+	}
+	for i, f := range fields {
+		fields[i] = tsvEscaper.Replace(f)
+	}
+	return strings.Join(fields, "\t")
+}
+
+type accessLogger struct {
+	option  AccessLogOption
+	file    *os.File
+	size    int64
+	queue   chan *AccessEntry
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+var (
+	tsvEscaper = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+	// the read lock is held to queue, so a stopped logger is never sent to
+	accessLogMux sync.RWMutex
+	accessLog    *accessLogger
+)
+
+// UpdateAccessLog replaces the access log, nil disables it. The file is kept open when the
+// option is unchanged, it's opened for append so the lines of the previous runs are kept.
+func UpdateAccessLog(opt *AccessLogOption) error {
+	accessLogMux.Lock()
+	defer accessLogMux.Unlock()
+
+	old := accessLog
+	if old != nil && opt != nil && old.option == *opt {
+		return nil
+	}
+
+	var next *accessLogger
+	if opt != nil {
+		path := opt.Path
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("access-log: %w", err)
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("access-log: %w", err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("access-log: %w", err)
+		}
+		next = &accessLogger{
+			option: *opt,
+			file:   file,
+			size:   info.Size(),
+			queue:  make(chan *AccessEntry, accessLogQueueSize),
+			done:   make(chan struct{}),
+		}
+		go next.run()
+	}
+
+	accessLog = next
+	if old != nil {
+		old.stop()
+	}
+	return nil
+}
+
+// logAccess queues the entry of a closed connection, it never blocks the close
+func logAccess(t tracker) {
+	accessLogMux.RLock()
+	defer accessLogMux.RUnlock()
+
+	l := accessLog
+	if l == nil || (l.option.Sample < 1 && rand.Float64() >= l.option.Sample) {
+		return
+	}
+
+	info := t.info()
+	m := info.Metadata
+	now := time.Now()
+	entry := &AccessEntry{
+		Time:        now,
+		ID:          info.id,
+		Network:     m.NetWork.String(),
+		Inbound:     m.Type.String(),
+		Source:      m.SourceAddress(),
+		Host:        m.Host,
+		Rule:        info.Rule,
+		RulePayload: info.RulePayload,
+		Chain:       info.Chain,
+		Upload:      info.UploadTotal.Load(),
+		Download:    info.DownloadTotal.Load(),
+		Duration:    now.Sub(time.Unix(0, int64(info.Start))).Milliseconds(),
+		Reason:      info.closeReason(),
+
+		SniffHost:    m.SniffHost,
+		HostMismatch: m.HostMismatch,
+	}
+	if m.DstIP != nil {
+		entry.Destination = net.JoinHostPort(m.DstIP.String(), m.DstPort)
+	}
+
+	select {
+	case l.queue <- entry:
+	default:
+		l.dropped.Inc()
+		log.Dedupln(log.WARNING, "access-log-full", "[AccessLog] writer can't keep up, %d entries dropped", l.dropped.Load())
+	}
+}
+
+func (l *accessLogger) run() {
+	defer close(l.done)
+	for entry := range l.queue {
+		var line []byte
+		if l.option.Format == AccessLogTSV {
+			line = []byte(entry.tsv() + "\n")
+		} else {
+			line, _ = json.Marshal(entry)
+			line = append(line, '\n')
+		}
+		if err := l.write(line); err != nil {
+			log.Dedupln(log.ERROR, "access-log-write", "[AccessLog] write %s: %s", l.option.Path, err)
+		}
+	}
+	l.file.Close()
+}
+
+func (l *accessLogger) write(line []byte) error {
+	if l.option.MaxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.option.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// rotate shifts Path.N to Path.N+1, drops the ones over MaxBackups and reopens Path
+func (l *accessLogger) rotate() error {
+	path := l.option.Path
+	l.file.Close()
+
+	if l.option.MaxBackups <= 0 {
+		os.Remove(path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", path, l.option.MaxBackups))
+		for i := l.option.MaxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+		}
+		os.Rename(path, path+".1")
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("reopen after rotation: %w", err)
+	}
+	l.file = file
+	l.size = 0
+	return nil
+}
+
+// stop writes the queued entries and closes the file
+func (l *accessLogger) stop() {
+	close(l.queue)
+	<-l.done
+}
@@ -0,0 +1,84 @@
+package statistic
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func closeTracked(t *testing.T, m *Manager, i int, reason string) {
+	client, server := net.Pipe()
+	t.Cleanup(func() { server.Close() })
+	tt := NewTCPTracker(&chainConn{Conn: client, chain: C.Chain{"hk", "Proxy"}}, m, testMetadata(i), testRule{}, nil)
+	tt.UploadTotal.Add(12)
+	if reason != "" {
+		tt.SetCloseReason(reason)
+	}
+	tt.Close()
+}
+
+func TestAccessLog_JSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "access.log")
+	require.NoError(t, UpdateAccessLog(&AccessLogOption{Path: path, Format: AccessLogJSON, Sample: 1}))
+
+	m := newTestManager()
+	closeTracked(t, m, 3, "")
+	closeTracked(t, m, 4, CloseReasonAPI)
+	// stopping writes the queued entries
+	require.NoError(t, UpdateAccessLog(nil))
+
+	buf, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	require.Len(t, lines, 2)
+
+	entry := AccessEntry{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "tcp", entry.Network)
+	assert.Equal(t, "HTTP Connect", entry.Inbound)
+	assert.Equal(t, "192.168.1.3:10003", entry.Source)
+	assert.Equal(t, "www.3.example.com", entry.Host)
+	assert.Equal(t, "1.1.1.1:443", entry.Destination)
+	assert.Equal(t, "Domain", entry.Rule)
+	assert.Equal(t, []string{"hk", "Proxy"}, entry.Chain)
+	assert.EqualValues(t, 12, entry.Upload)
+	assert.Equal(t, CloseReasonClosed, entry.Reason)
+
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, CloseReasonAPI, entry.Reason)
+
+	// reopening appends
+	require.NoError(t, UpdateAccessLog(&AccessLogOption{Path: path, Format: AccessLogTSV, Sample: 1}))
+	closeTracked(t, m, 5, "")
+	require.NoError(t, UpdateAccessLog(nil))
+	buf, _ = os.ReadFile(path)
+	lines = strings.Split(strings.TrimSpace(string(buf)), "\n")
+	require.Len(t, lines, 3)
+	assert.Len(t, strings.Split(lines[2], "\t"), 16)
+}
+
+func TestAccessLog_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	require.NoError(t, UpdateAccessLog(&AccessLogOption{Path: path, Format: AccessLogTSV, MaxSize: 100, MaxBackups: 2, Sample: 1}))
+
+	m := newTestManager()
+	for i := 0; i < 4; i++ {
+		closeTracked(t, m, i, "")
+	}
+	require.NoError(t, UpdateAccessLog(nil))
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		buf, err := os.ReadFile(name)
+		require.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(string(buf), "\n"))
+	}
+	assert.NoFileExists(t, path+".3")
+}
@@ -0,0 +1,426 @@
+package statistic
+
+import (
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/common/pcapng"
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+
+	"github.com/gofrs/uuid/v5"
+	"go.uber.org/atomic"
+)
+
+const (
+	DefaultCaptureBytes    = 10 * 1024 * 1024
+	MaxCaptureBytes        = 100 * 1024 * 1024
+	DefaultCaptureDuration = time.Minute
+	MaxCaptureDuration     = 10 * time.Minute
+
+	// records waiting to be written, the relay never waits for the writer
+	captureQueueSize = 1024
+)
+
+var ErrCaptureNoMatch = errors.New("no connection matches the capture")
+
+type CaptureOption struct {
+	ConnectionID string
+	Filter       string
+	MaxBytes     int64
+	Duration     time.Duration
+}
+
+type captureRecord struct {
+	info   *trackerInfo
+	upload bool
+	// raw is an ip packet of the tun device, written as is
+	raw  bool
+	time time.Time
+	data []byte
+}
+
+// tunFlow is the addresses of the packets of a tun connection from the client
+type tunFlow struct {
+	network C.NetWork
+	src     netip.AddrPort
+	dst     netip.AddrPort
+}
+
+// tunFlowOf return the flow of a connection of the tun, its packets are tapped at the ipstack
+func tunFlowOf(info *trackerInfo) (tunFlow, bool) {
+	metadata := info.Metadata
+	if metadata.Type != C.TUN || !metadata.OriginDst.IsValid() {
+		return tunFlow{}, false
+	}
+	dst := netip.AddrPortFrom(metadata.OriginDst.Addr().Unmap(), metadata.OriginDst.Port())
+	return tunFlow{network: metadata.NetWork, src: addrPort(metadata.SrcIP, metadata.SrcPort), dst: dst}, true
+}
+
+type seqState struct {
+	up   uint32
+	down uint32
+}
+
+// Capture taps the relays of matching connections and writes them into a pcap-ng file.
+// Stream data is written as synthesized tcp segments, udp payloads as udp datagrams, the
+// connections of the tun are written as the raw ip packets of the device.
+type Capture struct {
+	id       string
+	path     string
+	file     *os.File
+	writer   *pcapng.Writer
+	option   CaptureOption
+	follow   bool
+	manager  *Manager
+	records  chan captureRecord
+	written  *atomic.Int64
+	dropped  *atomic.Int64
+	start    time.Time
+	done     chan struct{}
+	stopOnce sync.Once
+	closed   chan struct{}
+}
+
+func (c *Capture) ID() string {
+	return c.id
+}
+
+// Path return the location of the pcap-ng file
+func (c *Capture) Path() string {
+	return c.path
+}
+
+// Done is closed when the capture file is complete
+func (c *Capture) Done() <-chan struct{} {
+	return c.closed
+}
+
+func (c *Capture) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"id":      c.id,
+		"path":    c.path,
+		"start":   c.start,
+		"written": c.written.Load(),
+		"dropped": c.dropped.Load(),
+		"running": !c.stopped(),
+	})
+}
+
+func (c *Capture) match(info *trackerInfo) bool {
+	if c.option.ConnectionID != "" {
+		return info.UUID.String() == c.option.ConnectionID
+	}
+
+	filter := c.option.Filter
+	metadata := info.Metadata
+	switch {
+	case metadata.Host != "" && (metadata.Host == filter || strings.HasSuffix(metadata.Host, "."+filter)):
+		return true
+	case metadata.DstIP != nil && metadata.DstIP.String() == filter:
+		return true
+	default:
+		return metadata.RemoteAddress() == filter
+	}
+}
+
+func (c *Capture) stopped() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// tap is called in the relay path, it copies the data and never blocks. The relay of a
+// tun connection isn't tapped, its packets are
+func (c *Capture) tap(info *trackerInfo, upload bool, b []byte) {
+	if _, ok := tunFlowOf(info); ok {
+		return
+	}
+	c.queue(captureRecord{info: info, upload: upload}, b)
+}
+
+func (c *Capture) queue(record captureRecord, b []byte) {
+	if len(b) == 0 || c.stopped() {
+		return
+	}
+
+	record.time = time.Now()
+	record.data = make([]byte, len(b))
+	copy(record.data, b)
+
+	select {
+	case c.records <- record:
+	default:
+		c.dropped.Add(int64(len(b)))
+	}
+}
+
+// Stop detaches the capture from all connections and finishes the file
+func (c *Capture) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.done)
+		c.manager.connections.Range(func(key, value any) bool {
+			info := value.(tracker).info()
+			if info.capture.CompareAndSwap(c, nil) {
+				c.manager.removeTunFlow(info)
+			}
+			return true
+		})
+	})
+}
+
+func (c *Capture) process() {
+	defer close(c.closed)
+	defer c.file.Close()
+
+	timer := time.NewTimer(c.option.Duration)
+	defer timer.Stop()
+
+	seqs := map[*trackerInfo]*seqState{}
+	for {
+		select {
+		case record := <-c.records:
+			if err := c.write(seqs, record); err != nil {
+				log.Warnln("[Capture] %s write failed: %s", c.id, err.Error())
+				c.Stop()
+				return
+			}
+			if c.written.Load() >= c.option.MaxBytes {
+				log.Infoln("[Capture] %s reached the size limit", c.id)
+				c.Stop()
+				return
+			}
+		case <-timer.C:
+			c.Stop()
+			c.drain(seqs)
+			return
+		case <-c.done:
+			c.drain(seqs)
+			return
+		}
+	}
+}
+
+// drain writes the records queued before the capture stopped, up to the size limit
+func (c *Capture) drain(seqs map[*trackerInfo]*seqState) {
+	for c.written.Load() < c.option.MaxBytes {
+		select {
+		case record := <-c.records:
+			if err := c.write(seqs, record); err != nil {
+				log.Warnln("[Capture] %s write failed: %s", c.id, err.Error())
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (c *Capture) write(seqs map[*trackerInfo]*seqState, record captureRecord) error {
+	if record.raw {
+		n, err := c.writer.WritePacket(record.time, record.data)
+		c.written.Add(int64(n))
+		return err
+	}
+
+	metadata := record.info.Metadata
+	local := addrPort(metadata.SrcIP, metadata.SrcPort)
+	remote := addrPort(metadata.DstIP, metadata.DstPort)
+
+	src, dst := remote, local
+	if record.upload {
+		src, dst = local, remote
+	}
+
+	seq := seqs[record.info]
+	if seq == nil {
+		seq = &seqState{up: 1, down: 1}
+		seqs[record.info] = seq
+	}
+
+	for data := record.data; len(data) > 0; {
+		chunk := data
+		if len(chunk) > pcapng.MaxPayload {
+			chunk = chunk[:pcapng.MaxPayload]
+		}
+		data = data[len(chunk):]
+
+		var pkt []byte
+		if metadata.NetWork == C.UDP {
+			pkt = pcapng.UDPDatagram(src, dst, chunk)
+		} else if record.upload {
+			pkt = pcapng.TCPSegment(src, dst, seq.up, seq.down, chunk)
+			seq.up += uint32(len(chunk))
+		} else {
+			pkt = pcapng.TCPSegment(src, dst, seq.down, seq.up, chunk)
+			seq.down += uint32(len(chunk))
+		}
+
+		n, err := c.writer.WritePacket(record.time, pkt)
+		if err != nil {
+			return err
+		}
+		c.written.Add(int64(n))
+	}
+
+	return nil
+}
+
+func addrPort(ip []byte, port string) netip.AddrPort {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		addr = netip.IPv4Unspecified()
+	}
+	p, _ := strconv.ParseUint(port, 10, 16)
+	return netip.AddrPortFrom(addr.Unmap(), uint16(p))
+}
+
+// StartCapture creates a capture file and attaches it to the matching connections,
+// connections created later are attached as well until the capture stops
+func (m *Manager) StartCapture(option CaptureOption) (*Capture, error) {
+	if option.ConnectionID == "" && option.Filter == "" {
+		return nil, errors.New("connection id or filter is required")
+	}
+	if option.MaxBytes <= 0 {
+		option.MaxBytes = DefaultCaptureBytes
+	} else if option.MaxBytes > MaxCaptureBytes {
+		option.MaxBytes = MaxCaptureBytes
+	}
+	if option.Duration <= 0 {
+		option.Duration = DefaultCaptureDuration
+	} else if option.Duration > MaxCaptureDuration {
+		option.Duration = MaxCaptureDuration
+	}
+
+	id, _ := uuid.NewV4()
+	c := &Capture{
+		id:      id.String(),
+		option:  option,
+		manager: m,
+		records: make(chan captureRecord, captureQueueSize),
+		written: atomic.NewInt64(0),
+		dropped: atomic.NewInt64(0),
+		start:   time.Now(),
+		done:    make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+
+	matched := []*trackerInfo{}
+	m.connections.Range(func(key, value any) bool {
+		if info := value.(tracker).info(); c.match(info) {
+			matched = append(matched, info)
+		}
+		return true
+	})
+	if option.ConnectionID != "" && len(matched) == 0 {
+		return nil, ErrCaptureNoMatch
+	}
+
+	file, err := os.CreateTemp("", "clash-capture-*.pcapng")
+	if err != nil {
+		return nil, err
+	}
+	writer, err := pcapng.NewWriter(file, pcapng.LinkTypeRaw)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	c.file = file
+	c.path = file.Name()
+	c.writer = writer
+
+	// a filter keeps following new connections until the capture stops
+	c.follow = option.ConnectionID == ""
+	m.captures.Store(c.id, c)
+	for _, info := range matched {
+		m.attach(info, c)
+	}
+
+	go c.process()
+	log.Infoln("[Capture] %s started, writing to %s", c.id, c.path)
+	return c, nil
+}
+
+// Capture return a running or finished capture by id
+func (m *Manager) Capture(id string) (*Capture, bool) {
+	item, ok := m.captures.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return item.(*Capture), true
+}
+
+// RemoveCapture stops the capture and deletes its file
+func (m *Manager) RemoveCapture(id string) bool {
+	c, ok := m.Capture(id)
+	if !ok {
+		return false
+	}
+
+	c.Stop()
+	<-c.closed
+	m.captures.Delete(id)
+	os.Remove(c.path)
+	return true
+}
+
+func (m *Manager) attachCapture(info *trackerInfo) {
+	m.captures.Range(func(key, value any) bool {
+		c := value.(*Capture)
+		if c.follow && !c.stopped() && c.match(info) {
+			m.attach(info, c)
+			return false
+		}
+		return true
+	})
+}
+
+// attach taps the connection of info into c, a connection of the tun is looked up by the
+// addresses of its packets
+func (m *Manager) attach(info *trackerInfo, c *Capture) {
+	info.capture.Store(c)
+	if flow, ok := tunFlowOf(info); ok {
+		if _, loaded := m.tunFlows.LoadOrStore(flow, info); !loaded {
+			m.tunFlowCount.Inc()
+		}
+	}
+}
+
+func (m *Manager) removeTunFlow(info *trackerInfo) {
+	if flow, ok := tunFlowOf(info); ok && m.tunFlows.CompareAndDelete(flow, info) {
+		m.tunFlowCount.Dec()
+	}
+}
+
+// TappingPackets reports whether a capture waits for the packets of a tun connection
+func (m *Manager) TappingPackets() bool {
+	return m.tunFlowCount.Load() != 0
+}
+
+// TapPacket is called by the tun with the ip packets of its device from src to dst, the
+// ones of a captured connection are copied into the capture, it never blocks
+func (m *Manager) TapPacket(network C.NetWork, src, dst netip.AddrPort, packet []byte) {
+	upload := true
+	item, ok := m.tunFlows.Load(tunFlow{network: network, src: src, dst: dst})
+	if !ok {
+		upload = false
+		if item, ok = m.tunFlows.Load(tunFlow{network: network, src: dst, dst: src}); !ok {
+			return
+		}
+	}
+
+	info := item.(*trackerInfo)
+	if c := info.capture.Load(); c != nil {
+		c.queue(captureRecord{info: info, upload: upload, raw: true}, packet)
+	}
+}
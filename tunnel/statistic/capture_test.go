@@ -0,0 +1,120 @@
+package statistic
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readPcapng returns the packets of the enhanced packet blocks of a pcap-ng file
+func readPcapng(t *testing.T, data []byte) [][]byte {
+	const blockEnhancedPacket = 6
+	packets := [][]byte{}
+	for len(data) > 0 {
+		require.GreaterOrEqual(t, len(data), 12)
+		blockType, total := binary.LittleEndian.Uint32(data), binary.LittleEndian.Uint32(data[4:])
+		require.LessOrEqual(t, int(total), len(data))
+		require.Equal(t, total, binary.LittleEndian.Uint32(data[total-4:]))
+		if blockType == blockEnhancedPacket {
+			captured := binary.LittleEndian.Uint32(data[20:])
+			packets = append(packets, data[28:28+captured])
+		}
+		data = data[total:]
+	}
+	return packets
+}
+
+func TestCapture_Records(t *testing.T) {
+	m := newTestManager()
+	client, server := net.Pipe()
+	defer server.Close()
+	tt := NewTCPTracker(&chainConn{Conn: client, chain: C.Chain{"DIRECT"}}, m, testMetadata(7), nil, nil)
+	defer tt.Close()
+
+	c, err := m.StartCapture(CaptureOption{ConnectionID: tt.ID()})
+	require.NoError(t, err)
+	defer m.RemoveCapture(c.ID())
+
+	// queued right before the stop, the tail is written all the same
+	payloads := []string{"GET / HTTP/1.1\r\n", "Host: example.com\r\n\r\n", "HTTP/1.1 200 OK\r\n"}
+	tt.tap(true, []byte(payloads[0]))
+	tt.tap(true, []byte(payloads[1]))
+	tt.tap(false, []byte(payloads[2]))
+	c.Stop()
+	<-c.Done()
+
+	data, err := os.ReadFile(c.Path())
+	require.NoError(t, err)
+	packets := readPcapng(t, data)
+	require.Len(t, packets, len(payloads))
+
+	seq := uint32(1)
+	for i, pkt := range packets {
+		// ipv4 and tcp headers without options
+		require.Greater(t, len(pkt), 40)
+		ip, tcp := pkt[:20], pkt[20:40]
+		upload := i < 2
+		src, dst := net.IP(ip[12:16]), net.IP(ip[16:20])
+		if upload {
+			assert.Equal(t, "192.168.1.7", src.String())
+			assert.Equal(t, "1.1.1.1", dst.String())
+			assert.Equal(t, uint16(10007), binary.BigEndian.Uint16(tcp[0:]))
+			assert.Equal(t, seq, binary.BigEndian.Uint32(tcp[4:]))
+			seq += uint32(len(payloads[i]))
+		} else {
+			assert.Equal(t, "1.1.1.1", src.String())
+			assert.Equal(t, uint16(443), binary.BigEndian.Uint16(tcp[0:]))
+			assert.Equal(t, seq, binary.BigEndian.Uint32(tcp[8:]))
+		}
+		assert.Equal(t, payloads[i], string(pkt[40:]))
+	}
+
+	// stopped, the relay isn't tapped any more
+	tt.tap(true, []byte("late"))
+	assert.Zero(t, c.dropped.Load())
+}
+
+func TestCapture_TunPackets(t *testing.T) {
+	m := newTestManager()
+	client, server := net.Pipe()
+	defer server.Close()
+	metadata := testMetadata(7)
+	metadata.Type = C.TUN
+	metadata.OriginDst = netip.MustParseAddrPort("1.1.1.1:443")
+	tt := NewTCPTracker(&chainConn{Conn: client, chain: C.Chain{"DIRECT"}}, m, metadata, nil, nil)
+
+	assert.False(t, m.TappingPackets())
+	c, err := m.StartCapture(CaptureOption{ConnectionID: tt.ID()})
+	require.NoError(t, err)
+	defer m.RemoveCapture(c.ID())
+	assert.True(t, m.TappingPackets())
+
+	local, remote := netip.MustParseAddrPort("192.168.1.7:10007"), netip.MustParseAddrPort("1.1.1.1:443")
+	// the relay of a tun connection is left to the packets of the device
+	tt.tap(true, []byte("relayed"))
+	m.TapPacket(C.TCP, local, remote, []byte("upload packet"))
+	m.TapPacket(C.TCP, remote, local, []byte("download packet"))
+	m.TapPacket(C.UDP, local, remote, []byte("other flow"))
+	m.TapPacket(C.TCP, local, netip.MustParseAddrPort("1.1.1.1:80"), []byte("other flow"))
+
+	// the flow is forgotten with the connection
+	tt.Close()
+	assert.False(t, m.TappingPackets())
+	m.TapPacket(C.TCP, local, remote, []byte("closed"))
+
+	c.Stop()
+	<-c.Done()
+	data, err := os.ReadFile(c.Path())
+	require.NoError(t, err)
+	packets := readPcapng(t, data)
+	require.Len(t, packets, 2)
+	assert.Equal(t, "upload packet", string(packets[0]))
+	assert.Equal(t, "download packet", string(packets[1]))
+}
@@ -0,0 +1,216 @@
+package statistic
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// types of the connection events
+const (
+	EventSnapshot = "snapshot"
+	EventCreate   = "create"
+	EventUpdate   = "update"
+	EventClose    = "close"
+)
+
+// maxPendingEvents is how far behind the create and close events a subscriber may fall
+// before it is cut off
+const maxPendingEvents = 16384
+
+// ConnectionEvent is a message of the connection event stream. The ids are the ones of
+// /connections, a snapshot carries the connections open when subscribing, a create the new
+// connection, an update and a close the byte counts of one, the close with its reason.
+type ConnectionEvent struct {
+	Type        string
+	ID          string
+	Connection  tracker
+	Connections []tracker
+	Upload      int64
+	Download    int64
+	Reason      string
+}
+
+// MarshalJSON writes the fields of the event type only
+func (e ConnectionEvent) MarshalJSON() ([]byte, error) {
+	switch e.Type {
+	case EventSnapshot:
+		return json.Marshal(struct {
+			Type        string    `json:"type"`
+			Connections []tracker `json:"connections"`
+		}{e.Type, e.Connections})
+	case EventCreate:
+		return json.Marshal(struct {
+			Type       string  `json:"type"`
+			ID         string  `json:"id"`
+			Connection tracker `json:"connection"`
+		}{e.Type, e.ID, e.Connection})
+	}
+	return json.Marshal(struct {
+		Type     string `json:"type"`
+		ID       string `json:"id"`
+		Upload   int64  `json:"upload"`
+		Download int64  `json:"download"`
+		Reason   string `json:"reason,omitempty"`
+	}{e.Type, e.ID, e.Upload, e.Download, e.Reason})
+}
+
+type knownConn struct {
+	tracker  tracker
+	upload   int64
+	download int64
+}
+
+// ConnectionEvents is the subscription of one consumer to the connection events. The create
+// and close events are queued and never dropped, a consumer too slow for them is cut off
+// and Done is closed. The updates are made on demand from the connections the consumer
+// knows, a consumer behind on them simply misses some.
+type ConnectionEvents struct {
+	manager *Manager
+	notify  chan struct{}
+	done    chan struct{}
+	once    sync.Once
+
+	mux     sync.Mutex
+	pending []ConnectionEvent
+	known   map[string]*knownConn
+	cut     bool
+}
+
+// SubscribeConnections starts queuing the create and close events, the first message is
+// the Snapshot taken right after
+func (m *Manager) SubscribeConnections() *ConnectionEvents {
+	s := &ConnectionEvents{
+		manager: m,
+		notify:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		known:   map[string]*knownConn{},
+	}
+	m.eventMux.Lock()
+	if m.subscribers == nil {
+		m.subscribers = map[*ConnectionEvents]struct{}{}
+	}
+	m.subscribers[s] = struct{}{}
+	m.eventMux.Unlock()
+	return s
+}
+
+func (m *Manager) publish(event ConnectionEvent) {
+	m.eventMux.RLock()
+	defer m.eventMux.RUnlock()
+	for s := range m.subscribers {
+		s.push(event)
+	}
+}
+
+func (s *ConnectionEvents) push(event ConnectionEvent) {
+	s.mux.Lock()
+	if s.cut {
+		s.mux.Unlock()
+		return
+	}
+	if len(s.pending) >= maxPendingEvents {
+		s.cut = true
+		s.mux.Unlock()
+		// the manager holds eventMux while publishing
+		go s.Close()
+		return
+	}
+	s.pending = append(s.pending, event)
+	s.mux.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Snapshot return the snapshot event of the connections open now, they are the ones the
+// updates are made for until their close
+func (s *ConnectionEvents) Snapshot() ConnectionEvent {
+	connections := []tracker{}
+	s.manager.connections.Range(func(key, value any) bool {
+		connections = append(connections, value.(tracker))
+		return true
+	})
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, c := range connections {
+		info := c.info()
+		s.known[c.ID()] = &knownConn{tracker: c, upload: info.UploadTotal.Load(), download: info.DownloadTotal.Load()}
+	}
+	return ConnectionEvent{Type: EventSnapshot, Connections: connections}
+}
+
+// Notify is signaled when create or close events are pending
+func (s *ConnectionEvents) Notify() <-chan struct{} {
+	return s.notify
+}
+
+// Done is closed when the subscription is closed or cut off
+func (s *ConnectionEvents) Done() <-chan struct{} {
+	return s.done
+}
+
+// Pending return the number of create and close events not taken yet
+func (s *ConnectionEvents) Pending() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.pending)
+}
+
+// Lifecycle takes the pending create and close events. As the queuing starts before the
+// snapshot, a create already in it and a close of a connection gone before it are left out.
+func (s *ConnectionEvents) Lifecycle() []ConnectionEvent {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	events := s.pending[:0:0]
+	for _, event := range s.pending {
+		switch event.Type {
+		case EventCreate:
+			if _, ok := s.known[event.ID]; ok {
+				continue
+			}
+			info := event.Connection.info()
+			s.known[event.ID] = &knownConn{tracker: event.Connection, upload: info.UploadTotal.Load(), download: info.DownloadTotal.Load()}
+		case EventClose:
+			if _, ok := s.known[event.ID]; !ok {
+				continue
+			}
+			delete(s.known, event.ID)
+		}
+		events = append(events, event)
+	}
+	s.pending = nil
+	return events
+}
+
+// Updates return an update event for each known connection whose counts changed since the
+// last updates
+func (s *ConnectionEvents) Updates() []ConnectionEvent {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var events []ConnectionEvent
+	for id, k := range s.known {
+		info := k.tracker.info()
+		upload, download := info.UploadTotal.Load(), info.DownloadTotal.Load()
+		if upload == k.upload && download == k.download {
+			continue
+		}
+		k.upload, k.download = upload, download
+		events = append(events, ConnectionEvent{Type: EventUpdate, ID: id, Upload: upload, Download: download})
+	}
+	return events
+}
+
+// Close stops the subscription
+func (s *ConnectionEvents) Close() {
+	s.once.Do(func() {
+		s.manager.eventMux.Lock()
+		delete(s.manager.subscribers, s)
+		s.manager.eventMux.Unlock()
+		close(s.done)
+	})
+}
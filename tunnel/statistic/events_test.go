@@ -0,0 +1,77 @@
+package statistic
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionEvents(t *testing.T) {
+	m := newTestManager()
+	before := track(m, "hk", "Proxy")
+	gone := track(m, "hk", "Proxy")
+
+	events := m.SubscribeConnections()
+	defer events.Close()
+	// created before the snapshot, already in it
+	during := track(m, "jp", "Proxy")
+	gone.Close()
+
+	snapshot := events.Snapshot()
+	require.Len(t, snapshot.Connections, 2)
+	assert.Empty(t, events.Lifecycle())
+
+	after := track(m, "us", "Proxy")
+	select {
+	case <-events.Notify():
+	default:
+		require.FailNow(t, "no notify")
+	}
+	lifecycle := events.Lifecycle()
+	require.Len(t, lifecycle, 1)
+	assert.Equal(t, EventCreate, lifecycle[0].Type)
+	assert.Equal(t, after.ID(), lifecycle[0].ID)
+
+	assert.Empty(t, events.Updates())
+	before.UploadTotal.Add(3)
+	after.DownloadTotal.Add(5)
+	updates := map[string]ConnectionEvent{}
+	for _, event := range events.Updates() {
+		updates[event.ID] = event
+	}
+	assert.Len(t, updates, 2)
+	assert.Equal(t, int64(3), updates[before.ID()].Upload)
+	assert.Equal(t, int64(5), updates[after.ID()].Download)
+	assert.Empty(t, events.Updates())
+
+	during.SetCloseReason(CloseReasonAPI)
+	during.UploadTotal.Add(7)
+	during.Close()
+	lifecycle = events.Lifecycle()
+	require.Len(t, lifecycle, 1)
+	buf, err := json.Marshal(lifecycle[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"close","id":"`+during.ID()+`","upload":7,"download":0,"reason":"api"}`, string(buf))
+}
+
+func TestConnectionEvents_CutOff(t *testing.T) {
+	m := newTestManager()
+	events := m.SubscribeConnections()
+	events.Snapshot()
+	for i := 0; i < maxPendingEvents; i++ {
+		m.publish(ConnectionEvent{Type: EventUpdate})
+	}
+	select {
+	case <-events.Done():
+		require.FailNow(t, "cut off early")
+	default:
+	}
+
+	m.publish(ConnectionEvent{Type: EventUpdate})
+	<-events.Done()
+	m.eventMux.RLock()
+	assert.Empty(t, m.subscribers)
+	m.eventMux.RUnlock()
+}
@@ -0,0 +1,63 @@
+package statistic
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+)
+
+const (
+	internSize   = 4096
+	internMaxLen = 256
+)
+
+// interner dedups the strings repeated across connections, such as hosts and
+// ports, it forgets everything once full so it never grows past internSize
+type interner struct {
+	mux  sync.Mutex
+	strs map[string]string
+}
+
+func (i *interner) intern(s string) string {
+	if s == "" || len(s) > internMaxLen {
+		return s
+	}
+
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	if v, ok := i.strs[s]; ok {
+		return v
+	}
+	if i.strs == nil || len(i.strs) >= internSize {
+		i.strs = make(map[string]string, internSize)
+	}
+	i.strs[s] = s
+	return s
+}
+
+var strs = &interner{}
+
+// internMetadata swaps the strings of metadata for shared copies, the
+// tracker owns metadata from then on so the duplicates can be collected
+func internMetadata(metadata *C.Metadata) {
+	metadata.Host = strs.intern(metadata.Host)
+	metadata.DstPort = strs.intern(metadata.DstPort)
+	metadata.ProcessPath = strs.intern(metadata.ProcessPath)
+	metadata.SpecialProxy = strs.intern(metadata.SpecialProxy)
+	metadata.OriginDestination = strs.intern(metadata.OriginDestination)
+}
+
+// timestamp is a time.Time in unix nanoseconds, it is encoded like time.Time
+type timestamp int64
+
+func (t timestamp) Time() time.Time {
+	return time.Unix(0, int64(t))
+}
+
+// MarshalJSON implements json.Marshaler
+func (t timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Time())
+}
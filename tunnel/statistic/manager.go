@@ -7,6 +7,15 @@ import (
 	"go.uber.org/atomic"
 )
 
+const (
+	// CloseClientAbandoned counts connections whose client went away before the dial completed
+	CloseClientAbandoned = "client-abandoned"
+	// CloseDialPoolFull counts connections rejected after waiting too long for a dial slot
+	CloseDialPoolFull = "dial-pool-full"
+	// CloseHostMismatch counts connections rejected by host-mismatch for their tls server name
+	CloseHostMismatch = "host-mismatch"
+)
+
 var DefaultManager *Manager
 
 func init() {
@@ -24,6 +33,14 @@ func init() {
 
 type Manager struct {
 	connections   sync.Map
+	captures      sync.Map
+	tunFlows      sync.Map // tunFlow -> *trackerInfo of a captured tun connection
+	tunFlowCount  atomic.Int64
+	closeReasons  sync.Map // reason -> *atomic.Int64
+	chainMux      sync.Mutex
+	chains        map[string]map[tracker]struct{} // proxy in the chain -> trackers
+	eventMux      sync.RWMutex
+	subscribers   map[*ConnectionEvents]struct{}
 	uploadTemp    *atomic.Int64
 	downloadTemp  *atomic.Int64
 	uploadBlip    *atomic.Int64
@@ -33,11 +50,28 @@ type Manager struct {
 }
 
 func (m *Manager) Join(c tracker) {
+	m.attachCapture(c.info())
 	m.connections.Store(c.ID(), c)
+	m.indexChain(c, c.info().Chain)
+	m.publish(ConnectionEvent{Type: EventCreate, ID: c.ID(), Connection: c})
 }
 
 func (m *Manager) Leave(c tracker) {
-	m.connections.Delete(c.ID())
+	if _, loaded := m.connections.LoadAndDelete(c.ID()); loaded {
+		m.unindexChain(c, c.info().Chain)
+		logAccess(c)
+		info := c.info()
+		if info.capture.Load() != nil {
+			m.removeTunFlow(info)
+		}
+		m.publish(ConnectionEvent{
+			Type:     EventClose,
+			ID:       c.ID(),
+			Upload:   info.UploadTotal.Load(),
+			Download: info.DownloadTotal.Load(),
+			Reason:   info.closeReason(),
+		})
+	}
 }
 
 func (m *Manager) PushUploaded(size int64) {
@@ -50,10 +84,21 @@ func (m *Manager) PushDownloaded(size int64) {
 	m.downloadTotal.Add(size)
 }
 
+// CountClose records a connection closed for reason before it was tracked
+func (m *Manager) CountClose(reason string) {
+	counter, _ := m.closeReasons.LoadOrStore(reason, atomic.NewInt64(0))
+	counter.(*atomic.Int64).Inc()
+}
+
 func (m *Manager) Now() (up int64, down int64) {
 	return m.uploadBlip.Load(), m.downloadBlip.Load()
 }
 
+// Total return the bytes uploaded and downloaded since the start or the last reset
+func (m *Manager) Total() (up int64, down int64) {
+	return m.uploadTotal.Load(), m.downloadTotal.Load()
+}
+
 func (m *Manager) Snapshot() *Snapshot {
 	connections := []tracker{}
 	m.connections.Range(func(key, value any) bool {
@@ -61,10 +106,17 @@ func (m *Manager) Snapshot() *Snapshot {
 		return true
 	})
 
+	closeReasons := map[string]int64{}
+	m.closeReasons.Range(func(key, value any) bool {
+		closeReasons[key.(string)] = value.(*atomic.Int64).Load()
+		return true
+	})
+
 	return &Snapshot{
 		UploadTotal:   m.uploadTotal.Load(),
 		DownloadTotal: m.downloadTotal.Load(),
 		Connections:   connections,
+		CloseReasons:  closeReasons,
 	}
 }
 
@@ -75,6 +127,10 @@ func (m *Manager) ResetStatistic() {
 	m.downloadTemp.Store(0)
 	m.downloadBlip.Store(0)
 	m.downloadTotal.Store(0)
+	m.closeReasons.Range(func(key, value any) bool {
+		value.(*atomic.Int64).Store(0)
+		return true
+	})
 }
 
 func (m *Manager) handle() {
@@ -89,7 +145,8 @@ func (m *Manager) handle() {
 }
 
 type Snapshot struct {
-	DownloadTotal int64     `json:"downloadTotal"`
-	UploadTotal   int64     `json:"uploadTotal"`
-	Connections   []tracker `json:"connections"`
+	DownloadTotal int64            `json:"downloadTotal"`
+	UploadTotal   int64            `json:"uploadTotal"`
+	Connections   []tracker        `json:"connections"`
+	CloseReasons  map[string]int64 `json:"closeReasons"`
 }
@@ -0,0 +1,239 @@
+package statistic
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/log"
+
+	"go.uber.org/atomic"
+)
+
+// ClosePolicy decides what happens to the connections through a proxy that
+// is no longer selected or no longer exists
+type ClosePolicy int32
+
+const (
+	// ClosePolicyKeep leaves the connections on the old proxy
+	ClosePolicyKeep ClosePolicy = iota
+	// ClosePolicyImmediate closes the connections at once
+	ClosePolicyImmediate
+	// ClosePolicyGraceful closes a connection once it has been idle for closeIdleGrace
+	ClosePolicyGraceful
+)
+
+const closeIdleGrace = 5 * time.Second
+
+// ClosePolicyMapping is a mapping for ClosePolicy enum
+var ClosePolicyMapping = map[string]ClosePolicy{
+	ClosePolicyKeep.String():      ClosePolicyKeep,
+	ClosePolicyImmediate.String(): ClosePolicyImmediate,
+	ClosePolicyGraceful.String():  ClosePolicyGraceful,
+}
+
+var closePolicy = atomic.NewInt32(int32(ClosePolicyKeep))
+
+// SetClosePolicy sets the policy used when a request doesn't give one
+func SetClosePolicy(p ClosePolicy) {
+	closePolicy.Store(int32(p))
+}
+
+// DefaultClosePolicy returns the policy set by SetClosePolicy
+func DefaultClosePolicy() ClosePolicy {
+	return ClosePolicy(closePolicy.Load())
+}
+
+// ParseClosePolicy parses the name of a policy, an empty name is the default policy
+func ParseClosePolicy(name string) (ClosePolicy, error) {
+	if name == "" {
+		return DefaultClosePolicy(), nil
+	}
+
+	p, exist := ClosePolicyMapping[strings.ToLower(name)]
+	if !exist {
+		return ClosePolicyKeep, errors.New("invalid close policy")
+	}
+	return p, nil
+}
+
+// UnmarshalYAML unserialize ClosePolicy with yaml
+func (p *ClosePolicy) UnmarshalYAML(unmarshal func(any) error) error {
+	var tp string
+	if err := unmarshal(&tp); err != nil {
+		return err
+	}
+	policy, exist := ClosePolicyMapping[strings.ToLower(tp)]
+	if !exist {
+		return errors.New("invalid close policy")
+	}
+	*p = policy
+	return nil
+}
+
+// MarshalJSON serialize ClosePolicy
+func (p ClosePolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+func (p ClosePolicy) String() string {
+	switch p {
+	case ClosePolicyKeep:
+		return "keep"
+	case ClosePolicyImmediate:
+		return "immediate"
+	case ClosePolicyGraceful:
+		return "graceful"
+	default:
+		return "Unknown"
+	}
+}
+
+// CloseSwitched applies the policy to the connections through group that
+// don't go through its new selection
+func (m *Manager) CloseSwitched(group, selected string, policy ClosePolicy) {
+	if policy == ClosePolicyKeep {
+		return
+	}
+
+	var affected []tracker
+	for _, t := range m.through(group) {
+		chain := t.info().Chain
+		for i, name := range chain {
+			// the chain starts from the node, so the proxy before the group is the one it selected
+			if name == group && (i == 0 || chain[i-1] != selected) {
+				affected = append(affected, t)
+				break
+			}
+		}
+	}
+	m.closeBy(affected, policy)
+}
+
+// CloseRemoved applies the policy to the connections through any of the removed proxies
+func (m *Manager) CloseRemoved(names []string, policy ClosePolicy) {
+	if policy == ClosePolicyKeep || len(names) == 0 {
+		return
+	}
+
+	affected := map[string]tracker{}
+	for _, name := range names {
+		for _, t := range m.through(name) {
+			affected[t.ID()] = t
+		}
+	}
+
+	trackers := make([]tracker, 0, len(affected))
+	for _, t := range affected {
+		trackers = append(trackers, t)
+	}
+	m.closeBy(trackers, policy)
+}
+
+func (m *Manager) closeBy(trackers []tracker, policy ClosePolicy) {
+	if len(trackers) == 0 {
+		return
+	}
+
+	log.Debugln("[Statistic] close %d connections on proxy change (%s)", len(trackers), policy)
+	switch policy {
+	case ClosePolicyImmediate:
+		for _, t := range trackers {
+			t.SetCloseReason(CloseReasonProxyChange)
+			t.Close()
+		}
+	case ClosePolicyGraceful:
+		go m.closeIdle(trackers, time.Second, closeIdleGrace)
+	}
+}
+
+type idleWatch struct {
+	tracker tracker
+	total   int64
+	idle    time.Duration
+}
+
+// closeIdle closes each connection once its traffic hasn't moved for grace
+func (m *Manager) closeIdle(trackers []tracker, interval, grace time.Duration) {
+	pending := make([]*idleWatch, 0, len(trackers))
+	for _, t := range trackers {
+		pending = append(pending, &idleWatch{tracker: t, total: trafficOf(t.info())})
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for len(pending) != 0 {
+		<-ticker.C
+
+		rest := pending[:0]
+		for _, w := range pending {
+			if _, ok := m.connections.Load(w.tracker.ID()); !ok {
+				continue
+			}
+
+			if total := trafficOf(w.tracker.info()); total != w.total {
+				w.total = total
+				w.idle = 0
+			} else {
+				w.idle += interval
+			}
+
+			if w.idle >= grace {
+				w.tracker.SetCloseReason(CloseReasonProxyChange)
+				w.tracker.Close()
+				continue
+			}
+			rest = append(rest, w)
+		}
+		pending = rest
+	}
+}
+
+func trafficOf(info *trackerInfo) int64 {
+	return info.UploadTotal.Load() + info.DownloadTotal.Load()
+}
+
+// through returns the connections with name in their chain
+func (m *Manager) through(name string) []tracker {
+	m.chainMux.Lock()
+	defer m.chainMux.Unlock()
+
+	trackers := make([]tracker, 0, len(m.chains[name]))
+	for t := range m.chains[name] {
+		trackers = append(trackers, t)
+	}
+	return trackers
+}
+
+func (m *Manager) indexChain(c tracker, chain C.Chain) {
+	m.chainMux.Lock()
+	defer m.chainMux.Unlock()
+
+	if m.chains == nil {
+		m.chains = map[string]map[tracker]struct{}{}
+	}
+	for _, name := range chain {
+		trackers, ok := m.chains[name]
+		if !ok {
+			trackers = map[tracker]struct{}{}
+			m.chains[name] = trackers
+		}
+		trackers[c] = struct{}{}
+	}
+}
+
+func (m *Manager) unindexChain(c tracker, chain C.Chain) {
+	m.chainMux.Lock()
+	defer m.chainMux.Unlock()
+
+	for _, name := range chain {
+		trackers := m.chains[name]
+		delete(trackers, c)
+		if len(trackers) == 0 {
+			delete(m.chains, name)
+		}
+	}
+}
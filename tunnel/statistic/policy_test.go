@@ -0,0 +1,87 @@
+package statistic
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+)
+
+type chainConn struct {
+	net.Conn
+	chain C.Chain
+}
+
+func (c *chainConn) Chains() C.Chain                 { return c.chain }
+func (c *chainConn) AppendToChains(a C.ProxyAdapter) { c.chain = append(c.chain, a.Name()) }
+
+func newTestManager() *Manager {
+	return &Manager{
+		uploadTemp:    atomic.NewInt64(0),
+		downloadTemp:  atomic.NewInt64(0),
+		uploadBlip:    atomic.NewInt64(0),
+		downloadBlip:  atomic.NewInt64(0),
+		uploadTotal:   atomic.NewInt64(0),
+		downloadTotal: atomic.NewInt64(0),
+	}
+}
+
+func track(m *Manager, chain ...string) *tcpTracker {
+	client, _ := net.Pipe()
+	return NewTCPTracker(&chainConn{Conn: client, chain: chain}, m, &C.Metadata{}, nil, nil)
+}
+
+func alive(m *Manager, t tracker) bool {
+	_, ok := m.connections.Load(t.ID())
+	return ok
+}
+
+func TestCloseSwitched(t *testing.T) {
+	m := newTestManager()
+	old := track(m, "hk", "Proxy")
+	nested := track(m, "jp", "Auto", "Proxy")
+	selected := track(m, "us", "Proxy")
+	other := track(m, "hk", "Streaming")
+
+	m.CloseSwitched("Proxy", "us", ClosePolicyKeep)
+	assert.True(t, alive(m, old))
+
+	m.CloseSwitched("Proxy", "us", ClosePolicyImmediate)
+	assert.False(t, alive(m, old))
+	assert.False(t, alive(m, nested))
+	assert.True(t, alive(m, selected))
+	assert.True(t, alive(m, other))
+	assert.Len(t, m.through("hk"), 1)
+}
+
+func TestCloseIdle(t *testing.T) {
+	m := newTestManager()
+	idle := track(m, "hk")
+	busy := track(m, "hk")
+
+	done := make(chan struct{})
+	go func() {
+		m.closeIdle(m.through("hk"), 10*time.Millisecond, 50*time.Millisecond)
+		close(done)
+	}()
+
+	for i := 0; i < 10; i++ {
+		busy.DownloadTotal.Add(1)
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, alive(m, idle))
+	assert.True(t, alive(m, busy))
+
+	select {
+	case <-done:
+		t.Fatal("busy connection closed")
+	default:
+	}
+
+	<-done
+	assert.False(t, alive(m, busy))
+}
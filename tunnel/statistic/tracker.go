@@ -1,6 +1,7 @@
 package statistic
 
 import (
+	"encoding/json"
 	"net"
 	"time"
 
@@ -13,17 +14,82 @@ import (
 type tracker interface {
 	ID() string
 	Close() error
+	SetCloseReason(reason string)
+	info() *trackerInfo
 }
 
 type trackerInfo struct {
-	UUID          uuid.UUID     `json:"id"`
-	Metadata      *C.Metadata   `json:"metadata"`
-	UploadTotal   *atomic.Int64 `json:"upload"`
-	DownloadTotal *atomic.Int64 `json:"download"`
-	Start         time.Time     `json:"start"`
-	Chain         C.Chain       `json:"chains"`
-	Rule          string        `json:"rule"`
-	RulePayload   string        `json:"rulePayload"`
+	UUID          uuid.UUID    `json:"id"`
+	Metadata      *C.Metadata  `json:"metadata"`
+	UploadTotal   atomic.Int64 `json:"upload"`
+	DownloadTotal atomic.Int64 `json:"download"`
+	Start         timestamp    `json:"start"`
+	Chain         C.Chain      `json:"chains"`
+	Rule          string       `json:"rule"`
+	RulePayload   string       `json:"rulePayload"`
+
+	id       string // UUID as string, the key of the manager
+	capture  atomic.Pointer[Capture]
+	reason   atomic.Pointer[string]
+	endpoint C.TunEndpoint
+}
+
+func newTrackerInfo(metadata *C.Metadata, chain C.Chain, rule C.Rule) *trackerInfo {
+	uuid, _ := uuid.NewV4()
+	internMetadata(metadata)
+
+	ti := &trackerInfo{
+		UUID:     uuid,
+		Start:    timestamp(time.Now().UnixNano()),
+		Metadata: metadata,
+		Chain:    chain,
+		id:       uuid.String(),
+	}
+
+	if rule != nil {
+		ti.Rule = rule.RuleType().String()
+		ti.RulePayload = strs.intern(rule.Payload())
+	}
+	return ti
+}
+
+func (ti *trackerInfo) ID() string {
+	return ti.id
+}
+
+func (ti *trackerInfo) info() *trackerInfo {
+	return ti
+}
+
+// SetCloseReason records why the connection is about to be closed, the first reason is kept
+func (ti *trackerInfo) SetCloseReason(reason string) {
+	ti.reason.CompareAndSwap(nil, &reason)
+}
+
+func (ti *trackerInfo) closeReason() string {
+	if reason := ti.reason.Load(); reason != nil {
+		return *reason
+	}
+	return CloseReasonClosed
+}
+
+// MarshalJSON reads the tun endpoint state when the snapshot is encoded
+func (ti *trackerInfo) MarshalJSON() ([]byte, error) {
+	type plain trackerInfo
+	if ti.endpoint == nil {
+		return json.Marshal((*plain)(ti))
+	}
+
+	return json.Marshal(struct {
+		*plain
+		Endpoint C.TunEndpointState `json:"endpoint"`
+	}{(*plain)(ti), ti.endpoint.EndpointState()})
+}
+
+func (ti *trackerInfo) tap(upload bool, b []byte) {
+	if c := ti.capture.Load(); c != nil {
+		c.tap(ti, upload, b)
+	}
 }
 
 type tcpTracker struct {
@@ -32,12 +98,9 @@ type tcpTracker struct {
 	manager *Manager
 }
 
-func (tt *tcpTracker) ID() string {
-	return tt.UUID.String()
-}
-
 func (tt *tcpTracker) Read(b []byte) (int, error) {
 	n, err := tt.Conn.Read(b)
+	tt.tap(false, b[:n])
 	download := int64(n)
 	tt.manager.PushDownloaded(download)
 	tt.DownloadTotal.Add(download)
@@ -46,6 +109,7 @@ func (tt *tcpTracker) Read(b []byte) (int, error) {
 
 func (tt *tcpTracker) Write(b []byte) (int, error) {
 	n, err := tt.Conn.Write(b)
+	tt.tap(true, b[:n])
 	upload := int64(n)
 	tt.manager.PushUploaded(upload)
 	tt.UploadTotal.Add(upload)
@@ -57,26 +121,17 @@ func (tt *tcpTracker) Close() error {
 	return tt.Conn.Close()
 }
 
-func NewTCPTracker(conn C.Conn, manager *Manager, metadata *C.Metadata, rule C.Rule) *tcpTracker {
-	uuid, _ := uuid.NewV4()
-
+// NewTCPTracker tracks conn, the inbound conn is kept to report the endpoint state of tun connections.
+// The strings of metadata are interned in place, so it must not be modified concurrently.
+func NewTCPTracker(conn C.Conn, manager *Manager, metadata *C.Metadata, rule C.Rule, inbound net.Conn) *tcpTracker {
 	t := &tcpTracker{
-		Conn:    conn,
-		manager: manager,
-		trackerInfo: &trackerInfo{
-			UUID:          uuid,
-			Start:         time.Now(),
-			Metadata:      metadata,
-			Chain:         conn.Chains(),
-			Rule:          "",
-			UploadTotal:   atomic.NewInt64(0),
-			DownloadTotal: atomic.NewInt64(0),
-		},
+		Conn:        conn,
+		manager:     manager,
+		trackerInfo: newTrackerInfo(metadata, conn.Chains(), rule),
 	}
 
-	if rule != nil {
-		t.trackerInfo.Rule = rule.RuleType().String()
-		t.trackerInfo.RulePayload = rule.Payload()
+	if endpoint, ok := inbound.(C.TunEndpoint); ok {
+		t.trackerInfo.endpoint = endpoint
 	}
 
 	manager.Join(t)
@@ -87,14 +142,12 @@ type udpTracker struct {
 	C.PacketConn `json:"-"`
 	*trackerInfo
 	manager *Manager
-}
-
-func (ut *udpTracker) ID() string {
-	return ut.UUID.String()
+	flow    C.TunFlow
 }
 
 func (ut *udpTracker) ReadFrom(b []byte) (int, net.Addr, error) {
 	n, addr, err := ut.PacketConn.ReadFrom(b)
+	ut.tap(false, b[:n])
 	download := int64(n)
 	ut.manager.PushDownloaded(download)
 	ut.DownloadTotal.Add(download)
@@ -103,37 +156,35 @@ func (ut *udpTracker) ReadFrom(b []byte) (int, net.Addr, error) {
 
 func (ut *udpTracker) WriteTo(b []byte, addr net.Addr) (int, error) {
 	n, err := ut.PacketConn.WriteTo(b, addr)
+	ut.tap(true, b[:n])
 	upload := int64(n)
 	ut.manager.PushUploaded(upload)
 	ut.UploadTotal.Add(upload)
 	return n, err
 }
 
+// Close evicts the tun session of the flow too when it's closed with a reason, by the api or
+// a policy, the flows ending on their own leave it to its timeout
 func (ut *udpTracker) Close() error {
 	ut.manager.Leave(ut)
-	return ut.PacketConn.Close()
+	err := ut.PacketConn.Close()
+	if ut.flow != nil && ut.reason.Load() != nil {
+		ut.flow.Evict()
+	}
+	return err
 }
 
-func NewUDPTracker(conn C.PacketConn, manager *Manager, metadata *C.Metadata, rule C.Rule) *udpTracker {
-	uuid, _ := uuid.NewV4()
-
+// NewUDPTracker tracks conn like NewTCPTracker, the inbound packet is kept to evict the
+// session of tun flows
+func NewUDPTracker(conn C.PacketConn, manager *Manager, metadata *C.Metadata, rule C.Rule, inbound C.UDPPacket) *udpTracker {
 	ut := &udpTracker{
-		PacketConn: conn,
-		manager:    manager,
-		trackerInfo: &trackerInfo{
-			UUID:          uuid,
-			Start:         time.Now(),
-			Metadata:      metadata,
-			Chain:         conn.Chains(),
-			Rule:          "",
-			UploadTotal:   atomic.NewInt64(0),
-			DownloadTotal: atomic.NewInt64(0),
-		},
+		PacketConn:  conn,
+		manager:     manager,
+		trackerInfo: newTrackerInfo(metadata, conn.Chains(), rule),
 	}
 
-	if rule != nil {
-		ut.trackerInfo.Rule = rule.RuleType().String()
-		ut.trackerInfo.RulePayload = rule.Payload()
+	if flow, ok := inbound.(C.TunFlow); ok {
+		ut.flow = flow
 	}
 
 	manager.Join(ut)
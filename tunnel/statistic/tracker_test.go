@@ -0,0 +1,113 @@
+package statistic
+
+import (
+	"encoding/json"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRule struct{}
+
+func (testRule) RuleType() C.RuleType    { return C.Domain }
+func (testRule) Match(*C.Metadata) bool  { return true }
+func (testRule) Adapter() string         { return "Proxy" }
+func (testRule) Payload() string         { return "example.com" }
+func (testRule) ShouldResolveIP() bool   { return false }
+func (testRule) ShouldFindProcess() bool { return false }
+
+func testMetadata(i int) *C.Metadata {
+	return &C.Metadata{
+		NetWork: C.TCP,
+		Type:    C.HTTPCONNECT,
+		SrcIP:   net.IPv4(192, 168, 1, byte(i)),
+		DstIP:   net.IPv4(1, 1, 1, 1),
+		SrcPort: strconv.Itoa(10000 + i%50000),
+		// strings parsed from the requests of each connection
+		DstPort: strings.Clone("443"),
+		Host:    "www." + strconv.Itoa(i%500) + ".example.com",
+	}
+}
+
+func TestTracker_JSON(t *testing.T) {
+	m := newTestManager()
+	tt := NewTCPTracker(&chainConn{chain: C.Chain{"hk", "Proxy"}}, m, testMetadata(3), testRule{}, nil)
+	tt.UUID = uuid.Must(uuid.FromString("6ba7b810-9dad-11d1-80b4-00c04fd430c8"))
+	tt.Start = timestamp(1700000000123456789)
+	tt.UploadTotal.Add(12)
+
+	buf, err := json.Marshal(tt)
+	assert.NoError(t, err)
+	expected := `{"id":"6ba7b810-9dad-11d1-80b4-00c04fd430c8",` +
+		`"metadata":{"network":"tcp","type":"HTTP Connect","sourceIP":"192.168.1.3","destinationIP":"1.1.1.1","sourcePort":"10003","destinationPort":"443","host":"www.3.example.com","dnsMode":"normal","processPath":"","specialProxy":""},` +
+		`"upload":12,"download":0,"start":"` + tt.Start.Time().Format(time.RFC3339Nano) + `","chains":["hk","Proxy"],"rule":"Domain","rulePayload":"example.com"}`
+	assert.Equal(t, expected, string(buf))
+}
+
+type chainPacketConn struct {
+	net.PacketConn
+	chain C.Chain
+}
+
+func (c *chainPacketConn) Chains() C.Chain                 { return c.chain }
+func (c *chainPacketConn) AppendToChains(a C.ProxyAdapter) { c.chain = append(c.chain, a.Name()) }
+
+// tunPacket is a packet of the tun counting the evictions of its session
+type tunPacket struct {
+	C.UDPPacket
+	evicted int
+}
+
+func (p *tunPacket) Evict() { p.evicted++ }
+
+func TestUDPTracker_EvictTunFlow(t *testing.T) {
+	m := newTestManager()
+	listen := func() C.PacketConn {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		return &chainPacketConn{PacketConn: pc, chain: C.Chain{"DIRECT"}}
+	}
+
+	// ended on its own, the session is left to its timeout
+	packet := &tunPacket{}
+	ut := NewUDPTracker(listen(), m, testMetadata(1), nil, packet)
+	ut.Close()
+	assert.Equal(t, 0, packet.evicted)
+
+	// closed by the api
+	ut = NewUDPTracker(listen(), m, testMetadata(2), nil, packet)
+	ut.SetCloseReason(CloseReasonAPI)
+	ut.Close()
+	assert.Equal(t, 1, packet.evicted)
+	assert.False(t, alive(m, ut))
+}
+
+// BenchmarkTrack50k reports the heap held per tracked connection with 50k connections
+func BenchmarkTrack50k(b *testing.B) {
+	const conns = 50000
+
+	var stats runtime.MemStats
+	for i := 0; i < b.N; i++ {
+		m := newTestManager()
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		before := stats.HeapAlloc
+
+		for j := 0; j < conns; j++ {
+			NewTCPTracker(&chainConn{chain: C.Chain{"hk", "Proxy"}}, m, testMetadata(j), testRule{}, nil)
+		}
+
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		b.ReportMetric(float64(stats.HeapAlloc-before)/conns, "B/conn")
+		runtime.KeepAlive(m)
+	}
+}
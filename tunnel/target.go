@@ -0,0 +1,71 @@
+package tunnel
+
+import (
+	"strings"
+
+	C "github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/constant/provider"
+	"github.com/Dreamacro/clash/log"
+)
+
+// ProviderTargetPrefix marks a rule target naming a proxy of a proxy provider,
+// provider:<provider>/<proxy>
+const ProviderTargetPrefix = "provider:"
+
+// policy used when the proxy of a provider target is gone, empty skips the rule
+var providerFallback string
+
+// ParseProviderTarget splits a provider target, the proxy name may contain slashes
+func ParseProviderTarget(target string) (providerName, proxyName string, ok bool) {
+	rest, ok := strings.CutPrefix(target, ProviderTargetPrefix)
+	if !ok {
+		return "", "", false
+	}
+	providerName, proxyName, ok = strings.Cut(rest, "/")
+	if !ok || providerName == "" || proxyName == "" {
+		return "", "", false
+	}
+	return providerName, proxyName, true
+}
+
+// UpdateProviderFallback sets the policy used when a provider target can't be found
+func UpdateProviderFallback(policy string) {
+	configMux.Lock()
+	providerFallback = policy
+	configMux.Unlock()
+}
+
+// resolveTarget return the proxy of a rule target, provider targets are looked up
+// at match time so a provider update renaming or removing the proxy degrades to
+// the fallback policy. It's called with configMux held.
+func resolveTarget(target string) (C.Proxy, bool) {
+	if proxy, ok := proxies[target]; ok {
+		return proxy, true
+	}
+
+	providerName, proxyName, ok := ParseProviderTarget(target)
+	if !ok {
+		return nil, false
+	}
+
+	if pd, ok := providers[providerName]; ok {
+		if pa, ok := pd.(provider.ProxyAliases); ok {
+			if alias, ok := pa.Alias(proxyName); ok {
+				proxyName = alias
+			}
+		}
+		for _, proxy := range pd.Proxies() {
+			if proxy.Name() == proxyName {
+				return proxy, true
+			}
+		}
+	}
+
+	proxy, ok := proxies[providerFallback]
+	if ok {
+		log.Dedupln(log.WARNING, "provider-target|"+target, "[Matcher] %s not found, fallback to %s", target, providerFallback)
+	} else {
+		log.Dedupln(log.WARNING, "provider-target|"+target, "[Matcher] %s not found, rule skipped", target)
+	}
+	return proxy, ok
+}
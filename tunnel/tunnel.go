@@ -2,6 +2,7 @@ package tunnel
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
@@ -12,25 +13,30 @@ import (
 
 	"github.com/Dreamacro/clash/adapter/inbound"
 	"github.com/Dreamacro/clash/component/nat"
+	"github.com/Dreamacro/clash/component/power"
 	P "github.com/Dreamacro/clash/component/process"
 	"github.com/Dreamacro/clash/component/resolver"
 	C "github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/constant/provider"
 	icontext "github.com/Dreamacro/clash/context"
 	"github.com/Dreamacro/clash/log"
+	R "github.com/Dreamacro/clash/rule"
 	"github.com/Dreamacro/clash/tunnel/statistic"
 
 	"go.uber.org/atomic"
 )
 
 var (
-	tcpQueue  = make(chan C.ConnContext, 200)
-	udpQueue  = make(chan *inbound.PacketAdapter, 200)
-	natTable  = nat.New()
-	rules     []C.Rule
-	proxies   = make(map[string]C.Proxy)
-	providers map[string]provider.ProxyProvider
-	configMux sync.RWMutex
+	tcpQueue      = make(chan C.ConnContext, 200)
+	udpQueue      = make(chan *inbound.PacketAdapter, 200)
+	natTable      = nat.New()
+	rules         []C.Rule
+	compacted     []C.Rule
+	final         = "DIRECT"
+	proxies       = make(map[string]C.Proxy)
+	providers     map[string]provider.ProxyProvider
+	ruleProviders map[string]provider.RuleProvider
+	configMux     sync.RWMutex
 
 	// Outbound Rule
 	mode = Rule
@@ -40,8 +46,39 @@ var (
 
 	// experimental feature
 	UDPFallbackMatch = atomic.NewBool(false)
+	UDPRematch       = atomic.NewBool(false)
 )
 
+// udpSession is the nat entry of a udp flow, it keeps the verdict
+// the flow was dialed with so that it can be re-evaluated later
+type udpSession struct {
+	C.PacketConn
+	host  *atomic.String
+	proxy C.Proxy
+	rule  C.Rule
+	// dst is the destination the flow was dialed for, as the client sent it
+	dst     netip.AddrPort
+	dstHost string
+	// target is where the packets to dst are sent, after the rewrites
+	target *C.Metadata
+}
+
+// targetOf returns the resolved target of the packets to dst with host, nil when the packet
+// is for another destination of the client socket
+func (s *udpSession) targetOf(host string, dst netip.AddrPort) *C.Metadata {
+	if dst != s.dst || host != s.dstHost {
+		return nil
+	}
+	return s.target
+}
+
+// rematchable reports whether a packet to dst with host is the first one naming the host of
+// a session dialed without it. The nat entry is of the client socket, the packets of the socket
+// to other destinations, like the ones of a stun client to two servers, don't re-evaluate it
+func (s *udpSession) rematchable(host string, dst netip.AddrPort) bool {
+	return host != "" && dst == s.dst && s.host.CompareAndSwap("", host)
+}
+
 func init() {
 	go process()
 }
@@ -61,10 +98,35 @@ func Rules() []C.Rule {
 	return rules
 }
 
+// RuleProviders return all rule providers
+func RuleProviders() map[string]provider.RuleProvider {
+	configMux.RLock()
+	defer configMux.RUnlock()
+	return ruleProviders
+}
+
 // UpdateRules handle update rules
-func UpdateRules(newRules []C.Rule) {
+func UpdateRules(newRules []C.Rule, newRuleProviders map[string]provider.RuleProvider) {
+	compactedRules := R.Compact(newRules)
+
 	configMux.Lock()
 	rules = newRules
+	compacted = compactedRules
+	ruleProviders = newRuleProviders
+	configMux.Unlock()
+}
+
+// Final return the policy used when no rule matches
+func Final() string {
+	configMux.RLock()
+	defer configMux.RUnlock()
+	return final
+}
+
+// UpdateFinal handle update final policy
+func UpdateFinal(name string) {
+	configMux.Lock()
+	final = name
 	configMux.Unlock()
 }
 
@@ -97,6 +159,17 @@ func SetMode(m TunnelMode) {
 }
 
 // processUDP starts a loop to handle udp packet
+// Health reports an inbound queue not drained, a full queue stalls every listener
+func Health() error {
+	if len(tcpQueue) == cap(tcpQueue) {
+		return errors.New("the tcp queue of the tunnel is full")
+	}
+	if len(udpQueue) == cap(udpQueue) {
+		return errors.New("the udp queue of the tunnel is full")
+	}
+	return nil
+}
+
 func processUDP() {
 	queue := udpQueue
 	for conn := range queue {
@@ -180,6 +253,10 @@ func handleUDPConn(packet *inbound.PacketAdapter) {
 		log.Warnln("[Metadata] not valid: %#v", metadata)
 		return
 	}
+	power.Touch()
+
+	// the destination as the client sent it, before a fake ip is mapped back to its host
+	dst := addrPortFrom(metadata.DstIP, metadata.DstPort)
 
 	// make a fAddr if request ip is fakeip
 	var fAddr netip.Addr
@@ -212,7 +289,30 @@ func handleUDPConn(packet *inbound.PacketAdapter) {
 	handle := func() bool {
 		pc := natTable.Get(key)
 		if pc != nil {
-			handleUDPToRemote(packet, pc, metadata)
+			session, ok := pc.(*udpSession)
+			if ok && shouldRematchUDP(session, metadata, dst) {
+				natTable.DeleteIfEqual(key, session)
+				session.Close()
+				return false
+			}
+			var target *C.Metadata
+			if ok {
+				target = session.targetOf(metadata.Host, dst)
+			}
+			if target == nil {
+				var err error
+				if target, err = udpTarget(metadata); err != nil {
+					log.Debugln("[UDP] %s --> %s resolve target error: %s", metadata.SourceAddress(), metadata.RemoteAddress(), err.Error())
+					return true
+				}
+			}
+			if err := handleUDPToRemote(packet, pc, target); err != nil && sessionBroken(err) {
+				// the stream under a udp-over-tcp session is gone, dial again instead of waiting for the timeout
+				log.Debugln("[UDP] %s --> %s session broken: %s", metadata.SourceAddress(), metadata.RemoteAddress(), err.Error())
+				natTable.DeleteIfEqual(key, pc)
+				pc.Close()
+				return false
+			}
 			return true
 		}
 		return false
@@ -249,12 +349,35 @@ func handleUDPConn(packet *inbound.PacketAdapter) {
 			return
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), C.DefaultUDPTimeout)
-		defer cancel()
-		rawPc, err := proxy.ListenPacketContext(ctx, metadata.Pure())
+		target, err := udpTarget(metadata)
+		if err != nil {
+			log.Debugln("[UDP] %s --> %s resolve target error: %s", metadata.SourceAddress(), metadata.RemoteAddress(), err.Error())
+			return
+		}
+
+		release, err := acquireDial()
+		if err != nil {
+			log.Dedupln(log.WARNING, "dial-pool-full-udp", "[UDP] %s --> %s rejected: %s", metadata.SourceAddress(), metadata.RemoteAddress(), err)
+			return
+		}
+
+		// a socket of a ttl set from a trace hop isn't shared with the later flows
+		reuse := target.TTL == 0
+		var rawPc C.PacketConn
+		if reuse {
+			rawPc = udpPorts.take(key, proxy.Name())
+		}
+		if rawPc == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), C.DefaultUDPTimeout)
+			defer cancel()
+			rawPc, err = proxy.ListenPacketContext(ctx, target.Pure())
+		}
+		release()
 		if err != nil {
 			if rule == nil {
-				log.Warnln(
+				log.Dedupln(
+					log.WARNING,
+					dialErrorKey(proxy, metadata, err),
 					"[UDP] dial %s %s --> %s error: %s",
 					proxy.Name(),
 					metadata.SourceAddress(),
@@ -262,12 +385,15 @@ func handleUDPConn(packet *inbound.PacketAdapter) {
 					err.Error(),
 				)
 			} else {
-				log.Warnln("[UDP] dial %s (match %s/%s) %s --> %s error: %s", proxy.Name(), rule.RuleType().String(), rule.Payload(), metadata.SourceAddress(), metadata.RemoteAddress(), err.Error())
+				log.Dedupln(log.WARNING, dialErrorKey(proxy, metadata, err), "[UDP] dial %s (match %s/%s) %s --> %s error: %s", proxy.Name(), rule.RuleType().String(), rule.Payload(), metadata.SourceAddress(), metadata.RemoteAddress(), err.Error())
 			}
 			return
 		}
+		if reuse {
+			rawPc = udpPorts.track(key, proxy.Name(), rawPc)
+		}
 		pCtx.InjectPacketConn(rawPc)
-		pc := statistic.NewUDPTracker(rawPc, statistic.DefaultManager, metadata, rule)
+		pc := statistic.NewUDPTracker(rawPc, statistic.DefaultManager, target, rule, packet.UDPPacket)
 
 		switch true {
 		case metadata.SpecialProxy != "":
@@ -287,47 +413,154 @@ func handleUDPConn(packet *inbound.PacketAdapter) {
 			log.Infoln("[UDP] %s --> %s using DIRECT", metadata.SourceAddress(), metadata.RemoteAddress())
 		default:
 			log.Infoln(
-				"[UDP] %s --> %s doesn't match any rule using DIRECT",
+				"[UDP] %s --> %s doesn't match any rule using %s",
 				metadata.SourceAddress(),
 				metadata.RemoteAddress(),
+				rawPc.Chains().String(),
 			)
 		}
 
-		oAddr, _ := netip.AddrFromSlice(metadata.DstIP)
-		oAddr = oAddr.Unmap()
-		go handleUDPToLocal(packet.UDPPacket, pc, key, oAddr, fAddr)
+		session := &udpSession{
+			PacketConn: pc,
+			host:       atomic.NewString(metadata.Host),
+			proxy:      proxy,
+			rule:       rule,
+			dst:        dst,
+			dstHost:    metadata.Host,
+			target:     target,
+		}
+
+		oAddr := addrPortFrom(target.DstIP, target.DstPort)
+		rAddr := netip.AddrPortFrom(fAddr, 0)
+		// replies of a rewritten flow are sent back from the address the client asked for
+		if target != metadata {
+			rAddr = addrPortFrom(metadata.DstIP, metadata.DstPort)
+			if fAddr.IsValid() {
+				rAddr = netip.AddrPortFrom(fAddr, rAddr.Port())
+			}
+		}
+		go handleUDPToLocal(packet.UDPPacket, session, key, oAddr, rAddr)
 
-		natTable.Set(key, pc)
+		natTable.Set(key, session)
 		handle()
 	}()
 }
 
+// shouldRematchUDP reports whether a udp flow dialed without knowing the
+// host should be torn down after the host of its destination is discovered
+func shouldRematchUDP(session *udpSession, metadata *C.Metadata, dst netip.AddrPort) bool {
+	// only the first packet that carries the host triggers the re-evaluation
+	if !UDPRematch.Load() || !session.rematchable(metadata.Host, dst) {
+		return false
+	}
+
+	pCtx := icontext.NewPacketConnContext(metadata)
+	proxy, rule, err := resolveMetadata(pCtx, metadata)
+	if err != nil || proxy.Name() == session.proxy.Name() {
+		return false
+	}
+
+	log.Infoln(
+		"[UDP] %s --> %s rematch %s using %s, previously %s using %s",
+		metadata.SourceAddress(),
+		metadata.RemoteAddress(),
+		ruleString(rule),
+		proxy.Name(),
+		ruleString(session.rule),
+		session.proxy.Name(),
+	)
+	return true
+}
+
+func addrPortFrom(ip net.IP, port string) netip.AddrPort {
+	addr, _ := netip.AddrFromSlice(ip)
+	p, _ := strconv.ParseUint(port, 10, 16)
+	return netip.AddrPortFrom(addr.Unmap(), uint16(p))
+}
+
+// dialErrorKey tells the dial errors apart for log.Dedupln, the source port of every
+// connection differs so it is not part of the key
+func dialErrorKey(proxy C.Proxy, metadata *C.Metadata, err error) string {
+	return proxy.Name() + " " + metadata.RemoteAddress() + " " + err.Error()
+}
+
+func ruleString(rule C.Rule) string {
+	if rule == nil {
+		return "no rule"
+	}
+	return fmt.Sprintf("%s(%s)", rule.RuleType().String(), rule.Payload())
+}
+
 func handleTCPConn(connCtx C.ConnContext) {
 	defer connCtx.Conn().Close()
+	inbound := connCtx.Conn()
 
 	metadata := connCtx.Metadata()
 	if !metadata.Valid() {
 		log.Warnln("[Metadata] not valid: %#v", metadata)
 		return
 	}
+	power.Touch()
+	latency := startLatency()
 
 	if err := preHandleMetadata(metadata); err != nil {
 		log.Debugln("[Metadata PreHandle] error: %s", err)
 		return
 	}
 
+	// checkHost waits for the client to speak, it's left out of the match
+	var reject bool
+	latency.hold()
+	inbound, reject = checkHost(inbound, metadata)
+	latency.resume()
+	if reject {
+		statistic.DefaultManager.CountClose(statistic.CloseHostMismatch)
+		return
+	}
+
 	proxy, rule, err := resolveMetadata(connCtx, metadata)
 	if err != nil {
 		log.Warnln("[Metadata] parse failed: %s", err.Error())
 		return
 	}
 
+	metadata = rewriteMetadata(metadata)
+	latency.match()
+
+	if req, ok := inbound.(C.BindRequest); ok {
+		handleBind(req, metadata, proxy, rule)
+		return
+	}
+
+	release, err := acquireDial()
+	if err != nil {
+		statistic.DefaultManager.CountClose(statistic.CloseDialPoolFull)
+		log.Dedupln(log.WARNING, "dial-pool-full-tcp", "[TCP] %s --> %s rejected: %s", metadata.SourceAddress(), metadata.RemoteAddress(), err)
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), C.DefaultTCPTimeout)
 	defer cancel()
-	remoteConn, err := proxy.DialContext(ctx, metadata.Pure())
+	watcher := watchAbandon(inbound, cancel)
+	upstream := &C.Upstream{}
+	remoteConn, err := proxy.DialContext(C.WithUpstream(ctx, upstream), metadata.Pure())
+	release()
+	if watcher != nil {
+		var abandoned bool
+		if inbound, abandoned = watcher.stop(); abandoned {
+			statistic.DefaultManager.CountClose(statistic.CloseClientAbandoned)
+			log.Debugln("[TCP] %s --> %s abandoned by the client while dialing", metadata.SourceAddress(), metadata.RemoteAddress())
+			if remoteConn != nil {
+				remoteConn.Close()
+			}
+			return
+		}
+	}
 	if err != nil {
 		if rule == nil {
-			log.Warnln(
+			log.Dedupln(
+				log.WARNING,
+				dialErrorKey(proxy, metadata, err),
 				"[TCP] dial %s %s --> %s error: %s",
 				proxy.Name(),
 				metadata.SourceAddress(),
@@ -335,11 +568,16 @@ func handleTCPConn(connCtx C.ConnContext) {
 				err.Error(),
 			)
 		} else {
-			log.Warnln("[TCP] dial %s (match %s/%s) %s --> %s error: %s", proxy.Name(), rule.RuleType().String(), rule.Payload(), metadata.SourceAddress(), metadata.RemoteAddress(), err.Error())
+			log.Dedupln(log.WARNING, dialErrorKey(proxy, metadata, err), "[TCP] dial %s (match %s/%s) %s --> %s error: %s", proxy.Name(), rule.RuleType().String(), rule.Payload(), metadata.SourceAddress(), metadata.RemoteAddress(), err.Error())
 		}
 		return
 	}
-	remoteConn = statistic.NewTCPTracker(remoteConn, statistic.DefaultManager, metadata, rule)
+	latency.dialed(metadata.Type, remoteConn)
+	isReject := rejected(remoteConn)
+	if upstream.Address != "" {
+		metadata.Upstream = upstream
+	}
+	remoteConn = statistic.NewTCPTracker(remoteConn, statistic.DefaultManager, metadata, rule, connCtx.Conn())
 	defer remoteConn.Close()
 
 	switch true {
@@ -360,13 +598,21 @@ func handleTCPConn(connCtx C.ConnContext) {
 		log.Infoln("[TCP] %s --> %s using DIRECT", metadata.SourceAddress(), metadata.RemoteAddress())
 	default:
 		log.Infoln(
-			"[TCP] %s --> %s doesn't match any rule using DIRECT",
+			"[TCP] %s --> %s doesn't match any rule using %s",
 			metadata.SourceAddress(),
 			metadata.RemoteAddress(),
+			remoteConn.Chains().String(),
 		)
 	}
 
-	handleSocket(connCtx, remoteConn)
+	if responder, ok := connCtx.Conn().(C.DialResponder); ok {
+		if err := responder.Respond(inbound, isReject, rule); err != nil {
+			log.Debugln("[TCP] %s --> %s answered: %s", metadata.SourceAddress(), metadata.RemoteAddress(), err)
+			return
+		}
+	}
+
+	handleSocket(inbound, remoteConn)
 }
 
 func shouldResolveIP(rule C.Rule, metadata *C.Metadata) bool {
@@ -386,7 +632,7 @@ func match(metadata *C.Metadata) (C.Proxy, C.Rule, error) {
 		resolved = true
 	}
 
-	for _, rule := range rules {
+	for _, rule := range compacted {
 		if !resolved && shouldResolveIP(rule, metadata) {
 			ip, err := resolver.ResolveIP(metadata.Host)
 			if err != nil {
@@ -398,7 +644,7 @@ func match(metadata *C.Metadata) (C.Proxy, C.Rule, error) {
 			resolved = true
 		}
 
-		if !processFound && rule.ShouldFindProcess() {
+		if !processFound && rule.ShouldFindProcess() && !metadata.ProcessLooked {
 			processFound = true
 
 			srcIP, ok := netip.AddrFromSlice(metadata.SrcIP)
@@ -414,19 +660,31 @@ func match(metadata *C.Metadata) (C.Proxy, C.Rule, error) {
 			}
 		}
 
-		if rule.Match(metadata) {
-			adapter, ok := proxies[rule.Adapter()]
-			if !ok {
+		if set, ok := rule.(*R.IPCIDRSet); ok {
+			// report the rule of the run that matched
+			if matched := set.Lookup(metadata); matched != nil {
+				rule = matched
+			} else {
 				continue
 			}
+		} else if !rule.Match(metadata) {
+			continue
+		}
 
-			if metadata.NetWork == C.UDP && !adapter.SupportUDP() && UDPFallbackMatch.Load() {
-				log.Debugln("[Matcher] %s UDP is not supported, skip match", adapter.Name())
-				continue
-			}
-			return adapter, rule, nil
+		adapter, ok := resolveTarget(rule.Adapter())
+		if !ok {
+			continue
 		}
+
+		if metadata.NetWork == C.UDP && !adapter.SupportUDP() && UDPFallbackMatch.Load() {
+			log.Debugln("[Matcher] %s UDP is not supported, skip match", adapter.Name())
+			continue
+		}
+		return adapter, rule, nil
 	}
 
+	if adapter, ok := proxies[final]; ok {
+		return adapter, nil, nil
+	}
 	return proxies["DIRECT"], nil, nil
 }
@@ -0,0 +1,50 @@
+package tunnel
+
+import (
+	"net/netip"
+	"testing"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+)
+
+func TestUDPSession_Rematchable(t *testing.T) {
+	stunA := netip.MustParseAddrPort("198.18.0.5:3478")
+	stunB := netip.MustParseAddrPort("198.18.0.6:3478")
+
+	// a socket alternating between two servers doesn't flip the session
+	session := &udpSession{host: atomic.NewString(""), dst: stunA}
+	for i := 0; i < 3; i++ {
+		assert.False(t, session.rematchable("stun.b.example.com", stunB))
+	}
+	assert.Equal(t, "", session.host.Load())
+
+	// the host of the destination dialed for, only once
+	assert.False(t, session.rematchable("", stunA))
+	assert.True(t, session.rematchable("stun.a.example.com", stunA))
+	assert.False(t, session.rematchable("stun.a.example.com", stunA))
+	assert.False(t, session.rematchable("other.example.com", stunA))
+	assert.Equal(t, "stun.a.example.com", session.host.Load())
+
+	// dialed with its host
+	session = &udpSession{host: atomic.NewString("stun.a.example.com"), dst: stunA}
+	assert.False(t, session.rematchable("stun.c.example.com", stunA))
+}
+
+func TestUDPSession_TargetOf(t *testing.T) {
+	dst := netip.MustParseAddrPort("198.18.0.5:53")
+	target := &C.Metadata{Host: "dns.example.net", DstPort: "5353"}
+	session := &udpSession{host: atomic.NewString("example.com"), dst: dst, dstHost: "example.com", target: target}
+
+	assert.Same(t, target, session.targetOf("example.com", dst))
+	// the other destinations of the socket are rewritten per packet
+	assert.Nil(t, session.targetOf("example.com", netip.MustParseAddrPort("198.18.0.5:54")))
+	assert.Nil(t, session.targetOf("example.org", dst))
+
+	// the domain destinations of a socks client have no ip
+	session = &udpSession{host: atomic.NewString("example.com"), dstHost: "example.com", target: target}
+	assert.Same(t, target, session.targetOf("example.com", netip.AddrPort{}))
+	assert.Nil(t, session.targetOf("example.org", netip.AddrPort{}))
+}
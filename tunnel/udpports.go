@@ -0,0 +1,237 @@
+package tunnel
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	C "github.com/Dreamacro/clash/constant"
+
+	"go.uber.org/atomic"
+)
+
+const (
+	DefaultUDPPortIdle = 5 * time.Minute
+
+	// maxUDPPorts caps the sockets tracked for reuse, the sessions over it aren't reused
+	maxUDPPorts = 1024
+)
+
+var udpPorts = &udpPortPool{idle: DefaultUDPPortIdle, entries: map[string]*udpPort{}}
+
+// UDPPort is a socket of the udp port reuse, Idle is set while it waits for the next session
+// of its client through its proxy
+type UDPPort struct {
+	Client   string    `json:"client"`
+	Proxy    string    `json:"proxy"`
+	Local    string    `json:"local"`
+	Chains   C.Chain   `json:"chains"`
+	Sessions int       `json:"sessions"`
+	Idle     bool      `json:"idle"`
+	LastUse  time.Time `json:"lastUse"`
+}
+
+type udpPort struct {
+	UDPPort
+	key   string
+	pc    C.PacketConn
+	timer *time.Timer
+}
+
+// udpPortPool keeps the outbound socket of a udp session after it ends, the next session of
+// the same client through the same proxy takes it back, so the local port and the nat
+// mappings made for it stay the same across the flows. A socket left idle for idle is closed.
+type udpPortPool struct {
+	mux     sync.Mutex
+	enable  bool
+	idle    time.Duration
+	entries map[string]*udpPort
+}
+
+// UpdateUDPPortReuse turns the udp port reuse on or off, turning it off closes the idle sockets
+func UpdateUDPPortReuse(enable bool, idle time.Duration) {
+	udpPorts.update(enable, idle)
+}
+
+// FlushUDPPorts drops the sockets of the udp port reuse, the idle ones are closed and the ones
+// in use are closed with their session. A proxy keeping its name across a reload may have
+// another server or cipher, its sockets are of the old one
+func FlushUDPPorts() {
+	udpPorts.flush()
+}
+
+// GetUDPPorts return the sockets of the udp port reuse, ordered by client
+func GetUDPPorts() []UDPPort {
+	return udpPorts.snapshot()
+}
+
+func udpPortKey(client, proxy string) string {
+	return client + "\x00" + proxy
+}
+
+func (p *udpPortPool) update(enable bool, idle time.Duration) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.enable, p.idle = enable, idle
+	if enable {
+		return
+	}
+	for key, e := range p.entries {
+		if e.Idle {
+			e.timer.Stop()
+			e.pc.Close()
+			delete(p.entries, key)
+		}
+	}
+}
+
+func (p *udpPortPool) flush() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for key, e := range p.entries {
+		if e.Idle {
+			e.timer.Stop()
+			e.pc.Close()
+		}
+		delete(p.entries, key)
+	}
+}
+
+func (p *udpPortPool) snapshot() []UDPPort {
+	p.mux.Lock()
+	ports := make([]UDPPort, 0, len(p.entries))
+	for _, e := range p.entries {
+		ports = append(ports, e.UDPPort)
+	}
+	p.mux.Unlock()
+
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].Client != ports[j].Client {
+			return ports[i].Client < ports[j].Client
+		}
+		return ports[i].Proxy < ports[j].Proxy
+	})
+	return ports
+}
+
+// take return the idle socket of client through proxy, nil if there is none
+func (p *udpPortPool) take(client, proxy string) C.PacketConn {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	e := p.entries[udpPortKey(client, proxy)]
+	if e == nil || !e.Idle {
+		return nil
+	}
+	e.timer.Stop()
+	e.Idle = false
+	e.Sessions++
+	e.LastUse = time.Now()
+	return e.pc
+}
+
+// track wraps the socket of a new session so closing the session parks it, pc is returned
+// as is when the reuse is off or the pool is full
+func (p *udpPortPool) track(client, proxy string, pc C.PacketConn) C.PacketConn {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if !p.enable {
+		return pc
+	}
+
+	key := udpPortKey(client, proxy)
+	e := p.entries[key]
+	if e == nil || e.pc != pc {
+		if e == nil && len(p.entries) >= maxUDPPorts {
+			return pc
+		}
+		if e != nil && e.Idle {
+			e.timer.Stop()
+			e.pc.Close()
+		}
+		e = &udpPort{
+			UDPPort: UDPPort{
+				Client:   client,
+				Proxy:    proxy,
+				Local:    pc.LocalAddr().String(),
+				Chains:   pc.Chains(),
+				Sessions: 1,
+				LastUse:  time.Now(),
+			},
+			key: key,
+			pc:  pc,
+		}
+		p.entries[key] = e
+	}
+	return &reusedPacketConn{PacketConn: pc, pool: p, entry: e}
+}
+
+// release parks the socket of a finished session, a broken one is closed
+func (p *udpPortPool) release(e *udpPort, broken bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if broken || !p.enable || p.entries[e.key] != e {
+		if p.entries[e.key] == e {
+			delete(p.entries, e.key)
+		}
+		e.pc.Close()
+		return
+	}
+
+	e.Idle = true
+	e.LastUse = time.Now()
+	parked := e.LastUse
+	e.timer = time.AfterFunc(p.idle, func() {
+		p.mux.Lock()
+		defer p.mux.Unlock()
+		// a timer stopped too late finds the socket taken or parked again
+		if p.entries[e.key] == e && e.Idle && e.LastUse.Equal(parked) {
+			delete(p.entries, e.key)
+			e.pc.Close()
+		}
+	})
+}
+
+// reusedPacketConn is the socket of one session, Close gives it back to the pool unless a
+// read or write failed otherwise than by a timeout. It can't be used after Close, the socket
+// may be another session's by then.
+type reusedPacketConn struct {
+	C.PacketConn
+	pool      *udpPortPool
+	entry     *udpPort
+	broken    atomic.Bool
+	closed    atomic.Bool
+	closeOnce sync.Once
+}
+
+func (c *reusedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if c.closed.Load() {
+		return 0, nil, net.ErrClosed
+	}
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err != nil && !isTimeout(err) {
+		c.broken.Store(true)
+	}
+	return n, addr, err
+}
+
+func (c *reusedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.closed.Load() {
+		return 0, net.ErrClosed
+	}
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if err != nil && !isTimeout(err) {
+		c.broken.Store(true)
+	}
+	return n, err
+}
+
+func (c *reusedPacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closed.Store(true)
+		// a read of the session still blocked would take the first reply of the next one
+		c.PacketConn.SetReadDeadline(time.Unix(1, 0))
+		c.pool.release(c.entry, c.broken.Load())
+	})
+	return nil
+}
@@ -0,0 +1,112 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/outbound"
+	C "github.com/Dreamacro/clash/constant"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDPPortReuse(t *testing.T) {
+	defer UpdateUDPPortReuse(false, DefaultUDPPortIdle)
+	UpdateUDPPortReuse(true, 100*time.Millisecond)
+
+	direct := outbound.NewDirect()
+	raw, err := direct.ListenPacketContext(context.Background(), &C.Metadata{NetWork: C.UDP})
+	require.NoError(t, err)
+
+	client := "127.0.0.1:40000"
+	pc := udpPorts.track(client, direct.Name(), raw)
+	pc.Close()
+	_, err = pc.WriteTo([]byte("late"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9})
+	assert.ErrorIs(t, err, net.ErrClosed)
+
+	ports := GetUDPPorts()
+	require.Len(t, ports, 1)
+	assert.True(t, ports[0].Idle)
+	assert.Equal(t, raw.LocalAddr().String(), ports[0].Local)
+
+	// the next session of the client takes the socket back, another client gets none
+	assert.Nil(t, udpPorts.take("127.0.0.1:40001", direct.Name()))
+	taken := udpPorts.take(client, direct.Name())
+	require.Equal(t, raw, taken)
+	pc = udpPorts.track(client, direct.Name(), taken)
+	ports = GetUDPPorts()
+	require.Len(t, ports, 1)
+	assert.False(t, ports[0].Idle)
+	assert.Equal(t, 2, ports[0].Sessions)
+
+	// an idle socket is closed after the idle time
+	pc.Close()
+	assert.Eventually(t, func() bool { return len(GetUDPPorts()) == 0 }, time.Second, 10*time.Millisecond)
+	_, err = raw.WriteTo([]byte("late"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9})
+	assert.ErrorIs(t, err, net.ErrClosed)
+
+	// without the reuse the socket is returned as is
+	UpdateUDPPortReuse(false, DefaultUDPPortIdle)
+	raw, err = direct.ListenPacketContext(context.Background(), &C.Metadata{NetWork: C.UDP})
+	require.NoError(t, err)
+	defer raw.Close()
+	assert.Equal(t, raw, udpPorts.track(client, direct.Name(), raw))
+}
+
+func TestUDPPortReuse_CloseUnblocksRead(t *testing.T) {
+	defer UpdateUDPPortReuse(false, DefaultUDPPortIdle)
+	UpdateUDPPortReuse(true, time.Minute)
+	defer FlushUDPPorts()
+
+	direct := outbound.NewDirect()
+	raw, err := direct.ListenPacketContext(context.Background(), &C.Metadata{NetWork: C.UDP})
+	require.NoError(t, err)
+
+	pc := udpPorts.track("127.0.0.1:40000", direct.Name(), raw)
+	read := make(chan error, 1)
+	go func() {
+		_, _, err := pc.ReadFrom(make([]byte, 16))
+		read <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// the parked socket doesn't leave the read of the finished session waiting for the next reply
+	pc.Close()
+	select {
+	case err := <-read:
+		assert.True(t, isTimeout(err))
+	case <-time.After(time.Second):
+		require.FailNow(t, "the read of the closed session is still blocked")
+	}
+	assert.Len(t, GetUDPPorts(), 1)
+}
+
+func TestUDPPortReuse_Flush(t *testing.T) {
+	defer UpdateUDPPortReuse(false, DefaultUDPPortIdle)
+	UpdateUDPPortReuse(true, time.Minute)
+
+	direct := outbound.NewDirect()
+	idle, err := direct.ListenPacketContext(context.Background(), &C.Metadata{NetWork: C.UDP})
+	require.NoError(t, err)
+	udpPorts.track("127.0.0.1:40000", direct.Name(), idle).Close()
+	used, err := direct.ListenPacketContext(context.Background(), &C.Metadata{NetWork: C.UDP})
+	require.NoError(t, err)
+	pc := udpPorts.track("127.0.0.1:40001", direct.Name(), used)
+	require.Len(t, GetUDPPorts(), 2)
+
+	// a reload may change the server of a proxy of the same name
+	FlushUDPPorts()
+	assert.Empty(t, GetUDPPorts())
+	_, err = idle.WriteTo([]byte("late"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9})
+	assert.ErrorIs(t, err, net.ErrClosed)
+
+	// the socket in use is closed with its session instead of being parked
+	pc.Close()
+	assert.Empty(t, GetUDPPorts())
+	_, err = used.WriteTo([]byte("late"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9})
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.Nil(t, udpPorts.take("127.0.0.1:40001", direct.Name()))
+}